@@ -0,0 +1,80 @@
+package mmauth
+
+import (
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/spf"
+)
+
+// Action は認証結果から推奨されるメッセージの扱い
+type Action string
+
+const (
+	// ActionAccept はそのまま受け入れる
+	ActionAccept Action = "accept"
+	// ActionAcceptWithAnnotation は受け入れるが、認証に問題があることを利用者やフィルタに示す
+	ActionAcceptWithAnnotation Action = "accept-with-annotation"
+	// ActionQuarantine は迷惑メールフォルダなどに隔離する
+	ActionQuarantine Action = "quarantine"
+	// ActionReject は受信を拒否する
+	ActionReject Action = "reject"
+)
+
+// RecommendedAction はSPF・DKIM・DMARC・ARCの結果を組み合わせて推奨される扱いを返す
+// M3AAWGの推奨に沿って、次の順に判定する
+//   - SMTP AUTHで認証された送信は受け入れる
+//   - DMARCがfailの場合はDisposition(PolicyHook適用後)に従い、noneの場合は注記付きで受け入れる
+//   - DMARCがtemperror・permerrorの場合は一時的な障害や設定の誤りの可能性があるため注記付きで受け入れる
+//   - DMARCレコードがなく、SPFがfailでDKIMとARCでも認証できない場合は隔離する
+//     (転送でSPFが失敗することがあるため、SPFのみを理由に拒否しない)
+//   - SPFのsoftfail・fail、DKIMのfail、ARCのfailがあれば注記付きで受け入れる
+//
+// DMARCのポリシーがローカルポリシーで上書きされた場合も注記付きで受け入れる
+// SPFの結果はSPFPolicyStatusが設定されていればそれを使う
+func (r *AuthResult) RecommendedAction() Action {
+	if r.AuthUser != "" {
+		return ActionAccept
+	}
+	if r.DMARC != nil {
+		switch r.DMARC.Result {
+		case dmarc.ResultFail:
+			switch r.Disposition {
+			case DispositionReject:
+				return ActionReject
+			case DispositionQuarantine:
+				return ActionQuarantine
+			}
+			return ActionAcceptWithAnnotation
+		case dmarc.ResultTempError, dmarc.ResultPermError:
+			return ActionAcceptWithAnnotation
+		}
+	}
+
+	dkimPass, dkimFail := false, false
+	for _, d := range r.DKIM {
+		if d == nil || d.VerifyResult == nil {
+			continue
+		}
+		switch d.VerifyResult.Status() {
+		case dkim.VerifyStatusPass:
+			dkimPass = true
+		case dkim.VerifyStatusFail:
+			dkimFail = true
+		}
+	}
+	spfStatus := spf.None
+	if r.SPFPolicyStatus != "" {
+		spfStatus = r.SPFPolicyStatus
+	} else if r.SPF != nil {
+		spfStatus = r.SPF.Status
+	}
+	dmarcNone := r.DMARC == nil || r.DMARC.Result == dmarc.ResultNone
+	if dmarcNone && spfStatus == spf.Fail && !dkimPass && r.ARC != arc.ChainValidationResultPass {
+		return ActionQuarantine
+	}
+	if spfStatus == spf.Fail || spfStatus == spf.SoftFail || dkimFail || r.ARC == arc.ChainValidationResultFail {
+		return ActionAcceptWithAnnotation
+	}
+	return ActionAccept
+}
@@ -0,0 +1,99 @@
+package mmauth
+
+import (
+	"testing"
+
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/spf"
+)
+
+func TestRecommendedAction(t *testing.T) {
+	failedDKIM, err := dkim.ParseSignature("DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=selector; h=From; bh=AAAA; b=AAAA\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+	failedDKIM.Verify([]string{"From: user@example.com\r\n"}, "BBBB", &domainkey.DomainKey{})
+	if failedDKIM.VerifyResult.Status() != dkim.VerifyStatusFail {
+		t.Fatalf("want dkim fail, but got %s", failedDKIM.VerifyResult.Status())
+	}
+
+	dmarcResult := func(res dmarc.Result) *dmarc.Evaluation {
+		return &dmarc.Evaluation{Result: res}
+	}
+	testCases := []struct {
+		name string
+		r    AuthResult
+		want Action
+	}{
+		{
+			name: "all pass",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.Pass}, DMARC: dmarcResult(dmarc.ResultPass)},
+			want: ActionAccept,
+		},
+		{
+			name: "smtp auth",
+			r:    AuthResult{AuthUser: "user", SPF: &spf.Result{Status: spf.Fail}, DMARC: dmarcResult(dmarc.ResultFail), Disposition: DispositionReject},
+			want: ActionAccept,
+		},
+		{
+			name: "dmarc reject",
+			r:    AuthResult{DMARC: dmarcResult(dmarc.ResultFail), Disposition: DispositionReject},
+			want: ActionReject,
+		},
+		{
+			name: "dmarc quarantine",
+			r:    AuthResult{DMARC: dmarcResult(dmarc.ResultFail), Disposition: DispositionQuarantine},
+			want: ActionQuarantine,
+		},
+		{
+			name: "dmarc p=none",
+			r:    AuthResult{DMARC: dmarcResult(dmarc.ResultFail), Disposition: DispositionNone},
+			want: ActionAcceptWithAnnotation,
+		},
+		{
+			name: "dmarc temperror",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.Fail}, DMARC: dmarcResult(dmarc.ResultTempError)},
+			want: ActionAcceptWithAnnotation,
+		},
+		{
+			name: "spf fail without dmarc",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.Fail}, DMARC: dmarcResult(dmarc.ResultNone)},
+			want: ActionQuarantine,
+		},
+		{
+			name: "spf fail with arc pass",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.Fail}, ARC: arc.ChainValidationResultPass},
+			want: ActionAcceptWithAnnotation,
+		},
+		{
+			name: "spf softfail",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.SoftFail}, DMARC: dmarcResult(dmarc.ResultNone)},
+			want: ActionAcceptWithAnnotation,
+		},
+		{
+			name: "spf softfail treated as fail",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.SoftFail}, SPFPolicyStatus: spf.Fail, DMARC: dmarcResult(dmarc.ResultNone)},
+			want: ActionQuarantine,
+		},
+		{
+			name: "spf softfail treated as neutral",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.SoftFail}, SPFPolicyStatus: spf.Neutral, DMARC: dmarcResult(dmarc.ResultNone)},
+			want: ActionAccept,
+		},
+		{
+			name: "dkim fail",
+			r:    AuthResult{SPF: &spf.Result{Status: spf.Pass}, DKIM: []*dkim.Signature{failedDKIM}, DMARC: dmarcResult(dmarc.ResultNone)},
+			want: ActionAcceptWithAnnotation,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.r.RecommendedAction(); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
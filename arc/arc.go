@@ -5,17 +5,21 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/masa23/mmauth/authres"
 	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
 	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/msgcat"
 )
 
-// 正規化
-type Canonicalization canonical.Canonicalization
+// Canonicalization はdkim.Canonicalizationと同じ正規化方式の型
+type Canonicalization = canonical.Canonicalization
 
 const (
-	CanonicalizationSimple  Canonicalization = "simple"
-	CanonicalizationRelaxed Canonicalization = "relaxed"
+	CanonicalizationSimple  = canonical.Simple
+	CanonicalizationRelaxed = canonical.Relaxed
 )
 
 // ARC署名のアルゴリズム
@@ -34,22 +38,25 @@ type CanonicalizationAndAlgorithm struct {
 	HashAlgo  crypto.Hash
 }
 
-type VerifyStatus string
+// VerifyStatus は検証結果
+// dkim.VerifyStatusと同じくauthres.Resultの別名
+type VerifyStatus = authres.Result
 
 const (
-	VerifyStatusNeutral VerifyStatus = "neutral"
-	VerifyStatusFail    VerifyStatus = "fail"
-	VerifyStatusTempErr VerifyStatus = "temperror"
-	VerifyStatusPermErr VerifyStatus = "permerror"
-	VerifyStatusPass    VerifyStatus = "pass"
-	VerifyStatusNone    VerifyStatus = "none"
+	VerifyStatusNeutral = authres.ResultNeutral
+	VerifyStatusFail    = authres.ResultFail
+	VerifyStatusTempErr = authres.ResultTempError
+	VerifyStatusPermErr = authres.ResultPermError
+	VerifyStatusPass    = authres.ResultPass
+	VerifyStatusNone    = authres.ResultNone
 )
 
 type VerifyResult struct {
-	status    VerifyStatus
-	err       error
-	msg       string
-	domainKey *domainkey.DomainKey
+	status      VerifyStatus
+	err         error
+	msg         string
+	domainKey   *domainkey.DomainKey
+	annotations []string
 }
 
 func (v *VerifyResult) Status() VerifyStatus {
@@ -62,6 +69,31 @@ func (v *VerifyResult) Message() string {
 	return v.msg
 }
 
+// MessageIn はMessageをlangに翻訳して返す(ログなど人が読むための出力に使う)
+// langが空の場合はmsgcat.SetLanguageで設定した言語、翻訳がない場合は英語のまま
+// Authentication-Resultsのコメントには常に英語のMessageを使う
+func (v *VerifyResult) MessageIn(lang msgcat.Language) string {
+	return msgcat.Translate(lang, v.msg)
+}
+
+// DomainKey は検証に使ったドメインキーを返す
+// 鍵を取得する前に検証が終わった場合はnil
+func (v *VerifyResult) DomainKey() *domainkey.DomainKey {
+	return v.domainKey
+}
+
+// Code は監視や集計に使う結果のコードを返す
+// dkim.VerifyResult.Codeと同じく、原因を特定できない場合はARC_PASSのように結果のみのコード
+func (v *VerifyResult) Code() errcode.Code {
+	return errcode.Result("arc", string(v.status), v.err)
+}
+
+// Annotations は検証結果に付けられた注記を返す(rsa-sha1の使用など)
+func (v *VerifyResult) Annotations() []string {
+	return v.annotations
+}
+
+// ChainValidationResult はARC-Sealのcv=タグの値 (RFC 8617 4.1.3)
 type ChainValidationResult string
 
 const (
@@ -70,8 +102,22 @@ const (
 	ChainValidationResultNone ChainValidationResult = "none"
 )
 
-func isChainValidationResult(s string) bool {
-	switch ChainValidationResult(s) {
+// ErrInvalidChainValidation はcv=の値がnone・fail・passのいずれでもない場合のエラー
+var ErrInvalidChainValidation = errors.New("arc: invalid chain validation result")
+
+// ParseChainValidation はcv=の値をパースする
+// 前後の空白を除き、大文字と小文字は区別せずに小文字にそろえて返す
+func ParseChainValidation(value string) (ChainValidationResult, error) {
+	cv := ChainValidationResult(strings.ToLower(strings.TrimSpace(value)))
+	if !cv.IsValid() {
+		return "", fmt.Errorf("%w: %q", ErrInvalidChainValidation, value)
+	}
+	return cv, nil
+}
+
+// IsValid はcv=に使える値かを返す
+func (cv ChainValidationResult) IsValid() bool {
+	switch cv {
 	case ChainValidationResultPass, ChainValidationResultFail, ChainValidationResultNone:
 		return true
 	default:
@@ -79,6 +125,11 @@ func isChainValidationResult(s string) bool {
 	}
 }
 
+// String はcv=に記載する値を返す
+func (cv ChainValidationResult) String() string {
+	return string(cv)
+}
+
 type Signature struct {
 	instanceNumber           int
 	arcSeal                  *ARCSeal
@@ -108,10 +159,22 @@ func (arc *Signature) GetVerifyResult() *VerifyResult {
 }
 
 func (arc *Signature) Verify(headers []string, bodyHash string, domainKey *domainkey.DomainKey) {
+	arc.VerifyWithOptions(headers, bodyHash, domainKey, nil)
+}
+
+// VerifyWithOptions はオプションを指定してARC-SealとARC-Message-Signatureを検証する
+// optsがnilの場合はVerifyと同じ
+func (arc *Signature) VerifyWithOptions(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts *VerifyOptions) {
+	arc.verify(headers, bodyHash, domainKey)
+	arc.applySHA1Policy(opts)
+	arc.applyKeyEncodingPolicy(opts)
+}
+
+func (arc *Signature) verify(headers []string, bodyHash string, domainKey *domainkey.DomainKey) {
 	if arc == nil || arc.arcSeal == nil || arc.arcMessageSignature == nil {
 		arc.VerifyResult = &VerifyResult{
 			status: VerifyStatusNeutral,
-			err:    fmt.Errorf("arc is not found"),
+			err:    errcode.New(errcode.ARCNeutralNoSignature, "arc is not found"),
 			msg:    "arc is not found",
 		}
 		return
@@ -123,14 +186,21 @@ func (arc *Signature) Verify(headers []string, bodyHash string, domainKey *domai
 		if errors.Is(err, domainkey.ErrNoRecordFound) {
 			arc.VerifyResult = &VerifyResult{
 				status: VerifyStatusPermErr,
-				err:    fmt.Errorf("domain key is not found: %v", err),
+				err:    errcode.Errorf(errcode.ARCPermErrorKeyNotFound, "domain key is not found: %w", err),
 				msg:    "domain key is not found",
 			}
 			return
+		} else if errors.Is(err, domainkey.ErrControlCharacter) {
+			arc.VerifyResult = &VerifyResult{
+				status: VerifyStatusPermErr,
+				err:    errcode.Errorf(errcode.ARCPermErrorKeyRecordInvalid, "invalid domain key record: %w", err),
+				msg:    "domain key record contains control characters",
+			}
+			return
 		} else if err != nil {
 			arc.VerifyResult = &VerifyResult{
 				status: VerifyStatusTempErr,
-				err:    fmt.Errorf("failed to lookup domain key: %v", err),
+				err:    errcode.Errorf(errcode.ARCTempErrorKeyLookup, "failed to lookup domain key: %w", err),
 				msg:    "failed to lookup domain key",
 			}
 			return
@@ -1,6 +1,8 @@
 package arc
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -19,7 +21,7 @@ func TestARCSeal_Sign_ArcSetOnly(t *testing.T) {
 		// ARC Set 2
 		"ARC-Authentication-Results: i=2; spf=pass\r\n",
 		"ARC-Message-Signature: i=2; a=rsa-sha256; d=example.com; s=default; h=from:to;\r\n        bh=dummyBodyHash; b=signature2\r\n",
-		"ARC-Seal: i=2; a=rsa-sha256; t=12345; cv=none; d=example.com; s=default; b=seal2\r\n",
+		"ARC-Seal: i=2; a=rsa-sha256; t=12345; cv=pass; d=example.com; s=default; b=seal2\r\n",
 		// ARC Set 3 (creating a new seal for this instance)
 		"ARC-Authentication-Results: i=3; spf=pass\r\n",
 		"ARC-Message-Signature: i=3; a=rsa-sha256; d=example.com; s=default; h=from:to;\r\n        bh=dummyBodyHash; b=signature3\r\n",
@@ -30,7 +32,7 @@ func TestARCSeal_Sign_ArcSetOnly(t *testing.T) {
 
 	as := &ARCSeal{
 		Algorithm:       SignatureAlgorithmRSA_SHA256,
-		ChainValidation: ChainValidationResultNone,
+		ChainValidation: ChainValidationResultPass,
 		Domain:          "example.com",
 		InstanceNumber:  3, // Creating a new seal for instance 3
 		Selector:        "default",
@@ -58,6 +60,112 @@ func TestARCSeal_Sign_ArcSetOnly(t *testing.T) {
 	}
 }
 
+func TestARCSealSignChainValidation(t *testing.T) {
+	aar := func(i int) string {
+		return fmt.Sprintf("ARC-Authentication-Results: i=%d; example.com; spf=pass\r\n", i)
+	}
+	ams := func(i int) string {
+		return fmt.Sprintf("ARC-Message-Signature: i=%d; a=rsa-sha256; d=example.com; s=default; h=from; bh=dummyBodyHash; b=sig%d\r\n", i, i)
+	}
+	seal := func(i int, cv string) string {
+		return fmt.Sprintf("ARC-Seal: i=%d; a=rsa-sha256; t=12345; cv=%s; d=example.com; s=default; b=seal%d\r\n", i, cv, i)
+	}
+
+	testCases := []struct {
+		name     string
+		instance int
+		cv       ChainValidationResult
+		headers  []string
+		wantErr  bool
+	}{
+		{
+			name:     "first instance",
+			instance: 1,
+			cv:       ChainValidationResultNone,
+			headers:  []string{aar(1), ams(1)},
+		},
+		{
+			name:     "first instance with cv=pass",
+			instance: 1,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1)},
+			wantErr:  true,
+		},
+		{
+			name:     "valid chain",
+			instance: 3,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), aar(2), ams(2), seal(2, "pass"), aar(3), ams(3)},
+		},
+		{
+			name:     "cv=none after first instance",
+			instance: 2,
+			cv:       ChainValidationResultNone,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), aar(2), ams(2)},
+			wantErr:  true,
+		},
+		{
+			name:     "hole in instances",
+			instance: 3,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), aar(3), ams(3)},
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate instance",
+			instance: 2,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), seal(1, "none"), aar(2), ams(2)},
+			wantErr:  true,
+		},
+		{
+			name:     "prior instance failed",
+			instance: 3,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), aar(2), ams(2), seal(2, "fail"), aar(3), ams(3)},
+			wantErr:  true,
+		},
+		{
+			name:     "prior first instance not none",
+			instance: 2,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1), seal(1, "pass"), aar(2), ams(2)},
+			wantErr:  true,
+		},
+		{
+			name:     "later instance present",
+			instance: 2,
+			cv:       ChainValidationResultPass,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), aar(2), ams(2), aar(3)},
+			wantErr:  true,
+		},
+		{
+			name:     "cv=fail seals broken chain",
+			instance: 3,
+			cv:       ChainValidationResultFail,
+			headers:  []string{aar(1), ams(1), seal(1, "none"), aar(3), ams(3)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			as := &ARCSeal{
+				Algorithm:       SignatureAlgorithmRSA_SHA256,
+				ChainValidation: tc.cv,
+				Domain:          "example.com",
+				InstanceNumber:  tc.instance,
+				Selector:        "default",
+			}
+			err := as.Sign(tc.headers, testKeys.getPrivateKey("rsa"))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidChain) {
+				t.Errorf("want %v, but got %v", ErrInvalidChain, err)
+			}
+		})
+	}
+}
+
 // isValidBase64 checks if a string is valid base64 (simplified check)
 func isValidBase64(s string) bool {
 	// Remove any whitespace that might be added by wrapping
@@ -7,9 +7,12 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"log"
 	"os"
 	"testing"
+
+	"github.com/masa23/mmauth/domainkey"
 )
 
 var testRSAPrivateKey = `
@@ -226,3 +229,80 @@ func TestParseARCSealForbiddenTag(t *testing.T) {
 		})
 	}
 }
+
+func TestParseChainValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    ChainValidationResult
+		wantErr bool
+	}{
+		{name: "none", input: "none", want: ChainValidationResultNone},
+		{name: "pass", input: "pass", want: ChainValidationResultPass},
+		{name: "fail", input: "fail", want: ChainValidationResultFail},
+		{name: "uppercase with spaces", input: " Pass ", want: ChainValidationResultPass},
+		{name: "empty", input: "", wantErr: true},
+		{name: "unknown", input: "neutral", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseChainValidation(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidChainValidation) {
+					t.Errorf("want %v, but got %v", ErrInvalidChainValidation, err)
+				}
+				return
+			}
+			if got != tc.want || got.String() != string(tc.want) || !got.IsValid() {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestApplySHA1Policy(t *testing.T) {
+	testCases := []struct {
+		name          string
+		sealAlgo      SignatureAlgorithm
+		policy        domainkey.SHA1Policy
+		status        VerifyStatus
+		want          VerifyStatus
+		wantAnnotated bool
+	}{
+		{name: "rsa-sha256", sealAlgo: SignatureAlgorithmRSA_SHA256, policy: domainkey.SHA1Deny, status: VerifyStatusPass, want: VerifyStatusPass},
+		{name: "default warns", sealAlgo: SignatureAlgorithmRSA_SHA1, status: VerifyStatusPass, want: VerifyStatusPass, wantAnnotated: true},
+		{name: "allow", sealAlgo: SignatureAlgorithmRSA_SHA1, policy: domainkey.SHA1Allow, status: VerifyStatusPass, want: VerifyStatusPass},
+		{name: "deny pass", sealAlgo: SignatureAlgorithmRSA_SHA1, policy: domainkey.SHA1Deny, status: VerifyStatusPass, want: VerifyStatusPermErr, wantAnnotated: true},
+		{name: "deny keeps temperror", sealAlgo: SignatureAlgorithmRSA_SHA1, policy: domainkey.SHA1Deny, status: VerifyStatusTempErr, want: VerifyStatusTempErr, wantAnnotated: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := &Signature{
+				instanceNumber:      1,
+				arcSeal:             &ARCSeal{Algorithm: tc.sealAlgo},
+				arcMessageSignature: &ARCMessageSignature{Algorithm: SignatureAlgorithmRSA_SHA256},
+				VerifyResult:        &VerifyResult{status: tc.status},
+			}
+			sig.applySHA1Policy(&VerifyOptions{SHA1Policy: tc.policy})
+			if got := sig.VerifyResult.Status(); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+			if annotated := len(sig.VerifyResult.Annotations()) == 1 && sig.VerifyResult.Annotations()[0] == domainkey.SHA1Annotation; annotated != tc.wantAnnotated {
+				t.Errorf("want annotated %v, but got %v", tc.wantAnnotated, sig.VerifyResult.Annotations())
+			}
+		})
+	}
+}
+
+func TestNewVerifyOptions(t *testing.T) {
+	o := NewVerifyOptions(WithSHA1Policy(domainkey.SHA1Deny), WithStrictEd25519Keys())
+	if o.SHA1Policy != domainkey.SHA1Deny || !o.StrictEd25519Keys {
+		t.Errorf("want sha1 deny and strict ed25519 keys, but got %+v", o)
+	}
+	if o := NewVerifyOptions(); *o != (VerifyOptions{}) {
+		t.Errorf("want zero options, but got %+v", o)
+	}
+}
@@ -0,0 +1,194 @@
+package arc
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// ChainExportVersion はExportが出力するJSONのスキーマのバージョン
+// フィールドを削除したり意味を変えたりする場合に上げる(フィールドの追加では上げない)
+const ChainExportVersion = 1
+
+// ChainExport はSIEMなどに取り込むために検証したARCチェーンをJSONで表したもの
+//
+// スキーマ(バージョン1)の例:
+//
+//	{
+//	  "version": 1,
+//	  "cv": "pass",
+//	  "result": "pass",
+//	  "instances": [
+//	    {
+//	      "i": 1,
+//	      "seal": {"d": "relay.example.net", "s": "arc", "a": "rsa-sha256", "cv": "none", "t": 1706971004},
+//	      "ams": {"d": "relay.example.net", "s": "arc", "a": "rsa-sha256", "c": "relaxed/relaxed",
+//	              "h": ["from", "to", "subject"], "t": 1706971004},
+//	      "aar": {"authserv_id": "relay.example.net",
+//	              "results": [{"method": "dkim", "result": "pass",
+//	                           "properties": [{"type": "header", "name": "d", "value": "example.com"}]}]},
+//	      "verification": {"status": "pass", "message": "good signature"}
+//	    }
+//	  ]
+//	}
+//
+// cvはチェーン全体の判定(GetARCChainValidation)、resultは最後のインスタンスの検証結果
+// instancesはi=の昇順で、欠けているヘッダ(seal・ams・aar)と未検証のverificationは省略する
+// tは署名にt=がない場合は省略する
+type ChainExport struct {
+	Version         int                   `json:"version"`
+	ChainValidation ChainValidationResult `json:"cv"`
+	Result          VerifyStatus          `json:"result"`
+	Instances       []InstanceExport      `json:"instances"`
+}
+
+// InstanceExport はARCの1つのインスタンス
+type InstanceExport struct {
+	Instance     int                 `json:"i"`
+	Seal         *SealExport         `json:"seal,omitempty"`
+	AMS          *AMSExport          `json:"ams,omitempty"`
+	AAR          *AARExport          `json:"aar,omitempty"`
+	Verification *VerificationExport `json:"verification,omitempty"`
+}
+
+// SealExport はARC-Sealのタグ(b=を除く)
+type SealExport struct {
+	Domain          string                `json:"d"`
+	Selector        string                `json:"s"`
+	Algorithm       SignatureAlgorithm    `json:"a"`
+	ChainValidation ChainValidationResult `json:"cv"`
+	Timestamp       int64                 `json:"t,omitempty"`
+}
+
+// AMSExport はARC-Message-Signatureのタグ(b=・bh=を除く)
+// hは小文字にそろえたヘッダ名
+type AMSExport struct {
+	Domain           string             `json:"d"`
+	Selector         string             `json:"s"`
+	Algorithm        SignatureAlgorithm `json:"a"`
+	Canonicalization string             `json:"c"`
+	Headers          []string           `json:"h"`
+	Timestamp        int64              `json:"t,omitempty"`
+}
+
+// AARExport はARC-Authentication-Resultsの内容
+// 解析できない結果は含まない
+type AARExport struct {
+	AuthServID string         `json:"authserv_id"`
+	Results    []ResultExport `json:"results"`
+}
+
+// ResultExport はARC-Authentication-Resultsの1つの結果
+type ResultExport struct {
+	Method     string           `json:"method"`
+	Result     string           `json:"result"`
+	Comment    string           `json:"comment,omitempty"`
+	Properties []PropertyExport `json:"properties,omitempty"`
+}
+
+// PropertyExport は結果のプロパティ(header.d=example.comなど)
+type PropertyExport struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// VerificationExport はインスタンスの検証結果
+type VerificationExport struct {
+	Status      VerifyStatus `json:"status"`
+	Message     string       `json:"message,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Annotations []string     `json:"annotations,omitempty"`
+}
+
+// Export はチェーンをChainExportにする
+// 検証の前に呼んだ場合はverificationを含まない
+func (s *Signatures) Export() *ChainExport {
+	e := &ChainExport{
+		Version:         ChainExportVersion,
+		ChainValidation: s.GetARCChainValidation(),
+		Result:          s.GetVerifyResult(),
+		Instances:       []InstanceExport{},
+	}
+	if s == nil {
+		return e
+	}
+	sigs := make([]*Signature, 0, len(*s))
+	for _, sig := range *s {
+		if sig != nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	sort.SliceStable(sigs, func(i, j int) bool {
+		return sigs[i].instanceNumber < sigs[j].instanceNumber
+	})
+	for _, sig := range sigs {
+		e.Instances = append(e.Instances, sig.export())
+	}
+	return e
+}
+
+// ExportJSON はExportの結果をJSONにする
+func (s *Signatures) ExportJSON() ([]byte, error) {
+	return json.Marshal(s.Export())
+}
+
+func (arc *Signature) export() InstanceExport {
+	ie := InstanceExport{Instance: arc.instanceNumber}
+	if as := arc.arcSeal; as != nil {
+		ie.Seal = &SealExport{
+			Domain:          as.Domain,
+			Selector:        as.Selector,
+			Algorithm:       as.Algorithm,
+			ChainValidation: as.ChainValidation,
+			Timestamp:       as.Timestamp,
+		}
+	}
+	if ams := arc.arcMessageSignature; ams != nil {
+		var headers []string
+		for _, h := range strings.Split(ams.Headers, ":") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+				headers = append(headers, h)
+			}
+		}
+		ie.AMS = &AMSExport{
+			Domain:           ams.Domain,
+			Selector:         ams.Selector,
+			Algorithm:        ams.Algorithm,
+			Canonicalization: ams.Canonicalization,
+			Headers:          headers,
+			Timestamp:        ams.Timestamp,
+		}
+	}
+	if aar := arc.arcAuthenticationResults; aar != nil {
+		ae := &AARExport{AuthServID: aar.AuthServId, Results: []ResultExport{}}
+		for _, ri := range aar.ResultInfos() {
+			re := ResultExport{
+				Method:  string(ri.Method),
+				Result:  string(ri.Result),
+				Comment: ri.Comment,
+			}
+			for _, p := range ri.Properties {
+				re.Properties = append(re.Properties, PropertyExport{
+					Type:  string(p.Type),
+					Name:  p.Name,
+					Value: p.Value,
+				})
+			}
+			ae.Results = append(ae.Results, re)
+		}
+		ie.AAR = ae
+	}
+	if v := arc.VerifyResult; v != nil {
+		ve := &VerificationExport{
+			Status:      v.status,
+			Message:     v.msg,
+			Annotations: v.annotations,
+		}
+		if v.err != nil {
+			ve.Error = v.err.Error()
+		}
+		ie.Verification = ve
+	}
+	return ie
+}
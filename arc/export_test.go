@@ -0,0 +1,68 @@
+package arc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	headers := []string{
+		"ARC-Seal: i=2; a=rsa-sha256; t=200; cv=pass; d=list.example; s=s2; b=signature",
+		"ARC-Message-Signature: i=2; a=rsa-sha256; c=relaxed/relaxed; d=list.example; s=s2; h=From:To; bh=bodyhash; b=signature",
+		"ARC-Authentication-Results: i=2; mx.list.example; arc=pass (i=1) smtp.remote-ip=192.0.2.1",
+		"ARC-Seal: i=1; a=ed25519-sha256; cv=none; d=origin.example; s=s1; b=signature",
+		"ARC-Message-Signature: i=1; a=ed25519-sha256; c=relaxed/simple; d=origin.example; s=s1; h=from; bh=bodyhash; b=signature",
+		"ARC-Authentication-Results: i=1; mx.origin.example; dkim=pass header.d=example.jp; spf=pass smtp.mailfrom=example.jp",
+	}
+	sigs, err := ParseARCHeaders(headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sigs.GetInstance(1).VerifyResult = &VerifyResult{status: VerifyStatusPass, msg: "good signature", annotations: []string{"rsa-sha1"}}
+	sigs.GetInstance(2).VerifyResult = &VerifyResult{status: VerifyStatusFail, msg: "signature is not valid", err: errors.New("verification error")}
+
+	got, err := sigs.ExportJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"version":1,"cv":"fail","result":"fail","instances":[` +
+		`{"i":1,"seal":{"d":"origin.example","s":"s1","a":"ed25519-sha256","cv":"none"},` +
+		`"ams":{"d":"origin.example","s":"s1","a":"ed25519-sha256","c":"relaxed/simple","h":["from"]},` +
+		`"aar":{"authserv_id":"mx.origin.example","results":[` +
+		`{"method":"dkim","result":"pass","properties":[{"type":"header","name":"d","value":"example.jp"}]},` +
+		`{"method":"spf","result":"pass","properties":[{"type":"smtp","name":"mailfrom","value":"example.jp"}]}]},` +
+		`"verification":{"status":"pass","message":"good signature","annotations":["rsa-sha1"]}},` +
+		`{"i":2,"seal":{"d":"list.example","s":"s2","a":"rsa-sha256","cv":"pass","t":200},` +
+		`"ams":{"d":"list.example","s":"s2","a":"rsa-sha256","c":"relaxed/relaxed","h":["from","to"]},` +
+		`"aar":{"authserv_id":"mx.list.example","results":[` +
+		`{"method":"arc","result":"pass","comment":"i=1","properties":[{"type":"smtp","name":"remote-ip","value":"192.0.2.1"}]}]},` +
+		`"verification":{"status":"fail","message":"signature is not valid","error":"verification error"}}]}`
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("want %s, but got %s", want, got)
+	}
+
+	// 未検証のチェーンはverificationを含まない
+	sigs, err = ParseARCHeaders(headers[3:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var e ChainExport
+	data, err := sigs.ExportJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if len(e.Instances) != 1 || e.Instances[0].Verification != nil || e.Result != VerifyStatusNone {
+		t.Errorf("unexpected export: %s", data)
+	}
+
+	// ARCヘッダがない場合は空のinstances
+	var empty *Signatures
+	if data, err := empty.ExportJSON(); err != nil || string(data) != `{"version":1,"cv":"none","result":"none","instances":[]}` {
+		t.Errorf("unexpected export: %s (%v)", data, err)
+	}
+}
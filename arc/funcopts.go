@@ -0,0 +1,70 @@
+package arc
+
+import (
+	"crypto"
+
+	"github.com/masa23/mmauth/domainkey"
+)
+
+// VerifyOption はVerifyOptionsの項目を設定する関数
+type VerifyOption func(*VerifyOptions)
+
+// NewVerifyOptions はoptsを順に適用したVerifyOptionsを返す
+func NewVerifyOptions(opts ...VerifyOption) *VerifyOptions {
+	o := &VerifyOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSHA1Policy はrsa-sha1の署名の扱いを設定する
+func WithSHA1Policy(policy domainkey.SHA1Policy) VerifyOption {
+	return func(o *VerifyOptions) { o.SHA1Policy = policy }
+}
+
+// WithStrictEd25519Keys はPKIX形式で公開されたed25519の鍵をpermerrorにする
+func WithStrictEd25519Keys() VerifyOption {
+	return func(o *VerifyOptions) { o.StrictEd25519Keys = true }
+}
+
+// VerifyWith はoptsを適用してVerifyWithOptionsと同じく検証する
+func (arc *Signature) VerifyWith(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts ...VerifyOption) {
+	arc.VerifyWithOptions(headers, bodyHash, domainKey, NewVerifyOptions(opts...))
+}
+
+// SignerOption はSignerOptionsの項目を設定する関数
+type SignerOption func(*SignerOptions)
+
+// NewSignerOptions はoptsを順に適用したSignerOptionsを返す
+func NewSignerOptions(opts ...SignerOption) *SignerOptions {
+	o := &SignerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSelfCheck は署名の直後にpubで署名を検証する
+// pubがnilの場合は署名に使う鍵のPublic()
+func WithSelfCheck(pub crypto.PublicKey) SignerOption {
+	return func(o *SignerOptions) {
+		o.SelfCheck = true
+		o.PublicKey = pub
+	}
+}
+
+// WithSignHeaders はARC-Message-Signatureで署名するヘッダ名を設定する
+func WithSignHeaders(names ...string) SignerOption {
+	return func(o *SignerOptions) { o.Headers = names }
+}
+
+// SignWith はoptsを適用してSignWithOptionsと同じくARC-Message-Signatureを署名する
+func (ams *ARCMessageSignature) SignWith(headers []string, key crypto.Signer, opts ...SignerOption) error {
+	return ams.SignWithOptions(headers, key, NewSignerOptions(opts...))
+}
+
+// SignWith はoptsを適用してSignWithOptionsと同じくARC-Sealを署名する
+func (as *ARCSeal) SignWith(headers []string, key crypto.Signer, opts ...SignerOption) error {
+	return as.SignWithOptions(headers, key, NewSignerOptions(opts...))
+}
@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/masa23/mmauth/authres"
 	"github.com/masa23/mmauth/internal/header"
 )
 
+// MaxInstance はインスタンス番号の上限 (RFC 8617 4.2.1)
+const MaxInstance = 50
+
 type Signatures []*Signature
 
 // インスタンス番号を指定してSignatureを取得する
@@ -42,20 +46,26 @@ func (s *Signatures) GetMaxInstance() int {
 
 // 最後のARCのVerify結果を文字列で取得する
 func (s *Signatures) GetVerifyResultString() string {
+	none := authres.None(authres.MethodARC).String()
 	if s == nil {
-		return "arc=none"
+		return none
 	}
 	max := s.GetMaxInstance()
 	if max == 0 {
-		return "arc=none"
+		return none
 	}
 
 	// 最後のインスタンスの結果を取得
 	ah := s.GetInstance(max)
 	if ah == nil || ah.VerifyResult == nil {
-		return "arc=none"
+		return none
+	}
+	ri := &authres.ResultInfo{
+		Method:  authres.MethodARC,
+		Result:  ah.VerifyResult.Status(),
+		Comment: fmt.Sprintf("i=%d %s", ah.GetInstanceNumber(), ah.VerifyResult.Message()),
 	}
-	return fmt.Sprintf("arc=%s (i=%d %s)", ah.VerifyResult.Status(), ah.GetInstanceNumber(), ah.VerifyResult.Message())
+	return ri.String()
 }
 
 // 最後のARCのVerify結果を取得する
@@ -89,6 +99,11 @@ func (s *Signatures) GetARCChainValidation() ChainValidationResult {
 		return ChainValidationResultNone
 	}
 
+	// 最後のインスタンスの検証がfailの場合(チェーンのポリシー違反を含む)はFail
+	if last := s.GetInstance(max); last.VerifyResult != nil && last.VerifyResult.Status() == VerifyStatusFail {
+		return ChainValidationResultFail
+	}
+
 	// すべてのインスタンスが検証済みかを確認
 	allVerified := true
 	for i := 1; i <= max; i++ {
@@ -102,14 +117,23 @@ func (s *Signatures) GetARCChainValidation() ChainValidationResult {
 	// すべてのインスタンスが検証済みの場合、検証結果に基づいて判定
 	if allVerified {
 		// 最大インスタンスから1まで降順にチェック
+		neutral := false
 		for i := max; i >= 1; i-- {
 			a := s.GetInstance(i)
 			result := a.GetVerifyResult()
+			// ポリシーで鍵を取得できなかったインスタンスをneutralとした場合は評価できなかったものとする
+			if result.Status() == VerifyStatusNeutral && i < max {
+				neutral = true
+				continue
+			}
 			// いずれかのインスタンスで検証が失敗した場合はFail
 			if result.Status() != VerifyStatusPass {
 				return ChainValidationResultFail
 			}
 		}
+		if neutral {
+			return ChainValidationResultNone
+		}
 		// すべてのインスタンスがPassの場合はPass
 		return ChainValidationResultPass
 	}
@@ -135,6 +159,12 @@ func (s *Signatures) GetARCChainValidation() ChainValidationResult {
 
 // ARCヘッダをパースする
 func ParseARCHeaders(headers []string) (*Signatures, error) {
+	return ParseARCHeadersWithOptions(headers, nil)
+}
+
+// ParseARCHeadersWithOptions はオプションを指定してARCヘッダをパースする
+// optsがnilの場合はParseARCHeadersと同じ
+func ParseARCHeadersWithOptions(headers []string, opts *ParseOptions) (*Signatures, error) {
 	var sigs Signatures
 
 	for _, h := range headers {
@@ -145,8 +175,8 @@ func ParseARCHeaders(headers []string) (*Signatures, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse arc-seal: %v", err)
 			}
-			// インスタンス番号が50以上の場合はエラー
-			if ret.InstanceNumber > 50 {
+			// インスタンス番号がMaxInstanceを超える場合はエラー
+			if ret.InstanceNumber > MaxInstance {
 				return nil, fmt.Errorf("instance number is too large")
 			}
 			as := sigs.GetInstance(ret.InstanceNumber)
@@ -156,19 +186,19 @@ func ParseARCHeaders(headers []string) (*Signatures, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse arc-authentication-results: %v", err)
 			}
-			// インスタンス番号が50以上の場合はエラー
-			if ret.InstanceNumber > 50 {
+			// インスタンス番号がMaxInstanceを超える場合はエラー
+			if ret.InstanceNumber > MaxInstance {
 				return nil, fmt.Errorf("instance number is too large")
 			}
 			as := sigs.GetInstance(ret.InstanceNumber)
 			as.arcAuthenticationResults = ret
 		case "arc-message-signature":
-			ret, err := ParseARCMessageSignature(h)
+			ret, err := ParseARCMessageSignatureWithOptions(h, opts)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse arc-message-signature: %v", err)
+				return nil, fmt.Errorf("failed to parse arc-message-signature: %w", err)
 			}
-			// インスタンス番号が50以上の場合はエラー
-			if ret.InstanceNumber > 50 {
+			// インスタンス番号がMaxInstanceを超える場合はエラー
+			if ret.InstanceNumber > MaxInstance {
 				return nil, fmt.Errorf("instance number is too large")
 			}
 			as := sigs.GetInstance(ret.InstanceNumber)
@@ -0,0 +1,115 @@
+package arc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// Instance は1つのインスタンス番号のARCヘッダの組(AAR/AMS/AS)
+// メッセージに含まれていないヘッダはnil
+type Instance struct {
+	Number                int
+	AuthenticationResults *ARCAuthenticationResults
+	MessageSignature      *ARCMessageSignature
+	Seal                  *ARCSeal
+}
+
+// Complete はAAR・AMS・ASがすべて揃っているかを返す
+func (in *Instance) Complete() bool {
+	return in.AuthenticationResults != nil && in.MessageSignature != nil && in.Seal != nil
+}
+
+// Headers はインスタンスのヘッダをARC-Sealで署名する順(AAR, AMS, AS)に受信したままの形で返す
+// 存在しないヘッダは含まない
+func (in *Instance) Headers() []string {
+	var ret []string
+	if in.AuthenticationResults != nil {
+		ret = append(ret, in.AuthenticationResults.Raw())
+	}
+	if in.MessageSignature != nil {
+		ret = append(ret, in.MessageSignature.Raw())
+	}
+	if in.Seal != nil {
+		ret = append(ret, in.Seal.Raw())
+	}
+	return ret
+}
+
+// ExtractInstances はヘッダからARCヘッダを取り出し、インスタンス番号の昇順に返す
+// ARC以外のヘッダは無視する。検証は行わず、インスタンスの欠落や不足しているヘッダもエラーにしないため、
+// 壊れたチェーンの調査にも使える。解析できないARCヘッダがある場合はエラーを返す
+// 同じインスタンスに同じ種類のヘッダが複数ある場合は後にあるものを使う
+func ExtractInstances(headers []string) ([]Instance, error) {
+	instances, _, err := extractInstances(headers)
+	return instances, err
+}
+
+// extractInstances はExtractInstancesと同じく、同じインスタンスに同じ種類のヘッダが
+// 複数あったインスタンス番号も返す
+func extractInstances(headers []string) ([]Instance, []int, error) {
+	var duplicates []int
+	duplicated := func(i int, exists bool) {
+		if exists {
+			duplicates = append(duplicates, i)
+		}
+	}
+	byNumber := make(map[int]*Instance)
+	get := func(i int) *Instance {
+		in, ok := byNumber[i]
+		if !ok {
+			in = &Instance{Number: i}
+			byNumber[i] = in
+		}
+		return in
+	}
+
+	for _, h := range headers {
+		k, _ := header.ParseHeaderField(h)
+		switch strings.ToLower(k) {
+		case "arc-seal":
+			ret, err := ParseARCSeal(h)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse arc-seal: %v", err)
+			}
+			in := get(ret.InstanceNumber)
+			duplicated(ret.InstanceNumber, in.Seal != nil)
+			in.Seal = ret
+		case "arc-authentication-results":
+			ret, err := ParseARCAuthenticationResults(h)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse arc-authentication-results: %v", err)
+			}
+			in := get(ret.InstanceNumber)
+			duplicated(ret.InstanceNumber, in.AuthenticationResults != nil)
+			in.AuthenticationResults = ret
+		case "arc-message-signature":
+			ret, err := ParseARCMessageSignature(h)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse arc-message-signature: %v", err)
+			}
+			in := get(ret.InstanceNumber)
+			duplicated(ret.InstanceNumber, in.MessageSignature != nil)
+			in.MessageSignature = ret
+		}
+	}
+
+	instances := make([]Instance, 0, len(byNumber))
+	for _, in := range byNumber {
+		instances = append(instances, *in)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Number < instances[j].Number })
+	return instances, duplicates, nil
+}
+
+// findInstance はinstancesからインスタンス番号iのものを返す。ない場合は空のInstance
+func findInstance(instances []Instance, i int) Instance {
+	for _, in := range instances {
+		if in.Number == i {
+			return in
+		}
+	}
+	return Instance{Number: i}
+}
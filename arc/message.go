@@ -11,6 +11,9 @@ import (
 	"time"
 
 	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/headerfold"
+	"github.com/masa23/mmauth/internal/bodyhash"
 	"github.com/masa23/mmauth/internal/canonical"
 	"github.com/masa23/mmauth/internal/dkimheader"
 	"github.com/masa23/mmauth/internal/header"
@@ -46,19 +49,29 @@ func (ams *ARCMessageSignature) GetCanonicalizationAndAlgorithm() *Canonicalizat
 // ヘッダ名は含まない
 func (ams ARCMessageSignature) String() string {
 	// format: i=1; a=rsa-sha256; d=example.com; s=selector; t=1234567890; h=from:to:subject; b=MIIBI...
-	return fmt.Sprintf("i=%d; a=%s; c=%s; d=%s; s=%s;\r\n"+
-		"        h=%s;\r\n"+
-		"        bh=%s; t=%d;\r\n"+
-		"        b=%s",
-		ams.InstanceNumber, ams.Algorithm, ams.Canonicalization, ams.Domain, ams.Selector,
-		ams.Headers,
-		ams.BodyHash, ams.Timestamp,
-		header.WrapSignatureWithBreaks(ams.Signature),
-	)
+	f := headerfold.NewFieldFolder("ARC-Message-Signature", nil)
+	f.Word(fmt.Sprintf("i=%d;", ams.InstanceNumber))
+	f.Word(fmt.Sprintf("a=%s;", ams.Algorithm))
+	f.Word(fmt.Sprintf("c=%s;", ams.Canonicalization))
+	f.Word(fmt.Sprintf("d=%s;", ams.Domain))
+	f.Word(fmt.Sprintf("s=%s;", ams.Selector))
+	f.Newline()
+	f.Append("h=")
+	f.List(strings.Split(ams.Headers+";", ":"), ":")
+	f.Newline()
+	f.Word(fmt.Sprintf("bh=%s;", ams.BodyHash))
+	f.Word(fmt.Sprintf("t=%d;", ams.Timestamp))
+	return f.Value() + "\r\n        b=" + headerfold.WrapSignatureWithBreaks(ams.Signature)
 }
 
 // ARC-Message-Signature のパース
 func ParseARCMessageSignature(s string) (*ARCMessageSignature, error) {
+	return ParseARCMessageSignatureWithOptions(s, nil)
+}
+
+// ParseARCMessageSignatureWithOptions はオプションを指定してARC-Message-Signatureをパースする
+// optsがnilの場合はParseARCMessageSignatureと同じ
+func ParseARCMessageSignatureWithOptions(s string, opts *ParseOptions) (*ARCMessageSignature, error) {
 	result := &ARCMessageSignature{}
 	result.raw = s
 
@@ -67,17 +80,13 @@ func ParseARCMessageSignature(s string) (*ARCMessageSignature, error) {
 	if !strings.EqualFold(k, "arc-message-signature") {
 		return nil, fmt.Errorf("invalid header field")
 	}
-	fields := strings.Split(v, ";")
-
-	for _, field := range fields {
-		keyValue := strings.SplitN(strings.TrimSpace(field), "=", 2)
-		if len(keyValue) != 2 {
-			continue
-		}
-
-		key := strings.ToLower(strings.TrimSpace(keyValue[0]))
-		value := header.StripWhiteSpace(keyValue[1])
+	params, err := dkimheader.ParseARCMessageSignatureParamsWithLimits(v, opts.limits())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ARC-Message-Signature header field: %w", err)
+	}
 
+	for key, value := range params {
+		value = header.StripWhiteSpace(value)
 		switch key {
 		case "i":
 			instanceNumber, err := strconv.Atoi(value)
@@ -116,7 +125,8 @@ func ParseARCMessageSignature(s string) (*ARCMessageSignature, error) {
 		case "bh":
 			result.BodyHash = value
 		case "h":
-			result.Headers = value
+			// h=はコロンの前後で折り返されていることがある
+			result.Headers = header.StripWhiteSpace(value)
 		}
 	}
 
@@ -125,8 +135,8 @@ func ParseARCMessageSignature(s string) (*ARCMessageSignature, error) {
 		return nil, err
 	}
 	result.canonnAndAlgo = &CanonicalizationAndAlgorithm{
-		Header:    Canonicalization(canHeader),
-		Body:      Canonicalization(canBody),
+		Header:    canHeader,
+		Body:      canBody,
 		Algorithm: result.Algorithm,
 		HashAlgo:  hashAlgo(result.Algorithm),
 	}
@@ -134,8 +144,30 @@ func ParseARCMessageSignature(s string) (*ARCMessageSignature, error) {
 	return result, nil
 }
 
+// ErrSignHeadersMissingFrom は署名するヘッダにFromが含まれていない場合のエラー
+// AMSのh=はDKIM-Signatureと同じ規則に従い(RFC 8617 4.1.2)、Fromは必ず署名しなければならない
+var ErrSignHeadersMissingFrom = errors.New("arc: signed header list must include From")
+
+// RecommendedSignHeaders はAMSで署名を推奨するヘッダ (RFC 6376 5.4.1)
+// SignerOptions.Headersに指定すると、これらのうちメッセージに存在するものだけを署名する
+var RecommendedSignHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc",
+	"Resent-Date", "Resent-From", "Resent-To", "Resent-Cc",
+	"In-Reply-To", "References",
+	"List-Id", "List-Help", "List-Unsubscribe", "List-Subscribe", "List-Post", "List-Owner", "List-Archive",
+	"Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"DKIM-Signature",
+}
+
 // ARC-Message-Signature の署名
+// headersのヘッダ(RFC 8617で禁止されるものを除く)をすべて署名する
+// Fromが含まれていない場合はErrSignHeadersMissingFromを返す
 func (ams *ARCMessageSignature) Sign(headers []string, key crypto.Signer) error {
+	return ams.sign(headers, key, nil)
+}
+
+// namesがnilでない場合は、namesに含まれるヘッダだけを署名する
+func (ams *ARCMessageSignature) sign(headers []string, key crypto.Signer, names []string) error {
 	// RFC 8617で禁止されるヘッダを定義
 	forbiddenHeaders := map[string]bool{
 		"authentication-results":     true,
@@ -156,9 +188,15 @@ func (ams *ARCMessageSignature) Sign(headers []string, key crypto.Signer) error
 		if forbiddenHeaders[lowerK] {
 			continue
 		}
+		if names != nil && !containsHeaderName(names, strings.TrimSpace(k)) {
+			continue
+		}
 		h = append(h, k)
 	}
 	h = header.RemoveDuplicates(h)
+	if !containsHeaderName(h, "From") {
+		return ErrSignHeadersMissingFrom
+	}
 	canHeader, _, err := header.ParseHeaderCanonicalization(ams.Canonicalization)
 	if err != nil {
 		return err
@@ -190,8 +228,8 @@ func (ams *ARCMessageSignature) Sign(headers []string, key crypto.Signer) error
 			return fmt.Errorf("failed to parse canonicalization: %w", err)
 		}
 		ams.canonnAndAlgo = &CanonicalizationAndAlgorithm{
-			Header:    Canonicalization(canHeaderCanon),
-			Body:      Canonicalization(canBodyCanon),
+			Header:    canHeaderCanon,
+			Body:      canBodyCanon,
 			Algorithm: ams.Algorithm,
 			HashAlgo:  hashAlgo(ams.Algorithm),
 		}
@@ -201,11 +239,15 @@ func (ams *ARCMessageSignature) Sign(headers []string, key crypto.Signer) error
 	signingHeaders := header.ExtractHeadersDKIM(headers, strings.Split(ams.Headers, ":"))
 
 	// AMSヘッダ自身を署名対象に追加 (b=値を空にしてcanonicalize)
-	amsSigHeader := dkimheader.StripBValueForSigning("ARC-Message-Signature: " + ams.String() + "\r\n")
+	amsField := "ARC-Message-Signature: " + ams.String() + "\r\n"
+	if err := headerfold.Check(amsField, nil); err != nil {
+		return fmt.Errorf("arc: %w", err)
+	}
+	amsSigHeader := dkimheader.StripBValueForSigning(amsField)
 	signingHeaders = append(signingHeaders, amsSigHeader)
 
 	// RFC 6376 §3.7: the signature header field itself is hashed without a trailing CRLF.
-	signature, err := header.SignerWithOmitLastCRLF(signingHeaders, key, canonical.Canonicalization(canHeader), ams.canonnAndAlgo.HashAlgo, true)
+	signature, err := header.SignerWithOmitLastCRLF(signingHeaders, key, canHeader, ams.canonnAndAlgo.HashAlgo, true)
 	if err != nil {
 		return err
 	}
@@ -213,6 +255,16 @@ func (ams *ARCMessageSignature) Sign(headers []string, key crypto.Signer) error
 	return nil
 }
 
+// 大文字小文字を区別せずにヘッダ名が含まれているかを返す
+func containsHeaderName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSpace(n), name) {
+			return true
+		}
+	}
+	return false
+}
+
 // ARC-Message-Signature の検証
 func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domainKey *domainkey.DomainKey) *VerifyResult {
 	// h= に含まれてはいけないヘッダをチェック
@@ -228,7 +280,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 		if forbiddenHeaders[normalized] {
 			return &VerifyResult{
 				status: VerifyStatusPermErr,
-				err:    fmt.Errorf("ARC-Message-Signature header field contains forbidden header: %s", normalized),
+				err:    errcode.Errorf(errcode.ARCPermErrorHeader, "ARC-Message-Signature header field contains forbidden header: %s", normalized),
 				msg:    fmt.Sprintf("forbidden header %s found in h= tag", normalized),
 			}
 		}
@@ -240,13 +292,13 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 		if errors.Is(err, domainkey.ErrNoRecordFound) {
 			return &VerifyResult{
 				status: VerifyStatusPermErr,
-				err:    fmt.Errorf("domain key is not found: %v", err),
+				err:    errcode.Errorf(errcode.ARCPermErrorKeyNotFound, "domain key is not found: %v", err),
 				msg:    "domain key is not found",
 			}
 		} else if err != nil {
 			return &VerifyResult{
 				status: VerifyStatusTempErr,
-				err:    fmt.Errorf("failed to lookup domain key: %v", err),
+				err:    errcode.Errorf(errcode.ARCTempErrorKeyLookup, "failed to lookup domain key: %v", err),
 				msg:    "failed to lookup domain key",
 			}
 		}
@@ -256,17 +308,17 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 	if ams.raw == "" {
 		return &VerifyResult{
 			status:    VerifyStatusNeutral,
-			err:       fmt.Errorf("arc message signature is not found"),
+			err:       errcode.New(errcode.ARCNeutralNoSignature, "arc message signature is not found"),
 			msg:       "sign is not found",
 			domainKey: domainKey,
 		}
 	}
 
 	// ボディハッシュの検証
-	if ams.BodyHash != bodyHash {
+	if !bodyhash.Equal(ams.BodyHash, bodyHash) {
 		return &VerifyResult{
 			status:    VerifyStatusFail,
-			err:       fmt.Errorf("ARC-Message-Signature body hash is not match: %s != %s", ams.BodyHash, bodyHash),
+			err:       errcode.Errorf(errcode.ARCFailBodyHash, "ARC-Message-Signature body hash is not match: %s != %s", ams.BodyHash, bodyHash),
 			msg:       "body hash is not match",
 			domainKey: domainKey,
 		}
@@ -302,16 +354,16 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 		if strings.EqualFold(strings.TrimSpace(k), "ARC-Seal") {
 			return &VerifyResult{
 				status:    VerifyStatusPermErr,
-				err:       fmt.Errorf("ARC-Message-Signature header field contains ARC-Seal"),
+				err:       errcode.New(errcode.ARCPermErrorHeader, "ARC-Message-Signature header field contains ARC-Seal"),
 				msg:       "ARC-Seal is found",
 				domainKey: domainKey,
 			}
 		}
-		s += canonical.Header(header, canonical.Canonicalization(ams.canonnAndAlgo.Header))
+		s += canonical.Header(header, ams.canonnAndAlgo.Header)
 	}
 
 	// AMSヘッダ自身を追加
-	s += canonical.Header(amsSigHeader, canonical.Canonicalization(ams.canonnAndAlgo.Header))
+	s += canonical.Header(amsSigHeader, ams.canonnAndAlgo.Header)
 
 	// 末尾の\r\nを削除 (DKIM方式に統一)
 	s = strings.TrimSuffix(s, "\r\n")
@@ -321,7 +373,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 	if err != nil {
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to decode arc-message-signature signature: %v", err),
+			err:       errcode.Errorf(errcode.ARCPermErrorHeader, "failed to decode arc-message-signature signature: %v", err),
 			msg:       "invalid signature",
 			domainKey: domainKey,
 		}
@@ -336,7 +388,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 	if err != nil {
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to decode domainkey public key: %v", err),
+			err:       errcode.Errorf(errcode.ARCPermErrorKeyInvalid, "failed to decode domainkey public key: %v", err),
 			msg:       "invalid public key",
 			domainKey: domainKey,
 		}
@@ -348,7 +400,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 	if err != nil {
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to parse domainkey public key: %v", err),
+			err:       errcode.Errorf(errcode.ARCPermErrorKeyInvalid, "failed to parse domainkey public key: %v", err),
 			msg:       "invalid public key",
 			domainKey: domainKey,
 		}
@@ -360,7 +412,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 		if err := rsa.VerifyPKCS1v15(pub, ams.canonnAndAlgo.HashAlgo, hash.Sum(nil), signature); err != nil {
 			return &VerifyResult{
 				status:    VerifyStatusFail,
-				err:       fmt.Errorf("failed to verify arc-message-signature signature: %v", err),
+				err:       errcode.Errorf(errcode.ARCFailSignature, "failed to verify arc-message-signature signature: %v", err),
 				msg:       "invalid signature",
 				domainKey: domainKey,
 			}
@@ -370,7 +422,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 		if !ed25519.Verify(pub, hash.Sum(nil), signature) {
 			return &VerifyResult{
 				status:    VerifyStatusFail,
-				err:       fmt.Errorf("failed to verify arc-message-signature signature"),
+				err:       errcode.New(errcode.ARCFailSignature, "failed to verify arc-message-signature signature"),
 				msg:       "invalid signature",
 				domainKey: domainKey,
 			}
@@ -378,7 +430,7 @@ func (ams *ARCMessageSignature) Verify(headers []string, bodyHash string, domain
 	default:
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to convert arc-message-signature public key to rsa or ed25519"),
+			err:       errcode.New(errcode.ARCPermErrorKeyInvalid, "failed to convert arc-message-signature public key to rsa or ed25519"),
 			msg:       "invalid public key",
 			domainKey: domainKey,
 		}
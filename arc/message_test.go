@@ -2,10 +2,15 @@ package arc
 
 import (
 	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/headerfold"
 	"github.com/masa23/mmauth/internal/bodyhash"
 	"github.com/masa23/mmauth/internal/canonical"
 )
@@ -127,6 +132,36 @@ func TestARCMessageSignatureParse(t *testing.T) {
 	}
 }
 
+func TestARCMessageSignatureParseInvalid(t *testing.T) {
+	base := "a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=selector; h=From; bh=aGFzaA==; b=c2ln"
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{name: "duplicate tag", input: "i=1; " + base + "; d=example.net"},
+		{name: "duplicate tag case-insensitive", input: "i=1; " + base + "; S=other"},
+		{name: "malformed tag", input: "i=1; " + base + "; broken"},
+		{name: "missing instance", input: base},
+		{name: "missing body hash", input: "i=1; a=rsa-sha256; d=example.com; s=selector; h=From; b=c2ln"},
+		{name: "missing signature", input: "i=1; a=rsa-sha256; d=example.com; s=selector; h=From; bh=aGFzaA=="},
+		{name: "instance zero", input: "i=0; " + base},
+		{name: "instance too large", input: "i=51; " + base},
+		{name: "instance not a number", input: "i=one; " + base},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseARCMessageSignature("ARC-Message-Signature: " + tc.input + "\r\n"); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+
+	// 上限の50は有効
+	if _, err := ParseARCMessageSignature("ARC-Message-Signature: i=50; " + base + "\r\n"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestARCMessageSignatureSign(t *testing.T) {
 	testCases := []struct {
 		name    string
@@ -564,3 +599,159 @@ func TestARCMessageSignatureSignAndVerify(t *testing.T) {
 		t.Errorf("Verification failed: %s - %s", result.Status(), result.Message())
 	}
 }
+
+func TestSignWithOptionsSelfCheck(t *testing.T) {
+	headers := []string{
+		"From: alice@example.com\r\n",
+		"To: bob@example.com\r\n",
+		"Subject: Test\r\n",
+		"ARC-Authentication-Results: i=1; example.com; spf=pass\r\n",
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	testCases := []struct {
+		name string
+		key  crypto.Signer
+		opts *SignerOptions
+		err  error
+	}{
+		{name: "rsa", key: testKeys.RSAPrivateKey, opts: &SignerOptions{SelfCheck: true}},
+		{name: "ed25519", key: testKeys.ED25519PrivateKey, opts: &SignerOptions{SelfCheck: true}},
+		{name: "no options", key: testKeys.RSAPrivateKey},
+		{name: "mismatched public key", key: testKeys.RSAPrivateKey, opts: &SignerOptions{SelfCheck: true, PublicKey: &otherKey.PublicKey}, err: ErrSelfCheckFailed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ams := &ARCMessageSignature{
+				Canonicalization: "relaxed/relaxed",
+				Domain:           "example.com",
+				Selector:         "default",
+				InstanceNumber:   1,
+				BodyHash:         "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=",
+			}
+			if err := ams.SignWithOptions(headers, tc.key, tc.opts); !errors.Is(err, tc.err) {
+				t.Fatalf("want %v, but got %v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+			as := &ARCSeal{
+				InstanceNumber:  1,
+				ChainValidation: ChainValidationResultNone,
+				Domain:          "example.com",
+				Selector:        "default",
+			}
+			sealHeaders := append(append([]string(nil), headers...), "ARC-Message-Signature: "+ams.String()+"\r\n")
+			if err := as.SignWithOptions(sealHeaders, tc.key, tc.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestARCMessageSignatureSignHeaders(t *testing.T) {
+	headers := []string{
+		"Received: from mx.example.net\r\n",
+		"From: alice@example.com\r\n",
+		"To: bob@example.com\r\n",
+		"Subject: Test\r\n",
+		"X-Mailer: test\r\n",
+		"Authentication-Results: example.com; spf=pass\r\n",
+	}
+	longHeaders := append([]string(nil), headers...)
+	longList := []string{"From"}
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("X-Long-Header-Name-%02d", i)
+		longHeaders = append(longHeaders, name+": value\r\n")
+		longList = append(longList, name)
+	}
+	tooLong := "X-" + strings.Repeat("a", headerfold.HardLimit)
+
+	testCases := []struct {
+		name        string
+		headers     []string
+		opts        *SignerOptions
+		wantHeaders string
+		err         error
+	}{
+		{name: "all headers", headers: headers, wantHeaders: "Received:From:To:Subject:X-Mailer"},
+		{name: "recommended headers", headers: headers, opts: &SignerOptions{Headers: RecommendedSignHeaders}, wantHeaders: "From:To:Subject"},
+		{name: "custom headers", headers: headers, opts: &SignerOptions{Headers: []string{"from", "x-mailer"}}, wantHeaders: "From:X-Mailer"},
+		{name: "missing from", headers: headers[2:], err: ErrSignHeadersMissingFrom},
+		{name: "from not in list", headers: headers, opts: &SignerOptions{Headers: []string{"To", "Subject"}}, err: ErrSignHeadersMissingFrom},
+		{name: "long list is folded", headers: longHeaders, opts: &SignerOptions{Headers: longList}, wantHeaders: strings.Join(longList, ":")},
+		{name: "header name over the hard limit", headers: append([]string{tooLong + ": value\r\n"}, headers...), opts: &SignerOptions{Headers: []string{"From", tooLong}}, err: headerfold.ErrLineTooLong},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ams := &ARCMessageSignature{
+				Canonicalization: "relaxed/relaxed",
+				Domain:           "example.com",
+				Selector:         "default",
+				InstanceNumber:   1,
+				BodyHash:         "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=",
+			}
+			err := ams.SignWithOptions(tc.headers, testKeys.RSAPrivateKey, tc.opts)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("want %v, but got %v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if ams.Headers != tc.wantHeaders {
+				t.Errorf("want %s, but got %s", tc.wantHeaders, ams.Headers)
+			}
+			field := "ARC-Message-Signature: " + ams.String()
+			for _, line := range strings.Split(field, "\r\n") {
+				if len(line) > headerfold.SoftLimit {
+					t.Errorf("want at most %d characters, but got %q", headerfold.SoftLimit, line)
+				}
+			}
+			parsed, err := ParseARCMessageSignature(field + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if parsed.Headers != tc.wantHeaders {
+				t.Errorf("want parsed %s, but got %s", tc.wantHeaders, parsed.Headers)
+			}
+		})
+	}
+}
+
+func TestSignWith(t *testing.T) {
+	headers := []string{
+		"From: alice@example.com\r\n",
+		"To: bob@example.com\r\n",
+		"X-Mailer: test\r\n",
+		"ARC-Authentication-Results: i=1; example.com; spf=pass\r\n",
+	}
+	ams := &ARCMessageSignature{
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "default",
+		InstanceNumber:   1,
+		BodyHash:         "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=",
+	}
+	if err := ams.SignWith(headers, testKeys.RSAPrivateKey, WithSelfCheck(nil), WithSignHeaders(RecommendedSignHeaders...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ams.Headers != "From:To" {
+		t.Errorf("want From:To, but got %s", ams.Headers)
+	}
+	as := &ARCSeal{
+		InstanceNumber:  1,
+		ChainValidation: ChainValidationResultNone,
+		Domain:          "example.com",
+		Selector:        "default",
+	}
+	sealHeaders := append(append([]string(nil), headers...), "ARC-Message-Signature: "+ams.String()+"\r\n")
+	if err := as.SignWith(sealHeaders, testKeys.RSAPrivateKey, WithSelfCheck(&testKeys.RSAPrivateKey.PublicKey)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := as.SignWith(sealHeaders, testKeys.ED25519PrivateKey, WithSelfCheck(&testKeys.RSAPrivateKey.PublicKey)); !errors.Is(err, ErrSelfCheckFailed) {
+		t.Errorf("want %v, but got %v", ErrSelfCheckFailed, err)
+	}
+}
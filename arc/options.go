@@ -0,0 +1,159 @@
+package arc
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/internal/dkimheader"
+)
+
+// ErrSelfCheckFailed はSelfCheckで作成した署名を検証できなかった場合のエラー
+var ErrSelfCheckFailed = errors.New("arc: signature failed self-check")
+
+// ErrTagTooLong はARC-Message-Signatureのタグの名前または値がParseOptionsの上限を超えた場合のエラー
+// dkim.ErrTagTooLongと同じ値
+var ErrTagTooLong = dkimheader.ErrTagTooLong
+
+// ParseOptions はARC-Message-Signatureのパースのオプション
+type ParseOptions struct {
+	// MaxTagNameLength はタグの名前の長さの上限。0の場合はdkim.DefaultMaxTagNameLengthと同じ値
+	MaxTagNameLength int
+	// MaxTagValueLength はタグの値の長さの上限。0の場合はdkim.DefaultMaxTagValueLengthと同じ値
+	MaxTagValueLength int
+}
+
+func (o *ParseOptions) limits() dkimheader.Limits {
+	if o == nil {
+		return dkimheader.Limits{}
+	}
+	return dkimheader.Limits{MaxTagNameLength: o.MaxTagNameLength, MaxTagValueLength: o.MaxTagValueLength}
+}
+
+// VerifyOptions はARCの検証のオプション
+type VerifyOptions struct {
+	// SHA1Policy はARC-SealまたはARC-Message-Signatureがrsa-sha1の場合の扱い
+	// 空の場合はdomainkey.DefaultSHA1Policy
+	SHA1Policy domainkey.SHA1Policy
+	// StrictEd25519Keys がtrueの場合、PKIX形式で公開されたed25519の鍵による署名をpermerrorにする
+	// falseの場合は検証し、結果にdomainkey.PKIXEd25519Annotationの注記を付ける
+	StrictEd25519Keys bool
+}
+
+// ed25519の鍵がPKIX形式で公開されていれば注記を付け、StrictEd25519Keysに従って検証結果を変更する
+func (arc *Signature) applyKeyEncodingPolicy(opts *VerifyOptions) {
+	if arc == nil || arc.VerifyResult == nil || !arc.VerifyResult.domainKey.IsPKIXEd25519() {
+		return
+	}
+	result := arc.VerifyResult
+	result.annotations = append(result.annotations, domainkey.PKIXEd25519Annotation)
+	if opts != nil && opts.StrictEd25519Keys && (result.status == VerifyStatusPass || result.status == VerifyStatusFail) {
+		result.status = VerifyStatusPermErr
+		result.err = errcode.Errorf(errcode.ARCPermErrorKeyPolicy, "ARC set %d: ed25519 public key is published in PKIX form", arc.instanceNumber)
+		result.msg = "non-standard ed25519 key encoding"
+	}
+}
+
+// rsa-sha1を使っていればSHA1Policyに従って検証結果を変更する
+func (arc *Signature) applySHA1Policy(opts *VerifyOptions) {
+	if arc == nil || arc.VerifyResult == nil || arc.arcSeal == nil || arc.arcMessageSignature == nil {
+		return
+	}
+	if arc.arcSeal.Algorithm != SignatureAlgorithmRSA_SHA1 && arc.arcMessageSignature.Algorithm != SignatureAlgorithmRSA_SHA1 {
+		return
+	}
+	var policy domainkey.SHA1Policy
+	if opts != nil {
+		policy = opts.SHA1Policy
+	}
+	policy = policy.Resolve()
+	if policy == domainkey.SHA1Allow {
+		return
+	}
+	result := arc.VerifyResult
+	result.annotations = append(result.annotations, domainkey.SHA1Annotation)
+	if policy == domainkey.SHA1Deny && (result.status == VerifyStatusPass || result.status == VerifyStatusFail) {
+		result.status = VerifyStatusPermErr
+		result.err = errcode.Errorf(errcode.ARCPermErrorSHA1NotAllowed, "ARC set %d uses rsa-sha1 which is not allowed", arc.instanceNumber)
+		result.msg = "rsa-sha1 is not allowed"
+	}
+}
+
+// SignerOptions はARC-Message-SignatureとARC-Sealの署名のオプション
+type SignerOptions struct {
+	// SelfCheck がtrueの場合、署名の直後に公開鍵で署名を検証し、
+	// 検証できない場合はErrSelfCheckFailedを返す
+	// ARC-Message-Signatureのボディーハッシュは渡されたbh=をそのまま使う
+	SelfCheck bool
+	// PublicKey はSelfCheckに使う公開鍵。nilの場合は署名に使う鍵のPublic()
+	PublicKey crypto.PublicKey
+	// Headers はARC-Message-Signatureで署名するヘッダ名
+	// nilの場合は渡されたヘッダをすべて署名する。RecommendedSignHeadersを指定できる
+	Headers []string
+}
+
+// SignWithOptions はオプションを指定してARC-Message-Signatureを署名する
+// optsがnilの場合はSignと同じ
+func (ams *ARCMessageSignature) SignWithOptions(headers []string, key crypto.Signer, opts *SignerOptions) error {
+	var names []string
+	if opts != nil {
+		names = opts.Headers
+	}
+	if err := ams.sign(headers, key, names); err != nil {
+		return err
+	}
+	if opts == nil || !opts.SelfCheck {
+		return nil
+	}
+	domainKey, err := opts.selfCheckKey(key)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseARCMessageSignature("ARC-Message-Signature: " + ams.String() + "\r\n")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, err)
+	}
+	if result := parsed.Verify(headers, ams.BodyHash, domainKey); result.Status() != VerifyStatusPass {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, result.Error())
+	}
+	return nil
+}
+
+// SignWithOptions はオプションを指定してARC-Sealを署名する
+// headersには同じインスタンスのARC-Authentication-ResultsとARC-Message-Signatureを含める
+// optsがnilの場合はSignと同じ
+func (as *ARCSeal) SignWithOptions(headers []string, key crypto.Signer, opts *SignerOptions) error {
+	if err := as.Sign(headers, key); err != nil {
+		return err
+	}
+	if opts == nil || !opts.SelfCheck {
+		return nil
+	}
+	domainKey, err := opts.selfCheckKey(key)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseARCSeal("ARC-Seal: " + as.String() + "\r\n")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, err)
+	}
+	if result := parsed.Verify(headers, domainKey); result.Status() != VerifyStatusPass {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, result.Error())
+	}
+	return nil
+}
+
+// SelfCheckに使う鍵を公開鍵から作る
+func (o *SignerOptions) selfCheckKey(key crypto.Signer) (*domainkey.DomainKey, error) {
+	pub := o.PublicKey
+	if pub == nil {
+		pub = key.Public()
+	}
+	domainKey, err := domainkey.FromPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSelfCheckFailed, err)
+	}
+	return domainKey, nil
+}
@@ -0,0 +1,152 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+)
+
+var (
+	// ErrChainTooOld は最初のARC-Sealのタイムスタンプが許容される古さを超えている場合のエラー
+	ErrChainTooOld = errors.New("arc chain is too old")
+	// ErrChainNonMonotonic はARC-Sealのタイムスタンプがインスタンス順に増加していない場合のエラー
+	ErrChainNonMonotonic = errors.New("arc seal timestamps are not monotonic")
+)
+
+// ChainPolicy はARCチェーンのタイムスタンプに対する検査の設定
+// 古いチェーンや順序の崩れたチェーンは再送(リプレイ)されたものの可能性がある
+// t=のないARC-Sealは検査の対象外
+type ChainPolicy struct {
+	// MaxAge はi=1のARC-Sealのt=から許容される経過時間。0の場合は検査しない
+	MaxAge time.Duration
+	// RequireMonotonic がtrueの場合、t=がインスタンス番号の順に減少していればエラーとする
+	RequireMonotonic bool
+	// ClockSkew は中継サーバ間の時刻のずれとして許容する幅
+	ClockSkew time.Duration
+	// Now は現在時刻を返す関数。nilの場合はtime.Now
+	Now func() time.Time
+	// MissingKey は最後以外のインスタンスで鍵のレコードが存在しなかった場合のチェーンの扱い
+	MissingKey KeyErrorAction
+	// KeyLookupError は最後以外のインスタンスで鍵の問い合わせに失敗した場合のチェーンの扱い
+	KeyLookupError KeyErrorAction
+}
+
+// KeyErrorAction はARCの鍵を取得できなかったインスタンスをチェーンの検証でどう扱うか
+type KeyErrorAction string
+
+const (
+	// KeyErrorFail はチェーンの検証結果をfailにする(デフォルト)
+	KeyErrorFail KeyErrorAction = ""
+	// KeyErrorNeutral はインスタンスの検証結果をneutralにし、
+	// チェーンをfailではなくnone(評価できなかったもの)として扱う
+	KeyErrorNeutral KeyErrorAction = "neutral"
+)
+
+func (p *ChainPolicy) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// CheckChainPolicy はARC-Sealのタイムスタンプをポリシーに従って検査する
+// 違反がある場合はErrChainTooOldまたはErrChainNonMonotonicをラップしたエラーを返す
+func (s *Signatures) CheckChainPolicy(p *ChainPolicy) error {
+	if s == nil || p == nil {
+		return nil
+	}
+	max := s.GetMaxInstance()
+	if max == 0 {
+		return nil
+	}
+
+	if p.MaxAge > 0 {
+		if seal := s.GetInstance(1).GetARCSeal(); seal != nil && seal.Timestamp > 0 {
+			age := p.now().Sub(time.Unix(seal.Timestamp, 0))
+			if age > p.MaxAge+p.ClockSkew {
+				return fmt.Errorf("%w: i=1 t=%d age=%s", ErrChainTooOld, seal.Timestamp, age.Truncate(time.Second))
+			}
+		}
+	}
+
+	if p.RequireMonotonic {
+		var prev int64
+		prevInstance := 0
+		for i := 1; i <= max; i++ {
+			seal := s.GetInstance(i).GetARCSeal()
+			if seal == nil || seal.Timestamp == 0 {
+				continue
+			}
+			if prevInstance > 0 && time.Duration(prev-seal.Timestamp)*time.Second > p.ClockSkew {
+				return fmt.Errorf("%w: i=%d t=%d is earlier than i=%d t=%d",
+					ErrChainNonMonotonic, i, seal.Timestamp, prevInstance, prev)
+			}
+			prev = seal.Timestamp
+			prevInstance = i
+		}
+	}
+	return nil
+}
+
+// ApplyChainPolicy はCheckChainPolicyで違反があった場合に、
+// 最後のインスタンスの検証結果をfailにする
+// MissingKeyやKeyLookupErrorにKeyErrorNeutralが指定されている場合は、
+// 該当する最後以外のインスタンスの検証結果をneutralにする
+// 検証(Verify)の後に呼び出すことで、GetVerifyResultやGetARCChainValidationに違反が反映される
+func (s *Signatures) ApplyChainPolicy(p *ChainPolicy) error {
+	s.applyKeyErrorPolicy(p)
+	err := s.CheckChainPolicy(p)
+	if err == nil {
+		return nil
+	}
+	last := s.GetInstance(s.GetMaxInstance())
+	last.VerifyResult = &VerifyResult{
+		status: VerifyStatusFail,
+		err:    errcode.Wrap(errcode.ARCFailChain, err),
+		msg:    chainPolicyMessage(err),
+	}
+	return err
+}
+
+func chainPolicyMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrChainTooOld):
+		return "chain is too old"
+	case errors.Is(err, ErrChainNonMonotonic):
+		return "seal timestamps are not monotonic"
+	}
+	return err.Error()
+}
+
+// 鍵を取得できなかった最後以外のインスタンスの検証結果をポリシーに従って置き換える
+// 最後のインスタンスはチェーン全体を封印しているため対象にしない
+func (s *Signatures) applyKeyErrorPolicy(p *ChainPolicy) {
+	if s == nil || p == nil {
+		return
+	}
+	max := s.GetMaxInstance()
+	for i := 1; i < max; i++ {
+		sig := s.GetInstance(i)
+		v := sig.GetVerifyResult()
+		if v == nil {
+			continue
+		}
+		var action KeyErrorAction
+		switch {
+		case v.status == VerifyStatusPermErr && errors.Is(v.err, domainkey.ErrNoRecordFound):
+			action = p.MissingKey
+		case v.status == VerifyStatusTempErr:
+			action = p.KeyLookupError
+		}
+		if action == KeyErrorNeutral {
+			sig.VerifyResult = &VerifyResult{
+				status: VerifyStatusNeutral,
+				err:    v.err,
+				msg:    v.msg,
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+)
+
+func arcHeadersWithTimestamps(ts ...int64) []string {
+	var headers []string
+	for i, t := range ts {
+		cv := "pass"
+		if i == 0 {
+			cv = "none"
+		}
+		headers = append(headers,
+			fmt.Sprintf("ARC-Seal: i=%d; a=rsa-sha256; t=%d; cv=%s; d=example.com; s=selector; b=signature", i+1, t, cv),
+			fmt.Sprintf("ARC-Message-Signature: i=%d; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=selector; t=%d; h=from:to:subject; bh=bodyhash; b=signature", i+1, t),
+			fmt.Sprintf("ARC-Authentication-Results: i=%d; example.com; dkim=pass", i+1),
+		)
+	}
+	return headers
+}
+
+func TestCheckChainPolicy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	testCases := []struct {
+		name   string
+		ts     []int64
+		policy *ChainPolicy
+		expect error
+	}{
+		{
+			name:   "nil policy",
+			ts:     []int64{1, 2},
+			policy: nil,
+		},
+		{
+			name:   "within max age",
+			ts:     []int64{1700000000 - 3600, 1700000000 - 60},
+			policy: &ChainPolicy{MaxAge: 2 * time.Hour},
+		},
+		{
+			name:   "too old",
+			ts:     []int64{1700000000 - 3*86400, 1700000000 - 60},
+			policy: &ChainPolicy{MaxAge: 24 * time.Hour},
+			expect: ErrChainTooOld,
+		},
+		{
+			name:   "monotonic",
+			ts:     []int64{100, 100, 200},
+			policy: &ChainPolicy{RequireMonotonic: true},
+		},
+		{
+			name:   "non monotonic",
+			ts:     []int64{100, 200, 150},
+			policy: &ChainPolicy{RequireMonotonic: true},
+			expect: ErrChainNonMonotonic,
+		},
+		{
+			name:   "non monotonic within clock skew",
+			ts:     []int64{100, 200, 150},
+			policy: &ChainPolicy{RequireMonotonic: true, ClockSkew: time.Minute},
+		},
+		{
+			name:   "non monotonic not required",
+			ts:     []int64{100, 200, 150},
+			policy: &ChainPolicy{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sigs, err := ParseARCHeaders(arcHeadersWithTimestamps(tc.ts...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.policy != nil {
+				tc.policy.Now = func() time.Time { return now }
+			}
+			err = sigs.CheckChainPolicy(tc.policy)
+			if !errors.Is(err, tc.expect) {
+				t.Errorf("want %v, but got %v", tc.expect, err)
+			}
+		})
+	}
+}
+
+func TestApplyChainPolicy(t *testing.T) {
+	sigs, err := ParseARCHeaders(arcHeadersWithTimestamps(300, 200))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sigs.GetARCChainValidation(); got != ChainValidationResultPass {
+		t.Fatalf("want pass before policy, but got %s", got)
+	}
+	if err := sigs.ApplyChainPolicy(&ChainPolicy{RequireMonotonic: true}); !errors.Is(err, ErrChainNonMonotonic) {
+		t.Fatalf("want ErrChainNonMonotonic, but got %v", err)
+	}
+	if got := sigs.GetVerifyResult(); got != VerifyStatusFail {
+		t.Errorf("want fail, but got %s", got)
+	}
+	if got := sigs.GetARCChainValidation(); got != ChainValidationResultFail {
+		t.Errorf("want chain fail, but got %s", got)
+	}
+	if got := sigs.GetVerifyResultString(); got != "arc=fail (i=2 seal timestamps are not monotonic)" {
+		t.Errorf("unexpected result string: %s", got)
+	}
+}
+
+func TestApplyChainPolicyKeyErrors(t *testing.T) {
+	missing := &VerifyResult{status: VerifyStatusPermErr, err: fmt.Errorf("domain key is not found: %w", domainkey.ErrNoRecordFound), msg: "domain key is not found"}
+	lookupFailed := &VerifyResult{status: VerifyStatusTempErr, err: errors.New("timeout"), msg: "failed to lookup domain key"}
+	badKey := &VerifyResult{status: VerifyStatusPermErr, err: errors.New("invalid public key"), msg: "invalid public key"}
+	pass := &VerifyResult{status: VerifyStatusPass, msg: "good signature"}
+
+	testCases := []struct {
+		name    string
+		results []*VerifyResult
+		policy  *ChainPolicy
+		want    ChainValidationResult
+	}{
+		{
+			name:    "missing key fails by default",
+			results: []*VerifyResult{missing, pass, pass},
+			policy:  &ChainPolicy{},
+			want:    ChainValidationResultFail,
+		},
+		{
+			name:    "missing intermediate key neutral",
+			results: []*VerifyResult{missing, pass, pass},
+			policy:  &ChainPolicy{MissingKey: KeyErrorNeutral},
+			want:    ChainValidationResultNone,
+		},
+		{
+			name:    "missing key on newest instance still fails",
+			results: []*VerifyResult{pass, pass, missing},
+			policy:  &ChainPolicy{MissingKey: KeyErrorNeutral},
+			want:    ChainValidationResultFail,
+		},
+		{
+			name:    "lookup error not covered by missing key policy",
+			results: []*VerifyResult{pass, lookupFailed, pass},
+			policy:  &ChainPolicy{MissingKey: KeyErrorNeutral},
+			want:    ChainValidationResultFail,
+		},
+		{
+			name:    "lookup error neutral",
+			results: []*VerifyResult{pass, lookupFailed, pass},
+			policy:  &ChainPolicy{KeyLookupError: KeyErrorNeutral},
+			want:    ChainValidationResultNone,
+		},
+		{
+			name:    "invalid key is not a missing key",
+			results: []*VerifyResult{badKey, pass, pass},
+			policy:  &ChainPolicy{MissingKey: KeyErrorNeutral, KeyLookupError: KeyErrorNeutral},
+			want:    ChainValidationResultFail,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sigs, err := ParseARCHeaders(arcHeadersWithTimestamps(100, 200, 300))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i, r := range tc.results {
+				sigs.GetInstance(i + 1).VerifyResult = r
+			}
+			if err := sigs.ApplyChainPolicy(tc.policy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := sigs.GetARCChainValidation(); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/masa23/mmauth/authres"
+	"github.com/masa23/mmauth/headerfold"
 	"github.com/masa23/mmauth/internal/header"
 )
 
@@ -17,6 +19,20 @@ type ARCAuthenticationResults struct {
 	raw            string
 }
 
+// NewARCAuthenticationResults は認証結果からARC-Authentication-Resultsを作る
+// resultsはorderの順に並べ替えるため、同じ結果からは常に同じヘッダになる
+// orderがnilの場合はauthres.DefaultOrdering
+func NewARCAuthenticationResults(instance int, authservID string, results []*authres.ResultInfo, order *authres.Ordering) *ARCAuthenticationResults {
+	aar := &ARCAuthenticationResults{
+		InstanceNumber: instance,
+		AuthServId:     authservID,
+	}
+	for _, ri := range order.Sort(results) {
+		aar.Results = append(aar.Results, ri.String())
+	}
+	return aar
+}
+
 func (aar *ARCAuthenticationResults) Raw() string {
 	if aar.raw == "" {
 		return aar.String()
@@ -26,12 +42,18 @@ func (aar *ARCAuthenticationResults) Raw() string {
 
 // ARC-Authentication-Results の文字列化
 // ヘッダ名は含まない
+// 結果ごとに改行し、1行に収まらない結果は空白の位置で折り返す
 func (aar ARCAuthenticationResults) String() string {
-	header := fmt.Sprintf("i=%d; %s;", aar.InstanceNumber, aar.AuthServId)
+	f := headerfold.NewFieldFolder("ARC-Authentication-Results", nil)
+	f.Word(fmt.Sprintf("i=%d;", aar.InstanceNumber))
+	f.Word(aar.AuthServId + ";")
 	for _, result := range aar.Results {
-		header += fmt.Sprintf("\r\n        %s;", result)
+		f.Newline()
+		for _, w := range headerfold.Fields(result + ";") {
+			f.Word(w)
+		}
 	}
-	return header
+	return f.Value()
 }
 
 // ARC-Authentication-Results のパース
@@ -44,7 +66,7 @@ func ParseARCAuthenticationResults(s string) (*ARCAuthenticationResults, error)
 	if !strings.EqualFold(k, "arc-authentication-results") {
 		return nil, fmt.Errorf("invalid header field")
 	}
-	fields := strings.Split(v, ";")
+	fields := authres.SplitResultInfos(v)
 
 	for i, field := range fields {
 		keyValue := strings.SplitN(strings.TrimSpace(field), "=", 2)
@@ -75,3 +97,46 @@ func ParseARCAuthenticationResults(s string) (*ARCAuthenticationResults, error)
 
 	return result, nil
 }
+
+// ResultInfos はResultsを解析したresinfoを返す
+// 解析できない結果は含まない
+func (aar *ARCAuthenticationResults) ResultInfos() []*authres.ResultInfo {
+	var infos []*authres.ResultInfo
+	for _, r := range aar.Results {
+		ri, err := authres.ParseResultInfo(r)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, ri)
+	}
+	return infos
+}
+
+// AuthenticationResults はAuthentication-Resultsと共通の構造で結果を返す
+// インスタンス番号はInstanceNumberで参照する
+func (aar *ARCAuthenticationResults) AuthenticationResults() *authres.AuthenticationResults {
+	return &authres.AuthenticationResults{
+		AuthServID: strings.ToLower(aar.AuthServId),
+		Results:    aar.ResultInfos(),
+	}
+}
+
+// GetDKIMResults はdkimの結果を返す
+func (aar *ARCAuthenticationResults) GetDKIMResults() []*authres.ResultInfo {
+	return aar.AuthenticationResults().GetDKIMResults()
+}
+
+// GetSPFResults はspfの結果を返す
+func (aar *ARCAuthenticationResults) GetSPFResults() []*authres.ResultInfo {
+	return aar.AuthenticationResults().GetSPFResults()
+}
+
+// GetDMARCResults はdmarcの結果を返す
+func (aar *ARCAuthenticationResults) GetDMARCResults() []*authres.ResultInfo {
+	return aar.AuthenticationResults().GetDMARCResults()
+}
+
+// GetARCResults はarcの結果を返す
+func (aar *ARCAuthenticationResults) GetARCResults() []*authres.ResultInfo {
+	return aar.AuthenticationResults().GetARCResults()
+}
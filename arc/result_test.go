@@ -0,0 +1,102 @@
+package arc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/masa23/mmauth/authres"
+	"github.com/masa23/mmauth/headerfold"
+)
+
+func TestARCAuthenticationResultsResultInfos(t *testing.T) {
+	aar, err := ParseARCAuthenticationResults("ARC-Authentication-Results: i=2; mx.example.jp;\r\n" +
+		"\tdkim=pass (sig; ok) header.d=example.com header.s=sel;\r\n" +
+		"\tspf=softfail smtp.mailfrom=example.com;\r\n" +
+		"\tarc=pass (i=1) smtp.remote-ip=192.0.2.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aar.InstanceNumber != 2 {
+		t.Errorf("want instance 2, but got %d", aar.InstanceNumber)
+	}
+	ar := aar.AuthenticationResults()
+	if ar.AuthServID != "mx.example.jp" {
+		t.Errorf("want mx.example.jp, but got %s", ar.AuthServID)
+	}
+
+	testCases := []struct {
+		name     string
+		results  []*authres.ResultInfo
+		want     authres.Result
+		ptype    authres.PropertyType
+		property string
+		value    string
+	}{
+		{name: "dkim", results: aar.GetDKIMResults(), want: authres.ResultPass, ptype: authres.PropertyTypeHeader, property: "d", value: "example.com"},
+		{name: "spf", results: aar.GetSPFResults(), want: authres.ResultSoftFail, ptype: authres.PropertyTypeSMTP, property: "mailfrom", value: "example.com"},
+		{name: "arc", results: aar.GetARCResults(), want: authres.ResultPass, ptype: authres.PropertyTypeSMTP, property: "remote-ip", value: "192.0.2.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.results) != 1 {
+				t.Fatalf("want 1 result, but got %d", len(tc.results))
+			}
+			if tc.results[0].Result != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, tc.results[0].Result)
+			}
+			if v, _ := tc.results[0].Property(tc.ptype, tc.property); v != tc.value {
+				t.Errorf("want %s.%s=%s, but got %q", tc.ptype, tc.property, tc.value, v)
+			}
+		})
+	}
+	if len(aar.GetDMARCResults()) != 0 {
+		t.Errorf("want no dmarc results")
+	}
+}
+
+func TestNewARCAuthenticationResults(t *testing.T) {
+	results := []*authres.ResultInfo{
+		{Method: authres.MethodDMARC, Result: authres.ResultPass},
+		(&authres.ResultInfo{Method: authres.MethodSPF, Result: authres.ResultPass}).
+			AddProperty(authres.PropertyTypeSMTP, "mailfrom", "example.com"),
+	}
+	aar := NewARCAuthenticationResults(1, "mx.example.jp", results, nil)
+	want := "i=1; mx.example.jp;\r\n        spf=pass smtp.mailfrom=example.com;\r\n        dmarc=pass;"
+	if got := aar.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	if got := aar.Raw(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestARCAuthenticationResultsStringFolds(t *testing.T) {
+	ri := &authres.ResultInfo{Method: authres.MethodDKIM, Result: authres.ResultPass, Comment: "good signature"}
+	ri.AddProperty(authres.PropertyTypeHeader, "d", "mail.subdomain.example.com").
+		AddProperty(authres.PropertyTypeHeader, "i", "@mail.subdomain.example.com").
+		AddProperty(authres.PropertyTypeHeader, "s", "selector-2024-long").
+		AddProperty(authres.PropertyTypeHeader, "b", "abcdefgh")
+	aar := NewARCAuthenticationResults(1, "mx.example.jp", []*authres.ResultInfo{ri}, nil)
+
+	got := aar.String()
+	want := "i=1; mx.example.jp;\r\n" +
+		"        dkim=pass (good signature) header.d=mail.subdomain.example.com\r\n" +
+		"        header.i=@mail.subdomain.example.com header.s=selector-2024-long\r\n" +
+		"        header.b=abcdefgh;"
+	if got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	for _, line := range strings.Split("ARC-Authentication-Results: "+got, "\r\n") {
+		if len(line) > headerfold.SoftLimit {
+			t.Errorf("want at most %d characters, but got %q", headerfold.SoftLimit, line)
+		}
+	}
+	parsed, err := ParseARCAuthenticationResults("ARC-Authentication-Results: " + got + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	infos := parsed.ResultInfos()
+	if len(infos) != 1 || infos[0].String() != ri.String() {
+		t.Errorf("want %s, but got %v", ri, infos)
+	}
+}
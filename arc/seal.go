@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/headerfold"
 	"github.com/masa23/mmauth/internal/canonical"
 	"github.com/masa23/mmauth/internal/header"
 )
@@ -38,21 +40,20 @@ func (as *ARCSeal) Raw() string {
 // ARC-Seal の文字列化
 // ヘッダ名は含まない
 func (as ARCSeal) String() string {
-	return fmt.Sprintf("i=%d; a=%s; t=%d; cv=%s;\r\n"+
-		"        d=%s; s=%s;\r\n"+
-		"        b=%s",
-		as.InstanceNumber, as.Algorithm, as.Timestamp, as.ChainValidation, as.Domain, as.Selector,
-		header.WrapSignatureWithBreaks(as.Signature),
-	)
+	return as.StringWithoutSignature() + headerfold.WrapSignatureWithBreaks(as.Signature)
 }
 
 // StringWithoutSignature returns the ARC-Seal header string with an empty signature
 func (as ARCSeal) StringWithoutSignature() string {
-	return fmt.Sprintf("i=%d; a=%s; t=%d; cv=%s;\r\n"+
-		"        d=%s; s=%s;\r\n"+
-		"        b=",
-		as.InstanceNumber, as.Algorithm, as.Timestamp, as.ChainValidation, as.Domain, as.Selector,
-	)
+	f := headerfold.NewFieldFolder("ARC-Seal", nil)
+	f.Word(fmt.Sprintf("i=%d;", as.InstanceNumber))
+	f.Word(fmt.Sprintf("a=%s;", as.Algorithm))
+	f.Word(fmt.Sprintf("t=%d;", as.Timestamp))
+	f.Word(fmt.Sprintf("cv=%s;", as.ChainValidation))
+	f.Newline()
+	f.Word(fmt.Sprintf("d=%s;", as.Domain))
+	f.Word(fmt.Sprintf("s=%s;", as.Selector))
+	return f.Value() + "\r\n        b="
 }
 
 // ARC-Seal のパース
@@ -121,10 +122,11 @@ func ParseARCSeal(s string) (*ARCSeal, error) {
 			}
 			result.Timestamp = timestamp
 		case "cv":
-			if !isChainValidationResult(value) {
+			cv, err := ParseChainValidation(value)
+			if err != nil {
 				return nil, fmt.Errorf("invalid chain validation result")
 			}
-			result.ChainValidation = ChainValidationResult(value)
+			result.ChainValidation = cv
 		}
 	}
 	result.hashAlgo = hashAlgo(result.Algorithm)
@@ -133,6 +135,7 @@ func ParseARCSeal(s string) (*ARCSeal, error) {
 }
 
 // ARC-Seal の署名
+// cv=fail以外の場合は既存のチェーンの構造を確認し、不正であればErrInvalidChainを返す
 func (as *ARCSeal) Sign(headers []string, key crypto.Signer) error {
 	// timestampを設定
 	if as.Timestamp == 0 {
@@ -157,30 +160,30 @@ func (as *ARCSeal) Sign(headers []string, key crypto.Signer) error {
 	extractedHeaders := header.ExtractHeadersAll(headers, []string{"ARC-Authentication-Results", "ARC-Message-Signature", "ARC-Seal"})
 
 	// 既存のARCヘッダをパースして、署名対象の順序で並べ替える
-	ah, err := parseARCHeaders(extractedHeaders)
+	instances, duplicates, err := extractInstances(extractedHeaders)
 	if err != nil {
 		return err
 	}
+	if as.ChainValidation != ChainValidationResultFail {
+		if err := checkChainForSealing(instances, duplicates, as.InstanceNumber, as.ChainValidation); err != nil {
+			return err
+		}
+	}
 
 	var sortedHeaders []string
 	for i := 1; i < as.InstanceNumber; i++ {
-		arc := ah.getInstance(i)
-		// 既存インスタンスは AAR/AMS/AS が揃っている必要がある
-		if arc.arcAuthenticationResults == nil || arc.arcMessageSignature == nil || arc.arcSeal == nil {
-			return fmt.Errorf("missing ARC headers for instance %d", i)
-		}
-		sortedHeaders = append(sortedHeaders, arc.arcAuthenticationResults.raw)
-		sortedHeaders = append(sortedHeaders, arc.arcMessageSignature.raw)
-		sortedHeaders = append(sortedHeaders, arc.arcSeal.raw)
+		// cv=failの場合は壊れたチェーンでも存在するヘッダのみで署名する
+		arc := findInstance(instances, i)
+		sortedHeaders = append(sortedHeaders, arc.Headers()...)
 	}
 
 	// 現在インスタンスは AAR/AMS が必須（AS は placeholder を使う）
-	cur := ah.getInstance(as.InstanceNumber)
-	if cur.arcAuthenticationResults == nil || cur.arcMessageSignature == nil {
+	cur := findInstance(instances, as.InstanceNumber)
+	if cur.AuthenticationResults == nil || cur.MessageSignature == nil {
 		return fmt.Errorf("missing ARC headers for instance %d", as.InstanceNumber)
 	}
-	sortedHeaders = append(sortedHeaders, cur.arcAuthenticationResults.raw)
-	sortedHeaders = append(sortedHeaders, cur.arcMessageSignature.raw)
+	sortedHeaders = append(sortedHeaders, cur.AuthenticationResults.raw)
+	sortedHeaders = append(sortedHeaders, cur.MessageSignature.raw)
 
 	// 自分の ARC-Seal を署名対象に含める際は、b= を空にした placeholder を追加
 	placeholder := "ARC-Seal: " + as.StringWithoutSignature() + "\r\n"
@@ -190,7 +193,7 @@ func (as *ARCSeal) Sign(headers []string, key crypto.Signer) error {
 	// we add to the signing set is also CRLF-terminated so header canonicalization
 	// behaves consistently (especially for simple header canonicalization).
 	// RFC 6376 §3.7 (applied by ARC): the signature header field itself is hashed without a trailing CRLF.
-	signature, err := header.SignerWithOmitLastCRLF(sortedHeaders, key, canonical.Canonicalization(canonical.Relaxed), as.hashAlgo, true)
+	signature, err := header.SignerWithOmitLastCRLF(sortedHeaders, key, canonical.Relaxed, as.hashAlgo, true)
 	if err != nil {
 		return err
 	}
@@ -198,13 +201,56 @@ func (as *ARCSeal) Sign(headers []string, key crypto.Signer) error {
 	return nil
 }
 
+// ErrInvalidChain は既存のARCチェーンの構造が不正で、cv=fail以外では封をできない場合のエラー
+var ErrInvalidChain = errors.New("arc: invalid chain for sealing")
+
+// checkChainForSealing はインスタンスnのARC-Sealを付ける前に既存のチェーンの構造を確認する (RFC 8617 5.1.2)
+// インスタンス番号の欠落や重複、前のインスタンスのcv=がcvと矛盾する場合はErrInvalidChainを返す
+func checkChainForSealing(instances []Instance, duplicates []int, n int, cv ChainValidationResult) error {
+	if n < 1 || n > MaxInstance {
+		return fmt.Errorf("%w: instance number %d is out of range", ErrInvalidChain, n)
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w: duplicate headers for instance %d", ErrInvalidChain, duplicates[0])
+	}
+	// i=1はcv=none、それ以降はcv=passのみ
+	if n == 1 && cv != ChainValidationResultNone {
+		return fmt.Errorf("%w: cv=%s is not allowed for instance 1", ErrInvalidChain, cv)
+	}
+	if n > 1 && cv != ChainValidationResultPass {
+		return fmt.Errorf("%w: cv=%s is not allowed for instance %d", ErrInvalidChain, cv, n)
+	}
+	for _, in := range instances {
+		switch {
+		case in.Number < 1 || in.Number > n:
+			return fmt.Errorf("%w: unexpected instance %d", ErrInvalidChain, in.Number)
+		case in.Number == n && in.Seal != nil:
+			return fmt.Errorf("%w: instance %d is already sealed", ErrInvalidChain, n)
+		}
+	}
+	for i := 1; i < n; i++ {
+		in := findInstance(instances, i)
+		if !in.Complete() {
+			return fmt.Errorf("%w: missing ARC headers for instance %d", ErrInvalidChain, i)
+		}
+		want := ChainValidationResultPass
+		if i == 1 {
+			want = ChainValidationResultNone
+		}
+		if in.Seal.ChainValidation != want {
+			return fmt.Errorf("%w: instance %d has cv=%s", ErrInvalidChain, i, in.Seal.ChainValidation)
+		}
+	}
+	return nil
+}
+
 // ARC-Seal の検証
 func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *VerifyResult {
 	// cv=fail の場合は即座に fail を返す
 	if as.ChainValidation == ChainValidationResultFail {
 		return &VerifyResult{
 			status:    VerifyStatusFail,
-			err:       fmt.Errorf("chain validation result is fail"),
+			err:       errcode.New(errcode.ARCFailChain, "chain validation result is fail"),
 			msg:       "chain validation result is fail",
 			domainKey: domainKey,
 		}
@@ -216,13 +262,13 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 		if errors.Is(err, domainkey.ErrNoRecordFound) {
 			return &VerifyResult{
 				status: VerifyStatusPermErr,
-				err:    fmt.Errorf("domain key is not found: %v", err),
+				err:    errcode.Errorf(errcode.ARCPermErrorKeyNotFound, "domain key is not found: %v", err),
 				msg:    "domain key is not found",
 			}
 		} else if err != nil {
 			return &VerifyResult{
 				status: VerifyStatusTempErr,
-				err:    fmt.Errorf("failed to lookup domain key: %v", err),
+				err:    errcode.Errorf(errcode.ARCTempErrorKeyLookup, "failed to lookup domain key: %v", err),
 				msg:    "failed to lookup domain key",
 			}
 		}
@@ -232,7 +278,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 	if as.raw == "" {
 		return &VerifyResult{
 			status:    VerifyStatusNeutral,
-			err:       fmt.Errorf("arc seal is not found"),
+			err:       errcode.New(errcode.ARCNeutralNoSignature, "arc seal is not found"),
 			msg:       "seal is not found",
 			domainKey: domainKey,
 		}
@@ -259,7 +305,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 	if err != nil {
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to decode arc-seal signature: %v", err),
+			err:       errcode.Errorf(errcode.ARCPermErrorHeader, "failed to decode arc-seal signature: %v", err),
 			msg:       "invalid signature",
 			domainKey: domainKey,
 		}
@@ -270,7 +316,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 	if err != nil {
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to decode domainkey public key: %v", err),
+			err:       errcode.Errorf(errcode.ARCPermErrorKeyInvalid, "failed to decode domainkey public key: %v", err),
 			msg:       "invalid public key",
 			domainKey: domainKey,
 		}
@@ -281,7 +327,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 	if err != nil {
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to parse domainkey public key: %v", err),
+			err:       errcode.Errorf(errcode.ARCPermErrorKeyInvalid, "failed to parse domainkey public key: %v", err),
 			msg:       "invalid public key",
 			domainKey: domainKey,
 		}
@@ -292,7 +338,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 		if err := rsa.VerifyPKCS1v15(pub, as.hashAlgo, hash.Sum(nil), signature); err != nil {
 			return &VerifyResult{
 				status:    VerifyStatusFail,
-				err:       fmt.Errorf("failed to verify arc-seal signature"),
+				err:       errcode.New(errcode.ARCFailSignature, "failed to verify arc-seal signature"),
 				msg:       "invalid signature",
 				domainKey: domainKey,
 			}
@@ -301,7 +347,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 		if !ed25519.Verify(pub, hash.Sum(nil), signature) {
 			return &VerifyResult{
 				status:    VerifyStatusFail,
-				err:       fmt.Errorf("failed to verify arc-seal signature"),
+				err:       errcode.New(errcode.ARCFailSignature, "failed to verify arc-seal signature"),
 				msg:       "invalid signature",
 				domainKey: domainKey,
 			}
@@ -309,7 +355,7 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 	default:
 		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to convert arc-seal public key to rsa or ed25519"),
+			err:       errcode.New(errcode.ARCPermErrorKeyInvalid, "failed to convert arc-seal public key to rsa or ed25519"),
 			msg:       "invalid public key",
 			domainKey: domainKey,
 		}
@@ -327,78 +373,14 @@ func (as *ARCSeal) Verify(headers []string, domainKey *domainkey.DomainKey) *Ver
 // ARCヘッダをSealで署名する順番にソートする
 func arcHeaderSort(h []string) []string {
 	var ret []string
-	ah, err := parseARCHeaders(h)
+	instances, err := ExtractInstances(h)
 	if err != nil {
 		return ret
 	}
-
-	max := ah.getMaxInstance()
-
-	for i := 1; i <= max; i++ {
-		arc := ah.getInstance(i)
-		if arc.arcAuthenticationResults != nil && arc.arcMessageSignature != nil && arc.arcSeal != nil {
-			ret = append(ret, arc.arcAuthenticationResults.raw)
-			ret = append(ret, arc.arcMessageSignature.raw)
-			ret = append(ret, arc.arcSeal.raw)
+	for _, arc := range instances {
+		if arc.Complete() {
+			ret = append(ret, arc.Headers()...)
 		}
 	}
 	return ret
 }
-
-type signatures []*Signature
-
-func (s *signatures) getInstance(i int) *Signature {
-	for _, sig := range *s {
-		if sig.instanceNumber == i {
-			return sig
-		}
-	}
-	sig := &Signature{
-		instanceNumber: i,
-	}
-	*s = append(*s, sig)
-	return sig
-}
-
-func (s *signatures) getMaxInstance() int {
-	max := 0
-	for _, sig := range *s {
-		if sig.instanceNumber > max {
-			max = sig.instanceNumber
-		}
-	}
-	return max
-}
-
-func parseARCHeaders(headers []string) (*signatures, error) {
-	var sigs signatures
-
-	for _, h := range headers {
-		k, _ := header.ParseHeaderField(h)
-		switch strings.ToLower(k) {
-		case "arc-seal":
-			ret, err := ParseARCSeal(h)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse arc-seal: %v", err)
-			}
-			as := sigs.getInstance(ret.InstanceNumber)
-			as.arcSeal = ret
-		case "arc-authentication-results":
-			ret, err := ParseARCAuthenticationResults(h)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse arc-authentication-results: %v", err)
-			}
-			as := sigs.getInstance(ret.InstanceNumber)
-			as.arcAuthenticationResults = ret
-		case "arc-message-signature":
-			ret, err := ParseARCMessageSignature(h)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse arc-message-signature: %v", err)
-			}
-			as := sigs.getInstance(ret.InstanceNumber)
-			as.arcMessageSignature = ret
-		}
-	}
-
-	return &sigs, nil
-}
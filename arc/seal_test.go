@@ -2,6 +2,7 @@ package arc
 
 import (
 	"crypto"
+	"reflect"
 	"testing"
 
 	"github.com/masa23/mmauth/domainkey"
@@ -174,12 +175,12 @@ func Test_arcHeaderSort(t *testing.T) {
 			name: "test1",
 			input: []string{
 				"ARC-Authentication-Results: i=1;\r\n",
-				"ARC-Message-Signature: i=1;\r\n",
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=1;\r\n",
 			},
 			want: []string{
 				"ARC-Authentication-Results: i=1;\r\n",
-				"ARC-Message-Signature: i=1;\r\n",
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=1;\r\n",
 			},
 		},
@@ -187,18 +188,18 @@ func Test_arcHeaderSort(t *testing.T) {
 			name: "test2",
 			input: []string{
 				"ARC-Authentication-Results: i=1;\r\n",
-				"ARC-Message-Signature: i=1;\r\n",
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=1;\r\n",
 				"ARC-Authentication-Results: i=2;\r\n",
-				"ARC-Message-Signature: i=2;\r\n",
+				"ARC-Message-Signature: i=2; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=2;\r\n",
 			},
 			want: []string{
 				"ARC-Authentication-Results: i=1;\r\n",
-				"ARC-Message-Signature: i=1;\r\n",
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=1;\r\n",
 				"ARC-Authentication-Results: i=2;\r\n",
-				"ARC-Message-Signature: i=2;\r\n",
+				"ARC-Message-Signature: i=2; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=2;\r\n",
 			},
 		},
@@ -206,8 +207,8 @@ func Test_arcHeaderSort(t *testing.T) {
 			name: "test3",
 			input: []string{
 				"ARC-Seal: i=1;\r\n",
-				"ARC-Message-Signature: i=2;\r\n",
-				"ARC-Message-Signature: i=1;\r\n",
+				"ARC-Message-Signature: i=2; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Authentication-Results: i=1;\r\n",
 				"ARC-Seal: i=1;\r\n",
 				"ARC-Authentication-Results: i=2;\r\n",
@@ -215,10 +216,10 @@ func Test_arcHeaderSort(t *testing.T) {
 			},
 			want: []string{
 				"ARC-Authentication-Results: i=1;\r\n",
-				"ARC-Message-Signature: i=1;\r\n",
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=1;\r\n",
 				"ARC-Authentication-Results: i=2;\r\n",
-				"ARC-Message-Signature: i=2;\r\n",
+				"ARC-Message-Signature: i=2; a=rsa-sha256; d=example.com; s=selector; h=from; bh=aGFzaA==; b=c2ln;\r\n",
 				"ARC-Seal: i=2;\r\n",
 			},
 		},
@@ -311,11 +312,11 @@ func TestARCSealVerify(t *testing.T) {
 	}
 }
 
-func Test_parseARCHeader(t *testing.T) {
+func TestExtractInstances(t *testing.T) {
 	testCases := []struct {
 		name   string
 		input  []string
-		expect signatures
+		expect []Instance
 	}{
 		{
 			name: "arc-seal",
@@ -330,10 +331,10 @@ func Test_parseARCHeader(t *testing.T) {
 				"ARC-Authentication-Results: i=2; example.com ; arc=pass; spf=pass",
 				"ARC-Authentication-Results: i=3; example.com ; arc=pass; dmarc=pass",
 			},
-			expect: signatures{
+			expect: []Instance{
 				{
-					instanceNumber: 1,
-					arcSeal: &ARCSeal{
+					Number: 1,
+					Seal: &ARCSeal{
 						InstanceNumber:  1,
 						Algorithm:       SignatureAlgorithmRSA_SHA256,
 						Timestamp:       1617220000,
@@ -343,13 +344,13 @@ func Test_parseARCHeader(t *testing.T) {
 						Signature:       "signature1",
 						raw:             "ARC-Seal: i=1; a=rsa-sha256; t=1617220000; cv=pass; d=example.com; s=selector; b=signature1",
 					},
-					arcAuthenticationResults: &ARCAuthenticationResults{
+					AuthenticationResults: &ARCAuthenticationResults{
 						InstanceNumber: 1,
 						AuthServId:     "example.com",
 						Results:        []string{"arc=pass", "dkim=pass"},
 						raw:            "ARC-Authentication-Results: i=1; example.com; arc=pass; dkim=pass",
 					},
-					arcMessageSignature: &ARCMessageSignature{
+					MessageSignature: &ARCMessageSignature{
 						InstanceNumber:   1,
 						Algorithm:        SignatureAlgorithmRSA_SHA256,
 						Canonicalization: "relaxed/relaxed",
@@ -363,8 +364,8 @@ func Test_parseARCHeader(t *testing.T) {
 					},
 				},
 				{
-					instanceNumber: 2,
-					arcSeal: &ARCSeal{
+					Number: 2,
+					Seal: &ARCSeal{
 						InstanceNumber:  2,
 						Algorithm:       SignatureAlgorithmRSA_SHA256,
 						Timestamp:       1617220000,
@@ -374,13 +375,13 @@ func Test_parseARCHeader(t *testing.T) {
 						Signature:       "signature2",
 						raw:             "ARC-Seal: i=2; a=rsa-sha256; t=1617220000; cv=pass; d=example.com; s=selector; b=signature2",
 					},
-					arcAuthenticationResults: &ARCAuthenticationResults{
+					AuthenticationResults: &ARCAuthenticationResults{
 						InstanceNumber: 2,
 						AuthServId:     "example.com",
 						Results:        []string{"arc=pass", "spf=pass"},
 						raw:            "ARC-Authentication-Results: i=2; example.com ; arc=pass; spf=pass",
 					},
-					arcMessageSignature: &ARCMessageSignature{
+					MessageSignature: &ARCMessageSignature{
 						InstanceNumber:   2,
 						Algorithm:        SignatureAlgorithmRSA_SHA256,
 						Canonicalization: "relaxed/relaxed",
@@ -394,8 +395,8 @@ func Test_parseARCHeader(t *testing.T) {
 					},
 				},
 				{
-					instanceNumber: 3,
-					arcSeal: &ARCSeal{
+					Number: 3,
+					Seal: &ARCSeal{
 						InstanceNumber:  3,
 						Algorithm:       SignatureAlgorithmRSA_SHA1,
 						Timestamp:       1617220000,
@@ -405,13 +406,13 @@ func Test_parseARCHeader(t *testing.T) {
 						Signature:       "signature3",
 						raw:             "ARC-Seal: i=3; a=rsa-sha1; t=1617220000; cv=pass; d=example.com; s=selector; b=signature3",
 					},
-					arcAuthenticationResults: &ARCAuthenticationResults{
+					AuthenticationResults: &ARCAuthenticationResults{
 						InstanceNumber: 3,
 						AuthServId:     "example.com",
 						Results:        []string{"arc=pass", "dmarc=pass"},
 						raw:            "ARC-Authentication-Results: i=3; example.com ; arc=pass; dmarc=pass",
 					},
-					arcMessageSignature: &ARCMessageSignature{
+					MessageSignature: &ARCMessageSignature{
 						InstanceNumber:   3,
 						Algorithm:        SignatureAlgorithmRSA_SHA256,
 						Canonicalization: "relaxed/relaxed",
@@ -430,86 +431,118 @@ func Test_parseARCHeader(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := parseARCHeaders(tc.input)
+			got, err := ExtractInstances(tc.input)
 			if err != nil {
 				t.Errorf("unexpected error: %v", err)
 			}
-			if len(*got) != len(tc.expect) {
-				t.Errorf("unexpected result: got=%d, expect=%d", len(*got), len(tc.expect))
+			if len(got) != len(tc.expect) {
+				t.Errorf("unexpected result: got=%d, expect=%d", len(got), len(tc.expect))
 			}
-			for i, v := range *got {
-				if v.instanceNumber != tc.expect[i].instanceNumber {
-					t.Errorf("unexpected result: *got=%d, expect=%d", v.instanceNumber, tc.expect[i].instanceNumber)
+			for i, v := range got {
+				if v.Number != tc.expect[i].Number {
+					t.Errorf("unexpected result: *got=%d, expect=%d", v.Number, tc.expect[i].Number)
 				}
-				if v.arcSeal.InstanceNumber != tc.expect[i].arcSeal.InstanceNumber {
-					t.Errorf("unexpected result: *got=%d, expect=%d", v.arcSeal.InstanceNumber, tc.expect[i].arcSeal.InstanceNumber)
+				if v.Seal.InstanceNumber != tc.expect[i].Seal.InstanceNumber {
+					t.Errorf("unexpected result: *got=%d, expect=%d", v.Seal.InstanceNumber, tc.expect[i].Seal.InstanceNumber)
 				}
-				if v.arcSeal.Algorithm != tc.expect[i].arcSeal.Algorithm {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcSeal.Algorithm, tc.expect[i].arcSeal.Algorithm)
+				if v.Seal.Algorithm != tc.expect[i].Seal.Algorithm {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.Seal.Algorithm, tc.expect[i].Seal.Algorithm)
 				}
-				if v.arcSeal.Timestamp != tc.expect[i].arcSeal.Timestamp {
-					t.Errorf("unexpected result: *got=%d, expect=%d", v.arcSeal.Timestamp, tc.expect[i].arcSeal.Timestamp)
+				if v.Seal.Timestamp != tc.expect[i].Seal.Timestamp {
+					t.Errorf("unexpected result: *got=%d, expect=%d", v.Seal.Timestamp, tc.expect[i].Seal.Timestamp)
 				}
-				if v.arcSeal.ChainValidation != tc.expect[i].arcSeal.ChainValidation {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcSeal.ChainValidation, tc.expect[i].arcSeal.ChainValidation)
+				if v.Seal.ChainValidation != tc.expect[i].Seal.ChainValidation {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.Seal.ChainValidation, tc.expect[i].Seal.ChainValidation)
 				}
-				if v.arcSeal.Domain != tc.expect[i].arcSeal.Domain {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcSeal.Domain, tc.expect[i].arcSeal.Domain)
+				if v.Seal.Domain != tc.expect[i].Seal.Domain {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.Seal.Domain, tc.expect[i].Seal.Domain)
 				}
-				if v.arcSeal.Selector != tc.expect[i].arcSeal.Selector {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcSeal.Selector, tc.expect[i].arcSeal.Selector)
+				if v.Seal.Selector != tc.expect[i].Seal.Selector {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.Seal.Selector, tc.expect[i].Seal.Selector)
 				}
-				if v.arcSeal.Signature != tc.expect[i].arcSeal.Signature {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcSeal.Signature, tc.expect[i].arcSeal.Signature)
+				if v.Seal.Signature != tc.expect[i].Seal.Signature {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.Seal.Signature, tc.expect[i].Seal.Signature)
 				}
-				if v.arcSeal.raw != tc.expect[i].arcSeal.raw {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcSeal.raw, tc.expect[i].arcSeal.raw)
+				if v.Seal.raw != tc.expect[i].Seal.raw {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.Seal.raw, tc.expect[i].Seal.raw)
 				}
-				if v.arcAuthenticationResults.InstanceNumber != tc.expect[i].arcAuthenticationResults.InstanceNumber {
-					t.Errorf("unexpected result: *got=%d, expect=%d", v.arcAuthenticationResults.InstanceNumber, tc.expect[i].arcAuthenticationResults.InstanceNumber)
+				if v.AuthenticationResults.InstanceNumber != tc.expect[i].AuthenticationResults.InstanceNumber {
+					t.Errorf("unexpected result: *got=%d, expect=%d", v.AuthenticationResults.InstanceNumber, tc.expect[i].AuthenticationResults.InstanceNumber)
 				}
-				if v.arcAuthenticationResults.AuthServId != tc.expect[i].arcAuthenticationResults.AuthServId {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcAuthenticationResults.AuthServId, tc.expect[i].arcAuthenticationResults.AuthServId)
+				if v.AuthenticationResults.AuthServId != tc.expect[i].AuthenticationResults.AuthServId {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.AuthenticationResults.AuthServId, tc.expect[i].AuthenticationResults.AuthServId)
 				}
-				for j, r := range v.arcAuthenticationResults.Results {
-					if r != tc.expect[i].arcAuthenticationResults.Results[j] {
-						t.Errorf("unexpected result: *got=%s, expect=%s", r, tc.expect[i].arcAuthenticationResults.Results[j])
+				for j, r := range v.AuthenticationResults.Results {
+					if r != tc.expect[i].AuthenticationResults.Results[j] {
+						t.Errorf("unexpected result: *got=%s, expect=%s", r, tc.expect[i].AuthenticationResults.Results[j])
 					}
 				}
-				if v.arcAuthenticationResults.raw != tc.expect[i].arcAuthenticationResults.raw {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcAuthenticationResults.raw, tc.expect[i].arcAuthenticationResults.raw)
+				if v.AuthenticationResults.raw != tc.expect[i].AuthenticationResults.raw {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.AuthenticationResults.raw, tc.expect[i].AuthenticationResults.raw)
 				}
-				if v.arcMessageSignature.InstanceNumber != tc.expect[i].arcMessageSignature.InstanceNumber {
-					t.Errorf("unexpected result: *got=%d, expect=%d", v.arcMessageSignature.InstanceNumber, tc.expect[i].arcMessageSignature.InstanceNumber)
+				if v.MessageSignature.InstanceNumber != tc.expect[i].MessageSignature.InstanceNumber {
+					t.Errorf("unexpected result: *got=%d, expect=%d", v.MessageSignature.InstanceNumber, tc.expect[i].MessageSignature.InstanceNumber)
 				}
-				if v.arcMessageSignature.Algorithm != tc.expect[i].arcMessageSignature.Algorithm {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.Algorithm, tc.expect[i].arcMessageSignature.Algorithm)
+				if v.MessageSignature.Algorithm != tc.expect[i].MessageSignature.Algorithm {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.Algorithm, tc.expect[i].MessageSignature.Algorithm)
 				}
-				if v.arcMessageSignature.Canonicalization != tc.expect[i].arcMessageSignature.Canonicalization {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.Canonicalization, tc.expect[i].arcMessageSignature.Canonicalization)
+				if v.MessageSignature.Canonicalization != tc.expect[i].MessageSignature.Canonicalization {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.Canonicalization, tc.expect[i].MessageSignature.Canonicalization)
 				}
-				if v.arcMessageSignature.Domain != tc.expect[i].arcMessageSignature.Domain {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.Domain, tc.expect[i].arcMessageSignature.Domain)
+				if v.MessageSignature.Domain != tc.expect[i].MessageSignature.Domain {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.Domain, tc.expect[i].MessageSignature.Domain)
 				}
-				if v.arcMessageSignature.Selector != tc.expect[i].arcMessageSignature.Selector {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.Selector, tc.expect[i].arcMessageSignature.Selector)
+				if v.MessageSignature.Selector != tc.expect[i].MessageSignature.Selector {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.Selector, tc.expect[i].MessageSignature.Selector)
 				}
-				if v.arcMessageSignature.Timestamp != tc.expect[i].arcMessageSignature.Timestamp {
-					t.Errorf("unexpected result: *got=%d, expect=%d", v.arcMessageSignature.Timestamp, tc.expect[i].arcMessageSignature.Timestamp)
+				if v.MessageSignature.Timestamp != tc.expect[i].MessageSignature.Timestamp {
+					t.Errorf("unexpected result: *got=%d, expect=%d", v.MessageSignature.Timestamp, tc.expect[i].MessageSignature.Timestamp)
 				}
-				if v.arcMessageSignature.Headers != tc.expect[i].arcMessageSignature.Headers {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.Headers, tc.expect[i].arcMessageSignature.Headers)
+				if v.MessageSignature.Headers != tc.expect[i].MessageSignature.Headers {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.Headers, tc.expect[i].MessageSignature.Headers)
 				}
-				if v.arcMessageSignature.BodyHash != tc.expect[i].arcMessageSignature.BodyHash {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.BodyHash, tc.expect[i].arcMessageSignature.BodyHash)
+				if v.MessageSignature.BodyHash != tc.expect[i].MessageSignature.BodyHash {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.BodyHash, tc.expect[i].MessageSignature.BodyHash)
 				}
-				if v.arcMessageSignature.Signature != tc.expect[i].arcMessageSignature.Signature {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.Signature, tc.expect[i].arcMessageSignature.Signature)
+				if v.MessageSignature.Signature != tc.expect[i].MessageSignature.Signature {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.Signature, tc.expect[i].MessageSignature.Signature)
 				}
-				if v.arcMessageSignature.raw != tc.expect[i].arcMessageSignature.raw {
-					t.Errorf("unexpected result: *got=%s, expect=%s", v.arcMessageSignature.raw, tc.expect[i].arcMessageSignature.raw)
+				if v.MessageSignature.raw != tc.expect[i].MessageSignature.raw {
+					t.Errorf("unexpected result: *got=%s, expect=%s", v.MessageSignature.raw, tc.expect[i].MessageSignature.raw)
 				}
 			}
 		})
 	}
 }
+
+func TestExtractInstancesIncomplete(t *testing.T) {
+	headers := []string{
+		"From: user@example.com\r\n",
+		"ARC-Seal: i=3; a=rsa-sha256; t=1617220000; cv=pass; d=example.com; s=selector; b=signature3\r\n",
+		"ARC-Authentication-Results: i=1; example.com; spf=pass\r\n",
+		"ARC-Message-Signature: i=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=selector; t=1617220000; h=from; bh=bodyhash1; b=signature1\r\n",
+		"Subject: test\r\n",
+		"ARC-Seal: i=1; a=rsa-sha256; t=1617220000; cv=none; d=example.com; s=selector; b=signature1\r\n",
+	}
+	got, err := ExtractInstances(headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0].Number != 1 || got[1].Number != 3 {
+		t.Fatalf("want instances 1 and 3, but got %+v", got)
+	}
+	if !got[0].Complete() || got[1].Complete() {
+		t.Errorf("want only instance 1 complete")
+	}
+	want := []string{headers[2], headers[3], headers[5]}
+	if !reflect.DeepEqual(got[0].Headers(), want) {
+		t.Errorf("want %q, but got %q", want, got[0].Headers())
+	}
+	if !reflect.DeepEqual(got[1].Headers(), []string{headers[1]}) {
+		t.Errorf("want %q, but got %q", headers[1], got[1].Headers())
+	}
+
+	if _, err := ExtractInstances([]string{"ARC-Seal: i=x; b=\r\n"}); err == nil {
+		t.Errorf("expected error for unparsable ARC-Seal")
+	}
+}
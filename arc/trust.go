@@ -0,0 +1,42 @@
+package arc
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/authres"
+)
+
+// TrustedResult は信頼するシーラーが記録したARC-Authentication-Resultsからmethodの結果を返す
+// チェーンの検証結果がpassの場合のみ、最も新しいインスタンスから順に探し、
+// ARC-Sealのd=がtrustedSealersのいずれかと一致するインスタンスの結果を使う
+// 見つからない場合はnilを返す
+func (s *Signatures) TrustedResult(trustedSealers []string, method authres.Method) (*authres.ResultInfo, *Signature) {
+	if s == nil || len(trustedSealers) == 0 {
+		return nil, nil
+	}
+	if s.GetARCChainValidation() != ChainValidationResultPass {
+		return nil, nil
+	}
+	for i := s.GetMaxInstance(); i >= 1; i-- {
+		sig := s.GetInstance(i)
+		seal := sig.GetARCSeal()
+		aar := sig.GetARCAuthenticationResults()
+		if seal == nil || aar == nil || !isTrustedSealer(seal.Domain, trustedSealers) {
+			continue
+		}
+		if results := aar.AuthenticationResults().Get(method); len(results) > 0 {
+			return results[0], sig
+		}
+	}
+	return nil, nil
+}
+
+func isTrustedSealer(domain string, trustedSealers []string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	for _, t := range trustedSealers {
+		if strings.EqualFold(domain, strings.TrimSuffix(t, ".")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package arc
+
+import (
+	"testing"
+
+	"github.com/masa23/mmauth/authres"
+)
+
+func TestTrustedResult(t *testing.T) {
+	headers := []string{
+		"ARC-Seal: i=1; a=rsa-sha256; t=100; cv=none; d=origin.example; s=s1; b=signature",
+		"ARC-Message-Signature: i=1; a=rsa-sha256; c=relaxed/relaxed; d=origin.example; s=s1; h=from; bh=bodyhash; b=signature",
+		"ARC-Authentication-Results: i=1; mx.origin.example; spf=pass smtp.mailfrom=example.jp; dmarc=pass header.from=example.jp",
+		"ARC-Seal: i=2; a=rsa-sha256; t=200; cv=pass; d=list.example; s=s1; b=signature",
+		"ARC-Message-Signature: i=2; a=rsa-sha256; c=relaxed/relaxed; d=list.example; s=s1; h=from; bh=bodyhash; b=signature",
+		"ARC-Authentication-Results: i=2; mx.list.example; dmarc=fail header.from=example.jp",
+	}
+	testCases := []struct {
+		name         string
+		trusted      []string
+		wantResult   authres.Result
+		wantInstance int
+	}{
+		{name: "no trusted sealers"},
+		{name: "untrusted", trusted: []string{"other.example"}},
+		{name: "origin", trusted: []string{"Origin.Example."}, wantResult: authres.ResultPass, wantInstance: 1},
+		{name: "newest first", trusted: []string{"origin.example", "list.example"}, wantResult: authres.ResultFail, wantInstance: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sigs, err := ParseARCHeaders(headers)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ri, sig := sigs.TrustedResult(tc.trusted, authres.MethodDMARC)
+			if tc.wantInstance == 0 {
+				if ri != nil || sig != nil {
+					t.Errorf("want nil, but got %v", ri)
+				}
+				return
+			}
+			if ri == nil || sig == nil {
+				t.Fatalf("want result, but got nil")
+			}
+			if ri.Result != tc.wantResult || sig.GetInstanceNumber() != tc.wantInstance {
+				t.Errorf("want %s at i=%d, but got %s at i=%d", tc.wantResult, tc.wantInstance, ri.Result, sig.GetInstanceNumber())
+			}
+			if from, _ := ri.Property(authres.PropertyTypeHeader, "from"); from != "example.jp" {
+				t.Errorf("unexpected header.from: %s", from)
+			}
+		})
+	}
+
+	// チェーンがpassでない場合は信頼しない
+	broken := append([]string(nil), headers...)
+	broken[3] = "ARC-Seal: i=2; a=rsa-sha256; t=200; cv=fail; d=list.example; s=s1; b=signature"
+	sigs, err := ParseARCHeaders(broken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ri, _ := sigs.TrustedResult([]string{"origin.example"}, authres.MethodDMARC); ri != nil {
+		t.Errorf("want nil for broken chain, but got %v", ri)
+	}
+}
@@ -0,0 +1,282 @@
+package mmauth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/authres"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/spf"
+)
+
+// Disposition は認証結果に基づくメッセージの扱い
+// RFC 7489 のDMARCポリシーと同じ値を使う
+type Disposition string
+
+const (
+	DispositionNone       Disposition = "none"
+	DispositionQuarantine Disposition = "quarantine"
+	DispositionReject     Disposition = "reject"
+)
+
+// AuthResult はSPF・DKIM・ARC・DMARCの認証結果をまとめたもの
+type AuthResult struct {
+	AuthUser         string                    // SMTP AUTHで認証されたユーザー(認証されていない場合は空)
+	MailFrom         string                    // RFC5321.MailFrom
+	Helo             string                    // HELO/EHLOのドメイン
+	FromDomain       string                    // RFC5322.Fromのドメイン(複数ある場合はDMARCの結果が最も厳しいもの)
+	FromDomains      []string                  // RFC5322.Fromのすべてのドメイン
+	SPF              *spf.Result               // MAIL FROMで評価したSPFの結果(null senderの場合はHELO)
+	SPFDomain        string                    // SPFで評価したドメイン(DMARCの識別子)
+	HeloSPF          *spf.Result               // HELOのIDのみで評価したSPFの結果
+	DKIM             []*dkim.Signature         // 検証済みのDKIM署名
+	ARC              arc.ChainValidationResult // ARCチェーンの検証結果
+	ARCSignatures    *arc.Signatures           // 検証済みのARCセット
+	DMARC            *dmarc.Evaluation         // DMARCの評価結果
+	Disposition      Disposition               // 判定結果(PolicyHook適用後)
+	DMARCDisposition Disposition               // DMARCポリシーのみから判定した結果
+	// SPFPolicyStatus はMMAuth.SPFMappingを適用した後のSPFの結果
+	// RecommendedActionでのみ使い、DMARCの評価とAuthentication-Resultsには元の結果を使う
+	SPFPolicyStatus spf.Status
+	// SPFPolicyStrength はSPFの結果を決定したレコードの末尾のallによる方針の強さ
+	// (-allはstrict、~allはsoft)。レポートの集計や管理画面での表示に使う
+	SPFPolicyStrength spf.PolicyStrength
+	// Automated はバウンスや自動応答など自動送信のメッセージの種類
+	// 自動応答を返さない、転送しないなどの判断に使う。認証の評価には影響しない
+	Automated dkim.AutomatedKind
+}
+
+// SPFMapping はSPFのsoftfailとneutralを、RecommendedActionでどの結果とみなすかの設定
+// softfailを拒否の判断に使うかなどはサイトによって異なる
+// DMARCの評価には適用しない。passに変換してもSPFで認証したことにはならず、
+// ?allや~allのドメインでdmarc=passになってしまうため (RFC 7489 4.2)
+type SPFMapping struct {
+	// SoftFail はsoftfailをみなす結果。空の場合はsoftfailのまま
+	SoftFail spf.Status
+	// Neutral はneutralをみなす結果。空の場合はneutralのまま
+	Neutral spf.Status
+}
+
+// Map はSPFの結果を設定に従って変換する
+// mがnilの場合や、softfail・neutral以外の結果はそのまま返す
+func (m *SPFMapping) Map(s spf.Status) spf.Status {
+	if m == nil {
+		return s
+	}
+	switch {
+	case s == spf.SoftFail && m.SoftFail != "":
+		return m.SoftFail
+	case s == spf.Neutral && m.Neutral != "":
+		return m.Neutral
+	}
+	return s
+}
+
+// PolicyHook は認証結果から最終的な扱いを決めるためのフック
+// AuthResult.Disposition にはDMARCポリシーによる判定が入っているので、
+// 変更しない場合はそのまま返す。
+type PolicyHook interface {
+	Decide(AuthResult) Disposition
+}
+
+// PolicyHookFunc は関数をPolicyHookとして使うための型
+type PolicyHookFunc func(AuthResult) Disposition
+
+// Decide はfを呼び出す
+func (f PolicyHookFunc) Decide(r AuthResult) Disposition {
+	return f(r)
+}
+
+// DMARCレコードの問い合わせ関数を返す
+func (m *MMAuth) dmarcLookup() func(string) (*dmarc.Record, error) {
+	if m.DMARCLookup != nil {
+		return m.DMARCLookup
+	}
+	return dmarc.LookupRecordWithSubdomainFallback
+}
+
+// Authenticate はDKIM・ARCの検証とSPF・DMARCの評価を行い、結果をまとめて返す
+// PolicyHookが設定されている場合は最後に呼び出して判定を上書きする
+func (m *MMAuth) Authenticate(remoteAddr net.IP, helo, mailFrom string) *AuthResult {
+	r := &AuthResult{
+		AuthUser:         m.AuthUser,
+		MailFrom:         mailFrom,
+		Helo:             helo,
+		ARC:              arc.ChainValidationResultNone,
+		Disposition:      DispositionNone,
+		DMARCDisposition: DispositionNone,
+	}
+	if m.AuthenticationHeaders == nil {
+		return r
+	}
+	// Authenticateでは空のMAIL FROMはnull sender(<>)
+	sender := mailFrom
+	if sender == "" {
+		sender = "<>"
+	}
+	r.Automated = dkim.DetectAutomated(m.Headers, sender)
+	m.Verify()
+
+	r.SPF, r.SPFDomain, r.HeloSPF = evaluateSPF(remoteAddr, helo, mailFrom)
+
+	id := dmarc.Identifiers{}
+	if r.SPF != nil {
+		r.SPFPolicyStatus = m.SPFMapping.Map(r.SPF.Status)
+		r.SPFPolicyStrength = r.SPF.PolicyStrength()
+	}
+	if r.SPF != nil && r.SPF.Status == spf.Pass {
+		id.SPFDomain = r.SPFDomain
+	}
+	if m.AuthenticationHeaders.DKIMSignatures != nil {
+		for _, d := range *m.AuthenticationHeaders.DKIMSignatures {
+			if d == nil {
+				continue
+			}
+			r.DKIM = append(r.DKIM, d)
+			if d.VerifyResult != nil && d.VerifyResult.Status() == dkim.VerifyStatusPass {
+				id.DKIMDomains = append(id.DKIMDomains, d.VerifyResult.Identity().SDID)
+			}
+		}
+	}
+	if m.AuthenticationHeaders.ARCSignatures != nil {
+		r.ARCSignatures = m.AuthenticationHeaders.ARCSignatures
+		r.ARC = m.AuthenticationHeaders.ARCSignatures.GetARCChainValidation()
+	}
+
+	// Fromが複数ある場合や異なるドメインのアドレスが複数ある場合は、すべてのドメインを評価して
+	// 最も厳しい結果を適用する (RFC 7489 6.6.1)
+	fromDomains, fromErr := FromDomains(m.Headers)
+	r.FromDomains = fromDomains
+	if fromErr != nil {
+		r.DMARC = dmarc.EvaluateWithOptions(id, m.dmarcLookup(), m.DMARCOptions)
+		r.DMARC.Err = fmt.Errorf("invalid RFC5322.From: %w", fromErr)
+	}
+	for _, d := range fromDomains {
+		id.FromDomain = d
+		ev := dmarc.EvaluateWithOptions(id, m.dmarcLookup(), m.DMARCOptions)
+		if ev.Result == dmarc.ResultFail {
+			m.applyARCOverride(r.ARCSignatures, d, ev)
+		}
+		if r.DMARC == nil || stricterDMARC(ev, r.DMARC) {
+			r.DMARC = ev
+			r.FromDomain = d
+		}
+	}
+	if r.DMARC.Result == dmarc.ResultFail {
+		r.DMARCDisposition = Disposition(r.DMARC.Disposition)
+	}
+	r.Disposition = r.DMARCDisposition
+
+	if m.PolicyHook != nil {
+		r.Disposition = m.PolicyHook.Decide(*r)
+	}
+	return r
+}
+
+// 信頼するシーラーが転送前にDMARCのpassを確認している場合はポリシーを適用しない
+// ARC-Authentication-Resultsのheader.fromが評価したFromドメインと異なる場合は対象外
+func (m *MMAuth) applyARCOverride(sigs *arc.Signatures, fromDomain string, ev *dmarc.Evaluation) {
+	if len(m.ARCTrustedSealers) == 0 || sigs == nil {
+		return
+	}
+	ri, _ := sigs.TrustedResult(m.ARCTrustedSealers, authres.MethodDMARC)
+	if ri == nil || ri.Result != dmarc.ResultPass {
+		return
+	}
+	if from, ok := ri.Property(authres.PropertyTypeHeader, "from"); ok && !strings.EqualFold(from, fromDomain) {
+		return
+	}
+	ev.Override(dmarc.OverrideLocalPolicy, "arc=pass", dmarc.PolicyNone)
+}
+
+// aがbより厳しいDMARCの評価結果かを返す
+// 適用するポリシー、評価結果の順に比べる
+func stricterDMARC(a, b *dmarc.Evaluation) bool {
+	if ra, rb := dispositionRank(Disposition(a.Disposition)), dispositionRank(Disposition(b.Disposition)); ra != rb {
+		return ra > rb
+	}
+	return dmarcResultRank(a.Result) > dmarcResultRank(b.Result)
+}
+
+func dispositionRank(d Disposition) int {
+	switch d {
+	case DispositionReject:
+		return 2
+	case DispositionQuarantine:
+		return 1
+	}
+	return 0
+}
+
+func dmarcResultRank(r dmarc.Result) int {
+	switch r {
+	case dmarc.ResultFail:
+		return 4
+	case dmarc.ResultTempError:
+		return 3
+	case dmarc.ResultPermError:
+		return 2
+	case dmarc.ResultNone:
+		return 1
+	}
+	return 0
+}
+
+// ResultInfos は認証結果をAuthentication-Resultsのresinfoとして返す
+// auth, spf, dkim, arc, dmarc の順に並べる
+func (r *AuthResult) ResultInfos() []*authres.ResultInfo {
+	var ret []*authres.ResultInfo
+	if r.AuthUser != "" {
+		ret = append(ret, authres.SMTPAuth(r.AuthUser))
+	}
+	ret = append(ret, spfResultInfos(r.SPF, r.HeloSPF, r.MailFrom, r.Helo)...)
+	for _, d := range r.DKIM {
+		ret = append(ret, d.ResultInfo())
+	}
+	if r.ARCSignatures != nil {
+		ret = append(ret, &authres.ResultInfo{Method: authres.MethodARC, Result: authres.Result(r.ARC)})
+	}
+	if r.DMARC != nil {
+		ri := &authres.ResultInfo{Method: authres.MethodDMARC, Result: r.DMARC.Result}
+		if r.DMARC.Record != nil {
+			ri.Comment = fmt.Sprintf("p=%s dis=%s", r.DMARC.Policy, strings.ToUpper(string(r.Disposition)))
+		}
+		ri.AddProperty(authres.PropertyTypeHeader, "from", r.FromDomain)
+		ret = append(ret, ri)
+	}
+	return ret
+}
+
+// SPFの結果をresinfoとして返す
+// HELOの結果がある場合はsmtp.heloとして別に記載し、MAIL FROMで評価した場合はsmtp.mailfromとして続ける
+// HELOの結果がない場合は従来どおり1つのresinfoに両方のプロパティを記載する
+func spfResultInfos(result, heloResult *spf.Result, mailFrom, helo string) []*authres.ResultInfo {
+	var ret []*authres.ResultInfo
+	if heloResult != nil {
+		ri := &authres.ResultInfo{Method: authres.MethodSPF, Result: heloResult.Status}
+		ret = append(ret, ri.AddProperty(authres.PropertyTypeSMTP, "helo", helo))
+	}
+	if result == nil || result == heloResult {
+		return ret
+	}
+	ri := &authres.ResultInfo{Method: authres.MethodSPF, Result: result.Status}
+	ri.AddProperty(authres.PropertyTypeSMTP, "mailfrom", mailFrom)
+	if heloResult == nil {
+		ri.AddProperty(authres.PropertyTypeSMTP, "helo", helo)
+	}
+	return append(ret, ri)
+}
+
+// AuthenticationResults はAuthentication-Resultsヘッダの値を返す
+func (r *AuthResult) AuthenticationResults(authservID string) string {
+	return authres.Format(authservID, r.ResultInfos()...)
+}
+
+// ARCAuthenticationResults は認証結果からARCで署名するARC-Authentication-Resultsを作る
+// resinfoとプロパティはorderの順に並べる。orderがnilの場合はauthres.DefaultOrdering
+func (r *AuthResult) ARCAuthenticationResults(instance int, authservID string, order *authres.Ordering) *arc.ARCAuthenticationResults {
+	return arc.NewARCAuthenticationResults(instance, authservID, r.ResultInfos(), order)
+}
@@ -0,0 +1,473 @@
+package mmauth
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/authres"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/spf"
+)
+
+func TestAuthenticate(t *testing.T) {
+	msg := "From: user@example.com\r\n" +
+		"To: rcpt@example.net\r\n" +
+		"Subject: test\r\n" +
+		"\r\n" +
+		"body\r\n"
+
+	spfRecords := map[string][]string{
+		"example.com": {"v=spf1 ip4:192.0.2.1 ?ip4:192.0.2.3 ~ip4:192.0.2.4 -all"},
+	}
+	origTXT := spf.DefaultTXTResolver
+	t.Cleanup(func() { spf.DefaultTXTResolver = origTXT })
+	spf.DefaultTXTResolver = func(name string) ([]string, error) {
+		if r, ok := spfRecords[name]; ok {
+			return r, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	dmarcLookup := func(domain string) (*dmarc.Record, error) {
+		if domain == "example.com" {
+			return dmarc.ParseRecord("v=DMARC1; p=reject;")
+		}
+		return nil, dmarc.ErrNoRecordFound
+	}
+
+	testCases := []struct {
+		name            string
+		remoteAddr      string
+		hook            PolicyHook
+		mapping         *SPFMapping
+		wantSPFPolicy   spf.Status
+		wantDMARC       dmarc.Result
+		wantDMARCDispo  Disposition
+		wantDisposition Disposition
+	}{
+		{
+			name:            "spf aligned pass",
+			remoteAddr:      "192.0.2.1",
+			wantDMARC:       dmarc.ResultPass,
+			wantDMARCDispo:  DispositionNone,
+			wantDisposition: DispositionNone,
+		},
+		{
+			name:            "spf fail rejected by policy",
+			remoteAddr:      "192.0.2.2",
+			wantDMARC:       dmarc.ResultFail,
+			wantDMARCDispo:  DispositionReject,
+			wantDisposition: DispositionReject,
+		},
+		{
+			name:       "hook overrides disposition",
+			remoteAddr: "192.0.2.2",
+			hook: PolicyHookFunc(func(r AuthResult) Disposition {
+				if r.Disposition == DispositionReject {
+					return DispositionQuarantine
+				}
+				return r.Disposition
+			}),
+			wantDMARC:       dmarc.ResultFail,
+			wantDMARCDispo:  DispositionReject,
+			wantDisposition: DispositionQuarantine,
+		},
+		{
+			name:            "spf softfail is not authenticated",
+			remoteAddr:      "192.0.2.4",
+			wantSPFPolicy:   spf.SoftFail,
+			wantDMARC:       dmarc.ResultFail,
+			wantDMARCDispo:  DispositionReject,
+			wantDisposition: DispositionReject,
+		},
+		{
+			// マッピングはDMARCの評価に使わないため、?allではdmarc=passにならない
+			name:            "spf neutral mapped to pass",
+			remoteAddr:      "192.0.2.3",
+			mapping:         &SPFMapping{Neutral: spf.Pass},
+			wantSPFPolicy:   spf.Pass,
+			wantDMARC:       dmarc.ResultFail,
+			wantDMARCDispo:  DispositionReject,
+			wantDisposition: DispositionReject,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMMAuth(WithDMARCLookup(dmarcLookup), WithPolicy(tc.hook), WithSPFMapping(tc.mapping))
+			if _, err := m.Write([]byte(msg)); err != nil {
+				t.Fatalf("failed to write message: %v", err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+			r := m.Authenticate(net.ParseIP(tc.remoteAddr), "mx.example.net", "user@example.com")
+			if r.FromDomain != "example.com" {
+				t.Errorf("expected from domain example.com, got %q", r.FromDomain)
+			}
+			if tc.wantSPFPolicy != "" && r.SPFPolicyStatus != tc.wantSPFPolicy {
+				t.Errorf("expected spf policy status %s, got %s", tc.wantSPFPolicy, r.SPFPolicyStatus)
+			}
+			if r.DMARC.Result != tc.wantDMARC {
+				t.Errorf("expected dmarc %s, got %s", tc.wantDMARC, r.DMARC.Result)
+			}
+			if r.DMARCDisposition != tc.wantDMARCDispo {
+				t.Errorf("expected dmarc disposition %s, got %s", tc.wantDMARCDispo, r.DMARCDisposition)
+			}
+			if r.Disposition != tc.wantDisposition {
+				t.Errorf("expected disposition %s, got %s", tc.wantDisposition, r.Disposition)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSPFIdentity(t *testing.T) {
+	spfRecords := map[string][]string{
+		"example.com":     {"v=spf1 ip4:192.0.2.1 -all"},
+		"mx.example.com":  {"v=spf1 ip4:192.0.2.5 -all"},
+		"other.example":   {"v=spf1 -all"},
+		"bad.example.com": {"v=spf1 -all"},
+	}
+	origTXT := spf.DefaultTXTResolver
+	t.Cleanup(func() { spf.DefaultTXTResolver = origTXT })
+	spf.DefaultTXTResolver = func(name string) ([]string, error) {
+		if r, ok := spfRecords[name]; ok {
+			return r, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	dmarcLookup := func(domain string) (*dmarc.Record, error) {
+		if domain == "example.com" {
+			return dmarc.ParseRecord("v=DMARC1; p=reject;")
+		}
+		return nil, dmarc.ErrNoRecordFound
+	}
+
+	testCases := []struct {
+		name          string
+		remoteAddr    string
+		helo          string
+		mailFrom      string
+		wantSPF       spf.Status
+		wantSPFDomain string
+		wantHeloSPF   spf.Status
+		wantDMARC     dmarc.Result
+	}{
+		{
+			// HELOのpassはDMARCのアライメントに使わない
+			name:          "helo pass and mailfrom fail",
+			remoteAddr:    "192.0.2.5",
+			helo:          "mx.example.com",
+			mailFrom:      "user@other.example",
+			wantSPF:       spf.Fail,
+			wantSPFDomain: "other.example",
+			wantHeloSPF:   spf.Pass,
+			wantDMARC:     dmarc.ResultFail,
+		},
+		{
+			// HELOのfailでMAIL FROMのpassを隠さない
+			name:          "helo fail and mailfrom pass",
+			remoteAddr:    "192.0.2.1",
+			helo:          "bad.example.com",
+			mailFrom:      "user@example.com",
+			wantSPF:       spf.Pass,
+			wantSPFDomain: "example.com",
+			wantHeloSPF:   spf.Fail,
+			wantDMARC:     dmarc.ResultPass,
+		},
+		{
+			name:          "null sender uses helo",
+			remoteAddr:    "192.0.2.5",
+			helo:          "mx.example.com",
+			mailFrom:      "",
+			wantSPF:       spf.Pass,
+			wantSPFDomain: "mx.example.com",
+			wantHeloSPF:   spf.Pass,
+			wantDMARC:     dmarc.ResultPass,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMMAuth()
+			m.DMARCLookup = dmarcLookup
+			if _, err := m.Write([]byte("From: user@example.com\r\nSubject: test\r\n\r\nbody\r\n")); err != nil {
+				t.Fatalf("failed to write message: %v", err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+			r := m.Authenticate(net.ParseIP(tc.remoteAddr), tc.helo, tc.mailFrom)
+			if r.SPF.Status != tc.wantSPF || r.SPFDomain != tc.wantSPFDomain {
+				t.Errorf("expected spf %s for %s, got %s for %s", tc.wantSPF, tc.wantSPFDomain, r.SPF.Status, r.SPFDomain)
+			}
+			if r.HeloSPF.Status != tc.wantHeloSPF {
+				t.Errorf("expected helo spf %s, got %s", tc.wantHeloSPF, r.HeloSPF.Status)
+			}
+			if r.DMARC.Result != tc.wantDMARC {
+				t.Errorf("expected dmarc %s, got %s", tc.wantDMARC, r.DMARC.Result)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAutomated(t *testing.T) {
+	origTXT := spf.DefaultTXTResolver
+	t.Cleanup(func() { spf.DefaultTXTResolver = origTXT })
+	spf.DefaultTXTResolver = func(name string) ([]string, error) {
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name     string
+		headers  string
+		mailFrom string
+		want     dkim.AutomatedKind
+	}{
+		{name: "ordinary", headers: "From: user@example.com\r\n", mailFrom: "user@example.com", want: dkim.AutomatedNone},
+		{name: "null sender", headers: "From: MAILER-DAEMON@example.com\r\n", mailFrom: "", want: dkim.AutomatedBounce},
+		{name: "vacation reply", headers: "From: user@example.com\r\nAuto-Submitted: auto-replied\r\n", mailFrom: "user@example.com", want: dkim.AutomatedAutoReplied},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMMAuth()
+			m.DMARCLookup = func(string) (*dmarc.Record, error) { return nil, dmarc.ErrNoRecordFound }
+			if _, err := m.Write([]byte(tc.headers + "\r\nbody\r\n")); err != nil {
+				t.Fatalf("failed to write message: %v", err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+			r := m.Authenticate(net.ParseIP("192.0.2.1"), "mx.example.com", tc.mailFrom)
+			if r.Automated != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, r.Automated)
+			}
+			// 自動送信のメッセージでも認証は行う
+			if r.DMARC == nil || r.DMARC.Result != dmarc.ResultNone {
+				t.Errorf("want dmarc none, but got %+v", r.DMARC)
+			}
+		})
+	}
+}
+
+func TestAuthenticateMultipleFrom(t *testing.T) {
+	origTXT := spf.DefaultTXTResolver
+	t.Cleanup(func() { spf.DefaultTXTResolver = origTXT })
+	spf.DefaultTXTResolver = func(name string) ([]string, error) {
+		if name == "attacker.example" {
+			return []string{"v=spf1 +all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	dmarcLookup := func(domain string) (*dmarc.Record, error) {
+		switch domain {
+		case "bank.example":
+			return dmarc.ParseRecord("v=DMARC1; p=reject;")
+		case "attacker.example":
+			return dmarc.ParseRecord("v=DMARC1; p=none;")
+		}
+		return nil, dmarc.ErrNoRecordFound
+	}
+
+	testCases := []struct {
+		name            string
+		from            string
+		wantFromDomains []string
+	}{
+		{
+			name:            "single from",
+			from:            "From: ceo@bank.example\r\n",
+			wantFromDomains: []string{"bank.example"},
+		},
+		{
+			name:            "multiple addresses",
+			from:            "From: ceo@bank.example, x@attacker.example\r\n",
+			wantFromDomains: []string{"bank.example", "attacker.example"},
+		},
+		{
+			name:            "multiple headers",
+			from:            "From: x@attacker.example\r\nFrom: ceo@bank.example\r\n",
+			wantFromDomains: []string{"attacker.example", "bank.example"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMMAuth()
+			m.DMARCLookup = dmarcLookup
+			if _, err := m.Write([]byte(tc.from + "Subject: test\r\n\r\nbody\r\n")); err != nil {
+				t.Fatalf("failed to write message: %v", err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+			r := m.Authenticate(net.ParseIP("192.0.2.9"), "mx.attacker.example", "x@attacker.example")
+			if !reflect.DeepEqual(r.FromDomains, tc.wantFromDomains) {
+				t.Errorf("expected from domains %v, got %v", tc.wantFromDomains, r.FromDomains)
+			}
+			// attacker.exampleでSPFがアラインしても、bank.exampleのp=rejectを適用する
+			if r.FromDomain != "bank.example" {
+				t.Errorf("expected from domain bank.example, got %q", r.FromDomain)
+			}
+			if r.DMARC.Result != dmarc.ResultFail {
+				t.Errorf("expected dmarc fail, got %s (%v)", r.DMARC.Result, r.DMARC.Err)
+			}
+			if r.Disposition != DispositionReject {
+				t.Errorf("expected disposition reject, got %s", r.Disposition)
+			}
+			if got := r.RecommendedAction(); got != ActionReject {
+				t.Errorf("expected action reject, got %s", got)
+			}
+		})
+	}
+}
+
+func TestAuthenticationResultsSMTPAuth(t *testing.T) {
+	origTXT := spf.DefaultTXTResolver
+	t.Cleanup(func() { spf.DefaultTXTResolver = origTXT })
+	spf.DefaultTXTResolver = func(name string) ([]string, error) {
+		if name == "example.com" {
+			return []string{"v=spf1 ip4:192.0.2.1 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	m := NewMMAuth()
+	m.AuthUser = "user@example.com"
+	m.DMARCLookup = func(domain string) (*dmarc.Record, error) {
+		return nil, dmarc.ErrNoRecordFound
+	}
+	if _, err := m.Write([]byte("From: user@example.com\r\nSubject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	r := m.Authenticate(net.ParseIP("192.0.2.1"), "mx.example.com", "user@example.com")
+	want := "mx.example.jp; auth=pass smtp.auth=user@example.com; " +
+		"spf=none smtp.helo=mx.example.com; spf=pass smtp.mailfrom=user@example.com; " +
+		"arc=none; dmarc=none header.from=example.com"
+	if got := r.AuthenticationResults("mx.example.jp"); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	headers := m.GetAuthenticationHeader(net.ParseIP("192.0.2.1"), "mx.example.com", "user@example.com")
+	if len(headers) == 0 || headers[0] != "auth=pass smtp.auth=user@example.com" {
+		t.Errorf("expected auth result first, got %v", headers)
+	}
+}
+
+func TestSPFResultInfos(t *testing.T) {
+	pass := &spf.Result{Status: spf.Pass}
+	none := &spf.Result{Status: spf.None}
+	testCases := []struct {
+		name   string
+		result *spf.Result
+		helo   *spf.Result
+		want   string
+	}{
+		{
+			name:   "helo decided",
+			result: pass,
+			helo:   pass,
+			want:   "spf=pass smtp.helo=mx.example.com",
+		},
+		{
+			name:   "mail from fallback",
+			result: pass,
+			helo:   none,
+			want:   "spf=none smtp.helo=mx.example.com; spf=pass smtp.mailfrom=user@example.com",
+		},
+		{
+			name:   "without helo result",
+			result: pass,
+			want:   "spf=pass smtp.mailfrom=user@example.com smtp.helo=mx.example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, ri := range spfResultInfos(tc.result, tc.helo, "user@example.com", "mx.example.com") {
+				got = append(got, ri.String())
+			}
+			if strings.Join(got, "; ") != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, strings.Join(got, "; "))
+			}
+		})
+	}
+}
+
+func TestVerifyStatusUnified(t *testing.T) {
+	// 各パッケージの結果の型はauthres.Resultの別名なので変換なしで比較できる
+	statuses := []authres.Result{
+		dkim.VerifyStatusPass,
+		arc.VerifyStatusPass,
+		spf.Pass,
+		dmarc.ResultPass,
+	}
+	for _, s := range statuses {
+		if s != authres.ResultPass {
+			t.Errorf("want %s, but got %s", authres.ResultPass, s)
+		}
+	}
+	var status arc.VerifyStatus = dkim.VerifyStatusTempErr
+	if status != spf.TempError {
+		t.Errorf("unexpected status: %s", status)
+	}
+}
+
+func TestApplyARCOverride(t *testing.T) {
+	sigs, err := arc.ParseARCHeaders([]string{
+		"ARC-Seal: i=1; a=rsa-sha256; t=100; cv=none; d=list.example; s=s1; b=signature",
+		"ARC-Message-Signature: i=1; a=rsa-sha256; c=relaxed/relaxed; d=list.example; s=s1; h=from; bh=bodyhash; b=signature",
+		"ARC-Authentication-Results: i=1; mx.list.example; dmarc=pass header.from=example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lookup := func(string) (*dmarc.Record, error) { return dmarc.ParseRecord("v=DMARC1; p=reject;") }
+
+	testCases := []struct {
+		name       string
+		trusted    []string
+		fromDomain string
+		want       []dmarc.PolicyOverride
+		wantDispo  dmarc.PolicyType
+	}{
+		{
+			name:       "trusted sealer",
+			trusted:    []string{"list.example"},
+			fromDomain: "example.com",
+			want:       []dmarc.PolicyOverride{{Type: dmarc.OverrideLocalPolicy, Comment: "arc=pass"}},
+			wantDispo:  dmarc.PolicyNone,
+		},
+		{
+			name:       "untrusted sealer",
+			trusted:    []string{"other.example"},
+			fromDomain: "example.com",
+			wantDispo:  dmarc.PolicyReject,
+		},
+		{
+			name:       "different from domain",
+			trusted:    []string{"list.example"},
+			fromDomain: "example.net",
+			wantDispo:  dmarc.PolicyReject,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &MMAuth{ARCTrustedSealers: tc.trusted}
+			ev := dmarc.Evaluate(dmarc.Identifiers{FromDomain: tc.fromDomain}, lookup)
+			m.applyARCOverride(sigs, tc.fromDomain, ev)
+			if ev.Disposition != tc.wantDispo {
+				t.Errorf("want disposition %s, but got %s", tc.wantDispo, ev.Disposition)
+			}
+			if !reflect.DeepEqual(ev.Overrides, tc.want) {
+				t.Errorf("want overrides %+v, but got %+v", tc.want, ev.Overrides)
+			}
+		})
+	}
+}
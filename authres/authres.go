@@ -0,0 +1,352 @@
+// Package authres はAuthentication-Results(RFC 8601)で使う認証方式名・結果の定数と、
+// resinfo(method=result (comment) ptype.property=value ...)の生成と解析を提供する
+package authres
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Method は認証方式の名前 (RFC 8601 2.7, IANA Email Authentication Methods)
+type Method string
+
+const (
+	MethodDKIM  Method = "dkim"
+	MethodSPF   Method = "spf"
+	MethodDMARC Method = "dmarc"
+	MethodARC   Method = "arc"
+	MethodIPRev Method = "iprev"
+	MethodAuth  Method = "auth"
+)
+
+// Result は認証方式の結果
+// dkim.VerifyStatus, arc.VerifyStatus, spf.Status, dmarc.Result はこの型の別名
+type Result string
+
+const (
+	ResultNone      Result = "none"
+	ResultPass      Result = "pass"
+	ResultFail      Result = "fail"
+	ResultSoftFail  Result = "softfail"
+	ResultNeutral   Result = "neutral"
+	ResultPolicy    Result = "policy"
+	ResultTempError Result = "temperror"
+	ResultPermError Result = "permerror"
+)
+
+// PropertyType はプロパティの種類 (ptype)
+type PropertyType string
+
+const (
+	PropertyTypeSMTP   PropertyType = "smtp"
+	PropertyTypeHeader PropertyType = "header"
+	PropertyTypeBody   PropertyType = "body"
+	PropertyTypePolicy PropertyType = "policy"
+)
+
+// Property は ptype.property=value の組
+type Property struct {
+	Type  PropertyType
+	Name  string
+	Value string
+}
+
+// String はプロパティを ptype.property=value の形式で返す
+func (p Property) String() string {
+	return fmt.Sprintf("%s.%s=%s", p.Type, p.Name, quoteValue(p.Value))
+}
+
+// ResultInfo は1つの認証方式の結果 (resinfo)
+type ResultInfo struct {
+	Method     Method
+	Result     Result
+	Comment    string // 結果の後に付けるコメント(括弧は含まない)
+	Properties []Property
+}
+
+// AddProperty はプロパティを追加する
+// 値が空の場合は追加しない
+func (r *ResultInfo) AddProperty(t PropertyType, name, value string) *ResultInfo {
+	if value != "" {
+		r.Properties = append(r.Properties, Property{Type: t, Name: name, Value: value})
+	}
+	return r
+}
+
+// Property は指定した種類と名前のプロパティの値を返す
+func (r *ResultInfo) Property(t PropertyType, name string) (string, bool) {
+	for _, p := range r.Properties {
+		if p.Type == t && strings.EqualFold(p.Name, name) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// String は resinfo を method=result (comment) ptype.property=value の形式で返す
+func (r *ResultInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s=%s", r.Method, r.Result)
+	if r.Comment != "" {
+		fmt.Fprintf(&b, " (%s)", escapeComment(r.Comment))
+	}
+	for _, p := range r.Properties {
+		b.WriteString(" ")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// None は結果が none のみの resinfo を返す
+func None(m Method) *ResultInfo {
+	return &ResultInfo{Method: m, Result: ResultNone}
+}
+
+// SMTPAuth はSMTP AUTHで認証されたセッションを表す auth=pass smtp.auth=user を返す
+// RFC 8601 2.7.4
+func SMTPAuth(user string) *ResultInfo {
+	ri := &ResultInfo{Method: MethodAuth, Result: ResultPass}
+	return ri.AddProperty(PropertyTypeSMTP, "auth", user)
+}
+
+// Format はAuthentication-Resultsヘッダの値(authserv-id; resinfo; ...)を返す
+// 結果がない場合は "authserv-id; none" を返す
+func Format(authservID string, results ...*ResultInfo) string {
+	if len(results) == 0 {
+		return authservID + "; none"
+	}
+	parts := make([]string, 0, len(results)+1)
+	parts = append(parts, authservID)
+	for _, r := range results {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ParseAuthServID はAuthentication-Resultsヘッダの値からauthserv-idを取り出す
+// authserv-idの後のバージョン番号とコメントは無視し、小文字にして返す
+func ParseAuthServID(value string) (string, error) {
+	value, _ = stripComments(value)
+	first, _, _ := strings.Cut(value, ";")
+	fields := splitFields(first)
+	if len(fields) == 0 {
+		return "", errors.New("missing authserv-id")
+	}
+	return strings.ToLower(unquoteValue(fields[0])), nil
+}
+
+// ParseResultInfo は method=result (comment) ptype.property=value の形式の resinfo を解析する
+func ParseResultInfo(s string) (*ResultInfo, error) {
+	s, comments := stripComments(s)
+	fields := splitFields(s)
+	if len(fields) == 0 {
+		return nil, errors.New("empty resinfo")
+	}
+	method, result, ok := strings.Cut(fields[0], "=")
+	if !ok || method == "" || result == "" {
+		return nil, fmt.Errorf("invalid method result: %s", fields[0])
+	}
+	// method/version の形式はバージョンを無視する
+	if m, _, ok := strings.Cut(method, "/"); ok {
+		method = m
+	}
+	ri := &ResultInfo{
+		Method: Method(strings.ToLower(strings.TrimSpace(method))),
+		Result: Result(strings.ToLower(strings.TrimSpace(result))),
+	}
+	if len(comments) > 0 {
+		ri.Comment = comments[0]
+	}
+	for _, f := range fields[1:] {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			// reason= などの値が次のフィールドに続く場合は無視する
+			continue
+		}
+		t, name, ok := strings.Cut(k, ".")
+		if !ok {
+			// reason=... は ptype を持たないので無視する
+			continue
+		}
+		ri.Properties = append(ri.Properties, Property{
+			Type:  PropertyType(strings.ToLower(t)),
+			Name:  strings.ToLower(name),
+			Value: unquoteValue(v),
+		})
+	}
+	return ri, nil
+}
+
+// AuthenticationResults はAuthentication-Resultsヘッダの値を解析した結果
+// ARC-Authentication-Resultsの結果も同じ構造で扱う
+type AuthenticationResults struct {
+	AuthServID string
+	Results    []*ResultInfo
+}
+
+// ParseAuthenticationResults はAuthentication-Resultsヘッダの値(ヘッダ名は含まない)を解析する
+// 解析できないresinfoは無視する。結果がnoneのみの場合はResultsが空になる
+func ParseAuthenticationResults(value string) (*AuthenticationResults, error) {
+	id, err := ParseAuthServID(value)
+	if err != nil {
+		return nil, err
+	}
+	ar := &AuthenticationResults{AuthServID: id}
+	parts := SplitResultInfos(value)
+	for _, part := range parts[1:] {
+		ri, err := ParseResultInfo(part)
+		if err != nil {
+			continue
+		}
+		ar.Results = append(ar.Results, ri)
+	}
+	return ar, nil
+}
+
+// SplitResultInfos はヘッダの値をセミコロンで分割する
+// コメントとquoted-stringの中のセミコロンでは分割せず、空の要素は含めない
+func SplitResultInfos(value string) []string {
+	var parts []string
+	start, depth := 0, 0
+	inQuote := false
+	add := func(p string) {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && (inQuote || depth > 0):
+			i++
+		case c == '"' && depth == 0:
+			inQuote = !inQuote
+		case c == '(' && !inQuote:
+			depth++
+		case c == ')' && !inQuote && depth > 0:
+			depth--
+		case c == ';' && !inQuote && depth == 0:
+			add(value[start:i])
+			start = i + 1
+		}
+	}
+	add(value[start:])
+	return parts
+}
+
+// Get はmethodの結果をヘッダに記載された順に返す
+func (a *AuthenticationResults) Get(m Method) []*ResultInfo {
+	var results []*ResultInfo
+	for _, r := range a.Results {
+		if r.Method == m {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// GetDKIMResults はdkimの結果を返す
+func (a *AuthenticationResults) GetDKIMResults() []*ResultInfo { return a.Get(MethodDKIM) }
+
+// GetSPFResults はspfの結果を返す
+func (a *AuthenticationResults) GetSPFResults() []*ResultInfo { return a.Get(MethodSPF) }
+
+// GetDMARCResults はdmarcの結果を返す
+func (a *AuthenticationResults) GetDMARCResults() []*ResultInfo { return a.Get(MethodDMARC) }
+
+// GetARCResults はarcの結果を返す
+func (a *AuthenticationResults) GetARCResults() []*ResultInfo { return a.Get(MethodARC) }
+
+// 値に空白や特殊文字が含まれる場合は quoted-string にする
+func quoteValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t;()\"\\") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	}
+	return v
+}
+
+func unquoteValue(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(v[1 : len(v)-1])
+	}
+	return v
+}
+
+// コメント内の括弧とバックスラッシュをエスケープする
+func escapeComment(c string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(c)
+}
+
+// 括弧で囲まれたコメントを取り除き、コメントの内容を返す
+// quoted-string の中の括弧はコメントとして扱わない
+func stripComments(s string) (string, []string) {
+	var out, cur strings.Builder
+	var comments []string
+	depth := 0
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (depth > 0 || inQuote):
+			i++
+			if depth > 0 {
+				cur.WriteByte(s[i])
+			} else {
+				out.WriteByte(c)
+				out.WriteByte(s[i])
+			}
+		case c == '"' && depth == 0:
+			inQuote = !inQuote
+			out.WriteByte(c)
+		case c == '(' && !inQuote:
+			if depth > 0 {
+				cur.WriteByte(c)
+			}
+			depth++
+		case c == ')' && !inQuote && depth > 0:
+			depth--
+			if depth > 0 {
+				cur.WriteByte(c)
+			} else {
+				comments = append(comments, cur.String())
+				cur.Reset()
+				out.WriteByte(' ')
+			}
+		case depth > 0:
+			cur.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), comments
+}
+
+// 空白で分割する。quoted-string の中の空白では分割しない
+func splitFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(s):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(s[i])
+		case c == '"':
+			inQuote = !inQuote
+			cur.WriteByte(c)
+		case (c == ' ' || c == '\t' || c == '\r' || c == '\n') && !inQuote:
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
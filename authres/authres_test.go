@@ -0,0 +1,270 @@
+package authres
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResultInfoString(t *testing.T) {
+	testCases := []struct {
+		name string
+		ri   *ResultInfo
+		want string
+	}{
+		{
+			name: "none",
+			ri:   None(MethodDKIM),
+			want: "dkim=none",
+		},
+		{
+			name: "dkim with properties",
+			ri: (&ResultInfo{Method: MethodDKIM, Result: ResultPass, Comment: "good signature"}).
+				AddProperty(PropertyTypeHeader, "d", "example.com").
+				AddProperty(PropertyTypeHeader, "s", "selector").
+				AddProperty(PropertyTypeHeader, "i", ""),
+			want: "dkim=pass (good signature) header.d=example.com header.s=selector",
+		},
+		{
+			name: "quoted value and escaped comment",
+			ri: (&ResultInfo{Method: MethodSPF, Result: ResultFail, Comment: "see (details)"}).
+				AddProperty(PropertyTypeSMTP, "mailfrom", `a b"c`),
+			want: `spf=fail (see \(details\)) smtp.mailfrom="a b\"c"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.ri.String(); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got := Format("mx.example.jp"); got != "mx.example.jp; none" {
+		t.Errorf("unexpected: %q", got)
+	}
+	got := Format("mx.example.jp", None(MethodSPF), &ResultInfo{Method: MethodDMARC, Result: ResultPass})
+	if want := "mx.example.jp; spf=none; dmarc=pass"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestOrdering(t *testing.T) {
+	results := []*ResultInfo{
+		{Method: MethodDMARC, Result: ResultPass, Properties: []Property{{Type: PropertyTypeHeader, Name: "from", Value: "example.com"}}},
+		{Method: MethodDKIM, Result: ResultPass, Properties: []Property{
+			{Type: PropertyTypeHeader, Name: "s", Value: "sel"},
+			{Type: PropertyTypeHeader, Name: "d", Value: "example.com"},
+		}},
+		{Method: "x-custom", Result: ResultPass},
+		{Method: MethodSPF, Result: ResultPass, Properties: []Property{
+			{Type: PropertyTypeHeader, Name: "from", Value: "example.com"},
+			{Type: PropertyTypeSMTP, Name: "mailfrom", Value: "example.com"},
+		}},
+		{Method: MethodDKIM, Result: ResultFail, Properties: []Property{{Type: PropertyTypeHeader, Name: "d", Value: "example.net"}}},
+	}
+
+	testCases := []struct {
+		name  string
+		order *Ordering
+		want  string
+	}{
+		{
+			name:  "default",
+			order: nil,
+			want: "mx.example.jp; spf=pass smtp.mailfrom=example.com header.from=example.com; " +
+				"dkim=pass header.s=sel header.d=example.com; dkim=fail header.d=example.net; " +
+				"dmarc=pass header.from=example.com; x-custom=pass",
+		},
+		{
+			name:  "custom",
+			order: &Ordering{Methods: []Method{MethodDMARC, MethodDKIM}, PropertyTypes: []PropertyType{PropertyTypeHeader}},
+			want: "mx.example.jp; dmarc=pass header.from=example.com; " +
+				"dkim=pass header.s=sel header.d=example.com; dkim=fail header.d=example.net; " +
+				"x-custom=pass; spf=pass header.from=example.com smtp.mailfrom=example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				if got := tc.order.Format("mx.example.jp", results...); got != tc.want {
+					t.Fatalf("want %q, but got %q", tc.want, got)
+				}
+			}
+		})
+	}
+
+	// 元のスライスとプロパティは変更しない
+	if results[0].Method != MethodDMARC || results[3].Properties[0].Type != PropertyTypeHeader {
+		t.Errorf("want results to be unchanged, but got %v", results)
+	}
+}
+
+func TestParseResultInfo(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    *ResultInfo
+		wantErr bool
+	}{
+		{
+			name:  "dkim",
+			input: "dkim=pass (good signature) header.d=example.com header.s=selector",
+			want: &ResultInfo{
+				Method:  MethodDKIM,
+				Result:  ResultPass,
+				Comment: "good signature",
+				Properties: []Property{
+					{Type: PropertyTypeHeader, Name: "d", Value: "example.com"},
+					{Type: PropertyTypeHeader, Name: "s", Value: "selector"},
+				},
+			},
+		},
+		{
+			name:  "versioned method and quoted value",
+			input: `SPF/1=Fail smtp.mailfrom="a b\"c" reason="x"`,
+			want: &ResultInfo{
+				Method: MethodSPF,
+				Result: ResultFail,
+				Properties: []Property{
+					{Type: PropertyTypeSMTP, Name: "mailfrom", Value: `a b"c`},
+				},
+			},
+		},
+		{
+			name:  "round trip of escaped comment",
+			input: `arc=fail (i=2 \(bad\)) `,
+			want:  &ResultInfo{Method: MethodARC, Result: ResultFail, Comment: "i=2 (bad)"},
+		},
+		{name: "empty", input: " ", wantErr: true},
+		{name: "missing result", input: "dkim", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseResultInfo(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err == nil && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseAuthServID(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: " MX.Example.JP; spf=pass", want: "mx.example.jp"},
+		{input: "mx.example.jp 1; none", want: "mx.example.jp"},
+		{input: "(comment) mx.example.jp\r\n\t; dkim=pass", want: "mx.example.jp"},
+		{input: "mx.example.jp", want: "mx.example.jp"},
+		{input: " ; spf=pass", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseAuthServID(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	value := "MX.Example.JP 1; dkim=pass (good; signature) header.d=example.com header.s=sel; " +
+		"spf=pass smtp.mailfrom=\"user;name@example.com\"; dkim=fail header.d=example.net; " +
+		"dmarc=pass policy.published-domain-policy=reject header.from=example.com"
+	ar, err := ParseAuthenticationResults(value)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ar.AuthServID != "mx.example.jp" {
+		t.Errorf("expected authserv-id mx.example.jp, got %q", ar.AuthServID)
+	}
+	if len(ar.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(ar.Results))
+	}
+	dkims := ar.GetDKIMResults()
+	if len(dkims) != 2 || dkims[0].Result != ResultPass || dkims[1].Result != ResultFail {
+		t.Fatalf("unexpected dkim results: %v", dkims)
+	}
+	if dkims[0].Comment != "good; signature" {
+		t.Errorf("expected comment to be kept, got %q", dkims[0].Comment)
+	}
+	if v, _ := dkims[0].Property(PropertyTypeHeader, "s"); v != "sel" {
+		t.Errorf("expected header.s=sel, got %q", v)
+	}
+	if v, _ := ar.GetSPFResults()[0].Property(PropertyTypeSMTP, "mailfrom"); v != "user;name@example.com" {
+		t.Errorf("expected quoted mailfrom, got %q", v)
+	}
+	if v, _ := ar.GetDMARCResults()[0].Property(PropertyTypePolicy, "published-domain-policy"); v != "reject" {
+		t.Errorf("expected policy property, got %q", v)
+	}
+	if len(ar.GetARCResults()) != 0 {
+		t.Errorf("expected no arc results")
+	}
+
+	none, err := ParseAuthenticationResults("mx.example.jp; none")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(none.Results) != 0 {
+		t.Errorf("expected no results, got %v", none.Results)
+	}
+}
+
+func TestParseReceivedSPF(t *testing.T) {
+	value := " Pass (mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender)\r\n" +
+		"\treceiver=mybox.example.org; client-ip=192.0.2.1;\r\n" +
+		"\tenvelope-from=\"myname@example.com\"; helo=foo.example.com; x-ext=ignored;"
+	got, err := ParseReceivedSPF(value)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &ReceivedSPF{
+		Result:       ResultPass,
+		Comment:      "mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender",
+		Receiver:     "mybox.example.org",
+		ClientIP:     "192.0.2.1",
+		EnvelopeFrom: "myname@example.com",
+		Helo:         "foo.example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if s := got.String(); s != "pass (mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender)"+
+		" receiver=mybox.example.org; client-ip=192.0.2.1; envelope-from=\"myname@example.com\"; helo=foo.example.com;" {
+		t.Errorf("unexpected string: %q", s)
+	}
+	if s := got.ResultInfo().String(); s != "spf=pass (mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender) smtp.mailfrom=myname@example.com" {
+		t.Errorf("unexpected resinfo: %q", s)
+	}
+
+	helo, err := ParseReceivedSPF("softfail identity=helo; helo=mx.example.net; client-ip=\"2001:db8::1\"")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if helo.ClientIP != "2001:db8::1" {
+		t.Errorf("expected unquoted client-ip, got %q", helo.ClientIP)
+	}
+	if s := helo.ResultInfo().String(); s != "spf=softfail smtp.helo=mx.example.net" {
+		t.Errorf("unexpected resinfo: %q", s)
+	}
+	if s := helo.String(); s != `softfail client-ip="2001:db8::1"; helo=mx.example.net; identity=helo;` {
+		t.Errorf("unexpected string: %q", s)
+	}
+
+	for _, v := range []string{"", "(comment only)", "pass receiver"} {
+		if _, err := ParseReceivedSPF(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package authres
+
+import (
+	"sort"
+	"strings"
+)
+
+// Ordering はresinfoとプロパティを並べる順序
+// ARC-Authentication-Resultsは署名の対象になるため、同じ結果からは常に同じ文字列を作る必要がある
+type Ordering struct {
+	// Methods は認証方式の順序
+	// 含まれない認証方式は後ろに並べ、同じ認証方式の結果は元の順を保つ
+	Methods []Method
+	// PropertyTypes はプロパティの種類(ptype)の順序
+	// 含まれない種類は後ろに並べ、同じ種類のプロパティは元の順を保つ
+	PropertyTypes []PropertyType
+}
+
+// DefaultOrdering はOrderingを指定しない場合の順序
+// auth, spf, dkim, arc, dmarc, iprev の順で、プロパティは smtp, header, body, policy の順
+var DefaultOrdering = Ordering{
+	Methods:       []Method{MethodAuth, MethodSPF, MethodDKIM, MethodARC, MethodDMARC, MethodIPRev},
+	PropertyTypes: []PropertyType{PropertyTypeSMTP, PropertyTypeHeader, PropertyTypeBody, PropertyTypePolicy},
+}
+
+// Sort はresultsを順序に従って並べた新しいスライスを返す
+// 各ResultInfoはプロパティを並べ替えたコピーで、resultsは変更しない
+// nilの要素は取り除く
+func (o *Ordering) Sort(results []*ResultInfo) []*ResultInfo {
+	if o == nil {
+		o = &DefaultOrdering
+	}
+	ret := make([]*ResultInfo, 0, len(results))
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		ri := *r
+		ri.Properties = append([]Property(nil), r.Properties...)
+		sort.SliceStable(ri.Properties, func(i, j int) bool {
+			return o.propertyRank(ri.Properties[i].Type) < o.propertyRank(ri.Properties[j].Type)
+		})
+		ret = append(ret, &ri)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return o.methodRank(ret[i].Method) < o.methodRank(ret[j].Method)
+	})
+	return ret
+}
+
+// Format はresultsを順序に従って並べ、Formatと同じ形式で返す
+func (o *Ordering) Format(authservID string, results ...*ResultInfo) string {
+	return Format(authservID, o.Sort(results)...)
+}
+
+func (o *Ordering) methodRank(m Method) int {
+	for i, v := range o.Methods {
+		if strings.EqualFold(string(v), string(m)) {
+			return i
+		}
+	}
+	return len(o.Methods)
+}
+
+func (o *Ordering) propertyRank(t PropertyType) int {
+	for i, v := range o.PropertyTypes {
+		if strings.EqualFold(string(v), string(t)) {
+			return i
+		}
+	}
+	return len(o.PropertyTypes)
+}
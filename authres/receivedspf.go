@@ -0,0 +1,113 @@
+package authres
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReceivedSPF はReceived-SPFヘッダ(RFC 7208 9.1)の値
+// 拡張のキーなど、以下のフィールド以外のキーは解析時に無視する
+type ReceivedSPF struct {
+	Result       Result
+	Comment      string // 結果の後のコメント(括弧は含まない)
+	Receiver     string // receiver=
+	ClientIP     string // client-ip=
+	EnvelopeFrom string // envelope-from=
+	Helo         string // helo=
+	Identity     string // identity= (mailfrom, helo など)
+	Mechanism    string // mechanism=
+	Problem      string // problem=
+}
+
+// ParseReceivedSPF はReceived-SPFヘッダの値(ヘッダ名は含まない)を解析する
+// 結果は小文字にし、最初のコメントをCommentとする
+func ParseReceivedSPF(value string) (*ReceivedSPF, error) {
+	s, comments := stripComments(value)
+	s = strings.TrimSpace(s)
+	result := s
+	if i := strings.IndexAny(s, " \t\r\n;"); i >= 0 {
+		result, s = s[:i], s[i:]
+	} else {
+		s = ""
+	}
+	if result == "" {
+		return nil, errors.New("missing result")
+	}
+	r := &ReceivedSPF{Result: Result(strings.ToLower(result))}
+	if len(comments) > 0 {
+		r.Comment = comments[0]
+	}
+	for _, part := range SplitResultInfos(s) {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key-value-pair: %s", part)
+		}
+		v = unquoteValue(strings.TrimSpace(v))
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "receiver":
+			r.Receiver = v
+		case "client-ip":
+			r.ClientIP = v
+		case "envelope-from":
+			r.EnvelopeFrom = v
+		case "helo":
+			r.Helo = v
+		case "identity":
+			r.Identity = v
+		case "mechanism":
+			r.Mechanism = v
+		case "problem":
+			r.Problem = v
+		}
+	}
+	return r, nil
+}
+
+// String はReceived-SPFヘッダの値を result (comment) key=value; ... の形式で返す
+// 空のフィールドは記載しない。長い場合はheaderfold.Foldで折り返す
+func (r *ReceivedSPF) String() string {
+	var b strings.Builder
+	b.WriteString(string(r.Result))
+	if r.Comment != "" {
+		fmt.Fprintf(&b, " (%s)", escapeComment(r.Comment))
+	}
+	for _, kv := range [][2]string{
+		{"receiver", r.Receiver},
+		{"client-ip", r.ClientIP},
+		{"envelope-from", r.EnvelopeFrom},
+		{"helo", r.Helo},
+		{"identity", r.Identity},
+		{"mechanism", r.Mechanism},
+		{"problem", r.Problem},
+	} {
+		if kv[1] != "" {
+			fmt.Fprintf(&b, " %s=%s;", kv[0], quoteDotAtom(kv[1]))
+		}
+	}
+	return b.String()
+}
+
+// ResultInfo はAuthentication-Resultsと同じ形式のspfの結果を返す
+// identityがheloの場合、またはenvelope-fromがない場合はsmtp.heloとして記載する
+func (r *ReceivedSPF) ResultInfo() *ResultInfo {
+	ri := &ResultInfo{Method: MethodSPF, Result: r.Result, Comment: r.Comment}
+	if strings.EqualFold(r.Identity, "helo") || r.EnvelopeFrom == "" {
+		return ri.AddProperty(PropertyTypeSMTP, "helo", r.Helo)
+	}
+	return ri.AddProperty(PropertyTypeSMTP, "mailfrom", r.EnvelopeFrom)
+}
+
+// dot-atomでない値はquoted-stringにする (RFC 7208 9.1 key-value-pair)
+func quoteDotAtom(v string) string {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),:;<>@[\]`, c) >= 0 {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	if v == "" || strings.HasPrefix(v, ".") || strings.HasSuffix(v, ".") || strings.Contains(v, "..") {
+		return `"` + v + `"`
+	}
+	return v
+}
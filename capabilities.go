@@ -0,0 +1,147 @@
+package mmauth
+
+import (
+	"runtime/debug"
+	"strings"
+
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/spf"
+)
+
+// modulePath はビルド情報からライブラリのバージョンを探すためのモジュールパス
+const modulePath = "github.com/masa23/mmauth"
+
+// Protocol は対応している認証方式の情報
+type Protocol struct {
+	// Name は認証方式の名前 (dkim, arc, spf, dmarc)
+	Name string
+	// Sign は署名(ヘッダの付与)に対応しているか
+	Sign bool
+	// Verify は検証に対応しているか
+	Verify bool
+	// RFCs は準拠しているRFCの番号
+	RFCs []int
+}
+
+// Algorithm は対応している署名アルゴリズムの情報
+type Algorithm struct {
+	Name SignatureAlgorithm
+	// Deprecated は使用が推奨されていないか (rsa-sha1はRFC 8301で署名に使ってはならない)
+	Deprecated bool
+	// Experimental はRFCで定義されていない実験的なアルゴリズムか
+	// dkim.VerifyOptions.ExperimentalAlgorithms、dkim.SignerOptions.ExperimentalAlgorithmsで有効にした場合のみ使える
+	Experimental bool
+}
+
+// Defaults はライブラリのデフォルト値と処理の上限
+type Defaults struct {
+	// DKIMCanonicalization はdkim.DefaultSignerOptionsの正規化方式
+	DKIMCanonicalization string
+	// DKIMLookupParallelism はVerifyAllで鍵を並行して問い合わせる数
+	DKIMLookupParallelism int
+	// SPFMaxDNSLookups はSPFの評価でDNSルックアップを伴う項の上限
+	SPFMaxDNSLookups int
+	// SPFMaxVoidLookups はSPFの評価で応答が空だったルックアップの上限
+	SPFMaxVoidLookups int
+	// SPFMaxEvalDepth はSPFのincludeとredirectの入れ子の上限
+	SPFMaxEvalDepth int
+	// ARCMaxInstances はARCのインスタンス番号の上限
+	ARCMaxInstances int
+}
+
+// CapabilityInfo はライブラリが対応している機能の一覧
+// 管理画面の表示や機能の有効化の判定に使い、ライブラリのバージョンごとの違いを
+// アプリケーション側で持たなくてよいようにする
+type CapabilityInfo struct {
+	// Version はビルド情報から取得したライブラリのバージョン
+	// 取得できない場合(テストやreplaceでのビルドなど)は空
+	Version             string
+	Protocols           []Protocol
+	SignatureAlgorithms []Algorithm
+	Canonicalizations   []Canonicalization
+	Defaults            Defaults
+}
+
+// Capabilities はライブラリが対応している機能の一覧を返す
+// Defaultsには呼び出した時点のdkim.DefaultSignerOptionsの値が入る
+func Capabilities() *CapabilityInfo {
+	return &CapabilityInfo{
+		Version: moduleVersion(),
+		Protocols: []Protocol{
+			{Name: "dkim", Sign: true, Verify: true, RFCs: []int{6376, 6651, 8301, 8463, 8616}},
+			{Name: "arc", Sign: true, Verify: true, RFCs: []int{8617}},
+			{Name: "spf", Verify: true, RFCs: []int{7208, 8616}},
+			{Name: "dmarc", Verify: true, RFCs: []int{7489, 9091}},
+		},
+		SignatureAlgorithms: []Algorithm{
+			{Name: SignatureAlgorithmRSA_SHA256},
+			{Name: SignatureAlgorithmED25519_SHA256},
+			{Name: SignatureAlgorithmRSA_SHA1, Deprecated: true},
+			{Name: SignatureAlgorithm(dkim.SignatureAlgorithmECDSA_SHA256), Experimental: true},
+		},
+		Canonicalizations: []Canonicalization{
+			CanonicalizationSimple,
+			CanonicalizationRelaxed,
+		},
+		Defaults: Defaults{
+			DKIMCanonicalization:  dkim.DefaultSignerOptions.Canonicalization,
+			DKIMLookupParallelism: dkim.DefaultLookupParallelism,
+			SPFMaxDNSLookups:      spf.MaxDNSLookups,
+			SPFMaxVoidLookups:     spf.MaxVoidLookups,
+			SPFMaxEvalDepth:       spf.MaxEvalDepth,
+			ARCMaxInstances:       arc.MaxInstance,
+		},
+	}
+}
+
+// Protocol は名前で認証方式を探す。対応していない場合はnil
+// 名前の大文字と小文字は区別しない
+func (c *CapabilityInfo) Protocol(name string) *Protocol {
+	if c == nil {
+		return nil
+	}
+	for i := range c.Protocols {
+		if strings.EqualFold(c.Protocols[i].Name, name) {
+			return &c.Protocols[i]
+		}
+	}
+	return nil
+}
+
+// SupportsAlgorithm は署名アルゴリズムに対応しているかを返す
+func (c *CapabilityInfo) SupportsAlgorithm(algo SignatureAlgorithm) bool {
+	if c == nil {
+		return false
+	}
+	for _, a := range c.SignatureAlgorithms {
+		if a.Name == algo {
+			return true
+		}
+	}
+	return false
+}
+
+// ビルド情報からこのモジュールのバージョンを取得する
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == modulePath {
+		if info.Main.Version == "(devel)" {
+			return ""
+		}
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return ""
+}
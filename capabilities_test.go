@@ -0,0 +1,108 @@
+package mmauth
+
+import (
+	"testing"
+
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/spf"
+)
+
+func TestCapabilities(t *testing.T) {
+	c := Capabilities()
+
+	testCases := []struct {
+		name   string
+		sign   bool
+		verify bool
+		found  bool
+	}{
+		{name: "dkim", sign: true, verify: true, found: true},
+		{name: "ARC", sign: true, verify: true, found: true},
+		{name: "spf", verify: true, found: true},
+		{name: "dmarc", verify: true, found: true},
+		{name: "bimi"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := c.Protocol(tc.name)
+			if (p != nil) != tc.found {
+				t.Fatalf("want found %v, but got %v", tc.found, p != nil)
+			}
+			if p == nil {
+				return
+			}
+			if p.Sign != tc.sign || p.Verify != tc.verify {
+				t.Errorf("want sign=%v verify=%v, but got sign=%v verify=%v", tc.sign, tc.verify, p.Sign, p.Verify)
+			}
+		})
+	}
+
+	for _, algo := range []SignatureAlgorithm{SignatureAlgorithmRSA_SHA256, SignatureAlgorithmED25519_SHA256, SignatureAlgorithmRSA_SHA1} {
+		if !c.SupportsAlgorithm(algo) {
+			t.Errorf("want %s to be supported", algo)
+		}
+	}
+	if c.SupportsAlgorithm("rsa-sha512") {
+		t.Errorf("want rsa-sha512 to be unsupported")
+	}
+
+	if c.Defaults.DKIMCanonicalization != dkim.DefaultSignerOptions.Canonicalization {
+		t.Errorf("want %s, but got %s", dkim.DefaultSignerOptions.Canonicalization, c.Defaults.DKIMCanonicalization)
+	}
+	if c.Defaults.SPFMaxDNSLookups != spf.MaxDNSLookups {
+		t.Errorf("want %d, but got %d", spf.MaxDNSLookups, c.Defaults.SPFMaxDNSLookups)
+	}
+
+	// 返り値を変更しても次の呼び出しに影響しない
+	c.Protocols[0].Sign = false
+	if !Capabilities().Protocol("dkim").Sign {
+		t.Errorf("want capabilities to be independent between calls")
+	}
+}
+
+func TestCapabilitiesDKIMAlgorithms(t *testing.T) {
+	c := Capabilities()
+	// dkimパッケージが定義しているアルゴリズムはすべて一覧に含まれること
+	algorithms := map[dkim.SignatureAlgorithm]Algorithm{
+		dkim.SignatureAlgorithmRSA_SHA1:       {Deprecated: true},
+		dkim.SignatureAlgorithmRSA_SHA256:     {},
+		dkim.SignatureAlgorithmED25519_SHA256: {},
+		dkim.SignatureAlgorithmECDSA_SHA256:   {Experimental: true},
+	}
+	for algo, want := range algorithms {
+		var got *Algorithm
+		for i := range c.SignatureAlgorithms {
+			if string(c.SignatureAlgorithms[i].Name) == string(algo) {
+				got = &c.SignatureAlgorithms[i]
+			}
+		}
+		if got == nil {
+			t.Errorf("want %s to be listed", algo)
+			continue
+		}
+		if got.Deprecated != want.Deprecated || got.Experimental != want.Experimental {
+			t.Errorf("%s: want deprecated=%v experimental=%v, but got deprecated=%v experimental=%v",
+				algo, want.Deprecated, want.Experimental, got.Deprecated, got.Experimental)
+		}
+	}
+	if len(c.SignatureAlgorithms) != len(algorithms) {
+		t.Errorf("want %d algorithms, but got %d", len(algorithms), len(c.SignatureAlgorithms))
+	}
+
+	for _, tc := range []struct {
+		protocol string
+		rfc      int
+	}{
+		{"dkim", 6651},
+		{"dkim", 8616},
+		{"spf", 8616},
+	} {
+		found := false
+		for _, rfc := range c.Protocol(tc.protocol).RFCs {
+			found = found || rfc == tc.rfc
+		}
+		if !found {
+			t.Errorf("want %s to list RFC %d", tc.protocol, tc.rfc)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package dkim
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// AUIDPolicy はi=(AUID)とFromのアドレスの照合の扱い
+// ユーザーごとにi=を付けて署名する運用で、署名者とFromの送信者が一致するかを確かめる
+type AUIDPolicy string
+
+const (
+	// AUIDIgnore は照合しない(デフォルト)
+	AUIDIgnore AUIDPolicy = ""
+	// AUIDAnnotate は照合の結果を検証結果に注記する
+	AUIDAnnotate AUIDPolicy = "annotate"
+	// AUIDEnforce は注記を付け、一致しない場合は検証に成功していてもpolicyにする
+	AUIDEnforce AUIDPolicy = "enforce"
+)
+
+// i=とFromの照合結果の注記
+const (
+	// AUIDAlignedAnnotation はi=のローカルパートとドメインがFromのアドレスと一致した署名の注記
+	AUIDAlignedAnnotation = "auid:aligned"
+	// AUIDMismatchAnnotation はi=がFromのアドレスと一致しなかった署名の注記
+	AUIDMismatchAnnotation = "auid:mismatch"
+)
+
+// i=にローカルパートがある署名について、VerifyOptions.AUIDPolicyに従ってFromのアドレスと照合する
+// ローカルパートは大文字小文字を区別し(RFC 5321 2.4)、ドメインはU-labelとA-labelを同じものとして比較する
+// i=にローカルパートがない(ドメインのみの)署名は照合しない
+func (d *Signature) applyAUIDPolicy(result *VerifyResult, headers []string, opts *VerifyOptions) {
+	if opts.AUIDPolicy == AUIDIgnore || result == nil {
+		return
+	}
+	local := d.identityLocalPart()
+	if local == "" {
+		return
+	}
+	_, auidDomain, _ := strings.Cut(d.Identity, "@")
+	if fromLocal, fromDomain, ok := signedFromAddress(headers); ok && local == fromLocal && sameDomain(auidDomain, fromDomain) {
+		result.annotations = append(result.annotations, AUIDAlignedAnnotation)
+		return
+	}
+	result.annotations = append(result.annotations, AUIDMismatchAnnotation)
+	if opts.AUIDPolicy == AUIDEnforce && result.status == VerifyStatusPass {
+		result.status = VerifyStatusPolicy
+		result.err = errcode.Errorf(errcode.DKIMPolicyAUIDMismatch, "identity does not match From address: i=%s", d.Identity)
+		result.msg = "identity does not match From address"
+	}
+}
+
+// 署名の対象になる(最も下の)Fromヘッダのアドレスをローカルパートとドメインに分けて返す
+func signedFromAddress(headers []string) (string, string, bool) {
+	from := header.ExtractHeadersDKIM(headers, []string{"From"})
+	if len(from) == 0 {
+		return "", "", false
+	}
+	_, value, _ := strings.Cut(from[0], ":")
+	addr := header.ParseAddress(strings.TrimSpace(value))
+	at := strings.LastIndex(addr, "@")
+	if at <= 0 {
+		return "", "", false
+	}
+	return addr[:at], addr[at+1:], true
+}
@@ -0,0 +1,106 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"reflect"
+	"testing"
+
+	"github.com/masa23/mmauth/errcode"
+)
+
+func TestAUIDPolicy(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	testCases := []struct {
+		name            string
+		from            string
+		identity        string
+		policy          AUIDPolicy
+		wantStatus      VerifyStatus
+		wantAnnotations []string
+		wantCode        errcode.Code
+	}{
+		{name: "ignored by default", from: "bob@example.com", identity: "alice@example.com", wantStatus: VerifyStatusPass},
+		{name: "aligned", from: "Alice <alice@example.com>", identity: "alice@example.com", policy: AUIDAnnotate, wantStatus: VerifyStatusPass, wantAnnotations: []string{AUIDAlignedAnnotation}},
+		{name: "domain case", from: "alice@EXAMPLE.com", identity: "alice@example.com", policy: AUIDAnnotate, wantStatus: VerifyStatusPass, wantAnnotations: []string{AUIDAlignedAnnotation}},
+		{name: "local-part case", from: "Alice@example.com", identity: "alice@example.com", policy: AUIDAnnotate, wantStatus: VerifyStatusPass, wantAnnotations: []string{AUIDMismatchAnnotation}},
+		{name: "annotate mismatch", from: "bob@example.com", identity: "alice@example.com", policy: AUIDAnnotate, wantStatus: VerifyStatusPass, wantAnnotations: []string{AUIDMismatchAnnotation}},
+		{name: "subdomain", from: "alice@example.com", identity: "alice@mail.example.com", policy: AUIDAnnotate, wantStatus: VerifyStatusPass, wantAnnotations: []string{AUIDMismatchAnnotation}},
+		{name: "enforce mismatch", from: "bob@example.com", identity: "alice@example.com", policy: AUIDEnforce, wantStatus: VerifyStatusPolicy, wantAnnotations: []string{AUIDMismatchAnnotation}, wantCode: errcode.DKIMPolicyAUIDMismatch},
+		{name: "enforce aligned", from: "alice@example.com", identity: "alice@example.com", policy: AUIDEnforce, wantStatus: VerifyStatusPass, wantAnnotations: []string{AUIDAlignedAnnotation}},
+		{name: "no local-part", from: "bob@example.com", identity: "@example.com", policy: AUIDEnforce, wantStatus: VerifyStatusPass},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := []string{
+				"From: " + tc.from + "\r\n",
+				"Subject: test\r\n",
+			}
+			signer := &Signature{
+				Version:   1,
+				BodyHash:  bodyHash,
+				Domain:    "example.com",
+				Identity:  tc.identity,
+				Selector:  "selector",
+				Timestamp: 1706971004,
+			}
+			if err := signer.Sign(headers, privateKey); err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			result := sig.Evaluate(headers, bodyHash, nil, &VerifyOptions{Resolver: resolver, AUIDPolicy: tc.policy})
+			if result.Status() != tc.wantStatus {
+				t.Errorf("want %v, but got %v (%v)", tc.wantStatus, result.Status(), result.Error())
+			}
+			if got := result.Annotations(); !reflect.DeepEqual(got, tc.wantAnnotations) && (len(got) != 0 || len(tc.wantAnnotations) != 0) {
+				t.Errorf("want %v, but got %v", tc.wantAnnotations, got)
+			}
+			if tc.wantCode != "" && result.Code() != tc.wantCode {
+				t.Errorf("want %s, but got %s", tc.wantCode, result.Code())
+			}
+		})
+	}
+}
+
+func TestVerifyAllAUIDPolicy(t *testing.T) {
+	body := []byte("body\r\n")
+	headers, resolver := newBulkTestMessage(t, 1, body)
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	(*sigs)[0].Identity = "alice@example.com"
+	opts := NewVerifyOptions(WithResolver(resolver))
+	opts.AUIDPolicy = AUIDAnnotate
+	// bh=が一致しない署名にもEvaluateと同じ注記を付ける
+	if err := sigs.VerifyAll(headers, bytes.NewReader([]byte("tampered\r\n")), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := (*sigs)[0].VerifyResult
+	want := (*sigs)[0].Evaluate(headers, "invalid", nil, opts)
+	if got.Status() != VerifyStatusFail {
+		t.Errorf("want %s, but got %s", VerifyStatusFail, got.Status())
+	}
+	if !reflect.DeepEqual(got.Annotations(), want.Annotations()) || len(got.Annotations()) == 0 {
+		t.Errorf("want %v, but got %v", want.Annotations(), got.Annotations())
+	}
+}
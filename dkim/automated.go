@@ -0,0 +1,97 @@
+package dkim
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// AutomatedKind は人が送ったものではない自動送信のメッセージの種類
+type AutomatedKind string
+
+const (
+	// AutomatedNone は自動送信のメッセージではない
+	AutomatedNone AutomatedKind = ""
+	// AutomatedBounce はエンベロープの送信者が空(<>)のメッセージや配送状態通知(DSN)
+	AutomatedBounce AutomatedKind = "bounce"
+	// AutomatedAutoReplied は不在通知などの自動応答 (RFC 3834 Auto-Submitted: auto-replied)
+	AutomatedAutoReplied AutomatedKind = "auto-replied"
+	// AutomatedAutoGenerated はその他の自動生成されたメッセージ (RFC 3834 Auto-Submitted: auto-generated など)
+	AutomatedAutoGenerated AutomatedKind = "auto-generated"
+)
+
+// ErrSignSkipped はSignRules.SkipAutomatedによって署名しなかった場合のエラー
+// メッセージは署名せずにそのまま送る
+var ErrSignSkipped = errors.New("dkim: signing skipped for automated message")
+
+// DetectAutomated はヘッダとエンベロープの送信者から自動送信のメッセージの種類を判定する
+// envelopeSenderが"<>"の場合はバウンスとみなす。空の場合は送信者が不明として扱い、ヘッダのみで判定する
+// 判定の順序は、バウンス(null sender、Content-Type: multipart/report; report-type=delivery-status)、
+// Auto-Submitted(noの場合は自動送信ではない)の順
+func DetectAutomated(headers []string, envelopeSender string) AutomatedKind {
+	if strings.TrimSpace(envelopeSender) == "<>" || isDeliveryStatusReport(headers) {
+		return AutomatedBounce
+	}
+	v := headerValue(headers, "Auto-Submitted")
+	// RFC 3834 5: auto-submitted-field = "Auto-Submitted:" [CFWS] auto-submitted *(CFWS optional-parameter)
+	if i := strings.IndexAny(v, ";("); i >= 0 {
+		v = v[:i]
+	}
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "", "no":
+		return AutomatedNone
+	case "auto-replied":
+		return AutomatedAutoReplied
+	}
+	return AutomatedAutoGenerated
+}
+
+// Automated は入力のメッセージの自動送信の種類を返す
+func (in SignInput) Automated() AutomatedKind {
+	return DetectAutomated(in.Headers, in.EnvelopeSender)
+}
+
+// Skip はSkipAutomatedに従って署名しないメッセージかを返す
+// MatchとSignConfig.Signで署名する場合に、署名の前に確認する
+func (s *SignRules) Skip(in SignInput) (AutomatedKind, bool) {
+	kind := in.Automated()
+	if kind == AutomatedNone {
+		return kind, false
+	}
+	for _, k := range s.SkipAutomated {
+		if k == kind {
+			return kind, true
+		}
+	}
+	return kind, false
+}
+
+func (s *SignRules) checkSkip(in SignInput) error {
+	if kind, skip := s.Skip(in); skip {
+		return fmt.Errorf("%w: %s", ErrSignSkipped, kind)
+	}
+	return nil
+}
+
+// 配送状態通知(RFC 3464)のContent-Typeか
+func isDeliveryStatusReport(headers []string) bool {
+	mediaType, params, err := mime.ParseMediaType(headerValue(headers, "Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "multipart/report" && strings.EqualFold(params["report-type"], "delivery-status")
+}
+
+// 最初に見つかったヘッダの値(継続行を展開したもの)
+func headerValue(headers []string, name string) string {
+	h := header.ExtractHeader(headers, name)
+	if h == "" {
+		return ""
+	}
+	_, v, _ := strings.Cut(h, ":")
+	v = strings.NewReplacer("\r\n", "", "\n", "").Replace(v)
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,138 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func TestDetectAutomated(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers []string
+		sender  string
+		want    AutomatedKind
+	}{
+		{
+			name:    "ordinary message",
+			headers: []string{"From: a@example.com\r\n", "Subject: hello\r\n"},
+			sender:  "a@example.com",
+			want:    AutomatedNone,
+		},
+		{
+			name:    "unknown sender",
+			headers: []string{"From: a@example.com\r\n"},
+			want:    AutomatedNone,
+		},
+		{
+			name:    "null sender",
+			headers: []string{"From: MAILER-DAEMON@example.com\r\n"},
+			sender:  "<>",
+			want:    AutomatedBounce,
+		},
+		{
+			name: "delivery status notification",
+			headers: []string{
+				"From: MAILER-DAEMON@example.com\r\n",
+				"Content-Type: multipart/report;\r\n\treport-type=\"delivery-status\"; boundary=\"b\"\r\n",
+			},
+			sender: "bounce@example.com",
+			want:   AutomatedBounce,
+		},
+		{
+			name:    "disposition notification is not a bounce",
+			headers: []string{"Content-Type: multipart/report; report-type=disposition-notification; boundary=b\r\n"},
+			sender:  "a@example.com",
+			want:    AutomatedNone,
+		},
+		{
+			name:    "auto-replied",
+			headers: []string{"Auto-Submitted: Auto-Replied (vacation)\r\n"},
+			sender:  "a@example.com",
+			want:    AutomatedAutoReplied,
+		},
+		{
+			name:    "auto-generated",
+			headers: []string{"Auto-Submitted: auto-generated\r\n"},
+			sender:  "a@example.com",
+			want:    AutomatedAutoGenerated,
+		},
+		{
+			name:    "auto-notified",
+			headers: []string{"Auto-Submitted: auto-notified; owner-email=\"a@example.com\"\r\n"},
+			sender:  "a@example.com",
+			want:    AutomatedAutoGenerated,
+		},
+		{
+			name:    "explicitly not automated",
+			headers: []string{"Auto-Submitted: no\r\n"},
+			sender:  "a@example.com",
+			want:    AutomatedNone,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DetectAutomated(tc.headers, tc.sender); got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSignRulesSkipAutomated(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	rules := &SignRules{
+		Default: &SignConfig{
+			Domain:   "example.com",
+			Selector: "selector",
+			Key:      priv.(*rsa.PrivateKey),
+		},
+		SkipAutomated: []AutomatedKind{AutomatedBounce, AutomatedAutoReplied},
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	testCases := []struct {
+		name string
+		in   SignInput
+		err  error
+	}{
+		{
+			name: "ordinary message",
+			in:   SignInput{Headers: []string{"From: a@example.com\r\n"}, EnvelopeSender: "a@example.com"},
+		},
+		{
+			name: "bounce",
+			in:   SignInput{Headers: []string{"From: MAILER-DAEMON@example.com\r\n"}, EnvelopeSender: "<>"},
+			err:  ErrSignSkipped,
+		},
+		{
+			name: "auto-replied",
+			in:   SignInput{Headers: []string{"From: a@example.com\r\n", "Auto-Submitted: auto-replied\r\n"}, EnvelopeSender: "a@example.com"},
+			err:  ErrSignSkipped,
+		},
+		{
+			name: "auto-generated is still signed",
+			in:   SignInput{Headers: []string{"From: a@example.com\r\n", "Auto-Submitted: auto-generated\r\n"}, EnvelopeSender: "a@example.com"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := rules.Sign(tc.in, bodyHash)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("want error %v, but got %v", tc.err, err)
+			}
+			if _, skip := rules.Skip(tc.in); skip != (tc.err != nil) {
+				t.Errorf("want skip %v, but got %v", tc.err != nil, skip)
+			}
+			if tc.err == nil && sig == nil {
+				t.Error("want signature, but got nil")
+			}
+		})
+	}
+}
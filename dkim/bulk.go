@@ -0,0 +1,183 @@
+package dkim
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/internal/bodyhash"
+)
+
+// ボディーハッシュを共有できる署名の組み合わせ
+type bodyHashKey struct {
+	canon Canonicalization
+	hash  crypto.Hash
+	limit int64
+}
+
+// VerifyAll はメッセージに付与された複数のDKIM署名をまとめて検証する
+// bodyはヘッダを除いた本文で、一度だけ読み込む
+// 本文の正規化方式・ハッシュアルゴリズム・l=が同じ署名ではボディーハッシュを共有し、
+// bh=が一致しない署名は署名の検証を行わずにfailとする
+// (このため鍵が存在しない署名でもbh=が一致しなければpermerrorではなくfailになる)
+// 鍵はr=yの署名でReportHookが設定されている場合にだけ、失敗レポートのために問い合わせる
+// 注記や結果の変更はEvaluateと同じく適用する
+// d=・s=・a=が同じでb=が異なる署名が複数ある場合は、それぞれの検証結果に
+// "duplicate-signature:<d>/<s>" の注記を付ける(リプレイやヘッダの挿入の可能性がある)
+// 本文の最後の行の改行の扱いはopts.FinalCRLFに従う
+// 鍵のレコードは検証の前にopts.LookupParallelismの数まで並行して問い合わせる
+// opts.MaxSignaturesとMaxSignatureSizeを超える署名は本文のハッシュの計算も含めて検証せず、
+// 検証した署名の結果に "signatures-skipped:<n>" の注記を付ける
+// optsがnilの場合はVerifyと同じ
+func (d *Signatures) VerifyAll(headers []string, body io.Reader, opts *VerifyOptions) error {
+	if d == nil || len(*d) == 0 {
+		return nil
+	}
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	skipped := d.ApplyLimits(opts)
+	targets := *d
+	if skipped > 0 {
+		targets = d.WithinLimits()
+	}
+
+	hashers := make(map[bodyHashKey]*bodyhash.BodyHash)
+	var writers []io.Writer
+	for _, sig := range targets {
+		if sig == nil || sig.canonnAndAlgo == nil {
+			continue
+		}
+		key := sig.bodyHashKey()
+		if _, ok := hashers[key]; ok {
+			continue
+		}
+		bh := opts.pool.newBodyHash(key, opts.FinalCRLF)
+		hashers[key] = bh
+		writers = append(writers, bh)
+	}
+	if len(writers) > 0 {
+		if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+	}
+	bodyHashes := make(map[bodyHashKey]string, len(hashers))
+	for key, bh := range hashers {
+		bh.Close()
+		bodyHashes[key] = bh.Get()
+		bh.Release()
+	}
+
+	// 複数のドメインの鍵が必要な場合は検証の前に並行して問い合わせる
+	parallelism := opts.LookupParallelism
+	if parallelism == 0 {
+		parallelism = DefaultLookupParallelism
+	}
+	if names := targets.keyNames(bodyHashes, opts); parallelism > 1 && len(names) > 1 {
+		resolver := opts.Resolver
+		if resolver == nil {
+			resolver = domainkey.NewDefaultTXTResolver()
+		}
+		prefetched := *opts
+		prefetched.Resolver = prefetchKeys(names, resolver, parallelism)
+		opts = &prefetched
+	}
+
+	for _, sig := range targets {
+		if sig == nil {
+			continue
+		}
+		if sig.canonnAndAlgo == nil {
+			sig.VerifyResult = sig.Evaluate(headers, "", nil, opts)
+			continue
+		}
+		computed := bodyHashes[sig.bodyHashKey()]
+		if !bodyhash.Equal(sig.BodyHash, computed) {
+			result := &VerifyResult{
+				status: VerifyStatusFail,
+				err:    errcode.Errorf(errcode.DKIMFailBodyHash, "DKIM-Signature body hash is not match: %s != %s", sig.BodyHash, computed),
+				msg:    "body hash is not match",
+			}
+			if sig.wantsReport(opts) {
+				// 失敗レポートの送信先を得るために鍵を問い合わせる
+				result.domainKey = sig.lookupReportKey(opts)
+			}
+			sig.applyPolicies(result, headers, opts)
+			sig.VerifyResult = result
+			continue
+		}
+		sig.VerifyResult = sig.Evaluate(headers, computed, nil, opts)
+	}
+
+	for _, group := range d.DuplicateSignatures() {
+		for _, sig := range group {
+			if sig.VerifyResult == nil {
+				continue
+			}
+			sig.VerifyResult.annotations = append(sig.VerifyResult.annotations,
+				"duplicate-signature:"+strings.ToLower(sig.Domain)+"/"+strings.ToLower(sig.Selector))
+		}
+	}
+	d.AnnotateSkipped(skipped)
+	return nil
+}
+
+// 重複を判定する署名のキー
+type signatureKey struct {
+	domain    string
+	selector  string
+	algorithm SignatureAlgorithm
+}
+
+// DuplicateSignatures はd=・s=・a=が同じでb=が異なる署名の組をメッセージ中の順に返す
+// b=まで同じ署名(同じヘッダの重複)は対象外
+func (d *Signatures) DuplicateSignatures() [][]*Signature {
+	if d == nil {
+		return nil
+	}
+	groups := make(map[signatureKey][]*Signature)
+	var order []signatureKey
+	for _, sig := range *d {
+		if sig == nil {
+			continue
+		}
+		key := signatureKey{
+			domain:    strings.ToLower(strings.TrimSuffix(sig.Domain, ".")),
+			selector:  strings.ToLower(sig.Selector),
+			algorithm: sig.Algorithm,
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], sig)
+	}
+	var ret [][]*Signature
+	for _, key := range order {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		distinct := false
+		for _, sig := range group[1:] {
+			if sig.Signature != group[0].Signature {
+				distinct = true
+				break
+			}
+		}
+		if distinct {
+			ret = append(ret, group)
+		}
+	}
+	return ret
+}
+
+func (d *Signature) bodyHashKey() bodyHashKey {
+	return bodyHashKey{
+		canon: d.canonnAndAlgo.Body,
+		hash:  d.canonnAndAlgo.HashAlgo,
+		limit: d.Limit,
+	}
+}
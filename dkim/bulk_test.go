@@ -0,0 +1,441 @@
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/internal/bodyhash"
+)
+
+// 問い合わせ回数を数えるリゾルバー
+type countingResolver struct {
+	*MockTXTResolver
+	count int
+}
+
+func (c *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	c.count++
+	return c.MockTXTResolver.LookupTXT(ctx, name)
+}
+
+func newBulkTestMessage(tb testing.TB, n int, body []byte) ([]string, *countingResolver) {
+	tb.Helper()
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		tb.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		tb.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := &countingResolver{MockTXTResolver: NewMockTXTResolver()}
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+
+	bh := bodyhash.NewBodyHash(CanonicalizationRelaxed, hashAlgo(SignatureAlgorithmRSA_SHA256), 0)
+	bh.Write(body)
+	bh.Close()
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	var sigHeaders []string
+	for i := 0; i < n; i++ {
+		signer := &Signature{
+			Version:          1,
+			Algorithm:        SignatureAlgorithmRSA_SHA256,
+			BodyHash:         bh.Get(),
+			Canonicalization: "relaxed/relaxed",
+			Domain:           "example.com",
+			Selector:         "selector",
+			Timestamp:        1706971004 + int64(i),
+		}
+		if err := signer.Sign(headers, privateKey); err != nil {
+			tb.Fatalf("failed to sign: %v", err)
+		}
+		sigHeaders = append(sigHeaders, "DKIM-Signature: "+signer.String()+"\r\n")
+	}
+	return append(sigHeaders, headers...), resolver
+}
+
+func TestVerifyAll(t *testing.T) {
+	body := []byte("body\r\n")
+	headers, resolver := newBulkTestMessage(t, 2, body)
+	// bh=が一致しない署名(鍵も存在しない)を追加する
+	headers = append([]string{
+		"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.net; s=missing; h=From:Subject; bh=AAAA; b=AAAA\r\n",
+	}, headers...)
+
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	if err := sigs.VerifyAll(headers, bytes.NewReader(body), &VerifyOptions{Resolver: resolver}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []VerifyStatus{VerifyStatusFail, VerifyStatusPass, VerifyStatusPass}
+	for i, sig := range *sigs {
+		if sig.VerifyResult.Status() != expected[i] {
+			t.Errorf("signature %d: want %s, but got %s (%v)", i, expected[i], sig.VerifyResult.Status(), sig.VerifyResult.Error())
+		}
+	}
+	if resolver.count != 2 {
+		t.Errorf("want 2 lookups, but got %d", resolver.count)
+	}
+}
+
+func TestVerifyAllLimits(t *testing.T) {
+	body := []byte("body\r\n")
+	headers, resolver := newBulkTestMessage(t, 3, body)
+	// 大きすぎる署名(鍵も存在しない)を先頭に追加する
+	headers = append([]string{
+		"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.net; s=large; h=From:Subject; bh=AAAA; b=AAAA; zz=" +
+			strings.Repeat("a", 900) + "\r\n",
+	}, headers...)
+
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	opts := &VerifyOptions{Resolver: resolver, MaxSignatures: 2, MaxSignatureSize: 800}
+	if err := sigs.VerifyAll(headers, bytes.NewReader(body), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		status     VerifyStatus
+		annotation string
+	}{
+		{status: VerifyStatusPolicy, annotation: SignatureLimitSizeAnnotation},
+		{status: VerifyStatusPass, annotation: "signatures-skipped:2"},
+		{status: VerifyStatusPass, annotation: "signatures-skipped:2"},
+		{status: VerifyStatusPolicy, annotation: SignatureLimitCountAnnotation},
+	}
+	for i, tc := range testCases {
+		result := (*sigs)[i].VerifyResult
+		if result.Status() != tc.status {
+			t.Errorf("signature %d: want %s, but got %s (%v)", i, tc.status, result.Status(), result.Error())
+		}
+		found := false
+		for _, a := range result.Annotations() {
+			if a == tc.annotation {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("signature %d: want annotation %s, but got %v", i, tc.annotation, result.Annotations())
+		}
+	}
+	if (*sigs)[3].VerifyResult.Code() != errcode.DKIMPolicySignatureLimit {
+		t.Errorf("want %s, but got %s", errcode.DKIMPolicySignatureLimit, (*sigs)[3].VerifyResult.Code())
+	}
+	// 検証しなかった署名の鍵は問い合わせない
+	if resolver.count != 2 {
+		t.Errorf("want 2 lookups, but got %d", resolver.count)
+	}
+}
+
+func TestVerifyAllDuplicateSignatures(t *testing.T) {
+	body := []byte("body\r\n")
+	headers, resolver := newBulkTestMessage(t, 2, body)
+	// d=・s=・a=が異なる署名は対象外
+	headers = append([]string{
+		"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.net; s=other; h=From:Subject; bh=AAAA; b=AAAA\r\n",
+	}, headers...)
+
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	dups := sigs.DuplicateSignatures()
+	if len(dups) != 1 || len(dups[0]) != 2 {
+		t.Fatalf("want one pair of duplicate signatures, but got %v", dups)
+	}
+	if err := sigs.VerifyAll(headers, bytes.NewReader(body), &VerifyOptions{Resolver: resolver}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := [][]string{nil, {"duplicate-signature:example.com/selector"}, {"duplicate-signature:example.com/selector"}}
+	for i, sig := range *sigs {
+		if !reflect.DeepEqual(sig.VerifyResult.Annotations(), expected[i]) {
+			t.Errorf("signature %d: want %v, but got %v", i, expected[i], sig.VerifyResult.Annotations())
+		}
+	}
+
+	// 同じ署名ヘッダが重複しているだけの場合は対象外
+	same := []string{headers[1], headers[1], headers[3], headers[4]}
+	sigs, err = ParseDKIMHeaders(same)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	if dups := sigs.DuplicateSignatures(); len(dups) != 0 {
+		t.Errorf("want no duplicates, but got %v", dups)
+	}
+}
+
+func TestVerifyAllFinalCRLF(t *testing.T) {
+	// 最後の行が改行で終わらない本文にCRLFを補って署名する
+	body := []byte("body")
+	headers, resolver := newBulkTestMessage(t, 1, body)
+
+	testCases := []struct {
+		name      string
+		finalCRLF FinalCRLF
+		want      VerifyStatus
+	}{
+		{"pad", FinalCRLFPad, VerifyStatusPass},
+		{"as-is", FinalCRLFAsIs, VerifyStatusFail},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sigs, err := ParseDKIMHeaders(headers)
+			if err != nil {
+				t.Fatalf("failed to parse headers: %v", err)
+			}
+			opts := NewVerifyOptions(WithResolver(resolver), WithFinalCRLF(tc.finalCRLF))
+			if err := sigs.VerifyAll(headers, bytes.NewReader(body), opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sig := (*sigs)[0]
+			if sig.VerifyResult.Status() != tc.want {
+				t.Errorf("want %s, but got %s (%v)", tc.want, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+			}
+		})
+	}
+}
+
+func benchmarkBody() []byte {
+	return []byte(strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit.  \r\n", 2000))
+}
+
+func BenchmarkVerifyAll(b *testing.B) {
+	body := benchmarkBody()
+	headers, resolver := newBulkTestMessage(b, 5, body)
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		b.Fatalf("failed to parse headers: %v", err)
+	}
+	opts := &VerifyOptions{Resolver: resolver}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sigs.VerifyAll(headers, bytes.NewReader(body), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Verifierのプールを使う場合との比較
+func BenchmarkVerifierVerifyAll(b *testing.B) {
+	body := benchmarkBody()
+	headers, resolver := newBulkTestMessage(b, 5, body)
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		b.Fatalf("failed to parse headers: %v", err)
+	}
+	v := NewVerifier(&VerifyOptions{Resolver: resolver})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := v.VerifyAll(sigs, headers, bytes.NewReader(body)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 署名ごとにボディーハッシュを計算する場合との比較
+func BenchmarkVerifyEach(b *testing.B) {
+	body := benchmarkBody()
+	headers, resolver := newBulkTestMessage(b, 5, body)
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		b.Fatalf("failed to parse headers: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, sig := range *sigs {
+			can := sig.GetCanonicalizationAndAlgorithm()
+			bh := bodyhash.NewBodyHash(can.Body, can.HashAlgo, sig.Limit)
+			bh.Write(body)
+			bh.Close()
+			sig.VerifyWithResolver(headers, bh.Get(), nil, resolver)
+			if sig.VerifyResult.Status() != VerifyStatusPass {
+				b.Fatal(sig.VerifyResult.Error())
+			}
+		}
+	}
+}
+
+// 同時に実行された問い合わせの数を記録するリゾルバー
+type concurrentResolver struct {
+	*MockTXTResolver
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+	calls   map[string]int
+}
+
+func (c *concurrentResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	c.mu.Lock()
+	c.active++
+	if c.active > c.maxSeen {
+		c.maxSeen = c.active
+	}
+	c.calls[name]++
+	c.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+	return c.MockTXTResolver.LookupTXT(ctx, name)
+}
+
+func TestVerifyAllParallelLookups(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	body := []byte("body\r\n")
+	bh := bodyhash.NewBodyHash(CanonicalizationRelaxed, hashAlgo(SignatureAlgorithmRSA_SHA256), 0)
+	bh.Write(body)
+	bh.Close()
+
+	domains := []string{"example.com", "example.net", "example.org", "example.com"}
+	mock := NewMockTXTResolver()
+	headers := []string{"From: hogefuga@example.com\r\n", "Subject: test\r\n"}
+	var sigHeaders []string
+	for i, domain := range domains {
+		mock.AddRecord("selector._domainkey."+domain, "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+		signer := &Signature{
+			Version:          1,
+			Algorithm:        SignatureAlgorithmRSA_SHA256,
+			BodyHash:         bh.Get(),
+			Canonicalization: "relaxed/relaxed",
+			Domain:           domain,
+			Selector:         "selector",
+			Timestamp:        1706971004 + int64(i),
+		}
+		if err := signer.Sign(headers, privateKey); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		sigHeaders = append(sigHeaders, "DKIM-Signature: "+signer.String()+"\r\n")
+	}
+	headers = append(sigHeaders, headers...)
+
+	testCases := []struct {
+		name        string
+		parallelism int
+		wantMax     int
+		wantCalls   int
+	}{
+		{name: "default", wantMax: 3, wantCalls: 1},
+		{name: "bounded", parallelism: 2, wantMax: 2, wantCalls: 1},
+		{name: "serial", parallelism: 1, wantMax: 1, wantCalls: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver := &concurrentResolver{MockTXTResolver: mock, calls: make(map[string]int)}
+			sigs, err := ParseDKIMHeaders(headers)
+			if err != nil {
+				t.Fatalf("failed to parse headers: %v", err)
+			}
+			opts := &VerifyOptions{Resolver: resolver, LookupParallelism: tc.parallelism}
+			if err := sigs.VerifyAll(headers, bytes.NewReader(body), opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i, sig := range *sigs {
+				if sig.VerifyResult.Status() != VerifyStatusPass {
+					t.Errorf("signature %d: want pass, but got %s (%v)", i, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+				}
+			}
+			if resolver.maxSeen != tc.wantMax {
+				t.Errorf("want %d concurrent lookups, but got %d", tc.wantMax, resolver.maxSeen)
+			}
+			if got := resolver.calls["selector._domainkey.example.com"]; got != tc.wantCalls {
+				t.Errorf("want %d lookups for example.com, but got %d", tc.wantCalls, got)
+			}
+		})
+	}
+}
+
+func TestVerifyAllReportHook(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	key := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal pkix public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("report._domainkey.example.com", "v=DKIM1; ra=dkim-fail; p="+base64.StdEncoding.EncodeToString(der))
+
+	headers := []string{
+		"From: bob@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	signer := &Signature{
+		Version:         1,
+		Algorithm:       SignatureAlgorithmRSA_SHA256,
+		BodyHash:        "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=",
+		Domain:          "example.com",
+		Identity:        "alice@example.com",
+		Selector:        "report",
+		ReportRequested: true,
+	}
+	if err := signer.Sign(headers, key); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	headers = append([]string{"DKIM-Signature: " + signer.String() + "\r\n"}, headers...)
+
+	calls := 0
+	opts := NewVerifyOptions(WithResolver(resolver))
+	opts.AUIDPolicy = AUIDAnnotate
+	opts.ReportHook = func(sig *Signature, req *ReportRequest, rt domainkey.ReportType) {
+		calls++
+		if req.Address != "dkim-fail@example.com" {
+			t.Errorf("want %s, but got %s", "dkim-fail@example.com", req.Address)
+		}
+	}
+
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	if err := sigs.VerifyAll(headers, strings.NewReader("tampered body\r\n"), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := (*sigs)[0].VerifyResult
+	want := (*sigs)[0].Evaluate(headers, "invalid", nil, opts)
+	if got.Status() != VerifyStatusFail || got.Code() != errcode.DKIMFailBodyHash {
+		t.Errorf("want %s (%s), but got %s (%s)", VerifyStatusFail, errcode.DKIMFailBodyHash, got.Status(), got.Code())
+	}
+	if !reflect.DeepEqual(got.Annotations(), want.Annotations()) {
+		t.Errorf("want %v, but got %v", want.Annotations(), got.Annotations())
+	}
+	// VerifyAllとEvaluateでそれぞれ1回ずつ呼ばれる
+	if calls != 2 {
+		t.Errorf("want %d hook calls, but got %d", 2, calls)
+	}
+}
@@ -0,0 +1,322 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/internal/bodyhash"
+	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// BodyTransform はボディーハッシュの診断で受信した本文に適用した変換
+type BodyTransform string
+
+const (
+	// BodyTransformStripTrailingWhitespace は各行末の空白を除去する
+	// 署名後に経路上で行末に空白が付加された場合に一致する
+	BodyTransformStripTrailingWhitespace BodyTransform = "strip-trailing-whitespace"
+	// BodyTransformLFLineEndings は正規化後の本文の改行をLFとしてハッシュを計算する
+	// 署名者が改行をCRLFに変換せずにハッシュを計算していた場合に一致する
+	// (受信側の改行コードの違いは正規化で吸収されるため、こちらのみ確認する)
+	BodyTransformLFLineEndings BodyTransform = "lf-line-endings"
+	// BodyTransformRemoveFooter は末尾の行を除去する
+	// メーリングリストなどでフッターが追加された場合に一致する
+	BodyTransformRemoveFooter BodyTransform = "remove-footer"
+	// BodyTransformUnpaddedFinalLine は正規化後の本文の末尾のCRLFを除いてハッシュを計算する
+	// 署名者が改行で終わらない最後の行にCRLFを補わなかった場合(FinalCRLFAsIs)や、
+	// その後に経路上で改行が付加された場合に一致する
+	BodyTransformUnpaddedFinalLine BodyTransform = "unpadded-final-line"
+)
+
+// DefaultDiagnoseMaxFooterLines は診断時に除去を試す末尾の行数の上限
+const DefaultDiagnoseMaxFooterLines = 30
+
+// BodyHashDiagnosis はボディーハッシュ不一致の診断結果
+type BodyHashDiagnosis struct {
+	Expected    string          // 署名のbh=
+	Computed    string          // 受信した本文から計算したボディーハッシュ
+	Matched     bool            // いずれかの変換で一致したか
+	Transforms  []BodyTransform // 一致した変換(変換なしで一致した場合は空)
+	FooterLines int             // BodyTransformRemoveFooterで除去した行数
+}
+
+// String は診断結果を人が読める形式で返す
+func (b *BodyHashDiagnosis) String() string {
+	if !b.Matched {
+		return fmt.Sprintf("body hash is not match: bh=%s computed=%s (no known transformation matched)", b.Expected, b.Computed)
+	}
+	if len(b.Transforms) == 0 {
+		return "body hash is match"
+	}
+	var desc []string
+	for _, t := range b.Transforms {
+		if t == BodyTransformRemoveFooter {
+			desc = append(desc, fmt.Sprintf("%s(%d lines)", t, b.FooterLines))
+			continue
+		}
+		desc = append(desc, string(t))
+	}
+	return fmt.Sprintf("body hash matches after %s", strings.Join(desc, ", "))
+}
+
+// DiagnoseBodyHash はボディーハッシュが一致しない場合に、
+// よくある本文の変更を元に戻す変換を適用してハッシュを再計算し、どの変換で一致するかを返す
+// bodyはヘッダを除いた受信時の本文
+func (d *Signature) DiagnoseBodyHash(body []byte) (*BodyHashDiagnosis, error) {
+	return d.DiagnoseBodyHashWithMaxFooterLines(body, DefaultDiagnoseMaxFooterLines)
+}
+
+// DiagnoseBodyHashWithMaxFooterLines はフッターとして除去を試す行数の上限を指定してDiagnoseBodyHashを行う
+func (d *Signature) DiagnoseBodyHashWithMaxFooterLines(body []byte, maxFooterLines int) (*BodyHashDiagnosis, error) {
+	canon, hash, err := d.bodyCanonicalizationAndHash()
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		transforms []BodyTransform
+		body       []byte
+		lfEndings  bool
+		unpadded   bool
+	}
+	compute := func(c candidate, b []byte) string {
+		if !c.lfEndings && !c.unpadded {
+			bh := bodyhash.NewBodyHash(canon, hash, d.Limit)
+			bh.Write(b)
+			bh.Close()
+			return bh.Get()
+		}
+		var buf bytes.Buffer
+		w := canonical.Body(&buf, canon)
+		w.Write(b)
+		w.Close()
+		out := buf.Bytes()
+		if c.unpadded {
+			out = bytes.TrimSuffix(out, []byte("\r\n"))
+		}
+		if c.lfEndings {
+			out = bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n"))
+		}
+		if d.Limit > 0 && int64(len(out)) > d.Limit {
+			out = out[:d.Limit]
+		}
+		h := hash.New()
+		h.Write(out)
+		return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+
+	candidates := []candidate{
+		{body: body},
+		{transforms: []BodyTransform{BodyTransformStripTrailingWhitespace}, body: stripTrailingWhitespace(body)},
+		{transforms: []BodyTransform{BodyTransformLFLineEndings}, body: body, lfEndings: true},
+		{transforms: []BodyTransform{BodyTransformUnpaddedFinalLine}, body: body, unpadded: true},
+	}
+	diag := &BodyHashDiagnosis{
+		Expected: d.BodyHash,
+		Computed: compute(candidates[0], body),
+	}
+	if diag.Computed == d.BodyHash {
+		diag.Matched = true
+		return diag, nil
+	}
+	for _, c := range candidates[1:] {
+		if compute(c, c.body) == d.BodyHash {
+			diag.Matched = true
+			diag.Transforms = c.transforms
+			return diag, nil
+		}
+	}
+
+	// 末尾の行を1行ずつ除去して一致するかを確認する
+	for _, c := range candidates {
+		lines := splitBodyLines(c.body)
+		for n := 1; n <= maxFooterLines && n <= len(lines); n++ {
+			trimmed := bytes.Join(lines[:len(lines)-n], nil)
+			if compute(c, trimmed) == d.BodyHash {
+				diag.Matched = true
+				diag.Transforms = append(append([]BodyTransform{}, c.transforms...), BodyTransformRemoveFooter)
+				diag.FooterLines = n
+				return diag, nil
+			}
+		}
+	}
+	return diag, nil
+}
+
+// 署名から本文の正規化方式とハッシュアルゴリズムを取得する
+func (d *Signature) bodyCanonicalizationAndHash() (canonical.Canonicalization, crypto.Hash, error) {
+	if d.canonnAndAlgo != nil {
+		return d.canonnAndAlgo.Body, d.canonnAndAlgo.HashAlgo, nil
+	}
+	_, body, err := header.ParseHeaderCanonicalization(d.Canonicalization)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to parse canonicalization: %w", err)
+	}
+	return body, hashAlgo(d.Algorithm), nil
+}
+
+// 本文を改行を含む行に分割する
+// 正規化で無視される末尾の空行は行数に数えない
+func splitBodyLines(body []byte) [][]byte {
+	trimmed := bytes.TrimRight(body, "\r\n")
+	if len(trimmed) == 0 {
+		return nil
+	}
+	return bytes.SplitAfter(trimmed, []byte("\n"))
+}
+
+// 各行末の空白(SP, HTAB)を除去する
+func stripTrailingWhitespace(body []byte) []byte {
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	var out bytes.Buffer
+	for _, line := range lines {
+		content := line
+		var eol []byte
+		switch {
+		case bytes.HasSuffix(line, []byte("\r\n")):
+			content, eol = line[:len(line)-2], line[len(line)-2:]
+		case bytes.HasSuffix(line, []byte("\n")):
+			content, eol = line[:len(line)-1], line[len(line)-1:]
+		}
+		out.Write(bytes.TrimRight(content, " \t"))
+		out.Write(eol)
+	}
+	return out.Bytes()
+}
+
+// HeaderChange はヘッダハッシュの診断で見つかった署名後のヘッダの変更
+type HeaderChange string
+
+const (
+	// HeaderChangeAdded は署名後に追加されたヘッダ
+	// そのヘッダを取り除くと検証に成功する(h=で存在しないヘッダを署名していた場合など)
+	HeaderChangeAdded HeaderChange = "added"
+	// HeaderChangeDuplicateOrder は同名のヘッダの順序の変更
+	// 末尾側ではなく先頭側のヘッダから選ぶと検証に成功する
+	HeaderChangeDuplicateOrder HeaderChange = "duplicate-order"
+)
+
+// HeaderCandidate は変更されたと推定されるヘッダ
+type HeaderCandidate struct {
+	Field  string       // ヘッダ名(小文字)
+	Index  int          // headersでの位置。HeaderChangeDuplicateOrderでは最も上の同名ヘッダの位置
+	Change HeaderChange // 推定した変更
+}
+
+func (c HeaderCandidate) String() string {
+	return fmt.Sprintf("%s(%s, index %d)", c.Field, c.Change, c.Index)
+}
+
+// HeaderHashDiagnosis はヘッダハッシュ不一致の診断結果
+type HeaderHashDiagnosis struct {
+	Matched    bool              // 変更を戻さずに検証に成功したか
+	Candidates []HeaderCandidate // 戻すと検証に成功する変更
+}
+
+// String は診断結果を人が読める形式で返す
+func (h *HeaderHashDiagnosis) String() string {
+	if h.Matched {
+		return "header hash is match"
+	}
+	if len(h.Candidates) == 0 {
+		return "header hash is not match (no added or reordered header found; a signed header value may have been altered)"
+	}
+	var desc []string
+	for _, c := range h.Candidates {
+		desc = append(desc, c.String())
+	}
+	return fmt.Sprintf("header hash matches after reverting %s", strings.Join(desc, ", "))
+}
+
+// DiagnoseHeaderHash はボディーハッシュは一致するが署名の検証に失敗する場合に、
+// 署名対象のヘッダを1つずつ取り除いた場合と、同名のヘッダを先頭側から選んだ場合に
+// 検証に成功するかを試し、署名後に変更されたと推定されるヘッダを返す
+// 署名後に値だけが書き換えられたヘッダは元の値が分からないため特定できない
+// domainKeyがnilの場合はoptsのリゾルバーで問い合わせる。ヘッダの数だけ署名を検証するため、検証に失敗した署名の調査に使う
+func (d *Signature) DiagnoseHeaderHash(headers []string, domainKey *domainkey.DomainKey, opts *VerifyOptions) (*HeaderHashDiagnosis, error) {
+	if d.canonnAndAlgo == nil {
+		return nil, errors.New("signature is not parsed")
+	}
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	var keys []domainkey.DomainKey
+	if domainKey != nil {
+		keys = []domainkey.DomainKey{*domainKey}
+	} else {
+		resolver := opts.Resolver
+		if resolver == nil {
+			resolver = domainkey.NewDefaultTXTResolver()
+		}
+		var err error
+		keys, err = domainkey.LookupDKIMDomainKeysWithResolver(d.Selector, d.Domain, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup domain key: %w", err)
+		}
+	}
+	// ボディーハッシュは一致しているものとして、ヘッダの署名のみを確認する
+	verifies := func(h []string) bool {
+		for i := range keys {
+			if d.verifyWithDomainKey(h, d.BodyHash, &keys[i], opts).status == VerifyStatusPass {
+				return true
+			}
+		}
+		return false
+	}
+
+	diag := &HeaderHashDiagnosis{}
+	if verifies(headers) {
+		diag.Matched = true
+		return diag, nil
+	}
+
+	signed := make(map[string]bool)
+	var names []string
+	for _, k := range strings.Split(d.Headers, ":") {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k != "" && !signed[k] {
+			signed[k] = true
+			names = append(names, k)
+		}
+	}
+	positions := make(map[string][]int)
+	for i, h := range headers {
+		k, _, ok := strings.Cut(h, ":")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		if signed[k] {
+			positions[k] = append(positions[k], i)
+		}
+	}
+
+	for _, name := range names {
+		for _, i := range positions[name] {
+			removed := make([]string, 0, len(headers)-1)
+			removed = append(append(removed, headers[:i]...), headers[i+1:]...)
+			if verifies(removed) {
+				diag.Candidates = append(diag.Candidates, HeaderCandidate{Field: name, Index: i, Change: HeaderChangeAdded})
+			}
+		}
+	}
+	for _, name := range names {
+		pos := positions[name]
+		if len(pos) < 2 {
+			continue
+		}
+		reordered := append([]string{}, headers...)
+		for j, i := range pos {
+			reordered[i] = headers[pos[len(pos)-1-j]]
+		}
+		if verifies(reordered) {
+			diag.Candidates = append(diag.Candidates, HeaderCandidate{Field: name, Index: pos[0], Change: HeaderChangeDuplicateOrder})
+		}
+	}
+	return diag, nil
+}
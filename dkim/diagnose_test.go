@@ -0,0 +1,228 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"reflect"
+	"testing"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/internal/bodyhash"
+	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/internal/header"
+)
+
+func TestDiagnoseBodyHash(t *testing.T) {
+	original := "Hello,\r\nThis is a test.\r\n"
+	computeBH := func(canon canonical.Canonicalization, body string) string {
+		bh := bodyhash.NewBodyHash(canon, crypto.SHA256, 0)
+		bh.Write([]byte(body))
+		bh.Close()
+		return bh.Get()
+	}
+	lfSum := sha256.Sum256([]byte("Hello,\nThis is a test.\n"))
+	lfBodyHash := base64.StdEncoding.EncodeToString(lfSum[:])
+	unpaddedSum := sha256.Sum256([]byte("Hello,\r\nThis is a test."))
+	unpaddedBodyHash := base64.StdEncoding.EncodeToString(unpaddedSum[:])
+
+	testCases := []struct {
+		name            string
+		canon           string
+		signedBH        string
+		received        string
+		wantMatched     bool
+		wantTransforms  []BodyTransform
+		wantFooterLines int
+	}{
+		{
+			name:        "unchanged",
+			canon:       "simple/simple",
+			received:    original,
+			wantMatched: true,
+		},
+		{
+			name:           "trailing whitespace added",
+			canon:          "simple/simple",
+			received:       "Hello,  \r\nThis is a test.\t\r\n",
+			wantMatched:    true,
+			wantTransforms: []BodyTransform{BodyTransformStripTrailingWhitespace},
+		},
+		{
+			name:        "converted to LF in transit",
+			canon:       "simple/simple",
+			received:    "Hello,\nThis is a test.\n",
+			wantMatched: true,
+		},
+		{
+			name:           "signer hashed LF line endings",
+			canon:          "simple/simple",
+			signedBH:       lfBodyHash,
+			received:       original,
+			wantMatched:    true,
+			wantTransforms: []BodyTransform{BodyTransformLFLineEndings},
+		},
+		{
+			name:            "footer added",
+			canon:           "relaxed/relaxed",
+			received:        original + "--\r\nmailing list footer\r\n",
+			wantMatched:     true,
+			wantTransforms:  []BodyTransform{BodyTransformRemoveFooter},
+			wantFooterLines: 2,
+		},
+		{
+			name:            "footer added and signer hashed LF line endings",
+			canon:           "simple/simple",
+			signedBH:        lfBodyHash,
+			received:        original + "--\r\nfooter\r\n",
+			wantMatched:     true,
+			wantTransforms:  []BodyTransform{BodyTransformLFLineEndings, BodyTransformRemoveFooter},
+			wantFooterLines: 2,
+		},
+		{
+			name:           "signer did not pad final line",
+			canon:          "simple/simple",
+			signedBH:       unpaddedBodyHash,
+			received:       "Hello,\r\nThis is a test.",
+			wantMatched:    true,
+			wantTransforms: []BodyTransform{BodyTransformUnpaddedFinalLine},
+		},
+		{
+			name:           "signer did not pad final line and CRLF added in transit",
+			canon:          "relaxed/relaxed",
+			signedBH:       unpaddedBodyHash,
+			received:       original,
+			wantMatched:    true,
+			wantTransforms: []BodyTransform{BodyTransformUnpaddedFinalLine},
+		},
+		{
+			name:            "signer did not pad final line and footer added",
+			canon:           "simple/simple",
+			signedBH:        unpaddedBodyHash,
+			received:        original + "--\r\nfooter\r\n",
+			wantMatched:     true,
+			wantTransforms:  []BodyTransform{BodyTransformUnpaddedFinalLine, BodyTransformRemoveFooter},
+			wantFooterLines: 2,
+		},
+		{
+			name:        "content modified",
+			canon:       "simple/simple",
+			received:    "Hello,\r\nThis is another test.\r\n",
+			wantMatched: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, body, err := header.ParseHeaderCanonicalization(tc.canon)
+			if err != nil {
+				t.Fatalf("failed to parse canonicalization: %v", err)
+			}
+			sig := &Signature{
+				Algorithm:        SignatureAlgorithmRSA_SHA256,
+				Canonicalization: tc.canon,
+				BodyHash:         computeBH(body, original),
+			}
+			if tc.signedBH != "" {
+				sig.BodyHash = tc.signedBH
+			}
+			diag, err := sig.DiagnoseBodyHash([]byte(tc.received))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diag.Matched != tc.wantMatched {
+				t.Fatalf("expected matched=%v, got %v (%s)", tc.wantMatched, diag.Matched, diag)
+			}
+			if !reflect.DeepEqual(diag.Transforms, tc.wantTransforms) {
+				t.Errorf("expected transforms %v, got %v", tc.wantTransforms, diag.Transforms)
+			}
+			if diag.FooterLines != tc.wantFooterLines {
+				t.Errorf("expected footer lines %d, got %d", tc.wantFooterLines, diag.FooterLines)
+			}
+		})
+	}
+}
+
+func TestDiagnoseHeaderHash(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	domainKey, err := domainkey.FromPublicKey(privateKey.Public())
+	if err != nil {
+		t.Fatalf("failed to create domain key: %v", err)
+	}
+	original := []string{
+		"From: a@example.com\r\n",
+		"Subject: hello\r\n",
+		"X-Tag: signed\r\n",
+	}
+	signer := &Signature{
+		Version:          1,
+		BodyHash:         "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=",
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "selector",
+		Headers:          "From:Subject:X-Tag:To",
+	}
+	if err := signer.Sign(original, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sigHeader := "DKIM-Signature: " + signer.String() + "\r\n"
+
+	testCases := []struct {
+		name           string
+		headers        []string
+		wantMatched    bool
+		wantCandidates []HeaderCandidate
+	}{
+		{
+			name:        "unchanged",
+			headers:     original,
+			wantMatched: true,
+		},
+		{
+			name:    "over-signed header added",
+			headers: append(append([]string{}, original...), "To: b@example.net\r\n"),
+			wantCandidates: []HeaderCandidate{
+				{Field: "to", Index: 4, Change: HeaderChangeAdded},
+			},
+		},
+		{
+			name:    "duplicate added below",
+			headers: append(append([]string{}, original...), "X-Tag: appended\r\n"),
+			wantCandidates: []HeaderCandidate{
+				{Field: "x-tag", Index: 4, Change: HeaderChangeAdded},
+				{Field: "x-tag", Index: 3, Change: HeaderChangeDuplicateOrder},
+			},
+		},
+		{
+			name:    "value altered",
+			headers: []string{"From: a@example.com\r\n", "Subject: [list] hello\r\n", "X-Tag: signed\r\n"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := append([]string{sigHeader}, tc.headers...)
+			sig, err := ParseSignature(sigHeader)
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			diag, err := sig.DiagnoseHeaderHash(headers, domainKey, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diag.Matched != tc.wantMatched {
+				t.Errorf("want matched %v, but got %v", tc.wantMatched, diag.Matched)
+			}
+			if !reflect.DeepEqual(diag.Candidates, tc.wantCandidates) {
+				t.Errorf("want %v, but got %v (%s)", tc.wantCandidates, diag.Candidates, diag)
+			}
+		})
+	}
+}
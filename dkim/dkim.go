@@ -1,30 +1,57 @@
 package dkim
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/textproto"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/masa23/mmauth/authres"
 	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/headerfold"
+	"github.com/masa23/mmauth/internal/bodyhash"
 	"github.com/masa23/mmauth/internal/canonical"
 	"github.com/masa23/mmauth/internal/dkimheader"
 	"github.com/masa23/mmauth/internal/header"
+	"github.com/masa23/mmauth/msgcat"
 )
 
-// 正規化
-type Canonicalization canonical.Canonicalization
+// Canonicalization は正規化方式
+// arc.Canonicalizationとmmauth.Canonicalizationはこの型の別名
+type Canonicalization = canonical.Canonicalization
 
 const (
-	CanonicalizationSimple  Canonicalization = "simple"
-	CanonicalizationRelaxed Canonicalization = "relaxed"
+	CanonicalizationSimple  = canonical.Simple
+	CanonicalizationRelaxed = canonical.Relaxed
 )
 
+// FinalCRLF は改行で終わらない本文の最後の行の扱い
+// mmauth.FinalCRLFはこの型の別名
+type FinalCRLF = canonical.FinalCRLF
+
+const (
+	// FinalCRLFPad はRFC 6376に従い最後の行にCRLFを補う(デフォルト)
+	FinalCRLFPad = canonical.FinalCRLFPad
+	// FinalCRLFAsIs は最後の行にCRLFを補わずにボディーハッシュを計算する
+	// 補わずに署名する実装と相互運用するための標準外の動作
+	FinalCRLFAsIs = canonical.FinalCRLFAsIs
+)
+
+// ParseCanonicalization はc=タグの値をパースしてヘッダと本文の正規化方式を返す
+// 空の場合はsimple/simple、一つだけの場合は本文をsimpleとする
+func ParseCanonicalization(s string) (header Canonicalization, body Canonicalization, err error) {
+	return canonical.Parse(s)
+}
+
 // DKIMの署名アルゴリズム
 type SignatureAlgorithm string
 
@@ -44,15 +71,22 @@ type CanonicalizationAndAlgorithm struct {
 	HashAlgo  crypto.Hash
 }
 
-type VerifyStatus string
+// VerifyStatus は検証結果
+// arc.VerifyStatusなどと共通のauthres.Resultの別名で、変換せずに比較・代入できる
+type VerifyStatus = authres.Result
 
+// DKIM署名が検証されなかった場合の結果は次のように区別する (RFC 8601 2.7.1)
+//   - VerifyStatusNone: 署名がない
+//   - VerifyStatusNeutral: 署名はあるが検証していない、または検証できる形式ではない
+//   - VerifyStatusPolicy: 署名はあるが設定により検証を省略した
 const (
-	VerifyStatusNeutral VerifyStatus = "neutral"
-	VerifyStatusFail    VerifyStatus = "fail"
-	VerifyStatusTempErr VerifyStatus = "temperror"
-	VerifyStatusPermErr VerifyStatus = "permerror"
-	VerifyStatusPass    VerifyStatus = "pass"
-	VerifyStatusNone    VerifyStatus = "none"
+	VerifyStatusNeutral = authres.ResultNeutral
+	VerifyStatusFail    = authres.ResultFail
+	VerifyStatusTempErr = authres.ResultTempError
+	VerifyStatusPermErr = authres.ResultPermError
+	VerifyStatusPass    = authres.ResultPass
+	VerifyStatusNone    = authres.ResultNone
+	VerifyStatusPolicy  = authres.ResultPolicy
 )
 
 type VerifyResult struct {
@@ -60,6 +94,16 @@ type VerifyResult struct {
 	err       error
 	msg       string
 	domainKey *domainkey.DomainKey
+	keyIndex  int
+	keyCount  int
+	// 検証結果に付随する注記
+	annotations []string
+	// 検証した署名の識別子
+	identity *IdentityInfo
+	// 失敗レポートの種類 (RFC 6651)。空の場合はstatusから決める
+	reportType domainkey.ReportType
+	// t=が許容範囲を超えて未来だった場合の現在時刻との差
+	timestampSkew time.Duration
 }
 
 func (v *VerifyResult) Status() VerifyStatus {
@@ -72,6 +116,58 @@ func (v *VerifyResult) Message() string {
 	return v.msg
 }
 
+// MessageIn はMessageをlangに翻訳して返す(ログなど人が読むための出力に使う)
+// langが空の場合はmsgcat.SetLanguageで設定した言語、翻訳がない場合は英語のまま
+// Authentication-Resultsのコメントには常に英語のMessageを使う
+func (v *VerifyResult) MessageIn(lang msgcat.Language) string {
+	return msgcat.Translate(lang, v.msg)
+}
+
+// DomainKey は検証に使ったドメインキーを返す
+// 複数の鍵が公開されている場合は検証に成功した鍵(成功しなかった場合は最初の鍵)
+func (v *VerifyResult) DomainKey() *domainkey.DomainKey {
+	return v.domainKey
+}
+
+// KeyIndex は検証に使った鍵がセレクタで公開されている鍵のうち何番目(0始まり)かを返す
+func (v *VerifyResult) KeyIndex() int {
+	return v.keyIndex
+}
+
+// Annotations は検証結果に付随する注記を返す
+// 例えば重複した単一ヘッダが署名されていない場合に "duplicate-header:from" が入る
+func (v *VerifyResult) Annotations() []string {
+	return append([]string(nil), v.annotations...)
+}
+
+// Identity は検証した署名の識別子を返す
+// 検証前の場合はnil
+func (v *VerifyResult) Identity() *IdentityInfo {
+	return v.identity
+}
+
+// TimestampSkew はt=が現在時刻よりどれだけ未来だったかを返す
+// VerifyOptions.FutureTimestampPolicyで確認し、MaxClockSkewを超えていた場合のみ0以外になる
+func (v *VerifyResult) TimestampSkew() time.Duration {
+	return v.timestampSkew
+}
+
+// Code は監視や集計に使う結果のコードを返す
+// 原因を特定できない場合やpassの場合はDKIM_PASSのように結果のみのコード
+func (v *VerifyResult) Code() errcode.Code {
+	return errcode.Result("dkim", string(v.status), v.err)
+}
+
+// KeyCount はセレクタで公開されていた有効な鍵の数を返す
+// ドメインキーを指定して検証した場合は0
+func (v *VerifyResult) KeyCount() int {
+	return v.keyCount
+}
+
+// Signature はDKIM-Signatureヘッダ
+// ParseSignatureで解析したSignatureはEvaluateやString、ResultInfoなどの読み取りのみであれば
+// 複数のgoroutineから同時に使える
+// Sign・Verifyなど受信側を書き換えるメソッドやフィールドの変更は他の呼び出しと同時に行ってはならない
 type Signature struct {
 	Algorithm           SignatureAlgorithm // a algorithm
 	Signature           string             // b signature
@@ -82,6 +178,7 @@ type Signature struct {
 	Identity            string             // i identity
 	Limit               int64              // l limit length
 	QueryType           string             // q query
+	ReportRequested     bool               // r reporting requested (RFC 6651)
 	Selector            string             // s selector
 	Timestamp           int64              // t timestamp
 	Version             int                // v version
@@ -95,54 +192,75 @@ func (ds *Signature) GetCanonicalizationAndAlgorithm() *CanonicalizationAndAlgor
 	return ds.canonnAndAlgo
 }
 
+// DKIM-Signature の文字列化
+// ヘッダ名は含まない
+// タグのまとまりごとに改行し、1行に収まらない場合はタグの間やh=のヘッダ名の間で折り返す
 func (ds *Signature) String() string {
-	var optional []string
+	f := headerfold.NewFieldFolder("DKIM-Signature", nil)
+	f.Word(fmt.Sprintf("a=%s;", ds.Algorithm))
+	f.Word(fmt.Sprintf("bh=%s;", ds.BodyHash))
+	f.Newline()
+	f.Word(fmt.Sprintf("c=%s;", ds.Canonicalization))
+	f.Word(fmt.Sprintf("d=%s;", ds.Domain))
+	f.Newline()
+	f.Append("h=")
+	f.List(strings.Split(ds.Headers+";", ":"), ":")
 	if ds.Identity != "" {
-		optional = append(optional, fmt.Sprintf("        i=%s;\r\n", ds.Identity))
+		f.Newline()
+		f.Word(fmt.Sprintf("i=%s;", ds.Identity))
 	}
 	if ds.Limit > 0 {
-		optional = append(optional, fmt.Sprintf("        l=%d;\r\n", ds.Limit))
+		f.Newline()
+		f.Word(fmt.Sprintf("l=%d;", ds.Limit))
 	}
 	if ds.QueryType != "" {
-		optional = append(optional, fmt.Sprintf("        q=%s;\r\n", ds.QueryType))
+		f.Newline()
+		f.Word(fmt.Sprintf("q=%s;", ds.QueryType))
 	}
-	if ds.SignatureExpiration > 0 {
-		optional = append(optional, fmt.Sprintf("        x=%d;\r\n", ds.SignatureExpiration))
+	if ds.ReportRequested {
+		f.Newline()
+		f.Word("r=y;")
 	}
-
-	return fmt.Sprintf("a=%s; bh=%s;\r\n"+
-		"        c=%s; d=%s;\r\n"+
-		"        h=%s;\r\n"+
-		"%s"+
-		"        s=%s; t=%d; v=%d;\r\n"+
-		"        b=%s",
-		ds.Algorithm, ds.BodyHash,
-		ds.Canonicalization, ds.Domain,
-		ds.Headers,
-		strings.Join(optional, ""),
-		ds.Selector, ds.Timestamp, ds.Version,
-		header.WrapSignatureWithBreaks(ds.Signature),
-	)
+	if ds.SignatureExpiration > 0 {
+		f.Newline()
+		f.Word(fmt.Sprintf("x=%d;", ds.SignatureExpiration))
+	}
+	f.Newline()
+	f.Word(fmt.Sprintf("s=%s;", ds.Selector))
+	f.Word(fmt.Sprintf("t=%d;", ds.Timestamp))
+	f.Word(fmt.Sprintf("v=%d;", ds.Version))
+	return f.Value() + "\r\n        b=" + headerfold.WrapSignatureWithBreaks(ds.Signature)
 }
 
+// ResultString はAuthentication-Resultsに記載するdkimの結果を返す
 func (ds *Signature) ResultString() string {
-	if ds.VerifyResult == nil || ds.VerifyResult.status == VerifyStatusNeutral || ds.VerifyResult.status == VerifyStatusNone {
-		return "dkim=none"
-	}
-
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("dkim=%s (%s)", ds.VerifyResult.Status(), ds.VerifyResult.Message()))
+	return ds.ResultInfo().String()
+}
 
-	if ds.Domain != "" {
-		result.WriteString(fmt.Sprintf(" header.d=%s", ds.Domain))
-	}
-	if ds.Selector != "" {
-		result.WriteString(fmt.Sprintf(" header.s=%s", ds.Selector))
-	}
-	if ds.Identity != "" {
-		result.WriteString(fmt.Sprintf(" header.i=%s", ds.Identity))
+// ResultInfo はAuthentication-Resultsに記載するdkimの結果を返す
+// 署名がない場合はdkim=none、署名を検証していない場合はdkim=neutral、
+// 設定により検証を省略した場合はdkim=policyとし、署名のd=・s=・i=を記載する
+func (ds *Signature) ResultInfo() *authres.ResultInfo {
+	if ds == nil || (ds.VerifyResult != nil && ds.VerifyResult.status == VerifyStatusNone) {
+		return authres.None(authres.MethodDKIM)
+	}
+
+	ri := &authres.ResultInfo{
+		Method:  authres.MethodDKIM,
+		Result:  VerifyStatusNeutral,
+		Comment: "not verified",
+	}
+	id := ds.IdentityInfo()
+	if ds.VerifyResult != nil {
+		ri.Result = ds.VerifyResult.Status()
+		ri.Comment = ds.VerifyResult.Message()
+		if vid := ds.VerifyResult.Identity(); vid != nil {
+			id = vid
+		}
 	}
-	return result.String()
+	return ri.AddProperty(authres.PropertyTypeHeader, "d", id.SDID).
+		AddProperty(authres.PropertyTypeHeader, "s", id.Selector).
+		AddProperty(authres.PropertyTypeHeader, "i", id.AUID)
 }
 
 // stripFWS はFWS (Folding White Space) を削除する
@@ -156,8 +274,40 @@ func stripFWS(s string) string {
 	return s
 }
 
+// タグの長さの上限のデフォルト値
+const (
+	DefaultMaxTagNameLength  = dkimheader.DefaultMaxTagNameLength
+	DefaultMaxTagValueLength = dkimheader.DefaultMaxTagValueLength
+)
+
+// ErrTagTooLong はタグの名前または値がParseOptionsの上限を超えた場合のエラー
+// 不正な形式の署名と区別できるように、上限を超えた場合だけこのエラーを返す
+var ErrTagTooLong = dkimheader.ErrTagTooLong
+
+// ParseOptions はDKIM-Signatureのパースのオプション
+// 4096ビットのRSA鍵の署名や大きなh=・z=を持つ署名を受け付けるために上限を緩める場合に使う
+type ParseOptions struct {
+	// MaxTagNameLength はタグの名前の長さの上限。0の場合はDefaultMaxTagNameLength
+	MaxTagNameLength int
+	// MaxTagValueLength はタグの値(前後の空白を除く)の長さの上限。0の場合はDefaultMaxTagValueLength
+	MaxTagValueLength int
+}
+
+func (o *ParseOptions) limits() dkimheader.Limits {
+	if o == nil {
+		return dkimheader.Limits{}
+	}
+	return dkimheader.Limits{MaxTagNameLength: o.MaxTagNameLength, MaxTagValueLength: o.MaxTagValueLength}
+}
+
 // DKIM-SignatureヘッダをパースしDKIMSignatureを返す
 func ParseSignature(s string) (*Signature, error) {
+	return ParseSignatureWithOptions(s, nil)
+}
+
+// ParseSignatureWithOptions はオプションを指定してDKIM-Signatureヘッダをパースする
+// optsがnilの場合はParseSignatureと同じ
+func ParseSignatureWithOptions(s string, opts *ParseOptions) (*Signature, error) {
 	result := &Signature{}
 	result.raw = s
 
@@ -166,9 +316,9 @@ func ParseSignature(s string) (*Signature, error) {
 	if !strings.EqualFold(k, "dkim-signature") {
 		return nil, fmt.Errorf("invalid header field")
 	}
-	params, err := dkimheader.ParseSignatureParams(v)
+	params, err := dkimheader.ParseSignatureParamsWithLimits(v, opts.limits())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse DKIM-Signature header field: %v", err)
+		return nil, fmt.Errorf("failed to parse DKIM-Signature header field: %w", err)
 	}
 
 	seenTags := make(map[string]bool)
@@ -187,6 +337,9 @@ func ParseSignature(s string) (*Signature, error) {
 				result.Algorithm = SignatureAlgorithmRSA_SHA256
 			case SignatureAlgorithmED25519_SHA256:
 				result.Algorithm = SignatureAlgorithmED25519_SHA256
+			case SignatureAlgorithmECDSA_SHA256:
+				// 検証はVerifyOptions.ExperimentalAlgorithmsで有効にした場合のみ
+				result.Algorithm = SignatureAlgorithmECDSA_SHA256
 			default:
 				return nil, fmt.Errorf("invalid algorithm")
 			}
@@ -213,6 +366,9 @@ func ParseSignature(s string) (*Signature, error) {
 			result.Limit = limit
 		case "q":
 			result.QueryType = value
+		case "r":
+			// RFC 6651: r=y以外の値は無視する
+			result.ReportRequested = value == "y"
 		case "s":
 			result.Selector = value
 		case "t":
@@ -241,8 +397,8 @@ func ParseSignature(s string) (*Signature, error) {
 		return nil, err
 	}
 	result.canonnAndAlgo = &CanonicalizationAndAlgorithm{
-		Header:    Canonicalization(canHeader),
-		Body:      Canonicalization(canBody),
+		Header:    canHeader,
+		Body:      canBody,
 		Algorithm: result.Algorithm,
 		Limit:     result.Limit,
 		HashAlgo:  hashAlgo(result.Algorithm),
@@ -266,6 +422,11 @@ func ParseSignature(s string) (*Signature, error) {
 		return nil, fmt.Errorf("h= tag must include 'From' header")
 	}
 
+	// s=とd=から鍵のレコード名を問い合わせられることを検証
+	if err := validateKeyName(result.Selector, result.Domain); err != nil {
+		return nil, err
+	}
+
 	// i=タグの補完とドメイン整合性の検証 (RFC 6376要件)
 	if result.Identity == "" {
 		// i=タグが存在しない場合、デフォルト値として "@" + d を設定
@@ -276,7 +437,7 @@ func ParseSignature(s string) (*Signature, error) {
 		if atIndex != -1 {
 			identityDomain := result.Identity[atIndex+1:]
 			// d=タグのドメインがi=タグのドメインと同じかサブドメインであることを確認
-			if result.Domain != identityDomain && !strings.HasSuffix(identityDomain, "."+result.Domain) {
+			if !isSameOrSubdomain(identityDomain, result.Domain) {
 				return nil, fmt.Errorf("i= tag domain must be the same as or a subdomain of d= tag domain")
 			}
 		}
@@ -293,20 +454,43 @@ func ParseSignature(s string) (*Signature, error) {
 	return result, nil
 }
 
+// ErrSignHeadersMissingFrom は署名するヘッダにFromが含まれていない場合のエラー
+// RFC 6376 §5.4 でFromは必ず署名しなければならない
+var ErrSignHeadersMissingFrom = errors.New("dkim: signed header list must include From")
+
 // DKIMSignatureに署名を行う
+// d.Headersが空の場合はheadersのヘッダ名からh=を生成する
+// h=は重複を除き(最初の出現順)、ヘッダ名を正規の大文字小文字(例: Message-Id)に揃える
+// 署名するヘッダはRFC 6376 §5.4.2に従い、同名ヘッダの末尾側から選ぶ
 func (d *Signature) Sign(headers []string, key crypto.Signer) error {
+	return d.sign(headers, key, header.SignOptions{OmitLastCRLF: true}, nil)
+}
+
+// experimentalは有効にする実験的なアルゴリズム
+func (d *Signature) sign(headers []string, key crypto.Signer, signOpts header.SignOptions, experimental []SignatureAlgorithm) error {
 	// DKIM Version Check
 	if d.Version != 1 {
 		return errors.New("dkim: invalid version")
 	}
-	// headersのヘッダ名を抽出する
+	if err := validateKeyName(d.Selector, d.Domain); err != nil {
+		return err
+	}
 	var h []string
-	for _, header := range headers {
-		k, _, ok := strings.Cut(header, ":")
-		if !ok {
-			continue
+	if d.Headers != "" {
+		h = strings.Split(d.Headers, ":")
+	} else {
+		// headersのヘッダ名を抽出する
+		for _, header := range headers {
+			k, _, ok := strings.Cut(header, ":")
+			if !ok {
+				continue
+			}
+			h = append(h, k)
 		}
-		h = append(h, k)
+	}
+	h = normalizeSignedHeaders(h)
+	if !containsHeaderName(h, "From") {
+		return ErrSignHeadersMissingFrom
 	}
 	canHeader, _, err := header.ParseHeaderCanonicalization(d.Canonicalization)
 	if err != nil {
@@ -325,23 +509,33 @@ func (d *Signature) Sign(headers []string, key crypto.Signer) error {
 			d.Algorithm = SignatureAlgorithmRSA_SHA256
 		case ed25519.PublicKey:
 			d.Algorithm = SignatureAlgorithmED25519_SHA256
+		case *ecdsa.PublicKey:
+			d.Algorithm = SignatureAlgorithmECDSA_SHA256
 		default:
 			return fmt.Errorf("unknown key type: %T", key.Public())
 		}
 	}
+	if err := checkExperimentalAlgorithm(d.Algorithm, experimental); err != nil {
+		return err
+	}
+	signOpts.ECDSA = d.Algorithm == SignatureAlgorithmECDSA_SHA256
 
 	// DKIM-Signatureヘッダのb=タグの値を空文字列として扱う
 	// RFC 6376 §3.7: DKIM-Signature itself is hashed without a trailing CRLF.
 	// StripBValueForSigning expects a raw header field line (CRLF-terminated).
 	dkimSigHeader := "DKIM-Signature: " + d.String() + "\r\n"
+	// 長いヘッダ名をh=に含めると折り返しても1行が998文字を超えることがある
+	if err := headerfold.Check(dkimSigHeader, nil); err != nil {
+		return fmt.Errorf("dkim: %w", err)
+	}
 	strippedHeader := dkimheader.StripBValueForSigning(dkimSigHeader)
 
 	// Build signing header set (raw), appending DKIM-Signature (with empty b=)
-	signingHeaders := append(append([]string{}, headers...), strippedHeader)
+	signingHeaders := append(header.ExtractHeadersDKIM(headers, h), strippedHeader)
 
 	// 適切なハッシュアルゴリズムを選択
 	hashAlgo := hashAlgo(d.Algorithm)
-	signature, err := header.SignerWithOmitLastCRLF(signingHeaders, key, canHeader, hashAlgo, true)
+	signature, err := header.SignerWithOptions(signingHeaders, key, canHeader, hashAlgo, signOpts)
 	if err != nil {
 		return err
 	}
@@ -349,42 +543,157 @@ func (d *Signature) Sign(headers []string, key crypto.Signer) error {
 	return nil
 }
 
-// DKIMSignatureを検証する
+// h=に並べるヘッダ名を正規化する
+// 空の名前を除き、大文字小文字を区別せずに重複を除いて最初の出現順に並べる
+func normalizeSignedHeaders(names []string) []string {
+	var ret []string
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	return ret
+}
+
+func containsHeaderName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// DKIMSignatureを検証し、結果をd.VerifyResultに設定して返す
 // domainKeyがnilの場合はLookupDomainKeyを実行
-func (d *Signature) Verify(headers []string, bodyHash string, domainKey *domainkey.DomainKey) {
-	d.VerifyWithResolver(headers, bodyHash, domainKey, nil)
+//
+// Deprecated: 受信側を書き換えるため、同じSignatureを並行して検証できない。Evaluateを使う
+func (d *Signature) Verify(headers []string, bodyHash string, domainKey *domainkey.DomainKey) *VerifyResult {
+	return d.VerifyWithResolver(headers, bodyHash, domainKey, nil)
 }
 
-// DKIMSignatureを検証する
+// DKIMSignatureを検証し、結果をd.VerifyResultに設定して返す
 // domainKeyがnilの場合はLookupDomainKeyを実行
 // resolverがnilの場合はデフォルトのリゾルバーを使用
-func (d *Signature) VerifyWithResolver(headers []string, bodyHash string, domainKey *domainkey.DomainKey, resolver domainkey.TXTResolver) {
-	// domainKeyがnilの場合はLookupDomainKeyを実行
-	if domainKey == nil {
-		// リゾルバーがnilの場合はタイムアウト付きのデフォルトリゾルバーを作成
-		if resolver == nil {
-			resolver = domainkey.NewDefaultTXTResolver()
+// セレクタに複数の鍵が公開されている場合は検証に成功するまで順に試す
+//
+// Deprecated: 受信側を書き換えるため、同じSignatureを並行して検証できない。Evaluateを使う
+func (d *Signature) VerifyWithResolver(headers []string, bodyHash string, domainKey *domainkey.DomainKey, resolver domainkey.TXTResolver) *VerifyResult {
+	return d.verify(headers, bodyHash, domainKey, &VerifyOptions{Resolver: resolver})
+}
+
+// Evaluate はDKIMSignatureを検証して結果を返す
+// Verifyと異なりdを書き換えないため、解析済みのSignatureを複数のgoroutineから
+// 異なる鍵やリゾルバーで同時に検証できる
+// domainKeyがnilの場合はLookupDomainKeyを実行し、optsがnilの場合はデフォルトのオプションを使う
+// ReportHookに渡すsigのVerifyResultは設定されない
+func (d *Signature) Evaluate(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts *VerifyOptions) *VerifyResult {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	var result *VerifyResult
+	if err := checkExperimentalAlgorithm(d.Algorithm, opts.ExperimentalAlgorithms); err != nil {
+		// 有効にしていない実験的なアルゴリズムは未知のアルゴリズムと同じく鍵を問い合わせずにpermerror
+		result = &VerifyResult{status: VerifyStatusPermErr, err: errcode.Wrap(errcode.DKIMPermErrorAlgorithmUnsupported, err), msg: "unsupported algorithm"}
+	} else {
+		result = d.lookupAndVerify(headers, bodyHash, domainKey, opts)
+	}
+	d.applyPolicies(result, headers, opts)
+	return result
+}
+
+// 検証結果にoptsの設定による注記と結果の変更を適用し、求められていれば失敗レポートを送る
+// VerifyAllでボディーハッシュが一致しない署名にもEvaluateと同じ順で適用する
+func (d *Signature) applyPolicies(result *VerifyResult, headers []string, opts *VerifyOptions) {
+	result.identity = d.IdentityInfo()
+	d.applyDuplicateHeaderPolicy(result, headers, opts)
+	d.annotateHeaderOrder(result, headers)
+	d.applyAUIDPolicy(result, headers, opts)
+	d.applyFutureTimestampPolicy(result, opts)
+	d.applySHA1Policy(result, opts)
+	applyKeyEncodingPolicy(result, opts)
+	d.requestReport(result, opts)
+}
+
+// Skip は設定により検証を省略した署名としてd.VerifyResultにpolicyの結果を設定して返す
+// reasonはAuthentication-Resultsのコメントに記載する
+func (d *Signature) Skip(reason string) *VerifyResult {
+	d.VerifyResult = &VerifyResult{
+		status:   VerifyStatusPolicy,
+		err:      errcode.Errorf(errcode.DKIMPolicySkipped, "verification skipped: %s", reason),
+		msg:      reason,
+		identity: d.IdentityInfo(),
+	}
+	return d.VerifyResult
+}
+
+func (d *Signature) verify(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts *VerifyOptions) *VerifyResult {
+	d.VerifyResult = d.Evaluate(headers, bodyHash, domainKey, opts)
+	return d.VerifyResult
+}
+
+func (d *Signature) lookupAndVerify(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts *VerifyOptions) *VerifyResult {
+	if domainKey != nil {
+		return d.verifyWithDomainKey(headers, bodyHash, domainKey, opts)
+	}
+
+	// リゾルバーがnilの場合はタイムアウト付きのデフォルトリゾルバーを作成
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = domainkey.NewDefaultTXTResolver()
+	}
+
+	domKeys, err := domainkey.LookupDKIMDomainKeysWithResolver(d.Selector, d.Domain, resolver)
+	if errors.Is(err, domainkey.ErrNoRecordFound) {
+		code := errcode.DKIMPermErrorKeyNotFound
+		if errors.Is(err, domainkey.ErrKeyRevoked) {
+			code = errcode.DKIMPermErrorKeyRevoked
+		}
+		return &VerifyResult{
+			status: VerifyStatusPermErr,
+			err:    errcode.Errorf(code, "domain key is not found: %v", err),
+			msg:    "domain key is not found",
 		}
+	} else if errors.Is(err, domainkey.ErrControlCharacter) {
+		return &VerifyResult{
+			status: VerifyStatusPermErr,
+			err:    errcode.Errorf(errcode.DKIMPermErrorKeyRecordInvalid, "invalid domain key record: %w", err),
+			msg:    "domain key record contains control characters",
+		}
+	} else if err != nil {
+		return &VerifyResult{
+			status: VerifyStatusTempErr,
+			err:    errcode.Errorf(errcode.DKIMTempErrorKeyLookup, "failed to lookup domain key: %v", err),
+			msg:    "failed to lookup domain key",
+		}
+	}
 
-		domKey, err := domainkey.LookupDKIMDomainKeyWithResolver(d.Selector, d.Domain, resolver)
-		if errors.Is(err, domainkey.ErrNoRecordFound) {
-			d.VerifyResult = &VerifyResult{
-				status: VerifyStatusPermErr,
-				err:    fmt.Errorf("domain key is not found: %v", err),
-				msg:    "domain key is not found",
-			}
-			return
-		} else if err != nil {
-			d.VerifyResult = &VerifyResult{
-				status: VerifyStatusTempErr,
-				err:    fmt.Errorf("failed to lookup domain key: %v", err),
-				msg:    "failed to lookup domain key",
-			}
-			return
+	// 検証に成功した鍵があればその結果を、なければ最初の鍵の結果を使う
+	var first *VerifyResult
+	for i := range domKeys {
+		result := d.verifyWithDomainKey(headers, bodyHash, &domKeys[i], opts)
+		result.keyIndex = i
+		result.keyCount = len(domKeys)
+		if result.status == VerifyStatusPass {
+			return result
+		}
+		if first == nil {
+			first = result
 		}
-		domainKey = &domKey
 	}
+	return first
+}
 
+// 指定されたドメインキーで署名を検証する
+func (d *Signature) verifyWithDomainKey(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts *VerifyOptions) *VerifyResult {
 	// テストモードの確認
 	testFlagMsg := ""
 	if domainKey.IsTestFlag() {
@@ -393,83 +702,87 @@ func (d *Signature) VerifyWithResolver(headers []string, bodyHash string, domain
 
 	// service typeの確認 (RFC 6376要件)
 	if !domainKey.IsService(domainkey.ServiceTypeEmail) {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("domain key service type is invalid: %v", domainKey.ServiceType),
+			err:       errcode.Errorf(errcode.DKIMPermErrorKeyServiceType, "domain key service type is invalid: %v", domainKey.ServiceType),
 			msg:       "service type is invalid" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
 	// DKIM-Signatureがない場合はneutral (RFC 6376要件)
 	if d.raw == "" {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusNeutral,
-			err:       errors.New("DKIM-Signature is not found"),
+			err:       errcode.New(errcode.DKIMNeutralNoSignature, "DKIM-Signature is not found"),
 			msg:       "signature is not found" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
 	// バージョンを検証 (RFC 6376要件)
 	if d.Version != 1 {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("DKIM-Signature version is invalid: %d", d.Version),
+			err:       errcode.Errorf(errcode.DKIMPermErrorVersion, "DKIM-Signature version is invalid: %d", d.Version),
 			msg:       "version is invalid" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
 	if err := d.validateDomainKeyPolicy(domainKey); err != nil {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       err,
+			err:       errcode.Wrap(errcode.DKIMPermErrorKeyPolicy, err),
 			msg:       err.Error() + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
+	}
+
+	// DomainKeysとの互換のためにg=を照合する (オプション)
+	if opts.EnforceGranularity && !domainKey.MatchGranularity(d.identityLocalPart()) {
+		return &VerifyResult{
+			status:    VerifyStatusPermErr,
+			err:       errcode.Errorf(errcode.DKIMPermErrorGranularity, "identity local-part does not match key granularity: i=%s g=%s", d.Identity, domainKey.Granularity),
+			msg:       "identity does not match key granularity" + testFlagMsg,
+			domainKey: domainKey,
+		}
 	}
 
 	// expireを検証 (RFC 6376要件)
 	// TimestampとSignatureExpirationがセットされてない場合は検証しない
 	if d.SignatureExpiration != 0 {
 		// 現在時刻がSignatureExpirationを超えていたらFail
-		now := time.Now().Unix()
+		now := opts.now().Unix()
 		if now > d.SignatureExpiration {
-			d.VerifyResult = &VerifyResult{
-				status:    VerifyStatusFail,
-				err:       fmt.Errorf("DKIM-Signature is expired: now=%d expiration=%d", now, d.SignatureExpiration),
-				msg:       "signature is expired" + testFlagMsg,
-				domainKey: domainKey,
+			return &VerifyResult{
+				status:     VerifyStatusFail,
+				err:        errcode.Errorf(errcode.DKIMFailExpired, "DKIM-Signature is expired: now=%d expiration=%d", now, d.SignatureExpiration),
+				msg:        "signature is expired" + testFlagMsg,
+				domainKey:  domainKey,
+				reportType: domainkey.ReportTypeExpired,
 			}
-			return
 		}
 
 		// TimestampがSignatureExpirationより大きい場合はエラー (RFC 6376違反)
 		if d.Timestamp > d.SignatureExpiration {
-			d.VerifyResult = &VerifyResult{
+			return &VerifyResult{
 				status:    VerifyStatusPermErr,
-				err:       fmt.Errorf("DKIM-Signature timestamp is greater than expiration: timestamp=%d expiration=%d", d.Timestamp, d.SignatureExpiration),
+				err:       errcode.Errorf(errcode.DKIMPermErrorTimestampAfterExpiration, "DKIM-Signature timestamp is greater than expiration: timestamp=%d expiration=%d", d.Timestamp, d.SignatureExpiration),
 				msg:       "signature timestamp is greater than expiration" + testFlagMsg,
 				domainKey: domainKey,
 			}
-			return
 		}
 	}
 
 	// ボディーハッシュを検証 (RFC 6376要件)
-	if d.BodyHash != bodyHash {
-		d.VerifyResult = &VerifyResult{
+	if !bodyhash.Equal(d.BodyHash, bodyHash) {
+		return &VerifyResult{
 			status:    VerifyStatusFail,
-			err:       fmt.Errorf("DKIM-Signature body hash is not match: %s != %s", d.BodyHash, bodyHash),
+			err:       errcode.Errorf(errcode.DKIMFailBodyHash, "DKIM-Signature body hash is not match: %s != %s", d.BodyHash, bodyHash),
 			msg:       "body hash is not match" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
 	// ヘッダの抽出と連結
@@ -477,92 +790,127 @@ func (d *Signature) VerifyWithResolver(headers []string, bodyHash string, domain
 	dkimSigHeader := dkimheader.StripBValueForSigning(d.raw)
 
 	// ヘッダの正規化
-	var s string
+	buf := opts.pool.buffer()
+	defer opts.pool.putBuffer(buf)
 	for _, header := range h {
-		s += canonical.Header(header, canonical.Canonicalization(d.canonnAndAlgo.Header))
+		buf.WriteString(canonical.Header(header, d.canonnAndAlgo.Header))
 	}
 	// DKIM-Signatureヘッダの正規化
-	s += canonical.Header(dkimSigHeader, canonical.Canonicalization(d.canonnAndAlgo.Header))
+	buf.WriteString(canonical.Header(dkimSigHeader, d.canonnAndAlgo.Header))
 	// 末尾のCRLFを削除 (DKIM-Signatureヘッダの分は既に削除されている)
-	s = strings.TrimSuffix(s, "\r\n")
+	s := bytes.TrimSuffix(buf.Bytes(), []byte("\r\n"))
 
 	// 署名をbase64デコード
 	signature, err := base64Decode(d.Signature)
 	if err != nil {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusFail,
-			err:       fmt.Errorf("failed to decode signature: %v", err),
+			err:       errcode.Errorf(errcode.DKIMFailSignature, "failed to decode signature: %v", err),
 			msg:       "invalid signature" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
 	// 署名するヘッダをハッシュ化
-	hash := d.canonnAndAlgo.HashAlgo.New()
-	hash.Write([]byte(s))
+	hash := opts.pool.hash(d.canonnAndAlgo.HashAlgo)
+	hash.Write(s)
+	digest := hash.Sum(nil)
+	opts.pool.putHash(d.canonnAndAlgo.HashAlgo, hash)
+
+	// 同じ内容で検証済みの署名であれば公開鍵暗号の計算を省略する
+	if opts.Cache.lookup(d, domainKey, digest, opts) {
+		return &VerifyResult{
+			status:    VerifyStatusPass,
+			msg:       "good signature" + testFlagMsg,
+			domainKey: domainKey,
+		}
+	}
 
 	// 署名を検証
 	// public keyをbase64デコード
 	decoded, err := base64Decode(domainKey.PublicKey)
 	if err != nil {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to decode public key: %v", err),
+			err:       errcode.Errorf(errcode.DKIMPermErrorKeyInvalid, "failed to decode public key: %v", err),
 			msg:       "invalid public key" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
 	// 公開鍵をパース
 	// RFC 8463: ed25519 public key is raw 32-octet key, not PKIX
-	pub, err := domainkey.ParseDKIMPublicKey(decoded, domainKey.KeyType)
+	keyType := domainKey.KeyType
+	if d.Algorithm == SignatureAlgorithmECDSA_SHA256 {
+		keyType = domainKey.ExperimentalKeyType()
+	}
+	pub, err := domainkey.ParseDKIMPublicKey(decoded, keyType)
 	if err != nil {
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("failed to parse public key: %v", err),
+			err:       errcode.Errorf(errcode.DKIMPermErrorKeyInvalid, "failed to parse public key: %v", err),
 			msg:       "invalid public key" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
-	// RSAかed25519の公開鍵か確認
+	// RSAかed25519(有効にした場合はECDSA)の公開鍵か確認
 	switch pub := pub.(type) {
 	case *rsa.PublicKey:
+		// 鍵の長さや値が不正な場合は署名の不一致(fail)ではなくpermerror
+		if err := checkRSAPublicKey(pub); err != nil {
+			msg, code := "malformed public key", errcode.DKIMPermErrorKeyInvalid
+			if errors.Is(err, ErrKeyTooSmall) {
+				msg, code = "public key is too small", errcode.DKIMPermErrorKeyTooSmall
+			}
+			return &VerifyResult{
+				status:    VerifyStatusPermErr,
+				err:       errcode.Wrap(code, err),
+				msg:       msg + testFlagMsg,
+				domainKey: domainKey,
+			}
+		}
 		// 署名を検証
-		if err := rsa.VerifyPKCS1v15(pub, d.canonnAndAlgo.HashAlgo, hash.Sum(nil), signature); err != nil {
-			d.VerifyResult = &VerifyResult{
+		if err := verifyRSA(pub, d.canonnAndAlgo.HashAlgo, digest, signature, opts); err != nil {
+			return &VerifyResult{
 				status:    VerifyStatusFail,
-				err:       fmt.Errorf("failed to verify signature: %v", err),
+				err:       errcode.Errorf(errcode.DKIMFailSignature, "failed to verify signature: %v", err),
 				msg:       "invalid signature" + testFlagMsg,
 				domainKey: domainKey,
 			}
-			return
 		}
 	case ed25519.PublicKey:
 		// 署名を検証
-		if !ed25519.Verify(pub, hash.Sum(nil), signature) {
-			d.VerifyResult = &VerifyResult{
+		if !ed25519.Verify(pub, digest, signature) {
+			return &VerifyResult{
 				status:    VerifyStatusFail,
-				err:       fmt.Errorf("failed to verify signature: %v", err),
+				err:       errcode.Errorf(errcode.DKIMFailSignature, "failed to verify signature: %v", err),
+				msg:       "invalid signature" + testFlagMsg,
+				domainKey: domainKey,
+			}
+		}
+	case *ecdsa.PublicKey:
+		// 実験的なアルゴリズム。鍵の種類とa=の組み合わせはvalidateDomainKeyPolicyで確認済み
+		if !ecdsa.VerifyASN1(pub, digest, signature) {
+			return &VerifyResult{
+				status:    VerifyStatusFail,
+				err:       errcode.New(errcode.DKIMFailSignature, "failed to verify signature: ecdsa verification error"),
 				msg:       "invalid signature" + testFlagMsg,
 				domainKey: domainKey,
 			}
-			return
 		}
 	default:
-		d.VerifyResult = &VerifyResult{
+		return &VerifyResult{
 			status:    VerifyStatusPermErr,
-			err:       fmt.Errorf("invalid public key type: %T", pub),
+			err:       errcode.Errorf(errcode.DKIMPermErrorKeyInvalid, "invalid public key type: %T", pub),
 			msg:       "invalid public key" + testFlagMsg,
 			domainKey: domainKey,
 		}
-		return
 	}
 
-	d.VerifyResult = &VerifyResult{
+	opts.Cache.store(d, domainKey, digest, opts)
+
+	return &VerifyResult{
 		status:    VerifyStatusPass,
 		err:       nil,
 		msg:       "good signature" + testFlagMsg,
@@ -580,7 +928,7 @@ func (d *Signature) validateDomainKeyPolicy(domainKey *domainkey.DomainKey) erro
 		switch d.Algorithm {
 		case SignatureAlgorithmRSA_SHA1:
 			want = domainkey.HashAlgoSHA1
-		case SignatureAlgorithmRSA_SHA256, SignatureAlgorithmED25519_SHA256:
+		case SignatureAlgorithmRSA_SHA256, SignatureAlgorithmED25519_SHA256, SignatureAlgorithmECDSA_SHA256:
 			want = domainkey.HashAlgoSHA256
 		}
 		allowed := false
@@ -608,6 +956,10 @@ func (d *Signature) validateDomainKeyPolicy(domainKey *domainkey.DomainKey) erro
 		if keyType != domainkey.KeyTypeED25519 {
 			return fmt.Errorf("signature key type is not allowed by domain key")
 		}
+	case SignatureAlgorithmECDSA_SHA256:
+		if domainKey.ExperimentalKeyType() != domainkey.KeyTypeECDSA {
+			return fmt.Errorf("signature key type is not allowed by domain key")
+		}
 	}
 
 	for _, flag := range domainKey.SelectorFlags {
@@ -618,7 +970,7 @@ func (d *Signature) validateDomainKeyPolicy(domainKey *domainkey.DomainKey) erro
 		if atIndex := strings.LastIndex(d.Identity, "@"); atIndex != -1 {
 			identityDomain = d.Identity[atIndex+1:]
 		}
-		if !strings.EqualFold(identityDomain, d.Domain) {
+		if !sameDomain(identityDomain, d.Domain) {
 			return fmt.Errorf("identity domain is not allowed by strict domain key")
 		}
 	}
@@ -2,15 +2,25 @@ package dkim
 
 import (
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/headerfold"
 	"github.com/masa23/mmauth/internal/header"
+	"github.com/masa23/mmauth/msgcat"
 )
 
 var testRSAPrivateKey = `
@@ -490,6 +500,299 @@ func TestSign(t *testing.T) {
 
 }
 
+func TestSignHeaderList(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+
+	headers := []string{
+		"Received: from b.example.net\r\n",
+		"Received: from a.example.net\r\n",
+		"from: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+		"MESSAGE-ID: <1@example.com>\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	var longList []string
+	for i := 0; i < 30; i++ {
+		longList = append(longList, fmt.Sprintf("X-Long-Header-Name-%02d", i))
+	}
+	longList = append(longList, "From")
+
+	testCases := []struct {
+		name     string
+		input    string
+		headers  []string
+		expected string
+		err      error
+	}{
+		{
+			name:     "derived from headers",
+			headers:  headers,
+			expected: "Received:From:Subject:Message-Id",
+		},
+		{
+			name:     "caller provided list",
+			input:    "subject:FROM: from :Subject:x-unsigned",
+			headers:  headers,
+			expected: "Subject:From:X-Unsigned",
+		},
+		{
+			name:    "caller provided list without from",
+			input:   "Subject:Message-Id",
+			headers: headers,
+			err:     ErrSignHeadersMissingFrom,
+		},
+		{
+			name:    "headers without from",
+			headers: []string{"Subject: test\r\n"},
+			err:     ErrSignHeadersMissingFrom,
+		},
+		{
+			name:     "long list is folded",
+			input:    strings.Join(longList, ":"),
+			headers:  headers,
+			expected: strings.Join(longList, ":"),
+		},
+		{
+			name:    "header name over the hard limit",
+			input:   "From:X-" + strings.Repeat("a", headerfold.HardLimit),
+			headers: headers,
+			err:     headerfold.ErrLineTooLong,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer := &Signature{
+				Version:          1,
+				Algorithm:        SignatureAlgorithmRSA_SHA256,
+				BodyHash:         bodyHash,
+				Canonicalization: "simple/simple",
+				Domain:           "example.com",
+				Headers:          tc.input,
+				Selector:         "selector",
+				Timestamp:        1706971004,
+			}
+			err := signer.Sign(tc.headers, privateKey)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("want error %v, but got %v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if signer.Headers != tc.expected {
+				t.Errorf("want h=%s, but got %s", tc.expected, signer.Headers)
+			}
+			for _, line := range strings.Split(signer.String(), "\r\n") {
+				if len(line) > headerfold.SoftLimit {
+					t.Errorf("want at most %d characters, but got %q", headerfold.SoftLimit, line)
+				}
+			}
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			sig.VerifyWithResolver(tc.headers, bodyHash, nil, resolver)
+			if sig.VerifyResult.Status() != VerifyStatusPass {
+				t.Errorf("want pass, but got %v (%v)", sig.VerifyResult.Status(), sig.VerifyResult.Error())
+			}
+		})
+	}
+}
+
+func TestSignWithOptions(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.(*rsa.PrivateKey).PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	published := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	stale := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&otherKey.PublicKey))
+	resolverWith := func(records ...string) *MockTXTResolver {
+		r := NewMockTXTResolver()
+		if records != nil {
+			r.Records["selector._domainkey.example.com"] = records
+		}
+		return r
+	}
+
+	testCases := []struct {
+		name      string
+		canon     string
+		opts      *SignerOptions
+		wantCanon string
+		wantAlgo  SignatureAlgorithm
+		err       error
+	}{
+		{
+			name:      "package default",
+			wantCanon: "relaxed/relaxed",
+			wantAlgo:  SignatureAlgorithmRSA_SHA256,
+		},
+		{
+			name:      "options default",
+			opts:      &SignerOptions{Canonicalization: "simple/relaxed", Algorithm: SignatureAlgorithmRSA_SHA1},
+			wantCanon: "simple/relaxed",
+			wantAlgo:  SignatureAlgorithmRSA_SHA1,
+		},
+		{
+			name:      "signature value takes precedence",
+			canon:     "simple/simple",
+			opts:      &SignerOptions{Canonicalization: "relaxed/relaxed"},
+			wantCanon: "simple/simple",
+			wantAlgo:  SignatureAlgorithmRSA_SHA256,
+		},
+		{
+			name:      "allowed with short form",
+			canon:     "relaxed",
+			opts:      &SignerOptions{AllowedCanonicalizations: []string{"relaxed/simple"}},
+			wantCanon: "relaxed",
+			wantAlgo:  SignatureAlgorithmRSA_SHA256,
+		},
+		{
+			name:  "not allowed",
+			canon: "simple/relaxed",
+			opts:  &SignerOptions{AllowedCanonicalizations: []string{"relaxed/relaxed"}},
+			err:   ErrCanonicalizationNotAllowed,
+		},
+		{
+			name:      "self-check",
+			canon:     "simple/simple",
+			opts:      &SignerOptions{SelfCheck: true},
+			wantCanon: "simple/simple",
+			wantAlgo:  SignatureAlgorithmRSA_SHA256,
+		},
+		{
+			name:  "self-check with mismatched public key",
+			canon: "relaxed/relaxed",
+			opts:  &SignerOptions{SelfCheck: true, PublicKey: &otherKey.PublicKey},
+			err:   ErrSelfCheckFailed,
+		},
+		{
+			name:     "published key",
+			opts:     &SignerOptions{CheckPublishedKey: true, Resolver: resolverWith(published)},
+			wantAlgo: SignatureAlgorithmRSA_SHA256,
+		},
+		{
+			name:     "published key during rotation",
+			opts:     &SignerOptions{CheckPublishedKey: true, Resolver: resolverWith(stale, published)},
+			wantAlgo: SignatureAlgorithmRSA_SHA256,
+		},
+		{
+			name: "stale published key",
+			opts: &SignerOptions{CheckPublishedKey: true, Resolver: resolverWith(stale)},
+			err:  ErrPublishedKeyMismatch,
+		},
+		{
+			name: "revoked published key",
+			opts: &SignerOptions{CheckPublishedKey: true, Resolver: resolverWith("v=DKIM1; p=")},
+			err:  ErrPublishedKeyMissing,
+		},
+		{
+			name: "lookup failure",
+			opts: &SignerOptions{CheckPublishedKey: true, Resolver: resolverWith()},
+			err:  domainkey.ErrDNSLookupFailed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := &Signature{
+				Version:          1,
+				BodyHash:         "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=",
+				Canonicalization: tc.canon,
+				Domain:           "example.com",
+				Selector:         "selector",
+			}
+			err := sig.SignWithOptions(headers, priv.(*rsa.PrivateKey), tc.opts)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("want error %v, but got %v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if sig.Canonicalization != tc.wantCanon || sig.Algorithm != tc.wantAlgo {
+				t.Errorf("want c=%s a=%s, but got c=%s a=%s", tc.wantCanon, tc.wantAlgo, sig.Canonicalization, sig.Algorithm)
+			}
+		})
+	}
+}
+
+func TestSignRSAPSS(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	key := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal pkix public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	testCases := []struct {
+		name   string
+		pss    bool
+		accept bool
+		want   VerifyStatus
+	}{
+		{name: "pkcs1v15 default", want: VerifyStatusPass},
+		{name: "pkcs1v15 with pss accepted", accept: true, want: VerifyStatusPass},
+		{name: "pss rejected by default", pss: true, want: VerifyStatusFail},
+		{name: "pss accepted", pss: true, accept: true, want: VerifyStatusPass},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer := &Signature{
+				Version:   1,
+				Algorithm: SignatureAlgorithmRSA_SHA256,
+				BodyHash:  bodyHash,
+				Domain:    "example.com",
+				Selector:  "selector",
+			}
+			if err := signer.SignWithOptions(headers, key, &SignerOptions{Canonicalization: "relaxed/relaxed", RSAPSS: tc.pss}); err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			sig.VerifyWithOptions(headers, bodyHash, nil, &VerifyOptions{Resolver: resolver, AcceptRSAPSS: tc.accept})
+			if sig.VerifyResult.Status() != tc.want {
+				t.Errorf("want %s, but got %s (%v)", tc.want, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+			}
+		})
+	}
+}
+
 func TestVerify(t *testing.T) {
 	block, _ := pem.Decode([]byte(testRSAPublicKey))
 	if block == nil {
@@ -876,3 +1179,874 @@ func TestVerifyWithResolver(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyWithResolverMultipleKeys(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	validKey := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	otherDer, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	rotatedKey := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(otherDer)
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		records      []string
+		status       VerifyStatus
+		wantKeyIndex int
+		wantKeyCount int
+	}{
+		{
+			name:         "single key",
+			records:      []string{validKey},
+			status:       VerifyStatusPass,
+			wantKeyIndex: 0,
+			wantKeyCount: 1,
+		},
+		{
+			name:         "matching key is second",
+			records:      []string{rotatedKey, validKey},
+			status:       VerifyStatusPass,
+			wantKeyIndex: 1,
+			wantKeyCount: 2,
+		},
+		{
+			name:         "revoked and unparsable records are skipped",
+			records:      []string{"v=DKIM1; p=", "v=DKIM1; k=unknown; s=*; p=", validKey},
+			status:       VerifyStatusPass,
+			wantKeyIndex: 0,
+			wantKeyCount: 1,
+		},
+		{
+			name:         "no key matches",
+			records:      []string{rotatedKey},
+			status:       VerifyStatusFail,
+			wantKeyIndex: 0,
+			wantKeyCount: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			resolver := NewMockTXTResolver()
+			resolver.Records["selector._domainkey.example.com"] = tc.records
+			sig.VerifyWithResolver(headers, bodyHash, nil, resolver)
+			if sig.VerifyResult.Status() != tc.status {
+				t.Fatalf("want %v, but got %v (%v)", tc.status, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+			}
+			if sig.VerifyResult.KeyIndex() != tc.wantKeyIndex || sig.VerifyResult.KeyCount() != tc.wantKeyCount {
+				t.Errorf("want key %d/%d, but got %d/%d", tc.wantKeyIndex, tc.wantKeyCount, sig.VerifyResult.KeyIndex(), sig.VerifyResult.KeyCount())
+			}
+			if sig.VerifyResult.DomainKey() == nil {
+				t.Errorf("domain key is not reported")
+			}
+		})
+	}
+}
+
+func TestEvaluateConcurrent(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	otherDer, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	// 同じSignatureを異なる鍵の取得元で同時に検証する
+	valid := NewMockTXTResolver()
+	valid.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+	rotated := NewMockTXTResolver()
+	rotated.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(otherDer)}
+
+	var wg sync.WaitGroup
+	results := make([]*VerifyResult, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resolver := valid
+			if i%2 == 1 {
+				resolver = rotated
+			}
+			results[i] = sig.Evaluate(headers, bodyHash, nil, &VerifyOptions{Resolver: resolver})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		want := VerifyStatusPass
+		if i%2 == 1 {
+			want = VerifyStatusFail
+		}
+		if result.Status() != want {
+			t.Errorf("%d: want %v, but got %v (%v)", i, want, result.Status(), result.Error())
+		}
+	}
+	if sig.VerifyResult != nil {
+		t.Errorf("Evaluate must not set VerifyResult")
+	}
+
+	// Verifyは結果を返し、互換性のためVerifyResultにも設定する
+	result := sig.VerifyWithResolver(headers, bodyHash, nil, valid)
+	if result != sig.VerifyResult || result.Status() != VerifyStatusPass {
+		t.Errorf("want the returned result to be stored, but got %v and %v", result, sig.VerifyResult)
+	}
+}
+
+func TestEvaluateRSAKeyErrors(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	// 512ビットの奇数のモジュラス
+	small := new(big.Int).Rsh(privateKey.N, uint(privateKey.N.BitLen()-512))
+	small.SetBit(small, 0, 1)
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		pub     *rsa.PublicKey
+		want    VerifyStatus
+		wantErr error
+	}{
+		{
+			name: "valid key",
+			pub:  &privateKey.PublicKey,
+			want: VerifyStatusPass,
+		},
+		{
+			name: "signature mismatch",
+			pub:  &otherKey.PublicKey,
+			want: VerifyStatusFail,
+		},
+		{
+			name:    "key too small",
+			pub:     &rsa.PublicKey{N: small, E: 65537},
+			want:    VerifyStatusPermErr,
+			wantErr: ErrKeyTooSmall,
+		},
+		{
+			name:    "even modulus",
+			pub:     &rsa.PublicKey{N: new(big.Int).Add(privateKey.N, big.NewInt(1)), E: 65537},
+			want:    VerifyStatusPermErr,
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "exponent one",
+			pub:     &rsa.PublicKey{N: privateKey.N, E: 1},
+			want:    VerifyStatusPermErr,
+			wantErr: ErrMalformedKey,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			domainKey := &domainkey.DomainKey{
+				KeyType:   domainkey.KeyTypeRSA,
+				PublicKey: base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(tc.pub)),
+			}
+			result := sig.Evaluate(headers, bodyHash, domainKey, nil)
+			if result.Status() != tc.want {
+				t.Fatalf("want %v, but got %v (%v)", tc.want, result.Status(), result.Error())
+			}
+			if tc.wantErr != nil && !errors.Is(result.Error(), tc.wantErr) {
+				t.Errorf("want %v, but got %v", tc.wantErr, result.Error())
+			}
+		})
+	}
+}
+
+func TestVerifyWithOptionsGranularity(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	pub := base64.StdEncoding.EncodeToString(der)
+
+	headers := []string{
+		"From: news@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Identity:         "news@example.com",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		record  string
+		enforce bool
+		status  VerifyStatus
+	}{
+		{name: "ignored by default", record: "v=DKIM1; g=admin; p=" + pub, status: VerifyStatusPass},
+		{name: "enforced match", record: "v=DKIM1; g=new*; p=" + pub, enforce: true, status: VerifyStatusPass},
+		{name: "enforced mismatch", record: "v=DKIM1; g=admin; p=" + pub, enforce: true, status: VerifyStatusPermErr},
+		{name: "enforced without g tag", record: "v=DKIM1; p=" + pub, enforce: true, status: VerifyStatusPass},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			resolver := NewMockTXTResolver()
+			resolver.AddRecord("selector._domainkey.example.com", tc.record)
+			sig.VerifyWithOptions(headers, bodyHash, nil, &VerifyOptions{
+				Resolver:           resolver,
+				EnforceGranularity: tc.enforce,
+			})
+			if sig.VerifyResult.Status() != tc.status {
+				t.Errorf("want %v, but got %v (%v)", tc.status, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+			}
+		})
+	}
+}
+
+func TestVerifyWithOptionsDuplicateHeader(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+
+	headers := []string{
+		"From: news@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Headers:          "From:Subject",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	// 署名後にFromを上に追加する
+	received := append([]string{"From: attacker@example.net\r\n"}, headers...)
+
+	testCases := []struct {
+		name        string
+		policy      DuplicateHeaderPolicy
+		status      VerifyStatus
+		annotations []string
+	}{
+		{name: "ignored by default", policy: DuplicateHeaderIgnore, status: VerifyStatusPass},
+		{name: "annotate", policy: DuplicateHeaderAnnotate, status: VerifyStatusPass, annotations: []string{"duplicate-header:from"}},
+		{name: "fail", policy: DuplicateHeaderFail, status: VerifyStatusFail, annotations: []string{"duplicate-header:from"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			sig.VerifyWithOptions(received, bodyHash, nil, &VerifyOptions{
+				Resolver:              resolver,
+				DuplicateHeaderPolicy: tc.policy,
+			})
+			if sig.VerifyResult.Status() != tc.status {
+				t.Errorf("want %v, but got %v (%v)", tc.status, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+			}
+			if !reflect.DeepEqual(sig.VerifyResult.Annotations(), tc.annotations) {
+				t.Errorf("want annotations %v, but got %v", tc.annotations, sig.VerifyResult.Annotations())
+			}
+		})
+	}
+}
+
+func TestVerifyWithOptionsFutureTimestamp(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	domainKey := &domainkey.DomainKey{
+		KeyType:   domainkey.KeyTypeRSA,
+		PublicKey: base64.StdEncoding.EncodeToString(der),
+	}
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+	signedAt := time.Unix(signer.Timestamp, 0)
+
+	testCases := []struct {
+		name       string
+		policy     FutureTimestampPolicy
+		skew       time.Duration
+		now        time.Time
+		want       VerifyStatus
+		wantSkew   time.Duration
+		annotation string
+	}{
+		{
+			name:   "ignore",
+			policy: FutureTimestampIgnore,
+			now:    signedAt.Add(-time.Hour),
+			want:   VerifyStatusPass,
+		},
+		{
+			name:   "within default skew",
+			policy: FutureTimestampReject,
+			now:    signedAt.Add(-time.Minute),
+			want:   VerifyStatusPass,
+		},
+		{
+			name:       "annotate",
+			policy:     FutureTimestampAnnotate,
+			now:        signedAt.Add(-time.Hour),
+			want:       VerifyStatusPass,
+			wantSkew:   time.Hour,
+			annotation: "future-timestamp:3600s",
+		},
+		{
+			name:       "reject",
+			policy:     FutureTimestampReject,
+			now:        signedAt.Add(-time.Hour),
+			want:       VerifyStatusPermErr,
+			wantSkew:   time.Hour,
+			annotation: "future-timestamp:3600s",
+		},
+		{
+			name:       "custom skew",
+			policy:     FutureTimestampReject,
+			skew:       10 * time.Second,
+			now:        signedAt.Add(-time.Minute),
+			want:       VerifyStatusPermErr,
+			wantSkew:   time.Minute,
+			annotation: "future-timestamp:60s",
+		},
+		{
+			name:   "past timestamp",
+			policy: FutureTimestampReject,
+			now:    signedAt.Add(time.Hour),
+			want:   VerifyStatusPass,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := tc.now
+			result := sig.Evaluate(headers, bodyHash, domainKey, &VerifyOptions{
+				FutureTimestampPolicy: tc.policy,
+				MaxClockSkew:          tc.skew,
+				Now:                   func() time.Time { return now },
+			})
+			if result.Status() != tc.want {
+				t.Fatalf("want %v, but got %v (%v)", tc.want, result.Status(), result.Error())
+			}
+			if result.TimestampSkew() != tc.wantSkew {
+				t.Errorf("want skew %s, but got %s", tc.wantSkew, result.TimestampSkew())
+			}
+			var annotation string
+			if a := result.Annotations(); len(a) > 0 {
+				annotation = a[0]
+			}
+			if annotation != tc.annotation {
+				t.Errorf("want annotation %q, but got %q", tc.annotation, annotation)
+			}
+		})
+	}
+}
+
+func TestFunctionalOptions(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+
+	now := func() time.Time { return time.Unix(1706971004, 0) }
+	opts := NewVerifyOptions(
+		WithResolver(resolver),
+		WithClock(now),
+		WithDuplicateHeaderPolicy(DuplicateHeaderFail),
+		WithFutureTimestampPolicy(FutureTimestampReject, time.Minute),
+	)
+	if opts.Resolver != resolver || opts.Now == nil || opts.DuplicateHeaderPolicy != DuplicateHeaderFail ||
+		opts.FutureTimestampPolicy != FutureTimestampReject || opts.MaxClockSkew != time.Minute {
+		t.Errorf("want all options to be set, but got %+v", opts)
+	}
+
+	signOpts := NewSignerOptions(WithCanonicalization("simple/simple"), WithSelfCheck(nil))
+	if signOpts.Canonicalization != "simple/simple" || !signOpts.SelfCheck {
+		t.Errorf("want options to be set, but got %+v", signOpts)
+	}
+	if DefaultSignerOptions.Canonicalization != "relaxed/relaxed" || DefaultSignerOptions.SelfCheck {
+		t.Errorf("want DefaultSignerOptions to be unchanged, but got %+v", DefaultSignerOptions)
+	}
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:   1,
+		BodyHash:  bodyHash,
+		Domain:    "example.com",
+		Selector:  "selector",
+		Timestamp: 1706971004,
+	}
+	if err := signer.SignWith(headers, privateKey, WithSelfCheck(nil)); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if signer.Canonicalization != "relaxed/relaxed" {
+		t.Errorf("want relaxed/relaxed, but got %s", signer.Canonicalization)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+	result := sig.EvaluateWith(headers, bodyHash, WithResolver(resolver), WithClock(now))
+	if result.Status() != VerifyStatusPass {
+		t.Errorf("want %v, but got %v (%v)", VerifyStatusPass, result.Status(), result.Error())
+	}
+}
+
+func TestDuplicateSingletonHeaders(t *testing.T) {
+	testCases := []struct {
+		name    string
+		h       string
+		headers []string
+		want    []string
+	}{
+		{
+			name:    "no duplicates",
+			h:       "From:Subject",
+			headers: []string{"From: a@example.com\r\n", "Subject: x\r\n"},
+		},
+		{
+			name:    "duplicated from",
+			h:       "From:Subject",
+			headers: []string{"From: b@example.net\r\n", "From: a@example.com\r\n", "Subject: x\r\n"},
+			want:    []string{"from"},
+		},
+		{
+			name:    "all instances signed",
+			h:       "from:from:subject",
+			headers: []string{"From: b@example.net\r\n", "From: a@example.com\r\n", "Subject: x\r\n"},
+		},
+		{
+			name:    "duplicate not covered by h",
+			h:       "From",
+			headers: []string{"From: a@example.com\r\n", "Subject: x\r\n", "Subject: y\r\n"},
+		},
+		{
+			name:    "non singleton header",
+			h:       "From:Received",
+			headers: []string{"Received: a\r\n", "Received: b\r\n", "From: a@example.com\r\n"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := &Signature{Headers: tc.h}
+			got := sig.DuplicateSingletonHeaders(tc.headers, nil)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want %v, but got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSHA1Policy(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:   1,
+		BodyHash:  bodyHash,
+		Domain:    "example.com",
+		Selector:  "selector",
+		Timestamp: 1706971004,
+	}
+	if err := signer.SignWith(headers, privateKey, WithAlgorithm(SignatureAlgorithmRSA_SHA1)); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		defaultPolicy domainkey.SHA1Policy
+		policy        domainkey.SHA1Policy
+		bodyHash      string
+		want          VerifyStatus
+		wantAnnotated bool
+	}{
+		{name: "default warns", policy: domainkey.SHA1PolicyDefault, bodyHash: bodyHash, want: VerifyStatusPass, wantAnnotated: true},
+		{name: "allow", policy: domainkey.SHA1Allow, bodyHash: bodyHash, want: VerifyStatusPass},
+		{name: "warn", policy: domainkey.SHA1Warn, bodyHash: bodyHash, want: VerifyStatusPass, wantAnnotated: true},
+		{name: "deny", policy: domainkey.SHA1Deny, bodyHash: bodyHash, want: VerifyStatusPermErr, wantAnnotated: true},
+		{name: "deny body hash mismatch", policy: domainkey.SHA1Deny, bodyHash: "AAAA", want: VerifyStatusPermErr, wantAnnotated: true},
+		{name: "global deny", defaultPolicy: domainkey.SHA1Deny, policy: domainkey.SHA1PolicyDefault, bodyHash: bodyHash, want: VerifyStatusPermErr, wantAnnotated: true},
+		{name: "per-call overrides global", defaultPolicy: domainkey.SHA1Deny, policy: domainkey.SHA1Allow, bodyHash: bodyHash, want: VerifyStatusPass},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.defaultPolicy != "" {
+				orig := domainkey.DefaultSHA1Policy
+				t.Cleanup(func() { domainkey.DefaultSHA1Policy = orig })
+				domainkey.DefaultSHA1Policy = tc.defaultPolicy
+			}
+			result := sig.EvaluateWith(headers, tc.bodyHash, WithResolver(resolver), WithSHA1Policy(tc.policy))
+			if result.Status() != tc.want {
+				t.Errorf("want %s, but got %s (%v)", tc.want, result.Status(), result.Error())
+			}
+			annotated := false
+			for _, a := range result.Annotations() {
+				if a == domainkey.SHA1Annotation {
+					annotated = true
+				}
+			}
+			if annotated != tc.wantAnnotated {
+				t.Errorf("want annotated %v, but got %v", tc.wantAnnotated, result.Annotations())
+			}
+		})
+	}
+}
+
+func TestKeyRecordControlCharacters(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	record := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:   1,
+		BodyHash:  bodyHash,
+		Domain:    "example.com",
+		Selector:  "selector",
+		Timestamp: 1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		record string
+		strip  bool
+		want   VerifyStatus
+	}{
+		{name: "clean", record: record, want: VerifyStatusPass},
+		{name: "trailing nul", record: record + "\x00", want: VerifyStatusPermErr},
+		{name: "trailing nul stripped", record: record + "\x00", strip: true, want: VerifyStatusPass},
+		{name: "control character", record: "v=DKIM1;\x1b k=rsa; p=" + base64.StdEncoding.EncodeToString(der), strip: true, want: VerifyStatusPermErr},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			original := domainkey.StripTrailingNUL
+			t.Cleanup(func() { domainkey.StripTrailingNUL = original })
+			domainkey.StripTrailingNUL = tc.strip
+			resolver := NewMockTXTResolver()
+			resolver.AddRecord("selector._domainkey.example.com", tc.record)
+			sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			result := sig.EvaluateWith(headers, bodyHash, WithResolver(resolver))
+			if result.Status() != tc.want {
+				t.Errorf("want %s, but got %s (%v)", tc.want, result.Status(), result.Error())
+			}
+			if tc.want == VerifyStatusPermErr && !errors.Is(result.Error(), domainkey.ErrControlCharacter) {
+				t.Errorf("want %v, but got %v", domainkey.ErrControlCharacter, result.Error())
+			}
+		})
+	}
+}
+
+func TestParseSignatureWithOptions(t *testing.T) {
+	// 大きなz=を持つ署名
+	s := "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=selector; c=relaxed/relaxed;\r\n" +
+		" h=from:subject; bh=XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=;\r\n" +
+		" z=Subject:" + strings.Repeat("=20", 500) + ";\r\n" +
+		" b=c2lnbmF0dXJl\r\n"
+
+	if _, err := ParseSignature(s); !errors.Is(err, ErrTagTooLong) {
+		t.Errorf("want %v, but got %v", ErrTagTooLong, err)
+	}
+	if _, err := ParseDKIMHeaders([]string{s}); !errors.Is(err, ErrTagTooLong) {
+		t.Errorf("want %v, but got %v", ErrTagTooLong, err)
+	}
+	opts := &ParseOptions{MaxTagValueLength: 4096}
+	sig, err := ParseSignatureWithOptions(s, opts)
+	if err != nil {
+		t.Fatalf("want no error, but got %v", err)
+	}
+	if sig.Domain != "example.com" {
+		t.Errorf("want example.com, but got %s", sig.Domain)
+	}
+	if sigs, err := ParseDKIMHeadersWithOptions([]string{s}, opts); err != nil || len(*sigs) != 1 {
+		t.Errorf("want one signature, but got %v", err)
+	}
+	// 不正な形式はErrTagTooLongと区別できる
+	if _, err := ParseSignature("DKIM-Signature: v=1; a"); err == nil || errors.Is(err, ErrTagTooLong) {
+		t.Errorf("want a malformed error, but got %v", err)
+	}
+}
+
+func TestVerifyResultCode(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.(*rsa.PrivateKey).PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	published := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:   1,
+		BodyHash:  bodyHash,
+		Domain:    "example.com",
+		Selector:  "selector",
+		Timestamp: 1706971004,
+	}
+	if err := signer.Sign(headers, priv.(crypto.Signer)); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		records  []string
+		headers  []string
+		bodyHash string
+		want     errcode.Code
+	}{
+		{name: "pass", records: []string{published}, want: "DKIM_PASS"},
+		{name: "key not found", records: []string{}, want: errcode.DKIMPermErrorKeyNotFound},
+		{name: "key revoked", records: []string{"v=DKIM1; p="}, want: errcode.DKIMPermErrorKeyRevoked},
+		{name: "lookup failure", want: errcode.DKIMTempErrorKeyLookup},
+		{name: "body hash", records: []string{published}, bodyHash: "AAAA", want: errcode.DKIMFailBodyHash},
+		{
+			name:    "signature",
+			records: []string{published},
+			headers: []string{"From: other@example.com\r\n", "Subject: test\r\n"},
+			want:    errcode.DKIMFailSignature,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver := NewMockTXTResolver()
+			if tc.records != nil {
+				resolver.Records["selector._domainkey.example.com"] = tc.records
+			}
+			h, bh := headers, bodyHash
+			if tc.headers != nil {
+				h = tc.headers
+			}
+			if tc.bodyHash != "" {
+				bh = tc.bodyHash
+			}
+			result := sig.EvaluateWith(h, bh, WithResolver(resolver))
+			if result.Code() != tc.want {
+				t.Errorf("want %s, but got %s (%v)", tc.want, result.Code(), result.Error())
+			}
+		})
+	}
+}
+
+func TestVerifyResultMessageIn(t *testing.T) {
+	result := &VerifyResult{status: VerifyStatusFail, msg: "body hash is not match test mode"}
+	if got := result.Message(); got != "body hash is not match test mode" {
+		t.Errorf("want %q, but got %q", "body hash is not match test mode", got)
+	}
+	if got := result.MessageIn(msgcat.Japanese); got != "本文のハッシュが一致しない(テストモード)" {
+		t.Errorf("want %q, but got %q", "本文のハッシュが一致しない(テストモード)", got)
+	}
+}
@@ -0,0 +1,31 @@
+package dkim
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/internal/idn"
+)
+
+// RFC 8616 5: 国際化メール(EAI)ではd=やi=のドメインにU-labelが使われることがある
+// U-labelとA-labelは同じドメインとして比較し、鍵の問い合わせにはA-labelを使う
+
+// canonicalDomain はドメインを比較のために小文字のA-labelにする
+// A-labelに変換できない場合は小文字にするのみ
+func canonicalDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if ascii, err := idn.ToASCII(domain); err == nil {
+		return strings.ToLower(ascii)
+	}
+	return domain
+}
+
+// sameDomain はaとbが同じドメインかを返す
+func sameDomain(a, b string) bool {
+	return canonicalDomain(a) == canonicalDomain(b)
+}
+
+// isSameOrSubdomain はsubがparentと同じドメインかそのサブドメインかを返す
+func isSameOrSubdomain(sub, parent string) bool {
+	sub, parent = canonicalDomain(sub), canonicalDomain(parent)
+	return sub == parent || strings.HasSuffix(sub, "."+parent)
+}
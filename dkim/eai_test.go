@@ -0,0 +1,85 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+func TestParseSignatureEAIIdentity(t *testing.T) {
+	testCases := []struct {
+		name     string
+		domain   string
+		identity string
+		wantErr  bool
+	}{
+		{name: "u-label", domain: "日本語.jp", identity: "田中@日本語.jp"},
+		{name: "a-label identity", domain: "日本語.jp", identity: "田中@xn--wgv71a119e.jp"},
+		{name: "u-label identity", domain: "xn--wgv71a119e.jp", identity: "田中@メール.日本語.jp"},
+		{name: "quoted-printable local-part", domain: "xn--wgv71a119e.jp", identity: "=E7=94=B0=E4=B8=AD@xn--wgv71a119e.jp"},
+		{name: "case-insensitive", domain: "Example.COM", identity: "user@mail.example.com"},
+		{name: "other domain", domain: "日本語.jp", identity: "田中@example.jp", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseSignature("DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=" + tc.domain +
+				"; i=" + tc.identity + "; s=selector; t=1706971004; h=From; bh=XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=; b=AAAA\r\n")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error %v, but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifyEAISignature(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	pub := base64.StdEncoding.EncodeToString(der)
+
+	headers := []string{
+		"From: 田中@日本語.jp\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	signer := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		BodyHash:         bodyHash,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "日本語.jp",
+		Identity:         "=E7=94=B0=E4=B8=AD@日本語.jp",
+		Selector:         "selector",
+		Timestamp:        1706971004,
+	}
+	if err := signer.Sign(headers, privateKey); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	// 鍵はA-labelのレコード名で問い合わせ、g=はUTF-8のローカルパートと照合する
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.xn--wgv71a119e.jp", "v=DKIM1; g=田中; t=s; p="+pub)
+	sig.VerifyWithOptions(headers, bodyHash, nil, &VerifyOptions{
+		Resolver:           resolver,
+		EnforceGranularity: true,
+	})
+	if sig.VerifyResult.Status() != VerifyStatusPass {
+		t.Fatalf("want %v, but got %v (%v)", VerifyStatusPass, sig.VerifyResult.Status(), sig.VerifyResult.Error())
+	}
+	if got := sig.VerifyResult.Identity().SDID; got != "xn--wgv71a119e.jp" {
+		t.Errorf("want %s, but got %s", "xn--wgv71a119e.jp", got)
+	}
+}
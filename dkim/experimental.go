@@ -0,0 +1,35 @@
+package dkim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SignatureAlgorithmECDSA_SHA256 はECDSA(P-256)とSHA-256による実験的な署名アルゴリズム
+// RFCで定義されていない標準外のアルゴリズムで、RSAの後継を評価する閉じた環境のためのもの
+// 鍵はk=ecdsaでSubjectPublicKeyInfo(PKIX)形式で公開し、b=はASN.1 DER形式の署名をbase64にしたもの
+//
+// VerifyOptions.ExperimentalAlgorithms、SignerOptions.ExperimentalAlgorithmsで有効にしない限り、
+// 検証はpermerror、署名はErrExperimentalAlgorithmになる
+const SignatureAlgorithmECDSA_SHA256 SignatureAlgorithm = "ecdsa-sha256"
+
+// ErrExperimentalAlgorithm は有効にしていない実験的なアルゴリズムで署名・検証しようとした場合のエラー
+var ErrExperimentalAlgorithm = errors.New("dkim: experimental signature algorithm is not enabled")
+
+// 実験的なアルゴリズムか
+func isExperimentalAlgorithm(algo SignatureAlgorithm) bool {
+	return algo == SignatureAlgorithmECDSA_SHA256
+}
+
+// algoが実験的なアルゴリズムで、enabledに含まれていない場合はErrExperimentalAlgorithmを返す
+func checkExperimentalAlgorithm(algo SignatureAlgorithm, enabled []SignatureAlgorithm) error {
+	if !isExperimentalAlgorithm(algo) {
+		return nil
+	}
+	for _, e := range enabled {
+		if e == algo {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrExperimentalAlgorithm, algo)
+}
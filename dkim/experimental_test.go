@@ -0,0 +1,80 @@
+package dkim
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestExperimentalECDSA(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	// k=にrsaを先に書き、ecdsaを知らない検証者にはrsaの鍵として扱われるようにする
+	resolver.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa:ecdsa; p=" + base64.StdEncoding.EncodeToString(der)}
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	newSigner := func() *Signature {
+		return &Signature{
+			Version:   1,
+			BodyHash:  bodyHash,
+			Domain:    "example.com",
+			Selector:  "selector",
+			Timestamp: 1706971004,
+		}
+	}
+
+	if err := newSigner().Sign(headers, privateKey); !errors.Is(err, ErrExperimentalAlgorithm) {
+		t.Errorf("want %v, but got %v", ErrExperimentalAlgorithm, err)
+	}
+
+	signer := newSigner()
+	if err := signer.SignWith(headers, privateKey,
+		WithExperimentalSigning(SignatureAlgorithmECDSA_SHA256), WithSelfCheck(nil)); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if signer.Algorithm != SignatureAlgorithmECDSA_SHA256 {
+		t.Errorf("want %s, but got %s", SignatureAlgorithmECDSA_SHA256, signer.Algorithm)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	result := sig.EvaluateWith(headers, bodyHash, WithResolver(resolver))
+	if result.Status() != VerifyStatusPermErr || !errors.Is(result.Error(), ErrExperimentalAlgorithm) {
+		t.Errorf("want %v with %v, but got %v (%v)", VerifyStatusPermErr, ErrExperimentalAlgorithm, result.Status(), result.Error())
+	}
+
+	result = sig.EvaluateWith(headers, bodyHash, WithResolver(resolver), WithExperimentalAlgorithms(SignatureAlgorithmECDSA_SHA256))
+	if result.Status() != VerifyStatusPass {
+		t.Errorf("want %v, but got %v (%v)", VerifyStatusPass, result.Status(), result.Error())
+	}
+
+	result = sig.EvaluateWith([]string{"From: other@example.com\r\n", "Subject: test\r\n"}, bodyHash,
+		WithResolver(resolver), WithExperimentalAlgorithms(SignatureAlgorithmECDSA_SHA256))
+	if result.Status() != VerifyStatusFail {
+		t.Errorf("want %v, but got %v (%v)", VerifyStatusFail, result.Status(), result.Error())
+	}
+
+	// k=ecdsaを公開していない鍵では検証しない
+	resolver.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+	result = sig.EvaluateWith(headers, bodyHash, WithResolver(resolver), WithExperimentalAlgorithms(SignatureAlgorithmECDSA_SHA256))
+	if result.Status() != VerifyStatusPermErr {
+		t.Errorf("want %v, but got %v (%v)", VerifyStatusPermErr, result.Status(), result.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package dkim
+
+import (
+	"crypto"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+)
+
+// VerifyOption はVerifyOptionsの項目を設定する関数
+// 機能が増えても呼び出し側の引数を変えずに済むよう、EvaluateWithに必要なものだけを渡す
+type VerifyOption func(*VerifyOptions)
+
+// NewVerifyOptions はoptsを順に適用したVerifyOptionsを返す
+func NewVerifyOptions(opts ...VerifyOption) *VerifyOptions {
+	o := &VerifyOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithResolver はドメインキーの問い合わせに使うリゾルバーを設定する
+func WithResolver(resolver domainkey.TXTResolver) VerifyOption {
+	return func(o *VerifyOptions) { o.Resolver = resolver }
+}
+
+// WithClock はx=とt=の確認に使う現在時刻を返す関数を設定する
+func WithClock(now func() time.Time) VerifyOption {
+	return func(o *VerifyOptions) { o.Now = now }
+}
+
+// WithDuplicateHeaderPolicy は署名されていない単一ヘッダの重複の扱いを設定する
+func WithDuplicateHeaderPolicy(policy DuplicateHeaderPolicy) VerifyOption {
+	return func(o *VerifyOptions) { o.DuplicateHeaderPolicy = policy }
+}
+
+// WithFutureTimestampPolicy は未来のt=の扱いと許容する時刻のずれを設定する
+// maxSkewが0の場合はDefaultMaxClockSkew
+func WithFutureTimestampPolicy(policy FutureTimestampPolicy, maxSkew time.Duration) VerifyOption {
+	return func(o *VerifyOptions) {
+		o.FutureTimestampPolicy = policy
+		o.MaxClockSkew = maxSkew
+	}
+}
+
+// WithCache は検証に成功した署名のキャッシュを設定する
+func WithCache(cache *VerifyCache) VerifyOption {
+	return func(o *VerifyOptions) { o.Cache = cache }
+}
+
+// WithSHA1Policy はrsa-sha1の署名の扱いを設定する
+func WithSHA1Policy(policy domainkey.SHA1Policy) VerifyOption {
+	return func(o *VerifyOptions) { o.SHA1Policy = policy }
+}
+
+// WithStrictEd25519Keys はPKIX形式で公開されたed25519の鍵をpermerrorにする
+func WithStrictEd25519Keys() VerifyOption {
+	return func(o *VerifyOptions) { o.StrictEd25519Keys = true }
+}
+
+// WithExperimentalAlgorithms は実験的な署名アルゴリズムの検証を有効にする
+func WithExperimentalAlgorithms(algos ...SignatureAlgorithm) VerifyOption {
+	return func(o *VerifyOptions) { o.ExperimentalAlgorithms = algos }
+}
+
+// WithFinalCRLF はVerifyAllで改行で終わらない最後の行の扱いを指定する
+func WithFinalCRLF(finalCRLF FinalCRLF) VerifyOption {
+	return func(o *VerifyOptions) { o.FinalCRLF = finalCRLF }
+}
+
+// EvaluateWith はoptsを適用してEvaluateと同じく署名を検証する
+// ドメインキーはリゾルバーで問い合わせる
+func (d *Signature) EvaluateWith(headers []string, bodyHash string, opts ...VerifyOption) *VerifyResult {
+	return d.Evaluate(headers, bodyHash, nil, NewVerifyOptions(opts...))
+}
+
+// SignerOption はSignerOptionsの項目を設定する関数
+type SignerOption func(*SignerOptions)
+
+// NewSignerOptions はDefaultSignerOptionsのコピーにoptsを順に適用して返す
+func NewSignerOptions(opts ...SignerOption) *SignerOptions {
+	o := DefaultSignerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o
+}
+
+// WithCanonicalization はSignatureのCanonicalizationが空の場合に使う正規化方式を設定する
+func WithCanonicalization(c string) SignerOption {
+	return func(o *SignerOptions) { o.Canonicalization = c }
+}
+
+// WithAlgorithm はSignatureのAlgorithmが空の場合に使う署名アルゴリズムを設定する
+func WithAlgorithm(algo SignatureAlgorithm) SignerOption {
+	return func(o *SignerOptions) { o.Algorithm = algo }
+}
+
+// WithAllowedCanonicalizations は署名に使ってよい正規化方式を制限する
+func WithAllowedCanonicalizations(canons ...string) SignerOption {
+	return func(o *SignerOptions) { o.AllowedCanonicalizations = canons }
+}
+
+// WithSelfCheck は署名の直後にpubで署名を検証する
+// pubがnilの場合は署名に使う鍵のPublic()
+func WithSelfCheck(pub crypto.PublicKey) SignerOption {
+	return func(o *SignerOptions) {
+		o.SelfCheck = true
+		o.PublicKey = pub
+	}
+}
+
+// WithPublishedKeyCheck は署名の前に公開されている鍵が署名に使う鍵と一致するか確認する
+// resolverがnilの場合はデフォルトのリゾルバー
+func WithPublishedKeyCheck(resolver domainkey.TXTResolver) SignerOption {
+	return func(o *SignerOptions) {
+		o.CheckPublishedKey = true
+		o.Resolver = resolver
+	}
+}
+
+// WithExperimentalSigning は実験的な署名アルゴリズムでの署名を有効にする
+func WithExperimentalSigning(algos ...SignatureAlgorithm) SignerOption {
+	return func(o *SignerOptions) { o.ExperimentalAlgorithms = algos }
+}
+
+// SignWith はoptsを適用してSignWithOptionsと同じく署名する
+func (d *Signature) SignWith(headers []string, key crypto.Signer, opts ...SignerOption) error {
+	return d.SignWithOptions(headers, key, NewSignerOptions(opts...))
+}
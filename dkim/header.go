@@ -9,6 +9,9 @@ import (
 
 type Signatures []*Signature
 
+// GetResult は署名の検証結果をまとめて返す
+// すべてpassの場合はpass、そうでなければ最初のpass以外の結果を返す
+// 署名がない場合はnone、検証していない署名はneutralとして扱う
 func (d *Signatures) GetResult() VerifyStatus {
 	// DKIM署名がない場合はNone
 	if d == nil {
@@ -18,8 +21,11 @@ func (d *Signatures) GetResult() VerifyStatus {
 		return VerifyStatusNone
 	}
 	for _, sig := range *d {
-		if sig == nil || sig.VerifyResult == nil {
-			return VerifyStatusNone
+		if sig == nil {
+			continue
+		}
+		if sig.VerifyResult == nil {
+			return VerifyStatusNeutral
 		}
 		if sig.VerifyResult.Status() != VerifyStatusPass {
 			return sig.VerifyResult.Status()
@@ -29,14 +35,20 @@ func (d *Signatures) GetResult() VerifyStatus {
 }
 
 func ParseDKIMHeaders(headers []string) (*Signatures, error) {
+	return ParseDKIMHeadersWithOptions(headers, nil)
+}
+
+// ParseDKIMHeadersWithOptions はオプションを指定してヘッダからDKIM-Signatureをパースする
+// optsがnilの場合はParseDKIMHeadersと同じ
+func ParseDKIMHeadersWithOptions(headers []string, opts *ParseOptions) (*Signatures, error) {
 	var sigs Signatures
 	for _, h := range headers {
 		k, _ := header.ParseHeaderField(h)
 		switch strings.ToLower(k) {
 		case "dkim-signature":
-			sig, err := ParseSignature(h)
+			sig, err := ParseSignatureWithOptions(h, opts)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse dkim-signature: %v", err)
+				return nil, fmt.Errorf("failed to parse dkim-signature: %w", err)
 			}
 			sigs = append(sigs, sig)
 		}
@@ -55,7 +55,19 @@ func TestGetResult(t *testing.T) {
 			signatures: Signatures{
 				&Signature{},
 			},
-			expected: VerifyStatusNone,
+			expected: VerifyStatusNeutral,
+		},
+		{
+			name: "Skipped Signature",
+			signatures: Signatures{
+				&Signature{
+					VerifyResult: &VerifyResult{status: VerifyStatusPass},
+				},
+				&Signature{
+					VerifyResult: &VerifyResult{status: VerifyStatusPolicy},
+				},
+			},
+			expected: VerifyStatusPolicy,
 		},
 	}
 
@@ -130,3 +142,48 @@ func TestParseDKIMHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestResultInfo(t *testing.T) {
+	sig := func(v *VerifyResult) *Signature {
+		return &Signature{Domain: "example.com", Selector: "sel", Identity: "@example.com", VerifyResult: v}
+	}
+	cases := []struct {
+		name      string
+		signature *Signature
+		expected  string
+	}{
+		{
+			name:      "No Signature",
+			signature: nil,
+			expected:  "dkim=none",
+		},
+		{
+			name:      "None",
+			signature: sig(&VerifyResult{status: VerifyStatusNone}),
+			expected:  "dkim=none",
+		},
+		{
+			name:      "Not Verified",
+			signature: sig(nil),
+			expected:  "dkim=neutral (not verified) header.d=example.com header.s=sel header.i=@example.com",
+		},
+		{
+			name:      "Skipped",
+			signature: func() *Signature { s := sig(nil); s.Skip("too many signatures"); return s }(),
+			expected:  "dkim=policy (too many signatures) header.d=example.com header.s=sel header.i=@example.com",
+		},
+		{
+			name:      "Pass",
+			signature: sig(&VerifyResult{status: VerifyStatusPass, msg: "good signature"}),
+			expected:  "dkim=pass (good signature) header.d=example.com header.s=sel header.i=@example.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.signature.ResultString(); got != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package dkim
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// HeaderAboveSignatureAnnotation はh=で選ばれたヘッダが署名ヘッダより上にあった署名の注記の接頭辞
+// 後ろにヘッダ名(小文字)が続く(例: header-above-signature:subject)
+// 署名後に追加されたヘッダか、署名ヘッダを途中に挿入する生成系による署名の可能性がある
+const HeaderAboveSignatureAnnotation = "header-above-signature:"
+
+// headersを受信した順序として扱い、署名ヘッダより上から選ばれたヘッダを検証結果に注記する
+// headersに署名ヘッダが見つからない場合は何もしない
+func (d *Signature) annotateHeaderOrder(result *VerifyResult, headers []string) {
+	if result == nil || d.raw == "" {
+		return
+	}
+	sigIndex := signatureIndex(headers, d.raw)
+	if sigIndex < 0 {
+		return
+	}
+	_, warnings := header.ExtractHeadersDKIMWireOrder(headers, strings.Split(d.Headers, ":"), sigIndex)
+	for _, w := range warnings {
+		result.annotations = append(result.annotations, HeaderAboveSignatureAnnotation+w.Key)
+	}
+}
+
+// headersの中で署名ヘッダrawの位置を返す
+// 一致するものがない場合は折り返しや行末の違いを無視して(relaxedで)比べ、それでもない場合は-1
+func signatureIndex(headers []string, raw string) int {
+	for i, h := range headers {
+		if h == raw {
+			return i
+		}
+	}
+	want := canonical.Header(raw, canonical.Relaxed)
+	for i, h := range headers {
+		if canonical.Header(h, canonical.Relaxed) == want {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,45 @@
+package dkim
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderOrderAnnotation(t *testing.T) {
+	body := []byte("body\r\n")
+	headers, resolver := newBulkTestMessage(t, 1, body)
+	// 署名後にSubjectを署名ヘッダより上へ移動する(選ばれるヘッダは変わらないため検証は成功する)
+	headers = []string{headers[2], headers[0], headers[1]}
+	opts := NewVerifyOptions(WithResolver(resolver))
+	want := []string{HeaderAboveSignatureAnnotation + "subject"}
+
+	sigs, err := ParseDKIMHeaders(headers)
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	if err := sigs.VerifyAll(headers, bytes.NewReader(body), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := (*sigs)[0].VerifyResult
+	if got.Status() != VerifyStatusPass {
+		t.Errorf("want %s, but got %s (%v)", VerifyStatusPass, got.Status(), got.Error())
+	}
+	if !reflect.DeepEqual(got.Annotations(), want) {
+		t.Errorf("want %v, but got %v", want, got.Annotations())
+	}
+
+	// VerifyAllでbh=が一致しない署名にも同じ注記を付ける
+	if err := sigs.VerifyAll(headers, bytes.NewReader([]byte("tampered\r\n")), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := (*sigs)[0].VerifyResult.Annotations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, but got %v", want, got)
+	}
+
+	// 署名ヘッダが最も上にある場合は注記しない
+	headers = []string{headers[1], headers[2], headers[0]}
+	if got := (*sigs)[0].Evaluate(headers, "invalid", nil, opts).Annotations(); len(got) != 0 {
+		t.Errorf("want no annotations, but got %v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package dkim
+
+import (
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// IdentityInfo はDKIM署名の識別子をまとめたもの
+// DMARCのアライメントやAuthentication-Resultsの生成で文字列を再度パースせずに使える
+type IdentityInfo struct {
+	SDID                 string // 署名ドメイン(d=)、小文字のA-label
+	AUID                 string // エージェントまたはユーザーの識別子(i=)
+	Selector             string // セレクタ(s=)
+	OrganizationalDomain string // SDIDの組織ドメイン(求められない場合はSDID)
+}
+
+// IdentityInfo は署名の識別子を返す
+func (d *Signature) IdentityInfo() *IdentityInfo {
+	sdid := canonicalDomain(d.Domain)
+	info := &IdentityInfo{
+		SDID:                 sdid,
+		AUID:                 d.Identity,
+		Selector:             d.Selector,
+		OrganizationalDomain: sdid,
+	}
+	if org, err := publicsuffix.EffectiveTLDPlusOne(sdid); err == nil {
+		info.OrganizationalDomain = org
+	}
+	return info
+}
+
+// AUIDDomain はi=のドメイン部分を小文字のA-labelで返す
+func (i *IdentityInfo) AUIDDomain() string {
+	_, domain, ok := strings.Cut(i.AUID, "@")
+	if !ok {
+		return ""
+	}
+	return canonicalDomain(domain)
+}
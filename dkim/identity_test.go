@@ -0,0 +1,61 @@
+package dkim
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/masa23/mmauth/domainkey"
+)
+
+func TestIdentityInfo(t *testing.T) {
+	testCases := []struct {
+		name       string
+		input      string
+		expect     IdentityInfo
+		auidDomain string
+	}{
+		{
+			name:  "default auid",
+			input: "DKIM-Signature: v=1; a=rsa-sha256; d=Mail.Example.CO.JP; s=sel; h=from; bh=AAAA; b=AAAA",
+			expect: IdentityInfo{
+				SDID:                 "mail.example.co.jp",
+				AUID:                 "@Mail.Example.CO.JP",
+				Selector:             "sel",
+				OrganizationalDomain: "example.co.jp",
+			},
+			auidDomain: "mail.example.co.jp",
+		},
+		{
+			name:  "user auid",
+			input: "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; i=user@news.example.com; s=sel; h=from; bh=AAAA; b=AAAA",
+			expect: IdentityInfo{
+				SDID:                 "example.com",
+				AUID:                 "user@news.example.com",
+				Selector:             "sel",
+				OrganizationalDomain: "example.com",
+			},
+			auidDomain: "news.example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := ParseSignature(tc.input)
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			got := sig.IdentityInfo()
+			if !reflect.DeepEqual(*got, tc.expect) {
+				t.Errorf("want %+v, but got %+v", tc.expect, *got)
+			}
+			if got.AUIDDomain() != tc.auidDomain {
+				t.Errorf("want %s, but got %s", tc.auidDomain, got.AUIDDomain())
+			}
+
+			// 検証結果にも同じ識別子が入る
+			sig.Verify([]string{"From: user@example.com\r\n"}, "AAAA", &domainkey.DomainKey{KeyType: "rsa"})
+			if !reflect.DeepEqual(sig.VerifyResult.Identity(), got) {
+				t.Errorf("want %+v, but got %+v", got, sig.VerifyResult.Identity())
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package dkim
+
+import (
+	"fmt"
+
+	"github.com/masa23/mmauth/errcode"
+)
+
+// 上限により検証しなかった署名の注記
+const (
+	// SignatureLimitCountAnnotation はVerifyOptions.MaxSignaturesを超えて検証しなかった署名の注記
+	SignatureLimitCountAnnotation = "signature-limit:count"
+	// SignatureLimitSizeAnnotation はVerifyOptions.MaxSignatureSizeを超えて検証しなかった署名の注記
+	SignatureLimitSizeAnnotation = "signature-limit:size"
+)
+
+// ApplyLimits はVerifyOptions.MaxSignaturesとMaxSignatureSizeを超える署名を検証せずに
+// policyの結果とし、検証しなかった署名の数を返す
+// MaxSignatureSizeを超えるDKIM-Signatureヘッダを除き、メッセージの上から順にMaxSignaturesまでを検証の対象にする
+// 対象外の署名の結果にはSignatureLimitCountAnnotationまたはSignatureLimitSizeAnnotationの注記を付ける
+// VerifyAllは検証の前にこれを呼ぶ。署名を個別に検証する場合は検証の前に呼び、
+// WithinLimitsの署名だけを検証した後でAnnotateSkippedを呼ぶ
+func (d *Signatures) ApplyLimits(opts *VerifyOptions) int {
+	if d == nil || opts == nil || (opts.MaxSignatures <= 0 && opts.MaxSignatureSize <= 0) {
+		return 0
+	}
+	skipped, count := 0, 0
+	for _, sig := range *d {
+		if sig == nil {
+			continue
+		}
+		switch {
+		case opts.MaxSignatureSize > 0 && len(sig.raw) > opts.MaxSignatureSize:
+			sig.skipByLimit("signature too large", SignatureLimitSizeAnnotation)
+		case opts.MaxSignatures > 0 && count >= opts.MaxSignatures:
+			sig.skipByLimit("too many signatures", SignatureLimitCountAnnotation)
+		default:
+			count++
+			continue
+		}
+		skipped++
+	}
+	return skipped
+}
+
+// AnnotateSkipped はApplyLimitsで検証しなかった署名があることを、検証した署名の結果に
+// "signatures-skipped:<n>" の注記として付ける
+// 結果を見るだけで、すべての署名を検証したわけではないことがわかるようにする
+func (d *Signatures) AnnotateSkipped(n int) {
+	if d == nil || n <= 0 {
+		return
+	}
+	annotation := fmt.Sprintf("signatures-skipped:%d", n)
+	for _, sig := range *d {
+		if sig == nil || sig.VerifyResult == nil || sig.VerifyResult.isLimited() {
+			continue
+		}
+		sig.VerifyResult.annotations = append(sig.VerifyResult.annotations, annotation)
+	}
+}
+
+// WithinLimits はApplyLimitsで検証の対象にした署名を返す
+func (d *Signatures) WithinLimits() Signatures {
+	if d == nil {
+		return nil
+	}
+	var ret Signatures
+	for _, sig := range *d {
+		if sig != nil && (sig.VerifyResult == nil || !sig.VerifyResult.isLimited()) {
+			ret = append(ret, sig)
+		}
+	}
+	return ret
+}
+
+func (d *Signature) skipByLimit(reason, annotation string) {
+	d.VerifyResult = &VerifyResult{
+		status:      VerifyStatusPolicy,
+		err:         errcode.Errorf(errcode.DKIMPolicySignatureLimit, "verification skipped: %s", reason),
+		msg:         reason,
+		identity:    d.IdentityInfo(),
+		annotations: []string{annotation},
+	}
+}
+
+// 上限により検証しなかった署名の結果か
+func (v *VerifyResult) isLimited() bool {
+	for _, a := range v.annotations {
+		if a == SignatureLimitCountAnnotation || a == SignatureLimitSizeAnnotation {
+			return true
+		}
+	}
+	return false
+}
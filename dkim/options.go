@@ -0,0 +1,343 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/internal/dkimheader"
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// DuplicateHeaderPolicy は単一であるべきヘッダが複数ある場合の扱い
+// RFC 6376 8.15: 検証ではh=に対応する最も下のヘッダのみがハッシュされるため、
+// 署名後に上へ追加されたFromなどが表示されても署名は成功してしまう
+type DuplicateHeaderPolicy string
+
+const (
+	// DuplicateHeaderIgnore は重複を確認しない(デフォルト)
+	DuplicateHeaderIgnore DuplicateHeaderPolicy = ""
+	// DuplicateHeaderAnnotate は検証結果に注記を付ける
+	DuplicateHeaderAnnotate DuplicateHeaderPolicy = "annotate"
+	// DuplicateHeaderFail は注記を付け、検証に成功していてもfailにする
+	DuplicateHeaderFail DuplicateHeaderPolicy = "fail"
+)
+
+// DefaultSingletonHeaders はRFC 5322 3.6で最大1つと定められているヘッダ
+var DefaultSingletonHeaders = []string{
+	"From", "Sender", "Reply-To", "To", "Cc", "Bcc",
+	"Message-ID", "In-Reply-To", "References", "Subject", "Date",
+}
+
+// VerifyOptions はDKIM署名の検証オプション
+type VerifyOptions struct {
+	// Resolver はドメインキーの問い合わせに使うリゾルバー
+	// nilの場合はデフォルトのリゾルバーを使う
+	Resolver domainkey.TXTResolver
+	// EnforceGranularity はドメインキーのg=タグをi=のローカルパートと照合する
+	// g=はRFC 6376で廃止されているため、デフォルトでは無視する
+	EnforceGranularity bool
+	// DuplicateHeaderPolicy はh=に含まれる単一ヘッダが重複している場合の扱い
+	DuplicateHeaderPolicy DuplicateHeaderPolicy
+	// SingletonHeaders は重複を確認するヘッダ。nilの場合はDefaultSingletonHeaders
+	SingletonHeaders []string
+	// AUIDPolicy はi=のローカルパートがある署名をFromのアドレスと照合する場合の扱い
+	// デフォルトでは照合せず、AUIDAnnotateでは結果を注記するのみで検証結果は変えない
+	AUIDPolicy AUIDPolicy
+	// AcceptRSAPSS がtrueの場合、PKCS#1 v1.5で検証できないrsa-sha256の署名を
+	// RSASSA-PSSとしても検証する(SignerOptions.RSAPSSで署名した閉じた環境向けの標準外の動作)
+	AcceptRSAPSS bool
+	// ExperimentalAlgorithms は検証する実験的な署名アルゴリズム(SignatureAlgorithmECDSA_SHA256)
+	// 含まれていない実験的なアルゴリズムの署名は鍵を問い合わせずにpermerrorにする
+	ExperimentalAlgorithms []SignatureAlgorithm
+	// Cache は検証に成功した署名のキャッシュ。nilの場合は毎回署名を検証する
+	Cache *VerifyCache
+	// ReportHook は署名者がRFC 6651のレポートを求めている失敗について呼ばれる
+	// nilの場合は呼ばれない
+	ReportHook ReportHook
+	// LookupParallelism はVerifyAllで並行して鍵を問い合わせる数の上限
+	// 0の場合はDefaultLookupParallelism、1の場合は署名ごとに順に問い合わせる
+	LookupParallelism int
+	// MaxSignatures はVerifyAllで検証する署名の数の上限(RFC 6376 6.1)。0以下の場合は制限しない
+	// 上限を超えた署名は検証せずpolicyとし、注記を付ける(Signatures.ApplyLimits)
+	MaxSignatures int
+	// MaxSignatureSize はVerifyAllで検証するDKIM-Signatureヘッダの長さ(バイト)の上限。0以下の場合は制限しない
+	MaxSignatureSize int
+	// FutureTimestampPolicy はt=がMaxClockSkewを超えて未来の署名の扱い
+	FutureTimestampPolicy FutureTimestampPolicy
+	// MaxClockSkew はt=が現在時刻より未来であることを許容する幅
+	// 0の場合はDefaultMaxClockSkew
+	MaxClockSkew time.Duration
+	// Now は現在時刻を返す関数。nilの場合はtime.Now
+	// x=の有効期限とt=の確認に使う
+	Now func() time.Time
+	// SHA1Policy はrsa-sha1の署名の扱い
+	// 空の場合はdomainkey.DefaultSHA1Policy(デフォルトは注記を付けるdomainkey.SHA1Warn)
+	SHA1Policy domainkey.SHA1Policy
+	// StrictEd25519Keys がtrueの場合、RFC 8463の32オクテットの形式ではなく
+	// PKIX形式で公開されたed25519の鍵による署名をpermerrorにする
+	// falseの場合は検証し、結果にdomainkey.PKIXEd25519Annotationの注記を付ける
+	StrictEd25519Keys bool
+	// FinalCRLF はVerifyAllでボディーハッシュを計算する際の、改行で終わらない最後の行の扱い
+	// ゼロ値(FinalCRLFPad)はRFC 6376に従いCRLFを補う
+	FinalCRLF FinalCRLF
+
+	// pool はVerifierが設定するプール。nilの場合はプールを使わない
+	pool *verifierPool
+}
+
+// VerifyWithOptions はオプションを指定してDKIMSignatureを検証し、結果をd.VerifyResultに設定して返す
+// domainKeyがnilの場合はLookupDomainKeyを実行
+// optsがnilの場合はVerifyと同じ
+//
+// Deprecated: 受信側を書き換えるため、同じSignatureを並行して検証できない。Evaluateを使う
+func (d *Signature) VerifyWithOptions(headers []string, bodyHash string, domainKey *domainkey.DomainKey, opts *VerifyOptions) *VerifyResult {
+	return d.verify(headers, bodyHash, domainKey, opts)
+}
+
+// RSAの署名を検証する
+// PKCS#1 v1.5で検証できず、AcceptRSAPSSが指定されている場合はPSSとしても検証する
+func verifyRSA(pub *rsa.PublicKey, hash crypto.Hash, hashed, signature []byte, opts *VerifyOptions) error {
+	err := rsa.VerifyPKCS1v15(pub, hash, hashed, signature)
+	if err == nil || opts == nil || !opts.AcceptRSAPSS {
+		return err
+	}
+	if pssErr := rsa.VerifyPSS(pub, hash, hashed, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); pssErr == nil {
+		return nil
+	}
+	return err
+}
+
+// i=のローカルパートをdkim-quoted-printableから復号して返す
+// EAIのメッセージではUTF-8のまま書かれていることもある (RFC 8616 5)
+func (d *Signature) identityLocalPart() string {
+	local, _, ok := strings.Cut(d.Identity, "@")
+	if !ok {
+		return ""
+	}
+	return dkimheader.DecodeQuotedPrintable(local)
+}
+
+// DuplicateSingletonHeaders はh=に含まれる単一ヘッダのうち、
+// メッセージ中の数がh=に並べられた数より多い(署名されていないインスタンスがある)ものを返す
+// h=に同じヘッダを実際の数以上並べて署名している場合は追加を検出できるので対象外
+func (d *Signature) DuplicateSingletonHeaders(headers []string, singletons []string) []string {
+	if singletons == nil {
+		singletons = DefaultSingletonHeaders
+	}
+	isSingleton := make(map[string]bool, len(singletons))
+	for _, k := range singletons {
+		isSingleton[strings.ToLower(k)] = true
+	}
+
+	signed := make(map[string]int)
+	var order []string
+	for _, k := range strings.Split(d.Headers, ":") {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !isSingleton[k] {
+			continue
+		}
+		if signed[k] == 0 {
+			order = append(order, k)
+		}
+		signed[k]++
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	present := make(map[string]int)
+	for _, h := range headers {
+		k, _, ok := strings.Cut(h, ":")
+		if !ok {
+			continue
+		}
+		present[strings.ToLower(strings.TrimSpace(k))]++
+	}
+
+	var ret []string
+	for _, k := range order {
+		if present[k] > 1 && present[k] > signed[k] {
+			ret = append(ret, k)
+		}
+	}
+	return ret
+}
+
+// 重複した単一ヘッダがあればポリシーに従って検証結果を変更する
+func (d *Signature) applyDuplicateHeaderPolicy(result *VerifyResult, headers []string, opts *VerifyOptions) {
+	if opts.DuplicateHeaderPolicy == DuplicateHeaderIgnore || result == nil {
+		return
+	}
+	dups := d.DuplicateSingletonHeaders(headers, opts.SingletonHeaders)
+	if len(dups) == 0 {
+		return
+	}
+	for _, k := range dups {
+		result.annotations = append(result.annotations, "duplicate-header:"+k)
+	}
+	if opts.DuplicateHeaderPolicy == DuplicateHeaderFail && result.status == VerifyStatusPass {
+		result.status = VerifyStatusFail
+		result.err = errcode.Errorf(errcode.DKIMFailDuplicateHeader, "duplicate singleton header is not signed: %s", strings.Join(dups, ", "))
+		result.msg = "duplicate singleton header"
+	}
+}
+
+// ErrCanonicalizationNotAllowed は署名の正規化方式がSignerOptionsで許可されていない場合のエラー
+var ErrCanonicalizationNotAllowed = errors.New("dkim: canonicalization is not allowed")
+
+// ErrSelfCheckFailed はSelfCheckで作成した署名を検証できなかった場合のエラー
+var ErrSelfCheckFailed = errors.New("dkim: signature failed self-check")
+
+var (
+	// ErrPublishedKeyMissing はCheckPublishedKeyでセレクタに公開鍵が見つからなかった場合のエラー
+	ErrPublishedKeyMissing = errors.New("dkim: no public key is published for the selector")
+	// ErrPublishedKeyMismatch はCheckPublishedKeyで公開されている鍵が署名に使う鍵と一致しない場合のエラー
+	ErrPublishedKeyMismatch = errors.New("dkim: published public key does not match the signing key")
+)
+
+// SignerOptions は署名時のデフォルト値と制約
+type SignerOptions struct {
+	// Canonicalization はSignatureのCanonicalizationが空の場合に使う値(例: relaxed/relaxed)
+	Canonicalization string
+	// Algorithm はSignatureのAlgorithmが空の場合に使う値
+	// 空の場合は鍵の種類から決める
+	Algorithm SignatureAlgorithm
+	// AllowedCanonicalizations は署名に使ってよい正規化方式の組み合わせ(例: relaxed/relaxed)
+	// nilの場合は制限しない
+	AllowedCanonicalizations []string
+	// RSAPSS がtrueの場合はrsa-sha256の署名をRSASSA-PSSで行う
+	// PSSしか使えないHSMの鍵を使う閉じた環境向けの標準外の動作で、
+	// 一般の受信者はPKCS#1 v1.5(RFC 6376 3.3.1)でしか検証できないため公開のDKIMには使わないこと
+	RSAPSS bool
+	// SelfCheck がtrueの場合、署名の直後に公開鍵で署名を検証し、
+	// 検証できない場合はErrSelfCheckFailedを返す
+	// 正規化や署名するヘッダの選び方の不具合を受信者に届く前に見つけるために使う
+	// ボディーハッシュは渡されたbh=をそのまま使うため、本文の扱いは確認できない
+	SelfCheck bool
+	// PublicKey はSelfCheckに使う公開鍵。nilの場合は署名に使う鍵のPublic()
+	PublicKey crypto.PublicKey
+	// CheckPublishedKey がtrueの場合、署名の前にs=._domainkey.d=の公開鍵を問い合わせ、
+	// 署名に使う鍵と一致しない場合はErrPublishedKeyMissingまたはErrPublishedKeyMismatchを返す
+	// 新しく導入した環境でDNSへの公開漏れや鍵の差し替え忘れを送信前に見つけるために使う
+	// 署名のたびに問い合わせるため、大量に署名する場合は起動時の確認などに限って使う
+	CheckPublishedKey bool
+	// Resolver はCheckPublishedKeyの問い合わせに使うリゾルバー。nilの場合はデフォルトのリゾルバー
+	Resolver domainkey.TXTResolver
+	// ExperimentalAlgorithms は署名に使ってよい実験的な署名アルゴリズム(SignatureAlgorithmECDSA_SHA256)
+	// 一般の受信者は検証できないため、同じ設定で検証する閉じた環境でのみ使う
+	ExperimentalAlgorithms []SignatureAlgorithm
+}
+
+// DefaultSignerOptions はSignWithOptionsでoptsがnilの場合に使う設定
+// アプリケーションの初期化時に一度設定しておくと、署名ごとに指定する必要がなくなる
+var DefaultSignerOptions = SignerOptions{
+	Canonicalization: "relaxed/relaxed",
+}
+
+// SignWithOptions はoptsのデフォルト値を補ってから署名する
+// optsがnilの場合はDefaultSignerOptionsを使う
+func (d *Signature) SignWithOptions(headers []string, key crypto.Signer, opts *SignerOptions) error {
+	if opts == nil {
+		opts = &DefaultSignerOptions
+	}
+	if d.Canonicalization == "" {
+		d.Canonicalization = opts.Canonicalization
+	}
+	if d.Algorithm == "" {
+		d.Algorithm = opts.Algorithm
+	}
+	if err := opts.checkCanonicalization(d.Canonicalization); err != nil {
+		return err
+	}
+	if opts.CheckPublishedKey {
+		if err := checkPublishedKey(d.Selector, d.Domain, key.Public(), opts.Resolver); err != nil {
+			return err
+		}
+	}
+	if err := d.sign(headers, key, header.SignOptions{OmitLastCRLF: true, RSAPSS: opts.RSAPSS}, opts.ExperimentalAlgorithms); err != nil {
+		return err
+	}
+	if opts.SelfCheck {
+		return d.selfCheck(headers, key, opts)
+	}
+	return nil
+}
+
+// 受信者が問い合わせるのと同じ公開鍵が署名に使う鍵と一致するか確認する
+// 鍵のローテーション中で複数のレコードがある場合は、いずれかと一致すればよい
+func checkPublishedKey(selector, domain string, pub crypto.PublicKey, resolver domainkey.TXTResolver) error {
+	if err := validateKeyName(selector, domain); err != nil {
+		return err
+	}
+	name := domainkey.KeyRecordName(selector, domain)
+	keys, err := domainkey.LookupDKIMDomainKeysWithResolver(selector, domain, resolver)
+	if errors.Is(err, domainkey.ErrNoRecordFound) {
+		return fmt.Errorf("%w: %s: %v", ErrPublishedKeyMissing, name, err)
+	} else if err != nil {
+		return fmt.Errorf("dkim: failed to look up the published key %s: %w", name, err)
+	}
+	for _, k := range keys {
+		decoded, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil {
+			continue
+		}
+		published, err := domainkey.ParseDKIMPublicKey(decoded, k.KeyType)
+		if err != nil {
+			continue
+		}
+		if eq, ok := published.(interface{ Equal(crypto.PublicKey) bool }); ok && eq.Equal(pub) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrPublishedKeyMismatch, name)
+}
+
+// 作成した署名を受信者と同じ手順で検証する
+func (d *Signature) selfCheck(headers []string, key crypto.Signer, opts *SignerOptions) error {
+	pub := opts.PublicKey
+	if pub == nil {
+		pub = key.Public()
+	}
+	domainKey, err := domainkey.FromPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, err)
+	}
+	parsed, err := ParseSignature("DKIM-Signature: " + d.String() + "\r\n")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, err)
+	}
+	result := parsed.Evaluate(headers, d.BodyHash, domainKey, &VerifyOptions{
+		AcceptRSAPSS:           opts.RSAPSS,
+		ExperimentalAlgorithms: opts.ExperimentalAlgorithms,
+	})
+	if result.Status() != VerifyStatusPass {
+		return fmt.Errorf("%w: %v", ErrSelfCheckFailed, result.Error())
+	}
+	return nil
+}
+
+// 正規化方式が許可されているかを確認する
+// relaxed と relaxed/simple のように表記が異なっても同じ組み合わせとして扱う
+func (o *SignerOptions) checkCanonicalization(c string) error {
+	h, b, err := ParseCanonicalization(c)
+	if err != nil {
+		return err
+	}
+	if o.AllowedCanonicalizations == nil {
+		return nil
+	}
+	for _, allowed := range o.AllowedCanonicalizations {
+		ah, ab, err := ParseCanonicalization(allowed)
+		if err == nil && ah == h && ab == b {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s/%s", ErrCanonicalizationNotAllowed, h, b)
+}
@@ -0,0 +1,37 @@
+package dkim
+
+import (
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/errcode"
+)
+
+// ed25519の鍵がPKIX形式で公開されていれば注記を付け、
+// VerifyOptions.StrictEd25519Keysが指定されている場合はpermerrorにする
+func applyKeyEncodingPolicy(result *VerifyResult, opts *VerifyOptions) {
+	if result == nil || !result.domainKey.IsPKIXEd25519() {
+		return
+	}
+	result.annotations = append(result.annotations, domainkey.PKIXEd25519Annotation)
+	if opts.StrictEd25519Keys && (result.status == VerifyStatusPass || result.status == VerifyStatusFail) {
+		result.status = VerifyStatusPermErr
+		result.err = errcode.New(errcode.DKIMPermErrorKeyEncoding, "ed25519 public key is published in PKIX form instead of the raw 32-octet key")
+		result.msg = "non-standard ed25519 key encoding"
+	}
+}
+
+// rsa-sha1の署名であればVerifyOptions.SHA1Policyに従って検証結果を変更する
+func (d *Signature) applySHA1Policy(result *VerifyResult, opts *VerifyOptions) {
+	if result == nil || d.Algorithm != SignatureAlgorithmRSA_SHA1 {
+		return
+	}
+	policy := opts.SHA1Policy.Resolve()
+	if policy == domainkey.SHA1Allow {
+		return
+	}
+	result.annotations = append(result.annotations, domainkey.SHA1Annotation)
+	if policy == domainkey.SHA1Deny && (result.status == VerifyStatusPass || result.status == VerifyStatusFail) {
+		result.status = VerifyStatusPermErr
+		result.err = errcode.New(errcode.DKIMPermErrorSHA1NotAllowed, "DKIM-Signature uses rsa-sha1 which is not allowed")
+		result.msg = "rsa-sha1 is not allowed"
+	}
+}
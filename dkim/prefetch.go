@@ -0,0 +1,86 @@
+package dkim
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/internal/bodyhash"
+)
+
+// DefaultLookupParallelism はVerifyAllで並行して鍵を問い合わせる数の上限
+const DefaultLookupParallelism = 8
+
+// 鍵の問い合わせのタイムアウト (domainkeyの問い合わせと同じ)
+const prefetchTimeout = 5 * time.Second
+
+type prefetchedTXT struct {
+	records []string
+	err     error
+}
+
+// 事前に問い合わせたTXTレコードを返すリゾルバー
+// 事前に問い合わせていない名前はresolverで問い合わせる
+type prefetchedTXTResolver struct {
+	resolver domainkey.TXTResolver
+	results  map[string]prefetchedTXT
+}
+
+func (r *prefetchedTXTResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if res, ok := r.results[strings.ToLower(name)]; ok {
+		return res.records, res.err
+	}
+	return r.resolver.LookupTXT(ctx, name)
+}
+
+// 署名の検証に必要な鍵のレコードをparallelismの数まで並行して問い合わせる
+func prefetchKeys(names []string, resolver domainkey.TXTResolver, parallelism int) *prefetchedTXTResolver {
+	r := &prefetchedTXTResolver{
+		resolver: resolver,
+		results:  make(map[string]prefetchedTXT, len(names)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for _, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+			defer cancel()
+			records, err := resolver.LookupTXT(ctx, name)
+			mu.Lock()
+			r.results[name] = prefetchedTXT{records: records, err: err}
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return r
+}
+
+// 検証で問い合わせる鍵のレコード名を重複なく返す
+// bh=が一致しない署名は失敗レポートのために鍵を問い合わせる場合だけ含める
+func (d *Signatures) keyNames(bodyHashes map[bodyHashKey]string, opts *VerifyOptions) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, sig := range *d {
+		if sig == nil || sig.canonnAndAlgo == nil {
+			continue
+		}
+		if !bodyhash.Equal(sig.BodyHash, bodyHashes[sig.bodyHashKey()]) && !sig.wantsReport(opts) {
+			continue
+		}
+		name := strings.ToLower(domainkey.KeyRecordName(sig.Selector, sig.Domain))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
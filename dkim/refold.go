@@ -0,0 +1,99 @@
+package dkim
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/masa23/mmauth/headerfold"
+	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/internal/dkimheader"
+)
+
+// DefaultRefoldWidth はRefoldSignatureで幅を指定しない場合の1行の最大の長さ
+// RFC 5322 2.1.1 の推奨値(CRLFを除いて78文字)
+const DefaultRefoldWidth = headerfold.SoftLimit
+
+const crlf = "\r\n"
+
+// refoldIndent は折り返した行の先頭の空白
+const refoldIndent = "        "
+
+var (
+	// ErrSimpleHeaderCanonicalization はヘッダの正規化がsimpleの署名を折り返そうとした場合のエラー
+	// simpleでは空白や改行の位置も署名の対象になるため、折り返すと検証できなくなる
+	ErrSimpleHeaderCanonicalization = errors.New("dkim: signature uses simple header canonicalization")
+	// ErrCanonicalFormChanged は折り返しによってrelaxed正規化した結果が変わった場合のエラー
+	ErrCanonicalFormChanged = errors.New("dkim: canonical form changed by refolding")
+)
+
+// RefoldSignature は受信したDKIM-Signatureヘッダを1行がwidth文字以内になるように折り返し直す
+// アーカイブなど行の長さに制限のある保存先に、検証できる状態のまま格納するために使う
+// fieldは"DKIM-Signature: ..."の形式で、戻り値は末尾にCRLFを含む
+// widthが0以下の場合はDefaultRefoldWidthを使う
+//
+// 元の空白の位置とb=タグの値の中だけで折り返すため、空白のない長いタグはwidthを超えることがある
+// ヘッダの正規化がsimpleの場合はErrSimpleHeaderCanonicalizationを返す
+// 折り返した結果のrelaxed正規化(b=の値を除く)とb=の値が元と一致しない場合は
+// ErrCanonicalFormChangedを返す
+func RefoldSignature(field string, width int) (string, error) {
+	if width <= 0 {
+		width = DefaultRefoldWidth
+	}
+	field = strings.TrimRight(field, "\r\n") + crlf
+	sig, err := ParseSignature(field)
+	if err != nil {
+		return "", err
+	}
+	if sig.canonnAndAlgo == nil || sig.canonnAndAlgo.Header != CanonicalizationRelaxed {
+		return "", ErrSimpleHeaderCanonicalization
+	}
+
+	name, value, _ := strings.Cut(strings.TrimSuffix(field, crlf), ":")
+	value = strings.NewReplacer("\r\n ", " ", "\r\n\t", " ").Replace(value)
+	before, bValue, after := splitBValue(value)
+
+	f := headerfold.NewFolder(name+":", &headerfold.Options{SoftLimit: width, Indent: refoldIndent})
+	for _, w := range strings.Fields(before) {
+		f.Word(w)
+	}
+	f.Breakable(stripFWS(bValue))
+	for i, w := range strings.Fields(after) {
+		// b=の値と;の間の空白はb=の値として扱われるため、空白の有無を問わず折り返してよい
+		if i == 0 && bValue != "" && strings.HasPrefix(after, ";") {
+			f.Breakable(w)
+			continue
+		}
+		f.Word(w)
+	}
+	refolded := f.String() + crlf
+
+	check, err := ParseSignature(refolded)
+	if err != nil || check.Signature != sig.Signature ||
+		canonical.RelaxedHeader(dkimheader.StripBValueForSigning(refolded)) !=
+			canonical.RelaxedHeader(dkimheader.StripBValueForSigning(field)) {
+		return "", ErrCanonicalFormChanged
+	}
+	return refolded, nil
+}
+
+// splitBValue は折り返しを解除したヘッダの値をb=タグの値とその前後に分ける
+// beforeは"b="までを含み、afterはb=の値の次の;から始まる
+func splitBValue(value string) (before, bValue, after string) {
+	start := 0
+	for {
+		spec := strings.TrimLeft(value[start:], " \t")
+		if strings.HasPrefix(spec, "b=") {
+			valueStart := len(value) - len(spec) + len("b=")
+			end := strings.IndexByte(value[valueStart:], ';')
+			if end < 0 {
+				return value[:valueStart], value[valueStart:], ""
+			}
+			return value[:valueStart], value[valueStart : valueStart+end], value[valueStart+end:]
+		}
+		next := strings.IndexByte(value[start:], ';')
+		if next < 0 {
+			return value, "", ""
+		}
+		start += next + 1
+	}
+}
@@ -0,0 +1,88 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRefoldSignature(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	privateKey := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.Records["selector._domainkey.example.com"] = []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+	sign := func(canon string) string {
+		s := &Signature{
+			Version:   1,
+			BodyHash:  bodyHash,
+			Domain:    "example.com",
+			Selector:  "selector",
+			Timestamp: 1706971004,
+		}
+		if err := s.SignWith(headers, privateKey, WithCanonicalization(canon)); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return "DKIM-Signature: " + s.String() + "\r\n"
+	}
+	// 受信時に1行にまとめられた署名
+	unfolded := strings.NewReplacer("\r\n        ", " ", "\r\n         ", "").Replace(strings.TrimSuffix(sign("relaxed/relaxed"), "\r\n"))
+
+	testCases := []struct {
+		name    string
+		field   string
+		width   int
+		wantErr error
+	}{
+		{name: "folded", field: sign("relaxed/simple"), width: 40},
+		{name: "single line", field: unfolded},
+		{name: "narrow", field: unfolded, width: 20},
+		{name: "simple header", field: sign("simple/relaxed"), wantErr: ErrSimpleHeaderCanonicalization},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			refolded, err := RefoldSignature(tc.field, tc.width)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("want %v, but got %v", tc.wantErr, err)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			width := tc.width
+			if width == 0 {
+				width = DefaultRefoldWidth
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(refolded, "\r\n"), "\r\n") {
+				// 空白を含まない長いタグは折り返せないため1語だけの行になる
+				if len(line) > width && len(strings.Fields(line)) > 1 {
+					t.Errorf("want at most %d characters, but got %q", width, line)
+				}
+			}
+			sig, err := ParseSignature(refolded)
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			result := sig.EvaluateWith(headers, bodyHash, WithResolver(resolver))
+			if result.Status() != VerifyStatusPass {
+				t.Errorf("want %v, but got %v (%v)", VerifyStatusPass, result.Status(), result.Error())
+			}
+		})
+	}
+}
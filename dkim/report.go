@@ -0,0 +1,105 @@
+package dkim
+
+import (
+	"math/rand"
+
+	"github.com/masa23/mmauth/domainkey"
+)
+
+// ReportRequest は署名者がRFC 6651で求める失敗レポートの送信先と条件
+type ReportRequest struct {
+	// Address はレポートの送信先 (鍵のra=と署名のd=から作る)
+	Address string
+	// Percent はレポートを送る失敗の割合(0-100)
+	Percent int
+	// Types は求められているレポートの種類。空の場合はすべて
+	Types []domainkey.ReportType
+	// SMTPError は鍵のrs=で指定されたSMTPの拒否時の文字列
+	SMTPError string
+}
+
+// Wants はtの種類のレポートが求められているか
+func (r *ReportRequest) Wants(t domainkey.ReportType) bool {
+	key := domainkey.DomainKey{ReportTypes: r.Types}
+	return key.WantsReport(t)
+}
+
+// ReportHook は失敗レポートを生成するための関数
+// sigは検証に失敗した署名、tは失敗の種類
+// Percentによる間引きは呼び出し前に行われる
+type ReportHook func(sig *Signature, req *ReportRequest, t domainkey.ReportType)
+
+// ReportRequest は署名の失敗レポートの要求を返す
+// 署名にr=yがない場合や、検証に使った鍵にra=がない場合はnil
+func (d *Signature) ReportRequest() *ReportRequest {
+	return d.reportRequest(d.VerifyResult)
+}
+
+// resultの検証に使った鍵から失敗レポートの要求を作る
+func (d *Signature) reportRequest(result *VerifyResult) *ReportRequest {
+	if !d.ReportRequested || result == nil || result.domainKey == nil {
+		return nil
+	}
+	key := result.domainKey
+	if key.ReportAddress == "" {
+		return nil
+	}
+	return &ReportRequest{
+		Address:   key.ReportAddress + "@" + d.Domain,
+		Percent:   key.ReportPercentage(),
+		Types:     append([]domainkey.ReportType(nil), key.ReportTypes...),
+		SMTPError: key.ReportSMTP,
+	}
+}
+
+// ReportType は検証結果に対応するRFC 6651のレポートの種類を返す
+// 失敗でない場合や、レポートの対象にならない一時的なエラーの場合はReportTypeUndefined
+func (v *VerifyResult) ReportType() domainkey.ReportType {
+	if v.reportType != domainkey.ReportTypeUndefined {
+		return v.reportType
+	}
+	switch v.status {
+	case VerifyStatusFail:
+		return domainkey.ReportTypeVerify
+	case VerifyStatusPermErr:
+		return domainkey.ReportTypeSyntax
+	}
+	return domainkey.ReportTypeUndefined
+}
+
+// 署名者が失敗レポートを求めていて、ReportHookが設定されているか
+func (d *Signature) wantsReport(opts *VerifyOptions) bool {
+	return d.ReportRequested && opts.ReportHook != nil
+}
+
+// 失敗レポートの送信先を得るために鍵を問い合わせる。見つからない場合はnil
+func (d *Signature) lookupReportKey(opts *VerifyOptions) *domainkey.DomainKey {
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = domainkey.NewDefaultTXTResolver()
+	}
+	keys, err := domainkey.LookupDKIMDomainKeysWithResolver(d.Selector, d.Domain, resolver)
+	if err != nil || len(keys) == 0 {
+		return nil
+	}
+	return &keys[0]
+}
+
+// 求められている場合にReportHookを呼ぶ
+func (d *Signature) requestReport(result *VerifyResult, opts *VerifyOptions) {
+	if opts.ReportHook == nil {
+		return
+	}
+	t := result.ReportType()
+	if t == domainkey.ReportTypeUndefined {
+		return
+	}
+	req := d.reportRequest(result)
+	if req == nil || !req.Wants(t) {
+		return
+	}
+	if req.Percent < 100 && rand.Intn(100) >= req.Percent {
+		return
+	}
+	opts.ReportHook(d, req, t)
+}
@@ -0,0 +1,93 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/masa23/mmauth/domainkey"
+)
+
+func TestReportHook(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	key := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal pkix public key: %s", err)
+	}
+	p := base64.StdEncoding.EncodeToString(der)
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("report._domainkey.example.com", "v=DKIM1; ra=dkim-fail; rr=v; rs=5.7.1=20see=20report; p="+p)
+	resolver.AddRecord("noaddr._domainkey.example.com", "v=DKIM1; p="+p)
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	testCases := []struct {
+		name      string
+		selector  string
+		request   bool
+		bodyHash  string
+		wantType  domainkey.ReportType
+		wantAddr  string
+		wantCalls int
+	}{
+		{name: "verification failure", selector: "report", request: true, bodyHash: "invalid", wantType: domainkey.ReportTypeVerify, wantAddr: "dkim-fail@example.com", wantCalls: 1},
+		{name: "pass", selector: "report", request: true, bodyHash: bodyHash},
+		{name: "reports not requested by signer", selector: "report", bodyHash: "invalid"},
+		{name: "no reporting address", selector: "noaddr", request: true, bodyHash: "invalid"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer := &Signature{
+				Version:         1,
+				Algorithm:       SignatureAlgorithmRSA_SHA256,
+				BodyHash:        bodyHash,
+				Domain:          "example.com",
+				Selector:        tc.selector,
+				ReportRequested: tc.request,
+			}
+			if err := signer.SignWithOptions(headers, key, &SignerOptions{Canonicalization: "relaxed/relaxed"}); err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			s := signer.String()
+			if tc.request != strings.Contains(s, "r=y;") {
+				t.Errorf("unexpected r= tag in %q", s)
+			}
+			sig, err := ParseSignature("DKIM-Signature: " + s + "\r\n")
+			if err != nil {
+				t.Fatalf("failed to parse signature: %v", err)
+			}
+			if sig.ReportRequested != tc.request {
+				t.Errorf("want r=y %v, but got %v", tc.request, sig.ReportRequested)
+			}
+
+			calls := 0
+			hook := func(sig *Signature, req *ReportRequest, rt domainkey.ReportType) {
+				calls++
+				if rt != tc.wantType {
+					t.Errorf("want report type %q, but got %q", tc.wantType, rt)
+				}
+				if req.Address != tc.wantAddr {
+					t.Errorf("want address %q, but got %q", tc.wantAddr, req.Address)
+				}
+				if req.SMTPError != "5.7.1 see report" {
+					t.Errorf("unexpected smtp error: %q", req.SMTPError)
+				}
+			}
+			sig.VerifyWithOptions(headers, tc.bodyHash, nil, &VerifyOptions{Resolver: resolver, ReportHook: hook})
+			if calls != tc.wantCalls {
+				t.Errorf("want %d hook calls, but got %d", tc.wantCalls, calls)
+			}
+		})
+	}
+}
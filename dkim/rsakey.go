@@ -0,0 +1,34 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// MinRSAKeyBits は検証で受け付けるRSA鍵の最小のビット数
+// RFC 8301 3.2: 1024ビット未満の鍵による署名を有効とみなしてはならない
+const MinRSAKeyBits = 1024
+
+var (
+	// ErrKeyTooSmall はRSA鍵がMinRSAKeyBitsより短い場合のエラー
+	ErrKeyTooSmall = errors.New("dkim: public key is too small")
+	// ErrMalformedKey はRSA鍵のモジュラスや公開指数が不正な場合のエラー
+	ErrMalformedKey = errors.New("dkim: malformed public key")
+)
+
+// checkRSAPublicKey は署名の検証の前にRSA鍵を検査する
+// rsa.VerifyPKCS1v15は鍵の問題も署名の不一致も同じエラーで返すため、
+// 鍵に起因するもの(permerror)を事前に分けておく
+func checkRSAPublicKey(pub *rsa.PublicKey) error {
+	if pub.N == nil || pub.N.Sign() <= 0 || pub.N.Bit(0) == 0 {
+		return fmt.Errorf("%w: modulus is not a positive odd number", ErrMalformedKey)
+	}
+	if pub.E < 3 || pub.E%2 == 0 {
+		return fmt.Errorf("%w: invalid public exponent %d", ErrMalformedKey, pub.E)
+	}
+	if bits := pub.N.BitLen(); bits < MinRSAKeyBits {
+		return fmt.Errorf("%w: %d bits, want at least %d", ErrKeyTooSmall, bits, MinRSAKeyBits)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package dkim
+
+import (
+	"crypto"
+	"errors"
+	"strings"
+
+	"github.com/masa23/mmauth/internal/header"
+)
+
+// ErrNoSignRule は条件に一致するルールもデフォルトもない場合のエラー
+var ErrNoSignRule = errors.New("dkim: no signing rule matched")
+
+// SignConfig は署名に使う識別子・鍵・正規化方式
+type SignConfig struct {
+	Domain           string             // d=
+	Selector         string             // s=
+	Identity         string             // i= (空の場合は付けない)
+	Key              crypto.Signer      // 署名鍵
+	Algorithm        SignatureAlgorithm // 空の場合は鍵の種類から決める
+	Canonicalization string             // c= (空の場合はSignerOptionsのデフォルト)
+	// Headers は署名するヘッダ名(h=)。nilの場合は渡されたヘッダをすべて署名する
+	// メッセージにないヘッダ名を含めると、そのヘッダの後からの追加を検出できる
+	Headers []string
+}
+
+// SignInput はルールの照合に使うメッセージの情報
+type SignInput struct {
+	Headers        []string // メッセージのヘッダ
+	EnvelopeSender string   // RFC5321.MailFrom
+}
+
+// SignRule は条件と、条件に一致した場合に使う署名設定
+// 条件はすべて一致した場合にルールが選ばれる。空の条件は常に一致する
+type SignRule struct {
+	Name string
+	// FromDomain はRFC5322.Fromのドメイン
+	// "*.example.com" はサブドメインのみ、".example.com" はexample.comとサブドメインに一致する
+	FromDomain string
+	// EnvelopeSender はRFC5321.MailFromのアドレスまたはドメイン(FromDomainと同じ書式)
+	EnvelopeSender string
+	// HeaderPresent はメッセージに存在しなければならないヘッダ名
+	HeaderPresent string
+	Config        SignConfig
+}
+
+// SignRules は上から順にルールを照合し、最初に一致したルールの設定で署名する
+// どのルールにも一致しない場合はDefaultを使う
+type SignRules struct {
+	Rules   []SignRule
+	Default *SignConfig
+	// Options は署名時のデフォルト値と制約。nilの場合はDefaultSignerOptions
+	Options *SignerOptions
+	// SkipAutomated は署名しない自動送信のメッセージの種類(DetectAutomated)
+	// バウンスや自動応答に署名しない運用で指定する。受信側の検証には影響しない
+	// nilの場合はすべてのメッセージに署名する
+	SkipAutomated []AutomatedKind
+}
+
+// Match は入力に一致するルールの設定を返す
+// 一致するルールがない場合はDefault(nilの場合はfalse)を返す
+func (s *SignRules) Match(in SignInput) (*SignConfig, bool) {
+	fromDomain := headerFromDomain(in.Headers)
+	for i := range s.Rules {
+		if s.Rules[i].match(in, fromDomain) {
+			return &s.Rules[i].Config, true
+		}
+	}
+	if s.Default != nil {
+		return s.Default, true
+	}
+	return nil, false
+}
+
+// Sign は一致したルールの設定で署名し、DKIM-Signatureを返す
+// bodyHashは一致した設定の正規化方式で計算したボディーハッシュ
+// 正規化方式によってボディーハッシュが異なるため、複数の正規化方式を使う場合は
+// Matchで設定を取得してから SignConfig.Sign を使う
+// SkipAutomatedに該当するメッセージの場合はErrSignSkippedを返す
+func (s *SignRules) Sign(in SignInput, bodyHash string) (*Signature, error) {
+	if err := s.checkSkip(in); err != nil {
+		return nil, err
+	}
+	c, ok := s.Match(in)
+	if !ok {
+		return nil, ErrNoSignRule
+	}
+	return c.sign(in.Headers, bodyHash, s.Options)
+}
+
+// Sign は設定に従って署名したDKIM-Signatureを返す
+// 設定にない値はDefaultSignerOptionsで補う
+func (c *SignConfig) Sign(headers []string, bodyHash string) (*Signature, error) {
+	return c.sign(headers, bodyHash, nil)
+}
+
+func (c *SignConfig) sign(headers []string, bodyHash string, opts *SignerOptions) (*Signature, error) {
+	if c.Key == nil {
+		return nil, errors.New("dkim: signing key is nil")
+	}
+	sig := &Signature{
+		Version:          1,
+		Algorithm:        c.Algorithm,
+		BodyHash:         bodyHash,
+		Canonicalization: c.Canonicalization,
+		Domain:           c.Domain,
+		Selector:         c.Selector,
+		Identity:         c.Identity,
+		Headers:          strings.Join(c.Headers, ":"),
+	}
+	if err := sig.SignWithOptions(headers, c.Key, opts); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (r *SignRule) match(in SignInput, fromDomain string) bool {
+	if r.FromDomain != "" && !matchDomainPattern(r.FromDomain, fromDomain) {
+		return false
+	}
+	if r.EnvelopeSender != "" {
+		sender := strings.ToLower(strings.Trim(strings.TrimSpace(in.EnvelopeSender), "<>"))
+		if strings.Contains(r.EnvelopeSender, "@") {
+			if !strings.EqualFold(r.EnvelopeSender, sender) {
+				return false
+			}
+		} else {
+			_, domain, _ := strings.Cut(sender, "@")
+			if !matchDomainPattern(r.EnvelopeSender, domain) {
+				return false
+			}
+		}
+	}
+	if r.HeaderPresent != "" && !hasHeader(in.Headers, r.HeaderPresent) {
+		return false
+	}
+	return true
+}
+
+// ドメインがパターンに一致するか
+// "*.example.com" はサブドメインのみ、".example.com" はexample.comとサブドメインに一致する
+func matchDomainPattern(pattern, domain string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(domain, pattern[1:])
+	case strings.HasPrefix(pattern, "."):
+		return domain == pattern[1:] || strings.HasSuffix(domain, pattern)
+	}
+	return domain == pattern
+}
+
+func hasHeader(headers []string, name string) bool {
+	for _, h := range headers {
+		k, _, ok := strings.Cut(h, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fromヘッダのドメインを返す
+func headerFromDomain(headers []string) string {
+	from := header.ExtractHeader(headers, "From")
+	if from == "" {
+		return ""
+	}
+	_, v, _ := strings.Cut(from, ":")
+	d, err := header.ParseAddressDomain(v)
+	if err != nil {
+		return ""
+	}
+	return d
+}
@@ -0,0 +1,122 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func TestSignRulesMatch(t *testing.T) {
+	rules := &SignRules{
+		Rules: []SignRule{
+			{Name: "newsletter", FromDomain: "example.com", HeaderPresent: "List-Unsubscribe", Config: SignConfig{Domain: "example.com", Selector: "news"}},
+			{Name: "customer-a", FromDomain: ".customer-a.example", Config: SignConfig{Domain: "customer-a.example", Selector: "a"}},
+			{Name: "customer-b subdomains", FromDomain: "*.customer-b.example", Config: SignConfig{Domain: "customer-b.example", Selector: "b"}},
+			{Name: "bounce", EnvelopeSender: "bounce@example.net", Config: SignConfig{Domain: "example.net", Selector: "bounce"}},
+			{Name: "example.com", FromDomain: "example.com", Config: SignConfig{Domain: "example.com", Selector: "default"}},
+		},
+		Default: &SignConfig{Domain: "provider.example", Selector: "fallback"},
+	}
+
+	testCases := []struct {
+		name         string
+		headers      []string
+		sender       string
+		wantSelector string
+	}{
+		{
+			name:         "header present",
+			headers:      []string{"From: a@example.com\r\n", "List-Unsubscribe: <mailto:u@example.com>\r\n"},
+			wantSelector: "news",
+		},
+		{
+			name:         "header absent falls through",
+			headers:      []string{"From: a@example.com\r\n"},
+			wantSelector: "default",
+		},
+		{
+			name:         "domain and subdomain",
+			headers:      []string{"From: a@mail.customer-a.example\r\n"},
+			wantSelector: "a",
+		},
+		{
+			name:         "wildcard does not match apex",
+			headers:      []string{"From: a@customer-b.example\r\n"},
+			wantSelector: "fallback",
+		},
+		{
+			name:         "wildcard matches subdomain",
+			headers:      []string{"From: a@x.customer-b.example\r\n"},
+			wantSelector: "b",
+		},
+		{
+			name:         "envelope sender",
+			headers:      []string{"From: a@other.example\r\n"},
+			sender:       "<Bounce@example.net>",
+			wantSelector: "bounce",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, ok := rules.Match(SignInput{Headers: tc.headers, EnvelopeSender: tc.sender})
+			if !ok {
+				t.Fatalf("no rule matched")
+			}
+			if c.Selector != tc.wantSelector {
+				t.Errorf("want selector %s, but got %s", tc.wantSelector, c.Selector)
+			}
+		})
+	}
+
+	if _, err := (&SignRules{}).Sign(SignInput{}, ""); !errors.Is(err, ErrNoSignRule) {
+		t.Errorf("want ErrNoSignRule, but got %v", err)
+	}
+}
+
+func TestSignConfigSign(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+
+	c := &SignConfig{
+		Domain:   "example.com",
+		Selector: "selector",
+		Key:      priv.(*rsa.PrivateKey),
+		Headers:  []string{"from", "subject"},
+	}
+	headers := []string{
+		"From: a@example.com\r\n",
+		"X-Mailer: test\r\n",
+		"Subject: test\r\n",
+	}
+	sig, err := c.Sign(headers, "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=")
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if sig.Headers != "From:Subject" {
+		t.Errorf("want h=From:Subject, but got %s", sig.Headers)
+	}
+	if sig.Canonicalization != "relaxed/relaxed" || sig.Algorithm != SignatureAlgorithmRSA_SHA256 {
+		t.Errorf("unexpected defaults: c=%s a=%s", sig.Canonicalization, sig.Algorithm)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&priv.(*rsa.PrivateKey).PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+	parsed, err := ParseSignature("DKIM-Signature: " + sig.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+	parsed.VerifyWithOptions(headers, "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo=", nil, &VerifyOptions{Resolver: resolver})
+	if parsed.VerifyResult.Status() != VerifyStatusPass {
+		t.Errorf("want pass, but got %v (%v)", parsed.VerifyResult.Status(), parsed.VerifyResult.Error())
+	}
+}
@@ -0,0 +1,55 @@
+package dkim
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/masa23/mmauth/errcode"
+)
+
+// FutureTimestampPolicy はt=が現在時刻より未来の署名の扱い
+// RFC 6376 3.5: 検証者は未来のタイムスタンプを持つ署名を無視してもよい
+// 送信者の時刻の設定誤りやタイムスタンプの偽装の兆候になる
+type FutureTimestampPolicy string
+
+const (
+	// FutureTimestampIgnore はt=を確認しない(デフォルト)
+	FutureTimestampIgnore FutureTimestampPolicy = ""
+	// FutureTimestampAnnotate は検証結果に注記を付ける
+	FutureTimestampAnnotate FutureTimestampPolicy = "annotate"
+	// FutureTimestampReject は注記を付け、検証に成功していてもpermerrorにする
+	FutureTimestampReject FutureTimestampPolicy = "reject"
+)
+
+// DefaultMaxClockSkew はVerifyOptions.MaxClockSkewが0の場合に許容する時刻のずれ
+const DefaultMaxClockSkew = 5 * time.Minute
+
+func (o *VerifyOptions) now() time.Time {
+	if o != nil && o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// t=が許容範囲を超えて未来であればポリシーに従って検証結果を変更する
+func (d *Signature) applyFutureTimestampPolicy(result *VerifyResult, opts *VerifyOptions) {
+	if opts.FutureTimestampPolicy == FutureTimestampIgnore || result == nil || d.Timestamp == 0 {
+		return
+	}
+	skew := opts.MaxClockSkew
+	if skew == 0 {
+		skew = DefaultMaxClockSkew
+	}
+	delta := time.Unix(d.Timestamp, 0).Sub(opts.now())
+	if delta <= skew {
+		return
+	}
+	delta = delta.Truncate(time.Second)
+	result.timestampSkew = delta
+	result.annotations = append(result.annotations, fmt.Sprintf("future-timestamp:%ds", int64(delta/time.Second)))
+	if opts.FutureTimestampPolicy == FutureTimestampReject && result.status == VerifyStatusPass {
+		result.status = VerifyStatusPermErr
+		result.err = errcode.Errorf(errcode.DKIMPermErrorFutureTimestamp, "DKIM-Signature timestamp is in the future: t=%d delta=%s", d.Timestamp, delta)
+		result.msg = "signature timestamp is in the future"
+	}
+}
@@ -0,0 +1,95 @@
+package dkim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/internal/idn"
+)
+
+var (
+	// ErrInvalidSelector はs=がRFC 6376のselectorの文法に従っていない場合のエラー
+	ErrInvalidSelector = errors.New("dkim: invalid selector")
+	// ErrInvalidDomain はd=がRFC 6376のSDIDの文法に従っていない場合のエラー
+	ErrInvalidDomain = errors.New("dkim: invalid domain")
+)
+
+const (
+	maxLabelLength  = 63
+	maxDomainLength = 253
+)
+
+// ValidateSelector はs=の値がRFC 6376 3.1の文法(sub-domain *("." sub-domain))に従っているかを検証する
+// 各ラベルは英数字とハイフンのみで、先頭と末尾は英数字でなければならない
+// アンダースコアは鍵のレコード名の_domainkeyにのみ使われるため、selectorには使えない
+func ValidateSelector(selector string) error {
+	if err := validateLabels(selector); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidSelector, selector, err)
+	}
+	return nil
+}
+
+// ValidateDomain はd=の値がRFC 6376 3.5のSDIDの文法に従っているかを検証する
+// U-labelを含む場合はA-labelに変換してから検証する (RFC 8616)
+// 末尾のドットは許可しない
+func ValidateDomain(domain string) error {
+	ascii, err := idn.ToASCII(domain)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidDomain, domain, err)
+	}
+	if err := validateLabels(ascii); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidDomain, domain, err)
+	}
+	if !strings.Contains(ascii, ".") {
+		return fmt.Errorf("%w %q: must have at least two labels", ErrInvalidDomain, domain)
+	}
+	return nil
+}
+
+// validateKeyName はselectorとdomainから作る鍵のレコード名
+// (selector._domainkey.domain)がDNSの長さの上限を超えないかを検証する
+func validateKeyName(selector, domain string) error {
+	if err := ValidateSelector(selector); err != nil {
+		return err
+	}
+	if err := ValidateDomain(domain); err != nil {
+		return err
+	}
+	if name := domainkey.KeyRecordName(selector, domain); len(name) > maxDomainLength {
+		return fmt.Errorf("%w: key record name is too long (%d > %d): %s", ErrInvalidSelector, len(name), maxDomainLength, name)
+	}
+	return nil
+}
+
+// ドット区切りの各ラベルがLet-dig [Ldh-str]の形式であることを検証する
+func validateLabels(name string) error {
+	if name == "" {
+		return errors.New("empty")
+	}
+	if len(name) > maxDomainLength {
+		return fmt.Errorf("too long (%d > %d)", len(name), maxDomainLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return errors.New("empty label")
+		}
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("label %q is too long (%d > %d)", label, len(label), maxLabelLength)
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '-':
+				if i == 0 || i == len(label)-1 {
+					return fmt.Errorf("label %q must not start or end with a hyphen", label)
+				}
+			default:
+				return fmt.Errorf("label %q contains invalid character %q", label, c)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package dkim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateSelector(t *testing.T) {
+	testCases := []struct {
+		selector string
+		wantErr  bool
+	}{
+		{selector: "selector"},
+		{selector: "rs20240124"},
+		{selector: "brisbane.2024"},
+		{selector: "s-1"},
+		{selector: "", wantErr: true},
+		{selector: "sel_ector", wantErr: true},
+		{selector: "-sel", wantErr: true},
+		{selector: "sel-", wantErr: true},
+		{selector: "a..b", wantErr: true},
+		{selector: "sel ector", wantErr: true},
+		{selector: strings.Repeat("a", 64), wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.selector, func(t *testing.T) {
+			err := ValidateSelector(tc.selector)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSelector) {
+				t.Errorf("want ErrInvalidSelector, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateDomain(t *testing.T) {
+	testCases := []struct {
+		domain  string
+		wantErr bool
+	}{
+		{domain: "example.com"},
+		{domain: "Mail.Example.CO.JP"},
+		{domain: "例え.jp"},
+		{domain: "xn--r8jz45g.jp"},
+		{domain: "", wantErr: true},
+		{domain: "localhost", wantErr: true},
+		{domain: "example.com.", wantErr: true},
+		{domain: "_dmarc.example.com", wantErr: true},
+		{domain: "exa mple.com", wantErr: true},
+		{domain: strings.Repeat("a.", 127) + "com", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			err := ValidateDomain(tc.domain)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidDomain) {
+				t.Errorf("want ErrInvalidDomain, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestSignatureSelectorValidation(t *testing.T) {
+	sig := "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=bad_selector; h=from; bh=bodyhash; b=signature\r\n"
+	if _, err := ParseSignature(sig); !errors.Is(err, ErrInvalidSelector) {
+		t.Errorf("want ErrInvalidSelector on parse, but got %v", err)
+	}
+
+	s := &Signature{
+		Version:          1,
+		Algorithm:        SignatureAlgorithmRSA_SHA256,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "bad_selector",
+		BodyHash:         "bodyhash",
+	}
+	err := s.Sign([]string{"From: user@example.com\r\n"}, nil)
+	if !errors.Is(err, ErrInvalidSelector) {
+		t.Errorf("want ErrInvalidSelector on sign, but got %v", err)
+	}
+
+	// 鍵のレコード名全体が253文字を超える場合
+	s.Selector = strings.Repeat("a", 63) + "." + strings.Repeat("b", 63)
+	s.Domain = strings.Repeat("c", 63) + "." + strings.Repeat("d", 50) + ".com"
+	if err := s.Sign([]string{"From: user@example.com\r\n"}, nil); !errors.Is(err, ErrInvalidSelector) {
+		t.Errorf("want ErrInvalidSelector for long key name, but got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package dkim
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	body := []byte(strings.Repeat("hoge  fuga\r\n", 100) + "\r\n\r\n")
+	headers, resolver := newBulkTestMessage(t, 3, body)
+
+	testCases := []struct {
+		name   string
+		body   []byte
+		status VerifyStatus
+	}{
+		{name: "pass", body: body, status: VerifyStatusPass},
+		{name: "modified body", body: append([]byte("x"), body...), status: VerifyStatusFail},
+		{name: "pass after fail", body: body, status: VerifyStatusPass},
+	}
+
+	v := NewVerifier(&VerifyOptions{Resolver: resolver})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := ParseDKIMHeaders(headers)
+			if err != nil {
+				t.Fatalf("failed to parse headers: %v", err)
+			}
+			if err := want.VerifyAll(headers, bytes.NewReader(tc.body), &VerifyOptions{Resolver: resolver}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// 同じVerifierを並行して使っても結果が変わらないこと
+			var wg sync.WaitGroup
+			for n := 0; n < 4; n++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := ParseDKIMHeaders(headers)
+					if err != nil {
+						t.Errorf("failed to parse headers: %v", err)
+						return
+					}
+					if err := v.VerifyAll(got, headers, bytes.NewReader(tc.body)); err != nil {
+						t.Errorf("unexpected error: %v", err)
+						return
+					}
+					for i, sig := range *got {
+						if sig.VerifyResult.Status() != tc.status {
+							t.Errorf("signature %d: want %s, but got %s", i, tc.status, sig.VerifyResult.Status())
+						}
+						if w := (*want)[i].VerifyResult; sig.VerifyResult.Status() != w.Status() {
+							t.Errorf("signature %d: want %s, but got %s", i, w.Status(), sig.VerifyResult.Status())
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package dkim
+
+import (
+	"crypto/subtle"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/internal/bodyhash"
+	"github.com/masa23/mmauth/internal/ttlcache"
+)
+
+const (
+	// DefaultVerifyCacheTTL は検証に成功した署名を覚えておく期間
+	DefaultVerifyCacheTTL = 5 * time.Minute
+	// DefaultVerifyCacheMaxEntries は保持する署名の上限
+	DefaultVerifyCacheMaxEntries = 10000
+)
+
+type verifyCacheKey struct {
+	domain    string
+	selector  string
+	signature string
+	options   verifyCacheOptions
+}
+
+// 検証結果に影響するVerifyOptionsの項目
+// 異なるオプションで検証に成功した署名を別の呼び出しで使わないようにキーに含める
+type verifyCacheOptions struct {
+	acceptRSAPSS           bool
+	experimentalAlgorithms string
+	enforceGranularity     bool
+	sha1Policy             domainkey.SHA1Policy
+	strictEd25519Keys      bool
+	futureTimestampPolicy  FutureTimestampPolicy
+	maxClockSkew           time.Duration
+}
+
+type verifyCacheEntry struct {
+	algorithm SignatureAlgorithm
+	bodyHash  string
+	publicKey string
+	digest    []byte
+}
+
+// VerifyCacheStats はVerifyCacheの統計
+type VerifyCacheStats struct {
+	// Hits は署名の検証を省略した回数
+	Hits uint64
+	// Misses はキャッシュになく署名を検証した回数
+	Misses uint64
+	// Mismatches は同じd=, s=, b=の署名がキャッシュにあったが、
+	// ヘッダのハッシュやbh=、公開鍵が異なったため検証を省略しなかった回数
+	Mismatches uint64
+	// Entries は現在保持している署名の数
+	Entries int
+}
+
+// VerifyCache は検証に成功した署名(d=, s=, b=)を短い期間だけ覚えておき、
+// メーリングリストの一斉配信のように全く同じ署名のメッセージが続く場合に公開鍵暗号の計算を省略する
+//
+// 検証に成功した署名のみを保持し、再利用するのはb=の値全体に加えて
+// 正規化したヘッダのハッシュ、bh=、a=、公開鍵がすべて一致する場合に限る。
+// そのため異なる内容のメッセージに同じb=を付けてもキャッシュの結果は使われない。
+// AcceptRSAPSSなど検証結果に影響するオプションが異なる呼び出しの間では共有しない。
+// 有効期限や鍵のポリシーなど、暗号の計算以外の確認は毎回行う
+// MaxEntriesに達した場合は最も早く期限が切れる署名から削除する
+type VerifyCache struct {
+	// TTL は署名を覚えておく期間。0以下の場合はDefaultVerifyCacheTTL
+	TTL time.Duration
+	// MaxEntries は保持する署名の上限。0以下の場合はDefaultVerifyCacheMaxEntries
+	MaxEntries int
+
+	entries ttlcache.Cache[verifyCacheKey, *verifyCacheEntry]
+	mu      sync.Mutex
+	stats   VerifyCacheStats
+	now     func() time.Time
+}
+
+// NewVerifyCache はttlの期間だけ署名を覚えておくVerifyCacheを作成する
+func NewVerifyCache(ttl time.Duration) *VerifyCache {
+	return &VerifyCache{TTL: ttl}
+}
+
+// Stats は統計を返す
+func (c *VerifyCache) Stats() VerifyCacheStats {
+	c.mu.Lock()
+	s := c.stats
+	c.mu.Unlock()
+	s.Entries = c.entries.Len()
+	return s
+}
+
+func (c *VerifyCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func (c *VerifyCache) key(d *Signature, opts *VerifyOptions) verifyCacheKey {
+	algos := make([]string, 0, len(opts.ExperimentalAlgorithms))
+	for _, a := range opts.ExperimentalAlgorithms {
+		algos = append(algos, strings.ToLower(string(a)))
+	}
+	sort.Strings(algos)
+	return verifyCacheKey{
+		domain:    strings.ToLower(d.Domain),
+		selector:  strings.ToLower(d.Selector),
+		signature: d.Signature,
+		options: verifyCacheOptions{
+			acceptRSAPSS:           opts.AcceptRSAPSS,
+			experimentalAlgorithms: strings.Join(algos, ","),
+			enforceGranularity:     opts.EnforceGranularity,
+			sha1Policy:             opts.SHA1Policy.Resolve(),
+			strictEd25519Keys:      opts.StrictEd25519Keys,
+			futureTimestampPolicy:  opts.FutureTimestampPolicy,
+			maxClockSkew:           opts.MaxClockSkew,
+		},
+	}
+}
+
+// 署名がoptsで検証済みとして記録されているか確認する
+// nilの場合は常にfalse
+func (c *VerifyCache) lookup(d *Signature, domainKey *domainkey.DomainKey, digest []byte, opts *VerifyOptions) bool {
+	if c == nil {
+		return false
+	}
+	e, ok := c.entries.Get(c.key(d, opts), c.clock())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		c.stats.Misses++
+		return false
+	}
+	if e.algorithm != d.Algorithm || !bodyhash.Equal(e.bodyHash, d.BodyHash) ||
+		e.publicKey != domainKey.PublicKey || subtle.ConstantTimeCompare(e.digest, digest) != 1 {
+		c.stats.Mismatches++
+		c.stats.Misses++
+		return false
+	}
+	c.stats.Hits++
+	return true
+}
+
+// optsで検証に成功した署名を記録する
+func (c *VerifyCache) store(d *Signature, domainKey *domainkey.DomainKey, digest []byte, opts *VerifyOptions) {
+	if c == nil {
+		return
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultVerifyCacheTTL
+	}
+	max := c.MaxEntries
+	if max <= 0 {
+		max = DefaultVerifyCacheMaxEntries
+	}
+	now := c.clock()
+	c.entries.Set(c.key(d, opts), &verifyCacheEntry{
+		algorithm: d.Algorithm,
+		bodyHash:  d.BodyHash,
+		publicKey: domainKey.PublicKey,
+		digest:    append([]byte(nil), digest...),
+	}, now.Add(ttl), now, max)
+}
@@ -0,0 +1,157 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestVerifyCache(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	key := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal pkix public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	signer := &Signature{
+		Version:   1,
+		Algorithm: SignatureAlgorithmRSA_SHA256,
+		BodyHash:  bodyHash,
+		Domain:    "example.com",
+		Selector:  "selector",
+	}
+	if err := signer.SignWithOptions(headers, key, &SignerOptions{Canonicalization: "relaxed/relaxed"}); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	raw := "DKIM-Signature: " + signer.String() + "\r\n"
+
+	now := time.Unix(1700000000, 0)
+	cache := NewVerifyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+	opts := &VerifyOptions{Resolver: resolver, Cache: cache}
+
+	verify := func(headers []string) VerifyStatus {
+		t.Helper()
+		sig, err := ParseSignature(raw)
+		if err != nil {
+			t.Fatalf("failed to parse signature: %v", err)
+		}
+		sig.VerifyWithOptions(headers, bodyHash, nil, opts)
+		return sig.VerifyResult.Status()
+	}
+
+	testCases := []struct {
+		name    string
+		headers []string
+		advance time.Duration
+		want    VerifyStatus
+		stats   VerifyCacheStats
+	}{
+		{
+			name:    "first verification",
+			headers: headers,
+			want:    VerifyStatusPass,
+			stats:   VerifyCacheStats{Misses: 1, Entries: 1},
+		},
+		{
+			name:    "duplicate message",
+			headers: headers,
+			want:    VerifyStatusPass,
+			stats:   VerifyCacheStats{Hits: 1, Misses: 1, Entries: 1},
+		},
+		{
+			name:    "same b= with altered header",
+			headers: []string{"From: hogefuga@example.com\r\n", "Subject: forged\r\n"},
+			want:    VerifyStatusFail,
+			stats:   VerifyCacheStats{Hits: 1, Misses: 2, Mismatches: 1, Entries: 1},
+		},
+		{
+			name:    "expired entry",
+			headers: headers,
+			advance: 2 * time.Minute,
+			want:    VerifyStatusPass,
+			stats:   VerifyCacheStats{Hits: 1, Misses: 3, Mismatches: 1, Entries: 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(tc.advance)
+			if got := verify(tc.headers); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+			if got := cache.Stats(); got != tc.stats {
+				t.Errorf("want stats %+v, but got %+v", tc.stats, got)
+			}
+		})
+	}
+}
+
+func TestVerifyCacheOptions(t *testing.T) {
+	block, _ := pem.Decode([]byte(testRSAPrivateKey))
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse pkcs8 private key: %s", err)
+	}
+	key := priv.(*rsa.PrivateKey)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal pkix public key: %s", err)
+	}
+	resolver := NewMockTXTResolver()
+	resolver.AddRecord("selector._domainkey.example.com", "v=DKIM1; p="+base64.StdEncoding.EncodeToString(der))
+	headers := []string{
+		"From: hogefuga@example.com\r\n",
+		"Subject: test\r\n",
+	}
+	bodyHash := "XgF6uYzcgcROQtd83d1Evx8x2uW+SniFx69skZp5azo="
+
+	// RSASSA-PSSの署名はAcceptRSAPSSを指定した場合だけ検証に成功する
+	signer := &Signature{
+		Version:   1,
+		Algorithm: SignatureAlgorithmRSA_SHA256,
+		BodyHash:  bodyHash,
+		Domain:    "example.com",
+		Selector:  "selector",
+	}
+	if err := signer.SignWithOptions(headers, key, &SignerOptions{Canonicalization: "relaxed/relaxed", RSAPSS: true}); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig, err := ParseSignature("DKIM-Signature: " + signer.String() + "\r\n")
+	if err != nil {
+		t.Fatalf("failed to parse signature: %v", err)
+	}
+
+	cache := NewVerifyCache(time.Minute)
+	cache.MaxEntries = 1
+	permissive := &VerifyOptions{Resolver: resolver, Cache: cache, AcceptRSAPSS: true}
+	if got := sig.Evaluate(headers, bodyHash, nil, permissive).Status(); got != VerifyStatusPass {
+		t.Fatalf("want %s, but got %s", VerifyStatusPass, got)
+	}
+	// AcceptRSAPSSを指定していない呼び出しではキャッシュの結果を使わない
+	strict := &VerifyOptions{Resolver: resolver, Cache: cache}
+	if got := sig.Evaluate(headers, bodyHash, nil, strict).Status(); got != VerifyStatusFail {
+		t.Errorf("want %s, but got %s", VerifyStatusFail, got)
+	}
+	if got := sig.Evaluate(headers, bodyHash, nil, permissive).Status(); got != VerifyStatusPass {
+		t.Errorf("want %s, but got %s", VerifyStatusPass, got)
+	}
+	want := VerifyCacheStats{Hits: 1, Misses: 2, Entries: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("want stats %+v, but got %+v", want, got)
+	}
+}
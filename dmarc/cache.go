@@ -0,0 +1,172 @@
+package dmarc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/masa23/mmauth/internal/idn"
+	"github.com/masa23/mmauth/internal/ttlcache"
+)
+
+// TXTLookupWithTTLFunc はTTL付きでTXTレコードを問い合わせる関数
+// DNSの応答に含まれるTTLをキャッシュの有効期限として使う場合に指定する
+type TXTLookupWithTTLFunc func(name string) ([]string, time.Duration, error)
+
+const (
+	// DefaultCacheTTL はTTLが得られない場合に肯定応答をキャッシュする期間
+	DefaultCacheTTL = 5 * time.Minute
+	// DefaultCacheNegativeTTL はレコードが存在しない場合にキャッシュする期間
+	DefaultCacheNegativeTTL = time.Minute
+	// DefaultCacheMaxEntries はキャッシュするドメイン数の上限
+	DefaultCacheMaxEntries = 10000
+)
+
+type cacheEntry struct {
+	record *Record
+	err    error
+}
+
+// Cache はDMARCレコードの問い合わせ結果をキャッシュする
+// レコードが見つかった場合だけでなく、見つからなかった場合や
+// 不正なレコードだった場合も期限付きで保持する。
+// DNSの一時的な失敗はキャッシュしない。
+//
+// キーは正規化(小文字化・末尾のドット除去・A-labelへの変換)したドメイン名で、
+// サブドメインからのフォールバックで参照される組織ドメインのレコードは
+// 同じ組織ドメイン配下のメッセージ間で共有される。
+// 有効期限と上限の扱いはresolver.TXTCacheと同じ
+type Cache struct {
+	// Lookup はTTL付きの問い合わせ関数。nilの場合はDefaultResolverを使い、TTLにはDefaultTTLを使う
+	Lookup TXTLookupWithTTLFunc
+	// DefaultTTL はLookupがTTLを返さない(0以下)場合の肯定応答のキャッシュ期間
+	DefaultTTL time.Duration
+	// NegativeTTL はレコードが存在しない場合のキャッシュ期間
+	NegativeTTL time.Duration
+	// MaxTTL はDNSから得たTTLの上限。0の場合は制限しない
+	MaxTTL time.Duration
+	// MaxEntries はキャッシュするドメイン数の上限。0以下の場合はDefaultCacheMaxEntries
+	MaxEntries int
+
+	entries ttlcache.Cache[string, *cacheEntry]
+	now     func() time.Time
+}
+
+// NewCache はデフォルトのTTLでCacheを作成する
+func NewCache() *Cache {
+	return &Cache{
+		DefaultTTL:  DefaultCacheTTL,
+		NegativeTTL: DefaultCacheNegativeTTL,
+		MaxEntries:  DefaultCacheMaxEntries,
+	}
+}
+
+// LookupRecord はキャッシュを使ってdomainのDMARCレコードを問い合わせる
+// 返されるRecordはキャッシュとは別のコピー
+func (c *Cache) LookupRecord(domain string) (*Record, error) {
+	key := normalizeDomain(domain)
+
+	now := c.clock()
+	if e, ok := c.entries.Get(key, now); ok {
+		if e.err != nil {
+			return nil, e.err
+		}
+		return e.record.clone(), nil
+	}
+
+	record, ttl, cacheable, err := c.fetch(key)
+	if cacheable {
+		if err != nil {
+			ttl = c.NegativeTTL
+		}
+		if ttl > 0 {
+			c.entries.Set(key, &cacheEntry{record: record, err: err}, now.Add(ttl), now, c.maxEntries())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.clone(), nil
+}
+
+// LookupRecordWithSubdomainFallback はキャッシュを使ってLookupRecordWithSubdomainFallbackと同じ探索を行う
+func (c *Cache) LookupRecordWithSubdomainFallback(domain string) (*Record, error) {
+	return lookupRecordWithSubdomainFallback(normalizeDomain(domain), c.LookupRecord)
+}
+
+// Purge はキャッシュをすべて破棄する
+func (c *Cache) Purge() {
+	c.entries.Purge()
+}
+
+// Len はキャッシュされているドメイン数を返す
+func (c *Cache) Len() int {
+	return c.entries.Len()
+}
+
+func (c *Cache) fetch(domain string) (record *Record, ttl time.Duration, cacheable bool, err error) {
+	query := fmt.Sprintf("_dmarc.%s", domain)
+	var res []string
+	var lookupErr error
+	if c.Lookup != nil {
+		res, ttl, lookupErr = c.Lookup(query)
+	} else {
+		res, lookupErr = DefaultResolver(query)
+	}
+	if ttl <= 0 {
+		ttl = c.DefaultTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+
+	record, err = recordFromTXT(res, lookupErr)
+	if lookupErr != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(lookupErr, &dnsErr) || !dnsErr.IsNotFound {
+			// 一時的な失敗は次の問い合わせで回復する可能性があるためキャッシュしない
+			return record, ttl, false, err
+		}
+	}
+	return record, ttl, true, err
+}
+
+func (c *Cache) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return DefaultCacheMaxEntries
+	}
+	return c.MaxEntries
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// normalizeDomain はドメイン名を比較・キャッシュ用に正規化する
+// 国際化ドメイン名はA-labelに変換する
+func normalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if ascii, err := idn.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	return domain
+}
+
+// clone はキャッシュ内のRecordが呼び出し側で変更されないようにコピーを作る
+func (r *Record) clone() *Record {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.AggregateReportURI = append([]ReportURI(nil), r.AggregateReportURI...)
+	c.ForensicReportURI = append([]ReportURI(nil), r.ForensicReportURI...)
+	c.FailureOptions = append([]FailureOption(nil), r.FailureOptions...)
+	c.ReportFormat = append([]ReportFormat(nil), r.ReportFormat...)
+	c.invalidReportURIs = append([]error(nil), r.invalidReportURIs...)
+	return &c
+}
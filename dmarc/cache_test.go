@@ -0,0 +1,154 @@
+package dmarc
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCacheLookupRecord(t *testing.T) {
+	testCases := []struct {
+		name       string
+		records    map[string][]string
+		lookupErr  error
+		lookups    []string
+		wantErr    error
+		wantPolicy PolicyType
+		wantCalls  int
+	}{
+		{
+			name:       "positive result is cached",
+			records:    map[string][]string{"_dmarc.example.jp": {"v=DMARC1; p=reject;"}},
+			lookups:    []string{"example.jp", "example.jp"},
+			wantPolicy: PolicyReject,
+			wantCalls:  1,
+		},
+		{
+			name:       "key is normalized",
+			records:    map[string][]string{"_dmarc.example.jp": {"v=DMARC1; p=quarantine;"}},
+			lookups:    []string{"Example.JP.", "example.jp"},
+			wantPolicy: PolicyQuarantine,
+			wantCalls:  1,
+		},
+		{
+			name:      "negative result is cached",
+			records:   map[string][]string{},
+			lookups:   []string{"example.jp", "example.jp"},
+			wantErr:   ErrNoRecordFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "temporary failure is not cached",
+			lookupErr: &net.DNSError{IsTimeout: true},
+			lookups:   []string{"example.jp", "example.jp"},
+			wantCalls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			c := NewCache()
+			c.Lookup = func(name string) ([]string, time.Duration, error) {
+				calls++
+				if tc.lookupErr != nil {
+					return nil, 0, tc.lookupErr
+				}
+				if r, ok := tc.records[name]; ok {
+					return r, time.Hour, nil
+				}
+				return nil, 0, &net.DNSError{IsNotFound: true}
+			}
+			for _, domain := range tc.lookups {
+				got, err := c.LookupRecord(domain)
+				if tc.lookupErr != nil {
+					if err == nil {
+						t.Fatalf("expected error, got nil")
+					}
+					continue
+				}
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				if err == nil && got.Policy != tc.wantPolicy {
+					t.Errorf("expected policy %s, got %s", tc.wantPolicy, got.Policy)
+				}
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("expected %d lookups, got %d", tc.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	c := NewCache()
+	c.MaxTTL = 10 * time.Minute
+	c.now = func() time.Time { return now }
+	c.Lookup = func(name string) ([]string, time.Duration, error) {
+		calls++
+		if name == "_dmarc.example.jp" {
+			return []string{"v=DMARC1; p=reject;"}, time.Hour, nil
+		}
+		return nil, 0, &net.DNSError{IsNotFound: true}
+	}
+
+	if _, err := c.LookupRecord("example.jp"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.LookupRecord("none.jp"); !errors.Is(err, ErrNoRecordFound) {
+		t.Fatalf("expected ErrNoRecordFound, got %v", err)
+	}
+
+	// NegativeTTL(1分)を超えたので否定応答だけ再問い合わせされる
+	now = now.Add(2 * time.Minute)
+	c.LookupRecord("example.jp")
+	c.LookupRecord("none.jp")
+	if calls != 3 {
+		t.Errorf("expected 3 lookups after negative expiry, got %d", calls)
+	}
+
+	// DNSのTTLは1時間だがMaxTTL(10分)で打ち切られる
+	now = now.Add(9 * time.Minute)
+	c.LookupRecord("example.jp")
+	if calls != 4 {
+		t.Errorf("expected 4 lookups after MaxTTL, got %d", calls)
+	}
+}
+
+func TestCacheLookupRecordWithSubdomainFallback(t *testing.T) {
+	calls := map[string]int{}
+	c := NewCache()
+	c.Lookup = func(name string) ([]string, time.Duration, error) {
+		calls[name]++
+		if name == "_dmarc.example.jp" {
+			return []string{"v=DMARC1; p=reject; sp=quarantine;"}, time.Hour, nil
+		}
+		return nil, 0, &net.DNSError{IsNotFound: true}
+	}
+
+	for _, domain := range []string{"a.example.jp", "b.example.jp", "a.example.jp"} {
+		got, err := c.LookupRecordWithSubdomainFallback(domain)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got.isSubdomainPolicy || got.SubdomainPolicy != PolicyQuarantine {
+			t.Errorf("expected subdomain policy quarantine, got %+v", got)
+		}
+	}
+	if calls["_dmarc.example.jp"] != 1 {
+		t.Errorf("expected organizational domain to be looked up once, got %d", calls["_dmarc.example.jp"])
+	}
+
+	// フォールバックで立てたフラグがキャッシュに漏れていないこと
+	got, err := c.LookupRecord("example.jp")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.isSubdomainPolicy {
+		t.Errorf("cached record must not be modified by fallback lookup")
+	}
+}
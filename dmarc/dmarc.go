@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/masa23/mmauth/internal/idn"
+	"github.com/masa23/mmauth/internal/txtrecord"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -16,10 +18,18 @@ type TXTLookupFunc func(name string) ([]string, error)
 // DefaultResolver is the default TXT lookup function.
 var DefaultResolver TXTLookupFunc = net.LookupTXT
 
+// StripTrailingNUL, when true, strips the NULs some broken DNS servers append
+// to TXT strings before DMARC records are checked and parsed. It defaults to
+// false, matching spf.Options.StripTrailingNUL.
+var StripTrailingNUL bool
+
 var (
 	ErrNoRecordFound   = errors.New("no record found")
 	ErrDNSLookupFailed = errors.New("dns lookup failed")
 	ErrMultipleRecords = errors.New("multiple DMARC records found")
+	// ErrControlCharacter is returned when a DMARC record contains control
+	// characters. Trailing NULs are stripped first if StripTrailingNUL is set.
+	ErrControlCharacter = txtrecord.ErrControlCharacter
 )
 
 type AlignmentMode string
@@ -57,6 +67,7 @@ const (
 // ReportURI represents a DMARC URI with an optional size limit.
 // Example: "mailto:reports@example.com!50m" (50 megabytes max)
 // Per RFC 7489 Section 6.2 and 6.4.
+// The URI must have a scheme; mailto: URIs must name valid addresses (see Addresses).
 type ReportURI struct {
 	URI     string // The report URI
 	MaxSize int64  // Maximum size in bytes (0 means no limit)
@@ -68,14 +79,26 @@ type Record struct {
 	AlignmentSPF       AlignmentMode   // aspf SPF alignment mode (r or s)
 	ForensicReportURI  []ReportURI     // ruf Forensic report URIs (optional, deprecated)
 	FailureOptions     []FailureOption // fo Forensic reporting options (optional, deprecated)
-	Percent            int             // pct Percentage of messages to apply policy to
-	Policy             PolicyType      // p Policy (none, quarantine, reject)
-	ReportFormat       []ReportFormat  // rf Format for message-specific failure reports
-	ReportInterval     uint32          // ri Interval for aggregate reports (seconds)
-	SubdomainPolicy    PolicyType      // sp Subdomain policy
-	Version            string          // v DMARC version, must be "DMARC1"
-	isSubdomainPolicy  bool            // isSubdomainPolicy true if this is a subdomain policy
-	raw                string          // raw record
+	// NonExistentSubdomainPolicy is the np tag: the policy for subdomains that
+	// do not exist in the DNS (RFC 9091 Section 4.1)
+	NonExistentSubdomainPolicy PolicyType
+	Percent                    int            // pct Percentage of messages to apply policy to
+	Policy                     PolicyType     // p Policy (none, quarantine, reject)
+	ReportFormat               []ReportFormat // rf Format for message-specific failure reports
+	ReportInterval             uint32         // ri Interval for aggregate reports (seconds)
+	SubdomainPolicy            PolicyType     // sp Subdomain policy
+	Version                    string         // v DMARC version, must be "DMARC1"
+	isSubdomainPolicy          bool           // isSubdomainPolicy true if this is a subdomain policy
+	isPSDPolicy                bool           // isPSDPolicy true if the record was found at the Public Suffix Domain
+	percentSet                 bool           // percentSet true if pct was specified
+	invalidReportURIs          []error        // invalidReportURIs errors for the skipped rua and ruf URIs
+	raw                        string         // raw record
+}
+
+// Raw returns the TXT record text the Record was parsed from, so the exact
+// published policy can be logged alongside the evaluation result.
+func (r *Record) Raw() string {
+	return r.raw
 }
 
 // parseReportURI parses a DMARC URI with optional size limit.
@@ -186,6 +209,9 @@ func parseReportURI(uri string) (*ReportURI, error) {
 	if result.URI == "" {
 		return nil, fmt.Errorf("empty URI in report URI: %s", uri)
 	}
+	if err := validateReportURI(result.URI); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
@@ -207,10 +233,22 @@ func getParentDomain(domain string) (string, error) {
 }
 
 func LookupRecordWithSubdomainFallback(domain string) (*Record, error) {
-	d, err := LookupRecord(domain)
+	if ascii, err := idn.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	return lookupRecordWithSubdomainFallback(domain, LookupRecord)
+}
+
+// lookupRecordWithSubdomainFallback は lookup を使って domain から親ドメインへ順にDMARCレコードを探す
+func lookupRecordWithSubdomainFallback(domain string, lookup func(string) (*Record, error)) (*Record, error) {
+	d, err := lookup(domain)
 	if err == nil {
 		return d, nil
 	}
+	// 一時的なエラーで親ドメインやPSDのポリシーを適用しないように探索をやめる
+	if errors.Is(err, ErrDNSLookupFailed) {
+		return nil, err
+	}
 	for {
 		orgDomain, err := getParentDomain(domain)
 		if err != nil {
@@ -219,11 +257,9 @@ func LookupRecordWithSubdomainFallback(domain string) (*Record, error) {
 		if orgDomain == domain {
 			return nil, ErrNoRecordFound
 		}
-		d, err = LookupRecord(orgDomain)
+		d, err = lookup(orgDomain)
 		if err == nil {
-			if d.SubdomainPolicy == "" {
-				return nil, ErrNoRecordFound
-			}
+			// sp=がない場合はp=をサブドメインに適用する (RFC 7489 6.6.3)
 			d.isSubdomainPolicy = true
 			return d, nil
 		}
@@ -245,20 +281,47 @@ func isDMARCRecord(raw string) bool {
 }
 
 func LookupRecord(domain string) (*Record, error) {
+	// 国際化ドメイン名はA-labelで問い合わせる
+	ascii, err := idn.ToASCII(domain)
+	if err != nil {
+		return nil, err
+	}
+	domain = ascii
 	query := fmt.Sprintf("_dmarc.%s", domain)
 	res, err := DefaultResolver(query)
-	if dnsErr, ok := err.(*net.DNSError); ok {
-		if dnsErr.IsNotFound {
+	return recordFromTXT(res, err)
+}
+
+// LookupRecordWith はDefaultResolverの代わりにlookupを使ってLookupRecordを行う
+// 組織ドメインへのフォールバックは行わない
+func LookupRecordWith(domain string, lookup TXTLookupFunc) (*Record, error) {
+	ascii, err := idn.ToASCII(domain)
+	if err != nil {
+		return nil, err
+	}
+	return recordFromTXT(lookup("_dmarc." + ascii))
+}
+
+// recordFromTXT はTXTの問い合わせ結果からDMARCレコードを取り出す
+func recordFromTXT(res []string, err error) (*Record, error) {
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
 			return nil, ErrNoRecordFound
 		}
-	} else if err != nil {
-		return nil, fmt.Errorf("dns lookup failed: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrDNSLookupFailed, err)
 	}
 	var dmarcRecords []string
 	for _, v := range res {
-		if isDMARCRecord(v) {
-			dmarcRecords = append(dmarcRecords, v)
+		if StripTrailingNUL {
+			v = txtrecord.StripNUL(v)
 		}
+		if !isDMARCRecord(v) {
+			continue
+		}
+		if err := txtrecord.Check(v); err != nil {
+			return nil, err
+		}
+		dmarcRecords = append(dmarcRecords, v)
 	}
 	if len(dmarcRecords) > 1 {
 		return nil, ErrMultipleRecords
@@ -312,9 +375,12 @@ func ParseRecord(raw string) (*Record, error) {
 				if uri == "" {
 					continue
 				}
+				// Invalid URIs are skipped rather than discarding the whole
+				// record and its policy (RFC 7489 Section 6.3)
 				parsed, err := parseReportURI(uri)
 				if err != nil {
-					return nil, fmt.Errorf("invalid rua URI: %w", err)
+					d.invalidReportURIs = append(d.invalidReportURIs, fmt.Errorf("invalid rua URI: %w", err))
+					continue
 				}
 				d.AggregateReportURI = append(d.AggregateReportURI, *parsed)
 			}
@@ -340,9 +406,12 @@ func ParseRecord(raw string) (*Record, error) {
 				if uri == "" {
 					continue
 				}
+				// Invalid URIs are skipped rather than discarding the whole
+				// record and its policy (RFC 7489 Section 6.3)
 				parsed, err := parseReportURI(uri)
 				if err != nil {
-					return nil, fmt.Errorf("invalid ruf URI: %w", err)
+					d.invalidReportURIs = append(d.invalidReportURIs, fmt.Errorf("invalid ruf URI: %w", err))
+					continue
 				}
 				d.ForensicReportURI = append(d.ForensicReportURI, *parsed)
 			}
@@ -365,6 +434,7 @@ func ParseRecord(raw string) (*Record, error) {
 				return nil, fmt.Errorf("pct value out of range: %d", pct)
 			}
 			d.Percent = pct
+			d.percentSet = true
 		case "p":
 			d.Policy = PolicyType(strings.TrimSpace(v))
 			if d.Policy != PolicyNone && d.Policy != PolicyQuarantine && d.Policy != PolicyReject {
@@ -407,6 +477,11 @@ func ParseRecord(raw string) (*Record, error) {
 			if d.SubdomainPolicy != PolicyNone && d.SubdomainPolicy != PolicyQuarantine && d.SubdomainPolicy != PolicyReject {
 				return nil, fmt.Errorf("invalid sp value: %s", d.SubdomainPolicy)
 			}
+		case "np":
+			d.NonExistentSubdomainPolicy = PolicyType(strings.TrimSpace(v))
+			if d.NonExistentSubdomainPolicy != PolicyNone && d.NonExistentSubdomainPolicy != PolicyQuarantine && d.NonExistentSubdomainPolicy != PolicyReject {
+				return nil, fmt.Errorf("invalid np value: %s", d.NonExistentSubdomainPolicy)
+			}
 		}
 	}
 
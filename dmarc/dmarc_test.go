@@ -102,10 +102,21 @@ func TestParseRecord(t *testing.T) {
 				AlignmentDKIM:      AlignmentStrict,
 				AlignmentSPF:       AlignmentRelaxed,
 				Percent:            50,
+				percentSet:         true,
 				ReportInterval:     3600,
 				raw:                "v=DMARC1; p=none; rua=mailto:agg@example.com; ruf=mailto:for@example.com; fo=1:d:s; adkim=s; aspf=r; pct=50; ri=3600; sp=quarantine;",
 			},
 		},
+		{
+			raw: "v=DMARC1; p=reject; sp=quarantine; np=reject;",
+			expected: &Record{
+				Version:                    "DMARC1",
+				Policy:                     PolicyReject,
+				SubdomainPolicy:            PolicyQuarantine,
+				NonExistentSubdomainPolicy: PolicyReject,
+				raw:                        "v=DMARC1; p=reject; sp=quarantine; np=reject;",
+			},
+		},
 		{
 			raw: "v=DMARC1; p=reject; adkim=r; aspf=s;",
 			expected: &Record{
@@ -122,6 +133,7 @@ func TestParseRecord(t *testing.T) {
 				Version:        "DMARC1",
 				Policy:         PolicyQuarantine,
 				Percent:        100,
+				percentSet:     true,
 				ReportInterval: 86400,
 				raw:            "v=DMARC1; p=quarantine; pct=100; ri=86400;",
 			},
@@ -135,6 +147,9 @@ func TestParseRecord(t *testing.T) {
 				t.Fatalf("Unexpected error: %v", err)
 			}
 			assertRecordEqual(t, got, tc.expected)
+			if got.Raw() != tc.raw {
+				t.Errorf("want %q, but got %q", tc.raw, got.Raw())
+			}
 		})
 	}
 }
@@ -145,6 +160,7 @@ func TestLookupRecord(t *testing.T) {
 		want     *Record
 		wantErr  error
 		resolver TXTLookupFunc
+		strip    bool
 	}{
 		{
 			domain: "example.jp",
@@ -197,15 +213,56 @@ func TestLookupRecord(t *testing.T) {
 			},
 			wantErr: ErrMultipleRecords,
 		},
+		{
+			domain: "example.jp",
+			want: &Record{
+				Version: "DMARC1",
+				Policy:  "reject",
+				raw:     "v=DMARC1; p=reject;",
+			},
+			resolver: func(name string) ([]string, error) {
+				if name == "_dmarc.example.jp" {
+					return []string{"token\x1b", "v=DMARC1; p=reject;\x00"}, nil
+				}
+				return nil, &net.DNSError{IsNotFound: true}
+			},
+			strip:   true,
+			wantErr: nil,
+		},
+		{
+			domain: "example.jp",
+			want:   nil,
+			resolver: func(name string) ([]string, error) {
+				if name == "_dmarc.example.jp" {
+					return []string{"v=DMARC1; p=reject;\x00"}, nil
+				}
+				return nil, &net.DNSError{IsNotFound: true}
+			},
+			wantErr: ErrControlCharacter,
+		},
+		{
+			domain: "example.jp",
+			want:   nil,
+			resolver: func(name string) ([]string, error) {
+				if name == "_dmarc.example.jp" {
+					return []string{"v=DMARC1; p=reject;\x1b"}, nil
+				}
+				return nil, &net.DNSError{IsNotFound: true}
+			},
+			wantErr: ErrControlCharacter,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("Lookup: %s", tc.domain), func(t *testing.T) {
 			originalResolver := DefaultResolver
+			originalStrip := StripTrailingNUL
 			t.Cleanup(func() {
 				DefaultResolver = originalResolver
+				StripTrailingNUL = originalStrip
 			})
 			DefaultResolver = tc.resolver
+			StripTrailingNUL = tc.strip
 			got, err := LookupRecord(tc.domain)
 			assertErrorEqual(t, err, tc.wantErr)
 			assertRecordEqual(t, got, tc.want)
@@ -312,15 +369,21 @@ func TestLookupRecordWithSubdomainFallback(t *testing.T) {
 			wantErr: nil,
 		},
 		{
+			// sp=がない組織ドメインのレコードはp=をサブドメインに適用する
 			domain: "sub.example.jp",
-			want:   nil,
+			want: &Record{
+				Version:           "DMARC1",
+				Policy:            "reject",
+				isSubdomainPolicy: true,
+				raw:               "v=DMARC1; p=reject;",
+			},
 			resolver: func(name string) ([]string, error) {
 				if name == "_dmarc.example.jp" {
 					return []string{"v=DMARC1; p=reject;"}, nil
 				}
 				return nil, &net.DNSError{IsNotFound: true}
 			},
-			wantErr: ErrNoRecordFound,
+			wantErr: nil,
 		},
 		{
 			domain: "example.jp",
@@ -445,6 +508,35 @@ func TestParseReportURI(t *testing.T) {
 			uri:       "mailto:reports@example.com!50m!extra",
 			wantError: true,
 		},
+		{
+			name:      "Invalid URI - missing scheme",
+			uri:       "reports@example.com",
+			wantError: true,
+		},
+		{
+			name:      "Invalid mailto - no address",
+			uri:       "mailto:!10m",
+			wantError: true,
+		},
+		{
+			name:      "Invalid mailto - not an addr-spec",
+			uri:       "mailto:reports.example.com",
+			wantError: true,
+		},
+		{
+			name:      "Invalid mailto - display name",
+			uri:       "mailto:Reports%20%3Creports@example.com%3E",
+			wantError: true,
+		},
+		{
+			name: "mailto with encoded recipients and header fields",
+			uri:  "mailto:a@example.com%2Cb@example.com?subject=dmarc!10m",
+			expected: &ReportURI{
+				URI:     "mailto:a@example.com%2Cb@example.com?subject=dmarc",
+				MaxSize: 10 << 20,
+			},
+			wantError: false,
+		},
 		{
 			name:      "Invalid size - overflow (too large for int64)",
 			uri:       "mailto:reports@example.com!8388608t", // 8,388,608 * 2^40 > MaxInt64 (max 8,388,607t; 8,388,608t already overflows)
@@ -667,3 +759,60 @@ func TestParseRecord_duplicateURITags(t *testing.T) {
 		})
 	}
 }
+
+func TestReportURIAccessors(t *testing.T) {
+	rec, err := ParseRecord("v=DMARC1; p=none; rua=mailto:a@example.com!10m, https://reports.example.com/dmarc, MAILTO:b@example.com%2CA@example.com; ruf=mailto:f@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a@example.com", "b@example.com"}; !reflect.DeepEqual(rec.AggregateReportAddresses(), want) {
+		t.Errorf("want %v, but got %v", want, rec.AggregateReportAddresses())
+	}
+	if want := []string{"f@example.com"}; !reflect.DeepEqual(rec.ForensicReportAddresses(), want) {
+		t.Errorf("want %v, but got %v", want, rec.ForensicReportAddresses())
+	}
+
+	testCases := []struct {
+		uri    ReportURI
+		scheme string
+		mailto bool
+		size   int64
+		allows bool
+	}{
+		{uri: rec.AggregateReportURI[0], scheme: "mailto", mailto: true, size: 10 << 20, allows: true},
+		{uri: rec.AggregateReportURI[0], scheme: "mailto", mailto: true, size: 10<<20 + 1, allows: false},
+		{uri: rec.AggregateReportURI[1], scheme: "https", mailto: false, size: 1 << 40, allows: true},
+		{uri: rec.AggregateReportURI[2], scheme: "mailto", mailto: true, size: 1, allows: true},
+	}
+	for _, tc := range testCases {
+		if tc.uri.Scheme() != tc.scheme || tc.uri.IsMailto() != tc.mailto {
+			t.Errorf("%s: want scheme %s, but got %s", tc.uri.URI, tc.scheme, tc.uri.Scheme())
+		}
+		if tc.uri.Allows(tc.size) != tc.allows {
+			t.Errorf("%s: want Allows(%d) %v, but got %v", tc.uri.URI, tc.size, tc.allows, !tc.allows)
+		}
+	}
+	if rec.AggregateReportURI[1].Addresses() != nil {
+		t.Errorf("want no addresses for https URI")
+	}
+}
+
+func TestParseRecord_invalidReportURI(t *testing.T) {
+	// 不正なURIは無視し、ポリシーはそのまま使う
+	rec, err := ParseRecord("v=DMARC1; p=reject; rua=dmarc@example.com, mailto:agg@example.com; ruf=mailto:f@example.com!50x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Policy != PolicyReject {
+		t.Errorf("expected policy %s, got %s", PolicyReject, rec.Policy)
+	}
+	if want := []ReportURI{{URI: "mailto:agg@example.com"}}; !reflect.DeepEqual(rec.AggregateReportURI, want) {
+		t.Errorf("expected %v, got %v", want, rec.AggregateReportURI)
+	}
+	if len(rec.ForensicReportURI) != 0 {
+		t.Errorf("expected no ruf URIs, got %v", rec.ForensicReportURI)
+	}
+	if errs := rec.InvalidReportURIs(); len(errs) != 2 {
+		t.Errorf("expected 2 invalid URIs, got %v", errs)
+	}
+}
@@ -0,0 +1,245 @@
+package dmarc
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masa23/mmauth/authres"
+	"github.com/masa23/mmauth/errcode"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Result はDMARCの評価結果
+// authres.Resultの別名
+type Result = authres.Result
+
+const (
+	ResultPass      = authres.ResultPass
+	ResultFail      = authres.ResultFail
+	ResultNone      = authres.ResultNone
+	ResultTempError = authres.ResultTempError
+	ResultPermError = authres.ResultPermError
+)
+
+// Identifiers はDMARCの評価に使う識別子
+// SPFDomainとDKIMDomainsには認証に成功したものだけを渡す
+type Identifiers struct {
+	FromDomain  string   // RFC5322.Fromのドメイン
+	SPFDomain   string   // SPFでpassしたドメイン(MAIL FROMまたはHELO)
+	DKIMDomains []string // 検証に成功したDKIM署名のd=
+}
+
+// Evaluation はDMARCの評価結果と評価に使ったポリシー
+type Evaluation struct {
+	Result      Result
+	Domain      string     // 評価したRFC5322.Fromのドメイン
+	Record      *Record    // 適用したDMARCレコード(見つからない場合はnil)
+	Policy      PolicyType // 適用するポリシー(サブドメインの場合はsp)
+	SPFAligned  bool
+	DKIMAligned bool
+	Err         error
+	// Disposition はpct=によるサンプリング後に適用するポリシー
+	// failでない場合はnone
+	Disposition PolicyType
+	// Sampled はfailのメッセージがpct=の対象に選ばれ、ポリシーをそのまま適用するか
+	Sampled bool
+	// Overrides はpct=やローカルポリシーによりポリシーと異なる扱いをした理由
+	Overrides []PolicyOverride
+	// NonExistentDomain はFromドメインがDNSに存在しないと判定し、np=を適用したか
+	NonExistentDomain bool
+}
+
+// Code は監視や集計に使う評価結果のコードを返す
+// Errにコードが付けられていない場合はErrとResultから決める
+func (ev *Evaluation) Code() errcode.Code {
+	if c := errcode.Of(ev.Err); c != "" {
+		return c
+	}
+	switch {
+	case ev.Result == ResultFail:
+		return errcode.DMARCFailNotAligned
+	case errors.Is(ev.Err, ErrNoRecordFound):
+		return errcode.DMARCNoneNoRecord
+	case errors.Is(ev.Err, ErrMultipleRecords):
+		return errcode.DMARCNoneMultipleRecords
+	case errors.Is(ev.Err, ErrDNSLookupFailed):
+		return errcode.DMARCTempErrorDNS
+	case ev.Result == ResultPermError && ev.Err != nil:
+		return errcode.DMARCPermErrorRecordInvalid
+	}
+	return errcode.Status("dmarc", string(ev.Result))
+}
+
+// EvaluateOptions はDMARCの評価オプション
+type EvaluateOptions struct {
+	// Rand はpct=のサンプリングに使う乱数
+	// テストなどで再現性が必要な場合は rand.New(rand.NewSource(seed)) を渡す
+	// 複数のgoroutineから同時に使う場合は呼び出し側で排他すること
+	// nilの場合は時刻で初期化したパッケージ内の乱数を使う
+	Rand *rand.Rand
+	// IgnorePercent がtrueの場合はpct=に関わらず常にポリシーを適用する
+	// 実際の配送に使わずに結果を分析する場合に使う
+	IgnorePercent bool
+	// DomainExists はnp=のあるレコードを組織ドメインやPSDで見つけた場合に、
+	// Fromドメインが存在するかを判定する関数 (RFC 9091)
+	// nilの場合はnp=を使わない。DefaultDomainExistsを指定するとDNSに問い合わせる
+	DomainExists DomainExistsFunc
+}
+
+var (
+	defaultRandMu sync.Mutex
+	defaultRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// 0以上n未満の乱数を返す
+func (o *EvaluateOptions) intn(n int) int {
+	if o != nil && o.Rand != nil {
+		return o.Rand.Intn(n)
+	}
+	defaultRandMu.Lock()
+	defer defaultRandMu.Unlock()
+	return defaultRand.Intn(n)
+}
+
+// EffectivePercent はポリシーを適用する割合を返す
+// pct=が指定されていない場合は100
+func (r *Record) EffectivePercent() int {
+	if !r.percentSet {
+		return 100
+	}
+	return r.Percent
+}
+
+// pct=の対象外になったメッセージに適用するポリシー (RFC 7489 6.6.4)
+// rejectはquarantineに、quarantineはnoneに下げる
+func downgradePolicy(p PolicyType) PolicyType {
+	switch p {
+	case PolicyReject:
+		return PolicyQuarantine
+	case PolicyQuarantine:
+		return PolicyNone
+	}
+	return p
+}
+
+// AppliedPolicy はレコードから実際に適用するポリシーを返す
+// 組織ドメインやPSDのレコードを使っている場合はspを優先する
+func (r *Record) AppliedPolicy() PolicyType {
+	if r.isSubdomainPolicy && r.SubdomainPolicy != "" {
+		return r.SubdomainPolicy
+	}
+	return r.Policy
+}
+
+// AppliedPolicyFor はFromドメインが存在するかを考慮して適用するポリシーを返す
+// 組織ドメインやPSDのレコードを使っていて、Fromドメインが存在しない場合はnpを優先する (RFC 9091 4.1)
+func (r *Record) AppliedPolicyFor(domainExists bool) PolicyType {
+	if !domainExists && r.isSubdomainPolicy && r.NonExistentSubdomainPolicy != "" {
+		return r.NonExistentSubdomainPolicy
+	}
+	return r.AppliedPolicy()
+}
+
+// IsAligned は認証済みドメインとFromドメインが指定されたモードで一致するかを返す
+// RFC 7489 3.1
+func IsAligned(authDomain, fromDomain string, mode AlignmentMode) bool {
+	authDomain = normalizeDomain(authDomain)
+	fromDomain = normalizeDomain(fromDomain)
+	if authDomain == "" || fromDomain == "" {
+		return false
+	}
+	if authDomain == fromDomain {
+		return true
+	}
+	if mode == AlignmentStrict {
+		return false
+	}
+	authOrg, err := publicsuffix.EffectiveTLDPlusOne(authDomain)
+	if err != nil {
+		return false
+	}
+	fromOrg, err := publicsuffix.EffectiveTLDPlusOne(fromDomain)
+	if err != nil {
+		return false
+	}
+	return authOrg == fromOrg
+}
+
+// Evaluate はlookupで得たDMARCレコードを使って識別子のアライメントを評価する
+// lookupがnilの場合はLookupRecordWithSubdomainFallbackを使う
+func Evaluate(id Identifiers, lookup func(domain string) (*Record, error)) *Evaluation {
+	return EvaluateWithOptions(id, lookup, nil)
+}
+
+// EvaluateWithOptions はオプションを指定してEvaluateを行う
+// optsがnilの場合はEvaluateと同じ
+func EvaluateWithOptions(id Identifiers, lookup func(domain string) (*Record, error), opts *EvaluateOptions) *Evaluation {
+	if lookup == nil {
+		lookup = LookupRecordWithSubdomainFallback
+	}
+	ev := &Evaluation{
+		Result:      ResultNone,
+		Domain:      id.FromDomain,
+		Disposition: PolicyNone,
+	}
+	if strings.TrimSpace(id.FromDomain) == "" {
+		ev.Result = ResultPermError
+		ev.Err = errcode.New(errcode.DMARCPermErrorMissingFrom, "missing RFC5322.From domain")
+		return ev
+	}
+
+	record, err := lookup(id.FromDomain)
+	if err != nil {
+		ev.Err = err
+		switch {
+		case errors.Is(err, ErrNoRecordFound), errors.Is(err, ErrMultipleRecords):
+			ev.Result = ResultNone
+		case errors.Is(err, ErrDNSLookupFailed):
+			ev.Result = ResultTempError
+		default:
+			ev.Result = ResultPermError
+		}
+		return ev
+	}
+	ev.Record = record
+	ev.Policy = record.AppliedPolicy()
+	// 存在の確認に失敗した場合は存在するものとしてspまたはpを適用する
+	if record.isSubdomainPolicy && record.NonExistentSubdomainPolicy != "" && opts != nil && opts.DomainExists != nil {
+		if exists, err := opts.DomainExists(id.FromDomain); err == nil && !exists {
+			ev.NonExistentDomain = true
+			ev.Policy = record.AppliedPolicyFor(false)
+		}
+	}
+
+	ev.SPFAligned = IsAligned(id.SPFDomain, id.FromDomain, record.AlignmentSPF)
+	for _, d := range id.DKIMDomains {
+		if IsAligned(d, id.FromDomain, record.AlignmentDKIM) {
+			ev.DKIMAligned = true
+			break
+		}
+	}
+	if ev.SPFAligned || ev.DKIMAligned {
+		ev.Result = ResultPass
+		return ev
+	}
+	ev.Result = ResultFail
+
+	pct := record.EffectivePercent()
+	switch {
+	case (opts != nil && opts.IgnorePercent) || pct >= 100:
+		ev.Sampled = true
+	case pct <= 0:
+		ev.Sampled = false
+	default:
+		ev.Sampled = opts.intn(100) < pct
+	}
+	ev.Disposition = ev.Policy
+	if !ev.Sampled {
+		ev.Disposition = downgradePolicy(ev.Policy)
+		ev.Overrides = append(ev.Overrides, PolicyOverride{Type: OverrideSampledOut})
+	}
+	return ev
+}
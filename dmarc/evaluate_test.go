@@ -0,0 +1,221 @@
+package dmarc
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/masa23/mmauth/errcode"
+)
+
+func TestIsAligned(t *testing.T) {
+	testCases := []struct {
+		auth, from string
+		mode       AlignmentMode
+		want       bool
+	}{
+		{"example.jp", "example.jp", AlignmentStrict, true},
+		{"mail.example.jp", "example.jp", AlignmentStrict, false},
+		{"mail.example.jp", "example.jp", AlignmentRelaxed, true},
+		{"mail.example.jp", "news.example.jp", AlignmentRelaxed, true},
+		{"Example.JP.", "example.jp", AlignmentStrict, true},
+		{"example.co.jp", "other.co.jp", AlignmentRelaxed, false},
+		{"", "example.jp", AlignmentRelaxed, false},
+		{"xn--wgv71a119e.jp", "日本語.jp", AlignmentStrict, true},
+		{"mail.日本語.jp", "xn--wgv71a119e.jp", AlignmentRelaxed, true},
+		{"MAIL.日本語.JP", "日本語.jp", AlignmentRelaxed, true},
+		{"日本語.jp", "example.jp", AlignmentRelaxed, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.auth+"_"+tc.from, func(t *testing.T) {
+			if got := IsAligned(tc.auth, tc.from, tc.mode); got != tc.want {
+				t.Errorf("IsAligned(%q, %q, %q) = %v, want %v", tc.auth, tc.from, tc.mode, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	lookup := func(records map[string]string, err error) func(string) (*Record, error) {
+		return func(domain string) (*Record, error) {
+			if err != nil {
+				return nil, err
+			}
+			raw, ok := records[domain]
+			if !ok {
+				return nil, ErrNoRecordFound
+			}
+			return ParseRecord(raw)
+		}
+	}
+	testCases := []struct {
+		name       string
+		id         Identifiers
+		records    map[string]string
+		lookupErr  error
+		want       Result
+		wantPolicy PolicyType
+		wantCode   errcode.Code
+	}{
+		{
+			name:       "dkim aligned",
+			id:         Identifiers{FromDomain: "example.jp", DKIMDomains: []string{"mail.example.jp"}},
+			records:    map[string]string{"example.jp": "v=DMARC1; p=reject;"},
+			want:       ResultPass,
+			wantPolicy: PolicyReject,
+			wantCode:   "DMARC_PASS",
+		},
+		{
+			name:       "spf strict not aligned",
+			id:         Identifiers{FromDomain: "example.jp", SPFDomain: "mail.example.jp"},
+			records:    map[string]string{"example.jp": "v=DMARC1; p=quarantine; aspf=s"},
+			want:       ResultFail,
+			wantPolicy: PolicyQuarantine,
+			wantCode:   errcode.DMARCFailNotAligned,
+		},
+		{
+			name:     "no record",
+			id:       Identifiers{FromDomain: "example.jp", SPFDomain: "example.jp"},
+			want:     ResultNone,
+			wantCode: errcode.DMARCNoneNoRecord,
+		},
+		{
+			name:      "dns failure",
+			id:        Identifiers{FromDomain: "example.jp"},
+			lookupErr: ErrDNSLookupFailed,
+			want:      ResultTempError,
+			wantCode:  errcode.DMARCTempErrorDNS,
+		},
+		{
+			name:     "missing from domain",
+			id:       Identifiers{},
+			want:     ResultPermError,
+			wantCode: errcode.DMARCPermErrorMissingFrom,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Evaluate(tc.id, lookup(tc.records, tc.lookupErr))
+			if got.Result != tc.want {
+				t.Errorf("expected %s, got %s (err=%v)", tc.want, got.Result, got.Err)
+			}
+			if got.Policy != tc.wantPolicy {
+				t.Errorf("expected policy %s, got %s", tc.wantPolicy, got.Policy)
+			}
+			if tc.lookupErr != nil && !errors.Is(got.Err, tc.lookupErr) {
+				t.Errorf("expected error %v, got %v", tc.lookupErr, got.Err)
+			}
+			if got.Code() != tc.wantCode {
+				t.Errorf("expected code %s, got %s", tc.wantCode, got.Code())
+			}
+		})
+	}
+}
+
+func TestEvaluatePercent(t *testing.T) {
+	failing := Identifiers{FromDomain: "example.jp", SPFDomain: "other.example.net"}
+	testCases := []struct {
+		name        string
+		record      string
+		opts        *EvaluateOptions
+		wantSampled bool
+		wantDispo   PolicyType
+	}{
+		{
+			name:        "pct omitted",
+			record:      "v=DMARC1; p=reject;",
+			wantSampled: true,
+			wantDispo:   PolicyReject,
+		},
+		{
+			name:      "pct=0 downgrades reject",
+			record:    "v=DMARC1; p=reject; pct=0;",
+			wantDispo: PolicyQuarantine,
+		},
+		{
+			name:      "pct=0 downgrades quarantine",
+			record:    "v=DMARC1; p=quarantine; pct=0;",
+			wantDispo: PolicyNone,
+		},
+		{
+			name:        "ignore percent",
+			record:      "v=DMARC1; p=reject; pct=0;",
+			opts:        &EvaluateOptions{IgnorePercent: true},
+			wantSampled: true,
+			wantDispo:   PolicyReject,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := EvaluateWithOptions(failing, func(string) (*Record, error) { return ParseRecord(tc.record) }, tc.opts)
+			if ev.Result != ResultFail {
+				t.Fatalf("want fail, but got %s", ev.Result)
+			}
+			if ev.Sampled != tc.wantSampled || ev.Disposition != tc.wantDispo {
+				t.Errorf("want sampled=%v disposition=%s, but got sampled=%v disposition=%s",
+					tc.wantSampled, tc.wantDispo, ev.Sampled, ev.Disposition)
+			}
+		})
+	}
+
+	// 同じシードでは同じ結果になり、おおよそpct%にポリシーが適用される
+	lookup := func(string) (*Record, error) { return ParseRecord("v=DMARC1; p=reject; pct=30;") }
+	run := func(seed int64) (int, []PolicyType) {
+		opts := &EvaluateOptions{Rand: rand.New(rand.NewSource(seed))}
+		sampled := 0
+		var dispos []PolicyType
+		for i := 0; i < 1000; i++ {
+			ev := EvaluateWithOptions(failing, lookup, opts)
+			if ev.Sampled {
+				sampled++
+			}
+			dispos = append(dispos, ev.Disposition)
+		}
+		return sampled, dispos
+	}
+	n1, d1 := run(42)
+	n2, d2 := run(42)
+	if n1 != n2 || !reflect.DeepEqual(d1, d2) {
+		t.Errorf("same seed produced different results: %d, %d", n1, n2)
+	}
+	if n1 < 250 || n1 > 350 {
+		t.Errorf("want about 300 sampled, but got %d", n1)
+	}
+	for _, d := range d1 {
+		if d != PolicyReject && d != PolicyQuarantine {
+			t.Fatalf("unexpected disposition: %s", d)
+		}
+	}
+
+	// passの場合はnone
+	ev := EvaluateWithOptions(Identifiers{FromDomain: "example.jp", SPFDomain: "example.jp"}, lookup, nil)
+	if ev.Disposition != PolicyNone {
+		t.Errorf("want none for pass, but got %s", ev.Disposition)
+	}
+}
+
+func TestEvaluationOverride(t *testing.T) {
+	failing := Identifiers{FromDomain: "example.jp", SPFDomain: "other.example.net"}
+
+	ev := Evaluate(failing, func(string) (*Record, error) { return ParseRecord("v=DMARC1; p=reject; pct=0;") })
+	want := []PolicyOverride{{Type: OverrideSampledOut}}
+	if !reflect.DeepEqual(ev.Overrides, want) {
+		t.Errorf("want %+v, but got %+v", want, ev.Overrides)
+	}
+
+	ev = Evaluate(failing, func(string) (*Record, error) { return ParseRecord("v=DMARC1; p=reject;") })
+	ev.Override(OverrideLocalPolicy, "arc=pass", PolicyNone)
+	want = []PolicyOverride{{Type: OverrideLocalPolicy, Comment: "arc=pass"}}
+	if ev.Disposition != PolicyNone || !reflect.DeepEqual(ev.Overrides, want) {
+		t.Errorf("want disposition none with %+v, but got %s with %+v", want, ev.Disposition, ev.Overrides)
+	}
+
+	// passの場合は上書きしない
+	ev = Evaluate(Identifiers{FromDomain: "example.jp", SPFDomain: "example.jp"},
+		func(string) (*Record, error) { return ParseRecord("v=DMARC1; p=reject;") })
+	ev.Override(OverrideLocalPolicy, "arc=pass", PolicyNone)
+	if len(ev.Overrides) != 0 {
+		t.Errorf("unexpected overrides: %+v", ev.Overrides)
+	}
+}
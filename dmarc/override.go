@@ -0,0 +1,31 @@
+package dmarc
+
+// OverrideType は公開されたポリシーと異なる扱いをした理由の種類
+// 集約レポートのPolicyOverrideTypeと同じ値を使う (RFC 7489 Appendix C)
+type OverrideType string
+
+const (
+	OverrideForwarded        OverrideType = "forwarded"
+	OverrideSampledOut       OverrideType = "sampled_out"
+	OverrideTrustedForwarder OverrideType = "trusted_forwarder"
+	OverrideMailingList      OverrideType = "mailing_list"
+	OverrideLocalPolicy      OverrideType = "local_policy"
+	OverrideOther            OverrideType = "other"
+)
+
+// PolicyOverride はポリシーを上書きした理由
+// 集約レポートのpolicy_evaluated/reason要素に相当する
+type PolicyOverride struct {
+	Type    OverrideType
+	Comment string
+}
+
+// Override はローカルポリシーなどによりDispositionをdispositionに上書きし、理由を記録する
+// failでない評価結果には適用するポリシーがないため何もしない
+func (ev *Evaluation) Override(t OverrideType, comment string, disposition PolicyType) {
+	if ev == nil || ev.Result != ResultFail {
+		return
+	}
+	ev.Disposition = disposition
+	ev.Overrides = append(ev.Overrides, PolicyOverride{Type: t, Comment: comment})
+}
@@ -0,0 +1,94 @@
+package dmarc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/masa23/mmauth/internal/idn"
+	"golang.org/x/net/publicsuffix"
+)
+
+// RFC 9091 (PSD DMARC)
+// Fromドメインにも組織ドメインにもDMARCレコードがない場合に、
+// パブリックサフィックスドメイン(PSD)の_dmarcを問い合わせる
+
+// PSDFilter はPSD DMARCに参加しているパブリックサフィックスドメインかを判定する関数
+// RFC 9091 3.2のPSD DMARCのレジストリなどに載っているドメインだけをtrueにする
+type PSDFilter func(psd string) bool
+
+// IsPSDPolicy はレコードがPSDで見つかったものかを返す
+func (r *Record) IsPSDPolicy() bool {
+	return r.isPSDPolicy
+}
+
+// LookupRecordWithPSDFallback はLookupRecordWithSubdomainFallbackでレコードが見つからない場合に、
+// RFC 9091に従ってFromドメインのパブリックサフィックスドメインの_dmarcを問い合わせる
+// filterがfalseを返すPSDは問い合わせない。nilの場合はすべてのPSDを問い合わせる
+func LookupRecordWithPSDFallback(domain string, filter PSDFilter) (*Record, error) {
+	if ascii, err := idn.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	return lookupRecordWithPSDFallback(domain, LookupRecord, filter)
+}
+
+// LookupRecordWithPSDFallback はキャッシュを使ってLookupRecordWithPSDFallbackと同じ探索を行う
+func (c *Cache) LookupRecordWithPSDFallback(domain string, filter PSDFilter) (*Record, error) {
+	return lookupRecordWithPSDFallback(normalizeDomain(domain), c.LookupRecord, filter)
+}
+
+// lookupRecordWithPSDFallback は lookup を使ってFromドメイン、組織ドメイン、PSDの順にDMARCレコードを探す
+// DNSのエラーなどレコードがないこと以外のエラーではPSDを問い合わせない
+func lookupRecordWithPSDFallback(domain string, lookup func(string) (*Record, error), filter PSDFilter) (*Record, error) {
+	d, err := lookupRecordWithSubdomainFallback(domain, lookup)
+	if !errors.Is(err, ErrNoRecordFound) {
+		return d, err
+	}
+	psd, _ := publicsuffix.PublicSuffix(domain)
+	if psd == "" || psd == domain || (filter != nil && !filter(psd)) {
+		return nil, err
+	}
+	d, err = lookup(psd)
+	if err != nil {
+		return nil, err
+	}
+	// PSDのレコードは組織ドメインをサブドメインとみなして適用する
+	d.isSubdomainPolicy = true
+	d.isPSDPolicy = true
+	return d, nil
+}
+
+// DomainExistsFunc はドメインがDNSに存在するかを返す関数
+type DomainExistsFunc func(domain string) (bool, error)
+
+// DefaultDomainExists はA・AAAA・MXのいずれかのレコードがあればドメインが存在するとみなす
+// RFC 9091 2.7: いずれの問い合わせもNXDOMAINかNODATAの場合に存在しないドメインとなる
+var DefaultDomainExists DomainExistsFunc = lookupDomainExists
+
+func lookupDomainExists(domain string) (bool, error) {
+	ascii, err := idn.ToASCII(domain)
+	if err != nil {
+		return false, err
+	}
+	addrs, err := net.LookupHost(ascii)
+	if len(addrs) > 0 {
+		return true, nil
+	}
+	if !isNXDomainOrNoData(err) {
+		return false, fmt.Errorf("%w: %v", ErrDNSLookupFailed, err)
+	}
+	mxs, err := net.LookupMX(ascii)
+	if len(mxs) > 0 {
+		return true, nil
+	}
+	if !isNXDomainOrNoData(err) {
+		return false, fmt.Errorf("%w: %v", ErrDNSLookupFailed, err)
+	}
+	return false, nil
+}
+
+// 問い合わせの結果がNXDOMAINかNODATA(エラーなしで0件)か
+func isNXDomainOrNoData(err error) bool {
+	var dnsErr *net.DNSError
+	return err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
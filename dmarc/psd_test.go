@@ -0,0 +1,151 @@
+package dmarc
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestLookupRecordWithPSDFallback(t *testing.T) {
+	originalResolver := DefaultResolver
+	t.Cleanup(func() { DefaultResolver = originalResolver })
+	var queried []string
+	DefaultResolver = func(name string) ([]string, error) {
+		queried = append(queried, name)
+		switch name {
+		case "_dmarc.bank":
+			return []string{"v=DMARC1; p=reject; np=reject;"}, nil
+		case "_dmarc.example.bank":
+			return []string{"v=DMARC1; p=none;"}, nil
+		case "_dmarc.strict.bank":
+			return []string{"v=DMARC1; p=reject;"}, nil
+		case "_dmarc.tempfail.bank":
+			return nil, &net.DNSError{IsTemporary: true}
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name        string
+		domain      string
+		filter      PSDFilter
+		wantPolicy  PolicyType
+		wantPSD     bool
+		wantErr     error
+		wantQueried []string
+	}{
+		{
+			name:        "organizational domain record",
+			domain:      "example.bank",
+			wantPolicy:  PolicyNone,
+			wantQueried: []string{"_dmarc.example.bank"},
+		},
+		{
+			// sp=もnp=もない組織ドメインのレコードがある場合はPSDを問い合わせずにp=を適用する
+			name:        "organizational domain record without sp",
+			domain:      "mail.strict.bank",
+			wantPolicy:  PolicyReject,
+			wantQueried: []string{"_dmarc.mail.strict.bank", "_dmarc.strict.bank"},
+		},
+		{
+			name:        "psd record",
+			domain:      "mail.nonexistent.bank",
+			wantPolicy:  PolicyReject,
+			wantPSD:     true,
+			wantQueried: []string{"_dmarc.mail.nonexistent.bank", "_dmarc.nonexistent.bank", "_dmarc.bank"},
+		},
+		{
+			name:        "psd not participating",
+			domain:      "nonexistent.bank",
+			filter:      func(psd string) bool { return psd == "gov.uk" },
+			wantErr:     ErrNoRecordFound,
+			wantQueried: []string{"_dmarc.nonexistent.bank"},
+		},
+		{
+			name:        "dns error",
+			domain:      "tempfail.bank",
+			wantErr:     ErrDNSLookupFailed,
+			wantQueried: []string{"_dmarc.tempfail.bank"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			queried = nil
+			got, err := LookupRecordWithPSDFallback(tc.domain, tc.filter)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err == nil {
+				if got.AppliedPolicy() != tc.wantPolicy {
+					t.Errorf("expected policy %s, got %s", tc.wantPolicy, got.AppliedPolicy())
+				}
+				if got.IsPSDPolicy() != tc.wantPSD {
+					t.Errorf("expected psd %v, got %v", tc.wantPSD, got.IsPSDPolicy())
+				}
+			}
+			if len(queried) != len(tc.wantQueried) {
+				t.Fatalf("expected queries %v, got %v", tc.wantQueried, queried)
+			}
+			for i := range queried {
+				if queried[i] != tc.wantQueried[i] {
+					t.Errorf("expected queries %v, got %v", tc.wantQueried, queried)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateNonExistentSubdomain(t *testing.T) {
+	records := map[string]string{
+		"example.jp": "v=DMARC1; p=reject; sp=quarantine; np=none;",
+		"example.co": "v=DMARC1; p=quarantine; sp=none;",
+	}
+	lookup := func(domain string) (*Record, error) {
+		return lookupRecordWithSubdomainFallback(domain, func(d string) (*Record, error) {
+			raw, ok := records[d]
+			if !ok {
+				return nil, ErrNoRecordFound
+			}
+			return ParseRecord(raw)
+		})
+	}
+	exists := func(domain string) (bool, error) {
+		switch domain {
+		case "www.example.jp":
+			return true, nil
+		case "tempfail.example.jp":
+			return false, ErrDNSLookupFailed
+		}
+		return false, nil
+	}
+
+	testCases := []struct {
+		name        string
+		fromDomain  string
+		exists      DomainExistsFunc
+		wantPolicy  PolicyType
+		wantMissing bool
+	}{
+		{name: "organizational domain", fromDomain: "example.jp", exists: exists, wantPolicy: PolicyReject},
+		{name: "existing subdomain", fromDomain: "www.example.jp", exists: exists, wantPolicy: PolicyQuarantine},
+		{name: "non-existent subdomain", fromDomain: "nx.example.jp", exists: exists, wantPolicy: PolicyNone, wantMissing: true},
+		{name: "existence check failed", fromDomain: "tempfail.example.jp", exists: exists, wantPolicy: PolicyQuarantine},
+		{name: "no existence check", fromDomain: "nx.example.jp", wantPolicy: PolicyQuarantine},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := EvaluateWithOptions(Identifiers{FromDomain: tc.fromDomain}, lookup, &EvaluateOptions{DomainExists: tc.exists})
+			if ev.Policy != tc.wantPolicy {
+				t.Errorf("expected policy %s, got %s (%v)", tc.wantPolicy, ev.Policy, ev.Err)
+			}
+			if ev.NonExistentDomain != tc.wantMissing {
+				t.Errorf("expected non-existent %v, got %v", tc.wantMissing, ev.NonExistentDomain)
+			}
+		})
+	}
+
+	if _, err := ParseRecord("v=DMARC1; p=none; np=block;"); err == nil {
+		t.Errorf("expected error for invalid np value")
+	}
+}
@@ -0,0 +1,121 @@
+package dmarc
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// Scheme returns the lower-cased scheme of the report URI (e.g. "mailto").
+func (r ReportURI) Scheme() string {
+	scheme, _, ok := strings.Cut(r.URI, ":")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(scheme)
+}
+
+// IsMailto reports whether the report URI is a mailto: URI.
+func (r ReportURI) IsMailto() bool {
+	return r.Scheme() == "mailto"
+}
+
+// Addresses returns the recipient addresses of a mailto: URI (RFC 6068).
+// It returns nil for other schemes. Header fields such as ?subject= are dropped.
+func (r ReportURI) Addresses() []string {
+	if !r.IsMailto() {
+		return nil
+	}
+	addrs, err := mailtoAddresses(r.URI)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// Allows reports whether a report of size bytes may be sent to the URI
+// under its size limit (RFC 7489 Section 6.2). A zero MaxSize means no limit.
+func (r ReportURI) Allows(size int64) bool {
+	return r.MaxSize == 0 || size <= r.MaxSize
+}
+
+// AggregateReportAddresses returns the mailto: recipients of the rua tag in record order,
+// without duplicates.
+func (d *Record) AggregateReportAddresses() []string {
+	return reportAddresses(d.AggregateReportURI)
+}
+
+// ForensicReportAddresses returns the mailto: recipients of the ruf tag in record order,
+// without duplicates.
+func (d *Record) ForensicReportAddresses() []string {
+	return reportAddresses(d.ForensicReportURI)
+}
+
+// InvalidReportURIs returns the errors for the rua and ruf URIs that were
+// skipped because they could not be parsed, so that they can be logged.
+// The rest of the record is still used (RFC 7489 Section 6.3).
+func (d *Record) InvalidReportURIs() []error {
+	return d.invalidReportURIs
+}
+
+func reportAddresses(uris []ReportURI) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, u := range uris {
+		for _, a := range u.Addresses() {
+			key := strings.ToLower(a)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+// validateReportURI checks that the URI has a scheme and, for mailto:,
+// that every recipient is a valid addr-spec.
+func validateReportURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid report URI: %s (%v)", uri, err)
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("invalid report URI: %s (missing scheme)", uri)
+	}
+	if strings.EqualFold(u.Scheme, "mailto") {
+		if _, err := mailtoAddresses(uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mailtoAddresses returns the addresses in the path of a mailto: URI.
+// Commas separate URIs in rua/ruf, so multiple recipients must be encoded as %2C.
+func mailtoAddresses(uri string) ([]string, error) {
+	_, rest, _ := strings.Cut(uri, ":")
+	path, _, _ := strings.Cut(rest, "?")
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mailto URI: %s (%v)", uri, err)
+	}
+	var addrs []string
+	for _, a := range strings.Split(decoded, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(a)
+		if err != nil || addr.Name != "" || addr.Address != a || !strings.Contains(addr.Address, "@") {
+			return nil, fmt.Errorf("invalid mailto URI: %s (invalid address %q)", uri, a)
+		}
+		addrs = append(addrs, addr.Address)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("invalid mailto URI: %s (no address)", uri)
+	}
+	return addrs, nil
+}
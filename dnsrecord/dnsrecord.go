@@ -0,0 +1,115 @@
+// Package dnsrecord はSPF・DKIM・DMARCのTXTレコードを、DNSの文字列長の上限(255バイト)に
+// 合わせて分割し、ゾーンファイル(BIND形式)の行として出力する
+//
+// TXTレコードの1つの文字列(character-string)は255バイトまでのため、それより長いレコードは
+// 複数の文字列に分けて公開する。検証側は文字列を連結して1つのレコードとして扱う(RFC 7208 3.3, RFC 6376 3.6.2.2)。
+package dnsrecord
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxStringLength はTXTレコードの1つの文字列の最大長(バイト)
+const MaxStringLength = 255
+
+// Split はrecordをsizeバイト以下の文字列に分割する
+// sizeが0以下またはMaxStringLengthを超える場合はMaxStringLengthとする
+// 空のレコードは空文字列1つを返す
+func Split(record string, size int) []string {
+	if size <= 0 || size > MaxStringLength {
+		size = MaxStringLength
+	}
+	if record == "" {
+		return []string{""}
+	}
+	var ret []string
+	for len(record) > size {
+		ret = append(ret, record[:size])
+		record = record[size:]
+	}
+	return append(ret, record)
+}
+
+// Quote は文字列をゾーンファイルのquoted-stringにする
+// ダブルクォートとバックスラッシュはエスケープし、印字できない文字は\DDDで表す
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Options はゾーンファイルへの出力オプション
+type Options struct {
+	// TTL はレコードのTTL(秒)。0の場合は省略し、ゾーンの$TTLを使う
+	TTL uint32
+	// StringLength は分割する文字列の長さ。0の場合はMaxStringLength
+	StringLength int
+	// Multiline がtrueの場合は括弧で囲み、文字列ごとに改行する
+	Multiline bool
+	// Indent はMultilineの場合の継続行の字下げ。空の場合はタブ
+	Indent string
+}
+
+// TXT はrecordを分割してquoted-stringを空白で連結した値を返す
+// nsupdateやDNSプロバイダのAPIなど、ゾーンファイル以外でも使える形式
+func TXT(record string) string {
+	parts := Split(record, 0)
+	for i, p := range parts {
+		parts[i] = Quote(p)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ZoneLine はnameのTXTレコードをBIND形式のゾーンファイルの行として返す
+// nameはそのまま出力するため、絶対名の場合は末尾にドットを付けて渡す
+// optsがnilの場合はTTLを省略し、1行で出力する
+func ZoneLine(name, record string, opts *Options) string {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	if opts.TTL > 0 {
+		fmt.Fprintf(&b, "\t%d", opts.TTL)
+	}
+	b.WriteString("\tIN\tTXT\t")
+
+	parts := Split(record, opts.StringLength)
+	if !opts.Multiline || len(parts) == 1 {
+		for i, p := range parts {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(Quote(p))
+		}
+		return b.String()
+	}
+
+	indent := opts.Indent
+	if indent == "" {
+		indent = "\t"
+	}
+	b.WriteString("(\n")
+	for _, p := range parts {
+		b.WriteString(indent)
+		b.WriteString(Quote(p))
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent)
+	b.WriteByte(')')
+	return b.String()
+}
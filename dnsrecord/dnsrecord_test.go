@@ -0,0 +1,102 @@
+package dnsrecord
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	testCases := []struct {
+		name   string
+		record string
+		size   int
+		want   []string
+	}{
+		{name: "empty", record: "", want: []string{""}},
+		{name: "short", record: "v=spf1 -all", want: []string{"v=spf1 -all"}},
+		{name: "exactly max", record: long[:255], want: []string{long[:255]}},
+		{name: "long", record: long, want: []string{long[:255], long[255:]}},
+		{name: "custom size", record: "abcdefg", size: 3, want: []string{"abc", "def", "g"}},
+		{name: "size over max", record: long, size: 1000, want: []string{long[:255], long[255:]}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Split(tc.record, tc.size); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  string
+	}{
+		{input: "v=DMARC1; p=none", want: `"v=DMARC1; p=none"`},
+		{input: `a"b\c`, want: `"a\"b\\c"`},
+		{input: "a\tb", want: `"a\009b"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			if got := Quote(tc.input); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestZoneLine(t *testing.T) {
+	testCases := []struct {
+		name   string
+		record string
+		opts   *Options
+		want   string
+	}{
+		{
+			name:   "single string",
+			record: "v=DMARC1; p=reject",
+			want:   "_dmarc\tIN\tTXT\t\"v=DMARC1; p=reject\"",
+		},
+		{
+			name:   "ttl and split",
+			record: "v=DKIM1; k=rsa; p=ABCDEFGHIJ",
+			opts:   &Options{TTL: 3600, StringLength: 16},
+			want:   "_dmarc\t3600\tIN\tTXT\t\"v=DKIM1; k=rsa; \" \"p=ABCDEFGHIJ\"",
+		},
+		{
+			name:   "multiline",
+			record: "v=DKIM1; k=rsa; p=ABCDEFGHIJ",
+			opts:   &Options{StringLength: 16, Multiline: true, Indent: "  "},
+			want:   "_dmarc\tIN\tTXT\t(\n  \"v=DKIM1; k=rsa; \"\n  \"p=ABCDEFGHIJ\"\n  )",
+		},
+		{
+			name:   "multiline with single string",
+			record: "v=spf1 -all",
+			opts:   &Options{Multiline: true},
+			want:   "_dmarc\tIN\tTXT\t\"v=spf1 -all\"",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ZoneLine("_dmarc", tc.record, tc.opts); got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTXT(t *testing.T) {
+	record := "v=spf1 " + strings.Repeat("ip4:192.0.2.1 ", 20) + "-all"
+	got := TXT(record)
+	parts := strings.Split(got, `" "`)
+	if len(parts) != 2 {
+		t.Fatalf("want 2 strings, but got %d: %s", len(parts), got)
+	}
+	joined := strings.ReplaceAll(strings.Trim(got, `"`), `" "`, "")
+	if joined != record {
+		t.Errorf("concatenated strings do not match the record: %s", joined)
+	}
+}
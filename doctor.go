@@ -0,0 +1,424 @@
+package mmauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/internal/txtrecord"
+	"github.com/masa23/mmauth/resolver"
+	"github.com/masa23/mmauth/spf"
+)
+
+// DoctorCheck はDiagnoseで検査する項目
+type DoctorCheck string
+
+const (
+	DoctorCheckSPF    DoctorCheck = "spf"
+	DoctorCheckDMARC  DoctorCheck = "dmarc"
+	DoctorCheckDKIM   DoctorCheck = "dkim"
+	DoctorCheckMTASTS DoctorCheck = "mta-sts"
+	DoctorCheckTLSRPT DoctorCheck = "tls-rpt"
+)
+
+// DoctorSeverity は検出した問題の重要度
+type DoctorSeverity string
+
+const (
+	// DoctorSeverityInfo は問題ではないが改善できる点
+	DoctorSeverityInfo DoctorSeverity = "info"
+	// DoctorSeverityWarning は受信側の判定や運用に影響しうる設定
+	DoctorSeverityWarning DoctorSeverity = "warning"
+	// DoctorSeverityError は認証が失敗する、または保護が働かない設定
+	DoctorSeverityError DoctorSeverity = "error"
+)
+
+const (
+	// DoctorErrorPenalty はDoctorSeverityErrorの問題1つで減らすスコア
+	DoctorErrorPenalty = 20
+	// DoctorWarningPenalty はDoctorSeverityWarningの問題1つで減らすスコア
+	DoctorWarningPenalty = 5
+	// doctorSPFLookupWarning はSPFのDNSルックアップを伴う項の数がこれを超えると警告する
+	// RFC 7208 4.6.4 の上限(10)に近づくと、includeの先の変更だけでpermerrorになる
+	doctorSPFLookupWarning = 8
+	// doctorRecommendedRSABits は推奨するRSA鍵のビット数 (RFC 8301 3.2)
+	doctorRecommendedRSABits = 2048
+)
+
+// DefaultDoctorSelectors はDiagnoseで鍵を探すDKIMのセレクタ
+// 主要なメールサービスやMTAの既定値で、実際に使っているセレクタはDoctorOptions.Selectorsで指定する
+var DefaultDoctorSelectors = []string{
+	"default", "dkim", "mail", "selector1", "selector2", "google", "k1", "k2", "s1", "s2",
+}
+
+// doctorProbeIP はSPFの評価に使うアドレス(RFC 5737 TEST-NET-1)
+// どのレコードでも認可されていないはずのアドレスで評価し、末尾のallまでの項を数える
+var doctorProbeIP = net.IPv4(192, 0, 2, 0)
+
+// DoctorFinding はDiagnoseで検出した問題1つ
+type DoctorFinding struct {
+	Check       DoctorCheck
+	Severity    DoctorSeverity
+	Message     string // 問題の説明
+	Remediation string // 対処方法。ない場合は空
+}
+
+// String は問題を人が読める形式で返す
+func (f DoctorFinding) String() string {
+	s := fmt.Sprintf("[%s] %s: %s", f.Severity, f.Check, f.Message)
+	if f.Remediation != "" {
+		s += " (" + f.Remediation + ")"
+	}
+	return s
+}
+
+// DoctorReport はDiagnoseの結果
+type DoctorReport struct {
+	Domain        string
+	SPFRecord     string   // 評価したSPFレコード。ない場合は空
+	DMARCRecord   string   // _dmarcのレコード。ない場合は空
+	DKIMSelectors []string // 鍵が公開されていたセレクタ
+	MTASTSRecord  string   // _mta-stsのレコード。ない場合は空
+	TLSRPTRecord  string   // _smtp._tlsのレコード。ない場合は空
+	Findings      []DoctorFinding
+	// Score は100から問題の重要度に応じて減らした点数(0-100)
+	// DoctorSeverityErrorはDoctorErrorPenalty、DoctorSeverityWarningはDoctorWarningPenaltyを減らす
+	Score int
+}
+
+// HasErrors はDoctorSeverityErrorの問題があるかを返す
+func (r *DoctorReport) HasErrors() bool {
+	for _, f := range r.Findings {
+		if f.Severity == DoctorSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// String はレポートを人が読める形式で返す
+func (r *DoctorReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: score %d/100\n", r.Domain, r.Score)
+	for _, f := range r.Findings {
+		b.WriteString(f.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (r *DoctorReport) add(check DoctorCheck, severity DoctorSeverity, message, remediation string) {
+	r.Findings = append(r.Findings, DoctorFinding{
+		Check:       check,
+		Severity:    severity,
+		Message:     message,
+		Remediation: remediation,
+	})
+}
+
+// DoctorOptions はDiagnoseのオプション
+type DoctorOptions struct {
+	// Selectors は鍵を探すDKIMのセレクタ。nilの場合はDefaultDoctorSelectors
+	Selectors []string
+	// Resolver はTXTレコードの問い合わせに使うリゾルバー
+	// nilの場合はdomainkey.NewDefaultTXTResolver(resolver.Installで設定したものを含む)
+	// SPFの評価はspfパッケージの既定の問い合わせを使う
+	Resolver domainkey.TXTResolver
+}
+
+// Diagnose はドメインのSPF・DMARC・DKIM・MTA-STS・TLS-RPTのレコードを取得して検査し、
+// 問題と対処方法、スコアをまとめたレポートを返す
+// 運用者が自身のドメインの設定を確認するためのもので、受信したメッセージの認証には使わない
+// MTA-STSはTXTレコードのみを検査し、HTTPSで公開するポリシーファイルは取得しない
+func Diagnose(ctx context.Context, domain string) *DoctorReport {
+	return DiagnoseWithOptions(ctx, domain, nil)
+}
+
+// DiagnoseWithOptions はオプションを指定してDiagnoseを行う
+// optsがnilの場合はDiagnoseと同じ
+func DiagnoseWithOptions(ctx context.Context, domain string, opts *DoctorOptions) *DoctorReport {
+	if opts == nil {
+		opts = &DoctorOptions{}
+	}
+	txt := opts.Resolver
+	if txt == nil {
+		txt = domainkey.NewDefaultTXTResolver()
+	}
+	selectors := opts.Selectors
+	if selectors == nil {
+		selectors = DefaultDoctorSelectors
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	r := &DoctorReport{Domain: domain}
+	diagnoseSPF(ctx, r)
+	diagnoseDMARC(ctx, r, txt)
+	diagnoseDKIM(ctx, r, txt, selectors)
+	diagnoseMTASTS(ctx, r, txt)
+	diagnoseTLSRPT(ctx, r, txt)
+
+	r.Score = 100
+	for _, f := range r.Findings {
+		switch f.Severity {
+		case DoctorSeverityError:
+			r.Score -= DoctorErrorPenalty
+		case DoctorSeverityWarning:
+			r.Score -= DoctorWarningPenalty
+		}
+	}
+	if r.Score < 0 {
+		r.Score = 0
+	}
+	return r
+}
+
+func diagnoseSPF(ctx context.Context, r *DoctorReport) {
+	res := spf.CheckSPFContext(ctx, doctorProbeIP, r.Domain, "postmaster@"+r.Domain, r.Domain, &spf.Options{Trace: true})
+	r.SPFRecord = res.Record
+	switch res.Status {
+	case spf.None:
+		r.add(DoctorCheckSPF, DoctorSeverityError, "no SPF record is published",
+			"publish a TXT record such as \"v=spf1 mx -all\" listing every host that sends mail for the domain")
+		return
+	case spf.PermError:
+		r.add(DoctorCheckSPF, DoctorSeverityError, "record evaluates to permerror: "+res.Reason,
+			"fix the record; receivers treat permerror as a failed SPF check")
+		return
+	case spf.TempError:
+		r.add(DoctorCheckSPF, DoctorSeverityWarning, "record could not be evaluated: "+res.Reason,
+			"check that the domain's name servers and every included domain answer reliably")
+		return
+	}
+
+	for _, issue := range spf.Lint(res.Record) {
+		r.add(DoctorCheckSPF, DoctorSeverityWarning, issue.String(), "")
+	}
+	switch res.PolicyStrength() {
+	case spf.PolicyStrengthOpen:
+		r.add(DoctorCheckSPF, DoctorSeverityError, "+all authorizes every host on the internet",
+			"replace +all with -all or ~all")
+	case spf.PolicyStrengthNeutral, spf.PolicyStrengthNone:
+		r.add(DoctorCheckSPF, DoctorSeverityWarning, "record does not reject unauthorized hosts (no -all or ~all)",
+			"end the record with -all or ~all")
+	case spf.PolicyStrengthSoft:
+		r.add(DoctorCheckSPF, DoctorSeverityInfo, "record ends with ~all",
+			"use -all once every sending host is listed")
+	}
+	if res.Stats != nil && res.Stats.Terms > doctorSPFLookupWarning {
+		r.add(DoctorCheckSPF, DoctorSeverityWarning,
+			fmt.Sprintf("record uses %d of the 10 DNS lookups allowed by RFC 7208", res.Stats.Terms),
+			"replace includes with ip4/ip6 ranges or drop unused senders")
+	}
+}
+
+func diagnoseDMARC(ctx context.Context, r *DoctorReport, txt domainkey.TXTResolver) {
+	rec, err := dmarc.LookupRecordWith(r.Domain, func(name string) ([]string, error) {
+		return txt.LookupTXT(ctx, name)
+	})
+	switch {
+	case errors.Is(err, dmarc.ErrNoRecordFound):
+		r.add(DoctorCheckDMARC, DoctorSeverityError, "no DMARC record is published at _dmarc."+r.Domain,
+			"publish \"v=DMARC1; p=none; rua=mailto:<address>\" and move to p=quarantine or p=reject after reviewing the reports")
+		return
+	case err != nil:
+		r.add(DoctorCheckDMARC, DoctorSeverityError, "record could not be used: "+err.Error(),
+			"publish exactly one valid DMARC record")
+		return
+	}
+	r.DMARCRecord = rec.Raw()
+
+	if rec.Policy == dmarc.PolicyNone {
+		r.add(DoctorCheckDMARC, DoctorSeverityWarning, "p=none only monitors and does not protect the domain",
+			"move to p=quarantine or p=reject once legitimate mail passes")
+	}
+	if pct := rec.EffectivePercent(); pct < 100 {
+		r.add(DoctorCheckDMARC, DoctorSeverityWarning, fmt.Sprintf("policy is applied to only %d%% of failing mail", pct),
+			"raise pct to 100 or remove it")
+	}
+	if rec.Policy != dmarc.PolicyNone && rec.SubdomainPolicy == dmarc.PolicyNone {
+		r.add(DoctorCheckDMARC, DoctorSeverityWarning, "sp=none leaves subdomains unprotected",
+			"remove sp= or set it to quarantine or reject")
+	}
+	for _, err := range rec.InvalidReportURIs() {
+		r.add(DoctorCheckDMARC, DoctorSeverityWarning, err.Error()+" (ignored by receivers)",
+			"write each report address as mailto:<address>")
+	}
+	if len(rec.AggregateReportURI) == 0 {
+		r.add(DoctorCheckDMARC, DoctorSeverityWarning, "no aggregate report address (rua=)",
+			"add rua=mailto:<address> to see who sends mail as the domain")
+	}
+}
+
+func diagnoseDKIM(ctx context.Context, r *DoctorReport, txt domainkey.TXTResolver, selectors []string) {
+	for _, sel := range selectors {
+		name := sel + "._domainkey." + r.Domain
+		records, err := txt.LookupTXT(ctx, name)
+		if resolver.ClassifyError(err) == resolver.ErrorKindNotFound {
+			continue
+		}
+		if err != nil {
+			r.add(DoctorCheckDKIM, DoctorSeverityWarning, fmt.Sprintf("lookup of %s failed: %v", name, err), "")
+			continue
+		}
+		records, err = txtrecord.Sanitize(records, domainkey.StripTrailingNUL)
+		if err != nil {
+			r.add(DoctorCheckDKIM, DoctorSeverityError, fmt.Sprintf("%s: %v", name, err),
+				"republish the key record without control characters")
+			continue
+		}
+		if len(records) == 0 {
+			continue
+		}
+		r.DKIMSelectors = append(r.DKIMSelectors, sel)
+		for _, raw := range records {
+			diagnoseDKIMKey(r, name, raw)
+		}
+	}
+	if len(r.DKIMSelectors) == 0 {
+		r.add(DoctorCheckDKIM, DoctorSeverityWarning, "no DKIM key found at the probed selectors",
+			"sign outgoing mail with DKIM, or pass the selectors in use to DoctorOptions.Selectors")
+	}
+}
+
+func diagnoseDKIMKey(r *DoctorReport, name, raw string) {
+	dk, err := domainkey.ParseDomainKeyRecord(raw)
+	if err != nil {
+		r.add(DoctorCheckDKIM, DoctorSeverityError, fmt.Sprintf("%s: invalid key record: %v", name, err),
+			"republish the record in the form \"v=DKIM1; k=rsa; p=<base64 key>\"")
+		return
+	}
+	if dk.PublicKey == "" {
+		r.add(DoctorCheckDKIM, DoctorSeverityInfo, name+": key is revoked (empty p=)",
+			"remove the record once no signer uses the selector")
+		return
+	}
+	if dk.IsTestFlag() {
+		r.add(DoctorCheckDKIM, DoctorSeverityWarning, name+": key is in testing mode (t=y)",
+			"remove t=y so that receivers enforce failed signatures")
+	}
+	if dk.IsPKIXEd25519() {
+		r.add(DoctorCheckDKIM, DoctorSeverityWarning, name+": ed25519 key is published in PKIX form",
+			"publish the raw 32-octet key as RFC 8463 requires")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(dk.PublicKey), ""))
+	if err != nil {
+		r.add(DoctorCheckDKIM, DoctorSeverityError, name+": public key is not valid base64", "")
+		return
+	}
+	pub, err := domainkey.ParseDKIMPublicKey(decoded, dk.KeyType)
+	if err != nil {
+		r.add(DoctorCheckDKIM, DoctorSeverityError, fmt.Sprintf("%s: %v", name, err), "")
+		return
+	}
+	if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+		switch bits := rsaPub.N.BitLen(); {
+		case bits < dkim.MinRSAKeyBits:
+			r.add(DoctorCheckDKIM, DoctorSeverityError,
+				fmt.Sprintf("%s: %d-bit RSA key is rejected by receivers (RFC 8301)", name, bits),
+				fmt.Sprintf("rotate to a key of at least %d bits", doctorRecommendedRSABits))
+		case bits < doctorRecommendedRSABits:
+			r.add(DoctorCheckDKIM, DoctorSeverityWarning, fmt.Sprintf("%s: %d-bit RSA key is weak", name, bits),
+				fmt.Sprintf("rotate to a key of at least %d bits", doctorRecommendedRSABits))
+		}
+	}
+}
+
+func diagnoseMTASTS(ctx context.Context, r *DoctorReport, txt domainkey.TXTResolver) {
+	rec, ok := lookupVersionedTXT(ctx, r, txt, DoctorCheckMTASTS, "_mta-sts."+r.Domain, "STSv1")
+	if !ok {
+		return
+	}
+	if rec == "" {
+		r.add(DoctorCheckMTASTS, DoctorSeverityInfo, "MTA-STS is not published",
+			"publish _mta-sts TXT \"v=STSv1; id=<policy id>\" and the policy at https://mta-sts."+r.Domain+"/.well-known/mta-sts.txt")
+		return
+	}
+	r.MTASTSRecord = rec
+	tags := parseDoctorTags(rec)
+	id := tags["id"]
+	if id == "" || len(id) > 32 || strings.IndexFunc(id, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	}) >= 0 {
+		r.add(DoctorCheckMTASTS, DoctorSeverityError, "id= must be 1 to 32 letters or digits (RFC 8461 3.1)",
+			"set id= to a new value each time the policy changes")
+	}
+}
+
+func diagnoseTLSRPT(ctx context.Context, r *DoctorReport, txt domainkey.TXTResolver) {
+	rec, ok := lookupVersionedTXT(ctx, r, txt, DoctorCheckTLSRPT, "_smtp._tls."+r.Domain, "TLSRPTv1")
+	if !ok {
+		return
+	}
+	if rec == "" {
+		r.add(DoctorCheckTLSRPT, DoctorSeverityInfo, "TLS reporting is not published",
+			"publish _smtp._tls TXT \"v=TLSRPTv1; rua=mailto:<address>\"")
+		return
+	}
+	r.TLSRPTRecord = rec
+	rua := parseDoctorTags(rec)["rua"]
+	if rua == "" {
+		r.add(DoctorCheckTLSRPT, DoctorSeverityError, "rua= is missing (RFC 8460 3)",
+			"add rua=mailto:<address> or rua=https://<endpoint>")
+		return
+	}
+	for _, uri := range strings.Split(rua, ",") {
+		uri = strings.TrimSpace(uri)
+		if !strings.HasPrefix(uri, "mailto:") && !strings.HasPrefix(uri, "https:") {
+			r.add(DoctorCheckTLSRPT, DoctorSeverityError, fmt.Sprintf("unsupported rua URI %q", uri),
+				"use mailto: or https: URIs")
+		}
+	}
+}
+
+// lookupVersionedTXT はnameのTXTレコードからv=versionで始まるものを取り出す
+// レコードがない場合は空文字列、問い合わせの失敗や複数のレコードは問題として記録してokをfalseにする
+func lookupVersionedTXT(ctx context.Context, r *DoctorReport, txt domainkey.TXTResolver, check DoctorCheck, name, version string) (rec string, ok bool) {
+	records, err := txt.LookupTXT(ctx, name)
+	if resolver.ClassifyError(err) == resolver.ErrorKindNotFound {
+		return "", true
+	}
+	if err != nil {
+		r.add(check, DoctorSeverityWarning, fmt.Sprintf("lookup of %s failed: %v", name, err), "")
+		return "", false
+	}
+	var found []string
+	for _, v := range records {
+		v = txtrecord.StripNUL(v)
+		if parseDoctorTags(v)["v"] == version && strings.HasPrefix(strings.TrimSpace(v), "v=") {
+			found = append(found, v)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", true
+	case 1:
+		return found[0], true
+	}
+	r.add(check, DoctorSeverityError, fmt.Sprintf("%d records are published at %s", len(found), name),
+		"publish exactly one v="+version+" record")
+	return "", false
+}
+
+// parseDoctorTags は "k=v; k=v" 形式のレコードをタグ名から値への対応にする
+// 同じタグが複数ある場合は最初の値を使う
+func parseDoctorTags(rec string) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(rec, ";") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		if _, dup := tags[k]; !dup {
+			tags[k] = strings.TrimSpace(v)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,112 @@
+package mmauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"reflect"
+	"testing"
+
+	"github.com/masa23/mmauth/resolver"
+)
+
+func TestDiagnose(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		setup         func(zone *resolver.Zone)
+		wantSelectors []string
+		wantFindings  []DoctorFinding
+		wantScore     int
+	}{
+		{
+			name: "healthy",
+			setup: func(zone *resolver.Zone) {
+				zone.AddTXT("example.com", "v=spf1 ip4:198.51.100.0/24 -all")
+				zone.AddTXT("_dmarc.example.com", "v=DMARC1; p=reject; rua=mailto:dmarc@example.com")
+				publishKey(t, zone, "sel._domainkey.example.com", pub)
+				zone.AddTXT("_mta-sts.example.com", "v=STSv1; id=20240101")
+				zone.AddTXT("_smtp._tls.example.com", "v=TLSRPTv1; rua=mailto:tlsrpt@example.com")
+			},
+			wantSelectors: []string{"sel"},
+			wantScore:     100,
+		},
+		{
+			name:  "nothing published",
+			setup: func(zone *resolver.Zone) {},
+			wantFindings: []DoctorFinding{
+				{Check: DoctorCheckSPF, Severity: DoctorSeverityError},
+				{Check: DoctorCheckDMARC, Severity: DoctorSeverityError},
+				{Check: DoctorCheckDKIM, Severity: DoctorSeverityWarning},
+				{Check: DoctorCheckMTASTS, Severity: DoctorSeverityInfo},
+				{Check: DoctorCheckTLSRPT, Severity: DoctorSeverityInfo},
+			},
+			wantScore: 55,
+		},
+		{
+			name: "weak configuration",
+			setup: func(zone *resolver.Zone) {
+				zone.AddTXT("example.com", "v=spf1 ip4:198.51.100.0/24 ?all")
+				zone.AddTXT("_dmarc.example.com", "v=DMARC1; p=none; rua=dmarc@example.com")
+				zone.AddTXT("sel._domainkey.example.com", "v=DKIM1; p=")
+				zone.AddTXT("_mta-sts.example.com", "v=STSv1; id=not-valid")
+				zone.AddTXT("_smtp._tls.example.com", "v=TLSRPTv1")
+			},
+			wantSelectors: []string{"sel"},
+			wantFindings: []DoctorFinding{
+				{Check: DoctorCheckSPF, Severity: DoctorSeverityWarning},
+				{Check: DoctorCheckDMARC, Severity: DoctorSeverityWarning},
+				{Check: DoctorCheckDMARC, Severity: DoctorSeverityWarning},
+				{Check: DoctorCheckDMARC, Severity: DoctorSeverityWarning},
+				{Check: DoctorCheckDKIM, Severity: DoctorSeverityInfo},
+				{Check: DoctorCheckMTASTS, Severity: DoctorSeverityError},
+				{Check: DoctorCheckTLSRPT, Severity: DoctorSeverityError},
+			},
+			wantScore: 40,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			zone := resolver.NewZone()
+			tc.setup(zone)
+			useZone(t, zone)
+
+			report := DiagnoseWithOptions(context.Background(), "example.com.", &DoctorOptions{
+				Selectors: []string{"sel", "missing"},
+			})
+			if report.Domain != "example.com" {
+				t.Errorf("want example.com, but got %s", report.Domain)
+			}
+			if !reflect.DeepEqual(report.DKIMSelectors, tc.wantSelectors) {
+				t.Errorf("want selectors %v, but got %v", tc.wantSelectors, report.DKIMSelectors)
+			}
+			var got []DoctorFinding
+			for _, f := range report.Findings {
+				got = append(got, DoctorFinding{Check: f.Check, Severity: f.Severity})
+			}
+			if !reflect.DeepEqual(got, tc.wantFindings) {
+				t.Errorf("want findings %v, but got:\n%s", tc.wantFindings, report)
+			}
+			if report.Score != tc.wantScore {
+				t.Errorf("want score %d, but got %d", tc.wantScore, report.Score)
+			}
+			if want := hasSeverity(tc.wantFindings, DoctorSeverityError); report.HasErrors() != want {
+				t.Errorf("want HasErrors %v, but got %v", want, report.HasErrors())
+			}
+		})
+	}
+}
+
+func hasSeverity(findings []DoctorFinding, s DoctorSeverity) bool {
+	for _, f := range findings {
+		if f.Severity == s {
+			return true
+		}
+	}
+	return false
+}
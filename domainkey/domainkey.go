@@ -5,8 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/masa23/mmauth/internal/dkimheader"
+	"github.com/masa23/mmauth/internal/idn"
+	"github.com/masa23/mmauth/internal/txtrecord"
 )
 
 type TXTLookupFunc func(name string) ([]string, error)
@@ -22,8 +27,21 @@ type defaultTXTResolver struct {
 	resolver *net.Resolver
 }
 
+// SharedTXTResolver, when set, is returned by NewDefaultTXTResolver so that all
+// DKIM and ARC key lookups go through the same resolver (e.g. split-horizon DNS).
+var SharedTXTResolver TXTResolver
+
+// StripTrailingNUL, when true, strips the NULs some broken DNS servers append
+// to TXT strings before key records are checked and parsed. It defaults to
+// false, matching spf.Options.StripTrailingNUL, so such records are rejected
+// with ErrControlCharacter.
+var StripTrailingNUL bool
+
 // NewDefaultTXTResolver creates a new default TXTResolver.
 func NewDefaultTXTResolver() TXTResolver {
+	if SharedTXTResolver != nil {
+		return SharedTXTResolver
+	}
 	return &defaultTXTResolver{
 		resolver: net.DefaultResolver,
 	}
@@ -52,6 +70,14 @@ var (
 	ErrInvalidServiceType   = errors.New("invalid service type")
 	ErrInvalidSelectorFlags = errors.New("invalid selector flags")
 	ErrInvalidVersion       = errors.New("invalid version")
+	// ErrControlCharacter is returned when any TXT record at the key's name
+	// contains control characters. The name holds only key records, so all of
+	// them are checked. Trailing NULs are stripped first if StripTrailingNUL is set.
+	ErrControlCharacter = txtrecord.ErrControlCharacter
+	// ErrKeyRevoked is returned when the key has been revoked (empty p=).
+	// It wraps ErrNoRecordFound, so callers treating a revoked key as a
+	// missing one keep working.
+	ErrKeyRevoked = fmt.Errorf("key revoked: %w", ErrNoRecordFound)
 )
 
 type HashAlgo string
@@ -66,6 +92,10 @@ type KeyType string
 const (
 	KeyTypeRSA     KeyType = "rsa"
 	KeyTypeED25519 KeyType = "ed25519"
+	// KeyTypeECDSA is an experimental, non-standard key type for ECDSA P-256
+	// keys published as SubjectPublicKeyInfo. ParseDomainKeyRecord does not
+	// set it as KeyType; see ExperimentalKeyType.
+	KeyTypeECDSA KeyType = "ecdsa"
 )
 
 type ServiceType string
@@ -82,15 +112,58 @@ const (
 	SelectorFlagsStrictDomain SelectorFlags = "s" // identifier is strict domain
 )
 
+// ReportType はRFC 6651のrr=タグで求められるレポートの種類
+type ReportType string
+
+const (
+	ReportTypeAll       ReportType = "all" // すべての失敗
+	ReportTypeSyntax    ReportType = "s"   // 署名または鍵の構文エラー
+	ReportTypeVerify    ReportType = "v"   // 署名の検証失敗やボディーハッシュの不一致
+	ReportTypeExpired   ReportType = "x"   // 有効期限切れの署名
+	ReportTypeUndefined ReportType = ""
+)
+
 type DomainKey struct {
-	HashAlgo      []HashAlgo      // h hash algorithm separated by colons
-	KeyType       KeyType         // k default:rsa
-	Notes         string          // n notes
-	PublicKey     string          // p public key base64 encoded
-	ServiceType   []ServiceType   // s service type separated by colons
-	SelectorFlags []SelectorFlags // t flags separated by colons
-	Version       string          // v version default:DKIM1
-	raw           string          // raw record
+	Granularity      string          // g granularity (RFC 4871, RFC 6376で廃止) default:*
+	HashAlgo         []HashAlgo      // h hash algorithm separated by colons
+	KeyType          KeyType         // k default:rsa
+	Notes            string          // n notes
+	PublicKey        string          // p public key base64 encoded
+	ReportAddress    string          // ra reporting address local-part (RFC 6651)
+	ReportPercent    int             // rp percentage of failures to report (RFC 6651) default:100
+	ReportTypes      []ReportType    // rr requested report types separated by colons (RFC 6651) default:all
+	ReportSMTP       string          // rs SMTP error string (RFC 6651)
+	ServiceType      []ServiceType   // s service type separated by colons
+	SelectorFlags    []SelectorFlags // t flags separated by colons
+	Version          string          // v version default:DKIM1
+	hasGranularity   bool            // g=タグが存在するか
+	hasReportPercent bool            // rp=タグが存在するか
+	raw              string          // raw record
+
+	experimentalKeyType KeyType // k=に含まれていた実験的な鍵の種類
+}
+
+// ReportPercentage はレポートを送る失敗の割合(0-100)を返す
+// rp=がない場合は100
+func (d *DomainKey) ReportPercentage() int {
+	if !d.hasReportPercent {
+		return 100
+	}
+	return d.ReportPercent
+}
+
+// WantsReport はrr=でtの種類のレポートが求められているか
+// rr=がない場合はすべてのレポートが求められているものとして扱う
+func (d *DomainKey) WantsReport(t ReportType) bool {
+	if len(d.ReportTypes) == 0 {
+		return true
+	}
+	for _, rt := range d.ReportTypes {
+		if rt == ReportTypeAll || rt == t {
+			return true
+		}
+	}
+	return false
 }
 
 // テストフラグが立っているか
@@ -103,6 +176,45 @@ func (d *DomainKey) IsTestFlag() bool {
 	return false
 }
 
+// Raw は鍵を解析したTXTレコードの文字列をそのまま返す
+// 公開されていた内容をログやAuthentication-Resultsのコメントに残すために使う
+func (d *DomainKey) Raw() string {
+	return d.raw
+}
+
+// ExperimentalKeyType returns the experimental key type (KeyTypeECDSA) listed
+// in k=, or "" if there is none. Unknown key types must be ignored (RFC 6376
+// 3.6.1), so it never affects KeyType; only verifiers that opted in to the
+// experimental algorithm consult it.
+func (d *DomainKey) ExperimentalKeyType() KeyType {
+	return d.experimentalKeyType
+}
+
+// HasGranularity g=タグが公開されているか
+func (d *DomainKey) HasGranularity() bool {
+	return d.hasGranularity
+}
+
+// MatchGranularity i=のローカルパートがg=に一致するか
+// RFC 4871 3.6.1: g=は"*"をワイルドカードとして1つ含むことができ、
+// 空の場合はどのローカルパートにも一致しない。g=がない場合は"*"として扱う
+func (d *DomainKey) MatchGranularity(localPart string) bool {
+	if !d.hasGranularity {
+		return true
+	}
+	g := d.Granularity
+	if g == "" {
+		return false
+	}
+	prefix, suffix, wildcard := strings.Cut(g, "*")
+	if !wildcard {
+		return g == localPart
+	}
+	return len(localPart) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(localPart, prefix) &&
+		strings.HasSuffix(localPart, suffix)
+}
+
 // サービスタイプが指定されたものか
 func (d *DomainKey) IsService(service ServiceType) bool {
 	if service == ServiceTypeAll {
@@ -124,7 +236,7 @@ func (d *DomainKey) IsService(service ServiceType) bool {
 // A key is considered revoked if the record contains "p=" but the parsed PublicKey is empty.
 func isKeyRevoked(record string, domainKey DomainKey) error {
 	if strings.Contains(record, "p=") && domainKey.PublicKey == "" {
-		return fmt.Errorf("key revoked: %w", ErrNoRecordFound)
+		return ErrKeyRevoked
 	}
 	return nil
 }
@@ -156,15 +268,50 @@ func LookupDKIMDomainKeyWithResolver(selector, domain string, resolver TXTResolv
 	return d, nil
 }
 
+// LookupDKIMDomainKeysWithResolver セレクタに公開されているDKIMのドメインキーをすべてLookupする
+// 鍵のローテーション中などに複数のレコードが返される場合に使う
+// versionがDKIM1でない鍵や解析できないレコードは除外し、有効な鍵がない場合はエラーを返す
+// resolverがnilの場合はデフォルトのリゾルバーを使用
+func LookupDKIMDomainKeysWithResolver(selector, domain string, resolver TXTResolver) ([]DomainKey, error) {
+	res, err := lookupTXTWithResolver(selector, domain, resolver)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseAllDomainKeyRecords(res)
+	if err != nil {
+		return nil, err
+	}
+	var ret []DomainKey
+	for _, k := range keys {
+		if k.Version != "" && k.Version != "DKIM1" {
+			continue
+		}
+		ret = append(ret, k)
+	}
+	if len(ret) == 0 {
+		return nil, ErrInvalidVersion
+	}
+	return ret, nil
+}
+
 // LookupARCDomainKey ARCのドメインキーを検索する
 // versionが含まれていなくてもエラーを返さない
 func LookupARCDomainKey(selector, domain string) (DomainKey, error) {
 	return lookupDomainKey(selector, domain)
 }
 
+// KeyRecordName は鍵のレコード名(selector._domainkey.domain)を返す
+// domainにU-labelが含まれる場合はA-labelに変換する (RFC 8616 5)
+func KeyRecordName(selector, domain string) string {
+	if ascii, err := idn.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	return selector + "._domainkey." + domain
+}
+
 // lookupDomainKey
 func lookupDomainKey(selector, domain string) (DomainKey, error) {
-	query := fmt.Sprintf("%s._domainkey.%s", selector, domain)
+	query := KeyRecordName(selector, domain)
 	res, err := DefaultResolver(query)
 	if dnsErr, ok := err.(*net.DNSError); ok {
 		if dnsErr.IsNotFound {
@@ -173,6 +320,10 @@ func lookupDomainKey(selector, domain string) (DomainKey, error) {
 	} else if err != nil {
 		return DomainKey{}, ErrDNSLookupFailed
 	}
+	res, err = txtrecord.Sanitize(res, StripTrailingNUL)
+	if err != nil {
+		return DomainKey{}, err
+	}
 	return parseDomainKeyRecords(res)
 }
 
@@ -197,7 +348,16 @@ func parseDomainKeyRecords(records []string) (DomainKey, error) {
 
 // lookupDomainKeyWithResolver
 func lookupDomainKeyWithResolver(selector, domain string, resolver TXTResolver) (DomainKey, error) {
-	query := fmt.Sprintf("%s._domainkey.%s", selector, domain)
+	res, err := lookupTXTWithResolver(selector, domain, resolver)
+	if err != nil {
+		return DomainKey{}, err
+	}
+	return parseDomainKeyRecords(res)
+}
+
+// lookupTXTWithResolver セレクタのTXTレコードを問い合わせる
+func lookupTXTWithResolver(selector, domain string, resolver TXTResolver) ([]string, error) {
+	query := KeyRecordName(selector, domain)
 
 	var res []string
 	var err error
@@ -215,13 +375,43 @@ func lookupDomainKeyWithResolver(selector, domain string, resolver TXTResolver)
 
 	if dnsErr, ok := err.(*net.DNSError); ok {
 		if dnsErr.IsNotFound {
-			return DomainKey{}, ErrNoRecordFound
+			return nil, ErrNoRecordFound
 		}
 	} else if err != nil {
-		return DomainKey{}, ErrDNSLookupFailed
+		return nil, ErrDNSLookupFailed
 	}
+	return txtrecord.Sanitize(res, StripTrailingNUL)
+}
 
-	return parseDomainKeyRecords(res)
+// parseAllDomainKeyRecords はTXTレコードから公開鍵を含むドメインキーをすべて取り出す
+// 解析できないレコードは読み飛ばし、有効な鍵が1つもない場合は最初のエラーを返す
+func parseAllDomainKeyRecords(records []string) ([]DomainKey, error) {
+	var keys []DomainKey
+	var firstErr error
+	for _, r := range records {
+		domainKey, err := ParseDomainKeyRecord(r)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if domainKey.PublicKey != "" {
+			keys = append(keys, domainKey)
+			continue
+		}
+		// p=が空の場合はキーが撤回されたとみなす
+		if err := isKeyRevoked(r, domainKey); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(keys) > 0 {
+		return keys, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoRecordFound
 }
 
 // ドメインキーレコードの解析
@@ -239,6 +429,11 @@ func ParseDomainKeyRecord(r string) (DomainKey, error) {
 		case "v":
 			key.Version = v
 			continue
+		case "g":
+			// RFC 6376では廃止されたDomainKeysとの互換用タグ
+			// 検証時の適用はdkim.VerifyOptionsで指定する
+			key.Granularity = v
+			key.hasGranularity = true
 		case "h":
 			algos := strings.Split(v, ":")
 			for _, algo := range algos {
@@ -262,6 +457,9 @@ func ParseDomainKeyRecord(r string) (DomainKey, error) {
 					key.KeyType = KeyTypeRSA
 				case KeyTypeED25519:
 					key.KeyType = KeyTypeED25519
+				case KeyTypeECDSA:
+					// Experimental: kept aside so that KeyType still ignores it per RFC 6376
+					key.experimentalKeyType = KeyTypeECDSA
 				// RFC 6376: Unrecognized key types MUST be ignored
 				default:
 					// Unknown key types are ignored per RFC 6376 Section 3.6.1
@@ -272,6 +470,24 @@ func ParseDomainKeyRecord(r string) (DomainKey, error) {
 		case "p":
 			// 空白を削除して格納
 			key.PublicKey = strings.ReplaceAll(v, " ", "")
+		case "ra":
+			// RFC 6651: dkim-quoted-printableで符号化されたローカルパート
+			key.ReportAddress = dkimheader.DecodeQuotedPrintable(v)
+		case "rp":
+			// 範囲外の値は無視してデフォルトの100とする
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 100 {
+				key.ReportPercent = n
+				key.hasReportPercent = true
+			}
+		case "rr":
+			for _, t := range strings.Split(v, ":") {
+				if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+					// 未知の種類も失わないようにそのまま保持する
+					key.ReportTypes = append(key.ReportTypes, ReportType(t))
+				}
+			}
+		case "rs":
+			key.ReportSMTP = dkimheader.DecodeQuotedPrintable(v)
 		case "s":
 			serviceTypes := strings.Split(v, ":")
 			for _, serviceType := range serviceTypes {
@@ -1,8 +1,13 @@
 package domainkey
 
 import (
+	"context"
+	"errors"
 	"net"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestParseDomainKeyRecord(t *testing.T) {
@@ -152,6 +157,10 @@ func TestParseDomainKeyRecord(t *testing.T) {
 				t.Errorf("Expected selector flags: %s, but got: %s", tc.expectedResult.SelectorFlags, actualResult.SelectorFlags)
 			}
 
+			if actualErr == nil && actualResult.Raw() != tc.input {
+				t.Errorf("Expected raw record: %s, but got: %s", tc.input, actualResult.Raw())
+			}
+
 			if (tc.expectedErr == nil && actualErr != nil) || (tc.expectedErr != nil && actualErr == nil) || (tc.expectedErr != nil && actualErr != nil && tc.expectedErr.Error() != actualErr.Error()) {
 				t.Errorf("Expected error: %v, but got: %v", tc.expectedErr, actualErr)
 			}
@@ -231,3 +240,132 @@ func TestLookupDomainKey(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchGranularity(t *testing.T) {
+	testCases := []struct {
+		name      string
+		record    string
+		localPart string
+		expected  bool
+	}{
+		{name: "no g tag", record: "v=DKIM1; p=abc", localPart: "user", expected: true},
+		{name: "wildcard", record: "g=*; p=abc", localPart: "user", expected: true},
+		{name: "exact match", record: "g=user; p=abc", localPart: "user", expected: true},
+		{name: "exact mismatch", record: "g=user; p=abc", localPart: "other", expected: false},
+		{name: "prefix wildcard", record: "g=news-*; p=abc", localPart: "news-daily", expected: true},
+		{name: "prefix wildcard mismatch", record: "g=news-*; p=abc", localPart: "info", expected: false},
+		{name: "suffix wildcard overlaps", record: "g=ab*ba; p=abc", localPart: "aba", expected: false},
+		{name: "empty g matches nothing", record: "g=; p=abc", localPart: "user", expected: false},
+		{name: "empty g with empty local part", record: "g=; p=abc", localPart: "", expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := ParseDomainKeyRecord(tc.record)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := key.MatchGranularity(tc.localPart); got != tc.expected {
+				t.Errorf("MatchGranularity(%q) with %q = %v, expected %v", tc.localPart, tc.record, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseDomainKeyReportTags(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       string
+		wantAddress string
+		wantPercent int
+		wantSMTP    string
+		wants       map[ReportType]bool
+	}{
+		{
+			name:        "defaults",
+			input:       "v=DKIM1; p=abc",
+			wantPercent: 100,
+			wants:       map[ReportType]bool{ReportTypeVerify: true, ReportTypeExpired: true},
+		},
+		{
+			name:        "all tags",
+			input:       "v=DKIM1; ra=dkim=2Dreports; rp=25; rr=v:X; rs=5.7.1=20bad=20signature; p=abc",
+			wantAddress: "dkim-reports",
+			wantPercent: 25,
+			wantSMTP:    "5.7.1 bad signature",
+			wants:       map[ReportType]bool{ReportTypeVerify: true, ReportTypeExpired: true, ReportTypeSyntax: false},
+		},
+		{
+			name:        "out of range percentage",
+			input:       "v=DKIM1; ra=reports; rp=150; rr=all; p=abc",
+			wantAddress: "reports",
+			wantPercent: 100,
+			wants:       map[ReportType]bool{ReportTypeSyntax: true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := ParseDomainKeyRecord(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if key.ReportAddress != tc.wantAddress {
+				t.Errorf("want ra=%q, but got %q", tc.wantAddress, key.ReportAddress)
+			}
+			if got := key.ReportPercentage(); got != tc.wantPercent {
+				t.Errorf("want rp=%d, but got %d", tc.wantPercent, got)
+			}
+			if key.ReportSMTP != tc.wantSMTP {
+				t.Errorf("want rs=%q, but got %q", tc.wantSMTP, key.ReportSMTP)
+			}
+			for rt, want := range tc.wants {
+				if got := key.WantsReport(rt); got != want {
+					t.Errorf("WantsReport(%q): want %v, but got %v", rt, want, got)
+				}
+			}
+		})
+	}
+}
+
+type propagationResolver struct {
+	mu      sync.Mutex
+	calls   int
+	visible int // この回数目以降の問い合わせで新しい鍵を返す
+}
+
+func (r *propagationResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.visible == 0 || r.calls < r.visible {
+		return []string{"v=DKIM1; k=rsa; p=T0xES0VZ"}, nil
+	}
+	return []string{"v=DKIM1; k=rsa; p=TkVX S0VZ"}, nil
+}
+
+func TestWaitForRecord(t *testing.T) {
+	fast := &propagationResolver{visible: 1}
+	slow := &propagationResolver{visible: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := WaitForRecord(ctx, "s2", "example.com", "TkVXS0VZ", []TXTResolver{fast, slow}, time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fast.calls != 1 {
+		t.Errorf("want resolver to be queried once after the key is visible, but got %d calls", fast.calls)
+	}
+	if slow.calls != 3 {
+		t.Errorf("want 3 calls, but got %d", slow.calls)
+	}
+
+	stale := &propagationResolver{}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := WaitForRecord(ctx, "s2", "example.com", "TkVXS0VZ", []TXTResolver{&propagationResolver{visible: 1}, stale}, time.Millisecond)
+	var perr *PropagationError
+	if !errors.As(err, &perr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want PropagationError, but got %v", err)
+	}
+	if !reflect.DeepEqual(perr.Pending, []int{1}) {
+		t.Errorf("want pending [1], but got %v", perr.Pending)
+	}
+}
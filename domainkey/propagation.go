@@ -0,0 +1,117 @@
+package domainkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/masa23/mmauth/internal/txtrecord"
+)
+
+// DefaultPropagationInterval はWaitForRecordでintervalが0以下の場合の問い合わせ間隔
+const DefaultPropagationInterval = 10 * time.Second
+
+// PropagationError はWaitForRecordが期限までに公開を確認できなかった場合のエラー
+type PropagationError struct {
+	// Pending はまだ期待した鍵が見えていないリゾルバーのresolversでの位置
+	Pending []int
+	// LastErrors はPendingの各リゾルバーで最後に起きた問い合わせのエラー(鍵が異なるだけの場合はnil)
+	LastErrors []error
+	// Err はctxのエラー
+	Err error
+}
+
+func (e *PropagationError) Error() string {
+	msg := fmt.Sprintf("dkim key is not visible on %d resolver(s) %v", len(e.Pending), e.Pending)
+	for _, err := range e.LastErrors {
+		if err != nil {
+			msg += fmt.Sprintf(": %v", err)
+			break
+		}
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *PropagationError) Unwrap() error {
+	return e.Err
+}
+
+// WaitForRecord はselector._domainkey.domainにp=がexpectedKeyのレコードが
+// resolversのすべてから見えるようになるまでintervalごとに問い合わせる
+// 鍵の切り替えで新しいセレクタの公開を待ってから署名に使う場合などに使う
+// expectedKeyはbase64の公開鍵で、空白は無視する
+// resolversが空の場合はNewDefaultTXTResolverを使う。一度見えたリゾルバーには再度問い合わせない
+// ctxが終了するまでに確認できない場合は*PropagationErrorを返す
+func WaitForRecord(ctx context.Context, selector, domain, expectedKey string, resolvers []TXTResolver, interval time.Duration) error {
+	expectedKey = stripSpaces(expectedKey)
+	if expectedKey == "" {
+		return errors.New("expected public key is empty")
+	}
+	if len(resolvers) == 0 {
+		resolvers = []TXTResolver{NewDefaultTXTResolver()}
+	}
+	if interval <= 0 {
+		interval = DefaultPropagationInterval
+	}
+	name := KeyRecordName(selector, domain)
+
+	pending := make([]int, len(resolvers))
+	for i := range pending {
+		pending[i] = i
+	}
+	lastErrors := make([]error, len(resolvers))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var still []int
+		for _, i := range pending {
+			ok, err := recordVisible(ctx, resolvers[i], name, expectedKey)
+			lastErrors[i] = err
+			if !ok {
+				still = append(still, i)
+			}
+		}
+		pending = still
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			perr := &PropagationError{Pending: pending, Err: ctx.Err()}
+			for _, i := range pending {
+				perr.LastErrors = append(perr.LastErrors, lastErrors[i])
+			}
+			return perr
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolverからnameを問い合わせ、p=がexpectedKeyのレコードがあるかを返す
+func recordVisible(ctx context.Context, resolver TXTResolver, name, expectedKey string) (bool, error) {
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if records, err = txtrecord.Sanitize(records, StripTrailingNUL); err != nil {
+		return false, err
+	}
+	for _, r := range records {
+		key, err := ParseDomainKeyRecord(r)
+		if err != nil {
+			continue
+		}
+		if stripSpaces(key.PublicKey) == expectedKey {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func stripSpaces(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -2,10 +2,14 @@ package domainkey
 
 import (
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"strings"
 )
 
 // ParseDKIMPublicKey parses the decoded value of the "p=" tag according to the
@@ -17,7 +21,8 @@ import (
 // base64-encoded in DNS.
 //
 // For interoperability, this function also accepts SubjectPublicKeyInfo (PKIX)
-// form for RSA as a fallback.
+// form for RSA as a fallback. The experimental k=ecdsa accepts only P-256 keys
+// in PKIX form.
 func ParseDKIMPublicKey(decoded []byte, keyType KeyType) (crypto.PublicKey, error) {
 	if keyType == "" {
 		keyType = KeyTypeRSA
@@ -56,7 +61,83 @@ func ParseDKIMPublicKey(decoded []byte, keyType KeyType) (crypto.PublicKey, erro
 		}
 		return nil, fmt.Errorf("invalid ed25519 public key type: %T", pub)
 
+	case KeyTypeECDSA:
+		// Experimental: P-256 key as SubjectPublicKeyInfo (PKIX).
+		pub, err := x509.ParsePKIXPublicKey(decoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ecdsa public key: %w", err)
+		}
+		ecPub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid ecdsa public key type: %T", pub)
+		}
+		if ecPub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ecdsa curve: %s", ecPub.Curve.Params().Name)
+		}
+		return ecPub, nil
+
 	default:
 		return nil, fmt.Errorf("unsupported key type: %s", keyType)
 	}
 }
+
+// PKIXEd25519Annotation is the annotation added to verification results when
+// the ed25519 key was published as PKIX instead of the raw 32-octet key.
+const PKIXEd25519Annotation = "ed25519-key-pkix"
+
+// IsPKIXEd25519 reports whether d is an ed25519 key published as a PKIX
+// SubjectPublicKeyInfo rather than the raw 32-octet key RFC 8463 requires.
+// ParseDKIMPublicKey accepts both, so verifiers use this to flag or refuse
+// the non-standard form.
+func (d *DomainKey) IsPKIXEd25519() bool {
+	if d == nil || d.KeyType != KeyTypeED25519 {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(d.PublicKey), ""))
+	if err != nil || len(decoded) == ed25519.PublicKeySize {
+		return false
+	}
+	pub, err := x509.ParsePKIXPublicKey(decoded)
+	if err != nil {
+		return false
+	}
+	_, ok := pub.(ed25519.PublicKey)
+	return ok
+}
+
+// FromPublicKey returns the DomainKey a verifier would obtain from the DNS
+// record publishing pub. RSA keys are encoded as RSAPublicKey (PKCS#1),
+// ed25519 keys as the raw 32-octet key and (experimental) ECDSA P-256 keys as
+// PKIX, as ParseDKIMPublicKey expects.
+// It lets a signer check its own signatures without a DNS round trip.
+func FromPublicKey(pub crypto.PublicKey) (*DomainKey, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return &DomainKey{
+			Version:   "DKIM1",
+			KeyType:   KeyTypeRSA,
+			PublicKey: base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(pub)),
+		}, nil
+	case ed25519.PublicKey:
+		return &DomainKey{
+			Version:   "DKIM1",
+			KeyType:   KeyTypeED25519,
+			PublicKey: base64.StdEncoding.EncodeToString(pub),
+		}, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ecdsa curve: %s", pub.Curve.Params().Name)
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		return &DomainKey{
+			Version:             "DKIM1",
+			PublicKey:           base64.StdEncoding.EncodeToString(der),
+			experimentalKeyType: KeyTypeECDSA,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+}
@@ -0,0 +1,31 @@
+package domainkey
+
+// SHA1Policy はDKIMとARCの検証でのrsa-sha1の署名の扱い
+// RFC 8301 3.1ではrsa-sha1の署名を有効とみなしてはならないが、
+// 段階的に拒否できるように、まだrsa-sha1を使っている送信者を確認できるようにする
+type SHA1Policy string
+
+const (
+	// SHA1PolicyDefault はDefaultSHA1Policyに従う
+	SHA1PolicyDefault SHA1Policy = ""
+	// SHA1Allow はrsa-sha1の署名もほかの署名と同じく検証する
+	SHA1Allow SHA1Policy = "allow"
+	// SHA1Warn はrsa-sha1の署名を検証し、結果にSHA1Annotationを付ける
+	SHA1Warn SHA1Policy = "warn"
+	// SHA1Deny は結果にSHA1Annotationを付け、passとfailをpermerrorにする
+	SHA1Deny SHA1Policy = "deny"
+)
+
+// SHA1Annotation はSHA1WarnとSHA1Denyでrsa-sha1の署名の結果に付ける注記
+const SHA1Annotation = "deprecated-algorithm:rsa-sha1"
+
+// DefaultSHA1Policy は呼び出し側で指定がない場合の扱い
+var DefaultSHA1Policy = SHA1Warn
+
+// Resolve はpを返す。pがSHA1PolicyDefaultの場合はDefaultSHA1Policyを返す
+func (p SHA1Policy) Resolve() SHA1Policy {
+	if p == SHA1PolicyDefault {
+		return DefaultSHA1Policy
+	}
+	return p
+}
@@ -0,0 +1,172 @@
+package mmauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/resolver"
+	"github.com/masa23/mmauth/spf"
+)
+
+// 鍵の生成からDNSへの公開、DKIM署名、転送先でのARCの封、最終的な受信者での検証までを通して確認する
+func TestEndToEnd(t *testing.T) {
+	dkimKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, arcKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	zone := resolver.NewZone()
+	publishKey(t, zone, "sel._domainkey.example.com", dkimKey.Public())
+	publishKey(t, zone, "arc._domainkey.relay.example.net", arcKey.Public())
+	zone.AddTXT("example.com", "v=spf1 ip4:192.0.2.1 -all")
+	zone.AddTXT("_dmarc.example.com", "v=DMARC1; p=reject")
+	useZone(t, zone)
+	dmarcLookup := func(domain string) (*dmarc.Record, error) {
+		txt, err := zone.LookupTXT(context.Background(), "_dmarc."+domain)
+		if err != nil || len(txt) == 0 {
+			return nil, dmarc.ErrNoRecordFound
+		}
+		return dmarc.ParseRecord(txt[0])
+	}
+
+	msg := "From: alice@example.com\r\n" +
+		"To: bob@example.org\r\n" +
+		"Subject: end to end\r\n" +
+		"Message-ID: <e2e@example.com>\r\n" +
+		"\r\n" +
+		"Hello,\r\n" +
+		"this message is signed, forwarded and sealed.\r\n"
+	relaxed := BodyCanonicalizationAndAlgorithm{Body: CanonicalizationRelaxed, Algorithm: crypto.SHA256}
+
+	// 送信者: DKIM署名
+	m := readMMAuth(t, msg, &relaxed)
+	sig := &dkim.Signature{
+		Version:          1,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "sel",
+		Headers:          "From:To:Subject:Message-ID",
+		BodyHash:         m.GetBodyHash(relaxed),
+	}
+	if err := sig.SignWith(m.Headers, dkimKey, dkim.WithSelfCheck(nil)); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	msg = "DKIM-Signature: " + sig.String() + "\r\n" + msg
+
+	// 転送者: 認証してARCで封をする
+	m = readMMAuth(t, msg, &relaxed)
+	m.DMARCLookup = dmarcLookup
+	r := m.Authenticate(net.ParseIP("192.0.2.1"), "mail.example.com", "alice@example.com")
+	if r.DMARC.Result != dmarc.ResultPass || len(r.DKIM) != 1 || r.DKIM[0].VerifyResult.Status() != dkim.VerifyStatusPass {
+		t.Fatalf("want dkim and dmarc pass at the relay, but got %s", r.AuthenticationResults("relay.example.net"))
+	}
+	aar := "ARC-Authentication-Results: " + r.ARCAuthenticationResults(1, "relay.example.net", nil).String() + "\r\n"
+	headers := append([]string{aar}, m.Headers...)
+	ams := &arc.ARCMessageSignature{
+		InstanceNumber:   1,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "relay.example.net",
+		Selector:         "arc",
+		BodyHash:         m.GetBodyHash(relaxed),
+	}
+	if err := ams.SignWithOptions(headers, arcKey, &arc.SignerOptions{SelfCheck: true, Headers: arc.RecommendedSignHeaders}); err != nil {
+		t.Fatalf("failed to sign arc-message-signature: %v", err)
+	}
+	amsHeader := "ARC-Message-Signature: " + ams.String() + "\r\n"
+	headers = append([]string{amsHeader}, headers...)
+	seal := &arc.ARCSeal{
+		InstanceNumber:  1,
+		ChainValidation: arc.ChainValidationResultNone,
+		Domain:          "relay.example.net",
+		Selector:        "arc",
+	}
+	if err := seal.SignWithOptions(headers, arcKey, &arc.SignerOptions{SelfCheck: true}); err != nil {
+		t.Fatalf("failed to sign arc-seal: %v", err)
+	}
+	msg = "ARC-Seal: " + seal.String() + "\r\n" + amsHeader + aar + msg
+
+	// 受信者: 転送によりSPFはfailになるが、DKIMとARCでDMARCはpassする
+	m = readMMAuth(t, msg, nil)
+	m.DMARCLookup = dmarcLookup
+	r = m.Authenticate(net.ParseIP("198.51.100.1"), "relay.example.net", "alice@example.com")
+	if r.SPF.Status != spf.Fail {
+		t.Errorf("want spf fail after forwarding, but got %s", r.SPF.Status)
+	}
+	if r.SPFPolicyStrength != spf.PolicyStrengthStrict {
+		t.Errorf("want %s, but got %s", spf.PolicyStrengthStrict, r.SPFPolicyStrength)
+	}
+	if r.ARC != arc.ChainValidationResultPass {
+		t.Errorf("want arc pass, but got %s", r.ARC)
+	}
+	if r.DMARC.Result != dmarc.ResultPass {
+		t.Errorf("want dmarc pass, but got %s", r.DMARC.Result)
+	}
+	if got := r.RecommendedAction(); got != ActionAcceptWithAnnotation {
+		t.Errorf("want %s, but got %s", ActionAcceptWithAnnotation, got)
+	}
+	ar := r.AuthenticationResults("mx.example.org")
+	for _, want := range []string{"dkim=pass", "arc=pass", "dmarc=pass"} {
+		if !strings.Contains(ar, want) {
+			t.Errorf("want %q in %q", want, ar)
+		}
+	}
+}
+
+// 公開鍵をDKIMの鍵のレコードとしてゾーンに登録する
+func publishKey(t *testing.T, zone *resolver.Zone, name string, pub crypto.PublicKey) {
+	t.Helper()
+	dk, err := domainkey.FromPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to encode public key: %v", err)
+	}
+	zone.AddTXT(name, "v=DKIM1; k="+string(dk.KeyType)+"; p="+dk.PublicKey)
+}
+
+// DKIM・ARCの鍵とSPFの問い合わせをゾーンで行う
+func useZone(t *testing.T, zone *resolver.Zone) {
+	t.Helper()
+	origShared := domainkey.SharedTXTResolver
+	origTXT, origIP := spf.DefaultTXTResolver, spf.DefaultIPResolver
+	t.Cleanup(func() {
+		domainkey.SharedTXTResolver = origShared
+		spf.DefaultTXTResolver, spf.DefaultIPResolver = origTXT, origIP
+	})
+	domainkey.SharedTXTResolver = zone
+	spf.DefaultTXTResolver = func(name string) ([]string, error) {
+		return zone.LookupTXT(context.Background(), name)
+	}
+	spf.DefaultIPResolver = func(name string) ([]net.IP, error) {
+		return zone.LookupIP(context.Background(), "ip", name)
+	}
+}
+
+// メッセージを読み込んだMMAuthを返す
+// bcaを指定した場合はそのボディーハッシュも計算する
+func readMMAuth(t *testing.T, msg string, bca *BodyCanonicalizationAndAlgorithm) *MMAuth {
+	t.Helper()
+	m := NewMMAuth()
+	if bca != nil {
+		m.AddBodyHash(*bca)
+	}
+	if _, err := m.Write([]byte(msg)); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	return m
+}
@@ -0,0 +1,152 @@
+// Package errcode はSPF・DKIM・ARC・DMARCのエラーと検証結果に付ける、機械で扱うためのコード
+//
+// エラーや結果のメッセージは人が読むためのもので、日本語と英語が混在し、版によって変わることがある。
+// 監視や集計ではメッセージを正規表現で照合せずに、Codeで分類する。
+// コードは「方式_結果_原因」(例: DKIM_PERMERROR_KEY_REVOKED)の形式で、一度定義した値は変更しない。
+// 原因を特定できない場合は「方式_結果」(例: DKIM_PERMERROR)のコードになる。
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code はエラーや検証結果の種類を表す安定した識別子
+type Code string
+
+// String はコードの文字列を返す
+func (c Code) String() string {
+	return string(c)
+}
+
+// DKIMのコード
+const (
+	DKIMPermErrorAlgorithmUnsupported     Code = "DKIM_PERMERROR_ALGORITHM_UNSUPPORTED"
+	DKIMPermErrorKeyNotFound              Code = "DKIM_PERMERROR_KEY_NOT_FOUND"
+	DKIMPermErrorKeyRevoked               Code = "DKIM_PERMERROR_KEY_REVOKED"
+	DKIMPermErrorKeyRecordInvalid         Code = "DKIM_PERMERROR_KEY_RECORD_INVALID"
+	DKIMPermErrorKeyServiceType           Code = "DKIM_PERMERROR_KEY_SERVICE_TYPE"
+	DKIMPermErrorKeyPolicy                Code = "DKIM_PERMERROR_KEY_POLICY"
+	DKIMPermErrorKeyInvalid               Code = "DKIM_PERMERROR_KEY_INVALID"
+	DKIMPermErrorKeyTooSmall              Code = "DKIM_PERMERROR_KEY_TOO_SMALL"
+	DKIMPermErrorKeyEncoding              Code = "DKIM_PERMERROR_KEY_ENCODING"
+	DKIMPermErrorVersion                  Code = "DKIM_PERMERROR_VERSION"
+	DKIMPermErrorGranularity              Code = "DKIM_PERMERROR_GRANULARITY"
+	DKIMPermErrorTimestampAfterExpiration Code = "DKIM_PERMERROR_TIMESTAMP_AFTER_EXPIRATION"
+	DKIMPermErrorFutureTimestamp          Code = "DKIM_PERMERROR_FUTURE_TIMESTAMP"
+	DKIMPermErrorSHA1NotAllowed           Code = "DKIM_PERMERROR_SHA1_NOT_ALLOWED"
+	DKIMTempErrorKeyLookup                Code = "DKIM_TEMPERROR_KEY_LOOKUP"
+	DKIMFailSignature                     Code = "DKIM_FAIL_SIGNATURE"
+	DKIMFailBodyHash                      Code = "DKIM_FAIL_BODY_HASH"
+	DKIMFailExpired                       Code = "DKIM_FAIL_EXPIRED"
+	DKIMFailDuplicateHeader               Code = "DKIM_FAIL_DUPLICATE_HEADER"
+	DKIMNeutralNoSignature                Code = "DKIM_NEUTRAL_NO_SIGNATURE"
+	DKIMPolicySkipped                     Code = "DKIM_POLICY_SKIPPED"
+	DKIMPolicySignatureLimit              Code = "DKIM_POLICY_SIGNATURE_LIMIT"
+	DKIMPolicyAUIDMismatch                Code = "DKIM_POLICY_AUID_MISMATCH"
+)
+
+// ARCのコード
+const (
+	ARCPermErrorKeyNotFound      Code = "ARC_PERMERROR_KEY_NOT_FOUND"
+	ARCPermErrorKeyRecordInvalid Code = "ARC_PERMERROR_KEY_RECORD_INVALID"
+	ARCPermErrorKeyInvalid       Code = "ARC_PERMERROR_KEY_INVALID"
+	ARCPermErrorKeyPolicy        Code = "ARC_PERMERROR_KEY_POLICY"
+	ARCPermErrorSHA1NotAllowed   Code = "ARC_PERMERROR_SHA1_NOT_ALLOWED"
+	ARCPermErrorHeader           Code = "ARC_PERMERROR_HEADER"
+	ARCTempErrorKeyLookup        Code = "ARC_TEMPERROR_KEY_LOOKUP"
+	ARCFailSignature             Code = "ARC_FAIL_SIGNATURE"
+	ARCFailBodyHash              Code = "ARC_FAIL_BODY_HASH"
+	ARCFailChain                 Code = "ARC_FAIL_CHAIN"
+	ARCNeutralNoSignature        Code = "ARC_NEUTRAL_NO_SIGNATURE"
+)
+
+// SPFのコード
+const (
+	SPFPermErrorTooManyLookups     Code = "SPF_PERMERROR_TOO_MANY_LOOKUPS"
+	SPFPermErrorTooManyVoidLookups Code = "SPF_PERMERROR_TOO_MANY_VOID_LOOKUPS"
+	SPFPermErrorTooManyRecords     Code = "SPF_PERMERROR_TOO_MANY_RECORDS"
+	SPFPermErrorMultipleRecords    Code = "SPF_PERMERROR_MULTIPLE_RECORDS"
+	SPFPermErrorRecordTooLong      Code = "SPF_PERMERROR_RECORD_TOO_LONG"
+	SPFPermErrorSyntax             Code = "SPF_PERMERROR_SYNTAX"
+	SPFPermErrorMacro              Code = "SPF_PERMERROR_MACRO"
+	SPFPermErrorNestingTooDeep     Code = "SPF_PERMERROR_NESTING_TOO_DEEP"
+	SPFPermErrorLoop               Code = "SPF_PERMERROR_LOOP"
+	SPFPermErrorIncludeNoRecord    Code = "SPF_PERMERROR_INCLUDE_NO_RECORD"
+	SPFPermErrorRedirectNoRecord   Code = "SPF_PERMERROR_REDIRECT_NO_RECORD"
+	SPFPermErrorPTRNotAllowed      Code = "SPF_PERMERROR_PTR_NOT_ALLOWED"
+	SPFTempErrorDNS                Code = "SPF_TEMPERROR_DNS"
+	SPFTempErrorCanceled           Code = "SPF_TEMPERROR_CANCELED"
+	SPFTempErrorDNSTimeExceeded    Code = "SPF_TEMPERROR_DNS_TIME_EXCEEDED"
+	SPFNoneNoRecord                Code = "SPF_NONE_NO_RECORD"
+	SPFNoneInvalidDomain           Code = "SPF_NONE_INVALID_DOMAIN"
+)
+
+// DMARCのコード
+const (
+	DMARCPermErrorMissingFrom   Code = "DMARC_PERMERROR_MISSING_FROM"
+	DMARCPermErrorRecordInvalid Code = "DMARC_PERMERROR_RECORD_INVALID"
+	DMARCTempErrorDNS           Code = "DMARC_TEMPERROR_DNS"
+	DMARCNoneNoRecord           Code = "DMARC_NONE_NO_RECORD"
+	DMARCNoneMultipleRecords    Code = "DMARC_NONE_MULTIPLE_RECORDS"
+	DMARCFailNotAligned         Code = "DMARC_FAIL_NOT_ALIGNED"
+)
+
+// Error はコードを付けたエラー
+// Error()は元のエラーのメッセージをそのまま返し、errors.Is・errors.Asは元のエラーにも一致する
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap はerrにcodeを付ける
+// errがnilの場合はnilを返す
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// New はtextをメッセージとするコード付きのエラーを返す
+func New(code Code, text string) error {
+	return &Error{Code: code, Err: errors.New(text)}
+}
+
+// Errorf はfmt.Errorfで作ったエラーにcodeを付ける(%wも使える)
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Of はerrに付けられたコードを返す
+// 複数付けられている場合は最も外側のもの、付けられていない場合(errがnilを含む)は空
+func Of(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// Status は方式と結果のみのコード(例: DKIM_PERMERROR)を返す
+func Status(method, status string) Code {
+	return Code(strings.ToUpper(method + "_" + status))
+}
+
+// Result はerrに付けられたコードを返し、付けられていない場合は方式と結果のみのコードを返す
+// 各パッケージの検証結果のCodeメソッドで使う
+func Result(method, status string, err error) Code {
+	if c := Of(err); c != "" {
+		return c
+	}
+	return Status(method, status)
+}
@@ -0,0 +1,52 @@
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	base := errors.New("base")
+	testCases := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{name: "nil", err: nil, want: ""},
+		{name: "plain", err: base, want: ""},
+		{name: "wrapped", err: Wrap(DKIMFailSignature, base), want: DKIMFailSignature},
+		{name: "wrapped by fmt", err: fmt.Errorf("context: %w", Wrap(SPFTempErrorDNS, base)), want: SPFTempErrorDNS},
+		{name: "outermost wins", err: Wrap(ARCFailChain, Wrap(ARCFailSignature, base)), want: ARCFailChain},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Of(tc.err); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	base := errors.New("base")
+	err := Errorf(DKIMPermErrorKeyInvalid, "failed: %w", base)
+	if err.Error() != "failed: base" {
+		t.Errorf("want %q, but got %q", "failed: base", err.Error())
+	}
+	if !errors.Is(err, base) {
+		t.Errorf("want errors.Is to match the wrapped error")
+	}
+	if Wrap(DKIMFailSignature, nil) != nil {
+		t.Errorf("want nil for a nil error")
+	}
+}
+
+func TestResult(t *testing.T) {
+	if got := Result("dkim", "permerror", nil); got != "DKIM_PERMERROR" {
+		t.Errorf("want DKIM_PERMERROR, but got %s", got)
+	}
+	if got := Result("dkim", "permerror", New(DKIMPermErrorKeyRevoked, "revoked")); got != DKIMPermErrorKeyRevoked {
+		t.Errorf("want %s, but got %s", DKIMPermErrorKeyRevoked, got)
+	}
+}
@@ -0,0 +1,97 @@
+// dkimsign は標準入力のメッセージにDKIM署名を付与し、標準出力またはSMTPで送信する例
+//
+//	go run ./examples/dkimsign -key dkim.pem -domain example.com -selector sel < mail.eml
+//	go run ./examples/dkimsign -key dkim.pem -domain example.com -selector sel \
+//		-smtp localhost:25 -from alice@example.com -to bob@example.org < mail.eml
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"flag"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+
+	"github.com/masa23/mmauth"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/keyio"
+)
+
+func main() {
+	keyPath := flag.String("key", "", "秘密鍵のファイル (PEM)")
+	domain := flag.String("domain", "", "署名するドメイン (d=)")
+	selector := flag.String("selector", "", "セレクタ (s=)")
+	headers := flag.String("headers", "From:To:Subject:Date:Message-ID", "署名するヘッダ (h=)")
+	addr := flag.String("smtp", "", "送信先のSMTPサーバ (host:port)。空の場合は標準出力に書き出す")
+	from := flag.String("from", "", "エンベロープの送信者")
+	to := flag.String("to", "", "エンベロープの宛先 (カンマ区切り)")
+	checkDNS := flag.Bool("check-dns", false, "署名の前にDNSに公開されている公開鍵が秘密鍵と一致するか確認する")
+	flag.Parse()
+	if *keyPath == "" || *domain == "" || *selector == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	key, algo, err := keyio.LoadPrivateKey(*keyPath, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	raw, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ヘッダとボディーハッシュを取得する
+	bca := mmauth.BodyCanonicalizationAndAlgorithm{
+		Body:      mmauth.CanonicalizationRelaxed,
+		Algorithm: crypto.SHA256,
+	}
+	m := mmauth.NewMMAuth()
+	m.AddBodyHash(bca)
+	if _, err := m.Write(raw); err != nil {
+		log.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	sig := &dkim.Signature{
+		Version:          1,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           *domain,
+		Selector:         *selector,
+		Headers:          *headers,
+		BodyHash:         m.GetBodyHash(bca),
+	}
+	signOpts := []dkim.SignerOption{dkim.WithAlgorithm(algo), dkim.WithSelfCheck(nil)}
+	if *checkDNS {
+		signOpts = append(signOpts, dkim.WithPublishedKeyCheck(nil))
+	}
+	if err := sig.SignWith(m.Headers, key, signOpts...); err != nil {
+		log.Fatal(err)
+	}
+	signed, err := mmauth.PrependHeaders(bytes.NewReader(raw), "DKIM-Signature: "+sig.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *addr == "" {
+		if _, err := io.Copy(os.Stdout, signed); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *from == "" || *to == "" {
+		log.Fatal("-from and -to are required with -smtp")
+	}
+	msg, err := io.ReadAll(signed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := smtp.SendMail(*addr, nil, *from, strings.Split(*to, ","), msg); err != nil {
+		log.Fatal(err)
+	}
+}
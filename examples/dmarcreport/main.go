@@ -0,0 +1,58 @@
+// dmarcreport は受信したドメインのDMARCレコードを問い合わせ、集計レポートの送信先を一覧にする例
+// cronなどで定期的に実行し、レポートを送るドメインと宛先の確認に使う
+// (集計レポートのXMLの生成はこのライブラリには含まれない)
+//
+//	go run ./examples/dmarcreport example.com example.net
+//	cut -d' ' -f1 domains.txt | go run ./examples/dmarcreport
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/masa23/mmauth/dmarc"
+)
+
+func main() {
+	flag.Parse()
+	domains := flag.Args()
+	if len(domains) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if d := strings.TrimSpace(scanner.Text()); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 同じ組織ドメインのサブドメインが続く場合に問い合わせを減らす
+	cache := dmarc.NewCache()
+	for _, domain := range domains {
+		record, err := cache.LookupRecordWithSubdomainFallback(domain)
+		if errors.Is(err, dmarc.ErrNoRecordFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("%s: %v", domain, err)
+			continue
+		}
+		addrs := record.AggregateReportAddresses()
+		if len(addrs) == 0 {
+			continue
+		}
+		interval := 24 * time.Hour
+		if record.ReportInterval > 0 {
+			interval = time.Duration(record.ReportInterval) * time.Second
+		}
+		fmt.Printf("%s\t%s\t%s\n", domain, interval, strings.Join(addrs, ","))
+	}
+}
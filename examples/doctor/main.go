@@ -0,0 +1,40 @@
+// doctor はドメインのSPF・DMARC・DKIM・MTA-STS・TLS-RPTの設定を検査し、問題と対処方法を出力する例
+// errorの問題がある場合は終了コード1で終了する
+//
+//	go run ./examples/doctor example.com
+//	go run ./examples/doctor -selectors sel1,sel2 example.com
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/masa23/mmauth"
+)
+
+func main() {
+	selectors := flag.String("selectors", "", "鍵を探すDKIMのセレクタ (カンマ区切り)。空の場合は一般的なセレクタを探す")
+	timeout := flag.Duration("timeout", 30*time.Second, "検査全体のタイムアウト")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := &mmauth.DoctorOptions{}
+	if *selectors != "" {
+		opts.Selectors = strings.Split(*selectors, ",")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report := mmauth.DiagnoseWithOptions(ctx, flag.Arg(0), opts)
+	fmt.Print(report)
+	if report.HasErrors() {
+		os.Exit(1)
+	}
+}
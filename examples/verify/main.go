@@ -0,0 +1,43 @@
+// verify は受信したメッセージを認証し、付与するAuthentication-Resultsヘッダと推奨する処理を出力する例
+// MTAのmilterやフィルタから、接続元の情報とメッセージを渡して呼び出すことを想定している
+// (milterプロトコルの実装はこのライブラリには含まれない)
+//
+//	go run ./examples/verify -authserv mx.example.org -ip 192.0.2.1 \
+//		-helo mail.example.com -from alice@example.com < mail.eml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/masa23/mmauth"
+)
+
+func main() {
+	authservID := flag.String("authserv", "localhost", "Authentication-Resultsのauthserv-id")
+	ip := flag.String("ip", "", "接続元のIPアドレス")
+	helo := flag.String("helo", "", "HELO/EHLOのドメイン")
+	mailFrom := flag.String("from", "", "MAIL FROMのアドレス")
+	flag.Parse()
+
+	remoteAddr := net.ParseIP(*ip)
+	if remoteAddr == nil {
+		log.Fatalf("invalid ip address: %q", *ip)
+	}
+
+	m := mmauth.NewMMAuth()
+	if _, err := io.Copy(m, os.Stdin); err != nil {
+		log.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	r := m.Authenticate(remoteAddr, *helo, *mailFrom)
+	fmt.Printf("Authentication-Results: %s\r\n", r.AuthenticationResults(*authservID))
+	fmt.Fprintf(os.Stderr, "action: %s\n", r.RecommendedAction())
+}
@@ -0,0 +1,93 @@
+package mmauth
+
+import (
+	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
+)
+
+// Option はNewMMAuthでMMAuthの項目を設定する関数
+// 解析を始める前に適用されるため、書き込み中に読まれる項目も安全に設定できる
+type Option func(*MMAuth)
+
+// WithPolicy はAuthenticateの判定を上書きするPolicyHookを設定する
+func WithPolicy(hook PolicyHook) Option {
+	return func(m *MMAuth) { m.PolicyHook = hook }
+}
+
+// WithDMARCLookup はAuthenticateでDMARCレコードを取得する関数を設定する
+func WithDMARCLookup(lookup func(domain string) (*dmarc.Record, error)) Option {
+	return func(m *MMAuth) { m.DMARCLookup = lookup }
+}
+
+// WithDMARCOptions はAuthenticateでのDMARCの評価オプションを設定する
+func WithDMARCOptions(opts *dmarc.EvaluateOptions) Option {
+	return func(m *MMAuth) { m.DMARCOptions = opts }
+}
+
+// WithSPFMapping はSPFのsoftfail・neutralのRecommendedActionでの扱いを設定する
+func WithSPFMapping(mapping *SPFMapping) Option {
+	return func(m *MMAuth) { m.SPFMapping = mapping }
+}
+
+// WithAuthUser はSMTP AUTHで認証されたユーザー名を設定する
+func WithAuthUser(user string) Option {
+	return func(m *MMAuth) { m.AuthUser = user }
+}
+
+// WithARCChainPolicy はARCチェーンのタイムスタンプの検査を設定する
+func WithARCChainPolicy(policy *arc.ChainPolicy) Option {
+	return func(m *MMAuth) { m.ARCChainPolicy = policy }
+}
+
+// WithARCTrustedSealers は信頼するARCシーラーのドメインを設定する
+func WithARCTrustedSealers(domains ...string) Option {
+	return func(m *MMAuth) { m.ARCTrustedSealers = domains }
+}
+
+// WithSHA1Policy はDKIMとARCのrsa-sha1の署名の扱いを設定する
+func WithSHA1Policy(policy domainkey.SHA1Policy) Option {
+	return func(m *MMAuth) { m.SHA1Policy = policy }
+}
+
+// WithStrictEd25519Keys はPKIX形式で公開されたed25519の鍵による署名をpermerrorにする
+func WithStrictEd25519Keys() Option {
+	return func(m *MMAuth) { m.StrictEd25519Keys = true }
+}
+
+// WithWireFidelity はsimpleのヘッダの正規化を受信したままのヘッダで検証する
+func WithWireFidelity() Option {
+	return func(m *MMAuth) { m.WireFidelity = true }
+}
+
+// WithParseOptions はDKIM-SignatureとARC-Message-Signatureのタグの長さの上限を設定する
+func WithParseOptions(dkimOpts *dkim.ParseOptions, arcOpts *arc.ParseOptions) Option {
+	return func(m *MMAuth) {
+		m.DKIMParseOptions = dkimOpts
+		m.ARCParseOptions = arcOpts
+	}
+}
+
+// WithDKIMLimits は検証するDKIM署名の数とヘッダの長さの上限を設定する
+func WithDKIMLimits(maxSignatures, maxSignatureSize int) Option {
+	return func(m *MMAuth) {
+		m.MaxDKIMSignatures = maxSignatures
+		m.MaxDKIMSignatureSize = maxSignatureSize
+	}
+}
+
+// WithDKIMAUIDPolicy はi=のローカルパートをFromのアドレスと照合する場合の扱いを設定する
+func WithDKIMAUIDPolicy(policy dkim.AUIDPolicy) Option {
+	return func(m *MMAuth) { m.DKIMAUIDPolicy = policy }
+}
+
+// WithFinalCRLF は改行で終わらない最後の行の扱いを設定する
+func WithFinalCRLF(finalCRLF FinalCRLF) Option {
+	return func(m *MMAuth) { m.FinalCRLF = finalCRLF }
+}
+
+// WithScrub はヘッダの解析の前に自身のauthserv-idを名乗るAuthentication-Resultsを除去する
+func WithScrub(opts *ScrubOptions) Option {
+	return func(m *MMAuth) { m.Scrub = opts }
+}
@@ -3,11 +3,11 @@ package mmauth
 import (
 	"bufio"
 	"crypto"
+	"errors"
 	"fmt"
-	"net/textproto"
 	"strings"
 
-	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/dkim"
 	"github.com/masa23/mmauth/internal/header"
 )
 
@@ -15,26 +15,35 @@ type headers []string
 
 // ヘッダを読み込み分解する
 func readHeader(r *bufio.Reader) (headers, error) {
-	tr := textproto.NewReader(r)
+	h, _, err := readHeaderRaw(r)
+	return h, err
+}
 
-	var h headers
+// ヘッダを読み込み、行末をCRLFに揃えたヘッダと受信したままのヘッダを返す
+// 2つのヘッダリストは同じ位置が同じヘッダに対応する
+// 受信したままのヘッダはLFのみの行末などを含めて入力のバイト列をそのまま保持する
+func readHeaderRaw(r *bufio.Reader) (headers, headers, error) {
+	var h, raw headers
 	for {
-		l, err := tr.ReadLine()
+		line, err := r.ReadString('\n')
 		if err != nil {
-			return h, fmt.Errorf("failed to read header: %v", err)
+			return h, raw, fmt.Errorf("failed to read header: %v", err)
 		}
+		l := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
 
 		if len(l) == 0 {
 			break
 		} else if len(h) > 0 && (l[0] == ' ' || l[0] == '\t') {
 			// This is a continuation line
 			h[len(h)-1] += l + crlf
+			raw[len(raw)-1] += line
 		} else {
 			h = append(h, l+crlf)
+			raw = append(raw, line)
 		}
 	}
 
-	return h, nil
+	return h, raw, nil
 }
 
 func hashAlgo(algo SignatureAlgorithm) crypto.Hash {
@@ -54,42 +63,22 @@ type BodyCanonicalizationAndAlgorithm struct {
 	Body      Canonicalization
 	Algorithm crypto.Hash
 	Limit     int64
+	// FinalCRLF は改行で終わらない最後の行の扱い。ゼロ値はRFCに従いCRLFを補う
+	FinalCRLF FinalCRLF
 }
 
 func isCcanonicalizationBodyAndAlgorithm(c BodyCanonicalizationAndAlgorithm, can []BodyCanonicalizationAndAlgorithm) bool {
 	for _, v := range can {
-		if v.Body == c.Body && v.Algorithm == c.Algorithm && v.Limit == c.Limit {
+		if v.Body == c.Body && v.Algorithm == c.Algorithm && v.Limit == c.Limit && v.FinalCRLF == c.FinalCRLF {
 			return true
 		}
 	}
 	return false
 }
 
+// c=タグの値をパースする (dkim.ParseCanonicalizationを参照)
 func parseHeaderCanonicalization(s string) (header Canonicalization, body Canonicalization, err error) {
-	if s == "" {
-		// 指定がない場合はsimple/simple
-		return CanonicalizationSimple, CanonicalizationSimple, nil
-	}
-	ret := strings.Split(s, "/")
-	if len(ret) != 2 {
-		// 一つしかしていない場合はヘッダーに適用
-		// bodyはsimple
-		return Canonicalization(ret[0]), CanonicalizationSimple, nil
-	}
-	switch canonical.Canonicalization(ret[0]) {
-	case canonical.Simple, canonical.Relaxed:
-		header = Canonicalization(ret[0])
-	default:
-		return "", "", fmt.Errorf("invalid canonicalization")
-	}
-	switch canonical.Canonicalization(ret[1]) {
-	case canonical.Simple, canonical.Relaxed:
-		body = Canonicalization(ret[1])
-	default:
-		return "", "", fmt.Errorf("invalid canonicalization")
-	}
-
-	return
+	return dkim.ParseCanonicalization(s)
 }
 
 // ヘッダからアドレスを取得する
@@ -102,6 +91,96 @@ func ParseAddressDomain(s string) (string, error) {
 	return header.ParseAddressDomain(s)
 }
 
+var (
+	// ErrNoFromHeader はFromヘッダがない場合のエラー
+	ErrNoFromHeader = errors.New("no From header")
+	// ErrMultipleFromHeaders はFromヘッダが複数ある場合のエラー
+	ErrMultipleFromHeaders = errors.New("multiple From headers")
+	// ErrMultipleFromDomains はFromに異なるドメインのアドレスが複数ある場合のエラー
+	ErrMultipleFromDomains = errors.New("multiple From domains")
+	// ErrInvalidEmailFormat はFromからアドレスを取り出せない場合のエラー
+	ErrInvalidEmailFormat = header.ErrInvalidEmailFormat
+)
+
+// FromDomain はDMARCの評価に使うRFC5322.Fromのドメインをヘッダから取り出す
+// グループ構文、コメント、複数のアドレス、廃止された経路付きのアドレスに対応し、
+// 国際化ドメイン名はA-labelに変換して小文字で返す
+// RFC 7489 6.6.1 に従い、Fromヘッダが複数ある場合はErrMultipleFromHeaders、
+// 異なるドメインのアドレスが複数ある場合はErrMultipleFromDomainsを返す
+// (同じドメインのアドレスが複数ある場合はそのドメインを返す)
+func FromDomain(headers []string) (string, error) {
+	var from string
+	for _, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "From") {
+			continue
+		}
+		if from != "" {
+			return "", ErrMultipleFromHeaders
+		}
+		from = v
+		if strings.TrimSpace(v) == "" {
+			return "", ErrInvalidEmailFormat
+		}
+	}
+	if from == "" {
+		return "", ErrNoFromHeader
+	}
+	domains, err := header.ParseAddressListDomains(from)
+	if err != nil {
+		return "", err
+	}
+	domain := strings.ToLower(strings.TrimSuffix(domains[0], "."))
+	for _, d := range domains[1:] {
+		if !strings.EqualFold(strings.TrimSuffix(d, "."), domain) {
+			return "", fmt.Errorf("%w: %s, %s", ErrMultipleFromDomains, domain, strings.ToLower(d))
+		}
+	}
+	return domain, nil
+}
+
+// FromDomains はすべてのFromヘッダに含まれるアドレスのドメインを、重複を除いて現れた順に返す
+// FromDomainと異なり、Fromヘッダが複数ある場合や異なるドメインのアドレスが複数ある場合もエラーにしない
+// このようなメッセージは正規のFromを装うために使われることがあるため、
+// DMARCではすべてのドメインを評価して最も厳しい結果を適用する (RFC 7489 6.6.1)
+func FromDomains(headers []string) ([]string, error) {
+	var ret []string
+	found := false
+	for _, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "From") {
+			continue
+		}
+		found = true
+		if strings.TrimSpace(v) == "" {
+			return nil, ErrInvalidEmailFormat
+		}
+		domains, err := header.ParseAddressListDomains(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range domains {
+			d = strings.ToLower(strings.TrimSuffix(d, "."))
+			if !containsString(ret, d) {
+				ret = append(ret, d)
+			}
+		}
+	}
+	if !found {
+		return nil, ErrNoFromHeader
+	}
+	return ret, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 // ヘッダリストから指定された複数のヘッダをDKIM署名順で抽出する
 func ExtractHeadersDKIM(headers []string, keys []string) []string {
 	return header.ExtractHeadersDKIM(headers, keys)
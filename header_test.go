@@ -3,6 +3,8 @@ package mmauth
 import (
 	"bufio"
 	"crypto"
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -181,6 +183,21 @@ func Test_isCcanonicalizationBodyAndAlgorithm(t *testing.T) {
 			},
 			expect: false,
 		},
+		{
+			name: "different final crlf",
+			input: BodyCanonicalizationAndAlgorithm{
+				Body:      CanonicalizationSimple,
+				Algorithm: crypto.SHA256,
+				FinalCRLF: FinalCRLFAsIs,
+			},
+			can: []BodyCanonicalizationAndAlgorithm{
+				{
+					Body:      CanonicalizationSimple,
+					Algorithm: crypto.SHA256,
+				},
+			},
+			expect: false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -258,3 +275,59 @@ func Test_parseHeaderCanonicalization(t *testing.T) {
 		})
 	}
 }
+
+func TestFromDomain(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers []string
+		want    string
+		wantErr error
+	}{
+		{name: "single", headers: []string{"To: a@example.org\r\n", "From: John <John@Example.COM>\r\n"}, want: "example.com"},
+		{name: "same domain", headers: []string{"From: a@example.com, B <b@EXAMPLE.com>\r\n"}, want: "example.com"},
+		{name: "group", headers: []string{"From: Team: a@example.com, b@example.com;\r\n"}, want: "example.com"},
+		{name: "differing domains", headers: []string{"From: a@example.com, b@example.net\r\n"}, wantErr: ErrMultipleFromDomains},
+		{name: "multiple headers", headers: []string{"From: a@example.com\r\n", "from: a@example.com\r\n"}, wantErr: ErrMultipleFromHeaders},
+		{name: "no header", headers: []string{"To: a@example.org\r\n"}, wantErr: ErrNoFromHeader},
+		{name: "empty group", headers: []string{"From: undisclosed-recipients:;\r\n"}, wantErr: ErrInvalidEmailFormat},
+		{name: "empty value", headers: []string{"From: \r\n"}, wantErr: ErrInvalidEmailFormat},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := FromDomain(tc.headers)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("want %v, but got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFromDomains(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers []string
+		want    []string
+		wantErr error
+	}{
+		{name: "single", headers: []string{"From: John <John@Example.COM>\r\n"}, want: []string{"example.com"}},
+		{name: "same domain", headers: []string{"From: a@example.com, B <b@EXAMPLE.com>\r\n"}, want: []string{"example.com"}},
+		{name: "differing domains", headers: []string{"From: a@example.com, b@example.net\r\n"}, want: []string{"example.com", "example.net"}},
+		{name: "multiple headers", headers: []string{"From: a@example.net\r\n", "from: a@example.com\r\n"}, want: []string{"example.net", "example.com"}},
+		{name: "no header", headers: []string{"To: a@example.org\r\n"}, wantErr: ErrNoFromHeader},
+		{name: "invalid second header", headers: []string{"From: a@example.com\r\n", "From: \r\n"}, wantErr: ErrInvalidEmailFormat},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := FromDomains(tc.headers)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("want %v, but got %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want %v, but got %v", tc.want, got)
+			}
+		})
+	}
+}
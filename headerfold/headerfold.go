@@ -0,0 +1,221 @@
+// Package headerfold は生成するヘッダをRFC 5322 2.1.1 の行の長さの制限に収まるように折り返す
+//
+// 1行はCRLFを除いて998文字以下でなければならず(HardLimit)、78文字以下にすることが推奨される(SoftLimit)
+// 折り返しは空白(FWS)を入れてよい位置でのみ行うため、構造化されたヘッダの意味は変わらない
+package headerfold
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// SoftLimit はCRLFを除いた1行の長さの推奨値
+	SoftLimit = 78
+	// HardLimit はCRLFを除いた1行の長さの上限
+	HardLimit = 998
+	// DefaultIndent は折り返した行の先頭の空白
+	DefaultIndent = "        "
+)
+
+const crlf = "\r\n"
+
+// ErrLineTooLong は折り返せない部分があり、1行がHardLimitを超える場合のエラー
+var ErrLineTooLong = errors.New("headerfold: line exceeds hard limit")
+
+// Options は折り返しの設定
+type Options struct {
+	// SoftLimit はこの長さを超える前に折り返す。0以下の場合はSoftLimit
+	SoftLimit int
+	// HardLimit はCheckで許す1行の長さ。0以下の場合はHardLimit
+	HardLimit int
+	// Indent は折り返した行の先頭の空白。空の場合はDefaultIndent
+	Indent string
+}
+
+// DefaultOptions はoptsにnilを渡した場合の設定
+// dkim、arcパッケージが生成するヘッダはこの設定で折り返す
+var DefaultOptions = Options{
+	SoftLimit: SoftLimit,
+	HardLimit: HardLimit,
+	Indent:    DefaultIndent,
+}
+
+func (o *Options) normalize() Options {
+	if o == nil {
+		o = &DefaultOptions
+	}
+	n := *o
+	if n.SoftLimit <= 0 {
+		n.SoftLimit = SoftLimit
+	}
+	if n.HardLimit <= 0 {
+		n.HardLimit = HardLimit
+	}
+	if n.Indent == "" {
+		n.Indent = DefaultIndent
+	}
+	return n
+}
+
+// Folder は単語を詰めて行を組み立てる
+// 行の長さがSoftLimitを超える場合は、単語の前で改行してIndentから次の行を始める
+// 1つの単語がSoftLimitより長い場合は、その単語だけで1行になる
+type Folder struct {
+	opts  Options
+	name  string
+	lines []string
+	line  string
+}
+
+// NewFolder はstartから始まる行を組み立てるFolderを返す
+// startは値のうち折り返さない先頭部分
+func NewFolder(start string, opts *Options) *Folder {
+	return &Folder{opts: opts.normalize(), line: start}
+}
+
+// NewFieldFolder は"name:"から始まるヘッダを組み立てるFolderを返す
+// 最初の行の長さにヘッダ名を含めて折り返し、Valueでヘッダ名を除いた値を取り出す
+func NewFieldFolder(name string, opts *Options) *Folder {
+	return &Folder{opts: opts.normalize(), name: name, line: name + ":"}
+}
+
+// Word は空白で区切って単語を追加する。行に収まらない場合は改行してから追加する
+// 行が空白で終わっている場合は空白を付けない
+func (f *Folder) Word(w string) {
+	if f.line != f.opts.Indent && len(f.line)+1+len(w) > f.opts.SoftLimit {
+		f.Newline()
+	}
+	if n := len(f.line); n > 0 && f.line[n-1] != ' ' && f.line[n-1] != '\t' {
+		f.line += " "
+	}
+	f.line += w
+}
+
+// Append は空白を入れずに単語を続ける。行に収まらない場合は改行してから追加する
+// 改行した位置に空白が入るため、FWSを置ける位置の直前でのみ使う
+func (f *Folder) Append(s string) {
+	if f.line != f.opts.Indent && len(f.line)+len(s) > f.opts.SoftLimit {
+		f.Newline()
+	}
+	f.line += s
+}
+
+// Breakable は空白を入れずに続けて、行の残りに収まらない部分は次の行に送る
+// base64の値のように、どこにでもFWSを置ける値に使う
+func (f *Folder) Breakable(s string) {
+	for s != "" {
+		room := f.opts.SoftLimit - len(f.line)
+		if room <= 0 {
+			f.Newline()
+			continue
+		}
+		if room > len(s) {
+			room = len(s)
+		}
+		f.line += s[:room]
+		s = s[room:]
+	}
+}
+
+// Newline は改行してIndentから次の行を始める
+func (f *Folder) Newline() {
+	f.lines = append(f.lines, f.line)
+	f.line = f.opts.Indent
+}
+
+// String は組み立てた行をCRLFで結合して返す。末尾にCRLFは付けない
+func (f *Folder) String() string {
+	return strings.Join(append(f.lines[:len(f.lines):len(f.lines)], f.line), crlf)
+}
+
+// Value はNewFieldFolderで作ったFolderの組み立てた値を、ヘッダ名とその後の空白を除いて返す
+func (f *Folder) Value() string {
+	v := strings.TrimPrefix(f.String(), f.name+":")
+	return strings.TrimPrefix(v, " ")
+}
+
+// List はitemsをsepで区切って続け、行に収まらない場合はsepの後で折り返す
+// DKIM-Signatureのh=タグのように、区切り文字の前後にFWSを置ける値に使う
+func (f *Folder) List(items []string, sep string) {
+	for i, item := range items {
+		if i < len(items)-1 {
+			item += sep
+		}
+		f.Append(item)
+	}
+}
+
+// Fold はヘッダの値valueを空白の位置で折り返し、"name: value"の形式で返す。末尾にCRLFは付けない
+// 引用符で囲んだ文字列とコメントの中では折り返さず、それ以外の連続する空白は1つにまとめる
+// 1行がHardLimitを超える場合はErrLineTooLongを返す
+func Fold(name, value string, opts *Options) (string, error) {
+	f := NewFieldFolder(name, opts)
+	for _, w := range Fields(value) {
+		f.Word(w)
+	}
+	field := f.String()
+	if err := Check(field, opts); err != nil {
+		return "", err
+	}
+	return field, nil
+}
+
+// Fields はヘッダの値を折り返してよい空白の位置で分割する
+// 引用符で囲んだ文字列とコメントの中の空白では分割しない
+func Fields(value string) []string {
+	var fields []string
+	var b strings.Builder
+	quoted, depth, escaped := false, 0, false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || depth > 0):
+			escaped = true
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+		case (c == ' ' || c == '\t' || c == '\r' || c == '\n') && !quoted && depth == 0:
+			if b.Len() > 0 {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+// Check はfieldのどの行もHardLimitを超えていないかを確認する
+// fieldは折り返したヘッダで、末尾のCRLFはあってもなくてもよい
+func Check(field string, opts *Options) error {
+	o := opts.normalize()
+	for i, line := range strings.Split(strings.TrimSuffix(field, crlf), crlf) {
+		if len(line) > o.HardLimit {
+			return fmt.Errorf("%w: line %d is %d octets (limit %d)", ErrLineTooLong, i+1, len(line), o.HardLimit)
+		}
+	}
+	return nil
+}
+
+// WrapSignatureWithBreaks は署名を64文字ごとに改行しスペースを挿入する
+// "        b="に続けると、どの行もSoftLimitに収まる
+func WrapSignatureWithBreaks(s string) string {
+	var chunks []string
+	for 64 < len(s) {
+		chunks = append(chunks, s[:64])
+		s = s[64:]
+	}
+	chunks = append(chunks, s)
+	return strings.Join(chunks, "\r\n         ")
+}
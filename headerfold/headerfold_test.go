@@ -0,0 +1,132 @@
+package headerfold
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		value  string
+		opts   *Options
+		want   string
+		err    error
+	}{
+		{
+			name:   "short",
+			header: "Authentication-Results",
+			value:  "mx.example.jp; spf=pass",
+			want:   "Authentication-Results: mx.example.jp; spf=pass",
+		},
+		{
+			name:   "soft limit",
+			header: "Authentication-Results",
+			value:  "mx.example.jp; spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com header.s=selector",
+			want: "Authentication-Results: mx.example.jp; spf=pass smtp.mailfrom=example.com;\r\n" +
+				"        dkim=pass header.d=example.com header.s=selector",
+		},
+		{
+			name:  "configured",
+			value: "a b c d",
+			opts:  &Options{SoftLimit: 5, Indent: "\t"},
+			want:  "X: a\r\n\tb c\r\n\td",
+		},
+		{
+			name:  "quoted string and comment are not split",
+			value: `a=1 (a long comment) b="quoted  value"`,
+			opts:  &Options{SoftLimit: 10},
+			want:  "X: a=1\r\n        (a long comment)\r\n        b=\"quoted  value\"",
+		},
+		{
+			name:  "hard limit",
+			value: "a " + strings.Repeat("b", HardLimit),
+			err:   ErrLineTooLong,
+		},
+		{
+			name:  "configured hard limit",
+			value: "a " + strings.Repeat("b", 20),
+			opts:  &Options{HardLimit: 20},
+			err:   ErrLineTooLong,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := tc.header
+			if header == "" {
+				header = "X"
+			}
+			got, err := Fold(header, tc.value, tc.opts)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("want error %v, but got %v", tc.err, err)
+			}
+			if got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFolderList(t *testing.T) {
+	var names []string
+	for i := 0; i < 12; i++ {
+		names = append(names, "X-Header-Name")
+	}
+	f := NewFieldFolder("DKIM-Signature", nil)
+	f.Word("v=1;")
+	f.Newline()
+	f.Append("h=")
+	f.List(append(names, "From;"), ":")
+
+	got := f.Value()
+	want := "v=1;\r\n" +
+		"        h=X-Header-Name:X-Header-Name:X-Header-Name:X-Header-Name:\r\n" +
+		"        X-Header-Name:X-Header-Name:X-Header-Name:X-Header-Name:X-Header-Name:\r\n" +
+		"        X-Header-Name:X-Header-Name:X-Header-Name:From;"
+	if got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > SoftLimit {
+			t.Errorf("want at most %d characters, but got %q", SoftLimit, line)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	got := Fields("  a=1 \r\n\t(x (y) \\) z)  b=\"c \\\" d\";")
+	want := []string{"a=1", "(x (y) \\) z)", "b=\"c \\\" d\";"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ok := "X: " + strings.Repeat("a", HardLimit-3) + "\r\n " + strings.Repeat("b", HardLimit-1) + "\r\n"
+	if err := Check(ok, nil); err != nil {
+		t.Errorf("want nil, but got %v", err)
+	}
+	if err := Check(ok+" c", nil); err != nil {
+		t.Errorf("want nil, but got %v", err)
+	}
+	ng := "X: a\r\n " + strings.Repeat("b", HardLimit)
+	if err := Check(ng, nil); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("want %v, but got %v", ErrLineTooLong, err)
+	}
+}
+
+func TestWrapSignatureWithBreaks(t *testing.T) {
+	sig := strings.Repeat("A", 344) // 2048ビットのRSA署名
+	got := "        b=" + WrapSignatureWithBreaks(sig)
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > SoftLimit {
+			t.Errorf("want at most %d characters, but got %q", SoftLimit, line)
+		}
+	}
+	if s := strings.NewReplacer("\r\n", "", " ", "").Replace(got); s != "b="+sig {
+		t.Errorf("want b=%s, but got %s", sig, s)
+	}
+}
@@ -0,0 +1,233 @@
+package mmauth
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/authres"
+)
+
+// Hop は1つの中継で記録されたReceivedと認証結果のヘッダ
+type Hop struct {
+	// Received はこの中継のReceivedヘッダ
+	// どのReceivedより下にある認証結果のヘッダをまとめたHopでは空
+	Received string
+	// By はReceivedのby句のホスト名(小文字、末尾のドットを除く)
+	By string
+	// AuthenticationResults はこの中継のAuthentication-Results(ヘッダの上から順)
+	AuthenticationResults []*authres.AuthenticationResults
+	// ReceivedSPF はこの中継のReceived-SPF(ヘッダの上から順)
+	ReceivedSPF []*authres.ReceivedSPF
+}
+
+// HopConflict は同じ中継で同じ対象について記録された結果の食い違い
+type HopConflict struct {
+	Method authres.Method
+	// Results は食い違っている結果で、先頭がReconcileで採用したもの
+	Results []*authres.ResultInfo
+}
+
+// CollectHops はヘッダからReceived、Authentication-Results、Received-SPFを集め、中継ごとにまとめる
+// 戻り値は最も新しい(ヘッダの一番上の)中継から順に並ぶ
+//
+// 認証結果のヘッダは次の順で中継に対応付ける
+//   - Authentication-Resultsのauthserv-id、Received-SPFのreceiver=がReceivedのby句のホストと一致する場合は、
+//     そのヘッダより下にある最も近い一致するReceived
+//   - 一致しない場合は、そのヘッダより下にある最も近いReceived
+//     (中継はReceivedを付けた後で、その上に認証結果を付けるため)
+//
+// authserv-idやreceiver=が一致するReceivedがそのヘッダより上にしかない場合は、
+// 中継より前に送信者などが付けた偽の結果として含めない (RFC 8601 5)
+// 解析できない認証結果のヘッダも含めない
+func CollectHops(headers []string) []*Hop {
+	type pending struct {
+		pos  int
+		id   string
+		ar   *authres.AuthenticationResults
+		rspf *authres.ReceivedSPF
+	}
+	var hops []*Hop
+	var hopPos []int
+	var results []pending
+	for i, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "received":
+			hops = append(hops, &Hop{Received: h, By: receivedBy(v)})
+			hopPos = append(hopPos, i)
+		case "authentication-results":
+			if ar, err := authres.ParseAuthenticationResults(v); err == nil {
+				results = append(results, pending{pos: i, id: ar.AuthServID, ar: ar})
+			}
+		case "received-spf":
+			if r, err := authres.ParseReceivedSPF(v); err == nil {
+				results = append(results, pending{pos: i, id: r.Receiver, rspf: r})
+			}
+		}
+	}
+
+	var orphan *Hop
+	for _, p := range results {
+		hop, ok := matchHop(hops, hopPos, p.pos, p.id)
+		if !ok {
+			continue
+		}
+		if hop == nil {
+			if orphan == nil {
+				orphan = &Hop{}
+			}
+			hop = orphan
+		}
+		if p.ar != nil {
+			hop.AuthenticationResults = append(hop.AuthenticationResults, p.ar)
+		} else {
+			hop.ReceivedSPF = append(hop.ReceivedSPF, p.rspf)
+		}
+	}
+	if orphan != nil {
+		hops = append(hops, orphan)
+	}
+	return hops
+}
+
+// posにある認証結果のヘッダを付けた中継を探す
+// どのReceivedより下にある場合はnilを返し、偽の結果として除く場合はfalseを返す
+func matchHop(hops []*Hop, hopPos []int, pos int, id string) (*Hop, bool) {
+	id = normalizeHost(id)
+	if id != "" {
+		for i := range hops {
+			if hopPos[i] > pos && hops[i].By == id {
+				return hops[i], true
+			}
+		}
+		for i := range hops {
+			if hopPos[i] < pos && hops[i].By == id {
+				return nil, false
+			}
+		}
+	}
+	for i := range hops {
+		if hopPos[i] > pos {
+			return hops[i], true
+		}
+	}
+	return nil, true
+}
+
+// FindHop はauthserv-idまたはreceiver=がauthservIDと一致する認証結果を持つ最も新しい中継を返す
+// ARCで封をする際に、自身が付けた認証結果からARC-Authentication-Resultsを作るために使う
+// 見つからない場合はnilを返す
+func FindHop(hops []*Hop, authservID string) *Hop {
+	id := normalizeHost(authservID)
+	for _, h := range hops {
+		for _, ar := range h.AuthenticationResults {
+			if normalizeHost(ar.AuthServID) == id {
+				return h
+			}
+		}
+		for _, r := range h.ReceivedSPF {
+			if normalizeHost(r.Receiver) == id {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// Reconcile は中継の認証結果をまとめ、同じ対象の重複を除いた結果を返す
+// Authentication-Resultsの結果をヘッダの上から順に並べ、Received-SPFの結果をその後に続ける
+// 同じ認証方式で同じ対象(spfはsmtp.mailfromまたはsmtp.helo、dkimはheader.dとheader.s、
+// dmarcはheader.from)の結果が複数ある場合は最初のものを採用し、結果が異なる場合はHopConflictとして返す
+// Authentication-ResultsをReceived-SPFより優先するのは、Authentication-Resultsの方がより多くの情報を持つため
+//
+// 戻り値の結果はarc.NewARCAuthenticationResultsにそのまま渡せる
+func (h *Hop) Reconcile() ([]*authres.ResultInfo, []HopConflict) {
+	var all []*authres.ResultInfo
+	for _, ar := range h.AuthenticationResults {
+		all = append(all, ar.Results...)
+	}
+	for _, r := range h.ReceivedSPF {
+		all = append(all, r.ResultInfo())
+	}
+
+	var ret []*authres.ResultInfo
+	var conflicts []HopConflict
+	index := make(map[string]int)
+	conflictIndex := make(map[string]int)
+	for _, ri := range all {
+		key := resultKey(ri)
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(ret)
+			ret = append(ret, ri)
+			continue
+		}
+		if ret[i].Result == ri.Result {
+			continue
+		}
+		if c, ok := conflictIndex[key]; ok {
+			conflicts[c].Results = append(conflicts[c].Results, ri)
+			continue
+		}
+		conflictIndex[key] = len(conflicts)
+		conflicts = append(conflicts, HopConflict{Method: ri.Method, Results: []*authres.ResultInfo{ret[i], ri}})
+	}
+	return ret, conflicts
+}
+
+// 同じ対象の結果かを判定するためのキー
+func resultKey(ri *authres.ResultInfo) string {
+	prop := func(t authres.PropertyType, name string) string {
+		v, _ := ri.Property(t, name)
+		return strings.ToLower(v)
+	}
+	key := string(ri.Method)
+	switch ri.Method {
+	case authres.MethodSPF:
+		if v := prop(authres.PropertyTypeSMTP, "mailfrom"); v != "" {
+			return key + " mailfrom " + v
+		}
+		return key + " helo " + prop(authres.PropertyTypeSMTP, "helo")
+	case authres.MethodDKIM:
+		return key + " " + prop(authres.PropertyTypeHeader, "d") + " " + prop(authres.PropertyTypeHeader, "s")
+	case authres.MethodDMARC:
+		return key + " " + prop(authres.PropertyTypeHeader, "from")
+	}
+	return key
+}
+
+// Receivedの値からby句のホスト名を取り出す
+func receivedBy(v string) string {
+	// 日時の前のセミコロンまでが received-token
+	if i := strings.LastIndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\\' && depth > 0:
+			i++
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+			b.WriteByte(' ')
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	fields := strings.Fields(b.String())
+	for i := 0; i+1 < len(fields); i++ {
+		if strings.EqualFold(fields[i], "by") {
+			return normalizeHost(fields[i+1])
+		}
+	}
+	return ""
+}
+
+func normalizeHost(s string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "."))
+}
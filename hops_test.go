@@ -0,0 +1,99 @@
+package mmauth
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/masa23/mmauth/authres"
+)
+
+func TestCollectHops(t *testing.T) {
+	headers := []string{
+		"Authentication-Results: mx.example.jp; spf=pass smtp.mailfrom=user@example.com; dkim=pass header.d=example.com header.s=sel\r\n",
+		"Received-SPF: fail receiver=mx.example.jp; envelope-from=user@example.com;\r\n",
+		"Authentication-Results: mx.example.jp; dkim=pass header.d=example.com header.s=sel; dmarc=pass header.from=example.com\r\n",
+		"Received: from relay.example.net (relay.example.net [192.0.2.1])\r\n\tby MX.Example.JP. (Postfix) with ESMTPS id 1; Mon, 1 Jan 2024 00:00:01 +0900\r\n",
+		"Authentication-Results: relay.example.net; spf=softfail smtp.mailfrom=user@example.com\r\n",
+		"Received: from client (unknown [198.51.100.1])\r\n\tby relay.example.net with ESMTP; Mon, 1 Jan 2024 00:00:00 +0900\r\n",
+		"Authentication-Results: relay.example.net; dkim=pass header.d=example.com header.s=sel\r\n",
+		"Authentication-Results: mx.example.jp; dkim=pass header.d=bank.example header.s=sel; dmarc=pass header.from=bank.example\r\n",
+		"From: user@example.com\r\n",
+		"Authentication-Results: unknown.example; none\r\n",
+	}
+	hops := CollectHops(headers)
+	if len(hops) != 3 {
+		t.Fatalf("want 3 hops, but got %d", len(hops))
+	}
+
+	wantBy := []string{"mx.example.jp", "relay.example.net", ""}
+	wantAR := []int{2, 1, 1}
+	wantSPF := []int{1, 0, 0}
+	for i, hop := range hops {
+		if hop.By != wantBy[i] {
+			t.Errorf("hop %d: want by %s, but got %s", i, wantBy[i], hop.By)
+		}
+		if len(hop.AuthenticationResults) != wantAR[i] || len(hop.ReceivedSPF) != wantSPF[i] {
+			t.Errorf("hop %d: want %d A-R and %d Received-SPF, but got %d and %d",
+				i, wantAR[i], wantSPF[i], len(hop.AuthenticationResults), len(hop.ReceivedSPF))
+		}
+	}
+	// authserv-idが一致するReceivedより下にあるA-Rは偽の結果として除く
+	for _, hop := range hops {
+		for _, ar := range hop.AuthenticationResults {
+			for _, ri := range ar.Results {
+				if from, _ := ri.Property(authres.PropertyTypeHeader, "from"); from == "bank.example" {
+					t.Errorf("want the forged result to be dropped, but got %s in %s", ri, hop.By)
+				}
+			}
+		}
+	}
+
+	if got := FindHop(hops, "mx.example.jp."); got != hops[0] {
+		t.Errorf("want the first hop, but got %+v", got)
+	}
+	if got := FindHop(hops, "other.example"); got != nil {
+		t.Errorf("want nil, but got %+v", got)
+	}
+
+	results, conflicts := hops[0].Reconcile()
+	var got []string
+	for _, ri := range results {
+		got = append(got, ri.String())
+	}
+	want := []string{
+		"spf=pass smtp.mailfrom=user@example.com",
+		"dkim=pass header.d=example.com header.s=sel",
+		"dmarc=pass header.from=example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, but got %v", want, got)
+	}
+	if len(conflicts) != 1 || conflicts[0].Method != authres.MethodSPF || len(conflicts[0].Results) != 2 ||
+		conflicts[0].Results[0].Result != authres.ResultPass || conflicts[0].Results[1].Result != authres.ResultFail {
+		t.Errorf("want a spf conflict between pass and fail, but got %+v", conflicts)
+	}
+
+	results, conflicts = hops[1].Reconcile()
+	if len(results) != 1 || len(conflicts) != 0 {
+		t.Errorf("want 1 result without conflicts, but got %v %+v", results, conflicts)
+	}
+}
+
+func TestReceivedBy(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "simple", value: " from a by mx.example.jp with ESMTP; Mon, 1 Jan 2024 00:00:00 +0900", want: "mx.example.jp"},
+		{name: "comment with by", value: " from a (helo by fake.example) by MX.example.jp.; date", want: "mx.example.jp"},
+		{name: "no by", value: " from a with local; date", want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := receivedBy(tc.value); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
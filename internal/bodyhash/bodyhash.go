@@ -6,9 +6,11 @@ import (
 	"crypto"
 	_ "crypto/sha1"   // sha1を使う
 	_ "crypto/sha256" // sha256を使う
+	"crypto/subtle"
 	"encoding/base64"
 	"hash"
 	"io"
+	"sync"
 
 	"github.com/masa23/mmauth/internal/canonical"
 )
@@ -17,6 +19,8 @@ type BodyHash struct {
 	hashAlgo crypto.Hash
 	w        io.WriteCloser
 	hasher   hash.Hash
+	pool     *Pool              // Pool.NewBodyHashで作成した場合のプール
+	scratch  *canonical.Scratch // プールから取得した正規化の作業領域
 }
 
 // メール本文の書き込みを行う
@@ -37,8 +41,21 @@ func (b *BodyHash) Get() string {
 	return base64.StdEncoding.EncodeToString(hash)
 }
 
+// Equal はbh=の値と計算したボディーハッシュを一定時間で比較する
+// どちらも受信したメッセージから得られる値で秘密ではないが、比較にかかる時間から
+// 一致した先頭の長さを推測されることがないよう一定時間で比較しておく(多層防御)
+// 長さが異なる場合は内容を比較せずにfalseを返す
+func Equal(bh, computed string) bool {
+	return subtle.ConstantTimeCompare([]byte(bh), []byte(computed)) == 1
+}
+
 // Canonicalizationとハッシュアルゴリズムを指定してBodyHasherを生成する
 func NewBodyHash(canon canonical.Canonicalization, hashAlgo crypto.Hash, limit int64) *BodyHash {
+	return NewBodyHashWithFinalCRLF(canon, hashAlgo, limit, canonical.FinalCRLFPad)
+}
+
+// 最後の行の改行の扱いを指定してBodyHasherを生成する
+func NewBodyHashWithFinalCRLF(canon canonical.Canonicalization, hashAlgo crypto.Hash, limit int64, finalCRLF canonical.FinalCRLF) *BodyHash {
 	if limit < 0 {
 		limit = 0
 	}
@@ -55,14 +72,88 @@ func NewBodyHash(canon canonical.Canonicalization, hashAlgo crypto.Hash, limit i
 		writer = newLimitWriter(writer, limit)
 	}
 
-	switch canon {
-	case canonical.Simple:
-		bh.w = canonical.SimpleBody(writer)
-	case canonical.Relaxed:
-		bh.w = canonical.RelaxedBody(writer)
-	default:
-		// 指定が不明の場合はSimpleを使う
-		bh.w = canonical.SimpleBody(writer)
+	// 指定が不明の場合はSimpleを使う
+	bh.w = canonical.BodyWithFinalCRLF(writer, canon, finalCRLF)
+	return bh
+}
+
+// DefaultMaxPooledScratch はPoolに戻す正規化の作業領域の容量の上限(バイト)
+// 大きな本文で広がった作業領域を保持し続けないよう、これを超えるものは捨てる
+const DefaultMaxPooledScratch = 4 << 20
+
+// Pool はボディーハッシュの計算に使うハッシュの状態と正規化の作業領域を再利用する
+// 多数のメッセージを続けて検証する場合に、メッセージごとの割り当てを減らすために使う
+// 複数のゴルーチンから同時に使える
+type Pool struct {
+	// MaxScratch は戻す作業領域の容量の上限。0以下の場合はDefaultMaxPooledScratch
+	MaxScratch int
+
+	hashers sync.Map // crypto.Hash -> *sync.Pool
+	scratch sync.Pool
+}
+
+// Hash はhashAlgoのリセット済みのハッシュの状態を返す
+// 使い終わったらPutHashで戻す
+func (p *Pool) Hash(hashAlgo crypto.Hash) hash.Hash {
+	v, _ := p.hashers.LoadOrStore(hashAlgo, &sync.Pool{})
+	if h, ok := v.(*sync.Pool).Get().(hash.Hash); ok {
+		h.Reset()
+		return h
+	}
+	return hashAlgo.New()
+}
+
+// PutHash はHashで取得したハッシュの状態を戻す
+func (p *Pool) PutHash(hashAlgo crypto.Hash, h hash.Hash) {
+	v, _ := p.hashers.LoadOrStore(hashAlgo, &sync.Pool{})
+	v.(*sync.Pool).Put(h)
+}
+
+func (p *Pool) getScratch() *canonical.Scratch {
+	if s, ok := p.scratch.Get().(*canonical.Scratch); ok {
+		return s
+	}
+	return &canonical.Scratch{}
+}
+
+func (p *Pool) putScratch(s *canonical.Scratch) {
+	max := p.MaxScratch
+	if max <= 0 {
+		max = DefaultMaxPooledScratch
+	}
+	if s.Cap() > max {
+		return
 	}
+	p.scratch.Put(s)
+}
+
+// NewBodyHash はプールのハッシュの状態と作業領域を使うBodyHashを返す
+// CloseとGetで値を取り出した後にReleaseで戻す
+func (p *Pool) NewBodyHash(canon canonical.Canonicalization, hashAlgo crypto.Hash, limit int64, finalCRLF canonical.FinalCRLF) *BodyHash {
+	if limit < 0 {
+		limit = 0
+	}
+	bh := &BodyHash{
+		hashAlgo: hashAlgo,
+		hasher:   p.Hash(hashAlgo),
+		pool:     p,
+		scratch:  p.getScratch(),
+	}
+	var writer io.Writer = bh.hasher
+	if limit > 0 {
+		writer = newLimitWriter(writer, limit)
+	}
+	bh.w = canonical.BodyWithScratch(writer, canon, finalCRLF, bh.scratch)
 	return bh
 }
+
+// Release はPool.NewBodyHashで作成したBodyHashのハッシュの状態と作業領域をプールに戻す
+// Release の後はBodyHashを使えない。プールを使わずに作成したBodyHashでは何もしない
+func (b *BodyHash) Release() {
+	if b.pool == nil {
+		return
+	}
+	b.pool.PutHash(b.hashAlgo, b.hasher)
+	b.pool.putScratch(b.scratch)
+	b.pool, b.hasher, b.scratch, b.w = nil, nil, nil, nil
+}
@@ -2,6 +2,7 @@ package bodyhash
 
 import (
 	"crypto"
+	"strings"
 	"testing"
 
 	"github.com/masa23/mmauth/internal/canonical"
@@ -170,3 +171,54 @@ func TestBodyHash_Empty(t *testing.T) {
 		})
 	}
 }
+
+func TestEqual(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bh       string
+		computed string
+		want     bool
+	}{
+		{name: "equal", bh: "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=", computed: "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=", want: true},
+		{name: "different", bh: "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=", computed: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", want: false},
+		{name: "prefix", bh: "frcCV1k9", computed: "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=", want: false},
+		{name: "empty", bh: "", computed: "", want: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Equal(tc.bh, tc.computed); got != tc.want {
+				t.Errorf("want %v, but got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPool(t *testing.T) {
+	bodies := []string{
+		"hoge  \r\ntest\r\n  \r\n",
+		strings.Repeat("fuga \t fuga\r\n\r\n", 500),
+		"",
+		"\r\ntest",
+	}
+	p := &Pool{}
+	// 同じプールで正規化方法と本文を入れ替えながら、作業領域を再利用しても結果が変わらないこと
+	for round := 0; round < 2; round++ {
+		for _, canon := range []canonical.Canonicalization{canonical.Simple, canonical.Relaxed} {
+			for _, finalCRLF := range []canonical.FinalCRLF{canonical.FinalCRLFPad, canonical.FinalCRLFAsIs} {
+				for _, body := range bodies {
+					want := NewBodyHashWithFinalCRLF(canon, crypto.SHA256, 0, finalCRLF)
+					want.Write([]byte(body))
+					want.Close()
+
+					got := p.NewBodyHash(canon, crypto.SHA256, 0, finalCRLF)
+					got.Write([]byte(body))
+					got.Close()
+					if got.Get() != want.Get() {
+						t.Errorf("%s/%s %q: want %s, but got %s", canon, finalCRLF, body, want.Get(), got.Get())
+					}
+					got.Release()
+				}
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package canonical
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -16,6 +17,37 @@ const (
 	Relaxed Canonicalization = "relaxed"
 )
 
+// c=タグの値(relaxed/simpleなど)をパースしてヘッダと本文の正規化方式を返す関数です。
+// 空の場合はsimple/simple、一つだけ指定された場合はヘッダに適用し本文はsimpleになります。
+// simpleとrelaxed以外の方式や、"/"で3つ以上に区切られた値はエラーになります。
+func Parse(s string) (header Canonicalization, body Canonicalization, err error) {
+	if s == "" {
+		return Simple, Simple, nil
+	}
+	ret := strings.Split(s, "/")
+	if len(ret) > 2 {
+		return "", "", fmt.Errorf("invalid canonicalization: %s", s)
+	}
+	if header, err = parseOne(ret[0]); err != nil {
+		return "", "", err
+	}
+	if len(ret) == 1 {
+		return header, Simple, nil
+	}
+	if body, err = parseOne(ret[1]); err != nil {
+		return "", "", err
+	}
+	return header, body, nil
+}
+
+func parseOne(s string) (Canonicalization, error) {
+	switch c := Canonicalization(s); c {
+	case Simple, Relaxed:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid canonicalization: %s", s)
+}
+
 // ヘッダのシンプル正規化を行う関数です。
 func SimpleHeader(s string) string {
 	return s
@@ -61,7 +93,11 @@ type crlfFixer struct {
 }
 
 func (cf *crlfFixer) Fix(b []byte) []byte {
-	res := make([]byte, 0, len(b))
+	return cf.fixAppend(make([]byte, 0, len(b)), b)
+}
+
+// fixAppend は b の改行を CRLF にそろえて dst に追加します。
+func (cf *crlfFixer) fixAppend(dst, b []byte) []byte {
 	for _, ch := range b {
 		prevCR := cf.cr
 		cf.cr = false
@@ -70,12 +106,12 @@ func (cf *crlfFixer) Fix(b []byte) []byte {
 			cf.cr = true
 		case '\n':
 			if !prevCR {
-				res = append(res, '\r')
+				dst = append(dst, '\r')
 			}
 		}
-		res = append(res, ch)
+		dst = append(dst, ch)
 	}
-	return res
+	return dst
 }
 
 // ヘッダの正規化を行う関数です。
@@ -92,21 +128,63 @@ func Header(s string, canonical Canonicalization) string {
 	return result
 }
 
+// FinalCRLF は最後の行が改行で終わらない本文の正規化での扱いです。
+// RFC 6376 3.4.3・3.4.4 では改行を補いますが、補わずにハッシュを計算する実装があり、
+// 相互運用でbh=が一致しない原因としてよく見られます。
+type FinalCRLF int
+
+const (
+	// FinalCRLFPad は RFC 6376 に従い最後の行に CRLF を補います。
+	FinalCRLFPad FinalCRLF = iota
+	// FinalCRLFAsIs は最後の行に CRLF を補いません。
+	// 補わずに署名する実装と相互運用するための標準外の動作です。
+	// 本文が空の場合や末尾の空行を除去した場合は FinalCRLFPad と同じになります。
+	FinalCRLFAsIs
+)
+
+func (f FinalCRLF) String() string {
+	switch f {
+	case FinalCRLFPad:
+		return "pad"
+	case FinalCRLFAsIs:
+		return "as-is"
+	}
+	return fmt.Sprintf("FinalCRLF(%d)", int(f))
+}
+
+// Scratch は本文の正規化で使う作業領域です。
+// 正規化のたびに本文の大きさの領域を割り当てずに済むよう、BodyWithScratch に渡して再利用します。
+// 1つの Scratch を同時に複数の正規化で使うことはできません。
+type Scratch struct {
+	buf   []byte // 書き込まれた本文
+	fixed []byte // 改行を CRLF にそろえた本文
+	out   []byte // 正規化した本文(relaxed)
+}
+
+// Cap は作業領域が確保している容量の合計(バイト)を返します。
+// 大きな本文で広がった作業領域を再利用せずに捨てる判断に使います。
+func (s *Scratch) Cap() int {
+	return cap(s.buf) + cap(s.fixed) + cap(s.out)
+}
+
 type simpleBodyCanonicalizer struct {
 	w         io.Writer
-	buf       []byte
+	scratch   *Scratch
 	crlfFixer crlfFixer
+	finalCRLF FinalCRLF
 }
 
 func (c *simpleBodyCanonicalizer) Write(b []byte) (int, error) {
 	// buf にデータを追加
-	c.buf = append(c.buf, b...)
+	c.scratch.buf = append(c.scratch.buf, b...)
 	return len(b), nil
 }
 
 func (c *simpleBodyCanonicalizer) Close() error {
 	// CRLF を修正
-	fixed := c.crlfFixer.Fix(c.buf)
+	fixed := c.crlfFixer.fixAppend(c.scratch.fixed[:0], c.scratch.buf)
+	c.scratch.fixed = fixed
+	unterminated := len(fixed) > 0 && !bytes.HasSuffix(fixed, []byte(crlf))
 
 	// 末尾の空行を削除
 	for len(fixed) >= 2 && fixed[len(fixed)-2] == '\r' && fixed[len(fixed)-1] == '\n' {
@@ -114,7 +192,11 @@ func (c *simpleBodyCanonicalizer) Close() error {
 	}
 
 	// 末尾に CRLF を追加
-	fixed = append(fixed, []byte(crlf)...)
+	// FinalCRLFAsIs の場合は改行で終わらない最後の行にだけ補わない
+	if !unterminated || c.finalCRLF != FinalCRLFAsIs {
+		fixed = append(fixed, []byte(crlf)...)
+	}
+	c.scratch.fixed = fixed[:0]
 
 	// データを書き込む
 	if _, err := c.w.Write(fixed); err != nil {
@@ -126,72 +208,87 @@ func (c *simpleBodyCanonicalizer) Close() error {
 
 // ボディをシンプル正規化する関数です。
 func SimpleBody(w io.Writer) io.WriteCloser {
-	return &simpleBodyCanonicalizer{w: w}
+	return &simpleBodyCanonicalizer{w: w, scratch: &Scratch{}}
 }
 
 type relaxedBodyCanonicalizer struct {
 	w         io.Writer
-	buf       []byte
+	scratch   *Scratch
 	crlfFixer crlfFixer
+	finalCRLF FinalCRLF
 }
 
 func (c *relaxedBodyCanonicalizer) Write(b []byte) (int, error) {
 	// buf にデータを追加
-	c.buf = append(c.buf, b...)
+	c.scratch.buf = append(c.scratch.buf, b...)
 	return len(b), nil
 }
 
 func (c *relaxedBodyCanonicalizer) Close() error {
 	// CRLF を修正
-	fixed := c.crlfFixer.Fix(c.buf)
+	fixed := c.crlfFixer.fixAppend(c.scratch.fixed[:0], c.scratch.buf)
+	c.scratch.fixed = fixed
+	unterminated := len(fixed) > 0 && !bytes.HasSuffix(fixed, []byte(crlf))
 
-	// バイトスライスを\r\nで分割して行のスライスを作成
-	lines := bytes.Split(fixed, []byte("\r\n"))
+	// 各行を処理（RFC 6376 Section 3.4.4 ステップ a）し、行ごとに CRLF を付けて out に追加する
+	// 空行はその後に空でない行が続く場合にだけ出力するため、数だけ数えておく
+	// （最後の空行を削除: RFC 6376 Section 3.4.4 ステップ b）
+	// 空行とは、行終端子を除去した後に長さがゼロの行のこと（RFC 6376 Section 3.4.3）
+	// 正規化した本文は元の本文に末尾の CRLF を足した長さを超えないので、まとめて確保する
+	out := c.scratch.out[:0]
+	if cap(out) < len(fixed)+len(crlf) {
+		out = make([]byte, 0, len(fixed)+len(crlf))
+	}
+	emptyLines := 0
+	lastEmpty := false
+	for rest := fixed; ; {
+		line := rest
+		i := bytes.Index(rest, []byte(crlf))
+		if i >= 0 {
+			line, rest = rest[:i], rest[i+2:]
+		}
 
-	// 各行を処理（RFC 6376 Section 3.4.4 ステップ a）
-	var canonical [][]byte
-	for _, line := range lines {
 		// 行末の空白を削除
 		for len(line) > 0 && (line[len(line)-1] == ' ' || line[len(line)-1] == '\t') {
 			line = line[:len(line)-1]
 		}
-
-		// 行内の連続する空白を単一のスペースに圧縮
-		var compressedLine []byte
-		wsp := false
-		for _, ch := range line {
-			if ch == ' ' || ch == '\t' {
-				if !wsp {
-					compressedLine = append(compressedLine, ' ')
-					wsp = true
+		lastEmpty = len(line) == 0
+		if lastEmpty {
+			emptyLines++
+		} else {
+			for ; emptyLines > 0; emptyLines-- {
+				out = append(out, crlf...)
+			}
+			// 行内の連続する空白を単一のスペースに圧縮
+			wsp := false
+			for _, ch := range line {
+				if ch == ' ' || ch == '\t' {
+					if !wsp {
+						out = append(out, ' ')
+						wsp = true
+					}
+				} else {
+					out = append(out, ch)
+					wsp = false
 				}
-			} else {
-				compressedLine = append(compressedLine, ch)
-				wsp = false
 			}
+			out = append(out, crlf...)
+		}
+		if i < 0 {
+			break
 		}
-
-		canonical = append(canonical, compressedLine)
-	}
-
-	// 最後の空行を削除（RFC 6376 Section 3.4.4 ステップ b）
-	// 空行とは、行終端子を除去した後に長さがゼロの行のこと（RFC 6376 Section 3.4.3）
-	for len(canonical) > 0 && len(canonical[len(canonical)-1]) == 0 {
-		canonical = canonical[:len(canonical)-1]
 	}
+	c.scratch.out = out[:0]
 
-	// 結果を結合
-	var result []byte
-	if len(canonical) > 0 {
-		result = bytes.Join(canonical, []byte("\r\n"))
-		// RFC 6376 Section 3.4.4: 空でない body の場合は末尾に CRLF を追加
-		result = append(result, []byte("\r\n")...)
+	// RFC 6376 Section 3.4.4: 空でない body の場合は末尾に CRLF を追加
+	// 改行で終わらない最後の行が残っている場合だけ FinalCRLFAsIs で CRLF を補わない
+	if unterminated && c.finalCRLF == FinalCRLFAsIs && !lastEmpty {
+		out = out[:len(out)-2]
 	}
-	// 空の body の場合は何も書き込まない (0 バイト) - RFC 6376 Section 3.4.4 に従う
 
-	// データを書き込む (空の body の場合は何も書き込まない)
-	if len(result) > 0 {
-		if _, err := c.w.Write(result); err != nil {
+	// データを書き込む (空の body の場合は何も書き込まない - RFC 6376 Section 3.4.4 に従う)
+	if len(out) > 0 {
+		if _, err := c.w.Write(out); err != nil {
 			return err
 		}
 	}
@@ -201,7 +298,7 @@ func (c *relaxedBodyCanonicalizer) Close() error {
 
 // ボディをリラックス正規化する関数です。
 func RelaxedBody(w io.Writer) io.WriteCloser {
-	return &relaxedBodyCanonicalizer{w: w}
+	return &relaxedBodyCanonicalizer{w: w, scratch: &Scratch{}}
 }
 
 // ボディの正規化を行う関数です。
@@ -215,3 +312,22 @@ func Body(w io.Writer, canonical Canonicalization) io.WriteCloser {
 		return SimpleBody(w)
 	}
 }
+
+// 最後の行の改行の扱いを指定してボディの正規化を行う関数です。
+func BodyWithFinalCRLF(w io.Writer, canonical Canonicalization, finalCRLF FinalCRLF) io.WriteCloser {
+	return BodyWithScratch(w, canonical, finalCRLF, nil)
+}
+
+// 作業領域を再利用してボディの正規化を行う関数です。
+// scratch が nil の場合は新しい作業領域を使います。
+// Close の後は scratch を別の正規化に使えます。
+func BodyWithScratch(w io.Writer, canonical Canonicalization, finalCRLF FinalCRLF, scratch *Scratch) io.WriteCloser {
+	if scratch == nil {
+		scratch = &Scratch{}
+	}
+	scratch.buf = scratch.buf[:0]
+	if canonical == Relaxed {
+		return &relaxedBodyCanonicalizer{w: w, scratch: scratch, finalCRLF: finalCRLF}
+	}
+	return &simpleBodyCanonicalizer{w: w, scratch: scratch, finalCRLF: finalCRLF}
+}
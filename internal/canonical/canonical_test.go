@@ -379,3 +379,65 @@ func TestRelaxedBody(t *testing.T) {
 		})
 	}
 }
+
+func TestBodyWithFinalCRLF(t *testing.T) {
+	testCases := []struct {
+		name      string
+		canon     Canonicalization
+		finalCRLF FinalCRLF
+		body      string
+		want      string
+	}{
+		{"simple pad", Simple, FinalCRLFPad, "Hey\r\nyou!", "Hey\r\nyou!\r\n"},
+		{"simple as-is", Simple, FinalCRLFAsIs, "Hey\r\nyou!", "Hey\r\nyou!"},
+		{"simple as-is terminated", Simple, FinalCRLFAsIs, "Hey\r\nyou!\r\n\r\n", "Hey\r\nyou!\r\n"},
+		{"simple as-is empty", Simple, FinalCRLFAsIs, "", "\r\n"},
+		{"relaxed pad", Relaxed, FinalCRLFPad, "Hey \t you!", "Hey you!\r\n"},
+		{"relaxed as-is", Relaxed, FinalCRLFAsIs, "Hey \t you! ", "Hey you!"},
+		{"relaxed as-is terminated", Relaxed, FinalCRLFAsIs, "Hey\r\nyou!\n", "Hey\r\nyou!\r\n"},
+		// 改行で終わらない最後の行が空白だけの場合は空行として除去されるため補う
+		{"relaxed as-is trailing whitespace line", Relaxed, FinalCRLFAsIs, "Hey\r\n \t", "Hey\r\n"},
+		{"relaxed as-is empty", Relaxed, FinalCRLFAsIs, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := bytes.Buffer{}
+			wc := BodyWithFinalCRLF(&b, tc.canon, tc.finalCRLF)
+			wc.Write([]byte(tc.body))
+			wc.Close()
+			if got := b.String(); got != tc.want {
+				t.Errorf("body=%q want %q, but got %q", tc.body, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		input   string
+		header  Canonicalization
+		body    Canonicalization
+		wantErr bool
+	}{
+		{"", Simple, Simple, false},
+		{"relaxed", Relaxed, Simple, false},
+		{"relaxed/relaxed", Relaxed, Relaxed, false},
+		{"simple/relaxed", Simple, Relaxed, false},
+		{"bogus", "", "", true},
+		{"relaxed/bogus", "", "", true},
+		{"relaxed/simple/simple", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			header, body, err := Parse(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if header != tc.header || body != tc.body {
+				t.Errorf("want %s/%s, but got %s/%s", tc.header, tc.body, header, body)
+			}
+		})
+	}
+}
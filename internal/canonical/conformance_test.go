@@ -0,0 +1,208 @@
+package canonical
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// RFC 6376 3.4.5 の例
+func TestRFC6376Example(t *testing.T) {
+	headers := []string{"A: X\r\n", "B : Y\t\r\n\tZ  \r\n"}
+	body := " C \r\nD \t E\r\n\r\n\r\n"
+
+	testCases := []struct {
+		name       string
+		canon      Canonicalization
+		wantHeader string
+		wantBody   string
+	}{
+		{
+			name:       "relaxed",
+			canon:      Relaxed,
+			wantHeader: "a:X\r\nb:Y Z\r\n",
+			wantBody:   " C\r\nD E\r\n",
+		},
+		{
+			name:       "simple",
+			canon:      Simple,
+			wantHeader: "A: X\r\nB : Y\t\r\n\tZ  \r\n",
+			wantBody:   " C \r\nD \t E\r\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var h string
+			for _, v := range headers {
+				h += Header(v, tc.canon)
+			}
+			if h != tc.wantHeader {
+				t.Errorf("header: want %q, but got %q", tc.wantHeader, h)
+			}
+			if got := canonicalizeBody(t, body, tc.canon); got != tc.wantBody {
+				t.Errorf("body: want %q, but got %q", tc.wantBody, got)
+			}
+		})
+	}
+}
+
+// RFC 6376 3.4.2: relaxedのヘッダ正規化の各規則
+func TestRelaxedHeaderRules(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "lowercase name", header: "SubJECT: Hello\r\n", want: "subject:Hello\r\n"},
+		{name: "value case preserved", header: "Subject: HeLLo\r\n", want: "subject:HeLLo\r\n"},
+		{name: "unfold crlf space", header: "Subject: a\r\n b\r\n", want: "subject:a b\r\n"},
+		{name: "unfold crlf tab", header: "Subject: a\r\n\tb\r\n", want: "subject:a b\r\n"},
+		{name: "multiple folds", header: "Subject: a\r\n \r\n\tb\r\n", want: "subject:a b\r\n"},
+		{name: "compress wsp", header: "Subject: a \t  b\r\n", want: "subject:a b\r\n"},
+		{name: "trailing wsp", header: "Subject: a \t\r\n", want: "subject:a\r\n"},
+		{name: "wsp before colon", header: "Subject \t: a\r\n", want: "subject:a\r\n"},
+		{name: "wsp after colon", header: "Subject:\t  a\r\n", want: "subject:a\r\n"},
+		{name: "empty value", header: "Subject:\r\n", want: "subject:\r\n"},
+		{name: "whitespace only value", header: "Subject: \t \r\n", want: "subject:\r\n"},
+		{name: "colon in value", header: "Date: Mon, 1 Jan 2024 00:00:00 +0900\r\n", want: "date:Mon, 1 Jan 2024 00:00:00 +0900\r\n"},
+		{name: "missing crlf", header: "Subject: a", want: "subject:a\r\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Header(tc.header, Relaxed); got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+// RFC 6376 3.4.3, 3.4.4: 本文の正規化の各規則
+func TestBodyRules(t *testing.T) {
+	testCases := []struct {
+		name    string
+		body    string
+		simple  string
+		relaxed string
+	}{
+		// 3.4.3: simpleで空の本文はCRLF 1つ、3.4.4: relaxedでは空のまま
+		{name: "empty", body: "", simple: "\r\n", relaxed: ""},
+		{name: "only empty lines", body: "\r\n\r\n\r\n", simple: "\r\n", relaxed: ""},
+		{name: "missing final crlf", body: "a", simple: "a\r\n", relaxed: "a\r\n"},
+		{name: "trailing empty lines", body: "a\r\n\r\n", simple: "a\r\n", relaxed: "a\r\n"},
+		{name: "trailing wsp", body: "a \t\r\n", simple: "a \t\r\n", relaxed: "a\r\n"},
+		{name: "inner wsp", body: "a \t b\r\n", simple: "a \t b\r\n", relaxed: "a b\r\n"},
+		{name: "leading wsp kept", body: "\t a\r\n", simple: "\t a\r\n", relaxed: " a\r\n"},
+		{name: "wsp only line before end", body: "a\r\n \t\r\n", simple: "a\r\n \t\r\n", relaxed: "a\r\n"},
+		{name: "bare lf", body: "a\nb\n", simple: "a\r\nb\r\n", relaxed: "a\r\nb\r\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := canonicalizeBody(t, tc.body, Simple); got != tc.simple {
+				t.Errorf("simple: want %q, but got %q", tc.simple, got)
+			}
+			if got := canonicalizeBody(t, tc.body, Relaxed); got != tc.relaxed {
+				t.Errorf("relaxed: want %q, but got %q", tc.relaxed, got)
+			}
+		})
+	}
+}
+
+// 正規化を2回適用しても結果が変わらないこと
+func TestCanonicalizationIdempotence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(6376))
+	for i := 0; i < 2000; i++ {
+		h := randomHeader(rnd)
+		once := Header(h, Relaxed)
+		if twice := Header(once, Relaxed); twice != once {
+			t.Fatalf("relaxed header is not idempotent for %q: %q != %q", h, once, twice)
+		}
+		if !strings.HasSuffix(once, crlf) || strings.Count(once, crlf) != 1 {
+			t.Fatalf("relaxed header must be a single line ending with CRLF: %q", once)
+		}
+		name, _, _ := strings.Cut(once, ":")
+		if name != strings.ToLower(name) || strings.ContainsAny(name, " \t") {
+			t.Fatalf("relaxed header name must be lowercase without WSP: %q", once)
+		}
+		if simple := Header(h, Simple); simple != h {
+			t.Fatalf("simple header must not change the input: %q != %q", h, simple)
+		}
+
+		b := randomBody(rnd)
+		for _, c := range []Canonicalization{Simple, Relaxed} {
+			once := canonicalizeBody(t, b, c)
+			if twice := canonicalizeBody(t, once, c); twice != once {
+				t.Fatalf("%s body is not idempotent for %q: %q != %q", c, b, once, twice)
+			}
+			if strings.HasSuffix(once, "\r\n\r\n") {
+				t.Fatalf("%s body must not end with empty lines: %q", c, once)
+			}
+		}
+		// relaxedはsimpleの結果に適用しても同じになる
+		if got, want := canonicalizeBody(t, canonicalizeBody(t, b, Simple), Relaxed), canonicalizeBody(t, b, Relaxed); got != want {
+			t.Fatalf("relaxed(simple(b)) != relaxed(b) for %q: %q != %q", b, got, want)
+		}
+	}
+}
+
+func canonicalizeBody(t *testing.T, body string, c Canonicalization) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := Body(&buf, c)
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatalf("failed to write body: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	return buf.String()
+}
+
+func randomToken(rnd *rand.Rand, alphabet string, max int) string {
+	n := rnd.Intn(max + 1)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteByte(alphabet[rnd.Intn(len(alphabet))])
+	}
+	return b.String()
+}
+
+// 折り返しや空白を含む正しい形式のヘッダを作る
+func randomHeader(rnd *rand.Rand) string {
+	const wsp = " \t"
+	name := randomToken(rnd, "aAbBxX-", 8)
+	if name == "" {
+		name = "X"
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString(randomToken(rnd, wsp, 2))
+	b.WriteByte(':')
+	for i := rnd.Intn(5); i > 0; i-- {
+		b.WriteString(randomToken(rnd, wsp, 3))
+		b.WriteString(randomToken(rnd, "aB:;,.=", 6))
+		if rnd.Intn(3) == 0 {
+			b.WriteString(crlf)
+			b.WriteByte(wsp[rnd.Intn(2)])
+		}
+	}
+	b.WriteString(randomToken(rnd, wsp, 2))
+	b.WriteString(crlf)
+	return b.String()
+}
+
+func randomBody(rnd *rand.Rand) string {
+	var b strings.Builder
+	for i := rnd.Intn(6); i > 0; i-- {
+		b.WriteString(randomToken(rnd, "  \tab.", 8))
+		if rnd.Intn(5) == 0 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(crlf)
+		}
+	}
+	if rnd.Intn(3) == 0 {
+		b.WriteString(randomToken(rnd, " ab", 4))
+	}
+	return b.String()
+}
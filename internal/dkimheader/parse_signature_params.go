@@ -7,9 +7,113 @@ import (
 	"strings"
 )
 
+const (
+	// DefaultMaxTagNameLength is the default limit on the length of a tag name.
+	DefaultMaxTagNameLength = 100
+	// DefaultMaxTagValueLength is the default limit on the length of a tag value,
+	// measured after trimming surrounding whitespace but including folding inside it.
+	DefaultMaxTagValueLength = 1000
+)
+
+// ErrTagTooLong is returned when a tag name or value exceeds Limits, so that
+// callers can tell an oversized signature from a malformed one.
+var ErrTagTooLong = errors.New("tag exceeds length limit")
+
+// Limits bounds the tag names and values accepted by the parsers.
+// Zero fields use the defaults.
+type Limits struct {
+	MaxTagNameLength  int
+	MaxTagValueLength int
+}
+
+func (l Limits) maxTagNameLength() int {
+	if l.MaxTagNameLength <= 0 {
+		return DefaultMaxTagNameLength
+	}
+	return l.MaxTagNameLength
+}
+
+func (l Limits) maxTagValueLength() int {
+	if l.MaxTagValueLength <= 0 {
+		return DefaultMaxTagValueLength
+	}
+	return l.MaxTagValueLength
+}
+
 // ParseSignatureParams parses DKIM-Signature header parameters with strict validation
 // according to RFC 6376 requirements.
 func ParseSignatureParams(s string) (map[string]string, error) {
+	return ParseSignatureParamsWithLimits(s, Limits{})
+}
+
+// ParseSignatureParamsWithLimits is ParseSignatureParams with custom tag length limits.
+func ParseSignatureParamsWithLimits(s string, limits Limits) (map[string]string, error) {
+	params, err := parseTagList(s, "DKIM-Signature", isValidDKIMTag, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate required tags for DKIM-Signature according to RFC 6376
+	// All of the following tags are required: a, b, bh, d, h, s, v
+	// Note: v is explicitly required according to RFC 6376 Section 3.5
+	if err := requireTags(params, "DKIM-Signature", "a", "b", "bh", "d", "h", "s", "v"); err != nil {
+		return nil, err
+	}
+
+	// Validate v tag value (RFC 6376 requires version to be "1")
+	if params["v"] != "1" {
+		return nil, fmt.Errorf("invalid version tag value: %s", params["v"])
+	}
+
+	// Type validation for specific tags
+	if err := validateTagTypes(params); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// MaxARCInstance is the largest instance number allowed by RFC 8617 §4.2.1.
+const MaxARCInstance = 50
+
+// ParseARCMessageSignatureParams parses ARC-Message-Signature header parameters
+// with the same strict validation as DKIM-Signature (RFC 8617 §4.1.2).
+// The i tag must be an integer in the range 1..50.
+func ParseARCMessageSignatureParams(s string) (map[string]string, error) {
+	return ParseARCMessageSignatureParamsWithLimits(s, Limits{})
+}
+
+// ParseARCMessageSignatureParamsWithLimits is ParseARCMessageSignatureParams
+// with custom tag length limits.
+func ParseARCMessageSignatureParamsWithLimits(s string, limits Limits) (map[string]string, error) {
+	params, err := parseTagList(s, "ARC-Message-Signature", isValidAMSTag, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireTags(params, "ARC-Message-Signature", "i", "a", "b", "bh", "d", "s"); err != nil {
+		return nil, err
+	}
+
+	i, err := strconv.Atoi(params["i"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance 'i' value: %s", params["i"])
+	}
+	if i < 1 || i > MaxARCInstance {
+		return nil, fmt.Errorf("instance 'i' value out of range 1..%d: %d", MaxARCInstance, i)
+	}
+
+	if err := validateTagTypes(params); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// parseTagList splits a tag=value list (RFC 6376 §3.2), rejecting malformed
+// and duplicate tags. Tags for which isValid returns false are ignored.
+// Tags longer than limits are rejected with ErrTagTooLong.
+func parseTagList(s, fieldName string, isValid func(string) bool, limits Limits) (map[string]string, error) {
 	pairs := strings.Split(s, ";")
 	params := make(map[string]string)
 
@@ -35,50 +139,40 @@ func ParseSignatureParams(s string) (map[string]string, error) {
 		}
 
 		// Check for excessively long tag name
-		if len(trimmedKey) > 100 {
-			return nil, errors.New("malformed header params")
+		if max := limits.maxTagNameLength(); len(trimmedKey) > max {
+			return nil, fmt.Errorf("%w: tag name is %d bytes (limit %d) in %s header", ErrTagTooLong, len(trimmedKey), max, fieldName)
 		}
 
 		// Check for excessively long tag value
 		trimmedValue := strings.TrimSpace(value)
-		if len(trimmedValue) > 1000 {
-			return nil, errors.New("malformed header params")
+		if max := limits.maxTagValueLength(); len(trimmedValue) > max {
+			return nil, fmt.Errorf("%w: value of tag '%s' is %d bytes (limit %d) in %s header", ErrTagTooLong, trimmedKey, len(trimmedValue), max, fieldName)
 		}
 
 		// Check for duplicate tags (RFC 6376 §3.2 requires meticulous validation)
 		if seenTags[trimmedKey] {
-			return nil, fmt.Errorf("duplicate tag '%s' in DKIM-Signature header", trimmedKey)
+			return nil, fmt.Errorf("duplicate tag '%s' in %s header", trimmedKey, fieldName)
 		}
 		seenTags[trimmedKey] = true
 
 		// According to RFC 6376 §3.2, unrecognized tags MUST be ignored
 		// Only process recognized tags
-		if isValidDKIMTag(trimmedKey) {
+		if isValid(trimmedKey) {
 			params[trimmedKey] = trimmedValue
 		}
 	}
 
-	// Validate required tags for DKIM-Signature according to RFC 6376
-	// All of the following tags are required: a, b, bh, d, h, s, v
-	// Note: v is explicitly required according to RFC 6376 Section 3.5
-	requiredTags := []string{"a", "b", "bh", "d", "h", "s", "v"}
-	for _, tag := range requiredTags {
+	return params, nil
+}
+
+// requireTags returns an error if any of tags is missing from params
+func requireTags(params map[string]string, fieldName string, tags ...string) error {
+	for _, tag := range tags {
 		if _, exists := params[tag]; !exists {
-			return nil, fmt.Errorf("required tag '%s' is missing in DKIM-Signature header", tag)
+			return fmt.Errorf("required tag '%s' is missing in %s header", tag, fieldName)
 		}
 	}
-
-	// Validate v tag value (RFC 6376 requires version to be "1")
-	if params["v"] != "1" {
-		return nil, fmt.Errorf("invalid version tag value: %s", params["v"])
-	}
-
-	// Type validation for specific tags
-	if err := validateTagTypes(params); err != nil {
-		return nil, err
-	}
-
-	return params, nil
+	return nil
 }
 
 // isValidDKIMTag checks if a tag is a recognized DKIM-Signature tag according to RFC 6376
@@ -95,6 +189,7 @@ func isValidDKIMTag(tag string) bool {
 		"i":  true, // Identity
 		"l":  true, // Length
 		"q":  true, // Query
+		"r":  true, // Reporting requested (RFC 6651)
 		"s":  true, // Selector
 		"t":  true, // Timestamp
 		"x":  true, // Expiration
@@ -104,6 +199,16 @@ func isValidDKIMTag(tag string) bool {
 	return exists
 }
 
+// isValidAMSTag checks if a tag is a recognized ARC-Message-Signature tag.
+// AMS uses the DKIM-Signature tags except v, plus the ARC instance tag i
+// which replaces the DKIM AUID (RFC 8617 §4.1.2).
+func isValidAMSTag(tag string) bool {
+	if tag == "v" {
+		return false
+	}
+	return isValidDKIMTag(tag)
+}
+
 // validateTagTypes performs type checking for DKIM-Signature tags
 func validateTagTypes(params map[string]string) error {
 	// Validate t and x tags (timestamps) - must be integers
@@ -1,6 +1,7 @@
 package dkimheader
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -117,13 +118,13 @@ func TestParseSignatureParams(t *testing.T) {
 			name:    "Very long tag name should be rejected",
 			input:   "a=rsa-sha256; b=signature; bh=bodyhash; d=example.org; h=from:to; s=selector; v=1; " + strings.Repeat("x", 1000) + "=value",
 			wantErr: true,
-			errMsg:  "malformed header params",
+			errMsg:  "tag exceeds length limit",
 		},
 		{
 			name:    "Very long tag value should be rejected",
 			input:   "a=rsa-sha256; b=signature; bh=bodyhash; d=example.org; h=from:to; s=selector; v=1; tag=" + strings.Repeat("x", 10000),
 			wantErr: true,
-			errMsg:  "malformed header params",
+			errMsg:  "tag exceeds length limit",
 		},
 	}
 
@@ -229,3 +230,31 @@ func TestParseSignatureParamsUnknownTags(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSignatureParamsWithLimits(t *testing.T) {
+	base := "a=rsa-sha256; b=signature; bh=bodyhash; d=example.org; h=from:to; s=selector; v=1; "
+	tests := []struct {
+		name    string
+		input   string
+		limits  Limits
+		wantErr error
+	}{
+		{name: "default limit", input: base + "z=" + strings.Repeat("x", 1001), wantErr: ErrTagTooLong},
+		{name: "raised value limit", input: base + "z=" + strings.Repeat("x", 1001), limits: Limits{MaxTagValueLength: 4096}},
+		{name: "lowered value limit", input: base + "z=" + strings.Repeat("x", 65), limits: Limits{MaxTagValueLength: 64}, wantErr: ErrTagTooLong},
+		{name: "raised name limit", input: base + strings.Repeat("x", 101) + "=value", limits: Limits{MaxTagNameLength: 200}},
+		{name: "lowered name limit", input: base + "xyz=value", limits: Limits{MaxTagNameLength: 2}, wantErr: ErrTagTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSignatureParamsWithLimits(tt.input, tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseSignatureParamsWithLimits() error = %v, want %v", err, tt.wantErr)
+			}
+			_, err = ParseARCMessageSignatureParamsWithLimits(strings.Replace(tt.input, "v=1", "i=1", 1), tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseARCMessageSignatureParamsWithLimits() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
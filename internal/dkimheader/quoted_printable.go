@@ -0,0 +1,27 @@
+package dkimheader
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DecodeQuotedPrintable decodes a dkim-quoted-printable value (RFC 6376 2.11).
+// Malformed =XX sequences are kept as is. Raw UTF-8 is passed through, since
+// RFC 8616 5 allows non-ASCII characters without quoting in EAI messages.
+func DecodeQuotedPrintable(s string) string {
+	if !strings.Contains(s, "=") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '=' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
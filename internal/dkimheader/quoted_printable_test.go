@@ -0,0 +1,25 @@
+package dkimheader
+
+import "testing"
+
+func TestDecodeQuotedPrintable(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain", input: "user", want: "user"},
+		{name: "encoded", input: "dkim=3Dreport", want: "dkim=report"},
+		{name: "utf-8 encoded", input: "=E7=94=B0=E4=B8=AD", want: "田中"},
+		{name: "raw utf-8", input: "田中", want: "田中"},
+		{name: "malformed", input: "a=ZZ", want: "a=ZZ"},
+		{name: "truncated", input: "a=4", want: "a=4"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DecodeQuotedPrintable(tc.input); got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -13,14 +13,14 @@ import (
 // formatting preserved.
 func StripBValueForSigning(rawHeaderLine string) string {
 	// Find the start of the b= tag (case insensitive)
-	bTagStart := findBTagStart([]rune(rawHeaderLine))
+	bTagStart := findBTagStart(rawHeaderLine)
 	if bTagStart == -1 {
 		// No b= tag found, return original
 		return rawHeaderLine
 	}
 
 	// Find the end of the b= tag value
-	bTagEnd := findBTagEnd([]rune(rawHeaderLine), bTagStart)
+	bTagEnd := findBTagEnd(rawHeaderLine, bTagStart)
 	if bTagEnd == -1 {
 		// Malformed b= tag, return original
 		return rawHeaderLine
@@ -45,14 +45,15 @@ func StripBValueForSigning(rawHeaderLine string) string {
 }
 
 // findBTagStart finds the start position of the b= tag value
-// Returns the index after "b=" where the value starts, or -1 if not found
-func findBTagStart(runes []rune) int {
+// Returns the byte index after "b=" where the value starts, or -1 if not found.
+// The delimiters are ASCII, so scanning bytes is safe for UTF-8 values (RFC 8616).
+func findBTagStart(s string) int {
 	// Look for b= tag (case insensitive)
-	for i := 0; i < len(runes)-1; i++ {
+	for i := 0; i < len(s)-1; i++ {
 		// Check for possible b= tag start
-		if (runes[i] == 'b' || runes[i] == 'B') && runes[i+1] == '=' {
+		if (s[i] == 'b' || s[i] == 'B') && s[i+1] == '=' {
 			// Make sure it's preceded by ; or whitespace (or is at the beginning of the header value)
-			if i == 0 || runes[i-1] == ';' || isFWS(runes[i-1]) {
+			if i == 0 || s[i-1] == ';' || isFWS(s[i-1]) {
 				return i + 2 // Position after "b="
 			}
 		}
@@ -62,27 +63,27 @@ func findBTagStart(runes []rune) int {
 
 // findBTagEnd finds the end position of the b= tag value
 // Starting from bTagStart (after "b="), find where the value ends
-// Returns the index where the value ends (either at semicolon or end of line)
-func findBTagEnd(runes []rune, bTagStart int) int {
+// Returns the byte index where the value ends (either at semicolon or end of line)
+func findBTagEnd(s string, bTagStart int) int {
 	i := bTagStart
 
 	// Skip any leading FWS after b=
-	for i < len(runes) && isFWS(runes[i]) {
+	for i < len(s) && isFWS(s[i]) {
 		i++
 	}
 
 	// Scan through the value
 	// The value consists of base64 characters and FWS
-	for i < len(runes) {
+	for i < len(s) {
 		// Handle folded headers (CRLF + WSP)
-		if i+2 < len(runes) && runes[i] == '\r' && runes[i+1] == '\n' && isFWS(runes[i+2]) {
+		if i+2 < len(s) && s[i] == '\r' && s[i+1] == '\n' && isFWS(s[i+2]) {
 			// Skip the CRLF and WSP (folded header continuation)
 			i += 3
 			continue
 		}
 
 		// Stop at semicolon or end of line
-		if runes[i] == ';' || runes[i] == '\r' || runes[i] == '\n' {
+		if s[i] == ';' || s[i] == '\r' || s[i] == '\n' {
 			break
 		}
 		i++
@@ -91,9 +92,9 @@ func findBTagEnd(runes []rune, bTagStart int) int {
 	return i
 }
 
-// isFWS checks if a rune is considered Folding White Space (FWS)
+// isFWS checks if a byte is considered Folding White Space (FWS)
 // FWS = 1*WSP / obs-FWS (RFC 5322)
 // obs-FWS = 1*WSP *(CRLF 1*WSP)
-func isFWS(r rune) bool {
+func isFWS(r byte) bool {
 	return r == ' ' || r == '\t'
 }
@@ -30,6 +30,11 @@ func TestStripBValue(t *testing.T) {
 			input:    "DKIM-Signature: v=1; a=rsa-sha256; b=abc123\r\n def456; bh=ghi789",
 			expected: "DKIM-Signature: v=1; a=rsa-sha256; b=; bh=ghi789",
 		},
+		{
+			name:     "utf-8 before b",
+			input:    "DKIM-Signature: v=1; d=日本語.jp; i=田中@日本語.jp; b=abc123; bh=def456",
+			expected: "DKIM-Signature: v=1; d=日本語.jp; i=田中@日本語.jp; b=; bh=def456",
+		},
 		{
 			name:     "no semicolon after b",
 			input:    "DKIM-Signature: v=1; a=rsa-sha256; b=abc123\r\n",
@@ -0,0 +1,198 @@
+package header
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/internal/idn"
+)
+
+// addrToken はaddress-listの字句
+// specialsはその1文字、wordはatom・quoted-string・domain-literal
+type addrToken struct {
+	special byte
+	word    string
+}
+
+// tokenizeAddressList はaddress-listを字句に分ける
+// コメントとCFWSは読み飛ばす。閉じていないコメントや引用符はErrInvalidEmailFormat
+func tokenizeAddressList(s string) ([]addrToken, error) {
+	var tokens []addrToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			// コメントは入れ子にでき、\でエスケープした括弧は数えない
+			depth, j := 0, i
+			for ; j < len(s); j++ {
+				if s[j] == '\\' {
+					j++
+					continue
+				}
+				if s[j] == '(' {
+					depth++
+				} else if s[j] == ')' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			if j >= len(s) {
+				return nil, ErrInvalidEmailFormat
+			}
+			i = j + 1
+		case c == '"':
+			var sb strings.Builder
+			sb.WriteByte('"')
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					sb.WriteByte(s[i])
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, ErrInvalidEmailFormat
+			}
+			sb.WriteByte('"')
+			tokens = append(tokens, addrToken{word: sb.String()})
+			i++
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, ErrInvalidEmailFormat
+			}
+			tokens = append(tokens, addrToken{word: s[i : i+end+1]})
+			i += end + 1
+		case strings.IndexByte("<>@,:;.", c) >= 0:
+			tokens = append(tokens, addrToken{special: c})
+			i++
+		default:
+			start := i
+			for i < len(s) && strings.IndexByte(" \t\r\n()\"[]<>@,:;.\\", s[i]) < 0 {
+				i++
+			}
+			if i == start {
+				// 対応する開きのない ] や \ など
+				return nil, ErrInvalidEmailFormat
+			}
+			tokens = append(tokens, addrToken{word: s[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+// ParseAddressListDomains はFromなどのaddress-list (RFC 5322 3.4) から各mailboxのドメインを順に取り出す
+// グループ構文(display-name: mailbox-list;)、コメント、引用されたローカルパートや表示名、
+// 廃止された経路付きのアドレス(<@route1,@route2:user@example.com>)に対応する
+// 国際化ドメイン名はA-labelに変換する。mailboxを1つも含まない場合や
+// アドレスとして解析できない要素がある場合はErrInvalidEmailFormatを返す
+func ParseAddressListDomains(s string) ([]string, error) {
+	tokens, err := tokenizeAddressList(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	var current []addrToken
+	inGroup, inAngle := false, false
+	finish := func() error {
+		if len(current) == 0 {
+			// obs-mbox-listの空の要素
+			return nil
+		}
+		d, err := addrSpecDomain(current)
+		if err != nil {
+			return err
+		}
+		domains = append(domains, d)
+		current = nil
+		return nil
+	}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case inAngle:
+			switch t.special {
+			case '>':
+				inAngle = false
+			case '@':
+				// obs-route: <@route1,@route2:addr-spec> の経路を読み飛ばす
+				if len(current) == 0 {
+					for i < len(tokens) && tokens[i].special != ':' {
+						i++
+					}
+					if i >= len(tokens) {
+						return nil, ErrInvalidEmailFormat
+					}
+					continue
+				}
+				current = append(current, t)
+			default:
+				current = append(current, t)
+			}
+		case t.special == '<':
+			// 表示名を捨ててangle-addrの中身だけを残す
+			current = nil
+			inAngle = true
+		case t.special == ':' && !inGroup:
+			// グループの表示名
+			current = nil
+			inGroup = true
+		case t.special == ';' && inGroup:
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			inGroup = false
+		case t.special == ',':
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		case t.special == '>' || t.special == ':' || t.special == ';':
+			return nil, ErrInvalidEmailFormat
+		default:
+			current = append(current, t)
+		}
+	}
+	if inAngle {
+		return nil, ErrInvalidEmailFormat
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	if len(domains) == 0 {
+		return nil, ErrInvalidEmailFormat
+	}
+	return domains, nil
+}
+
+// addrSpecDomain はaddr-spec (local-part@domain) の字句からドメインを取り出す
+func addrSpecDomain(tokens []addrToken) (string, error) {
+	at := -1
+	for i, t := range tokens {
+		if t.special == '@' {
+			at = i
+		}
+	}
+	if at <= 0 || at == len(tokens)-1 {
+		return "", ErrInvalidEmailFormat
+	}
+	var sb strings.Builder
+	for _, t := range tokens[at+1:] {
+		switch {
+		case t.special == '.':
+			sb.WriteByte('.')
+		case t.special != 0, strings.HasPrefix(t.word, `"`):
+			return "", ErrInvalidEmailFormat
+		default:
+			sb.WriteString(t.word)
+		}
+	}
+	domain, err := idn.ToASCII(sb.String())
+	if err != nil {
+		return "", ErrInvalidEmailFormat
+	}
+	return domain, nil
+}
@@ -0,0 +1,49 @@
+package header
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseAddressListDomains(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       string
+		expected    []string
+		expectedErr error
+	}{
+		{name: "addr-spec", input: "user@example.com", expected: []string{"example.com"}},
+		{name: "name-addr", input: `"Doe, John" <john@example.com>`, expected: []string{"example.com"}},
+		{name: "comments", input: "John (the <boss@evil.example> (nested)) <john@example.com> (work)", expected: []string{"example.com"}},
+		{name: "comment in domain", input: "john@(comment)example.com", expected: []string{"example.com"}},
+		{name: "quoted local part with at", input: `"a@evil.example"@example.com`, expected: []string{"example.com"}},
+		{name: "display name with at", input: `"boss@evil.example" <user@example.com>`, expected: []string{"example.com"}},
+		{name: "multiple", input: "a@example.com, B <b@example.net>", expected: []string{"example.com", "example.net"}},
+		{name: "group", input: "Team: a@example.com, <b@example.net>;", expected: []string{"example.com", "example.net"}},
+		{name: "group and mailbox", input: "Team: a@example.com;, c@example.org", expected: []string{"example.com", "example.org"}},
+		{name: "obsolete route", input: "<@relay1.example,@relay2.example:user@example.com>", expected: []string{"example.com"}},
+		{name: "empty list elements", input: "a@example.com,, ,b@example.com", expected: []string{"example.com", "example.com"}},
+		{name: "folded", input: "John\r\n <john@example.com>", expected: []string{"example.com"}},
+		{name: "u-label", input: "テスト <テスト@日本語.jp>", expected: []string{"xn--wgv71a119e.jp"}},
+		{name: "empty group", input: "undisclosed-recipients:;", expectedErr: ErrInvalidEmailFormat},
+		{name: "no domain", input: "John <john>", expectedErr: ErrInvalidEmailFormat},
+		{name: "unclosed angle", input: "John <john@example.com", expectedErr: ErrInvalidEmailFormat},
+		{name: "unclosed comment", input: "john@example.com (oops", expectedErr: ErrInvalidEmailFormat},
+		{name: "unclosed quote", input: `"John <john@example.com>`, expectedErr: ErrInvalidEmailFormat},
+		{name: "nested group", input: "A: B: b@example.com;;", expectedErr: ErrInvalidEmailFormat},
+		{name: "empty", input: "", expectedErr: ErrInvalidEmailFormat},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			domains, err := ParseAddressListDomains(tc.input)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(domains, tc.expected) {
+				t.Errorf("Expected domains: %v, got: %v", tc.expected, domains)
+			}
+		})
+	}
+}
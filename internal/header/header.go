@@ -2,6 +2,7 @@ package header
 
 import (
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
@@ -9,11 +10,11 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"strings"
 	"unicode"
 
 	"github.com/masa23/mmauth/internal/canonical"
+	"github.com/masa23/mmauth/internal/idn"
 )
 
 const (
@@ -59,22 +60,6 @@ func StripWhiteSpace(s string) string {
 	}, s)
 }
 
-// WrapSignatureWithBreaks は署名を64文字ごとに改行しスペースを挿入する
-func WrapSignatureWithBreaks(s string) string {
-	lines := splitStringIntoChunks(s, 64)
-	return strings.Join(lines, "\r\n         ")
-}
-
-func splitStringIntoChunks(s string, chunkSize int) []string {
-	var chunks []string
-	for chunkSize < len(s) {
-		chunks = append(chunks, s[:chunkSize])
-		s = s[chunkSize:]
-	}
-	chunks = append(chunks, s)
-	return chunks
-}
-
 // ヘッダ、秘密鍵、正規化の種類を指定して署名を生成する
 //
 // RFC 6376 §3.7 (Computing the Message Hashes) requires the *signature header
@@ -93,6 +78,25 @@ func Signer(headers []string, key crypto.Signer, canon canonical.Canonicalizatio
 // This is required for DKIM/ARC signature computation where the signature
 // header field is hashed without its terminating CRLF.
 func SignerWithOmitLastCRLF(headers []string, key crypto.Signer, canon canonical.Canonicalization, hashAlgo crypto.Hash, omitLastCRLF bool) (string, error) {
+	return SignerWithOptions(headers, key, canon, hashAlgo, SignOptions{OmitLastCRLF: omitLastCRLF})
+}
+
+// SignOptions controls how SignerWithOptions computes the signature.
+type SignOptions struct {
+	// OmitLastCRLF omits the trailing CRLF from the last canonicalized header field.
+	OmitLastCRLF bool
+	// RSAPSS signs with RSASSA-PSS instead of RSASSA-PKCS1-v1_5 for RSA keys.
+	// This is NOT standard DKIM/ARC (RFC 6376 requires PKCS#1 v1.5) and is only
+	// meant for private deployments whose keys (e.g. in an HSM) are restricted to PSS.
+	RSAPSS bool
+	// ECDSA allows ECDSA keys for the experimental, non-standard ecdsa-sha256
+	// DKIM algorithm. Without it ECDSA keys are rejected like any other
+	// unsupported key type, so ARC never signs with them.
+	ECDSA bool
+}
+
+// SignerWithOptions is like Signer, with the behaviour controlled by opts.
+func SignerWithOptions(headers []string, key crypto.Signer, canon canonical.Canonicalization, hashAlgo crypto.Hash, opts SignOptions) (string, error) {
 	// keyがnilの場合はエラーを返す
 	if key == nil {
 		return "", errors.New("private key is nil")
@@ -106,10 +110,10 @@ func SignerWithOmitLastCRLF(headers []string, key crypto.Signer, canon canonical
 
 	var sb strings.Builder
 	for _, header := range headers {
-		sb.WriteString(canonical.Header(header, canonical.Canonicalization(canon)))
+		sb.WriteString(canonical.Header(header, canon))
 	}
 	s := sb.String()
-	if omitLastCRLF {
+	if opts.OmitLastCRLF {
 		s = strings.TrimSuffix(s, crlf)
 	}
 
@@ -129,19 +133,29 @@ func SignerWithOmitLastCRLF(headers []string, key crypto.Signer, canon canonical
 		hashed = sum[:]
 	}
 
-	var hash crypto.Hash
+	var signerOpts crypto.SignerOpts
 
 	switch publicKey.(type) {
 	case *rsa.PublicKey:
-		hash = hashAlgo
+		signerOpts = hashAlgo
+		if opts.RSAPSS {
+			// ソルト長はハッシュ長に合わせる(HSMで一般的な設定)
+			signerOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hashAlgo}
+		}
 	case ed25519.PublicKey:
-		hash = crypto.Hash(0)
+		signerOpts = crypto.Hash(0)
+	case *ecdsa.PublicKey:
+		// 実験的なecdsa-sha256。署名はASN.1 DER形式になる
+		if !opts.ECDSA {
+			return "", errors.New("unsupported private key type")
+		}
+		signerOpts = hashAlgo
 	default:
 		return "", errors.New("unsupported private key type")
 	}
 
 	// 秘密鍵を用いてハッシュを署名（ハッシュアルゴリズムの指定を修正）
-	signature, err := key.Sign(rand.Reader, hashed[:], hash)
+	signature, err := key.Sign(rand.Reader, hashed[:], signerOpts)
 	if err != nil {
 		return "", err
 	}
@@ -153,30 +167,7 @@ func SignerWithOmitLastCRLF(headers []string, key crypto.Signer, canon canonical
 
 // relaxed/simpleなどの文字列をパースしてcanonicalizationを返す
 func ParseHeaderCanonicalization(s string) (header canonical.Canonicalization, body canonical.Canonicalization, err error) {
-	if s == "" {
-		// 指定がない場合はsimple/simple
-		return canonical.Simple, canonical.Simple, nil
-	}
-	ret := strings.Split(s, "/")
-	if len(ret) != 2 {
-		// 一つしかしていない場合はヘッダーに適用
-		// bodyはsimple
-		return canonical.Canonicalization(ret[0]), canonical.Simple, nil
-	}
-	switch canonical.Canonicalization(ret[0]) {
-	case canonical.Simple, canonical.Relaxed:
-		header = canonical.Canonicalization(ret[0])
-	default:
-		return "", "", fmt.Errorf("invalid canonicalization")
-	}
-	switch canonical.Canonicalization(ret[1]) {
-	case canonical.Simple, canonical.Relaxed:
-		body = canonical.Canonicalization(ret[1])
-	default:
-		return "", "", fmt.Errorf("invalid canonicalization")
-	}
-
-	return
+	return canonical.Parse(s)
 }
 
 // DKIM、ARCのヘッダから署名を削除する
@@ -245,6 +236,50 @@ func ExtractHeadersDKIM(headers []string, keys []string) []string {
 	return ret
 }
 
+// ExtractWarning は署名対象のヘッダが署名ヘッダより上(署名後に追加された位置)から選ばれたことを示す
+type ExtractWarning struct {
+	Key            string // h=に含まれるヘッダ名(小文字)
+	Index          int    // 選ばれたヘッダのheaders内の位置
+	SignatureIndex int    // 署名ヘッダのheaders内の位置
+}
+
+// ExtractHeadersDKIMWireOrder はExtractHeadersDKIMと同じ規則でヘッダを選び、
+// headersを受信したままの順序(上が新しい)として扱って、署名ヘッダ(headers[sigIndex])より
+// 上にあるヘッダが選ばれた場合に警告を返す。
+// 通常、署名時に存在したヘッダは署名ヘッダより下にあるため、上から選ばれた場合は
+// 署名後に追加されたヘッダか、署名ヘッダを途中に挿入する生成系による署名の可能性がある。
+// sigIndexが範囲外の場合は警告を返さない。
+func ExtractHeadersDKIMWireOrder(headers []string, keys []string, sigIndex int) ([]string, []ExtractWarning) {
+	var ret []string
+	var warnings []ExtractWarning
+
+	byName := make(map[string][]int)
+	for i, header := range headers {
+		k, _, ok := strings.Cut(header, ":")
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(k))
+		byName[key] = append(byName[key], i)
+	}
+
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		indexes := byName[key]
+		if len(indexes) == 0 {
+			continue
+		}
+		idx := indexes[len(indexes)-1]
+		byName[key] = indexes[:len(indexes)-1]
+		ret = append(ret, headers[idx])
+		if sigIndex >= 0 && sigIndex < len(headers) && idx < sigIndex {
+			warnings = append(warnings, ExtractWarning{Key: key, Index: idx, SignatureIndex: sigIndex})
+		}
+	}
+
+	return ret, warnings
+}
+
 // ExtractHeadersAll extracts all headers matching the specified keys.
 // This function is used for ARC header processing where all instances are needed.
 func ExtractHeadersAll(headers []string, keys []string) []string {
@@ -381,5 +416,12 @@ func ParseAddressDomain(s string) (string, error) {
 		return "", ErrInvalidEmailFormat
 	}
 
-	return parts[len(parts)-1], nil
+	// 国際化ドメイン名(RFC 6531)はDNSの問い合わせやアライメントの比較に使えるようA-labelに変換する
+	// ローカルパートはUTF-8のまま扱う(RFC 6532)
+	domain, err := idn.ToASCII(parts[len(parts)-1])
+	if err != nil {
+		return "", ErrInvalidEmailFormat
+	}
+
+	return domain, nil
 }
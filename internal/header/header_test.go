@@ -648,6 +648,11 @@ func TestParseAddress(t *testing.T) {
 			input:          "John Doe <テスト@example.com>",
 			expectedDomain: "テスト@example.com",
 		},
+		{
+			name:           "Valid input with UTF-8 local part",
+			input:          "テスト <テスト@日本語.jp>",
+			expectedDomain: "テスト@日本語.jp",
+		},
 		{
 			name:           "Vaild input with ISO-2022-JP",
 			input:          "=?ISO-2022-JP?B?GyRCRnxLXDhsJDUkTxsoQg==?= <test@example.jp>",
@@ -725,6 +730,27 @@ func TestParseAddressDomain(t *testing.T) {
 			expectedDomain: "example.com",
 			expectedErr:    nil,
 		},
+		{
+			name:           "Valid input with UTF-8 local part and U-label domain",
+			input:          "テスト <テスト@日本語.jp>",
+			expectedDomain: "xn--wgv71a119e.jp",
+		},
+		{
+			name:           "Valid input with UTF-8 local part and A-label domain",
+			input:          "δοκιμή@xn--wgv71a119e.jp",
+			expectedDomain: "xn--wgv71a119e.jp",
+		},
+		{
+			name:           "Valid input with quoted UTF-8 local part",
+			input:          "\"名前 テスト\"@例え.テスト",
+			expectedDomain: "xn--r8jz45g.xn--zckzah",
+		},
+		{
+			name:           "Invalid input with malformed U-label domain",
+			input:          "user@\xff.jp",
+			expectedDomain: "",
+			expectedErr:    ErrInvalidEmailFormat,
+		},
 		{
 			name:           "Vaild input with ISO-2022-JP",
 			input:          "=?ISO-2022-JP?B?GyRCRnxLXDhsJDUkTxsoQg==?= <test@example.jp>",
@@ -0,0 +1,49 @@
+// Package idn は国際化ドメイン名(IDNA2008)の変換を行う
+package idn
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// ToASCII はU-labelを含むドメイン名をA-label(punycode)に変換する
+// ASCIIのみのドメイン名はそのまま返す
+func ToASCII(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	if !utf8.ValidString(domain) {
+		return "", fmt.Errorf("invalid UTF-8 in domain: %q", domain)
+	}
+	trailingDot := strings.HasSuffix(domain, ".")
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized domain %q: %w", domain, err)
+	}
+	if trailingDot {
+		ascii += "."
+	}
+	return ascii, nil
+}
+
+// ToUnicode はA-labelを含むドメイン名をU-labelに変換する
+// 変換できない場合は元の値を返す
+func ToUnicode(domain string) string {
+	u, err := idna.Lookup.ToUnicode(domain)
+	if err != nil {
+		return domain
+	}
+	return u
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package idn
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "ascii", input: "Example.COM", want: "Example.COM"},
+		{name: "japanese", input: "日本語.jp", want: "xn--wgv71a119e.jp"},
+		{name: "mixed case u-label", input: "BÜCHER.example", want: "xn--bcher-kva.example"},
+		{name: "trailing dot", input: "日本語.jp.", want: "xn--wgv71a119e.jp."},
+		{name: "invalid utf-8", input: "\xff.jp", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ToASCII(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	if got := ToUnicode("xn--wgv71a119e.jp"); got != "日本語.jp" {
+		t.Errorf("expected 日本語.jp, got %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Package ttlcache は有効期限と件数の上限を持つキャッシュを提供する
+// resolver.TXTCache、dmarc.Cache、dkim.VerifyCacheで共有する
+package ttlcache
+
+import (
+	"sync"
+	"time"
+)
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Cache はキーごとに有効期限付きの値を保持する。ゼロ値で使える
+// 上限に達した場合は期限切れのエントリを削除し、それでも上限に達している場合は
+// 最も早く期限が切れるエントリを削除する
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]entry[V]
+}
+
+// Get はnowの時点で有効なkeyの値を返す。期限切れのエントリは削除する
+func (c *Cache[K, V]) Get(key K, now time.Time) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if !now.Before(e.expires) {
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Set はkeyにexpiresまで有効な値を保存する。maxは保持するエントリ数の上限
+func (c *Cache[K, V]) Set(key K, value V, expires, now time.Time, max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[K]entry[V])
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= max {
+		c.evictLocked(now, max)
+	}
+	c.entries[key] = entry[V]{value: value, expires: expires}
+}
+
+// Expires はkeyの有効期限を返す。保持していない場合はfalseを返す
+func (c *Cache[K, V]) Expires(key K) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e.expires, ok
+}
+
+// Len は保持しているエントリ数を返す
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Purge はすべてのエントリを破棄する
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+// evictLocked は期限切れのエントリを削除し、それでも上限に達している場合は
+// 最も早く期限が切れるエントリを削除する
+func (c *Cache[K, V]) evictLocked(now time.Time, max int) {
+	var oldestKey K
+	var oldest time.Time
+	found := false
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+			continue
+		}
+		if !found || e.expires.Before(oldest) {
+			oldestKey, oldest, found = k, e.expires, true
+		}
+	}
+	if len(c.entries) >= max && found {
+		delete(c.entries, oldestKey)
+	}
+}
@@ -0,0 +1,48 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var c Cache[string, int]
+
+	c.Set("a", 1, now.Add(time.Minute), now, 2)
+	c.Set("b", 2, now.Add(2*time.Minute), now, 2)
+	if v, ok := c.Get("a", now); !ok || v != 1 {
+		t.Errorf("want 1, but got %d (%v)", v, ok)
+	}
+
+	// 上限に達した場合は最も早く期限が切れるエントリを削除する
+	c.Set("c", 3, now.Add(3*time.Minute), now, 2)
+	if _, ok := c.Get("a", now); ok {
+		t.Errorf("want a to be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("want 2 entries, but got %d", c.Len())
+	}
+
+	// 既存のキーの更新では削除しない
+	c.Set("b", 4, now.Add(time.Minute), now, 2)
+	if v, ok := c.Get("b", now); !ok || v != 4 {
+		t.Errorf("want 4, but got %d (%v)", v, ok)
+	}
+
+	// 期限切れのエントリは返さずに削除する
+	if _, ok := c.Get("b", now.Add(time.Minute)); ok {
+		t.Errorf("want b to be expired")
+	}
+	if c.Len() != 1 {
+		t.Errorf("want 1 entry, but got %d", c.Len())
+	}
+	if expires, ok := c.Expires("c"); !ok || !expires.Equal(now.Add(3*time.Minute)) {
+		t.Errorf("want %s, but got %s (%v)", now.Add(3*time.Minute), expires, ok)
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("want 0 entries, but got %d", c.Len())
+	}
+}
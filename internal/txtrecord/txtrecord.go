@@ -0,0 +1,61 @@
+// Package txtrecord はDNSから受け取ったTXTレコードを、DKIM・SPF・DMARCで解析する前に整える
+//
+// 制御文字は解析の途中で分かりにくいエラーになるため、ErrControlCharacterとして区別する
+// 一部の壊れたDNSサーバーは文字列の末尾にNULを付けて返すが、RFC 7208のテストスイートでは
+// NULを含むレコードはエラーのため、末尾のNULを取り除くのは各パッケージで指定された場合だけにする
+package txtrecord
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrControlCharacter はTXTレコードに取り除けない制御文字が含まれている場合のエラー
+var ErrControlCharacter = errors.New("TXT record contains control characters")
+
+// StripNUL はrの末尾のNUL文字を取り除く
+func StripNUL(r string) string {
+	return strings.TrimRight(r, "\x00")
+}
+
+// Check はrにタブ・CR・LF以外の制御文字(NUL・DELを含む)がないかを調べる
+// タブ・CR・LFは各レコードの解析で空白として扱われるため許可する
+func Check(r string) error {
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; {
+		case c == '\t', c == '\r', c == '\n':
+		case c < 0x20, c == 0x7f:
+			return fmt.Errorf("%w: 0x%02x at offset %d", ErrControlCharacter, c, i)
+		}
+	}
+	return nil
+}
+
+// Sanitize はすべてのレコードをCheckで調べて返す
+// stripNULがtrueの場合は、調べる前に末尾のNUL文字を取り除く
+// 制御文字を含むレコードがある場合はErrControlCharacterを返す
+// DKIMの鍵のように名前のレコードがすべて対象の場合に使い、
+// SPFやDMARCのように無関係なレコードが並ぶ場合は対象のレコードだけをCheckで調べる
+func Sanitize(records []string, stripNUL bool) ([]string, error) {
+	var ret []string
+	for i, r := range records {
+		stripped := r
+		if stripNUL {
+			stripped = StripNUL(r)
+		}
+		if err := Check(stripped); err != nil {
+			return nil, err
+		}
+		if ret == nil && stripped != r {
+			ret = append(make([]string, 0, len(records)), records[:i]...)
+		}
+		if ret != nil {
+			ret = append(ret, stripped)
+		}
+	}
+	if ret == nil {
+		return records, nil
+	}
+	return ret, nil
+}
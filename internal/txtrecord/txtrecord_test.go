@@ -0,0 +1,38 @@
+package txtrecord
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   []string
+		strip   bool
+		want    []string
+		wantErr error
+	}{
+		{name: "clean", input: []string{"v=spf1 -all", "other"}, want: []string{"v=spf1 -all", "other"}},
+		{name: "trailing nul", input: []string{"other", "v=DMARC1; p=none\x00"}, strip: true, want: []string{"other", "v=DMARC1; p=none"}},
+		{name: "trailing nuls", input: []string{"v=DKIM1; p=abc\x00\x00"}, strip: true, want: []string{"v=DKIM1; p=abc"}},
+		{name: "trailing nul not stripped", input: []string{"v=DKIM1; p=abc\x00"}, wantErr: ErrControlCharacter},
+		{name: "embedded nul", input: []string{"v=spf1 a:foo\x00.example.com -all"}, strip: true, wantErr: ErrControlCharacter},
+		{name: "whitespace", input: []string{"v=DKIM1;\tp=abc\r\n"}, want: []string{"v=DKIM1;\tp=abc\r\n"}},
+		{name: "escape", input: []string{"v=spf1 \x1b[0m-all"}, strip: true, wantErr: ErrControlCharacter},
+		{name: "delete", input: []string{"ok\x00", "v=DMARC1\x7f"}, strip: true, wantErr: ErrControlCharacter},
+		{name: "empty", input: nil, want: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Sanitize(tc.input, tc.strip)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("want %v, but got %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Package keyio はDKIM・ARCの署名に使う秘密鍵をファイルやバイト列から読み込む
+//
+// PEM形式のPKCS#1("RSA PRIVATE KEY")、PKCS#8("PRIVATE KEY")、パスフレーズで暗号化された
+// PKCS#8("ENCRYPTED PRIVATE KEY", PBES2)と旧形式の暗号化PEM(Proc-Type: 4,ENCRYPTED)、
+// OpenSSH形式("OPENSSH PRIVATE KEY", パスフレーズで保護された鍵を含む)のRSA・ed25519の鍵に対応する。
+// 読み込んだ鍵はcrypto.Signerとして、署名に使うアルゴリズムとともに返す。
+// 読み込みの途中で復号・展開した鍵の材料は解析後に消し、使い終えた鍵はZeroizeで消せる。
+package keyio
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/masa23/mmauth/dkim"
+)
+
+var (
+	// ErrNoPEMBlock はデータに秘密鍵のPEMブロックが含まれていない場合のエラー
+	ErrNoPEMBlock = errors.New("no private key PEM block found")
+	// ErrPassphraseRequired は暗号化された鍵をパスフレーズなしで読み込もうとした場合のエラー
+	ErrPassphraseRequired = errors.New("private key is encrypted and requires a passphrase")
+	// ErrIncorrectPassphrase はパスフレーズが誤っている場合のエラー
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+	// ErrUnsupportedKey は署名に使えない形式や種類の鍵の場合のエラー
+	ErrUnsupportedKey = errors.New("unsupported private key")
+)
+
+// LoadPrivateKey はpathの秘密鍵を読み込む
+// 暗号化されていない鍵の場合、passphraseは無視する
+// 読み込んだファイルの内容は解析後に消す
+func LoadPrivateKey(path string, passphrase []byte) (crypto.Signer, dkim.SignatureAlgorithm, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read private key: %w", err)
+	}
+	defer zero(data)
+	return ParsePrivateKey(data, passphrase)
+}
+
+// ParsePrivateKey はPEM形式の秘密鍵を解析し、署名に使うアルゴリズムとともに返す
+// 先頭にある秘密鍵のPEMブロックを使い、証明書などのほかのブロックは読み飛ばす
+func ParsePrivateKey(data, passphrase []byte) (crypto.Signer, dkim.SignatureAlgorithm, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, "", ErrNoPEMBlock
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = parsePKCS1(block, passphrase)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			key, err = parseEncryptedPKCS8(block.Bytes, passphrase)
+		case "OPENSSH PRIVATE KEY":
+			encoded := pem.EncodeToMemory(block)
+			key, err = parseOpenSSH(encoded, passphrase)
+			zero(encoded)
+		default:
+			continue
+		}
+		// 解析した鍵はblockの領域を参照しないため、展開した鍵の材料を消す
+		zero(block.Bytes)
+		if err != nil {
+			return nil, "", err
+		}
+		return signerOf(key)
+	}
+}
+
+// 旧形式の暗号化PEM(Proc-Type: 4,ENCRYPTED)を含むPKCS#1の鍵を解析する
+func parsePKCS1(block *pem.Block, passphrase []byte) (interface{}, error) {
+	der := block.Bytes
+	//nolint:staticcheck // 旧形式の暗号化PEMは安全ではないが、既存の鍵を読み込むために対応する
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		var err error
+		//nolint:staticcheck
+		der, err = x509.DecryptPEMBlock(block, passphrase)
+		if err != nil {
+			return nil, ErrIncorrectPassphrase
+		}
+		defer zero(der)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#1 private key: %w", err)
+	}
+	return key, nil
+}
+
+// 鍵の種類から署名のアルゴリズムを決める
+func signerOf(key interface{}) (crypto.Signer, dkim.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, dkim.SignatureAlgorithmRSA_SHA256, nil
+	case ed25519.PrivateKey:
+		return k, dkim.SignatureAlgorithmED25519_SHA256, nil
+	case *ed25519.PrivateKey:
+		return *k, dkim.SignatureAlgorithmED25519_SHA256, nil
+	}
+	return nil, "", fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
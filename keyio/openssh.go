@@ -0,0 +1,29 @@
+package keyio
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH形式の鍵を解析する
+// パスフレーズで保護された鍵(bcrypt_pbkdf)はpassphraseで復号する
+func parseOpenSSH(data, passphrase []byte) (interface{}, error) {
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OpenSSH private key: %w", err)
+	}
+	return key, nil
+}
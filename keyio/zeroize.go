@@ -0,0 +1,50 @@
+package keyio
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"math/big"
+)
+
+// Zeroize は読み込んだ秘密鍵の材料をメモリから消す
+// 鍵を使い終えたとき(鍵の入れ替えやプロセスの終了時など)に呼び、呼んだ後の鍵は署名に使えない
+// RSAの事前計算値はcrypto/rsaの内部に持つコピーへの参照を外すだけで、コピー自体は消せない
+// 対応していない種類の鍵の場合は何もしない
+func Zeroize(key crypto.Signer) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		zeroInt(k.D)
+		for _, p := range k.Primes {
+			zeroInt(p)
+		}
+		zeroInt(k.Precomputed.Dp)
+		zeroInt(k.Precomputed.Dq)
+		zeroInt(k.Precomputed.Qinv)
+		for _, v := range k.Precomputed.CRTValues {
+			zeroInt(v.Exp)
+			zeroInt(v.Coeff)
+			zeroInt(v.R)
+		}
+		k.Precomputed = rsa.PrecomputedValues{}
+	case ed25519.PrivateKey:
+		zero(k)
+	case *ed25519.PrivateKey:
+		zero(*k)
+	case *ecdsa.PrivateKey:
+		zeroInt(k.D)
+	}
+}
+
+// big.Intの値を保持している領域を消す
+func zeroInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
@@ -0,0 +1,187 @@
+package mmauth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/masa23/mmauth/internal/header"
+)
+
+var (
+	ErrInvalidHeaderField = errors.New("invalid header field")
+	// ErrDKIMSignatureNotFound は指定した位置のDKIM-Signatureヘッダがないことを示す
+	ErrDKIMSignatureNotFound = errors.New("DKIM-Signature header not found")
+)
+
+// PrependHeaders はメッセージの先頭にヘッダを追加したメッセージをストリームで返す
+// 既存のヘッダの順序と本文はバイト単位でそのまま保持する
+// fieldsは"DKIM-Signature: ..."のような名前を含むヘッダで、fields[0]が最も上になる
+// 末尾のCRLFは省略してもよい
+func PrependHeaders(msg io.Reader, fields ...string) (io.Reader, error) {
+	readers := make([]io.Reader, 0, len(fields)+1)
+	for _, f := range fields {
+		normalized, err := normalizeHeaderField(f)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, strings.NewReader(normalized))
+	}
+	readers = append(readers, msg)
+	return io.MultiReader(readers...), nil
+}
+
+// ヘッダを検証し、末尾をCRLFに揃える
+// 空行が含まれているとヘッダ部が終了してしまうため拒否する
+func normalizeHeaderField(f string) (string, error) {
+	f = strings.TrimRight(f, "\r\n")
+	name, _, ok := strings.Cut(f, ":")
+	if !ok || name == "" || strings.TrimSpace(name) != name {
+		return "", fmt.Errorf("%w: missing field name: %q", ErrInvalidHeaderField, f)
+	}
+	lines := strings.Split(f, crlf)
+	for i, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			return "", fmt.Errorf("%w: bare CR or LF: %q", ErrInvalidHeaderField, f)
+		}
+		// 継続行は空白で始まり、空行であってはならない
+		if i > 0 && (strings.TrimSpace(line) == "" || (line[0] != ' ' && line[0] != '\t')) {
+			return "", fmt.Errorf("%w: invalid folding: %q", ErrInvalidHeaderField, f)
+		}
+	}
+	return f + crlf, nil
+}
+
+// Message は受信したままの順序のヘッダと本文を保持する
+// Headersの先頭が最も上(最後に追加された)ヘッダになる
+type Message struct {
+	Headers []string // ヘッダ(継続行を含み、末尾にCRLFを含む)
+	Body    []byte   // 本文
+	// RawHeaders は受信したままのバイト列のヘッダ。Headersと同じ位置が同じヘッダに対応する
+	// ReadOptions.RetainRawHeadersを指定して読み込んだ場合のみ設定される
+	RawHeaders []string
+
+	// dkimSignatures は受信したままのバイト列のDKIM-Signatureヘッダ(上から順)
+	// RetainRawHeadersの指定に関わらずReadMessageWithOptionsで保持する
+	dkimSignatures []string
+}
+
+// ReadOptions はメッセージの読み込みオプション
+type ReadOptions struct {
+	// RetainRawHeaders はヘッダを受信したままのバイト列でも保持する
+	// simpleのヘッダ正規化ではヘッダをそのまま署名するため、
+	// 行末などを揃える前のヘッダで検証したい場合に指定する
+	RetainRawHeaders bool
+}
+
+// ReadMessage はメッセージを読み込みMessageを返す
+func ReadMessage(r io.Reader) (*Message, error) {
+	return ReadMessageWithOptions(r, nil)
+}
+
+// ReadMessageWithOptions はオプションを指定してメッセージを読み込む
+// optsがnilの場合はReadMessageと同じ
+func ReadMessageWithOptions(r io.Reader, opts *ReadOptions) (*Message, error) {
+	br := bufio.NewReader(r)
+	h, raw, err := readHeaderRaw(br)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %v", err)
+	}
+	m := &Message{Headers: h, Body: body}
+	for _, f := range raw {
+		if isHeaderNamed(f, "DKIM-Signature") {
+			m.dkimSignatures = append(m.dkimSignatures, f)
+		}
+	}
+	if opts != nil && opts.RetainRawHeaders {
+		m.RawHeaders = raw
+	}
+	return m, nil
+}
+
+// HeadersFor はヘッダの正規化方式cで署名を検証する際に使うヘッダを返す
+// simpleで受信したままのヘッダを保持している場合はRawHeadersを、それ以外はHeadersを返す
+func (m *Message) HeadersFor(c Canonicalization) []string {
+	if c == CanonicalizationSimple && m.RawHeaders != nil {
+		return m.RawHeaders
+	}
+	return m.Headers
+}
+
+// Reader はメッセージ全体をストリームで返す
+func (m *Message) Reader() io.Reader {
+	readers := make([]io.Reader, 0, len(m.Headers)+2)
+	for _, h := range m.Headers {
+		readers = append(readers, strings.NewReader(h))
+	}
+	readers = append(readers, strings.NewReader(crlf), bytes.NewReader(m.Body))
+	return io.MultiReader(readers...)
+}
+
+// HeaderIndexes は指定した名前のヘッダの位置を上から順に返す
+func (m *Message) HeaderIndexes(name string) []int {
+	var ret []int
+	for i, h := range m.Headers {
+		if isHeaderNamed(h, name) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func isHeaderNamed(h, name string) bool {
+	k, _, ok := strings.Cut(h, ":")
+	return ok && strings.EqualFold(strings.TrimSpace(k), name)
+}
+
+// GetDKIMSignatureRaw は上からn番目(0始まり)のDKIM-Signatureヘッダを
+// 受信したままのバイト列(折り返しと行末を含む)で返す
+// simpleのヘッダ正規化では署名ヘッダもそのまま署名されるため、
+// ヘッダを組み立て直さずに検証に使える
+// ReadMessageで読み込んでいないMessageではHeadersFor(CanonicalizationSimple)から探す
+func (m *Message) GetDKIMSignatureRaw(n int) (string, error) {
+	sigs := m.dkimSignatures
+	if sigs == nil {
+		for _, h := range m.HeadersFor(CanonicalizationSimple) {
+			if isHeaderNamed(h, "DKIM-Signature") {
+				sigs = append(sigs, h)
+			}
+		}
+	}
+	if n < 0 || n >= len(sigs) {
+		return "", fmt.Errorf("%w: index %d of %d", ErrDKIMSignatureNotFound, n, len(sigs))
+	}
+	return sigs[n], nil
+}
+
+// HeaderWarning は署名対象のヘッダが署名ヘッダより上から選ばれたことを示す
+type HeaderWarning struct {
+	Field          string // ヘッダ名(小文字)
+	Index          int    // 選ばれたヘッダの位置
+	SignatureIndex int    // 署名ヘッダの位置
+}
+
+func (w HeaderWarning) String() string {
+	return fmt.Sprintf("signed header %q (index %d) appears above the signature header (index %d)", w.Field, w.Index, w.SignatureIndex)
+}
+
+// ExtractHeadersDKIM はヘッダの正規化方式cの署名(HeadersFor(c)[sigIndex])のh=に従って署名対象のヘッダを抽出する
+// 抽出規則はExtractHeadersDKIMと同じで、ヘッダを受信した順序として扱い、
+// 署名ヘッダより上にある(署名後に追加された位置の)ヘッダが選ばれた場合は警告を返す
+// 検証(MMAuth.Verify、dkim.Signatures.VerifyAll)では同じ警告を検証結果の注記
+// (dkim.HeaderAboveSignatureAnnotation)として付ける
+func (m *Message) ExtractHeadersDKIM(c Canonicalization, sigIndex int, keys []string) ([]string, []HeaderWarning) {
+	extracted, warnings := header.ExtractHeadersDKIMWireOrder(m.HeadersFor(c), keys, sigIndex)
+	var ret []HeaderWarning
+	for _, w := range warnings {
+		ret = append(ret, HeaderWarning{Field: w.Key, Index: w.Index, SignatureIndex: w.SignatureIndex})
+	}
+	return extracted, ret
+}
@@ -0,0 +1,396 @@
+package mmauth
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/masa23/mmauth/dkim"
+)
+
+func TestPrependHeaders(t *testing.T) {
+	msg := "From: user@example.com\r\n" +
+		"Subject: test\r\n" +
+		"\r\n" +
+		"body  \r\n\r\n"
+
+	testCases := []struct {
+		name    string
+		fields  []string
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "single header without CRLF",
+			fields: []string{"DKIM-Signature: v=1; a=rsa-sha256;\r\n\tb=abc"},
+			want:   "DKIM-Signature: v=1; a=rsa-sha256;\r\n\tb=abc\r\n" + msg,
+		},
+		{
+			name: "multiple headers keep order",
+			fields: []string{
+				"ARC-Seal: i=1; cv=none\r\n",
+				"ARC-Message-Signature: i=1\r\n",
+				"ARC-Authentication-Results: i=1; example.com\r\n",
+			},
+			want: "ARC-Seal: i=1; cv=none\r\n" +
+				"ARC-Message-Signature: i=1\r\n" +
+				"ARC-Authentication-Results: i=1; example.com\r\n" + msg,
+		},
+		{
+			name:    "missing field name",
+			fields:  []string{"v=1; a=rsa-sha256"},
+			wantErr: ErrInvalidHeaderField,
+		},
+		{
+			name:    "empty line terminates header block",
+			fields:  []string{"X-Test: a\r\n\r\nX-Injected: b"},
+			wantErr: ErrInvalidHeaderField,
+		},
+		{
+			name:    "bare LF",
+			fields:  []string{"X-Test: a\nb"},
+			wantErr: ErrInvalidHeaderField,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := PrependHeaders(strings.NewReader(msg), tc.fields...)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("unexpected message:\nwant: %q\ngot:  %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	raw := "Received: from a\r\n\tby b\r\n" +
+		"From: user@example.com\r\n" +
+		"\r\n" +
+		"body\r\n"
+	m, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Headers) != 2 || m.Headers[0] != "Received: from a\r\n\tby b\r\n" {
+		t.Errorf("unexpected headers: %q", m.Headers)
+	}
+	if string(m.Body) != "body\r\n" {
+		t.Errorf("unexpected body: %q", m.Body)
+	}
+	got, _ := io.ReadAll(m.Reader())
+	if string(got) != raw {
+		t.Errorf("round trip mismatch:\nwant: %q\ngot:  %q", raw, got)
+	}
+}
+
+func TestReadMessageWithOptions(t *testing.T) {
+	raw := "Received: from a\n\tby b\r\n" +
+		"From: user@example.com\n" +
+		"\r\n" +
+		"body\r\n"
+	testCases := []struct {
+		name       string
+		opts       *ReadOptions
+		wantRaw    []string
+		wantSimple []string
+	}{
+		{
+			name:       "default",
+			wantSimple: []string{"Received: from a\r\n\tby b\r\n", "From: user@example.com\r\n"},
+		},
+		{
+			name:       "retain raw headers",
+			opts:       &ReadOptions{RetainRawHeaders: true},
+			wantRaw:    []string{"Received: from a\n\tby b\r\n", "From: user@example.com\n"},
+			wantSimple: []string{"Received: from a\n\tby b\r\n", "From: user@example.com\n"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := ReadMessageWithOptions(strings.NewReader(raw), tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			wantHeaders := []string{"Received: from a\r\n\tby b\r\n", "From: user@example.com\r\n"}
+			if !reflect.DeepEqual(m.Headers, wantHeaders) {
+				t.Errorf("unexpected headers: %q", m.Headers)
+			}
+			if !reflect.DeepEqual(m.RawHeaders, tc.wantRaw) {
+				t.Errorf("unexpected raw headers: %q", m.RawHeaders)
+			}
+			if got := m.HeadersFor(CanonicalizationSimple); !reflect.DeepEqual(got, tc.wantSimple) {
+				t.Errorf("unexpected simple headers: %q", got)
+			}
+			if got := m.HeadersFor(CanonicalizationRelaxed); !reflect.DeepEqual(got, wantHeaders) {
+				t.Errorf("unexpected relaxed headers: %q", got)
+			}
+			if string(m.Body) != "body\r\n" {
+				t.Errorf("unexpected body: %q", m.Body)
+			}
+		})
+	}
+}
+
+func TestMMAuthWireFidelity(t *testing.T) {
+	m := NewMMAuth()
+	if _, err := m.Write([]byte("From: user@example.com\nSubject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	normalized := headers{"From: user@example.com\r\n", "Subject: test\r\n"}
+	raw := headers{"From: user@example.com\n", "Subject: test\r\n"}
+	if got := m.verifyHeaders(CanonicalizationSimple); !reflect.DeepEqual(got, normalized) {
+		t.Errorf("expected normalized headers by default, got %q", got)
+	}
+	m.WireFidelity = true
+	if got := m.verifyHeaders(CanonicalizationSimple); !reflect.DeepEqual(got, raw) {
+		t.Errorf("expected raw headers for simple, got %q", got)
+	}
+	if got := m.verifyHeaders(CanonicalizationRelaxed); !reflect.DeepEqual(got, normalized) {
+		t.Errorf("expected normalized headers for relaxed, got %q", got)
+	}
+}
+
+func TestMMAuthFinalCRLF(t *testing.T) {
+	msg := "From: user@example.com\r\n\r\nbody"
+	padded := BodyCanonicalizationAndAlgorithm{Body: CanonicalizationSimple, Algorithm: crypto.SHA256}
+	asIs := padded
+	asIs.FinalCRLF = FinalCRLFAsIs
+
+	m := NewMMAuth()
+	m.AddBodyHash(padded)
+	m.AddBodyHash(asIs)
+	if _, err := m.Write([]byte(msg)); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	for _, tc := range []struct {
+		bca  BodyCanonicalizationAndAlgorithm
+		body string
+	}{
+		{padded, "body\r\n"},
+		{asIs, "body"},
+	} {
+		sum := sha256.Sum256([]byte(tc.body))
+		want := base64.StdEncoding.EncodeToString(sum[:])
+		if got := m.GetBodyHash(tc.bca); got != want {
+			t.Errorf("final crlf %s: want %s, but got %s", tc.bca.FinalCRLF, want, got)
+		}
+	}
+}
+
+func TestMMAuthSignatureLimitsBodyHash(t *testing.T) {
+	var msg strings.Builder
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(&msg, "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel%d; l=%d; h=from; bh=YmFk; b=YmFk\r\n", i, i)
+	}
+	msg.WriteString("From: user@example.com\r\n\r\nbody\r\n")
+
+	m := NewMMAuth(WithDKIMLimits(2, 0))
+	if _, err := m.Write([]byte(msg.String())); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	// 検証しない署名のl=のボディーハッシュは計算しない
+	if len(m.bodyHashList) != 2 {
+		t.Errorf("want 2 body hashes, but got %d", len(m.bodyHashList))
+	}
+	m.Verify()
+	for i, sig := range *m.AuthenticationHeaders.DKIMSignatures {
+		limited := sig.VerifyResult.Status() == dkim.VerifyStatusPolicy
+		if limited != (i >= 2) {
+			t.Errorf("signature %d: want limited %v, but got %s", i, i >= 2, sig.VerifyResult.Status())
+		}
+	}
+}
+
+func TestMessageExtractHeadersDKIM(t *testing.T) {
+	testCases := []struct {
+		name         string
+		headers      []string
+		keys         []string
+		wantHeaders  []string
+		wantWarnings []HeaderWarning
+	}{
+		{
+			name: "signature on top",
+			headers: []string{
+				"DKIM-Signature: v=1; h=from:subject\r\n",
+				"From: a@example.com\r\n",
+				"Subject: test\r\n",
+			},
+			keys:        []string{"from", "subject"},
+			wantHeaders: []string{"From: a@example.com\r\n", "Subject: test\r\n"},
+		},
+		{
+			name: "signed header above signature",
+			headers: []string{
+				"Subject: test\r\n",
+				"DKIM-Signature: v=1; h=from:subject\r\n",
+				"From: a@example.com\r\n",
+			},
+			keys:         []string{"from", "subject"},
+			wantHeaders:  []string{"From: a@example.com\r\n", "Subject: test\r\n"},
+			wantWarnings: []HeaderWarning{{Field: "subject", Index: 0, SignatureIndex: 1}},
+		},
+		{
+			name: "oversigned header added after signing",
+			headers: []string{
+				"From: evil@example.net\r\n",
+				"DKIM-Signature: v=1; h=from:from\r\n",
+				"From: a@example.com\r\n",
+			},
+			keys:         []string{"from", "from"},
+			wantHeaders:  []string{"From: a@example.com\r\n", "From: evil@example.net\r\n"},
+			wantWarnings: []HeaderWarning{{Field: "from", Index: 0, SignatureIndex: 1}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Message{Headers: tc.headers}
+			sigIndex := m.HeaderIndexes("DKIM-Signature")[0]
+			got, warnings := m.ExtractHeadersDKIM(CanonicalizationRelaxed, sigIndex, tc.keys)
+			if !reflect.DeepEqual(got, tc.wantHeaders) {
+				t.Errorf("expected headers %q, got %q", tc.wantHeaders, got)
+			}
+			if !reflect.DeepEqual(warnings, tc.wantWarnings) {
+				t.Errorf("expected warnings %v, got %v", tc.wantWarnings, warnings)
+			}
+			// 抽出結果は従来のExtractHeadersDKIMと同じであること
+			if legacy := ExtractHeadersDKIM(tc.headers, tc.keys); !reflect.DeepEqual(got, legacy) {
+				t.Errorf("wire order mode differs from ExtractHeadersDKIM: %q != %q", got, legacy)
+			}
+		})
+	}
+}
+
+func TestMessageExtractHeadersDKIMRawHeaders(t *testing.T) {
+	m := &Message{
+		Headers: []string{
+			"Subject: test\r\n",
+			"DKIM-Signature: v=1; h=subject\r\n",
+		},
+		RawHeaders: []string{
+			"Subject:  test\r\n",
+			"DKIM-Signature: v=1;\r\n h=subject\r\n",
+		},
+	}
+	// simpleでは受信したままのRawHeadersから抽出する
+	got, warnings := m.ExtractHeadersDKIM(CanonicalizationSimple, 1, []string{"subject"})
+	if want := []string{"Subject:  test\r\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected headers %q, got %q", want, got)
+	}
+	if want := []HeaderWarning{{Field: "subject", Index: 0, SignatureIndex: 1}}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("expected warnings %v, got %v", want, warnings)
+	}
+	got, _ = m.ExtractHeadersDKIM(CanonicalizationRelaxed, 1, []string{"subject"})
+	if want := []string{"Subject: test\r\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected headers %q, got %q", want, got)
+	}
+}
+
+func TestMessageGetDKIMSignatureRaw(t *testing.T) {
+	first := "DKIM-Signature: v=1; a=rsa-sha256; d=example.com;\n\t s=sel; h=From;  \n\tbh=aaaa; b=bbbb\r\n"
+	second := "dkim-signature:v=1; d=example.net; s=sel;\r\n    b=cccc\r\n"
+	raw := "Received: from a\r\n\tby b\r\n" +
+		first +
+		"From: user@example.com\r\n" +
+		second +
+		"\r\n" +
+		"body\r\n"
+
+	testCases := []struct {
+		name    string
+		message func(t *testing.T) *Message
+		n       int
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "first",
+			message: readTestMessage(raw),
+			n:       0,
+			want:    first,
+		},
+		{
+			name:    "second",
+			message: readTestMessage(raw),
+			n:       1,
+			want:    second,
+		},
+		{
+			name:    "out of range",
+			message: readTestMessage(raw),
+			n:       2,
+			wantErr: ErrDKIMSignatureNotFound,
+		},
+		{
+			name:    "negative",
+			message: readTestMessage(raw),
+			n:       -1,
+			wantErr: ErrDKIMSignatureNotFound,
+		},
+		{
+			name: "headers rewritten after read",
+			message: func(t *testing.T) *Message {
+				m := readTestMessage(raw)(t)
+				m.Headers = append([]string{"DKIM-Signature: v=1; d=example.org\r\n"}, m.Headers...)
+				return m
+			},
+			n:    0,
+			want: first,
+		},
+		{
+			name: "constructed message",
+			message: func(t *testing.T) *Message {
+				return &Message{Headers: []string{"From: user@example.com\r\n", "DKIM-Signature: v=1;\r\n b=dddd\r\n"}}
+			},
+			n:    0,
+			want: "DKIM-Signature: v=1;\r\n b=dddd\r\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.message(t).GetDKIMSignatureRaw(tc.n)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func readTestMessage(raw string) func(t *testing.T) *Message {
+	return func(t *testing.T) *Message {
+		m, err := ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return m
+	}
+}
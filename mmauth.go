@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
 
 	"github.com/masa23/mmauth/arc"
+	"github.com/masa23/mmauth/authres"
 	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
 	"github.com/masa23/mmauth/internal/bodyhash"
-	"github.com/masa23/mmauth/internal/canonical"
 	"github.com/masa23/mmauth/spf"
 )
 
@@ -19,11 +22,20 @@ const (
 	crlf = "\r\n"
 )
 
-type Canonicalization canonical.Canonicalization
+// Canonicalization はdkim.Canonicalizationの別名
+type Canonicalization = dkim.Canonicalization
 
 const (
-	CanonicalizationSimple  Canonicalization = "simple"
-	CanonicalizationRelaxed Canonicalization = "relaxed"
+	CanonicalizationSimple  = dkim.CanonicalizationSimple
+	CanonicalizationRelaxed = dkim.CanonicalizationRelaxed
+)
+
+// FinalCRLF はdkim.FinalCRLFの別名
+type FinalCRLF = dkim.FinalCRLF
+
+const (
+	FinalCRLFPad  = dkim.FinalCRLFPad
+	FinalCRLFAsIs = dkim.FinalCRLFAsIs
 )
 
 // DKIM ARCの署名アルゴリズム
@@ -42,14 +54,14 @@ type AuthenticationHeaders struct {
 	SPFResult      *spf.Result
 }
 
-func parseAuthentications(headers headers) (*AuthenticationHeaders, error) {
-	d, err := dkim.ParseDKIMHeaders(headers)
+func parseAuthentications(headers headers, dkimOpts *dkim.ParseOptions, arcOpts *arc.ParseOptions) (*AuthenticationHeaders, error) {
+	d, err := dkim.ParseDKIMHeadersWithOptions(headers, dkimOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse dkim headers: %v", err)
+		return nil, fmt.Errorf("failed to parse dkim headers: %w", err)
 	}
-	a, err := arc.ParseARCHeaders(headers)
+	a, err := arc.ParseARCHeadersWithOptions(headers, arcOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse arc headers: %v", err)
+		return nil, fmt.Errorf("failed to parse arc headers: %w", err)
 	}
 	return &AuthenticationHeaders{
 		DKIMSignatures: d,
@@ -57,9 +69,11 @@ func parseAuthentications(headers headers) (*AuthenticationHeaders, error) {
 	}, nil
 }
 
+// BodyHashCanonAndAlgo は署名の検証に必要なボディーハッシュの種類を返す
+// dkim.Signatures.ApplyLimitsで検証の対象外にしたDKIM署名は含めない
 func (a *AuthenticationHeaders) BodyHashCanonAndAlgo() []BodyCanonicalizationAndAlgorithm {
 	var ret []BodyCanonicalizationAndAlgorithm
-	for _, dkim := range *a.DKIMSignatures {
+	for _, dkim := range a.DKIMSignatures.WithinLimits() {
 		_, body, err := parseHeaderCanonicalization(dkim.Canonicalization)
 		if err != nil {
 			continue
@@ -99,6 +113,7 @@ func (a *AuthenticationHeaders) BodyHashCanonAndAlgo() []BodyCanonicalizationAnd
 type MMAuth struct {
 	AuthenticationHeaders *AuthenticationHeaders
 	Headers               headers
+	RawHeaders            headers // 受信したままのヘッダ(Headersと同じ位置が同じヘッダに対応する)
 	pw                    *io.PipeWriter
 	pr                    *io.PipeReader
 	pclose                bool
@@ -107,6 +122,64 @@ type MMAuth struct {
 	bodyHashList          []BodyCanonicalizationAndAlgorithm
 	bodyHashed            []BodyHash
 	mutex                 sync.Mutex
+
+	// DMARCLookup はAuthenticateでDMARCレコードを取得する関数
+	// nilの場合はdmarc.LookupRecordWithSubdomainFallbackを使う
+	DMARCLookup func(domain string) (*dmarc.Record, error)
+	// DMARCOptions はAuthenticateでのDMARCの評価オプション
+	// nilの場合はpct=に従ってランダムにポリシーを適用する
+	DMARCOptions *dmarc.EvaluateOptions
+	// PolicyHook はAuthenticateの判定を上書きするためのフック
+	PolicyHook PolicyHook
+	// AuthUser はSMTP AUTHで認証されたユーザー名
+	// milterなどでセッションが認証済みとわかっている場合に設定すると、
+	// 認証結果に auth=pass smtp.auth=<AuthUser> が含まれる
+	AuthUser string
+	// ARCChainPolicy はVerifyでARCチェーンのタイムスタンプを検査する設定
+	// nilの場合は検査しない
+	ARCChainPolicy *arc.ChainPolicy
+	// ARCTrustedSealers はAuthenticateで信頼するARCシーラーのドメイン(ARC-Sealのd=)
+	// DMARCがfailでも、チェーンがpassかつ信頼するシーラーのARC-Authentication-Resultsで
+	// dmarc=passとなっている場合はポリシーを適用せず、local_policy(arc=pass)として記録する
+	ARCTrustedSealers []string
+	// WireFidelity がtrueの場合、Verifyでヘッダの正規化がsimpleの署名は
+	// 行末をCRLFに揃える前の受信したままのヘッダ(RawHeaders)で検証する
+	WireFidelity bool
+	// SPFMapping はAuthenticateでSPFのsoftfail・neutralをRecommendedActionでどう扱うかの設定
+	// DMARCの評価には適用しない。nilの場合はSPFの結果をそのまま使う
+	SPFMapping *SPFMapping
+	// SHA1Policy はVerifyでのDKIMとARCのrsa-sha1の署名の扱い
+	// 空の場合はdomainkey.DefaultSHA1Policy(デフォルトは検証結果に注記を付ける)
+	SHA1Policy domainkey.SHA1Policy
+	// StrictEd25519Keys がtrueの場合、VerifyでPKIX形式で公開されたed25519の鍵による
+	// DKIMとARCの署名をpermerrorにする。falseの場合は検証結果に注記を付ける
+	StrictEd25519Keys bool
+	// DKIMParseOptions とARCParseOptions はDKIM-SignatureとARC-Message-Signatureの
+	// タグの長さの上限。nilの場合はデフォルトの上限を使う
+	// 上限を超えた場合、Closeのエラーはdkim.ErrTagTooLongになる
+	DKIMParseOptions *dkim.ParseOptions
+	ARCParseOptions  *arc.ParseOptions
+	// MaxDKIMSignatures はVerifyで検証するDKIM署名の数の上限(RFC 6376 6.1)
+	// 上限を超えた署名は検証せず、結果をdkim=policyとする。0以下の場合は制限しない
+	// 検証した署名の結果には検証しなかった数の注記を付ける(dkim.Signatures.ApplyLimits)
+	MaxDKIMSignatures int
+	// MaxDKIMSignatureSize はVerifyで検証するDKIM-Signatureヘッダの長さ(バイト)の上限
+	// 上限を超えた署名はMaxDKIMSignaturesと同じく検証しない。0以下の場合は制限しない
+	// どちらも書き込みの前に設定すると、検証しない署名のボディーハッシュを計算しない
+	MaxDKIMSignatureSize int
+	// DKIMAUIDPolicy はVerifyでi=のローカルパートがあるDKIM署名をFromのアドレスと照合する場合の扱い
+	// 空の場合は照合しない(dkim.AUIDPolicy)
+	DKIMAUIDPolicy dkim.AUIDPolicy
+	// FinalCRLF はVerifyでボディーハッシュを計算する際の、改行で終わらない最後の行の扱い
+	// ゼロ値(FinalCRLFPad)はRFC 6376に従いCRLFを補う
+	// ヘッダを読み込んだ時点で使うため、最初のWriteの前に設定する(WithFinalCRLF)
+	FinalCRLF FinalCRLF
+	// Scrub がnilでない場合、ヘッダを読み込んだ直後に自身のauthserv-idを名乗る
+	// Authentication-Resultsを除去してから署名を解析する(ScrubAuthenticationResults)
+	// 最初のWriteの前に設定する
+	Scrub *ScrubOptions
+	// Scrubbed はScrubで除去または名前を変更したヘッダの数
+	Scrubbed int
 }
 
 // 生成すべきBodyHashの種類を追加する
@@ -127,22 +200,31 @@ func (m *MMAuth) parsedMail() {
 
 	// ヘッダの取得
 	buf := bufio.NewReader(m.pr)
-	m.Headers, err = readHeader(buf)
+	m.Headers, m.RawHeaders, err = readHeaderRaw(buf)
 	if err != nil {
 		m.err = err
 		return
 	}
+	// 偽装されたAuthentication-Resultsを検証より前に除去する
+	m.Headers, m.RawHeaders, m.Scrubbed = scrubHeaders(m.Headers, m.RawHeaders, m.Scrub)
 
 	// 署名のヘッダを取得
-	m.AuthenticationHeaders, err = parseAuthentications(m.Headers)
+	m.AuthenticationHeaders, err = parseAuthentications(m.Headers, m.DKIMParseOptions, m.ARCParseOptions)
 	if err != nil {
-		m.err = fmt.Errorf("failed to parse auth headers: %v", err)
+		m.err = fmt.Errorf("failed to parse auth headers: %w", err)
 		return
 	}
 
+	// 上限を超えて検証しないDKIM署名のボディーハッシュは計算しない
+	m.AuthenticationHeaders.DKIMSignatures.ApplyLimits(&dkim.VerifyOptions{
+		MaxSignatures:    m.MaxDKIMSignatures,
+		MaxSignatureSize: m.MaxDKIMSignatureSize,
+	})
+
 	// ヘッダから必要なBodyHashの種類を全て取得しハッシュ生成対象に追加する
 	bca := m.AuthenticationHeaders.BodyHashCanonAndAlgo()
 	for _, bh := range bca {
+		bh.FinalCRLF = m.FinalCRLF
 		m.AddBodyHash(bh)
 	}
 
@@ -194,7 +276,7 @@ func (mh *multiBodyHash) bodyHash(bca []BodyCanonicalizationAndAlgorithm) {
 			*BodyCanonicalizationAndAlgorithm
 			Limit int64
 		}{
-			BodyHash:                         bodyhash.NewBodyHash(canonical.Canonicalization(v.Body), v.Algorithm, v.Limit),
+			BodyHash:                         bodyhash.NewBodyHashWithFinalCRLF(v.Body, v.Algorithm, v.Limit, v.FinalCRLF),
 			BodyCanonicalizationAndAlgorithm: &bca[i],
 			Limit:                            v.Limit,
 		})
@@ -235,7 +317,8 @@ func (mh *multiBodyHash) Get() []BodyHash {
 }
 
 // DKIM、ARCの署名を行うための構造体の初期化
-func NewMMAuth() *MMAuth {
+// optsはメールの解析を始める前に適用する
+func NewMMAuth(opts ...Option) *MMAuth {
 	pr, pw := io.Pipe()
 	done := make(chan struct{})
 	m := &MMAuth{
@@ -243,6 +326,9 @@ func NewMMAuth() *MMAuth {
 		pr:   pr,
 		done: done,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
 
 	// メールデータを読み込んで解析する
 	go m.parsedMail()
@@ -290,57 +376,75 @@ func (m *MMAuth) Verify() {
 	if m.AuthenticationHeaders == nil {
 		return
 	}
-	if m.AuthenticationHeaders.DKIMSignatures != nil {
-		for _, d := range *m.AuthenticationHeaders.DKIMSignatures {
+	if sigs := m.AuthenticationHeaders.DKIMSignatures; sigs != nil {
+		opts := &dkim.VerifyOptions{
+			SHA1Policy:        m.SHA1Policy,
+			StrictEd25519Keys: m.StrictEd25519Keys,
+			MaxSignatures:     m.MaxDKIMSignatures,
+			MaxSignatureSize:  m.MaxDKIMSignatureSize,
+			AUIDPolicy:        m.DKIMAUIDPolicy,
+		}
+		skipped := sigs.ApplyLimits(opts)
+		for _, d := range sigs.WithinLimits() {
 			can := d.GetCanonicalizationAndAlgorithm()
 			if can != nil {
 				bodyHash := m.GetBodyHash(BodyCanonicalizationAndAlgorithm{
-					Body:      Canonicalization(can.Body),
+					Body:      can.Body,
 					Algorithm: can.HashAlgo,
 					Limit:     d.Limit,
+					FinalCRLF: m.FinalCRLF,
 				})
-				d.Verify(m.Headers, bodyHash, nil)
+				d.VerifyResult = d.Evaluate(m.verifyHeaders(can.Header), bodyHash, nil, opts)
 			}
 		}
+		sigs.AnnotateSkipped(skipped)
 	}
 	// ARCの署名を検証する
 	if m.AuthenticationHeaders.ARCSignatures != nil {
 		max := m.AuthenticationHeaders.ARCSignatures.GetMaxInstance()
 		for i := max; i >= 1; i-- {
-			arc := m.AuthenticationHeaders.ARCSignatures.GetInstance(i)
-			if arc == nil {
+			set := m.AuthenticationHeaders.ARCSignatures.GetInstance(i)
+			if set == nil {
 				continue
 			}
-			sign := arc.GetARCMessageSignature()
+			sign := set.GetARCMessageSignature()
 			if sign == nil {
 				continue
 			}
 			can := sign.GetCanonicalizationAndAlgorithm()
 			if can != nil {
 				bodyHash := m.GetBodyHash(BodyCanonicalizationAndAlgorithm{
-					Body:      Canonicalization(can.Body),
+					Body:      can.Body,
 					Algorithm: can.HashAlgo,
 					Limit:     0,
+					FinalCRLF: m.FinalCRLF,
 				})
-				arc.Verify(m.Headers, bodyHash, nil)
+				set.VerifyWithOptions(m.verifyHeaders(can.Header), bodyHash, nil, &arc.VerifyOptions{SHA1Policy: m.SHA1Policy, StrictEd25519Keys: m.StrictEd25519Keys})
 			}
 		}
+		m.AuthenticationHeaders.ARCSignatures.ApplyChainPolicy(m.ARCChainPolicy)
 	}
 }
 
-func evaluateSPF(remoteAddr net.IP, helo, mailFrom string) *spf.Result {
-	result := spf.CheckSPF(remoteAddr, helo, "", helo)
-	// RFC 7208準拠のSPFチェック: まずHELOで評価し、結果がnone/neutralの場合のみMAIL FROMでフォールバック
-	if result.Status == spf.None || result.Status == spf.Neutral {
-		mailFromDomain := helo
-		if mailFrom != "" {
-			if d, err := ParseAddressDomain(mailFrom); err == nil {
-				mailFromDomain = d
-			}
-		}
-		result = spf.CheckSPF(remoteAddr, mailFromDomain, mailFrom, helo)
+// 署名の検証に使うヘッダを返す
+func (m *MMAuth) verifyHeaders(c Canonicalization) headers {
+	if m.WireFidelity && c == CanonicalizationSimple && m.RawHeaders != nil {
+		return m.RawHeaders
 	}
-	return result
+	return m.Headers
+}
+
+// SPFの評価を行い、MAIL FROMの結果と評価したドメイン、HELOのみの評価結果を返す
+// DMARCの識別子にはMAIL FROMの結果を使い、HELOの結果はAuthentication-Resultsにのみ記載する (RFC 7489 3.1.2, 4.1)
+// MAIL FROMが空(バウンス)の場合はHELOの結果をMAIL FROMの結果とし、resultとheloResultは同じ値になる (RFC 7208 2.4)
+func evaluateSPF(remoteAddr net.IP, helo, mailFrom string) (result *spf.Result, domain string, heloResult *spf.Result) {
+	heloResult = spf.CheckHelo(remoteAddr, helo)
+	if s := strings.TrimSpace(mailFrom); s == "" || s == "<>" {
+		return heloResult, helo, heloResult
+	}
+	result = spf.CheckHost(remoteAddr, mailFrom, helo)
+	domain = result.Sender[strings.LastIndex(result.Sender, "@")+1:]
+	return result, domain, heloResult
 }
 
 // 認証結果を配列形式で渡す
@@ -349,11 +453,14 @@ func (m *MMAuth) GetAuthenticationHeader(remoteAddr net.IP, helo, mailFrom strin
 		return nil
 	}
 	// SPFチェックを行う
-	spfResult := evaluateSPF(remoteAddr, helo, mailFrom)
+	spfResult, _, heloResult := evaluateSPF(remoteAddr, helo, mailFrom)
 
 	var results []string
-	if spfResult != nil {
-		results = append(results, fmt.Sprintf("spf=%s smtp.mailfrom=%s smtp.helo=%s", spfResult.Status, mailFrom, helo))
+	if m.AuthUser != "" {
+		results = append(results, authres.SMTPAuth(m.AuthUser).String())
+	}
+	for _, ri := range spfResultInfos(spfResult, heloResult, mailFrom, helo) {
+		results = append(results, ri.String())
 	}
 
 	// DKIM
@@ -378,7 +485,7 @@ func (m *MMAuth) GetAuthenticationHeader(remoteAddr net.IP, helo, mailFrom strin
 
 func (m *MMAuth) GetBodyHash(bca BodyCanonicalizationAndAlgorithm) string {
 	for _, bh := range m.bodyHashed {
-		if bh.Algorithm.Algorithm == bca.Algorithm && bh.Algorithm.Body == bca.Body && bh.Limit == bca.Limit {
+		if bh.Algorithm.Algorithm == bca.Algorithm && bh.Algorithm.Body == bca.Body && bh.Limit == bca.Limit && bh.Algorithm.FinalCRLF == bca.FinalCRLF {
 			return bh.BodyHash
 		}
 	}
@@ -0,0 +1,45 @@
+package msgcat
+
+// japanese はdkim・arcパッケージの検証結果のメッセージの日本語のカタログ
+var japanese = Catalog{
+	testModeSuffix: "(テストモード)",
+
+	// 共通
+	"good signature":                                "署名は正しい",
+	"invalid signature":                             "署名が一致しない",
+	"body hash is not match":                        "本文のハッシュが一致しない",
+	"invalid public key":                            "公開鍵が不正",
+	"domain key is not found":                       "公開鍵のレコードが見つからない",
+	"domain key record contains control characters": "公開鍵のレコードに制御文字が含まれている",
+	"failed to lookup domain key":                   "公開鍵のレコードを取得できない",
+	"non-standard ed25519 key encoding":             "ed25519の公開鍵が標準外の形式で公開されている",
+	"rsa-sha1 is not allowed":                       "rsa-sha1は許可されていない",
+
+	// DKIM
+	"unsupported algorithm":                                 "対応していないアルゴリズム",
+	"service type is invalid":                               "公開鍵のサービスの種類が不正",
+	"signature is not found":                                "署名が見つからない",
+	"version is invalid":                                    "バージョンが不正",
+	"identity does not match key granularity":               "署名者のIDが公開鍵のg=に一致しない",
+	"signature is expired":                                  "署名の有効期限が切れている",
+	"signature timestamp is greater than expiration":        "署名の作成日時が有効期限より後",
+	"signature timestamp is in the future":                  "署名の作成日時が未来",
+	"malformed public key":                                  "公開鍵の形式が不正",
+	"public key is too small":                               "公開鍵の長さが足りない",
+	"duplicate singleton header":                            "署名されていない重複したヘッダがある",
+	"identity does not match From address":                  "署名者のID(i=)がFromのアドレスに一致しない",
+	"too many signatures":                                   "署名が多すぎるため検証しない",
+	"signature too large":                                   "署名が大きすぎるため検証しない",
+	"signature hash algorithm is not allowed by domain key": "署名のハッシュアルゴリズムが公開鍵で許可されていない",
+	"signature key type is not allowed by domain key":       "署名の鍵の種類が公開鍵で許可されていない",
+	"identity domain is not allowed by strict domain key":   "署名者のIDのドメインが公開鍵(t=s)で許可されていない",
+
+	// ARC
+	"arc is not found":                  "ARCが見つからない",
+	"seal is not found":                 "ARC-Sealが見つからない",
+	"sign is not found":                 "ARC-Message-Signatureが見つからない",
+	"ARC-Seal is found":                 "ARC-Message-Signatureの署名対象にARC-Sealが含まれている",
+	"chain validation result is fail":   "チェーンの検証結果(cv=)がfail",
+	"chain is too old":                  "チェーンが古すぎる",
+	"seal timestamps are not monotonic": "ARC-Sealの作成日時が順に並んでいない",
+}
@@ -0,0 +1,88 @@
+// Package msgcat は検証結果のメッセージ(dkim.VerifyResult.Messageなど)を
+// ログに出力する言語に翻訳するためのカタログ
+//
+// メッセージは英語で作られ、Authentication-Resultsのコメントにもそのまま使われるため、
+// 翻訳はログなど人が読むための出力に限る。英語が既定で、日本語のカタログを含む。
+// カタログにないメッセージ(ヘッダ名などを含むもの)は英語のまま返す。
+package msgcat
+
+import (
+	"strings"
+	"sync"
+)
+
+// Language はメッセージの言語 (BCP 47の言語タグ)
+type Language string
+
+const (
+	English  Language = "en"
+	Japanese Language = "ja"
+)
+
+// Catalog は英語のメッセージから翻訳したメッセージへの対応
+type Catalog map[string]string
+
+// testModeSuffix は鍵がテストモード(t=y)の場合にメッセージの末尾に付く文字列
+const testModeSuffix = " test mode"
+
+var (
+	mu       sync.RWMutex
+	language = English
+	catalogs = map[Language]Catalog{
+		Japanese: japanese,
+	}
+)
+
+// SetLanguage はLanguageを指定しない翻訳(Translateにlangとして空を渡した場合)の言語を設定する
+// ログの言語をアプリケーション全体でそろえるために起動時に一度呼ぶ
+func SetLanguage(lang Language) {
+	mu.Lock()
+	defer mu.Unlock()
+	language = lang
+}
+
+// DefaultLanguage はSetLanguageで設定した言語を返す。設定していない場合はEnglish
+func DefaultLanguage() Language {
+	mu.RLock()
+	defer mu.RUnlock()
+	return language
+}
+
+// Register はlangのカタログを登録する
+// 同じ言語のカタログがある場合はcの項目で上書きし、含まれていない項目は残す
+func Register(lang Language, c Catalog) {
+	mu.Lock()
+	defer mu.Unlock()
+	merged := make(Catalog, len(catalogs[lang])+len(c))
+	for k, v := range catalogs[lang] {
+		merged[k] = v
+	}
+	for k, v := range c {
+		merged[k] = v
+	}
+	catalogs[lang] = merged
+}
+
+// Translate は英語のメッセージmsgをlangに翻訳する
+// langが空の場合はDefaultLanguage、Englishやカタログにないメッセージはmsgをそのまま返す
+// テストモードの鍵による " test mode" の接尾辞は分けて翻訳する
+func Translate(lang Language, msg string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if lang == "" {
+		lang = language
+	}
+	c := catalogs[lang]
+	if lang == English || c == nil {
+		return msg
+	}
+	if t, ok := c[msg]; ok {
+		return t
+	}
+	if base := strings.TrimSuffix(msg, testModeSuffix); base != msg {
+		if t, ok := c[base]; ok {
+			return t + c[testModeSuffix]
+		}
+	}
+	return msg
+}
@@ -0,0 +1,49 @@
+package msgcat
+
+import "testing"
+
+func TestTranslate(t *testing.T) {
+	testCases := []struct {
+		name string
+		lang Language
+		msg  string
+		want string
+	}{
+		{name: "english", lang: English, msg: "good signature", want: "good signature"},
+		{name: "japanese", lang: Japanese, msg: "good signature", want: "署名は正しい"},
+		{name: "test mode", lang: Japanese, msg: "invalid signature test mode", want: "署名が一致しない(テストモード)"},
+		{name: "not in catalog", lang: Japanese, msg: "forbidden header From found in h= tag", want: "forbidden header From found in h= tag"},
+		{name: "unknown language", lang: "fr", msg: "good signature", want: "good signature"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Translate(tc.lang, tc.msg); got != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultLanguage(t *testing.T) {
+	t.Cleanup(func() { SetLanguage(English) })
+
+	if got := Translate("", "good signature"); got != "good signature" {
+		t.Errorf("want %q, but got %q", "good signature", got)
+	}
+	SetLanguage(Japanese)
+	if got := Translate("", "good signature"); got != "署名は正しい" {
+		t.Errorf("want %q, but got %q", "署名は正しい", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const lang Language = "x-test"
+	Register(lang, Catalog{"good signature": "ok"})
+	Register(lang, Catalog{"invalid signature": "ng"})
+	if got := Translate(lang, "good signature"); got != "ok" {
+		t.Errorf("want %q, but got %q", "ok", got)
+	}
+	if got := Translate(lang, "invalid signature"); got != "ng" {
+		t.Errorf("want %q, but got %q", "ng", got)
+	}
+}
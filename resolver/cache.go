@@ -0,0 +1,178 @@
+// Package resolver はDKIM・SPF・DMARCで共有できるTXTレコードのキャッシュと、
+// よく使うレコードを期限切れ前に更新するPrefetcherを提供する
+//
+// TXTCacheはdomainkey.TXTResolverを満たし、LookupFuncでspf.DefaultTXTResolverや
+// dmarc.DefaultResolverに設定できる関数を返す。
+//
+// SplitHorizon・Failover・RetryResolverのInstallは、spf・dmarc・domainkeyの
+// パッケージ変数を同期せずに書き換える。問い合わせの途中で呼ぶとデータ競合になるため、
+// 起動時に問い合わせを始める前に一度だけ呼ぶ。返された関数で元に戻すのも、
+// 問い合わせがない状態(テストの終了時など)で行う。
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/masa23/mmauth/internal/ttlcache"
+)
+
+// LookupFunc はTTL付きでTXTレコードを問い合わせる関数
+type LookupFunc func(ctx context.Context, name string) ([]string, time.Duration, error)
+
+const (
+	// DefaultTTL はTTLが得られない場合に肯定応答をキャッシュする期間
+	DefaultTTL = 5 * time.Minute
+	// DefaultNegativeTTL はレコードが存在しない場合にキャッシュする期間
+	DefaultNegativeTTL = time.Minute
+	// DefaultLookupTimeout はLookupFuncで返す関数の問い合わせのタイムアウト
+	DefaultLookupTimeout = 5 * time.Second
+	// DefaultMaxEntries はキャッシュする名前の数の上限
+	DefaultMaxEntries = 10000
+)
+
+type txtEntry struct {
+	records []string
+	err     error
+}
+
+// TXTCache はTXTレコードの問い合わせ結果をキャッシュする
+// レコードが存在しない応答も期限付きで保持し、一時的な失敗はキャッシュしない
+// 問い合わせる名前は受信したメッセージのセレクタやドメインで決まるため、
+// MaxEntriesに達した場合は期限切れのエントリを削除してから最も早く期限が切れるものを削除する
+type TXTCache struct {
+	// Lookup はTTL付きの問い合わせ関数。nilの場合はnet.DefaultResolverを使い、TTLにはDefaultTTLを使う
+	Lookup LookupFunc
+	// DefaultTTL はLookupがTTLを返さない(0以下)場合の肯定応答のキャッシュ期間
+	DefaultTTL time.Duration
+	// NegativeTTL はレコードが存在しない場合のキャッシュ期間
+	NegativeTTL time.Duration
+	// MaxTTL はDNSから得たTTLの上限。0の場合は制限しない
+	MaxTTL time.Duration
+	// Retry は一時的な失敗に対する再試行の設定。nilの場合は再試行しない
+	// TXT以外の問い合わせも再試行する場合はRetryResolverを使う
+	Retry *RetryPolicy
+	// MaxEntries はキャッシュする名前の数の上限。0以下の場合はDefaultMaxEntries
+	MaxEntries int
+
+	entries ttlcache.Cache[string, *txtEntry]
+	now     func() time.Time
+}
+
+// NewTXTCache はデフォルトのTTLでTXTCacheを作成する
+func NewTXTCache() *TXTCache {
+	return &TXTCache{
+		DefaultTTL:  DefaultTTL,
+		NegativeTTL: DefaultNegativeTTL,
+		MaxEntries:  DefaultMaxEntries,
+	}
+}
+
+// LookupTXT はキャッシュを使ってnameのTXTレコードを問い合わせる
+// domainkey.TXTResolverを満たす
+func (c *TXTCache) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	key := normalizeName(name)
+	if e, ok := c.entries.Get(key, c.clock()); ok {
+		if e.err != nil {
+			return nil, e.err
+		}
+		return append([]string(nil), e.records...), nil
+	}
+
+	e, err := c.fetch(ctx, key)
+	if e != nil {
+		return append([]string(nil), e.records...), e.err
+	}
+	return nil, err
+}
+
+// LookupFunc はキャッシュを使う func(name string) ([]string, error) を返す
+// spf.DefaultTXTResolver や dmarc.DefaultResolver に設定して使う
+func (c *TXTCache) LookupFunc() func(name string) ([]string, error) {
+	return func(name string) ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultLookupTimeout)
+		defer cancel()
+		return c.LookupTXT(ctx, name)
+	}
+}
+
+// Refresh はキャッシュの有無にかかわらずnameを問い合わせてキャッシュを更新する
+// 一時的な失敗の場合は既存のエントリを残す
+func (c *TXTCache) Refresh(ctx context.Context, name string) error {
+	_, err := c.fetch(ctx, normalizeName(name))
+	return err
+}
+
+// Expires はnameのキャッシュの有効期限を返す
+// キャッシュされていない場合はfalseを返す
+func (c *TXTCache) Expires(name string) (time.Time, bool) {
+	return c.entries.Expires(normalizeName(name))
+}
+
+// Purge はキャッシュをすべて破棄する
+func (c *TXTCache) Purge() {
+	c.entries.Purge()
+}
+
+// Len はキャッシュされている名前の数を返す
+func (c *TXTCache) Len() int {
+	return c.entries.Len()
+}
+
+// fetch は問い合わせを行い、キャッシュできる結果であれば保存してエントリを返す
+// 一時的な失敗の場合はnilのエントリとエラーを返す
+func (c *TXTCache) fetch(ctx context.Context, key string) (*txtEntry, error) {
+	lookup := c.Lookup
+	if lookup == nil {
+		lookup = systemLookup
+	}
+	records, ttl, err := c.Retry.Wrap(lookup)(ctx, key)
+
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, err
+		}
+		ttl = c.NegativeTTL
+	} else if ttl <= 0 {
+		ttl = c.DefaultTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+
+	e := &txtEntry{records: records, err: err}
+	if ttl > 0 {
+		now := c.clock()
+		c.entries.Set(key, e, now.Add(ttl), now, c.maxEntries())
+	}
+	return e, err
+}
+
+func (c *TXTCache) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return DefaultMaxEntries
+	}
+	return c.MaxEntries
+}
+
+// systemLookup はnet.DefaultResolverで問い合わせる。TTLは得られないため0を返す
+func systemLookup(ctx context.Context, name string) ([]string, time.Duration, error) {
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	return records, 0, err
+}
+
+func (c *TXTCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// normalizeName はキャッシュのキー用に名前を小文字化し、末尾のドットを除去する
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,226 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailoverTimeout はFailoverで1つのリゾルバーの応答を待つ時間
+	DefaultFailoverTimeout = 2 * time.Second
+	// DefaultMaxFailures はリゾルバーを停止中とみなす連続した失敗の回数
+	DefaultMaxFailures = 3
+	// DefaultFailoverCooldown は停止中のリゾルバーを後回しにする期間
+	DefaultFailoverCooldown = 30 * time.Second
+)
+
+// ErrNoResolvers はFailoverにリゾルバーが設定されていない場合のエラー
+var ErrNoResolvers = errors.New("resolver: no resolvers configured")
+
+// ResolverHealth はFailoverの各リゾルバーの状態
+type ResolverHealth struct {
+	// Successes は応答を得た問い合わせの数(レコードが存在しない応答を含む)
+	Successes uint64
+	// Failures はタイムアウトやSERVFAILで次のリゾルバーに切り替えた問い合わせの数
+	Failures uint64
+	// ConsecutiveFailures は最後に応答を得てからの連続した失敗の数
+	ConsecutiveFailures int
+	// LastError は最後の失敗のエラー
+	LastError error
+	// LastFailure は最後に失敗した時刻
+	LastFailure time.Time
+	// Down は停止中とみなして後回しにしているか
+	Down bool
+}
+
+// Failover は順に並べた複数のリゾルバーに問い合わせ、タイムアウトやSERVFAILの場合に
+// 次のリゾルバーに切り替える
+// ローカルのキャッシュサーバーが止まった場合に公開のDNSで解決するなど、
+// 1つのリゾルバーが単一障害点になるのを避けるために使う
+// MaxFailures回続けて失敗したリゾルバーはCooldownの間は停止中とみなして最後に回し、
+// 期間が過ぎた後の問い合わせで応答を得ると元の順序に戻す
+// レコードが存在しない応答やctxのキャンセルでは切り替えない
+type Failover struct {
+	// Resolvers は問い合わせる順に並べたリゾルバー
+	Resolvers []DNSResolver
+	// Timeout は1つのリゾルバーの応答を待つ時間。0以下の場合はDefaultFailoverTimeout
+	Timeout time.Duration
+	// MaxFailures は停止中とみなす連続した失敗の回数。0以下の場合はDefaultMaxFailures
+	MaxFailures int
+	// Cooldown は停止中のリゾルバーを後回しにする期間。0以下の場合はDefaultFailoverCooldown
+	Cooldown time.Duration
+
+	mu     sync.Mutex
+	health []ResolverHealth
+	now    func() time.Time
+}
+
+// NewFailover はresolversの順に問い合わせるFailoverを作成する
+func NewFailover(resolvers ...DNSResolver) *Failover {
+	return &Failover{Resolvers: resolvers}
+}
+
+func (f *Failover) timeout() time.Duration {
+	if f.Timeout > 0 {
+		return f.Timeout
+	}
+	return DefaultFailoverTimeout
+}
+
+func (f *Failover) maxFailures() int {
+	if f.MaxFailures > 0 {
+		return f.MaxFailures
+	}
+	return DefaultMaxFailures
+}
+
+func (f *Failover) cooldown() time.Duration {
+	if f.Cooldown > 0 {
+		return f.Cooldown
+	}
+	return DefaultFailoverCooldown
+}
+
+func (f *Failover) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// isDown はf.muを保持して呼ぶ
+func (f *Failover) isDown(h *ResolverHealth, now time.Time) bool {
+	return h.ConsecutiveFailures >= f.maxFailures() && now.Before(h.LastFailure.Add(f.cooldown()))
+}
+
+// Health は各リゾルバーの状態をResolversと同じ順に返す
+func (f *Failover) Health() []ResolverHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.clock()
+	ret := make([]ResolverHealth, len(f.Resolvers))
+	for i := range ret {
+		if i < len(f.health) {
+			ret[i] = f.health[i]
+		}
+		ret[i].Down = f.isDown(&ret[i], now)
+	}
+	return ret
+}
+
+// order は問い合わせる順を返す。停止中のリゾルバーは最後に回す
+func (f *Failover) order() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.health) < len(f.Resolvers) {
+		f.health = append(f.health, ResolverHealth{})
+	}
+	now := f.clock()
+	up := make([]int, 0, len(f.Resolvers))
+	var down []int
+	for i := range f.Resolvers {
+		if f.isDown(&f.health[i], now) {
+			down = append(down, i)
+		} else {
+			up = append(up, i)
+		}
+	}
+	return append(up, down...)
+}
+
+func (f *Failover) record(i int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := &f.health[i]
+	if err == nil {
+		h.Successes++
+		h.ConsecutiveFailures = 0
+		return
+	}
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.LastError = err
+	h.LastFailure = f.clock()
+}
+
+// do はlookupを成功するか切り替えの対象でない失敗になるまで順にリゾルバーで実行する
+// すべてのリゾルバーで失敗した場合は最後のエラーを返す
+func (f *Failover) do(ctx context.Context, lookup func(ctx context.Context, r DNSResolver) error) error {
+	err := ErrNoResolvers
+	for _, i := range f.order() {
+		if ctx.Err() != nil {
+			return err
+		}
+		actx, cancel := context.WithTimeout(ctx, f.timeout())
+		err = lookup(actx, f.Resolvers[i])
+		cancel()
+		switch ClassifyError(err) {
+		case ErrorKindTimeout, ErrorKindServFail:
+			// 呼び出し元のctxが終了した場合はリゾルバーの失敗とはみなさない
+			if ctx.Err() != nil {
+				return err
+			}
+			f.record(i, err)
+			continue
+		case ErrorKindOther:
+			return err
+		}
+		f.record(i, nil)
+		return err
+	}
+	return err
+}
+
+// LookupTXT はTXTレコードを順にリゾルバーに問い合わせる
+func (f *Failover) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	var ret []string
+	err := f.do(ctx, func(ctx context.Context, r DNSResolver) (err error) {
+		ret, err = r.LookupTXT(ctx, name)
+		return err
+	})
+	return ret, err
+}
+
+// LookupIP はアドレスを順にリゾルバーに問い合わせる
+func (f *Failover) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var ret []net.IP
+	err := f.do(ctx, func(ctx context.Context, r DNSResolver) (err error) {
+		ret, err = r.LookupIP(ctx, network, host)
+		return err
+	})
+	return ret, err
+}
+
+// LookupMX はMXレコードを順にリゾルバーに問い合わせる
+func (f *Failover) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	var ret []*net.MX
+	err := f.do(ctx, func(ctx context.Context, r DNSResolver) (err error) {
+		ret, err = r.LookupMX(ctx, name)
+		return err
+	})
+	return ret, err
+}
+
+// LookupAddr はPTRレコードを順にリゾルバーに問い合わせる
+func (f *Failover) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	var ret []string
+	err := f.do(ctx, func(ctx context.Context, r DNSResolver) (err error) {
+		ret, err = r.LookupAddr(ctx, addr)
+		return err
+	})
+	return ret, err
+}
+
+// Install はSPF・DMARC・DKIM・ARCの既定の問い合わせがすべてfを使うよう設定し、
+// 元に戻す関数を返す。問い合わせを始める前に呼ぶ(パッケージの説明を参照)
+// 問い合わせ全体のタイムアウトは、すべてのリゾルバーをTimeoutまで待てる長さにする
+func (f *Failover) Install() (restore func()) {
+	n := len(f.Resolvers)
+	if n == 0 {
+		n = 1
+	}
+	return install(f, f.timeout()*time.Duration(n))
+}
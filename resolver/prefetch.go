@@ -0,0 +1,184 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultPrefetchInterval はPrefetcherが更新対象を確認する間隔
+	DefaultPrefetchInterval = 30 * time.Second
+	// DefaultRefreshBefore は有効期限のどれだけ前に更新するか
+	DefaultRefreshBefore = time.Minute
+	// DefaultPrefetchConcurrency は同時に行う問い合わせ数
+	DefaultPrefetchConcurrency = 4
+)
+
+// Prefetcher は登録された名前のTXTレコードを有効期限が切れる前にバックグラウンドで更新し、
+// メッセージ処理中の問い合わせがキャッシュに当たるようにする
+//
+// SPFはAddSPFで登録したドメインのレコードのみを更新し、include等で参照される
+// ドメインは個別に登録する必要がある。
+type Prefetcher struct {
+	Cache *TXTCache
+	// Interval は更新対象を確認する間隔。0以下の場合はDefaultPrefetchInterval
+	Interval time.Duration
+	// RefreshBefore は有効期限のどれだけ前から更新対象とするか。0以下の場合はDefaultRefreshBefore
+	RefreshBefore time.Duration
+	// Concurrency は同時に行う問い合わせ数。0以下の場合はDefaultPrefetchConcurrency
+	Concurrency int
+
+	mu     sync.Mutex
+	names  map[string]struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPrefetcher はcacheを更新するPrefetcherを作成する
+func NewPrefetcher(cache *TXTCache) *Prefetcher {
+	return &Prefetcher{
+		Cache:         cache,
+		Interval:      DefaultPrefetchInterval,
+		RefreshBefore: DefaultRefreshBefore,
+		Concurrency:   DefaultPrefetchConcurrency,
+	}
+}
+
+// Add はTXTレコードの名前を更新対象に登録する
+func (p *Prefetcher) Add(name string) {
+	name = normalizeName(name)
+	if name == "" {
+		return
+	}
+	p.mu.Lock()
+	if p.names == nil {
+		p.names = make(map[string]struct{})
+	}
+	p.names[name] = struct{}{}
+	p.mu.Unlock()
+}
+
+// AddDKIM はDKIMの公開鍵レコード(selector._domainkey.domain)を登録する
+func (p *Prefetcher) AddDKIM(selector, domain string) {
+	p.Add(selector + "._domainkey." + domain)
+}
+
+// AddSPF はdomainのSPFレコードを登録する
+func (p *Prefetcher) AddSPF(domain string) {
+	p.Add(domain)
+}
+
+// AddDMARC はdomainのDMARCレコード(_dmarc.domain)を登録する
+func (p *Prefetcher) AddDMARC(domain string) {
+	p.Add("_dmarc." + domain)
+}
+
+// Remove は名前を更新対象から外す
+func (p *Prefetcher) Remove(name string) {
+	p.mu.Lock()
+	delete(p.names, normalizeName(name))
+	p.mu.Unlock()
+}
+
+// Names は登録されている名前を返す
+func (p *Prefetcher) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := make([]string, 0, len(p.names))
+	for n := range p.names {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// RunOnce はキャッシュされていないか、有効期限がRefreshBefore以内の名前を更新する
+// 実際に更新できた名前の数を返し、途中でctxが終了した場合はctx.Err()も返す
+func (p *Prefetcher) RunOnce(ctx context.Context) (int, error) {
+	before := p.RefreshBefore
+	if before <= 0 {
+		before = DefaultRefreshBefore
+	}
+	deadline := p.Cache.clock().Add(before)
+	var due []string
+	for _, name := range p.Names() {
+		if exp, ok := p.Cache.Expires(name); ok && exp.After(deadline) {
+			continue
+		}
+		due = append(due, name)
+	}
+
+	n := p.Concurrency
+	if n <= 0 {
+		n = DefaultPrefetchConcurrency
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	var refreshed int64
+	for _, name := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if p.Cache.Refresh(ctx, name) == nil {
+				atomic.AddInt64(&refreshed, 1)
+			}
+		}(name)
+	}
+	wg.Wait()
+	return int(refreshed), ctx.Err()
+}
+
+// Start は登録済みの名前を一度更新してから、バックグラウンドでの更新を開始する
+// ctxがキャンセルされるかStopが呼ばれるまで続く
+func (p *Prefetcher) Start(ctx context.Context) {
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	p.cancel = cancel
+	p.done = done
+	p.mu.Unlock()
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultPrefetchInterval
+	}
+	p.RunOnce(ctx)
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// Stop はバックグラウンドでの更新を停止し、終了を待つ
+func (p *Prefetcher) Stop() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,693 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/spf"
+)
+
+type countingLookup struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	records map[string][]string
+	ttl     time.Duration
+	err     error
+}
+
+func (l *countingLookup) lookup(ctx context.Context, name string) ([]string, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.calls == nil {
+		l.calls = make(map[string]int)
+	}
+	l.calls[name]++
+	if l.err != nil {
+		return nil, 0, l.err
+	}
+	if r, ok := l.records[name]; ok {
+		return r, l.ttl, nil
+	}
+	return nil, 0, &net.DNSError{IsNotFound: true}
+}
+
+func (l *countingLookup) count(name string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls[name]
+}
+
+func TestTXTCacheLookupTXT(t *testing.T) {
+	testCases := []struct {
+		name      string
+		records   map[string][]string
+		err       error
+		query     string
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			name:      "positive result is cached",
+			records:   map[string][]string{"example.jp": {"v=spf1 -all"}},
+			query:     "Example.JP.",
+			wantCalls: 1,
+		},
+		{
+			name:      "negative result is cached",
+			records:   map[string][]string{},
+			query:     "example.jp",
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "temporary failure is not cached",
+			err:       &net.DNSError{IsTimeout: true},
+			query:     "example.jp",
+			wantErr:   true,
+			wantCalls: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &countingLookup{records: tc.records, err: tc.err, ttl: time.Hour}
+			c := NewTXTCache()
+			c.Lookup = l.lookup
+			for i := 0; i < 2; i++ {
+				got, err := c.LookupTXT(context.Background(), tc.query)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				if err == nil && !reflect.DeepEqual(got, tc.records["example.jp"]) {
+					t.Errorf("unexpected records: %v", got)
+				}
+			}
+			if n := l.count("example.jp"); n != tc.wantCalls {
+				t.Errorf("expected %d lookups, got %d", tc.wantCalls, n)
+			}
+		})
+	}
+}
+
+func TestTXTCacheRefreshKeepsEntryOnTemporaryFailure(t *testing.T) {
+	l := &countingLookup{records: map[string][]string{"example.jp": {"v=spf1 -all"}}, ttl: time.Hour}
+	c := NewTXTCache()
+	c.Lookup = l.lookup
+	if _, err := c.LookupTXT(context.Background(), "example.jp"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.err = errors.New("timeout")
+	if err := c.Refresh(context.Background(), "example.jp"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if got, err := c.LookupTXT(context.Background(), "example.jp"); err != nil || len(got) != 1 {
+		t.Errorf("expected cached record, got %v, %v", got, err)
+	}
+}
+
+func TestTXTCacheMaxEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := &countingLookup{records: map[string][]string{}, ttl: time.Hour}
+	c := NewTXTCache()
+	c.Lookup = l.lookup
+	c.NegativeTTL = time.Minute
+	c.MaxEntries = 3
+	c.now = func() time.Time { return now }
+
+	// 存在しない名前は期限付きでキャッシュされ、上限を超えない
+	for i := 0; i < 10; i++ {
+		c.LookupTXT(context.Background(), fmt.Sprintf("sel%d._domainkey.example.jp", i))
+		now = now.Add(time.Second)
+	}
+	if n := c.Len(); n != 3 {
+		t.Errorf("expected 3 entries, got %d", n)
+	}
+	// 最も早く期限が切れるものから削除する
+	if _, ok := c.Expires("sel0._domainkey.example.jp"); ok {
+		t.Errorf("expected the oldest entry to be evicted")
+	}
+	if _, ok := c.Expires("sel9._domainkey.example.jp"); !ok {
+		t.Errorf("expected the newest entry to be kept")
+	}
+
+	// 期限切れのエントリは次の追加でまとめて削除する
+	now = now.Add(time.Hour)
+	c.LookupTXT(context.Background(), "example.jp")
+	if n := c.Len(); n != 1 {
+		t.Errorf("expected expired entries to be purged, got %d entries", n)
+	}
+}
+
+func TestPrefetcherRunOnce(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := &countingLookup{
+		records: map[string][]string{
+			"s1._domainkey.example.jp": {"v=DKIM1; p=abc"},
+			"example.jp":               {"v=spf1 -all"},
+			"_dmarc.example.jp":        {"v=DMARC1; p=reject"},
+		},
+		ttl: 10 * time.Minute,
+	}
+	c := NewTXTCache()
+	c.Lookup = l.lookup
+	c.now = func() time.Time { return now }
+
+	p := NewPrefetcher(c)
+	p.AddDKIM("s1", "example.jp")
+	p.AddSPF("example.jp")
+	p.AddDMARC("Example.JP")
+
+	// 未キャッシュの名前はすべて更新される
+	if n, err := p.RunOnce(context.Background()); n != 3 || err != nil {
+		t.Fatalf("expected 3 refreshed names, got %d (%v)", n, err)
+	}
+	// 有効期限まで十分あるので更新されない
+	if n, _ := p.RunOnce(context.Background()); n != 0 {
+		t.Errorf("expected no refresh, got %d", n)
+	}
+	// 有効期限の1分前を切ったので更新される
+	now = now.Add(9*time.Minute + 30*time.Second)
+	if n, _ := p.RunOnce(context.Background()); n != 3 {
+		t.Errorf("expected 3 refreshed names, got %d", n)
+	}
+	// メッセージ処理からの問い合わせはキャッシュに当たる
+	if _, err := c.LookupFunc()("_dmarc.example.jp"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := l.count("_dmarc.example.jp"); n != 2 {
+		t.Errorf("expected 2 lookups, got %d", n)
+	}
+	// 終了したctxでは更新せずにエラーを返す
+	now = now.Add(10 * time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := p.RunOnce(ctx); n != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected no refresh and %v, got %d (%v)", context.Canceled, n, err)
+	}
+}
+
+func TestPrefetcherStartStop(t *testing.T) {
+	l := &countingLookup{records: map[string][]string{"example.jp": {"v=spf1 -all"}}, ttl: time.Hour}
+	c := NewTXTCache()
+	c.Lookup = l.lookup
+	p := NewPrefetcher(c)
+	p.AddSPF("example.jp")
+	p.Start(context.Background())
+	p.Stop()
+	if l.count("example.jp") != 1 {
+		t.Errorf("expected initial refresh on start, got %d", l.count("example.jp"))
+	}
+	// 二重に停止しても問題ない
+	p.Stop()
+}
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{err: nil, want: ErrorKindNone},
+		{err: &net.DNSError{IsNotFound: true}, want: ErrorKindNotFound},
+		{err: &net.DNSError{IsTimeout: true, IsTemporary: true}, want: ErrorKindTimeout},
+		{err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}, want: ErrorKindServFail},
+		{err: context.DeadlineExceeded, want: ErrorKindTimeout},
+		{err: context.Canceled, want: ErrorKindOther},
+		{err: errors.New("boom"), want: ErrorKindOther},
+	}
+	for _, tc := range testCases {
+		if got := ClassifyError(tc.err); got != tc.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	timeout := &net.DNSError{IsTimeout: true, IsTemporary: true}
+	servfail := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+	// failures回失敗した後に成功する
+	flaky := func(failErr error, failures int) (LookupFunc, *int) {
+		calls := 0
+		return func(ctx context.Context, name string) ([]string, time.Duration, error) {
+			calls++
+			if calls <= failures {
+				return nil, 0, failErr
+			}
+			return []string{"v=spf1 -all"}, time.Minute, nil
+		}, &calls
+	}
+
+	testCases := []struct {
+		name      string
+		policy    *RetryPolicy
+		failErr   error
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "nil policy", failErr: timeout, failures: 1, wantCalls: 1, wantErr: true},
+		{name: "retry timeout", policy: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, RetryOnTimeout: true}, failErr: timeout, failures: 2, wantCalls: 3},
+		{name: "attempts exhausted", policy: &RetryPolicy{Attempts: 2, Backoff: time.Millisecond, RetryOnTimeout: true}, failErr: timeout, failures: 5, wantCalls: 2, wantErr: true},
+		{name: "servfail not retried", policy: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, RetryOnTimeout: true}, failErr: servfail, failures: 1, wantCalls: 1, wantErr: true},
+		{name: "retry servfail", policy: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, RetryOnServFail: true}, failErr: servfail, failures: 1, wantCalls: 2},
+		{name: "not found never retried", policy: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, RetryOnTimeout: true, RetryOnServFail: true}, failErr: &net.DNSError{IsNotFound: true}, failures: 1, wantCalls: 1, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookup, calls := flaky(tc.failErr, tc.failures)
+			_, _, err := tc.policy.Wrap(lookup)(context.Background(), "example.jp")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if *calls != tc.wantCalls {
+				t.Errorf("want %d calls, but got %d", tc.wantCalls, *calls)
+			}
+		})
+	}
+
+	// 待っている間にctxが終了した場合は再試行しない
+	lookup, calls := flaky(timeout, 5)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	p := &RetryPolicy{Attempts: 5, Backoff: time.Second, RetryOnTimeout: true}
+	if _, _, err := p.Wrap(lookup)(ctx, "example.jp"); err == nil || *calls != 1 {
+		t.Errorf("want a single failed call, but got %d calls (%v)", *calls, err)
+	}
+
+	// バックオフは倍になり、上限で止まる
+	p = &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if got := p.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestTXTCacheRetry(t *testing.T) {
+	calls := 0
+	c := NewTXTCache()
+	c.Retry = &RetryPolicy{Attempts: 2, Backoff: time.Millisecond, RetryOnServFail: true}
+	c.Lookup = func(ctx context.Context, name string) ([]string, time.Duration, error) {
+		calls++
+		if calls == 1 {
+			return nil, 0, &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+		}
+		return []string{"v=DMARC1; p=none"}, time.Minute, nil
+	}
+	got, err := c.LookupTXT(context.Background(), "_dmarc.example.jp")
+	if err != nil || !reflect.DeepEqual(got, []string{"v=DMARC1; p=none"}) {
+		t.Errorf("unexpected result: %v, %v", got, err)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls, but got %d", calls)
+	}
+}
+
+type namedResolver struct {
+	name string
+}
+
+func (r namedResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return []string{r.name}, nil
+}
+
+func (r namedResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return nil, &net.DNSError{Err: r.name, IsNotFound: true}
+}
+
+func (r namedResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return []*net.MX{{Host: r.name}}, nil
+}
+
+func (r namedResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return []string{r.name}, nil
+}
+
+func TestSplitHorizon(t *testing.T) {
+	s := NewSplitHorizon(namedResolver{"public"})
+	s.Route("corp.example", namedResolver{"internal"})
+	s.Route("lab.corp.example.", namedResolver{"lab"})
+	s.Route("10.in-addr.arpa", namedResolver{"internal"})
+
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{name: "corp.example", want: "internal"},
+		{name: "_dmarc.CORP.example.", want: "internal"},
+		{name: "sel._domainkey.host.lab.corp.example", want: "lab"},
+		{name: "notcorp.example", want: "public"},
+		{name: "example.com", want: "public"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.LookupTXT(context.Background(), tc.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, []string{tc.want}) {
+				t.Errorf("want %s, but got %v", tc.want, got)
+			}
+		})
+	}
+
+	if got, _ := s.LookupAddr(context.Background(), "10.1.2.3"); !reflect.DeepEqual(got, []string{"internal"}) {
+		t.Errorf("want internal for 10.1.2.3, but got %v", got)
+	}
+	if got, _ := s.LookupAddr(context.Background(), "192.0.2.1"); !reflect.DeepEqual(got, []string{"public"}) {
+		t.Errorf("want public for 192.0.2.1, but got %v", got)
+	}
+	if got := reverseName(net.ParseIP("2001:db8::1")); got != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa" {
+		t.Errorf("unexpected reverse name: %s", got)
+	}
+}
+
+func TestSplitHorizonInstall(t *testing.T) {
+	s := NewSplitHorizon(namedResolver{"public"})
+	s.Route("corp.example", namedResolver{"internal"})
+	restore := s.Install()
+	defer restore()
+
+	for _, tc := range []struct {
+		name string
+		got  func() ([]string, error)
+		want string
+	}{
+		{name: "spf", got: func() ([]string, error) { return spf.DefaultResolver.LookupTXT(context.Background(), "corp.example") }, want: "internal"},
+		{name: "dmarc", got: func() ([]string, error) { return dmarc.DefaultResolver("_dmarc.example.com") }, want: "public"},
+		{name: "dkim", got: func() ([]string, error) {
+			return domainkey.NewDefaultTXTResolver().LookupTXT(context.Background(), "s._domainkey.corp.example")
+		}, want: "internal"},
+	} {
+		got, err := tc.got()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, []string{tc.want}) {
+			t.Errorf("%s: want %s, but got %v", tc.name, tc.want, got)
+		}
+	}
+
+	restore()
+	if domainkey.SharedTXTResolver != nil {
+		t.Errorf("expected restore to reset the shared DKIM resolver")
+	}
+}
+
+// brokenResolver はTXTの問い合わせでerrを返すか、errがnilの場合はctxが終了するまで応答しない
+type brokenResolver struct {
+	namedResolver
+	err   error
+	calls *int
+}
+
+func (r brokenResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	*r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	<-ctx.Done()
+	return nil, &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+}
+
+func TestFailover(t *testing.T) {
+	var calls int
+	now := time.Unix(1700000000, 0)
+	f := NewFailover(brokenResolver{err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}, calls: &calls}, namedResolver{"public"})
+	f.MaxFailures = 2
+	f.Cooldown = time.Minute
+	f.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		got, err := f.LookupTXT(context.Background(), "example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, []string{"public"}) {
+			t.Errorf("want public, but got %v", got)
+		}
+	}
+	// 2回失敗した後は停止中とみなし、3回目は後回しにする
+	if calls != 2 {
+		t.Errorf("want 2 calls to the failing resolver, but got %d", calls)
+	}
+	health := f.Health()
+	if !health[0].Down || health[0].Failures != 2 || health[0].LastError == nil {
+		t.Errorf("want the first resolver down after 2 failures, but got %+v", health[0])
+	}
+	if health[1].Down || health[1].Successes != 3 {
+		t.Errorf("want the second resolver up with 3 successes, but got %+v", health[1])
+	}
+
+	// 期間が過ぎると再び最初に問い合わせる
+	now = now.Add(time.Minute)
+	if _, err := f.LookupTXT(context.Background(), "example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("want the first resolver retried after the cooldown, but got %d calls", calls)
+	}
+
+	// レコードが存在しない応答では切り替えない
+	if _, err := f.LookupIP(context.Background(), "ip", "example.com"); ClassifyError(err) != ErrorKindNotFound {
+		t.Errorf("want not found, but got %v", err)
+	}
+
+	// 応答しないリゾルバーはTimeoutで打ち切って次に切り替える
+	var hung int
+	f = NewFailover(brokenResolver{calls: &hung}, namedResolver{"public"})
+	f.Timeout = 10 * time.Millisecond
+	got, err := f.LookupTXT(context.Background(), "example.com")
+	if err != nil || !reflect.DeepEqual(got, []string{"public"}) || hung != 1 {
+		t.Errorf("want public after a timeout, but got %v, %v", got, err)
+	}
+
+	// すべて失敗した場合は最後のエラーを返す
+	f = NewFailover(brokenResolver{err: &net.DNSError{IsTemporary: true}, calls: &calls}, brokenResolver{err: &net.DNSError{IsTimeout: true}, calls: &calls})
+	if _, err := f.LookupTXT(context.Background(), "example.com"); ClassifyError(err) != ErrorKindTimeout {
+		t.Errorf("want timeout, but got %v", err)
+	}
+	if _, err := NewFailover().LookupTXT(context.Background(), "example.com"); !errors.Is(err, ErrNoResolvers) {
+		t.Errorf("want %v, but got %v", ErrNoResolvers, err)
+	}
+}
+
+func TestZone(t *testing.T) {
+	z := NewZone()
+	z.AddTXT("example.com", "v=spf1 mx -all")
+	z.AddMX("example.com", "mx2.example.com", 20)
+	z.AddMX("example.com", "mx1.example.com", 10)
+	z.AddIP("mx1.example.com", net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"))
+	z.AddPTR(net.ParseIP("192.0.2.1"), "mx1.example.com")
+	z.AddCNAME("www.example.com", "example.com")
+	z.SetTimeout("slow.example.com")
+	ctx := context.Background()
+
+	txt, err := z.LookupTXT(ctx, "WWW.Example.com.")
+	if err != nil || !reflect.DeepEqual(txt, []string{"v=spf1 mx -all"}) {
+		t.Errorf("want TXT via CNAME, but got %v, %v", txt, err)
+	}
+	mx, err := z.LookupMX(ctx, "example.com")
+	if err != nil || len(mx) != 2 || mx[0].Host != "mx1.example.com." {
+		t.Errorf("want MX sorted by preference, but got %v, %v", mx, err)
+	}
+	ips, err := z.LookupIP(ctx, "ip4", "mx1.example.com")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("want only IPv4 address, but got %v, %v", ips, err)
+	}
+	names, err := z.LookupAddr(ctx, "192.0.2.1")
+	if err != nil || !reflect.DeepEqual(names, []string{"mx1.example.com."}) {
+		t.Errorf("want PTR, but got %v, %v", names, err)
+	}
+
+	var dnsErr *net.DNSError
+	if _, err := z.LookupTXT(ctx, "missing.example.com"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("want not found, but got %v", err)
+	}
+	if _, err := z.LookupTXT(ctx, "mx1.example.com"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("want not found for missing TXT, but got %v", err)
+	}
+	if _, err := z.LookupTXT(ctx, "slow.example.com"); ClassifyError(err) != ErrorKindTimeout {
+		t.Errorf("want temporary error, but got %v", err)
+	}
+}
+
+func TestLoadZone(t *testing.T) {
+	const zoneYAML = `
+example.com:
+  - TXT: v=spf1 ip4:192.0.2.0/24 include:_spf.example.net -all
+  - MX: [10, mail.example.com]
+_spf.example.net:
+  - TXT: ["v=spf1 ", "ip6:2001:db8::/32 -all"]
+mail.example.com:
+  - A: 192.0.2.25
+_dmarc.example.com:
+  - TXT: v=DMARC1; p=reject
+slow.example.com:
+  - TIMEOUT
+`
+	const zoneFile = `
+$ORIGIN example.com.
+$TTL 300
+@           IN  TXT  "v=spf1 ip4:192.0.2.0/24 include:_spf.example.net. -all"
+            IN  MX   10 mail
+mail    300 IN  A    192.0.2.25
+_dmarc      IN  TXT  "v=DMARC1; p=reject" ; comment
+$ORIGIN example.net.
+_spf        IN  TXT  ( "v=spf1 "
+                       "ip6:2001:db8::/32 -all" )
+`
+	yz, err := LoadZoneYAML(strings.NewReader(zoneYAML))
+	if err != nil {
+		t.Fatalf("LoadZoneYAML: %v", err)
+	}
+	bz, err := LoadZoneFile(strings.NewReader(zoneFile), "example.com")
+	if err != nil {
+		t.Fatalf("LoadZoneFile: %v", err)
+	}
+
+	for name, z := range map[string]*Zone{"yaml": yz, "zonefile": bz} {
+		t.Run(name, func(t *testing.T) {
+			restore := NewSplitHorizon(z).Install()
+			defer restore()
+
+			testCases := []struct {
+				ip   string
+				want spf.Status
+			}{
+				{ip: "192.0.2.10", want: spf.Pass},
+				{ip: "2001:db8::10", want: spf.Pass},
+				{ip: "198.51.100.1", want: spf.Fail},
+			}
+			for _, tc := range testCases {
+				res := spf.CheckSPF(net.ParseIP(tc.ip), "example.com", "user@example.com", "mail.example.com")
+				if res.Status != tc.want {
+					t.Errorf("%s: want %s, but got %s (%s)", tc.ip, tc.want, res.Status, res.Reason)
+				}
+			}
+
+			txt, err := dmarc.DefaultResolver("_dmarc.example.com")
+			if err != nil || !reflect.DeepEqual(txt, []string{"v=DMARC1; p=reject"}) {
+				t.Errorf("want DMARC record, but got %v, %v", txt, err)
+			}
+			mx, err := z.LookupMX(context.Background(), "example.com")
+			if err != nil || len(mx) != 1 || mx[0].Host != "mail.example.com." {
+				t.Errorf("want mail.example.com., but got %v, %v", mx, err)
+			}
+		})
+	}
+
+	for _, bad := range []string{
+		"example.com:\n  - A: not-an-ip\n",
+		"example.com:\n  - MX: mail.example.com\n",
+	} {
+		if _, err := LoadZoneYAML(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+	for _, bad := range []string{
+		"  IN A 192.0.2.1\n",
+		"@ IN TXT ( \"unterminated\"\n",
+		"@ IN A 192.0.2\n",
+	} {
+		if _, err := LoadZoneFile(strings.NewReader(bad), "example.com"); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+// flakyResolver はTXT・A/AAAA・MX・PTRの問い合わせのたびにcallsを数え、
+// failures回目まではerrを返す
+type flakyResolver struct {
+	namedResolver
+	err      error
+	failures int
+	calls    map[string]int
+}
+
+func (r flakyResolver) fail(kind string) error {
+	r.calls[kind]++
+	if r.calls[kind] <= r.failures {
+		return r.err
+	}
+	return nil
+}
+
+func (r flakyResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err := r.fail("txt"); err != nil {
+		return nil, err
+	}
+	return r.namedResolver.LookupTXT(ctx, name)
+}
+
+func (r flakyResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if err := r.fail("ip"); err != nil {
+		return nil, err
+	}
+	return []net.IP{net.ParseIP("192.0.2.1")}, nil
+}
+
+func (r flakyResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if err := r.fail("mx"); err != nil {
+		return nil, err
+	}
+	return r.namedResolver.LookupMX(ctx, name)
+}
+
+func (r flakyResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if err := r.fail("ptr"); err != nil {
+		return nil, err
+	}
+	return r.namedResolver.LookupAddr(ctx, addr)
+}
+
+func TestRetryResolverInstall(t *testing.T) {
+	flaky := flakyResolver{
+		namedResolver: namedResolver{"public"},
+		err:           &net.DNSError{Err: "server misbehaving", IsTemporary: true},
+		failures:      1,
+		calls:         make(map[string]int),
+	}
+	r := NewRetryResolver(flaky, &RetryPolicy{Attempts: 2, Backoff: time.Millisecond, RetryOnServFail: true})
+	restore := r.Install()
+	defer restore()
+
+	for _, tc := range []struct {
+		kind   string
+		lookup func() error
+	}{
+		{kind: "txt", lookup: func() error { _, err := spf.DefaultResolver.LookupTXT(context.Background(), "example.com"); return err }},
+		{kind: "ip", lookup: func() error {
+			_, err := spf.DefaultResolver.LookupIP(context.Background(), "ip", "example.com")
+			return err
+		}},
+		{kind: "mx", lookup: func() error { _, err := spf.DefaultResolver.LookupMX(context.Background(), "example.com"); return err }},
+		{kind: "ptr", lookup: func() error { _, err := spf.DefaultResolver.LookupAddr(context.Background(), "192.0.2.1"); return err }},
+	} {
+		if err := tc.lookup(); err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.kind, err)
+		}
+		if flaky.calls[tc.kind] != 2 {
+			t.Errorf("%s: want 2 calls, but got %d", tc.kind, flaky.calls[tc.kind])
+		}
+	}
+
+	// 再試行の対象でない失敗は1回で返す
+	flaky.err = &net.DNSError{IsNotFound: true}
+	flaky.failures = 10
+	flaky.calls["mx"] = 0
+	r.Resolver = flaky
+	if _, err := r.LookupMX(context.Background(), "example.com"); ClassifyError(err) != ErrorKindNotFound {
+		t.Errorf("want not found, but got %v", err)
+	}
+	if flaky.calls["mx"] != 1 {
+		t.Errorf("want 1 call, but got %d", flaky.calls["mx"])
+	}
+}
@@ -0,0 +1,256 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrorKind はDNS問い合わせの失敗の種類
+type ErrorKind int
+
+const (
+	// ErrorKindNone は失敗していない
+	ErrorKindNone ErrorKind = iota
+	// ErrorKindNotFound はNXDOMAINまたはレコードが存在しない。再試行しない
+	ErrorKindNotFound
+	// ErrorKindTimeout は応答がタイムアウトした
+	ErrorKindTimeout
+	// ErrorKindServFail はSERVFAILなどサーバーの一時的な失敗
+	ErrorKindServFail
+	// ErrorKindOther はそれ以外の失敗(ctxのキャンセルを含む)。再試行しない
+	ErrorKindOther
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindNone:
+		return "none"
+	case ErrorKindNotFound:
+		return "notfound"
+	case ErrorKindTimeout:
+		return "timeout"
+	case ErrorKindServFail:
+		return "servfail"
+	}
+	return "other"
+}
+
+// ClassifyError は問い合わせのエラーを種類に分類する
+// net.DNSErrorのIsNotFound・IsTimeout・IsTemporaryで判定する
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return ErrorKindNone
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorKindOther
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return ErrorKindNotFound
+		case dnsErr.IsTimeout:
+			return ErrorKindTimeout
+		case dnsErr.IsTemporary:
+			return ErrorKindServFail
+		}
+		return ErrorKindOther
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKindTimeout
+	}
+	return ErrorKindOther
+}
+
+const (
+	// DefaultRetryBackoff は最初の再試行までの待ち時間
+	DefaultRetryBackoff = 200 * time.Millisecond
+	// DefaultMaxRetryBackoff は再試行の待ち時間の上限
+	DefaultMaxRetryBackoff = 2 * time.Second
+)
+
+// RetryPolicy は一時的な失敗に対する問い合わせの再試行の設定
+// ゼロ値は再試行しない(1回のみ問い合わせる)
+type RetryPolicy struct {
+	// Attempts は最初の問い合わせを含む最大の試行回数。1以下の場合は再試行しない
+	Attempts int
+	// Backoff は最初の再試行までの待ち時間。以降は倍にしていく。0以下の場合はDefaultRetryBackoff
+	Backoff time.Duration
+	// MaxBackoff は待ち時間の上限。0以下の場合はDefaultMaxRetryBackoff
+	MaxBackoff time.Duration
+	// RetryOnTimeout がtrueの場合はタイムアウトで再試行する
+	RetryOnTimeout bool
+	// RetryOnServFail がtrueの場合はSERVFAILなどサーバーの一時的な失敗で再試行する
+	RetryOnServFail bool
+}
+
+// ShouldRetry はerrの種類が再試行の対象かを返す
+func (p *RetryPolicy) ShouldRetry(err error) bool {
+	if p == nil {
+		return false
+	}
+	switch ClassifyError(err) {
+	case ErrorKindTimeout:
+		return p.RetryOnTimeout
+	case ErrorKindServFail:
+		return p.RetryOnServFail
+	}
+	return false
+}
+
+// backoff はn回目(1始まり)の再試行までの待ち時間を返す
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = DefaultRetryBackoff
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxRetryBackoff
+	}
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Wrap はlookupを再試行するLookupFuncを返す
+// 待っている間にctxが終了した場合は最後のエラーを返す
+// pがnilの場合はlookupをそのまま返す
+func (p *RetryPolicy) Wrap(lookup LookupFunc) LookupFunc {
+	if p == nil || p.Attempts <= 1 {
+		return lookup
+	}
+	return func(ctx context.Context, name string) ([]string, time.Duration, error) {
+		var (
+			records []string
+			ttl     time.Duration
+		)
+		err := p.do(ctx, 0, func(ctx context.Context) (err error) {
+			records, ttl, err = lookup(ctx, name)
+			return err
+		})
+		return records, ttl, err
+	}
+}
+
+// do はlookupを成功するか再試行の対象でない失敗になるまで最大Attempts回実行する
+// timeoutが0より大きい場合は1回ごとの問い合わせをtimeoutで打ち切る
+// 待っている間にctxが終了した場合は最後のエラーを返す
+func (p *RetryPolicy) do(ctx context.Context, timeout time.Duration, lookup func(ctx context.Context) error) error {
+	attempts := 1
+	if p != nil && p.Attempts > 1 {
+		attempts = p.Attempts
+	}
+	for i := 1; ; i++ {
+		var err error
+		if timeout > 0 {
+			actx, cancel := context.WithTimeout(ctx, timeout)
+			err = lookup(actx)
+			cancel()
+		} else {
+			err = lookup(ctx)
+		}
+		if err == nil || i >= attempts || !p.ShouldRetry(err) {
+			return err
+		}
+		t := time.NewTimer(p.backoff(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// RetryResolver はResolverへのTXT・A/AAAA・MX・PTRの問い合わせをPolicyに従って再試行する
+// TXTCache.Retryと異なりTXT以外の問い合わせも再試行するため、
+// InstallでSPFのa・mx・ptr機構などの既定の問い合わせにも再試行を適用できる
+type RetryResolver struct {
+	// Resolver は問い合わせるリゾルバー。nilの場合はnet.DefaultResolver
+	Resolver DNSResolver
+	// Policy は再試行の設定。nilの場合は再試行しない
+	Policy *RetryPolicy
+	// Timeout は1回の問い合わせのタイムアウト。0以下の場合はDefaultLookupTimeout
+	Timeout time.Duration
+}
+
+// NewRetryResolver はrへの問い合わせをpに従って再試行するRetryResolverを作成する
+func NewRetryResolver(r DNSResolver, p *RetryPolicy) *RetryResolver {
+	return &RetryResolver{Resolver: r, Policy: p}
+}
+
+func (r *RetryResolver) resolver() DNSResolver {
+	if r.Resolver != nil {
+		return r.Resolver
+	}
+	return net.DefaultResolver
+}
+
+func (r *RetryResolver) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultLookupTimeout
+}
+
+// LookupTXT はTXTレコードを問い合わせる
+func (r *RetryResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	var ret []string
+	err := r.Policy.do(ctx, r.timeout(), func(ctx context.Context) (err error) {
+		ret, err = r.resolver().LookupTXT(ctx, name)
+		return err
+	})
+	return ret, err
+}
+
+// LookupIP はアドレスを問い合わせる
+func (r *RetryResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var ret []net.IP
+	err := r.Policy.do(ctx, r.timeout(), func(ctx context.Context) (err error) {
+		ret, err = r.resolver().LookupIP(ctx, network, host)
+		return err
+	})
+	return ret, err
+}
+
+// LookupMX はMXレコードを問い合わせる
+func (r *RetryResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	var ret []*net.MX
+	err := r.Policy.do(ctx, r.timeout(), func(ctx context.Context) (err error) {
+		ret, err = r.resolver().LookupMX(ctx, name)
+		return err
+	})
+	return ret, err
+}
+
+// LookupAddr はPTRレコードを問い合わせる
+func (r *RetryResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	var ret []string
+	err := r.Policy.do(ctx, r.timeout(), func(ctx context.Context) (err error) {
+		ret, err = r.resolver().LookupAddr(ctx, addr)
+		return err
+	})
+	return ret, err
+}
+
+// Install はSPF・DMARC・DKIM・ARCの既定の問い合わせがすべてrを使うよう設定し、
+// 元に戻す関数を返す。問い合わせを始める前に呼ぶ(パッケージの説明を参照)
+// 問い合わせ全体のタイムアウトは、すべての試行をTimeoutまで待てる長さに再試行の待ち時間を加えたもの
+func (r *RetryResolver) Install() (restore func()) {
+	attempts := 1
+	if r.Policy != nil && r.Policy.Attempts > 1 {
+		attempts = r.Policy.Attempts
+	}
+	total := r.timeout() * time.Duration(attempts)
+	for i := 1; i < attempts; i++ {
+		total += r.Policy.backoff(i)
+	}
+	return install(r, total)
+}
@@ -0,0 +1,205 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masa23/mmauth/dmarc"
+	"github.com/masa23/mmauth/domainkey"
+	"github.com/masa23/mmauth/spf"
+)
+
+// DNSResolver はDKIM・SPF・DMARCの評価に必要な問い合わせを行うリゾルバー
+// *net.Resolverが満たす
+type DNSResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+var _ DNSResolver = (*net.Resolver)(nil)
+
+// NewServerResolver は指定したDNSサーバー(例: 10.0.0.53:53)に問い合わせる*net.Resolverを返す
+// 社内DNSなどをSplitHorizonのゾーンに登録する場合に使う
+func NewServerResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+type zoneRoute struct {
+	suffix   string
+	resolver DNSResolver
+}
+
+// SplitHorizon はドメインのサフィックスごとに問い合わせ先のリゾルバーを切り替える
+// 社内のドメインは社内のDNSで、それ以外は公開のDNSで解決する場合などに使う
+// 問い合わせる名前に最も長く一致するゾーンのリゾルバーを使い、
+// どのゾーンにも一致しない場合はDefaultを使う
+// PTRの問い合わせは逆引きの名前(例: 10.in-addr.arpa)で振り分ける
+type SplitHorizon struct {
+	// Default はどのゾーンにも一致しない場合のリゾルバー。nilの場合はnet.DefaultResolver
+	Default DNSResolver
+
+	mu     sync.RWMutex
+	routes []zoneRoute
+}
+
+// NewSplitHorizon はdefを既定のリゾルバーとするSplitHorizonを作成する
+func NewSplitHorizon(def DNSResolver) *SplitHorizon {
+	return &SplitHorizon{Default: def}
+}
+
+// Route はzoneとそのサブドメインの問い合わせにrを使うよう登録する
+// 同じゾーンを再度登録した場合は置き換える
+func (s *SplitHorizon) Route(zone string, r DNSResolver) {
+	zone = normalizeName(zone)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.routes {
+		if s.routes[i].suffix == zone {
+			s.routes[i].resolver = r
+			return
+		}
+	}
+	s.routes = append(s.routes, zoneRoute{suffix: zone, resolver: r})
+}
+
+// ResolverFor はnameの問い合わせに使うリゾルバーを返す
+func (s *SplitHorizon) ResolverFor(name string) DNSResolver {
+	name = normalizeName(name)
+	s.mu.RLock()
+	var best DNSResolver
+	bestLen := -1
+	for _, r := range s.routes {
+		if len(r.suffix) > bestLen && inZone(name, r.suffix) {
+			best, bestLen = r.resolver, len(r.suffix)
+		}
+	}
+	s.mu.RUnlock()
+	if best != nil {
+		return best
+	}
+	if s.Default != nil {
+		return s.Default
+	}
+	return net.DefaultResolver
+}
+
+// nameがzoneまたはそのサブドメインかをラベル単位で判定する
+func inZone(name, zone string) bool {
+	if zone == "" {
+		return true
+	}
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// LookupTXT はnameのゾーンのリゾルバーでTXTレコードを問い合わせる
+func (s *SplitHorizon) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return s.ResolverFor(name).LookupTXT(ctx, name)
+}
+
+// LookupIP はhostのゾーンのリゾルバーでアドレスを問い合わせる
+func (s *SplitHorizon) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return s.ResolverFor(host).LookupIP(ctx, network, host)
+}
+
+// LookupMX はnameのゾーンのリゾルバーでMXレコードを問い合わせる
+func (s *SplitHorizon) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return s.ResolverFor(name).LookupMX(ctx, name)
+}
+
+// LookupAddr はaddrの逆引きの名前のゾーンのリゾルバーでPTRレコードを問い合わせる
+func (s *SplitHorizon) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return s.ResolverFor(reverseName(net.ParseIP(addr))).LookupAddr(ctx, addr)
+}
+
+// Install はSPF・DMARC・DKIM・ARCの既定の問い合わせがすべてsを使うよう設定し、
+// 元に戻す関数を返す。問い合わせを始める前に呼ぶ(パッケージの説明を参照)
+// 問い合わせごとのタイムアウトはDefaultLookupTimeout
+func (s *SplitHorizon) Install() (restore func()) {
+	return install(s, DefaultLookupTimeout)
+}
+
+// install はSPF・DMARC・DKIM・ARCの既定の問い合わせがすべてrを使うよう設定し、
+// 元に戻す関数を返す。問い合わせごとのタイムアウトはtimeout
+// SPFはspf.DefaultResolverでrを使い、評価のctxにtimeoutを加えて渡す
+// パッケージ変数を同期せずに書き換えるため、問い合わせと並行して呼んではならない
+func install(r DNSResolver, timeout time.Duration) (restore func()) {
+	origTXT, origIP, origMX, origPTR := spf.DefaultTXTResolver, spf.DefaultIPResolver, spf.DefaultMXResolver, spf.DefaultPTRResolver
+	origSPF, origDMARC, origKeyFunc, origKey := spf.DefaultResolver, dmarc.DefaultResolver, domainkey.DefaultResolver, domainkey.SharedTXTResolver
+
+	txt := func(name string) ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return r.LookupTXT(ctx, name)
+	}
+	spf.DefaultTXTResolver, spf.DefaultIPResolver, spf.DefaultMXResolver, spf.DefaultPTRResolver = nil, nil, nil, nil
+	spf.DefaultResolver = timeoutResolver{r: r, timeout: timeout}
+	dmarc.DefaultResolver = dmarc.TXTLookupFunc(txt)
+	domainkey.DefaultResolver = domainkey.TXTLookupFunc(txt)
+	domainkey.SharedTXTResolver = r
+
+	return func() {
+		spf.DefaultTXTResolver, spf.DefaultIPResolver, spf.DefaultMXResolver, spf.DefaultPTRResolver = origTXT, origIP, origMX, origPTR
+		spf.DefaultResolver, dmarc.DefaultResolver, domainkey.DefaultResolver, domainkey.SharedTXTResolver = origSPF, origDMARC, origKeyFunc, origKey
+	}
+}
+
+// timeoutResolver はrへの問い合わせをそれぞれtimeoutで打ち切る
+type timeoutResolver struct {
+	r       DNSResolver
+	timeout time.Duration
+}
+
+func (t timeoutResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.r.LookupTXT(ctx, name)
+}
+
+func (t timeoutResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.r.LookupIP(ctx, network, host)
+}
+
+func (t timeoutResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.r.LookupMX(ctx, name)
+}
+
+func (t timeoutResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.r.LookupAddr(ctx, addr)
+}
+
+// reverseName はipの逆引きの名前(in-addr.arpa / ip6.arpa)を返す
+func reverseName(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return net.IPv4(v4[3], v4[2], v4[1], v4[0]).String() + ".in-addr.arpa"
+	}
+	const hexDigits = "0123456789abcdef"
+	var sb strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		sb.WriteByte(hexDigits[ip[i]&0x0f])
+		sb.WriteByte('.')
+		sb.WriteByte(hexDigits[ip[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa")
+	return sb.String()
+}
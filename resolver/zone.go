@@ -0,0 +1,503 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxCNAMEChain はZoneでたどるCNAMEの最大数
+const maxCNAMEChain = 8
+
+type zoneNode struct {
+	txt     []string
+	ips     []net.IP
+	mx      []*net.MX
+	ptr     []string
+	cname   string
+	timeout bool
+}
+
+// Zone はメモリ上のレコードから応答するDNSResolver
+// ネットワークに接続できない環境での評価やデモ、テストに使う
+// 名前が存在しない場合や問い合わせた種類のレコードがない場合は、
+// net.Resolverと同様にIsNotFoundのnet.DNSErrorを返す
+// SPF・DMARC・DKIMの既定の問い合わせに使うにはNewSplitHorizon(zone).Install()を使う
+type Zone struct {
+	mu    sync.RWMutex
+	nodes map[string]*zoneNode
+}
+
+var _ DNSResolver = (*Zone)(nil)
+
+// NewZone は空のZoneを作成する
+func NewZone() *Zone {
+	return &Zone{nodes: make(map[string]*zoneNode)}
+}
+
+func (z *Zone) node(name string) *zoneNode {
+	name = normalizeName(name)
+	n, ok := z.nodes[name]
+	if !ok {
+		n = &zoneNode{}
+		z.nodes[name] = n
+	}
+	return n
+}
+
+// AddTXT はnameにTXTレコードを追加する
+// 255バイトを超える文字列に分割されたレコードは連結してから渡す
+func (z *Zone) AddTXT(name string, txt ...string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := z.node(name)
+	n.txt = append(n.txt, txt...)
+}
+
+// AddIP はnameにAまたはAAAAレコードを追加する
+func (z *Zone) AddIP(name string, ips ...net.IP) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := z.node(name)
+	n.ips = append(n.ips, ips...)
+}
+
+// AddMX はnameにMXレコードを追加する
+func (z *Zone) AddMX(name, host string, pref uint16) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := z.node(name)
+	n.mx = append(n.mx, &net.MX{Host: fqdn(host), Pref: pref})
+}
+
+// AddPTR はipの逆引きの名前にPTRレコードを追加する
+func (z *Zone) AddPTR(ip net.IP, names ...string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := z.node(reverseName(ip))
+	for _, name := range names {
+		n.ptr = append(n.ptr, fqdn(name))
+	}
+}
+
+// addPTR は逆引きの名前ownerにPTRレコードを追加する
+func (z *Zone) addPTR(owner, target string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := z.node(owner)
+	n.ptr = append(n.ptr, fqdn(target))
+}
+
+// AddCNAME はnameをtargetの別名にする
+func (z *Zone) AddCNAME(name, target string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.node(name).cname = normalizeName(target)
+}
+
+// SetTimeout はnameへの問い合わせがタイムアウトするようにする
+// 一時的な失敗(TempError)の動作を確かめる場合に使う
+func (z *Zone) SetTimeout(name string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.node(name).timeout = true
+}
+
+// lookup はCNAMEをたどってnameのノードを返す
+func (z *Zone) lookup(ctx context.Context, name string) (*zoneNode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &net.DNSError{Name: name, Err: err.Error(), IsTimeout: true, IsTemporary: true}
+	}
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	key := normalizeName(name)
+	for i := 0; i <= maxCNAMEChain; i++ {
+		n, ok := z.nodes[key]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}
+		if n.timeout {
+			return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true, IsTemporary: true}
+		}
+		if n.cname == "" {
+			return n, nil
+		}
+		key = n.cname
+	}
+	return nil, &net.DNSError{Err: "too many CNAME records", Name: name}
+}
+
+// LookupTXT はnameのTXTレコードを返す
+func (z *Zone) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	n, err := z.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(n.txt) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return append([]string(nil), n.txt...), nil
+}
+
+// LookupIP はhostのアドレスを返す
+// networkが"ip4"または"ip6"の場合はその種類のアドレスのみを返す
+func (z *Zone) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	n, err := z.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, ip := range n.ips {
+		is4 := ip.To4() != nil
+		if (network == "ip4" && !is4) || (network == "ip6" && is4) {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// LookupMX はnameのMXレコードを優先度の順に返す
+func (z *Zone) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	n, err := z.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(n.mx) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	mx := make([]*net.MX, len(n.mx))
+	for i, m := range n.mx {
+		mx[i] = &net.MX{Host: m.Host, Pref: m.Pref}
+	}
+	// 安定ソート(登録順を保つ)
+	for i := 1; i < len(mx); i++ {
+		for j := i; j > 0 && mx[j].Pref < mx[j-1].Pref; j-- {
+			mx[j], mx[j-1] = mx[j-1], mx[j]
+		}
+	}
+	return mx, nil
+}
+
+// LookupAddr はaddrのPTRレコードを返す
+func (z *Zone) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	n, err := z.lookup(ctx, reverseName(ip))
+	if err != nil {
+		return nil, err
+	}
+	if len(n.ptr) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return append([]string(nil), n.ptr...), nil
+}
+
+func fqdn(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// LoadZoneYAML はYAMLで記述したレコードからZoneを作成する
+// 形式はpyspfのテストスイートのzonedataと同じで、名前ごとにレコードのリストを書く
+//
+//	example.com:
+//	  - TXT: v=spf1 mx -all
+//	  - MX: [10, mail.example.com]
+//	mail.example.com:
+//	  - A: 192.0.2.1
+//	  - AAAA: 2001:db8::1
+//	1.2.0.192.in-addr.arpa:
+//	  - PTR: mail.example.com
+//	www.example.com:
+//	  - CNAME: example.com
+//	slow.example.com:
+//	  - TIMEOUT
+//
+// 文字列のリストで書いたTXTは分割された文字列として連結する
+func LoadZoneYAML(r io.Reader) (*Zone, error) {
+	var doc map[string][]yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode zone: %w", err)
+	}
+	z := NewZone()
+	for name, entries := range doc {
+		for _, entry := range entries {
+			if err := z.addYAMLEntry(name, &entry); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return z, nil
+}
+
+func (z *Zone) addYAMLEntry(name string, entry *yaml.Node) error {
+	switch entry.Kind {
+	case yaml.ScalarNode:
+		if strings.EqualFold(entry.Value, "TIMEOUT") {
+			z.SetTimeout(name)
+			return nil
+		}
+		return fmt.Errorf("unknown entry %q", entry.Value)
+	case yaml.MappingNode:
+	default:
+		return fmt.Errorf("line %d: unsupported entry", entry.Line)
+	}
+	for i := 0; i+1 < len(entry.Content); i += 2 {
+		typ, value := strings.ToUpper(entry.Content[i].Value), entry.Content[i+1]
+		var s string
+		var list []string
+		if value.Kind == yaml.SequenceNode {
+			if err := value.Decode(&list); err != nil {
+				return fmt.Errorf("line %d: %w", value.Line, err)
+			}
+		} else if err := value.Decode(&s); err != nil {
+			return fmt.Errorf("line %d: %w", value.Line, err)
+		}
+		switch typ {
+		case "TXT", "SPF":
+			if list != nil {
+				s = strings.Join(list, "")
+			}
+			z.AddTXT(name, s)
+		case "A", "AAAA":
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("line %d: invalid address %q", value.Line, s)
+			}
+			z.AddIP(name, ip)
+		case "MX":
+			if len(list) != 2 {
+				return fmt.Errorf("line %d: MX must be [preference, host]", value.Line)
+			}
+			pref, err := strconv.ParseUint(list[0], 10, 16)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid MX preference %q", value.Line, list[0])
+			}
+			z.AddMX(name, list[1], uint16(pref))
+		case "PTR":
+			z.addPTR(name, s)
+		case "CNAME":
+			z.AddCNAME(name, s)
+		default:
+			return fmt.Errorf("line %d: unsupported record type %s", value.Line, typ)
+		}
+	}
+	return nil
+}
+
+// LoadZoneFile はBIND形式のゾーンファイルからZoneを作成する
+// originは相対名と@の補完に使い、$ORIGINで変更できる
+// 対応するレコードはTXT・SPF・A・AAAA・MX・PTR・CNAMEで、それ以外の種類は無視する
+// クラスはINのみ、$INCLUDEには対応しない
+func LoadZoneFile(r io.Reader, origin string) (*Zone, error) {
+	z := NewZone()
+	origin = fqdn(origin)
+	var owner string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for {
+		fields, startsWithSpace, first, err := nextZoneEntry(sc, &lineNo)
+		if err != nil {
+			return nil, err
+		}
+		if fields == nil {
+			break
+		}
+		if strings.HasPrefix(fields[0], "$") {
+			switch strings.ToUpper(fields[0]) {
+			case "$ORIGIN":
+				if len(fields) < 2 {
+					return nil, fmt.Errorf("line %d: $ORIGIN without a name", first)
+				}
+				origin = absoluteName(fields[1], origin)
+			case "$TTL":
+			default:
+				return nil, fmt.Errorf("line %d: unsupported directive %s", first, fields[0])
+			}
+			continue
+		}
+		if !startsWithSpace {
+			owner = absoluteName(fields[0], origin)
+			fields = fields[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: record without an owner name", first)
+		}
+		// [TTL] [class] type または [class] [TTL] type
+		for len(fields) > 0 {
+			if _, err := strconv.ParseUint(fields[0], 10, 32); err == nil {
+				fields = fields[1:]
+				continue
+			}
+			if strings.EqualFold(fields[0], "IN") {
+				fields = fields[1:]
+				continue
+			}
+			break
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: missing record type", first)
+		}
+		typ, rdata := strings.ToUpper(fields[0]), fields[1:]
+		if err := z.addZoneRecord(owner, origin, typ, rdata); err != nil {
+			return nil, fmt.Errorf("line %d: %w", first, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *Zone) addZoneRecord(owner, origin, typ string, rdata []string) error {
+	need := func(n int) error {
+		if len(rdata) < n {
+			return fmt.Errorf("%s record needs %d fields", typ, n)
+		}
+		return nil
+	}
+	switch typ {
+	case "TXT", "SPF":
+		if err := need(1); err != nil {
+			return err
+		}
+		z.AddTXT(owner, strings.Join(rdata, ""))
+	case "A", "AAAA":
+		if err := need(1); err != nil {
+			return err
+		}
+		ip := net.ParseIP(rdata[0])
+		if ip == nil {
+			return fmt.Errorf("invalid address %q", rdata[0])
+		}
+		z.AddIP(owner, ip)
+	case "MX":
+		if err := need(2); err != nil {
+			return err
+		}
+		pref, err := strconv.ParseUint(rdata[0], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid MX preference %q", rdata[0])
+		}
+		z.AddMX(owner, absoluteName(rdata[1], origin), uint16(pref))
+	case "PTR":
+		if err := need(1); err != nil {
+			return err
+		}
+		z.addPTR(owner, absoluteName(rdata[0], origin))
+	case "CNAME":
+		if err := need(1); err != nil {
+			return err
+		}
+		z.AddCNAME(owner, absoluteName(rdata[0], origin))
+	}
+	return nil
+}
+
+// absoluteName は@と相対名をoriginで補完した名前を返す
+func absoluteName(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	if origin == "." {
+		return name + "."
+	}
+	return name + "." + origin
+}
+
+// nextZoneEntry は括弧による継続行をまとめた1件分のフィールドを返す
+// 引用符で囲まれた文字列は引用符を外し、\" などのエスケープを解除する
+func nextZoneEntry(sc *bufio.Scanner, lineNo *int) (fields []string, startsWithSpace bool, first int, err error) {
+	depth := 0
+	for sc.Scan() {
+		*lineNo++
+		line := sc.Text()
+		if fields == nil && depth == 0 {
+			first = *lineNo
+			startsWithSpace = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		}
+		toks, d, err := tokenizeZoneLine(line, depth)
+		if err != nil {
+			return nil, false, 0, fmt.Errorf("line %d: %w", *lineNo, err)
+		}
+		depth = d
+		fields = append(fields, toks...)
+		if depth == 0 && len(fields) > 0 {
+			return fields, startsWithSpace, first, nil
+		}
+	}
+	if depth != 0 {
+		return nil, false, 0, fmt.Errorf("line %d: unbalanced parentheses", first)
+	}
+	return nil, false, 0, nil
+}
+
+func tokenizeZoneLine(line string, depth int) ([]string, int, error) {
+	var toks []string
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ';':
+			return toks, depth, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			if depth == 0 {
+				return nil, 0, fmt.Errorf("unbalanced parentheses")
+			}
+			depth--
+			i++
+		case c == '"':
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(line) {
+				if line[i] == '\\' && i+1 < len(line) {
+					sb.WriteByte(line[i+1])
+					i += 2
+					continue
+				}
+				if line[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(line[i])
+				i++
+			}
+			if !closed {
+				return nil, 0, fmt.Errorf("unterminated quoted string")
+			}
+			toks = append(toks, sb.String())
+		default:
+			start := i
+			for i < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[i])) {
+				i++
+			}
+			toks = append(toks, line[start:i])
+		}
+	}
+	return toks, depth, nil
+}
@@ -0,0 +1,93 @@
+package mmauth
+
+import (
+	"strings"
+
+	"github.com/masa23/mmauth/authres"
+)
+
+// ScrubOptions は受信したAuthentication-Resultsヘッダの除去の設定
+type ScrubOptions struct {
+	// AuthServIDs は自身が使うauthserv-id
+	// 外部から届いたヘッダがこれらを名乗っている場合は偽装とみなす (RFC 8601 5)
+	AuthServIDs []string
+	// RenameTo が空でない場合は削除せずにヘッダ名をRenameToに変更する
+	// (例: X-Original-Authentication-Results)
+	RenameTo string
+}
+
+func (o *ScrubOptions) isLocal(authservID string) bool {
+	for _, id := range o.AuthServIDs {
+		if strings.EqualFold(strings.TrimSuffix(id, "."), strings.TrimSuffix(authservID, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScrubAuthenticationResults は自身のauthserv-idを名乗るAuthentication-Resultsヘッダを
+// 削除または名前を変更し、対象となったヘッダの数を返す
+// 検証や自身の結果の付与より前に呼び出す。authserv-idを解析できないヘッダは変更しない。
+// 対象外のヘッダはバイト単位でそのまま残すため、通常は既存の署名の検証に影響しないが、
+// 署名のh=にAuthentication-Resultsが含まれている場合は検証に失敗する
+func ScrubAuthenticationResults(headers []string, opts *ScrubOptions) ([]string, int) {
+	ret, _, n := scrubHeaders(headers, nil, opts)
+	return ret, n
+}
+
+// headersと、nilでなければ同じ位置が対応するrawの両方から同じヘッダを除去する
+func scrubHeaders(headers, raw []string, opts *ScrubOptions) ([]string, []string, int) {
+	if opts == nil || len(opts.AuthServIDs) == 0 {
+		return headers, raw, 0
+	}
+	ret := make([]string, 0, len(headers))
+	var retRaw []string
+	if raw != nil {
+		retRaw = make([]string, 0, len(raw))
+	}
+	n := 0
+	for i, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "Authentication-Results") {
+			ret = append(ret, h)
+			if raw != nil {
+				retRaw = append(retRaw, raw[i])
+			}
+			continue
+		}
+		id, err := authres.ParseAuthServID(v)
+		if err != nil || !opts.isLocal(id) {
+			ret = append(ret, h)
+			if raw != nil {
+				retRaw = append(retRaw, raw[i])
+			}
+			continue
+		}
+		n++
+		if opts.RenameTo != "" {
+			ret = append(ret, opts.RenameTo+":"+v)
+			if raw != nil {
+				_, rv, _ := strings.Cut(raw[i], ":")
+				retRaw = append(retRaw, opts.RenameTo+":"+rv)
+			}
+		}
+	}
+	return ret, retRaw, n
+}
+
+// ScrubAuthenticationResults はメッセージのヘッダにScrubAuthenticationResultsを適用する
+// 本文と他のヘッダの順序はそのまま保持する
+// RawHeadersからも同じヘッダを除去し、DKIM-Signatureの位置を作り直す
+func (m *Message) ScrubAuthenticationResults(opts *ScrubOptions) int {
+	var n int
+	m.Headers, m.RawHeaders, n = scrubHeaders(m.Headers, m.RawHeaders, opts)
+	if n > 0 && m.RawHeaders != nil {
+		m.dkimSignatures = nil
+		for _, f := range m.RawHeaders {
+			if isHeaderNamed(f, "DKIM-Signature") {
+				m.dkimSignatures = append(m.dkimSignatures, f)
+			}
+		}
+	}
+	return n
+}
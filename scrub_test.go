@@ -0,0 +1,129 @@
+package mmauth
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScrubAuthenticationResults(t *testing.T) {
+	headers := []string{
+		"Authentication-Results: mx.example.jp; spf=pass smtp.mailfrom=example.com\r\n",
+		"Authentication-Results: (forged)\r\n\tMX.Example.JP 1; dkim=pass header.d=example.com\r\n",
+		"Authentication-Results: mx.example.net; dmarc=pass header.from=example.com\r\n",
+		"From: user@example.com\r\n",
+	}
+	testCases := []struct {
+		name  string
+		opts  *ScrubOptions
+		want  []string
+		wantN int
+	}{
+		{
+			name: "no authserv-id",
+			opts: &ScrubOptions{},
+			want: headers,
+		},
+		{
+			name:  "remove",
+			opts:  &ScrubOptions{AuthServIDs: []string{"mx.example.jp"}},
+			want:  []string{headers[2], headers[3]},
+			wantN: 2,
+		},
+		{
+			name: "rename",
+			opts: &ScrubOptions{AuthServIDs: []string{"mx.example.jp."}, RenameTo: "X-Original-Authentication-Results"},
+			want: []string{
+				"X-Original-Authentication-Results: mx.example.jp; spf=pass smtp.mailfrom=example.com\r\n",
+				"X-Original-Authentication-Results: (forged)\r\n\tMX.Example.JP 1; dkim=pass header.d=example.com\r\n",
+				headers[2],
+				headers[3],
+			},
+			wantN: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, n := ScrubAuthenticationResults(headers, tc.opts)
+			if n != tc.wantN {
+				t.Errorf("expected %d scrubbed, got %d", tc.wantN, n)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMessageScrubAuthenticationResults(t *testing.T) {
+	raw := "Authentication-Results: mx.example.jp; spf=pass\r\n" +
+		"From: user@example.com\r\n" +
+		"\r\n" +
+		"body\r\n"
+	m, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := m.ScrubAuthenticationResults(&ScrubOptions{AuthServIDs: []string{"mx.example.jp"}}); n != 1 {
+		t.Errorf("expected 1 scrubbed, got %d", n)
+	}
+	got, _ := io.ReadAll(m.Reader())
+	if want := "From: user@example.com\r\n\r\nbody\r\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMessageScrubAuthenticationResultsRawHeaders(t *testing.T) {
+	raw := "Authentication-Results: mx.example.jp; dkim=pass header.d=example.com\r\n" +
+		"DKIM-Signature: v=1; d=example.com;  s=sel\r\n" +
+		"From: user@example.com\r\n" +
+		"\r\n" +
+		"body\r\n"
+	m, err := ReadMessageWithOptions(strings.NewReader(raw), &ReadOptions{RetainRawHeaders: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := m.ScrubAuthenticationResults(&ScrubOptions{AuthServIDs: []string{"mx.example.jp"}}); n != 1 {
+		t.Errorf("expected 1 scrubbed, got %d", n)
+	}
+	if len(m.RawHeaders) != len(m.Headers) {
+		t.Fatalf("expected %d raw headers, got %d", len(m.Headers), len(m.RawHeaders))
+	}
+	for _, h := range m.HeadersFor(CanonicalizationSimple) {
+		if isHeaderNamed(h, "Authentication-Results") {
+			t.Errorf("expected forged header to be removed, got %q", h)
+		}
+	}
+	sig, err := m.GetDKIMSignatureRaw(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "DKIM-Signature: v=1; d=example.com;  s=sel\r\n"; sig != want {
+		t.Errorf("expected %q, got %q", want, sig)
+	}
+}
+
+func TestMMAuthScrub(t *testing.T) {
+	raw := "Authentication-Results: mx.example.jp; dkim=pass header.d=example.com\r\n" +
+		"Authentication-Results: mx.example.net; spf=pass\r\n" +
+		"From: user@example.com\r\n" +
+		"\r\n" +
+		"body\r\n"
+	m := NewMMAuth(WithScrub(&ScrubOptions{AuthServIDs: []string{"mx.example.jp"}}))
+	if _, err := m.Write([]byte(raw)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Scrubbed != 1 {
+		t.Errorf("expected 1 scrubbed, got %d", m.Scrubbed)
+	}
+	if len(m.Headers) != 2 || len(m.RawHeaders) != 2 {
+		t.Fatalf("expected 2 headers, got %q and %q", m.Headers, m.RawHeaders)
+	}
+	if !strings.Contains(m.RawHeaders[0], "mx.example.net") {
+		t.Errorf("expected the foreign header to be kept, got %q", m.RawHeaders[0])
+	}
+}
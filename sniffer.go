@@ -0,0 +1,170 @@
+package mmauth
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrDataNotTerminated はDATAの終端の行(".")を受け取る前にDataSnifferを閉じた場合のエラー
+	ErrDataNotTerminated = errors.New("mmauth: DATA ended without the terminating line")
+	// ErrBareLineEnding はDATAにCRLFの組になっていないCRまたはLFが含まれている場合のエラー
+	ErrBareLineEnding = errors.New("mmauth: DATA contains a bare CR or LF")
+)
+
+// DataSniffer はSMTPプロキシが中継するDATAのバイト列を受け取り、
+// ドット詰め(RFC 5321 4.5.2)を解除しながらメッセージをMMAuthに書き込む
+// 中継するメッセージをバッファに溜め直さずに、ヘッダの解析とボディーハッシュの計算を並行して行い、
+// 終端の行を受け取った時点でMMAuthを閉じるため、すぐにAuthenticateで判定を得られる
+//
+// 終端はCRLF "." CRLFのみとし、ドット詰めの解除もCRLFの直後の行頭に限る (RFC 5321 4.1.1.4, 4.5.2)
+// CRLFの組になっていないCRやLFはメッセージの一部として扱い、ErrでErrBareLineEndingを返す
+// 中継先がLFのみの行末を解釈すると、検証していない部分を別のメッセージとして配送されるおそれがあるため
+// (SMTP smuggling)、Errがこのエラーを返す場合はメッセージを拒否する
+//
+// milterのように既にドット詰めが解除されたメッセージを受け取る場合はMMAuthに直接書き込む
+type DataSniffer struct {
+	mu    sync.Mutex
+	m     *MMAuth
+	state dataState
+	out   []byte
+	err   error
+	bare  bool
+}
+
+// DATAのバイト列の解析の状態
+type dataState int
+
+const (
+	dataLineStart dataState = iota // 行頭(CRLFの直後)
+	dataLine                       // 行の途中
+	dataCR                         // CRの直後
+	dataDot                        // 行頭の"."の直後
+	dataDotCR                      // 行頭の".\r"の直後
+	dataEnd                        // 終端の行を受け取った
+)
+
+// NewDataSniffer はmにメッセージを書き込むDataSnifferを返す
+// mにはNewMMAuthで作成し、まだ書き込んでいないものを渡す
+// DataSnifferにはDATAコマンドへの354の応答の後にクライアントが送るバイト列を渡す
+func NewDataSniffer(m *MMAuth) *DataSniffer {
+	return &DataSniffer{m: m}
+}
+
+// TeeReader はrから読んだDATAのバイト列を変更せずに返しながらsに書き込むio.Readerを返す
+// プロキシはこのReaderから読んだものを中継先に書き込む
+func (s *DataSniffer) TeeReader(r io.Reader) io.Reader {
+	return io.TeeReader(r, s)
+}
+
+// Write はDATAのバイト列(ドット詰めされたもの)を受け取り、解除したメッセージをMMAuthに書き込む
+// 終端の行を受け取るとMMAuthを閉じ、それ以降のバイト列(パイプライン化された次のコマンドなど)は無視する
+// 中継を止めないよう、MMAuthのエラーは返さずにErrで返す
+func (s *DataSniffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == dataEnd {
+		return len(p), nil
+	}
+	s.out = s.out[:0]
+	for _, c := range p {
+		s.unstuff(c)
+		if s.state == dataEnd {
+			break
+		}
+	}
+	if len(s.out) > 0 && s.err == nil {
+		if _, err := s.m.Write(s.out); err != nil {
+			s.err = err
+		}
+	}
+	if s.state == dataEnd {
+		if err := s.m.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	return len(p), nil
+}
+
+// 1バイトを処理し、メッセージのバイトをs.outに追加する
+func (s *DataSniffer) unstuff(c byte) {
+	switch s.state {
+	case dataLineStart:
+		if c == '.' {
+			s.state = dataDot
+			return
+		}
+	case dataDot:
+		// 行頭の"."はドット詰めとして取り除き、"."のみの行は終端とする
+		if c == '\r' {
+			s.state = dataDotCR
+			return
+		}
+		s.state = dataLine
+	case dataDotCR:
+		if c == '\n' {
+			s.state = dataEnd
+			return
+		}
+		s.out = append(s.out, '\r')
+		s.state = dataCR
+	}
+	if s.state == dataCR && c != '\n' {
+		s.bare = true
+	}
+	s.out = append(s.out, c)
+	switch {
+	case c == '\n' && s.state == dataCR:
+		s.state = dataLineStart
+	case c == '\n':
+		// CRを伴わないLFは行の区切りとしない
+		s.bare = true
+		s.state = dataLine
+	case c == '\r':
+		s.state = dataCR
+	default:
+		s.state = dataLine
+	}
+}
+
+// Ended は終端の行を受け取り、MMAuthへの書き込みを終えたかを返す
+func (s *DataSniffer) Ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == dataEnd
+}
+
+// Err はMMAuthへの書き込みやメッセージの解析のエラーを返す
+// それらのエラーがなく、CRLFの組になっていないCRやLFを受け取った場合はErrBareLineEndingを返す
+func (s *DataSniffer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errLocked()
+}
+
+func (s *DataSniffer) errLocked() error {
+	if s.err == nil && s.bare {
+		return ErrBareLineEnding
+	}
+	return s.err
+}
+
+// Close はMMAuthへの書き込みを終える
+// 終端の行を受け取る前に接続が切れた場合などはMMAuthを閉じてErrDataNotTerminatedを返す
+// 終端の行を受け取った後はErrと同じ
+func (s *DataSniffer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == dataEnd {
+		return s.errLocked()
+	}
+	s.state = dataEnd
+	if err := s.m.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return ErrDataNotTerminated
+}
@@ -0,0 +1,154 @@
+package mmauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+
+	"github.com/masa23/mmauth/dkim"
+	"github.com/masa23/mmauth/resolver"
+)
+
+func TestDataSniffer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	zone := resolver.NewZone()
+	publishKey(t, zone, "sel._domainkey.example.com", key.Public())
+	useZone(t, zone)
+
+	// 行頭の"."を含む本文に署名する
+	msg := "From: alice@example.com\r\n" +
+		"Subject: sniffer\r\n" +
+		"\r\n" +
+		"..leading dots\r\n" +
+		".\tdot and tab\r\n" +
+		"end\r\n"
+	relaxed := BodyCanonicalizationAndAlgorithm{Body: CanonicalizationRelaxed, Algorithm: crypto.SHA256}
+	m := readMMAuth(t, msg, &relaxed)
+	sig := &dkim.Signature{
+		Version:          1,
+		Canonicalization: "relaxed/relaxed",
+		Domain:           "example.com",
+		Selector:         "sel",
+		BodyHash:         m.GetBodyHash(relaxed),
+	}
+	if err := sig.Sign(m.Headers, key); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	msg = "DKIM-Signature: " + sig.String() + "\r\n" + msg
+
+	// SMTPのDATAとしてドット詰めし、終端の行とパイプライン化された次のコマンドを付ける
+	data := "DKIM-Signature: " + sig.String() + "\r\n" +
+		"From: alice@example.com\r\n" +
+		"Subject: sniffer\r\n" +
+		"\r\n" +
+		"...leading dots\r\n" +
+		"..\tdot and tab\r\n" +
+		"end\r\n" +
+		".\r\n" +
+		"QUIT\r\n"
+
+	m = NewMMAuth()
+	s := NewDataSniffer(m)
+	var relayed bytes.Buffer
+	if _, err := io.Copy(&relayed, s.TeeReader(iotest.OneByteReader(bytes.NewReader([]byte(data))))); err != nil {
+		t.Fatalf("failed to relay: %v", err)
+	}
+	if relayed.String() != data {
+		t.Errorf("relayed data was modified: %q", relayed.String())
+	}
+	if !s.Ended() {
+		t.Fatalf("want the terminating line to be detected")
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	r := m.Authenticate(net.ParseIP("192.0.2.1"), "mail.example.com", "alice@example.com")
+	if len(r.DKIM) != 1 || r.DKIM[0].VerifyResult.Status() != dkim.VerifyStatusPass {
+		t.Errorf("want dkim pass, but got %s", r.AuthenticationResults("mx.example.org"))
+	}
+}
+
+func TestDataSnifferUnstuff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     string
+		want     string
+		wantEnd  bool
+		wantBare bool
+	}{
+		{name: "terminated", data: "a\r\n.\r\n", want: "a\r\n", wantEnd: true},
+		{name: "stuffed dot", data: "..\r\n.\r\n", want: ".\r\n", wantEnd: true},
+		{name: "dot in line", data: "a.b\r\n.\r\n", want: "a.b\r\n", wantEnd: true},
+		{name: "dot and bare cr", data: ".\rx\r\n.\r\n", want: "\rx\r\n", wantEnd: true, wantBare: true},
+		{name: "bare lf", data: "a\n.\nb\r\n.\r\n", want: "a\n.\nb\r\n", wantEnd: true, wantBare: true},
+		{name: "bare lf after dot", data: "a\r\n.\nb\r\n.\r\n", want: "a\r\n\nb\r\n", wantEnd: true, wantBare: true},
+		{name: "bare cr", data: "a\r.\r\n.\r\n", want: "a\r.\r\n", wantEnd: true, wantBare: true},
+		{name: "not terminated", data: "a\r\n.", want: "a\r\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &DataSniffer{}
+			for i := 0; i < len(tc.data) && s.state != dataEnd; i++ {
+				s.unstuff(tc.data[i])
+			}
+			if string(s.out) != tc.want {
+				t.Errorf("want %q, but got %q", tc.want, s.out)
+			}
+			if (s.state == dataEnd) != tc.wantEnd {
+				t.Errorf("want end %v, but got %v", tc.wantEnd, s.state == dataEnd)
+			}
+			if s.bare != tc.wantBare {
+				t.Errorf("want bare %v, but got %v", tc.wantBare, s.bare)
+			}
+		})
+	}
+}
+
+func TestDataSnifferNotTerminated(t *testing.T) {
+	s := NewDataSniffer(NewMMAuth())
+	if _, err := s.Write([]byte("From: alice@example.com\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Ended() {
+		t.Errorf("want not ended")
+	}
+	if err := s.Close(); !errors.Is(err, ErrDataNotTerminated) {
+		t.Errorf("want %v, but got %v", ErrDataNotTerminated, err)
+	}
+}
+
+func TestDataSnifferBareLineEnding(t *testing.T) {
+	// LFのみの行末の"."で終わったとみなすと、後続の部分を検証せずに中継してしまう
+	data := "From: alice@example.com\r\n\r\nbody\n.\nMAIL FROM:<x@attacker.example>\r\n.\r\n"
+	s := NewDataSniffer(NewMMAuth())
+	if _, err := s.Write([]byte(data[:len(data)-3])); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Ended() {
+		t.Fatalf("want not ended at a bare LF")
+	}
+	if _, err := s.Write([]byte(data[len(data)-3:])); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !s.Ended() {
+		t.Fatalf("want the terminating line to be detected")
+	}
+	if err := s.Err(); !errors.Is(err, ErrBareLineEnding) {
+		t.Errorf("want %v, but got %v", ErrBareLineEnding, err)
+	}
+	if err := s.Close(); !errors.Is(err, ErrBareLineEnding) {
+		t.Errorf("want %v, but got %v", ErrBareLineEnding, err)
+	}
+}
@@ -0,0 +1,120 @@
+package spf
+
+import (
+	"fmt"
+	"net"
+)
+
+// RecordBuilder はSPFレコードを組み立てます。
+// 項は追加した順に並び、Build で構文を検証します。
+// RecordBuilder assembles an SPF record term by term; Build validates the syntax.
+//
+//	rec, err := spf.NewRecordBuilder().
+//		IP(spf.QualifierPass, ipnet).
+//		Include(spf.QualifierPass, "_spf.example.net").
+//		All(spf.QualifierFail).
+//		Build()
+type RecordBuilder struct {
+	rec Record
+}
+
+// NewRecordBuilder は空の RecordBuilder を返します。
+// NewRecordBuilder returns an empty RecordBuilder.
+func NewRecordBuilder() *RecordBuilder {
+	return &RecordBuilder{rec: Record{Version: "spf1"}}
+}
+
+// Mechanism は任意のメカニズムを追加します。
+// value はメカニズム名のあとに続く部分で、":" や "/" は含めません (例: ip4 の "192.0.2.0/24")。
+// a と mx の CIDR のみを指定する場合は "/24" のように "/" から始めます。
+// Mechanism appends a mechanism. value is what follows the name without the ":" separator;
+// for a bare a or mx CIDR length, start value with "/".
+func (b *RecordBuilder) Mechanism(q Qualifier, m Mechanism, value string) *RecordBuilder {
+	b.rec.Mechanisms = append(b.rec.Mechanisms, MechanismEntry{Mechanism: m, Value: value, Qualifier: q})
+	return b
+}
+
+// IP は ip4 または ip6 メカニズムを追加します。ホストアドレスの場合はプレフィックス長を省略します。
+// IP appends an ip4 or ip6 mechanism, omitting the prefix length for a single host.
+func (b *RecordBuilder) IP(q Qualifier, network *net.IPNet) *RecordBuilder {
+	ones, bits := network.Mask.Size()
+	value := network.IP.String()
+	if ones != bits {
+		value = fmt.Sprintf("%s/%d", value, ones)
+	}
+	if network.IP.To4() != nil {
+		return b.Mechanism(q, MechanismIP4, value)
+	}
+	return b.Mechanism(q, MechanismIP6, value)
+}
+
+// Include は include メカニズムを追加します。
+// Include appends an include mechanism.
+func (b *RecordBuilder) Include(q Qualifier, domain string) *RecordBuilder {
+	return b.Mechanism(q, MechanismInclude, domain)
+}
+
+// A は a メカニズムを追加します。domain が空の場合は評価中のドメインを使います。
+// A appends an a mechanism; an empty domain means the domain being evaluated.
+func (b *RecordBuilder) A(q Qualifier, domain string) *RecordBuilder {
+	return b.Mechanism(q, MechanismA, domain)
+}
+
+// MX は mx メカニズムを追加します。domain が空の場合は評価中のドメインを使います。
+// MX appends an mx mechanism; an empty domain means the domain being evaluated.
+func (b *RecordBuilder) MX(q Qualifier, domain string) *RecordBuilder {
+	return b.Mechanism(q, MechanismMX, domain)
+}
+
+// Exists は exists メカニズムを追加します。
+// Exists appends an exists mechanism.
+func (b *RecordBuilder) Exists(q Qualifier, domain string) *RecordBuilder {
+	return b.Mechanism(q, MechanismExists, domain)
+}
+
+// All は all メカニズムを追加します。
+// All appends the all mechanism.
+func (b *RecordBuilder) All(q Qualifier) *RecordBuilder {
+	return b.Mechanism(q, MechanismAll, "")
+}
+
+// Redirect は redirect= 修飾子を設定します。
+// Redirect sets the redirect= modifier.
+func (b *RecordBuilder) Redirect(domain string) *RecordBuilder {
+	return b.setModifier(ModifierRedirect, domain)
+}
+
+// Exp は exp= 修飾子を設定します。
+// Exp sets the exp= modifier.
+func (b *RecordBuilder) Exp(domain string) *RecordBuilder {
+	b.rec.Exp = domain
+	return b.setModifier(ModifierExp, domain)
+}
+
+func (b *RecordBuilder) setModifier(m Modifier, value string) *RecordBuilder {
+	for i := range b.rec.Modifiers {
+		if b.rec.Modifiers[i].Modifier == m {
+			b.rec.Modifiers[i].Value = value
+			return b
+		}
+	}
+	b.rec.Modifiers = append(b.rec.Modifiers, ModifierEntry{Modifier: m, Value: value})
+	return b
+}
+
+// String は組み立て中のレコードを返します。構文は検証しません。
+// String returns the record being built without validating it.
+func (b *RecordBuilder) String() string {
+	return b.rec.String()
+}
+
+// Build はレコードを ParseRecord で解析し直して返します。構文が誤っている場合はエラーを返します。
+// Build re-parses the record with ParseRecord and returns an error for invalid syntax.
+func (b *RecordBuilder) Build() (*Record, error) {
+	s := b.rec.String()
+	rec, res := ParseRecord(s)
+	if res != nil {
+		return nil, fmt.Errorf("invalid SPF record %q: %s", s, res.Reason)
+	}
+	return rec, nil
+}
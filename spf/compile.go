@@ -0,0 +1,204 @@
+package spf
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// ipSet は ip4, ip6 メカニズムを事前に解析し、アドレスの区間に分割したものです。
+// 区間はアドレスの昇順で重なりがなく、それぞれにその区間に最初にマッチするメカニズムの位置を持ちます。
+// ipSet holds ip4 and ip6 mechanisms pre-parsed into sorted, non-overlapping address
+// ranges, each labeled with the position of the first mechanism that matches it.
+type ipSet struct {
+	v4 []ipRange
+	v6 []ipRange
+}
+
+type ipRange struct {
+	first, last [net.IPv6len]byte
+	index       int
+}
+
+// Compile は ip4, ip6 メカニズムを事前に解析し、評価のたびにCIDRを解析せずに
+// 二分探索で送信元のアドレスを照合できるようにします。
+// キャッシュしたレコードを繰り返し評価する場合に、解析した後で一度だけ呼び出してください。
+// ip4, ip6 の値が不正な場合はエラーを返し、レコードは変更しません(評価で到達した時点で PermError になります)。
+// Compile の後に Mechanisms を変更した場合は、もう一度 Compile を呼び出してください。
+// 並行して評価しているレコードに対して呼び出すことはできません。
+//
+// Compile pre-parses the ip4 and ip6 mechanisms so that evaluation matches the
+// sender address by binary search instead of parsing CIDRs every time. Call it
+// once after parsing a record that is cached and evaluated repeatedly. If an
+// ip4 or ip6 value is invalid, it returns an error and leaves the record as is
+// (evaluation still results in PermError when the term is reached). Call it
+// again after changing Mechanisms. It must not be called while the record is
+// being evaluated concurrently.
+func (r *Record) Compile() error {
+	var v4, v6 []ipRange
+	for i, me := range r.Mechanisms {
+		if me.Mechanism != MechanismIP4 && me.Mechanism != MechanismIP6 {
+			continue
+		}
+		_, network, err := parseCIDRDefault(me.Value, me.Mechanism == MechanismIP4)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", me.Mechanism, err)
+		}
+		rng, family, ok := networkRange(network)
+		if !ok {
+			return fmt.Errorf("invalid %s: %s", me.Mechanism, me.Value)
+		}
+		rng.index = i
+		// 評価と同じく、ip4 は IPv4 の送信元だけ、ip6 は IPv4 でない送信元だけにマッチします
+		// As in evaluation, ip4 matches only IPv4 senders and ip6 only non-IPv4 senders
+		switch {
+		case me.Mechanism == MechanismIP4 && family == net.IPv4len:
+			v4 = append(v4, rng)
+		case me.Mechanism == MechanismIP6 && family == net.IPv6len:
+			v6 = append(v6, rng)
+		}
+	}
+	r.ipSet = &ipSet{v4: flattenRanges(v4), v6: flattenRanges(v6)}
+	return nil
+}
+
+// networkRange は net.IPNet.Contains と同じ規則でネットワークの先頭と末尾のアドレスを返します。
+// family はマッチするアドレスの長さ (net.IPv4len または net.IPv6len) です。
+// networkRange returns the first and last address of the network, following the
+// same rules as net.IPNet.Contains. family is the length of addresses it matches.
+func networkRange(n *net.IPNet) (rng ipRange, family int, ok bool) {
+	ip := n.IP.To4()
+	if ip == nil {
+		ip = n.IP
+	}
+	mask := n.Mask
+	if len(mask) == net.IPv6len && len(ip) == net.IPv4len {
+		mask = mask[12:]
+	}
+	if len(ip) != len(mask) || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return ipRange{}, 0, false
+	}
+	if ones, bits := mask.Size(); ones == 0 && bits == 0 {
+		// 連続していないマスク
+		// non-canonical mask
+		return ipRange{}, 0, false
+	}
+	off := net.IPv6len - len(ip)
+	for i := range ip {
+		rng.first[off+i] = ip[i] & mask[i]
+		rng.last[off+i] = ip[i] | ^mask[i]
+	}
+	return rng, len(ip), true
+}
+
+// flattenRanges はCIDRの区間を重なりのない区間に分割し、それぞれに最初にマッチするメカニズムの位置を付けます。
+// CIDRの区間は互いに含むか交わらないかのどちらかなので、外側の区間をスタックに積んで走査します。
+// flattenRanges splits CIDR ranges into non-overlapping ranges labeled with the
+// first matching mechanism. CIDR ranges are either nested or disjoint, so a
+// stack of enclosing ranges is enough.
+func flattenRanges(ranges []ipRange) []ipRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if c := bytes.Compare(ranges[i].first[:], ranges[j].first[:]); c != 0 {
+			return c < 0
+		}
+		if c := bytes.Compare(ranges[i].last[:], ranges[j].last[:]); c != 0 {
+			return c > 0
+		}
+		return ranges[i].index < ranges[j].index
+	})
+
+	var out []ipRange
+	var stack []ipRange
+	var pos [net.IPv6len]byte
+	exhausted := false // 末尾のアドレスまで出力した
+	emit := func(last [net.IPv6len]byte, index int) {
+		if exhausted || bytes.Compare(pos[:], last[:]) > 0 {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].index == index && isNextAddr(out[n-1].last, pos) {
+			out[n-1].last = last
+		} else {
+			out = append(out, ipRange{first: pos, last: last, index: index})
+		}
+		pos, exhausted = nextAddr(last)
+	}
+	for _, rng := range ranges {
+		for len(stack) > 0 && bytes.Compare(stack[len(stack)-1].last[:], rng.first[:]) < 0 {
+			top := stack[len(stack)-1]
+			emit(top.last, top.index)
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			if prev, underflow := prevAddr(rng.first); !underflow {
+				emit(prev, stack[len(stack)-1].index)
+			}
+		}
+		pos, exhausted = rng.first, false
+		if len(stack) > 0 && stack[len(stack)-1].index < rng.index {
+			rng.index = stack[len(stack)-1].index
+		}
+		stack = append(stack, rng)
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		emit(top.last, top.index)
+		stack = stack[:len(stack)-1]
+	}
+	return out
+}
+
+// nextAddr は a の次のアドレスを返します。a が末尾のアドレスの場合は overflow が true です。
+func nextAddr(a [net.IPv6len]byte) (next [net.IPv6len]byte, overflow bool) {
+	for i := len(a) - 1; i >= 0; i-- {
+		a[i]++
+		if a[i] != 0 {
+			return a, false
+		}
+	}
+	return a, true
+}
+
+func isNextAddr(a, b [net.IPv6len]byte) bool {
+	next, overflow := nextAddr(a)
+	return !overflow && next == b
+}
+
+// prevAddr は a の前のアドレスを返します。a が先頭のアドレスの場合は underflow が true です。
+func prevAddr(a [net.IPv6len]byte) (prev [net.IPv6len]byte, underflow bool) {
+	for i := len(a) - 1; i >= 0; i-- {
+		a[i]--
+		if a[i] != 0xff {
+			return a, false
+		}
+	}
+	return a, true
+}
+
+// lookup は ip に最初にマッチする ip4, ip6 メカニズムの位置を返します。マッチしない場合は -1 です。
+// lookup returns the position of the first ip4 or ip6 mechanism matching ip, or -1.
+func (s *ipSet) lookup(ip net.IP) int {
+	if ip == nil {
+		return -1
+	}
+	var key [net.IPv6len]byte
+	ranges := s.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(key[net.IPv6len-net.IPv4len:], ip4)
+		ranges = s.v4
+	} else if len(ip) == net.IPv6len {
+		copy(key[:], ip)
+	} else {
+		return -1
+	}
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].last[:], key[:]) >= 0
+	})
+	if i < len(ranges) && bytes.Compare(ranges[i].first[:], key[:]) <= 0 {
+		return ranges[i].index
+	}
+	return -1
+}
@@ -1,30 +1,90 @@
 package spf
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"time"
+
+	"github.com/masa23/mmauth/authres"
+	"github.com/masa23/mmauth/errcode"
+	"github.com/masa23/mmauth/internal/txtrecord"
 )
 
-type Status string
+// Status はSPFの評価結果です。authres.Resultの別名です。
+// Status is the SPF evaluation result, an alias of authres.Result.
+type Status = authres.Result
 
 const (
-	Pass      Status = "pass"
-	Fail      Status = "fail"
-	None      Status = "none"
-	SoftFail  Status = "softfail"
-	Neutral   Status = "neutral"
-	TempError Status = "temperror"
-	PermError Status = "permerror"
+	Pass      = authres.ResultPass
+	Fail      = authres.ResultFail
+	None      = authres.ResultNone
+	SoftFail  = authres.ResultSoftFail
+	Neutral   = authres.ResultNeutral
+	TempError = authres.ResultTempError
+	PermError = authres.ResultPermError
 )
 
 type Result struct {
 	Status Status
 	Reason string
+	// Domain は結果を決定したレコードのドメインです。
+	// redirect= で評価が移った場合は移動先のドメインになります。
+	// Domain is the domain whose record determined the result.
+	// When evaluation moved via redirect=, it is the redirect target.
+	Domain string
+	// Record は結果を決定したレコードの公開されている文字列です。
+	// ログや事後の調査で、評価したときのレコードをそのまま残すために使います。
+	// レコードを取得できなかった場合は空です。構文の誤りによる PermError では解析できなかったレコードです。
+	// Record is the published text of the record that determined the result,
+	// so logs and postmortems can keep exactly what was evaluated.
+	// It is empty when no record was retrieved; for a syntax PermError it is the record that failed to parse.
+	Record string
+	// All は Record の末尾の all の限定子です。all がない場合は空です。
+	// All is the qualifier of the terminal all in Record, or empty if there is none.
+	All Qualifier
+	// Identity と Sender はCheckHostで評価したIDと送信者です。
+	// MAIL FROMが空(<>)の場合は Identity が IdentityHelo、Sender が postmaster@<helo> になります。
+	// CheckSPFなど送信者を直接指定した場合は空です。
+	// Identity and Sender are the identity and sender evaluated by CheckHost.
+	// For an empty MAIL FROM (<>), Identity is IdentityHelo and Sender is postmaster@<helo>.
+	// They are empty when the sender was given directly, e.g. via CheckSPF.
+	Identity Identity
+	Sender   string
+	// Trace と Stats は Options.Trace が有効な場合の評価の記録と統計です。無効な場合は nil です。
+	// Trace and Stats hold the evaluation record and statistics when Options.Trace is set; nil otherwise.
+	Trace []TraceEntry
+	Stats *Stats
+	// PTRPolicy は評価が ptr メカニズムに到達した場合に、その扱い (Options.PTRPolicy) です。
+	// 到達しなかった場合は空です。
+	// PTRPolicy is how ptr was handled (Options.PTRPolicy) when evaluation reached it; empty otherwise.
+	PTRPolicy PTRPolicy
+
+	code errcode.Code
 }
 
+// Code は監視や集計に使う結果のコードです。Reason と異なり、版が変わっても同じ原因には同じ値を返します。
+// 原因を特定できない場合や pass・fail などの場合は SPF_PASS のように結果のみのコードです。
+// Code returns a stable code for alerting and analytics; unlike Reason it does not change between versions.
+// When the cause is not identified, e.g. for pass or fail, it is a result-only code such as SPF_PASS.
+func (r *Result) Code() errcode.Code {
+	if r.code != "" {
+		return r.code
+	}
+	return errcode.Status("spf", string(r.Status))
+}
+
+// Identity はSPFで評価するIDの種類です (RFC 7208 2.3, 2.4)。
+// Identity is the kind of identity checked by SPF (RFC 7208 2.3, 2.4).
+type Identity string
+
+const (
+	IdentityMailFrom Identity = "mailfrom"
+	IdentityHelo     Identity = "helo"
+)
+
 // TXTLookupFunc はTXTレコードを検索する関数型です。
 type TXTLookupFunc func(name string) ([]string, error)
 
@@ -52,24 +112,47 @@ type SPFResolver interface {
 	// RFC 7208 5.7 に対応するためのメソッド
 	lookupA(name string) ([]net.IP, *Result)
 	lookupAAAA(name string) ([]net.IP, *Result)
+	// ptr メカニズムと %{p} マクロで共有するPTR名の取得と検証
+	ptrNames(ip net.IP) []string
+	validatePTRName(name string, ip net.IP) bool
 }
 
 var (
 	ErrNoRecordFound = errors.New("no SPF record found")
 )
 
-// DefaultTXTResolver はデフォルトの TXT ルックアップ関数です。
-var DefaultTXTResolver TXTLookupFunc = net.LookupTXT
-var DefaultIPResolver IPLookupFunc = net.LookupIP
-var DefaultMXResolver MXLookupFunc = net.LookupMX
-var DefaultPTRResolver PTRLookupFunc = net.LookupAddr
+// Resolver は ctx を受け取る問い合わせのインターフェースです。*net.Resolver が満たします。
+// 評価の ctx の終了や Options.MaxTotalDNSTime で問い合わせ自体が打ち切られます。
+// Resolver is a lookup interface that takes a ctx; *net.Resolver satisfies it.
+// The lookup itself is canceled when the evaluation ctx is done or Options.MaxTotalDNSTime is reached.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// DefaultResolver は Default*Resolver の関数が nil の種類の問い合わせに使うリゾルバーです。
+// DefaultResolver is used for lookup kinds whose Default*Resolver func is nil.
+var DefaultResolver Resolver = net.DefaultResolver
+
+// DefaultTXTResolver などは問い合わせを置き換える関数です。nil の場合は DefaultResolver を使います。
+// ctx を受け取らないため、評価の期限で打ち切った問い合わせは応答が返るまでバックグラウンドで実行されます。
+// DefaultTXTResolver and friends replace lookups; nil uses DefaultResolver.
+// They take no ctx, so a lookup abandoned at the evaluation deadline keeps running in the background until it returns.
+var DefaultTXTResolver TXTLookupFunc
+var DefaultIPResolver IPLookupFunc
+var DefaultMXResolver MXLookupFunc
+var DefaultPTRResolver PTRLookupFunc
 
 // dnsResolverImpl は、SPF評価に必要なDNSルックアップ機能を提供します。
 type dnsResolverImpl struct {
-	txt TXTLookupFunc
-	ip  IPLookupFunc
-	mx  MXLookupFunc
-	ptr PTRLookupFunc
+	// 設定されている種類の問い合わせは関数で、それ以外は resolver で行います
+	txt      TXTLookupFunc
+	ip       IPLookupFunc
+	mx       MXLookupFunc
+	ptr      PTRLookupFunc
+	resolver Resolver
 
 	// SPF仕様によるDNSルックアップのデフォルト制限
 	// Default limit for DNS lookups according to SPF specification
@@ -85,6 +168,19 @@ type dnsResolverImpl struct {
 	// 訪問済みドメインの記録
 	// Record of visited domains
 	visitedDomains map[string]bool
+
+	// 評価オプション
+	opts Options
+	// 評価中のPTRルックアップと検証結果
+	ptrCache *ptrCache
+	// 評価のコンテキスト。終了後のルックアップはTempErrorになる
+	ctx context.Context
+	// Options.Trace が有効な場合の評価の記録
+	tracer *tracer
+	// 評価が ptr メカニズムに到達した場合の扱い
+	ptrPolicy PTRPolicy
+	// DNSの応答を待った時間の合計 (Options.MaxTotalDNSTime)
+	dnsTime time.Duration
 }
 
 // dnsImpl は基底の *dnsResolverImpl を公開します。
@@ -100,9 +196,11 @@ func newDNSResolver() *dnsResolverImpl {
 		mx:  DefaultMXResolver,
 		ptr: DefaultPTRResolver,
 
+		resolver: DefaultResolver,
+
 		// SPF仕様によるDNSルックアップのデフォルト制限
 		// Default limit for DNS lookups according to SPF specification
-		limit: 10,
+		limit: MaxDNSLookups,
 		// RFC 7208 4.6.4準拠の追加カウンター
 		// Additional counters compliant with RFC 7208 4.6.4
 		mxCount:   0,
@@ -114,6 +212,7 @@ func newDNSResolver() *dnsResolverImpl {
 		// 訪問済みドメインの記録
 		// Record of visited domains
 		visitedDomains: make(map[string]bool),
+		opts:           *DefaultOptions(),
 	}
 }
 
@@ -129,27 +228,51 @@ func (d *dnsResolverImpl) unmarkVisited(domain string) {
 	delete(d.visitedDomains, domain)
 }
 
+// lookupKind は問い合わせの種類です。
+type lookupKind int
+
+const (
+	lookupKindTXT lookupKind = iota
+	lookupKindIP
+	lookupKindMX
+	lookupKindPTR
+)
+
 // lookupType は指定されたタイプの DNS ルックアップを実行し、共通のロジックを処理します。
 // Performs a DNS lookup of the specified type and handles common logic.
-func (d *dnsResolverImpl) lookupType(name string, lookupFunc interface{}) (interface{}, *Result) {
+func (d *dnsResolverImpl) lookupType(name string, kind lookupKind) (interface{}, *Result) {
+	if d.ctx != nil {
+		if err := d.ctx.Err(); err != nil {
+			return nil, &Result{Status: TempError, code: errcode.SPFTempErrorCanceled, Reason: fmt.Sprintf("lookup canceled: %v", err)}
+		}
+	}
 	if res := incrementDNSLookupCounter(d); res != nil {
 		return nil, res
 	}
 
+	budget := d.opts.MaxTotalDNSTime - d.dnsTime
+	if d.opts.MaxTotalDNSTime > 0 && budget <= 0 {
+		return nil, &Result{Status: TempError, code: errcode.SPFTempErrorDNSTimeExceeded, Reason: errDNSTimeExceeded.Error()}
+	}
+	start := time.Now()
 	var result interface{}
 	var err error
-
-	switch f := lookupFunc.(type) {
-	case TXTLookupFunc:
-		result, err = f(name)
-	case IPLookupFunc:
-		result, err = f(name)
-	case MXLookupFunc:
-		result, err = f(name)
-	case PTRLookupFunc:
-		result, err = f(name)
-	default:
-		return nil, &Result{Status: PermError, Reason: "Unsupported lookup type"}
+	if f := d.lookupFunc(name, kind); f != nil {
+		result, err = d.call(f, budget)
+	} else {
+		result, err = d.callContext(name, kind, budget)
+	}
+	elapsed := time.Since(start)
+	d.dnsTime += elapsed
+	if d.tracer != nil {
+		d.tracer.queries++
+		d.tracer.lookupTime += elapsed
+	}
+	if errors.Is(err, errLookupCanceled) {
+		return nil, &Result{Status: TempError, code: errcode.SPFTempErrorCanceled, Reason: err.Error()}
+	}
+	if errors.Is(err, errDNSTimeExceeded) {
+		return nil, &Result{Status: TempError, code: errcode.SPFTempErrorDNSTimeExceeded, Reason: err.Error()}
 	}
 
 	if err != nil {
@@ -157,31 +280,29 @@ func (d *dnsResolverImpl) lookupType(name string, lookupFunc interface{}) (inter
 			// RFC 7208 4.6.4: void lookup は NXDOMAIN も含む
 			// RFC 7208 4.6.4: void lookup includes NXDOMAIN
 			d.voidCount++
-			if d.voidCount > 2 {
-				return nil, &Result{Status: PermError, Reason: "Void lookup limit exceeded"}
+			if d.voidCount > MaxVoidLookups {
+				return nil, &Result{Status: PermError, code: errcode.SPFPermErrorTooManyVoidLookups, Reason: "Void lookup limit exceeded"}
 			}
 			// Return empty slice based on the lookup type
-			switch lookupFunc.(type) {
-			case TXTLookupFunc:
+			switch kind {
+			case lookupKindTXT, lookupKindPTR:
 				return []string{}, nil
-			case IPLookupFunc:
+			case lookupKindIP:
 				return []net.IP{}, nil
-			case MXLookupFunc:
+			case lookupKindMX:
 				return []*net.MX{}, nil
-			case PTRLookupFunc:
-				return []string{}, nil
 			}
 		}
 		// Handle specific error cases for different lookup types
 		// 異なるルックアップタイプの特定のエラー処理
-		switch lookupFunc.(type) {
-		case TXTLookupFunc:
-			return nil, &Result{Status: TempError, Reason: fmt.Sprintf("TXT lookup error: %v", err)}
-		case IPLookupFunc:
-			return nil, &Result{Status: TempError, Reason: fmt.Sprintf("IP lookup error: %v", err)}
-		case MXLookupFunc:
-			return nil, &Result{Status: TempError, Reason: fmt.Sprintf("MX lookup error: %v", err)}
-		case PTRLookupFunc:
+		switch kind {
+		case lookupKindTXT:
+			return nil, &Result{Status: TempError, code: errcode.SPFTempErrorDNS, Reason: fmt.Sprintf("TXT lookup error: %v", err)}
+		case lookupKindIP:
+			return nil, &Result{Status: TempError, code: errcode.SPFTempErrorDNS, Reason: fmt.Sprintf("IP lookup error: %v", err)}
+		case lookupKindMX:
+			return nil, &Result{Status: TempError, code: errcode.SPFTempErrorDNS, Reason: fmt.Sprintf("MX lookup error: %v", err)}
+		case lookupKindPTR:
 			// RFC 7208: PTR ルックアップの失敗は単に空の結果と見なす
 			// RFC 7208: PTR lookup failures are simply treated as empty results
 			return []string{}, nil
@@ -204,37 +325,152 @@ func (d *dnsResolverImpl) lookupType(name string, lookupFunc interface{}) (inter
 		d.voidCount++
 		// ただし、voidCountが2以上の場合はエラーを返す (void-over-limitテスト対応)
 		// However, if voidCount is 2 or more, return an error (for void-over-limit test compatibility)
-		if d.voidCount > 2 {
-			return nil, &Result{Status: PermError, Reason: "Void lookup limit exceeded"}
+		if d.voidCount > MaxVoidLookups {
+			return nil, &Result{Status: PermError, code: errcode.SPFPermErrorTooManyVoidLookups, Reason: "Void lookup limit exceeded"}
 		}
 	}
 
 	return result, nil
 }
 
+// errLookupCanceled は応答を待たずに評価の期限で問い合わせを打ち切った場合のエラーです。
+var errLookupCanceled = errors.New("lookup canceled")
+
+// errDNSTimeExceeded は Options.MaxTotalDNSTime に達して問い合わせを打ち切った場合のエラーです。
+var errDNSTimeExceeded = errors.New("total DNS time limit exceeded")
+
+// lookupFunc は kind の問い合わせを置き換える関数が設定されていれば、それを呼び出す関数を返します。
+// lookupFunc returns a func calling the replacement lookup for kind, or nil if none is set.
+func (d *dnsResolverImpl) lookupFunc(name string, kind lookupKind) func() (interface{}, error) {
+	switch {
+	case kind == lookupKindTXT && d.txt != nil:
+		return func() (interface{}, error) { return d.txt(name) }
+	case kind == lookupKindIP && d.ip != nil:
+		return func() (interface{}, error) { return d.ip(name) }
+	case kind == lookupKindMX && d.mx != nil:
+		return func() (interface{}, error) { return d.mx(name) }
+	case kind == lookupKindPTR && d.ptr != nil:
+		return func() (interface{}, error) { return d.ptr(name) }
+	}
+	return nil
+}
+
+// callContext は Resolver で問い合わせを実行します。
+// 評価の ctx と、Options.MaxTotalDNSTime が指定されている場合は残り時間 budget を問い合わせの ctx に渡すため、
+// 期限を過ぎた問い合わせはリゾルバー自身が打ち切ります。
+// callContext runs a lookup through the Resolver. The evaluation ctx and, with
+// Options.MaxTotalDNSTime set, the remaining budget are passed down in the lookup ctx,
+// so the resolver itself cancels the lookup at the deadline.
+func (d *dnsResolverImpl) callContext(name string, kind lookupKind, budget time.Duration) (interface{}, error) {
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	lctx := ctx
+	if d.opts.MaxTotalDNSTime > 0 {
+		var cancel context.CancelFunc
+		lctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	r := d.resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	var v interface{}
+	var err error
+	switch kind {
+	case lookupKindTXT:
+		v, err = r.LookupTXT(lctx, name)
+	case lookupKindIP:
+		v, err = r.LookupIP(lctx, "ip", name)
+	case lookupKindMX:
+		v, err = r.LookupMX(lctx, name)
+	case lookupKindPTR:
+		v, err = r.LookupAddr(lctx, name)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %v", errLookupCanceled, ctx.Err())
+		}
+		if lctx.Err() != nil {
+			return nil, errDNSTimeExceeded
+		}
+	}
+	return v, err
+}
+
+// call は置き換えられた問い合わせの関数を実行します。
+// 関数は ctx を受け取らないため、ctx がキャンセル可能な場合は応答を待たずに ctx の終了で打ち切ります。
+// Options.MaxTotalDNSTime が指定されている場合は、残り時間 budget を過ぎても打ち切ります。
+// 打ち切った問い合わせは応答が返るまでバックグラウンドで実行されます。
+// call runs a replacement lookup func. The func takes no ctx, so when ctx can be canceled
+// the lookup is abandoned as soon as ctx is done. With Options.MaxTotalDNSTime set, it is
+// also abandoned once the remaining budget is spent. An abandoned lookup keeps running in the background until it returns.
+func (d *dnsResolverImpl) call(f func() (interface{}, error), budget time.Duration) (interface{}, error) {
+	var done <-chan struct{}
+	if d.ctx != nil {
+		done = d.ctx.Done()
+	}
+	limited := d.opts.MaxTotalDNSTime > 0
+	if done == nil && !limited {
+		return f()
+	}
+	var timeout <-chan time.Time
+	if limited {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	type answer struct {
+		v   interface{}
+		err error
+	}
+	ch := make(chan answer, 1)
+	go func() {
+		v, err := f()
+		ch <- answer{v: v, err: err}
+	}()
+	select {
+	case a := <-ch:
+		return a.v, a.err
+	case <-done:
+		return nil, fmt.Errorf("%w: %v", errLookupCanceled, d.ctx.Err())
+	case <-timeout:
+		return nil, errDNSTimeExceeded
+	}
+}
+
 func (d *dnsResolverImpl) lookupTXT(name string) ([]string, *Result) {
-	result, res := d.lookupType(name, d.txt)
+	result, res := d.lookupType(name, lookupKindTXT)
 	if res != nil {
 		return nil, res
 	}
 	return result.([]string), nil
 }
 func (d *dnsResolverImpl) lookupIP(name string) ([]net.IP, *Result) {
-	result, res := d.lookupType(name, d.ip)
+	result, res := d.lookupType(name, lookupKindIP)
 	if res != nil {
 		return nil, res
 	}
-	return result.([]net.IP), nil
+	ips := result.([]net.IP)
+	if max := d.opts.maxAddressRecords(); len(ips) > max {
+		return nil, &Result{Status: PermError, code: errcode.SPFPermErrorTooManyRecords, Reason: fmt.Sprintf("too many address records for %s (%d > %d)", name, len(ips), max)}
+	}
+	return ips, nil
 }
 func (d *dnsResolverImpl) lookupMX(name string) ([]*net.MX, *Result) {
-	result, res := d.lookupType(name, d.mx)
+	result, res := d.lookupType(name, lookupKindMX)
 	if res != nil {
 		return nil, res
 	}
-	return result.([]*net.MX), nil
+	mxs := result.([]*net.MX)
+	if max := d.opts.maxMXRecords(); len(mxs) > max {
+		return nil, &Result{Status: PermError, code: errcode.SPFPermErrorTooManyRecords, Reason: fmt.Sprintf("too many MX records for %s (%d > %d)", name, len(mxs), max)}
+	}
+	return mxs, nil
 }
 func (d *dnsResolverImpl) lookupPTR(addr string) ([]string, *Result) {
-	result, res := d.lookupType(addr, d.ptr)
+	result, res := d.lookupType(addr, lookupKindPTR)
 	if res != nil {
 		return nil, res
 	}
@@ -280,6 +516,29 @@ func (d *dnsResolverImpl) lookupRecord(domain string) (*Record, *Result) {
 	if result != nil {
 		return nil, result
 	}
+	// 悪意のあるゾーンに備えてレコード数を制限します
+	// Limit the number of records to guard against hostile zones
+	if max := d.opts.maxTXTRecords(); len(records) > max {
+		return nil, &Result{Status: PermError, code: errcode.SPFPermErrorTooManyRecords, Reason: fmt.Sprintf("too many TXT records (%d > %d)", len(records), max)}
+	}
+
+	// SPFレコードに制御文字があれば、解析の途中のエラーではなく区別できる PermError にします
+	// 同じ名前の無関係なレコードは、制御文字を含んでいても無視します
+	// Report control characters in an SPF record as a distinct PermError rather than a parse error.
+	// Unrelated records at the same name are ignored even if they contain them.
+	sanitized := make([]string, 0, len(records))
+	for _, rec := range records {
+		if d.opts.StripTrailingNUL {
+			rec = txtrecord.StripNUL(rec)
+		}
+		if parts := strings.Fields(rec); len(parts) > 0 && strings.EqualFold(parts[0], "v=spf1") {
+			if err := txtrecord.Check(rec); err != nil {
+				return nil, &Result{Status: PermError, code: errcode.SPFPermErrorSyntax, Reason: err.Error(), Record: rec}
+			}
+		}
+		sanitized = append(sanitized, rec)
+	}
+	records = sanitized
 
 	found := 0
 	validRecords := []string{}
@@ -310,20 +569,29 @@ func (d *dnsResolverImpl) lookupRecord(domain string) (*Record, *Result) {
 	// SPFレコードのように見えるレコードが複数ある場合は、permerrorを返します
 	// If multiple records look like SPF records, return permerror
 	if spfLikeCount > 1 {
-		return nil, &Result{Status: PermError, Reason: "multiple SPF records found"}
+		return nil, &Result{Status: PermError, code: errcode.SPFPermErrorMultipleRecords, Reason: "multiple SPF records found"}
 	}
 
 	if found == 1 {
-		parsedRecord, parseResult := ParseRecord(validRecords[0])
+		if max := d.opts.maxRecordLength(); len(validRecords[0]) > max {
+			return nil, &Result{Status: PermError, code: errcode.SPFPermErrorRecordTooLong, Reason: fmt.Sprintf("SPF record is too long (%d > %d bytes)", len(validRecords[0]), max)}
+		}
+		parse := ParseRecord
+		if d.opts.DeferUnknownMechanisms {
+			parse = ParseRecordLenient
+		}
+		parsedRecord, parseResult := parse(validRecords[0])
 		if parseResult != nil {
 			// ParseRecordでエラーが発生した場合は、そのエラーを返す
-			// ParseRecordでエラーが発生した場合は、そのエラーを返す
+			// 構文の誤りを調べられるように、レコードの文字列も返します
+			// Keep the record text so the syntax error can be inspected
+			parseResult.Record = validRecords[0]
 			return nil, parseResult
 		}
 		return parsedRecord, nil
 	}
 	if found > 1 {
-		return nil, &Result{Status: PermError, Reason: "multiple SPF records found"}
+		return nil, &Result{Status: PermError, code: errcode.SPFPermErrorMultipleRecords, Reason: "multiple SPF records found"}
 	}
 	// SPFレコードが見つからなかった場合、noneを返す
 	// 無効なレコードが見つかった場合、permerrorを返す
@@ -337,17 +605,24 @@ func (d *dnsResolverImpl) lookupRecord(domain string) (*Record, *Result) {
 			parts := strings.Fields(trimmedRec)
 			if len(parts) > 0 && strings.HasPrefix(strings.ToLower(parts[0]), "v=") &&
 				strings.ToLower(strings.TrimPrefix(parts[0], "v=")) == "spf1" {
-				return nil, &Result{Status: PermError, Reason: "malformed SPF record"}
+				return nil, &Result{Status: PermError, code: errcode.SPFPermErrorSyntax, Reason: "malformed SPF record"}
 			}
 		}
-		return nil, &Result{Status: None, Reason: "no SPF record found"}
+		return nil, &Result{Status: None, code: errcode.SPFNoneNoRecord, Reason: "no SPF record found"}
 	}
-	return nil, &Result{Status: None, Reason: "no TXT records found"}
+	return nil, &Result{Status: None, code: errcode.SPFNoneNoRecord, Reason: "no TXT records found"}
 }
 
 // CheckSPF はSPFレコードを評価して結果を返します。
 func (d *dnsResolverImpl) CheckSPF(ip net.IP, domain, sender, helo string) *Result {
 	now := time.Now()
+	if d.opts.Trace {
+		d.tracer = &tracer{start: now}
+	}
+	return d.attachTrace(d.attachPTRPolicy(d.checkSPF(ip, domain, sender, helo, now)))
+}
+
+func (d *dnsResolverImpl) checkSPF(ip net.IP, domain, sender, helo string, now time.Time) *Result {
 	// RFC 7208 4.3 初期処理
 	// HELOドメインの有効性をチェックします
 	// HELOがIPリテラルの場合は有効です
@@ -372,37 +647,32 @@ func (d *dnsResolverImpl) CheckSPF(ip net.IP, domain, sender, helo string) *Resu
 	// 	}
 	// }
 
+	// 国際化ドメイン名はA-labelに変換してから評価します
+	// Internationalized domain names are converted to A-labels before evaluation
+	domain, sender, helo, ok := toASCIIIdentities(domain, sender, helo)
+	if !ok {
+		return &Result{Status: None, code: errcode.SPFNoneInvalidDomain, Reason: "invalid domain"}
+	}
+
 	// RFC 7208 4.3 初期処理
 	// ドメインの有効性をチェックします
 	// RFC 7208 4.3 Initial processing
 	// Check the validity of the domain
 	if !isValidDomain(domain) {
-		return &Result{Status: None, Reason: "invalid domain"}
+		return &Result{Status: None, code: errcode.SPFNoneInvalidDomain, Reason: "invalid domain"}
 	}
 
 	// 送信者にローカルパートがない場合は、postmasterを使用します
 	// If the sender has no local part, use postmaster
-	if sender == "" || !strings.Contains(sender, "@") {
-		sender = "postmaster@" + domain
-	} else if strings.HasPrefix(sender, "@") {
-		// ローカルパートが空の場合もpostmasterを使用
-		// Also use postmaster if the local part is empty
-		sender = "postmaster@" + domain
-	} else if strings.Contains(sender, "@") {
-		// ローカルパートが空の場合もpostmasterを使用
-		// Also use postmaster if the local part is empty
-		parts := strings.Split(sender, "@")
-		if len(parts) == 2 && parts[0] == "" {
-			sender = "postmaster@" + domain
-		}
-	}
+	sender = defaultSender(sender, domain)
 
 	rec, res := d.lookupRecord(domain)
 	if res != nil {
+		res.Domain = domain
 		return res
 	}
 
-	return rec.Evaluate(ip, domain, sender, helo, now, SPFResolver(d), 0)
+	return rec.evaluate(ip, domain, sender, helo, now, SPFResolver(d), 0)
 }
 
 // --- ヘルパー: RFC 7208 4.6.4 term カウンター ---
@@ -426,7 +696,7 @@ func incrementDNSMechanismCounter(resv SPFResolver) *Result {
 		d.termCounter++
 		// DNSメカニズムの制限をチェックします（DNSルックアップを必要とするメカニズムの最大数は10）。
 		if d.termCounter > 10 {
-			return &Result{Status: PermError, Reason: "DNS mechanism limit exceeded"}
+			return &Result{Status: PermError, code: errcode.SPFPermErrorTooManyLookups, Reason: "DNS mechanism limit exceeded"}
 		}
 	}
 	return nil
@@ -1,32 +1,163 @@
 package spf
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
 	"time"
+
+	"github.com/masa23/mmauth/errcode"
+)
+
+// MaxEvalDepth は include と redirect の入れ子の上限です。
+// 超えた場合は PermError になります。
+// MaxEvalDepth is the maximum nesting of include and redirect.
+const MaxEvalDepth = 10
+
+const (
+	// MaxDNSLookups はDNSルックアップを伴う項の評価回数の上限です (RFC 7208 4.6.4)。
+	// MaxDNSLookups is the limit on terms that cause DNS lookups (RFC 7208 4.6.4).
+	MaxDNSLookups = 10
+	// MaxVoidLookups は応答が空(NXDOMAINを含む)だったルックアップの上限です (RFC 7208 4.6.4)。
+	// MaxVoidLookups is the limit on lookups with empty answers, including NXDOMAIN (RFC 7208 4.6.4).
+	MaxVoidLookups = 2
 )
 
+// EvalInput は Record.EvaluateContext に渡す評価の入力です。
+// EvalInput holds the inputs for Record.EvaluateContext.
+type EvalInput struct {
+	// IP は送信元のIPアドレスです。
+	IP net.IP
+	// Domain はこのレコードを取得したドメインで、マクロの %{d} になります。
+	Domain string
+	// Sender は MAIL FROM のアドレスです。空またはローカルパートがない場合は postmaster@Domain を使います。
+	Sender string
+	// Helo は HELO/EHLO のドメインです。
+	Helo string
+	// Now はマクロ %{t} に使う時刻です。ゼロ値の場合は現在時刻を使います。
+	Now time.Time
+	// Options は評価オプションです。nilの場合は DefaultOptions を使います。
+	Options *Options
+	// Resolver はDNSの問い合わせに使うリゾルバーで、評価の ctx を受け取ります。
+	// nilの場合は DefaultResolver と Default*Resolver を使います。
+	// Resolver performs the DNS lookups with the evaluation ctx.
+	// If nil, DefaultResolver and the Default*Resolver funcs are used.
+	Resolver Resolver
+
+	// resolver はテストで差し替えるリゾルバーです。指定した場合は Resolver より優先します。
+	resolver SPFResolver
+}
+
+// EvaluateContext はレコードを評価して結果を返します。
+//
+// include と redirect で参照したレコードも同じ評価の中で再帰的に評価され、
+// 入れ子が MaxEvalDepth を超えると PermError になります。
+// RFC 7208 4.6.4 のDNSルックアップ回数の制限は入れ子全体で共有されます。
+// ctx が終了すると、応答待ちのものを含めてDNSルックアップは TempError になります。
+//
+// EvaluateContext evaluates the record. Records referenced by include and redirect are
+// evaluated recursively within the same evaluation; nesting deeper than
+// MaxEvalDepth results in PermError and the RFC 7208 4.6.4 lookup limits are
+// shared across the whole evaluation. Once ctx is done, DNS lookups, including
+// one still waiting for an answer, result in TempError.
+func (r *Record) EvaluateContext(ctx context.Context, in EvalInput) *Result {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	now := in.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	resv := in.resolver
+	if resv == nil {
+		d := newDNSResolver()
+		if in.Options != nil {
+			d.opts = *in.Options
+		}
+		if in.Resolver != nil {
+			// すべての問い合わせを指定されたリゾルバーで行います
+			// All lookups go through the given resolver
+			d.txt, d.ip, d.mx, d.ptr = nil, nil, nil, nil
+			d.resolver = in.Resolver
+		}
+		resv = d
+	}
+	var d *dnsResolverImpl
+	if di, ok := resv.(interface{ dnsImpl() *dnsResolverImpl }); ok {
+		d = di.dnsImpl()
+		d.ctx = ctx
+		if d.opts.Trace {
+			d.tracer = &tracer{start: time.Now()}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return &Result{Status: TempError, code: errcode.SPFTempErrorCanceled, Reason: fmt.Sprintf("evaluation canceled: %v", err)}
+	}
+	res := r.evaluate(in.IP, in.Domain, defaultSender(in.Sender, in.Domain), in.Helo, now, resv, 0)
+	if d != nil {
+		res = d.attachTrace(d.attachPTRPolicy(res))
+	}
+	return res
+}
+
+// Evaluate は位置引数でレコードを評価します。
+//
+// Deprecated: EvaluateContext(ctx, EvalInput) を使ってください。
+// Deprecated: use EvaluateContext(ctx, EvalInput) instead.
 func (r *Record) Evaluate(ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int) *Result {
-	if depth > 10 {
-		return &Result{Status: PermError, Reason: "include/redirect depth exceeded"}
+	return r.evaluate(ip, domain, sender, helo, now, resv, depth)
+}
+
+func (r *Record) evaluate(ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int) *Result {
+	if depth > MaxEvalDepth {
+		return &Result{Status: PermError, code: errcode.SPFPermErrorNestingTooDeep, Reason: "include/redirect depth exceeded"}
 	}
 
 	// 1) mechanisms
 	res := r.evaluateMechanisms(ip, domain, sender, helo, now, resv, depth)
 
 	// 2) redirect modifier
+	// RFC 7208 6.1: メカニズムがマッチしなかった場合のみ評価し、移動先の結果をそのまま使います。
+	// 元のレコードの exp= は移動先の評価には適用されません。
+	// RFC 7208 6.1: evaluated only if no mechanism matched, and the target's result is used as is.
+	// The exp= of the original record does not apply to the redirected evaluation.
 	res = r.handleRedirectModifier(res, ip, domain, sender, helo, now, resv, depth)
 
 	// 3) 何もマッチしなければ Neutral (RFC 7208 4.7/1)
+	// 移動先で決まった結果のドメインは上書きしません
+	// Do not overwrite the domain of a result decided by the redirect target
+	if res != nil && res.Domain == "" {
+		res.Domain = domain
+		res.Record = r.Raw
+		res.All = r.AllQualifier()
+	}
 	return res
 }
 
 func (r *Record) evaluateMechanisms(ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int) *Result {
 	var last *Result
 
-	for _, me := range r.Mechanisms {
-		match, mres := r.matchMechanism(me, ip, domain, sender, helo, now, resv, depth)
+	// Compile 済みのレコードは ip4, ip6 メカニズムを1回の探索で照合します
+	// A compiled record matches all ip4 and ip6 mechanisms with a single lookup
+	ipMatch := -1
+	if r.ipSet != nil {
+		ipMatch = r.ipSet.lookup(ip)
+	}
+
+	for i, me := range r.Mechanisms {
+		var match bool
+		var mres *Result
+		if r.ipSet != nil && (me.Mechanism == MechanismIP4 || me.Mechanism == MechanismIP6) {
+			match = i == ipMatch
+		} else {
+			end := func(bool, *Result) {}
+			if isDNSMechanism(me.Mechanism) {
+				end = beginTerm(resv, depth, domain, me.String())
+			}
+			match, mres = r.matchMechanism(me, ip, domain, sender, helo, now, resv, depth)
+			end(match, mres)
+		}
 		if mres != nil { // Temp/Perm error
 			return mres
 		}
@@ -68,7 +199,7 @@ func (r *Record) handleExpModifier(result *Result, ip net.IP, domain, sender, he
 	// exp=の値が空白のみの場合は PermError (RFC 7208 6.2/4)
 	// If the exp= value consists only of whitespace, it is a PermError (RFC 7208 6.2/4)
 	if strings.TrimSpace(r.Exp) == "" {
-		return nil, &Result{Status: PermError, Reason: "exp= domain-spec is empty"}
+		return nil, &Result{Status: PermError, code: errcode.SPFPermErrorSyntax, Reason: "exp= domain-spec is empty"}
 	}
 
 	if res := incrementDNSMechanismCounter(resv); res != nil {
@@ -166,8 +297,8 @@ func (r *Record) handleExpModifier(result *Result, ip net.IP, domain, sender, he
 }
 
 func (r *Record) handleRedirectModifier(current *Result, ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int) *Result {
-	if depth > 10 {
-		return &Result{Status: PermError, Reason: "include/redirect depth exceeded"}
+	if depth > MaxEvalDepth {
+		return &Result{Status: PermError, code: errcode.SPFPermErrorNestingTooDeep, Reason: "include/redirect depth exceeded"}
 	}
 
 	redir := r.getModifier(ModifierRedirect)
@@ -187,6 +318,14 @@ func (r *Record) handleRedirectModifier(current *Result, ip net.IP, domain, send
 		return current
 	}
 
+	end := beginTerm(resv, depth, domain, ModifierEntry{Modifier: ModifierRedirect, Value: redir}.String())
+	res := r.redirect(redir, ip, domain, sender, helo, now, resv, depth)
+	end(false, res)
+	return res
+}
+
+// redirect は redirect= の移動先のレコードを評価します。
+func (r *Record) redirect(redir string, ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int) *Result {
 	if res := incrementDNSMechanismCounter(resv); res != nil {
 		return res
 	}
@@ -207,7 +346,7 @@ func (r *Record) handleRedirectModifier(current *Result, ip net.IP, domain, send
 
 	// 循環参照のチェック
 	if resv.isVisited(expandedRedir) {
-		return &Result{Status: PermError, Reason: "circular reference detected in redirect"}
+		return &Result{Status: PermError, code: errcode.SPFPermErrorLoop, Reason: "circular reference detected in redirect"}
 	}
 
 	// 訪問済みドメインの記録
@@ -217,12 +356,12 @@ func (r *Record) handleRedirectModifier(current *Result, ip net.IP, domain, send
 	rec, res := resv.lookupRecord(expandedRedir)
 	if res != nil {
 		if res.Status == None {
-			return &Result{Status: PermError, Reason: "redirect domain has no SPF record"}
+			return &Result{Status: PermError, code: errcode.SPFPermErrorRedirectNoRecord, Reason: "redirect domain has no SPF record"}
 		}
 		return res
 	}
 
-	return rec.Evaluate(ip, expandedRedir, sender, helo, now, resv, depth+1)
+	return rec.evaluate(ip, expandedRedir, sender, helo, now, resv, depth+1)
 }
 
 func qualToStatus(q Qualifier) Status {
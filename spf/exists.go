@@ -0,0 +1,117 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+)
+
+// RBLDomainSpec は exists で zone を DNSBL/DNSWL のように引く domain-spec (%{ir}.zone) を返します。
+// %{ir} は IPv4 ではオクテット、IPv6 ではニブルを逆順にしたものに展開されます (RFC 7208 7.3)。
+// RBLDomainSpec returns the domain-spec (%{ir}.zone) for querying zone as a DNSBL/DNSWL via exists.
+// %{ir} expands to the reversed octets for IPv4 and the reversed nibbles for IPv6 (RFC 7208 7.3).
+func RBLDomainSpec(zone string) string {
+	return "%{ir}." + strings.TrimSuffix(zone, ".")
+}
+
+// RBL は zone を引く exists メカニズムを追加します。
+// RBL appends an exists mechanism that queries zone.
+func (b *RecordBuilder) RBL(q Qualifier, zone string) *RecordBuilder {
+	return b.Exists(q, RBLDomainSpec(zone))
+}
+
+// ExpandExists は exists の domain-spec を DNS に問い合わせずに展開します。
+// sender が空の場合は postmaster@helo を使います。
+// PTR の問い合わせが必要な %{p} と、exists で使えない CIDR はエラーになります。
+// ExpandExists expands an exists domain-spec without querying DNS.
+// An empty sender means postmaster@helo. %{p}, which needs a PTR lookup,
+// and a CIDR length, which exists does not allow, are errors.
+func ExpandExists(domainSpec string, ip net.IP, sender, helo string) (string, error) {
+	host, v4bits, v6bits, err := splitHostAndDualCIDR(domainSpec)
+	if err != nil {
+		return "", fmt.Errorf("invalid exists domain-spec: %v", err)
+	}
+	if v4bits != -1 || v6bits != -1 {
+		return "", errors.New("exists domain-spec must not contain CIDR")
+	}
+	if sender == "" {
+		sender = "postmaster@" + helo
+	}
+	domain := helo
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		domain = sender[at+1:]
+	}
+	// CheckSPFと同じく、ドメインはA-labelに変換し、ローカルパートはUTF-8のまま展開します (RFC 8616 4)
+	// As in CheckSPF, domains are converted to A-labels and the local part is expanded as UTF-8 (RFC 8616 4).
+	domain, sender, helo, ok := toASCIIIdentities(domain, sender, helo)
+	if !ok {
+		return "", errors.New("invalid internationalized domain")
+	}
+	ctx := MacroContext{
+		IP:          ip,
+		Domain:      domain,
+		Sender:      sender,
+		Helo:        helo,
+		DNSResolver: staticMacroResolver{},
+	}
+	expanded, res := expandDomainSpec(host, ctx, MacroPurposeDomainSpec)
+	if res != nil {
+		return "", errors.New(res.Reason)
+	}
+	return expanded, nil
+}
+
+// CheckExists は exists メカニズムと同じく、展開した名前に A レコードがあるかを返します。
+// NXDOMAIN と NODATA は false です。lookup が nil の場合は DefaultIPResolver、それも nil の場合は DefaultResolver を使います。
+// CheckExists reports whether the expanded name has an A record, as the exists mechanism does.
+// NXDOMAIN and NODATA yield false. A nil lookup uses DefaultIPResolver, or DefaultResolver if that is nil too.
+func CheckExists(domainSpec string, ip net.IP, sender, helo string, lookup IPLookupFunc) (bool, error) {
+	name, err := ExpandExists(domainSpec, ip, sender, helo)
+	if err != nil {
+		return false, err
+	}
+	if lookup == nil {
+		lookup = DefaultIPResolver
+	}
+	if lookup == nil {
+		lookup = func(name string) ([]net.IP, error) {
+			return DefaultResolver.LookupIP(context.Background(), "ip", name)
+		}
+	}
+	ips, err := lookup(name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("exists lookup for %s: %w", name, err)
+	}
+	// RFC 7208 5.7: 接続がIPv6でもAレコードのみを確認する
+	// RFC 7208 5.7: only A records count, even for IPv6 connections
+	for _, a := range ips {
+		if a.To4() != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// staticMacroResolver は PTR を問い合わせずにマクロを展開します。
+// staticMacroResolver expands macros without PTR lookups.
+type staticMacroResolver struct{}
+
+func (staticMacroResolver) ReplaceMacroValues(s string, ctx MacroContext, purpose MacroPurpose) (string, error) {
+	tokens, err := parseMacroString(s)
+	if err != nil {
+		return "", err
+	}
+	for _, tok := range tokens {
+		if tok.Kind == TokenMacro && unicode.ToLower(tok.Macro.Letter) == rune(MacroClientPTR) {
+			return "", errors.New("macro %{p} requires a PTR lookup")
+		}
+	}
+	return replaceMacroTokens(tokens, ctx.Sender, ctx.Domain, ctx.Helo, ctx.Receiver, ctx.IP, ctx.Now.Unix(), "", purpose)
+}
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+
+	"github.com/masa23/mmauth/internal/idn"
 )
 
 func isSPFRecord(record string) bool {
@@ -365,3 +367,37 @@ func isValidDomainSpecWithoutColon(domainSpec string) bool {
 	}
 	return true
 }
+
+// toASCIIIdentities はdomain、senderのドメイン部、HELOに含まれるU-labelをA-labelに変換します
+// senderのローカルパートはUTF-8のまま残し、%{l}と%{s}はUTF-8のまま、
+// %{L}と%{S}はUTF-8のオクテットごとにURLエンコードして展開します (RFC 8616 4)
+// toASCIIIdentities converts U-labels in domain, the domain part of sender and HELO to A-labels.
+// The local part of sender stays UTF-8: %{l} and %{s} expand to UTF-8 and %{L} and %{S}
+// URL-encode each UTF-8 octet (RFC 8616 4).
+func toASCIIIdentities(domain, sender, helo string) (string, string, string, bool) {
+	var err error
+	if domain, err = idn.ToASCII(domain); err != nil {
+		return "", "", "", false
+	}
+	if i := strings.LastIndex(sender, "@"); i >= 0 {
+		d, err := idn.ToASCII(sender[i+1:])
+		if err != nil {
+			return "", "", "", false
+		}
+		sender = sender[:i+1] + d
+	}
+	if h, err := idn.ToASCII(helo); err == nil {
+		helo = h
+	}
+	return domain, sender, helo, true
+}
+
+// defaultSender は送信者にローカルパートがない場合に postmaster@domain を返します。
+// If the sender has no local part, defaultSender returns postmaster@domain.
+func defaultSender(sender, domain string) string {
+	at := strings.LastIndex(sender, "@")
+	if at <= 0 {
+		return "postmaster@" + domain
+	}
+	return sender
+}
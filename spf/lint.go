@@ -0,0 +1,65 @@
+package spf
+
+import "fmt"
+
+// LintIssue は Lint が検出したレコードの問題です。
+// LintIssue is a problem found in a record by Lint.
+type LintIssue struct {
+	// Term は問題のある項です。レコード全体の問題の場合は空です。
+	Term string
+	// Message は問題の説明です。
+	Message string
+}
+
+// String は問題を人が読める形式で返します。
+func (l LintIssue) String() string {
+	if l.Term == "" {
+		return l.Message
+	}
+	return fmt.Sprintf("%s: %s", l.Term, l.Message)
+}
+
+// Lint はレコードを静的に検査し、評価の結果に関わらず問題になる項を報告します。
+// 評価では到達しない項(マッチする all より後ろ)や不明なメカニズムも報告します。
+// Lint statically checks a record and reports problematic terms, including
+// terms that evaluation never reaches (after all) and unknown mechanisms.
+func Lint(record string) []LintIssue {
+	rec, res := ParseRecordLenient(record)
+	if res != nil {
+		return []LintIssue{{Message: res.Reason}}
+	}
+
+	var issues []LintIssue
+	afterAll := false
+	for _, me := range rec.Mechanisms {
+		if afterAll {
+			issues = append(issues, LintIssue{
+				Term:    me.String(),
+				Message: "term after all is never evaluated",
+			})
+		}
+		switch {
+		case !me.Mechanism.IsKnown():
+			issues = append(issues, LintIssue{
+				Term:    me.String(),
+				Message: "unknown mechanism (permerror by RFC 7208 4.6)",
+			})
+		case me.Mechanism == MechanismPTR:
+			issues = append(issues, LintIssue{
+				Term:    me.String(),
+				Message: "ptr mechanism should not be used (RFC 7208 5.5)",
+			})
+		case me.Mechanism == MechanismAll:
+			afterAll = true
+		}
+	}
+	if rec.AllExists {
+		if redir := rec.getModifier(ModifierRedirect); redir != "" {
+			issues = append(issues, LintIssue{
+				Term:    "redirect=" + redir,
+				Message: "redirect is ignored because all is present (RFC 7208 6.1)",
+			})
+		}
+	}
+	return issues
+}
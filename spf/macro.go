@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/masa23/mmauth/errcode"
 )
 
 // DNSResolverインターフェースは、DNSルックアップ機能を提供します。
@@ -33,17 +35,9 @@ func (d *dnsResolverImpl) ReplaceMacroValues(domainSpec string, ctx MacroContext
 				}
 			}
 
-			// PTRルックアップ
-			ptrRecords, res := d.lookupPTR(ctx.IP.String())
-			if res != nil && res.Status != Pass {
-				// PTRルックアップが失敗した場合は、元の実装に従って「unknown」を使用する
-				ptr = "unknown"
-			} else if len(ptrRecords) > 0 {
-				// pyspf suite / RFC 7208 safety: process at most 10 PTR names.
-				if len(ptrRecords) > 10 {
-					ptrRecords = ptrRecords[:10]
-				}
-
+			// PTRルックアップ (ptr メカニズムと結果を共有する)
+			ptrRecords := d.ptrNames(ctx.IP)
+			if len(ptrRecords) > 0 {
 				// PTR RR count is folded into the global 10-term DNS mechanism limit.
 				// %{p} 側には "ptr" メカニズムの事前 1 term が存在しないため、
 				// ptrRecords の残り分 (len-1) を追加で消費します。
@@ -58,31 +52,14 @@ func (d *dnsResolverImpl) ReplaceMacroValues(domainSpec string, ctx MacroContext
 				// RFC 7208 7.3: p マクロは検証済みのドメイン名に展開される
 				// 検証された最初のPTRレコードを使用する
 				for _, ptrRecord := range ptrRecords {
-					trimmedPTR := strings.TrimSuffix(ptrRecord, ".")
-					// Validate PTR record by doing A/AAAA lookup
-					ips, res2 := d.lookupIP(trimmedPTR)
-					if res2 != nil {
-						continue
-					}
-					if len(ips) > 10 {
-						ips = ips[:10]
-					}
-					for _, ip := range ips {
-						if ip.Equal(ctx.IP) {
-							ptr = trimmedPTR
-							break
-						}
-					}
-					if ptr != "" {
+					if d.validatePTRName(ptrRecord, ctx.IP) {
+						ptr = strings.TrimSuffix(ptrRecord, ".")
 						break
 					}
 				}
-				// 検証済みのPTRレコードが見つからない場合は「unknown」を使用する
-				if ptr == "" {
-					ptr = "unknown"
-				}
-			} else {
-				// PTRレコードが見つからない
+			}
+			// 検証済みのPTRレコードが見つからない場合は「unknown」を使用する
+			if ptr == "" {
 				ptr = "unknown"
 			}
 			break
@@ -107,13 +84,13 @@ func expandDomainSpec(domainSpec string, ctx MacroContext, purpose MacroPurpose)
 	// 1) マクロ展開
 	expanded, err := ctx.DNSResolver.ReplaceMacroValues(domainSpec, ctx, purpose)
 	if err != nil {
-		return "", &Result{Status: PermError, Reason: "macro expansion error: " + err.Error()}
+		return "", &Result{Status: PermError, code: errcode.SPFPermErrorMacro, Reason: "macro expansion error: " + err.Error()}
 	}
 
 	// 2) SPF的に最低限の妥当性チェック（空とか末尾ドットとかは弾く）
 	expanded = strings.TrimSpace(expanded)
 	if expanded == "" {
-		return "", &Result{Status: PermError, Reason: "empty domain-spec after macro expansion"}
+		return "", &Result{Status: PermError, code: errcode.SPFPermErrorMacro, Reason: "empty domain-spec after macro expansion"}
 	}
 	// ここは厳密にやるならさらに: ラベル長/全体長/許容文字など
 	// 最低でも " " や制御文字を含むなら弾く、くらいはおすすめです。
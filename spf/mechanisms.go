@@ -1,9 +1,12 @@
 package spf
 
 import (
+	"fmt"
 	"net"
 	"strings"
 	"time"
+
+	"github.com/masa23/mmauth/errcode"
 )
 
 func (r *Record) matchMechanism(me MechanismEntry, ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int) (bool, *Result) {
@@ -52,6 +55,13 @@ func (r *Record) matchMechanism(me MechanismEntry, ip net.IP, domain, sender, he
 		}
 		return r.matchExistsMechanism(me, ip, domain, sender, helo, resv, depth, ctx)
 	case MechanismPTR:
+		policy := ptrPolicyOf(resv)
+		switch policy {
+		case PTRPolicySkip:
+			return false, nil
+		case PTRPolicyPermError:
+			return false, &Result{Status: PermError, code: errcode.SPFPermErrorPTRNotAllowed, Reason: "ptr mechanism is not allowed"}
+		}
 		// RFC 7208 4.6.4 term counter
 		// RFC 7208 4.6.4 用語カウンター
 		if res := incrementDNSMechanismCounter(resv); res != nil {
@@ -59,7 +69,7 @@ func (r *Record) matchMechanism(me MechanismEntry, ip net.IP, domain, sender, he
 		}
 		return r.matchPTRMechanism(me, ip, domain, sender, helo, resv, depth, ctx)
 	default:
-		return false, &Result{Status: PermError, Reason: "unsupported mechanism"}
+		return false, &Result{Status: PermError, Reason: fmt.Sprintf("unknown mechanism: %s", me.Mechanism)}
 	}
 }
 
@@ -209,7 +219,7 @@ func (r *Record) matchMXMechanism(me MechanismEntry, ip net.IP, domain, sender,
 			return false, res2
 		}
 		if len(ips) > 10 {
-			return false, &Result{Status: PermError, Reason: "too many A/AAAA records for MX host"}
+			return false, &Result{Status: PermError, code: errcode.SPFPermErrorTooManyRecords, Reason: "too many A/AAAA records for MX host"}
 		}
 		for _, dip := range ips {
 			if dualCIDRMatch(ip, dip, v4bits, v6bits) {
@@ -221,8 +231,8 @@ func (r *Record) matchMXMechanism(me MechanismEntry, ip net.IP, domain, sender,
 }
 
 func (r *Record) matchIncludeMechanism(me MechanismEntry, ip net.IP, domain, sender, helo string, now time.Time, resv SPFResolver, depth int, ctx MacroContext) (bool, *Result) {
-	if depth > 10 {
-		return false, &Result{Status: PermError, Reason: "include/redirect depth exceeded"}
+	if depth > MaxEvalDepth {
+		return false, &Result{Status: PermError, code: errcode.SPFPermErrorNestingTooDeep, Reason: "include/redirect depth exceeded"}
 	}
 
 	incDomain := me.Value
@@ -253,7 +263,7 @@ func (r *Record) matchIncludeMechanism(me MechanismEntry, ip net.IP, domain, sen
 
 	// 循環参照のチェック
 	if resv.isVisited(expandedIncDomain) {
-		return false, &Result{Status: PermError, Reason: "circular reference detected in include"}
+		return false, &Result{Status: PermError, code: errcode.SPFPermErrorLoop, Reason: "circular reference detected in include"}
 	}
 
 	// 訪問済みドメインの記録
@@ -263,12 +273,12 @@ func (r *Record) matchIncludeMechanism(me MechanismEntry, ip net.IP, domain, sen
 	rec, res := resv.lookupRecord(expandedIncDomain)
 	if res != nil {
 		if res.Status == None {
-			return false, &Result{Status: PermError, Reason: "include domain has no SPF record"}
+			return false, &Result{Status: PermError, code: errcode.SPFPermErrorIncludeNoRecord, Reason: "include domain has no SPF record"}
 		}
 		return false, res
 	}
 
-	ires := rec.Evaluate(ip, expandedIncDomain, sender, helo, now, resv, depth+1)
+	ires := rec.evaluate(ip, expandedIncDomain, sender, helo, now, resv, depth+1)
 
 	if ires.Status == Pass {
 		return true, nil
@@ -315,17 +325,8 @@ func (r *Record) matchExistsMechanism(me MechanismEntry, ip net.IP, domain, send
 }
 
 func (r *Record) matchPTRMechanism(me MechanismEntry, ip net.IP, domain, sender, helo string, resv SPFResolver, depth int, ctx MacroContext) (bool, *Result) {
-	targets, res := resv.lookupPTR(ip.String())
-	if res != nil {
-		// PTR lookup errors are ignored
-		targets = []string{}
-	}
-
-	// pyspf suite / RFC 7208 safety: process at most 10 PTR names.
-	// pyspfスイート/RFC 7208の安全性：最大10個のPTR名を処理します。
-	if len(targets) > 10 {
-		targets = targets[:10]
-	}
+	// PTR名の取得 (%{p} マクロと結果を共有し、上限は Options.MaxPTRRecords)
+	targets := resv.ptrNames(ip)
 
 	// RFC 7208 4.6.4:
 	// PTR RR count is folded into the global 10-term DNS mechanism limit.
@@ -357,17 +358,8 @@ func (r *Record) matchPTRMechanism(me MechanismEntry, ip net.IP, domain, sender,
 		if expandedDomainToCheck == "" || strings.HasSuffix(strings.ToLower(trimmedTarget), strings.ToLower(expandedDomainToCheck)) {
 			// For implicit domain (when domainToCheck is empty), we just need to validate that
 			// the PTR record resolves back to the same IP
-			ips, res2 := resv.lookupIP(trimmedTarget)
-			if res2 != nil {
-				continue
-			}
-			if len(ips) > 10 {
-				ips = ips[:10]
-			}
-			for _, dip := range ips {
-				if dip.Equal(ip) {
-					return true, nil
-				}
+			if resv.validatePTRName(trimmedTarget, ip) {
+				return true, nil
 			}
 		}
 	}
@@ -0,0 +1,160 @@
+package spf
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// DefaultMaxPTRRecords は ptr メカニズムと %{p} マクロで処理するPTR名の上限です。
+	DefaultMaxPTRRecords = 10
+	// DefaultMaxValidationIPs はPTR名の検証(A/AAAAルックアップ)で比較するアドレスの上限です。
+	DefaultMaxValidationIPs = 10
+	// DefaultMaxTXTRecords は1回のTXTルックアップで受け付けるレコード数の上限です。
+	DefaultMaxTXTRecords = 64
+	// DefaultMaxRecordLength はSPFレコードとして評価する文字列の長さの上限(バイト)です。
+	// 複数の文字列に分割されたTXTレコードは連結後の長さで判定します。
+	DefaultMaxRecordLength = 4096
+	// DefaultMaxMXRecords は mx メカニズムで処理するMXレコード数の上限です (RFC 7208 4.6.4)。
+	DefaultMaxMXRecords = 10
+	// DefaultMaxAddressRecords は1回のA/AAAAルックアップで受け付けるアドレス数の上限です。
+	DefaultMaxAddressRecords = 128
+)
+
+// PTRPolicy は ptr メカニズムに到達したときの扱いです。
+// RFC 7208 5.5 は ptr メカニズムを使わないよう求めており、評価にはPTRと前方確認の問い合わせが必要です。
+// PTRPolicy is how a reached ptr mechanism is handled. RFC 7208 5.5 discourages
+// ptr, and evaluating it costs PTR and forward-confirmation lookups.
+type PTRPolicy string
+
+const (
+	// PTRPolicyEvaluate は ptr メカニズムを RFC 7208 5.5 に従って評価します。デフォルトです。
+	// PTRPolicyEvaluate evaluates ptr per RFC 7208 5.5. This is the default.
+	PTRPolicyEvaluate PTRPolicy = "evaluate"
+	// PTRPolicySkip は ptr メカニズムを問い合わせずにマッチしなかったものとして扱います。
+	// DNSルックアップの回数にも数えません。
+	// PTRPolicySkip treats ptr as not matching without any lookups; it is not
+	// counted against the DNS lookup limit either.
+	PTRPolicySkip PTRPolicy = "skip"
+	// PTRPolicyPermError は ptr メカニズムに到達した時点で PermError にします。
+	// PTRPolicyPermError results in PermError when ptr is reached.
+	PTRPolicyPermError PTRPolicy = "permerror"
+)
+
+// Options はSPF評価の動作を調整するためのオプションです。
+// ゼロ値のフィールドはデフォルト値として扱われます。
+type Options struct {
+	// MaxPTRRecords は ptr メカニズムと %{p} マクロで処理するPTR名の上限です。
+	MaxPTRRecords int
+	// MaxValidationIPs はPTR名の検証で比較するA/AAAAアドレスの上限です。
+	MaxValidationIPs int
+	// DeferUnknownMechanisms が true の場合、不明なメカニズムは評価で到達したときだけ
+	// PermError にします(例えばマッチした all より後ろにある場合は無視されます)。
+	// RFC 7208 4.6 はレコード中のどこにある構文エラーも PermError とするため、デフォルトは false です。
+	DeferUnknownMechanisms bool
+	// StripTrailingNUL が true の場合、一部の壊れたDNSサーバーがTXTレコードの末尾に付けるNULを
+	// 取り除いてから評価します。RFC 7208 のテストスイートではNULを含むレコードは PermError のため、
+	// デフォルトは false です。DKIMの鍵とDMARCでは domainkey.StripTrailingNUL と
+	// dmarc.StripTrailingNUL で同じ扱いを指定します。
+	StripTrailingNUL bool
+	// PTRPolicy は ptr メカニズムの扱いです。空の場合は PTRPolicyEvaluate です。
+	// 選んだ扱いは Result.PTRPolicy と、Trace が有効な場合は TraceEntry.PTRPolicy に記録されます。
+	PTRPolicy PTRPolicy
+
+	// 以下は悪意のあるゾーンに対してメモリと処理量を抑えるための上限です。
+	// 上限を超える応答はPermErrorになります。
+	// The following limits bound memory and work on hostile zones.
+	// Responses exceeding a limit result in PermError.
+
+	// MaxTXTRecords はSPFレコードを探すTXT応答のレコード数の上限です。
+	MaxTXTRecords int
+	// MaxRecordLength はSPFレコードの長さの上限(バイト)です。
+	MaxRecordLength int
+	// MaxMXRecords は mx メカニズムで処理するMXレコード数の上限です。
+	MaxMXRecords int
+	// MaxAddressRecords は a, mx メカニズムなどのA/AAAA応答のアドレス数の上限です。
+	MaxAddressRecords int
+
+	// MaxTotalDNSTime は1回の評価でDNSの応答を待つ時間の合計の上限です。0 の場合は上限なしです。
+	// 項の数が少なくても、応答の遅い権威サーバーによってMTAが止まらないようにするためのものです (RFC 7208 4.6.4)。
+	// 上限に達すると応答待ちの問い合わせを打ち切り、評価は TempError になります。
+	// MaxTotalDNSTime caps the total time spent waiting for DNS answers in one evaluation; zero means no cap.
+	// It keeps slow authoritative servers from stalling the MTA even when few terms are evaluated (RFC 7208 4.6.4).
+	// When the cap is reached, the pending lookup is canceled and evaluation results in TempError.
+	MaxTotalDNSTime time.Duration
+
+	// Trace が true の場合、DNSルックアップを伴う項ごとの経過時間を Result.Trace に、
+	// 問い合わせの回数と時間の合計を Result.Stats に記録します。
+	Trace bool
+}
+
+// DefaultOptions はデフォルトのOptionsを返します。
+func DefaultOptions() *Options {
+	return &Options{
+		MaxPTRRecords:     DefaultMaxPTRRecords,
+		MaxValidationIPs:  DefaultMaxValidationIPs,
+		MaxTXTRecords:     DefaultMaxTXTRecords,
+		MaxRecordLength:   DefaultMaxRecordLength,
+		MaxMXRecords:      DefaultMaxMXRecords,
+		MaxAddressRecords: DefaultMaxAddressRecords,
+	}
+}
+
+func (o *Options) maxPTRRecords() int {
+	if o == nil || o.MaxPTRRecords <= 0 {
+		return DefaultMaxPTRRecords
+	}
+	return o.MaxPTRRecords
+}
+
+func (o *Options) maxValidationIPs() int {
+	if o == nil || o.MaxValidationIPs <= 0 {
+		return DefaultMaxValidationIPs
+	}
+	return o.MaxValidationIPs
+}
+
+func (o *Options) ptrPolicy() PTRPolicy {
+	if o == nil || o.PTRPolicy == "" {
+		return PTRPolicyEvaluate
+	}
+	return o.PTRPolicy
+}
+
+func (o *Options) maxTXTRecords() int {
+	if o == nil || o.MaxTXTRecords <= 0 {
+		return DefaultMaxTXTRecords
+	}
+	return o.MaxTXTRecords
+}
+
+func (o *Options) maxRecordLength() int {
+	if o == nil || o.MaxRecordLength <= 0 {
+		return DefaultMaxRecordLength
+	}
+	return o.MaxRecordLength
+}
+
+func (o *Options) maxMXRecords() int {
+	if o == nil || o.MaxMXRecords <= 0 {
+		return DefaultMaxMXRecords
+	}
+	return o.MaxMXRecords
+}
+
+func (o *Options) maxAddressRecords() int {
+	if o == nil || o.MaxAddressRecords <= 0 {
+		return DefaultMaxAddressRecords
+	}
+	return o.MaxAddressRecords
+}
+
+// CheckSPFWithOptions はオプションを指定してSPFチェックを行います。
+// optsがnilの場合はCheckSPFと同じです。
+func CheckSPFWithOptions(ip net.IP, domain, sender, helo string, opts *Options) *Result {
+	resolver := newDNSResolver()
+	if opts != nil {
+		resolver.opts = *opts
+	}
+	return resolver.CheckSPF(ip, domain, sender, helo)
+}
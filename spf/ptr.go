@@ -0,0 +1,93 @@
+package spf
+
+import (
+	"net"
+	"strings"
+)
+
+// ptrCache は1回の評価の中でPTRルックアップと検証の結果を保持します。
+// ptr メカニズムと %{p} マクロが同じレコードに含まれる場合に、
+// 同じPTRルックアップと前方確認を繰り返さないようにします。
+type ptrCache struct {
+	ip        net.IP
+	looked    bool
+	names     []string
+	validated map[string]bool
+}
+
+// ptrNames は ip のPTR名を最大 MaxPTRRecords 件返します。
+// ルックアップに失敗した場合は空として扱います。
+func (d *dnsResolverImpl) ptrNames(ip net.IP) []string {
+	if d.ptrCache != nil && d.ptrCache.looked && d.ptrCache.ip.Equal(ip) {
+		return d.ptrCache.names
+	}
+	names, res := d.lookupPTR(ip.String())
+	if res != nil {
+		names = nil
+	}
+	if max := d.opts.maxPTRRecords(); len(names) > max {
+		names = names[:max]
+	}
+	d.ptrCache = &ptrCache{
+		ip:        ip,
+		looked:    true,
+		names:     names,
+		validated: make(map[string]bool),
+	}
+	return names
+}
+
+// validatePTRName は name のA/AAAAレコードに ip が含まれるかを確認します(前方確認)。
+// 結果は評価中キャッシュされます。
+func (d *dnsResolverImpl) validatePTRName(name string, ip net.IP) bool {
+	key := strings.ToLower(strings.TrimSuffix(name, "."))
+	if d.ptrCache != nil && d.ptrCache.ip.Equal(ip) {
+		if ok, exists := d.ptrCache.validated[key]; exists {
+			return ok
+		}
+	}
+	ok := false
+	ips, res := d.lookupIP(strings.TrimSuffix(name, "."))
+	if res == nil {
+		if max := d.opts.maxValidationIPs(); len(ips) > max {
+			ips = ips[:max]
+		}
+		for _, addr := range ips {
+			if addr.Equal(ip) {
+				ok = true
+				break
+			}
+		}
+	}
+	if d.ptrCache == nil || !d.ptrCache.ip.Equal(ip) {
+		d.ptrCache = &ptrCache{ip: ip, validated: make(map[string]bool)}
+	}
+	d.ptrCache.validated[key] = ok
+	return ok
+}
+
+// ptrPolicyOf は ptr メカニズムの扱いを返し、評価の結果と記録に残します。
+// ptr の項の記録は直前に beginTerm で確保されているため、最後の記録に付けます。
+// ptrPolicyOf returns how to handle ptr and notes it for the result and trace.
+// The entry for the ptr term was just reserved by beginTerm, so it is the last one.
+func ptrPolicyOf(resv SPFResolver) PTRPolicy {
+	di, ok := resv.(interface{ dnsImpl() *dnsResolverImpl })
+	if !ok {
+		return PTRPolicyEvaluate
+	}
+	d := di.dnsImpl()
+	policy := d.opts.ptrPolicy()
+	d.ptrPolicy = policy
+	if t := d.tracer; t != nil && len(t.entries) > 0 {
+		t.entries[len(t.entries)-1].PTRPolicy = policy
+	}
+	return policy
+}
+
+// attachPTRPolicy は評価が ptr メカニズムに到達した場合にその扱いを結果に付けます。
+func (d *dnsResolverImpl) attachPTRPolicy(res *Result) *Result {
+	if res != nil && d.ptrPolicy != "" {
+		res.PTRPolicy = d.ptrPolicy
+	}
+	return res
+}
@@ -1,13 +1,13 @@
 package spf
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
-	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -604,28 +604,9 @@ func runSPFCheck(resv SPFResolver, hostIP, helo, mailfrom string) *Result {
 	}
 	sender = strings.Trim(sender, `"`)
 
-	var domain string
-	if sender == "" {
-		// null reverse-path => postmaster@helo
-		if helo == "" {
-			return &Result{Status: None, Reason: "invalid HELO"}
-		}
-		sender = "postmaster@" + helo
-		domain = helo
-	} else {
-		at := strings.LastIndex(sender, "@")
-		if at < 0 {
-			// 単純なドメインとして扱う（RFC 7208 4.1に従って）
-			domain = sender
-			sender = "postmaster@" + domain
-		} else {
-			local := sender[:at]
-			domain = sender[at+1:]
-			if local == "" {
-				// local-partがない => postmasterに置き換え
-				sender = "postmaster@" + domain
-			}
-		}
+	domain, sender, _ := deriveIdentity(sender, helo)
+	if domain == "" {
+		return &Result{Status: None, Reason: "invalid HELO"}
 	}
 
 	// 初期処理：無効なドメイン => none
@@ -638,8 +619,13 @@ func runSPFCheck(resv SPFResolver, hostIP, helo, mailfrom string) *Result {
 		// SPFがない場合に"none"がここで浮き出る；スイートはそれを期待しています
 		return rr
 	}
-	now := time.Now()
-	return rec.Evaluate(ip, domain, sender, helo, now, resv, 0)
+	return rec.EvaluateContext(context.Background(), EvalInput{
+		IP:       ip,
+		Domain:   domain,
+		Sender:   sender,
+		Helo:     helo,
+		resolver: resv,
+	})
 }
 
 func statusFromString(s string) Status {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/masa23/mmauth/errcode"
 )
 
 type Mechanism string
@@ -53,6 +55,8 @@ type Record struct {
 	Modifiers  []ModifierEntry
 	Exp        string // exp= 修飾子の値（生の、未展開の状態）
 	AllExists  bool   // allメカニズムが存在するかどうか
+
+	ipSet *ipSet // Compile で事前に解析した ip4, ip6 メカニズム
 }
 
 func parseQualifier(part string) (Qualifier, string) {
@@ -95,8 +99,94 @@ func validateMacroSyntax(s string) error {
 	return err
 }
 
+// IsKnown は RFC 7208 で定義されたメカニズムかどうかを返します。
+// IsKnown reports whether m is a mechanism defined by RFC 7208.
+func (m Mechanism) IsKnown() bool {
+	switch m {
+	case MechanismAll, MechanismInclude, MechanismA, MechanismMX,
+		MechanismIP4, MechanismIP6, MechanismPTR, MechanismExists:
+		return true
+	}
+	return false
+}
+
+// String は項をレコード中の表記で返します。
+// String returns the term as written in a record.
+func (me MechanismEntry) String() string {
+	s := string(me.Mechanism)
+	if me.Qualifier != "" && me.Qualifier != QualifierPass {
+		s = string(me.Qualifier) + s
+	}
+	switch {
+	case me.Value == "":
+	case strings.HasPrefix(me.Value, "/"):
+		s += me.Value
+	default:
+		s += ":" + me.Value
+	}
+	return s
+}
+
+// String は修飾子をレコード中の表記で返します。
+// String returns the modifier as written in a record.
+func (me ModifierEntry) String() string {
+	return string(me.Modifier) + "=" + me.Value
+}
+
+// String はレコードを正規の表記で返します。
+// 修飾子 + は省略し、メカニズムのあとに redirect=、exp= の順に並べます。
+// exp= はマクロを展開する前の値 (Exp) を使います。解析時に無視された不明な修飾子は含みません。
+// String returns the record in canonical form: the default + qualifier is omitted and
+// modifiers follow the mechanisms, redirect= before exp=. exp= uses the unexpanded Exp value.
+// Unknown modifiers dropped while parsing are not included.
+func (r *Record) String() string {
+	var sb strings.Builder
+	sb.WriteString("v=spf1")
+	for _, me := range r.Mechanisms {
+		sb.WriteByte(' ')
+		sb.WriteString(me.String())
+	}
+	for _, name := range []Modifier{ModifierRedirect, ModifierExp} {
+		for _, m := range r.Modifiers {
+			if m.Modifier != name {
+				continue
+			}
+			if name == ModifierExp && r.Exp != "" {
+				m.Value = r.Exp
+			}
+			sb.WriteByte(' ')
+			sb.WriteString(m.String())
+		}
+	}
+	return sb.String()
+}
+
 // ParseRecord は SPF レコード文字列を Record 構造体に解析します。
+// RFC 7208 4.6 に従い、不明なメカニズムがどこにあっても PermError になります。
 func ParseRecord(record string) (*Record, *Result) {
+	return parseRecord(record, false)
+}
+
+// ParseRecordLenient は不明なメカニズムをエラーにせずに保持して解析します。
+// 保持された不明なメカニズムは、評価で到達した時点で PermError になります。
+// それ以外の構文エラーは ParseRecord と同じく PermError です。
+// ParseRecordLenient keeps unknown mechanisms instead of failing; evaluation
+// returns PermError only when such a term is reached.
+func ParseRecordLenient(record string) (*Record, *Result) {
+	return parseRecord(record, true)
+}
+
+// 構文の誤りによる PermError には SPF_PERMERROR_SYNTAX のコードを付けます
+// Syntax PermErrors carry the SPF_PERMERROR_SYNTAX code.
+func parseRecord(record string, lenient bool) (*Record, *Result) {
+	rec, res := parseRecordTerms(record, lenient)
+	if res != nil && res.Status == PermError && res.code == "" {
+		res.code = errcode.SPFPermErrorSyntax
+	}
+	return rec, res
+}
+
+func parseRecordTerms(record string, lenient bool) (*Record, *Result) {
 	var rec Record
 	rec.Raw = record
 	// RFC 7208 4.5/2 に従って末尾のスペースをトリム
@@ -358,7 +448,11 @@ func ParseRecord(record string) (*Record, *Result) {
 				return nil, &Result{Status: PermError, Reason: "invalid domain-spec for ptr"}
 			}
 		default:
-			return nil, &Result{Status: PermError, Reason: fmt.Sprintf("unknown mechanism: %s", mechName)}
+			if !lenient {
+				return nil, &Result{Status: PermError, Reason: fmt.Sprintf("unknown mechanism: %s", mechName)}
+			}
+			// 評価で到達した時点でエラーにするため、そのまま保持します
+			// Kept as is so that evaluation fails only when the term is reached
 		}
 
 		rec.Mechanisms = append(rec.Mechanisms, MechanismEntry{
@@ -1,9 +1,111 @@
 package spf
 
-import "net"
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/masa23/mmauth/errcode"
+)
 
 // CheckSPF performs an SPF check for the given IP, domain, sender, and HELO.
 func CheckSPF(ip net.IP, domain, sender, helo string) *Result {
 	resolver := newDNSResolver()
 	return resolver.CheckSPF(ip, domain, sender, helo)
 }
+
+// CheckSPFContext はctxを指定してSPFチェックを行います。
+// include や redirect をたどる途中でも ctx の期限を過ぎると、応答待ちの問い合わせを打ち切って TempError を返します。
+// optsがnilの場合はデフォルトのオプションを使います。
+// CheckSPFContext performs an SPF check bounded by ctx. Once ctx is done, even in the
+// middle of an include or redirect chain, the pending lookup is canceled and TempError is returned.
+// A nil opts uses the default options.
+func CheckSPFContext(ctx context.Context, ip net.IP, domain, sender, helo string, opts *Options) *Result {
+	if err := ctx.Err(); err != nil {
+		return &Result{Status: TempError, code: errcode.SPFTempErrorCanceled, Reason: fmt.Sprintf("evaluation canceled: %v", err), Domain: domain}
+	}
+	resolver := newDNSResolver()
+	if opts != nil {
+		resolver.opts = *opts
+	}
+	resolver.ctx = ctx
+	return resolver.CheckSPF(ip, domain, sender, helo)
+}
+
+// CheckHelo はHELO/EHLOのIDのみでSPFチェックを行います (RFC 7208 2.3)。
+// 送信者は postmaster@<helo> として評価します。
+// HELOがIPリテラルの場合はチェックできないため None を返します。
+// CheckHelo performs an SPF check of the HELO/EHLO identity only (RFC 7208 2.3).
+// The sender is evaluated as postmaster@<helo>.
+// An IP literal HELO cannot be checked and yields None.
+func CheckHelo(ip net.IP, helo string) *Result {
+	return CheckHeloWithOptions(ip, helo, nil)
+}
+
+// CheckHeloWithOptions はオプションを指定してCheckHeloを行います。
+// CheckHeloWithOptions is CheckHelo with options.
+func CheckHeloWithOptions(ip net.IP, helo string, opts *Options) *Result {
+	helo = strings.TrimSuffix(helo, ".")
+	if helo == "" || strings.HasPrefix(helo, "[") || net.ParseIP(helo) != nil {
+		return &Result{Status: None, code: errcode.SPFNoneInvalidDomain, Reason: "helo is not a domain", Domain: helo}
+	}
+	return CheckSPFWithOptions(ip, helo, "postmaster@"+helo, helo, opts)
+}
+
+// CheckHost はMAIL FROMとHELOからRFC 7208 4.1に従って評価するドメインと送信者を決め、SPFチェックを行います。
+// MAIL FROMが空または<>の場合(バウンス)は postmaster@<helo> をHELOのドメインで評価します。
+// ローカルパートがない場合は postmaster を補います。評価したIDはResultのIdentityとSenderに入ります。
+// CheckHost derives the domain and sender per RFC 7208 4.1 from MAIL FROM and HELO and runs the SPF check.
+// An empty or <> MAIL FROM (a bounce) is evaluated as postmaster@<helo> against the HELO domain.
+// A missing local-part is replaced with postmaster. The evaluated identity is set in Result.Identity and Result.Sender.
+func CheckHost(ip net.IP, mailFrom, helo string) *Result {
+	return CheckHostWithOptions(ip, mailFrom, helo, nil)
+}
+
+// CheckHostWithOptions はオプションを指定してCheckHostを行います。
+// CheckHostWithOptions is CheckHost with options.
+func CheckHostWithOptions(ip net.IP, mailFrom, helo string, opts *Options) *Result {
+	return CheckHostContext(context.Background(), ip, mailFrom, helo, opts)
+}
+
+// CheckHostContext はctxを指定してCheckHostを行います。期限の扱いはCheckSPFContextと同じです。
+// CheckHostContext is CheckHost bounded by ctx, like CheckSPFContext.
+func CheckHostContext(ctx context.Context, ip net.IP, mailFrom, helo string, opts *Options) *Result {
+	domain, sender, identity := deriveIdentity(mailFrom, helo)
+	var result *Result
+	if domain == "" {
+		result = &Result{Status: None, Reason: "no identity to check"}
+	} else {
+		result = CheckSPFContext(ctx, ip, domain, sender, helo, opts)
+	}
+	result.Identity = identity
+	result.Sender = sender
+	return result
+}
+
+// MAIL FROMとHELOから評価するドメインと送信者を決めます。
+// Derives the domain and sender to evaluate from MAIL FROM and HELO.
+func deriveIdentity(mailFrom, helo string) (domain, sender string, identity Identity) {
+	sender = strings.TrimSpace(mailFrom)
+	sender = strings.TrimSuffix(strings.TrimPrefix(sender, "<"), ">")
+	if sender == "" {
+		// null reverse-path => postmaster@helo
+		helo = strings.TrimSuffix(helo, ".")
+		if helo == "" {
+			return "", "", IdentityHelo
+		}
+		return helo, "postmaster@" + helo, IdentityHelo
+	}
+	at := strings.LastIndex(sender, "@")
+	if at < 0 {
+		// ドメインのみの場合
+		// domain only
+		return sender, "postmaster@" + sender, IdentityMailFrom
+	}
+	domain = sender[at+1:]
+	if at == 0 {
+		sender = "postmaster@" + domain
+	}
+	return domain, sender, IdentityMailFrom
+}
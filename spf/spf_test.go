@@ -1,7 +1,16 @@
 package spf
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/masa23/mmauth/errcode"
 )
 
 func TestIsValidDomainSpec(t *testing.T) {
@@ -41,3 +50,1255 @@ func TestIsValidDomainSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckSPFInternationalizedDomain(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	var queried []string
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		queried = append(queried, name)
+		if name == "xn--wgv71a119e.jp" {
+			return []string{"v=spf1 ip4:192.0.2.1 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name   string
+		ip     string
+		domain string
+		sender string
+		want   Status
+	}{
+		{
+			name:   "U-label domain with UTF-8 local part",
+			ip:     "192.0.2.1",
+			domain: "日本語.jp",
+			sender: "テスト@日本語.jp",
+			want:   Pass,
+		},
+		{
+			name:   "A-label domain",
+			ip:     "192.0.2.2",
+			domain: "xn--wgv71a119e.jp",
+			sender: "user@xn--wgv71a119e.jp",
+			want:   Fail,
+		},
+		{
+			name:   "invalid U-label",
+			ip:     "192.0.2.1",
+			domain: "\xff.jp",
+			sender: "user@\xff.jp",
+			want:   None,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CheckSPF(net.ParseIP(tc.ip), tc.domain, tc.sender, "mx.example.net")
+			if got.Status != tc.want {
+				t.Errorf("CheckSPF(%q) = %s (%s); expected %s", tc.domain, got.Status, got.Reason, tc.want)
+			}
+		})
+	}
+	for _, q := range queried {
+		if strings.IndexFunc(q, func(r rune) bool { return r > 0x7f }) >= 0 {
+			t.Errorf("query contains non-ASCII name: %q", q)
+		}
+	}
+}
+
+func TestExpandExistsEAI(t *testing.T) {
+	testCases := []struct {
+		name   string
+		spec   string
+		sender string
+		helo   string
+		want   string
+	}{
+		{name: "local-part", spec: "%{l}.%{o}.allow.example.org", sender: "田中@日本語.jp", helo: "mx.example.net", want: "田中.xn--wgv71a119e.jp.allow.example.org"},
+		{name: "url-encoded local-part", spec: "%{L}._spf.%{d}", sender: "田中@日本語.jp", helo: "mx.example.net", want: "%E7%94%B0%E4%B8%AD._spf.xn--wgv71a119e.jp"},
+		{name: "sender", spec: "%{s}.example.org", sender: "田中@日本語.jp", helo: "mx.example.net", want: "田中@xn--wgv71a119e.jp.example.org"},
+		{name: "helo", spec: "%{h}.example.org", sender: "user@example.com", helo: "メール.日本語.jp", want: "xn--4dkua4c.xn--wgv71a119e.jp.example.org"},
+		{name: "a-label sender", spec: "%{l}.%{o}.example.org", sender: "田中@xn--wgv71a119e.jp", helo: "mx.example.net", want: "田中.xn--wgv71a119e.jp.example.org"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ExpandExists(tc.spec, net.ParseIP("192.0.2.1"), tc.sender, tc.helo)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+	if _, err := ExpandExists("%{d}.example.org", net.ParseIP("192.0.2.1"), "user@\xff.jp", "mx.example.net"); err == nil {
+		t.Errorf("expected error for invalid U-label")
+	}
+}
+
+func TestPTRValidationCache(t *testing.T) {
+	clientIP := net.ParseIP("192.0.2.10")
+	testCases := []struct {
+		name          string
+		record        string
+		ptrNames      []string
+		opts          *Options
+		want          Status
+		wantPTRCalls  int
+		wantIPLookups map[string]int
+	}{
+		{
+			name:         "ptr mechanism and p macro share lookups",
+			record:       "v=spf1 exists:%{p}._spf.example.com ptr:example.com -all",
+			ptrNames:     []string{"mail.example.com."},
+			want:         Pass,
+			wantPTRCalls: 1,
+			wantIPLookups: map[string]int{
+				"mail.example.com": 1,
+			},
+		},
+		{
+			name:         "MaxPTRRecords limits validated names",
+			record:       "v=spf1 ptr:example.com -all",
+			ptrNames:     []string{"a.example.net.", "mail.example.com."},
+			opts:         &Options{MaxPTRRecords: 1},
+			want:         Fail,
+			wantPTRCalls: 1,
+			wantIPLookups: map[string]int{
+				"mail.example.com": 0,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDNSResolver()
+			if tc.opts != nil {
+				d.opts = *tc.opts
+			}
+			ptrCalls := 0
+			ipLookups := map[string]int{}
+			d.txt = func(name string) ([]string, error) {
+				if name == "example.com" {
+					return []string{tc.record}, nil
+				}
+				return nil, &net.DNSError{IsNotFound: true}
+			}
+			d.ptr = func(addr string) ([]string, error) {
+				ptrCalls++
+				return tc.ptrNames, nil
+			}
+			d.ip = func(name string) ([]net.IP, error) {
+				ipLookups[name]++
+				switch name {
+				case "mail.example.com":
+					return []net.IP{clientIP}, nil
+				}
+				return nil, &net.DNSError{IsNotFound: true}
+			}
+			got := d.CheckSPF(clientIP, "example.com", "user@example.com", "mail.example.com")
+			if got.Status != tc.want {
+				t.Errorf("expected %s, got %s (%s)", tc.want, got.Status, got.Reason)
+			}
+			if ptrCalls != tc.wantPTRCalls {
+				t.Errorf("expected %d PTR lookups, got %d", tc.wantPTRCalls, ptrCalls)
+			}
+			for name, want := range tc.wantIPLookups {
+				if ipLookups[name] != want {
+					t.Errorf("expected %d lookups of %s, got %d", want, name, ipLookups[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRecordEvaluate(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "example.jp":
+			return []string{"v=spf1 include:_spf.example.jp -all"}, nil
+		case "_spf.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.0/24 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name   string
+		ctx    context.Context
+		record string
+		in     EvalInput
+		want   Status
+	}{
+		{
+			name:   "include pass",
+			ctx:    context.Background(),
+			record: "v=spf1 include:_spf.example.jp -all",
+			in:     EvalInput{IP: net.ParseIP("192.0.2.1"), Domain: "example.jp", Sender: "user@example.jp"},
+			want:   Pass,
+		},
+		{
+			name:   "no match",
+			ctx:    context.Background(),
+			record: "v=spf1 include:_spf.example.jp -all",
+			in:     EvalInput{IP: net.ParseIP("198.51.100.1"), Domain: "example.jp", Sender: "user@example.jp"},
+			want:   Fail,
+		},
+		{
+			name:   "canceled context",
+			ctx:    canceled,
+			record: "v=spf1 include:_spf.example.jp -all",
+			in:     EvalInput{IP: net.ParseIP("192.0.2.1"), Domain: "example.jp"},
+			want:   TempError,
+		},
+		{
+			name:   "ip4 only",
+			ctx:    context.Background(),
+			record: "v=spf1 ip4:192.0.2.1 -all",
+			in:     EvalInput{IP: net.ParseIP("192.0.2.1"), Domain: "example.jp"},
+			want:   Pass,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, res := ParseRecord(tc.record)
+			if res != nil {
+				t.Fatalf("ParseRecord: %s", res.Reason)
+			}
+			got := rec.EvaluateContext(tc.ctx, tc.in)
+			if got.Status != tc.want {
+				t.Errorf("Evaluate = %s (%s); expected %s", got.Status, got.Reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestDefaultSender(t *testing.T) {
+	testCases := []struct {
+		sender string
+		want   string
+	}{
+		{"", "postmaster@example.jp"},
+		{"@example.jp", "postmaster@example.jp"},
+		{"user", "postmaster@example.jp"},
+		{"user@example.jp", "user@example.jp"},
+	}
+	for _, tc := range testCases {
+		if got := defaultSender(tc.sender, "example.jp"); got != tc.want {
+			t.Errorf("defaultSender(%q) = %q; expected %q", tc.sender, got, tc.want)
+		}
+	}
+}
+
+func TestRedirectResultDomain(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "a.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.1 exp=explain.a.example.jp redirect=b.example.jp"}, nil
+		case "explain.a.example.jp":
+			return []string{"explanation of a"}, nil
+		case "b.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.2 -all"}, nil
+		case "c.example.jp":
+			return []string{"v=spf1 redirect=none.example.jp"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name       string
+		ip         string
+		domain     string
+		want       Status
+		wantDomain string
+		wantReason string
+	}{
+		{
+			name:       "mechanism matched before redirect",
+			ip:         "192.0.2.1",
+			domain:     "a.example.jp",
+			want:       Pass,
+			wantDomain: "a.example.jp",
+		},
+		{
+			name:       "redirect target decides result",
+			ip:         "192.0.2.2",
+			domain:     "a.example.jp",
+			want:       Pass,
+			wantDomain: "b.example.jp",
+		},
+		{
+			name:       "exp of original record is not used",
+			ip:         "198.51.100.1",
+			domain:     "a.example.jp",
+			want:       Fail,
+			wantDomain: "b.example.jp",
+			wantReason: "DEFAULT",
+		},
+		{
+			name:       "redirect target without record",
+			ip:         "198.51.100.1",
+			domain:     "c.example.jp",
+			want:       PermError,
+			wantDomain: "c.example.jp",
+		},
+		{
+			name:       "no record",
+			ip:         "198.51.100.1",
+			domain:     "none.example.jp",
+			want:       None,
+			wantDomain: "none.example.jp",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CheckSPF(net.ParseIP(tc.ip), tc.domain, "user@"+tc.domain, "mx.example.net")
+			if got.Status != tc.want {
+				t.Fatalf("CheckSPF = %s (%s); expected %s", got.Status, got.Reason, tc.want)
+			}
+			if got.Domain != tc.wantDomain {
+				t.Errorf("Domain = %q; expected %q", got.Domain, tc.wantDomain)
+			}
+			if tc.wantReason != "" && got.Reason != tc.wantReason {
+				t.Errorf("Reason = %q; expected %q", got.Reason, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestDeferUnknownMechanisms(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "after-all.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.1 -all moo"}, nil
+		case "before-all.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.1 moo -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name     string
+		ip       string
+		domain   string
+		deferred bool
+		want     Status
+	}{
+		{name: "strict after all", ip: "192.0.2.1", domain: "after-all.example.jp", want: PermError},
+		{name: "deferred after all", ip: "192.0.2.1", domain: "after-all.example.jp", deferred: true, want: Pass},
+		{name: "deferred not reached", ip: "192.0.2.1", domain: "before-all.example.jp", deferred: true, want: Pass},
+		{name: "deferred reached", ip: "198.51.100.1", domain: "before-all.example.jp", deferred: true, want: PermError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CheckSPFWithOptions(net.ParseIP(tc.ip), tc.domain, "user@"+tc.domain, "mx.example.net",
+				&Options{DeferUnknownMechanisms: tc.deferred})
+			if got.Status != tc.want {
+				t.Errorf("CheckSPF = %s (%s); expected %s", got.Status, got.Reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	testCases := []struct {
+		name   string
+		record string
+		want   []string
+	}{
+		{name: "clean", record: "v=spf1 ip4:192.0.2.0/24 include:_spf.example.jp -all"},
+		{name: "unknown after all", record: "v=spf1 ip4:192.0.2.1 -all moo", want: []string{
+			"moo: term after all is never evaluated",
+			"moo: unknown mechanism (permerror by RFC 7208 4.6)",
+		}},
+		{name: "redirect with all", record: "v=spf1 a ~all redirect=example.net", want: []string{
+			"redirect=example.net: redirect is ignored because all is present (RFC 7208 6.1)",
+		}},
+		{name: "ptr", record: "v=spf1 ?ptr:example.jp -all", want: []string{
+			"?ptr:example.jp: ptr mechanism should not be used (RFC 7208 5.5)",
+		}},
+		{name: "syntax error", record: "v=spf1 ip4:300.0.0.1 -all", want: []string{
+			`invalid ip4: invalid ip "300.0.0.1"`,
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, issue := range Lint(tc.record) {
+				got = append(got, issue.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("Lint(%q) = %q; expected %q", tc.record, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckHelo(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "mx.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.1 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name string
+		ip   string
+		helo string
+		want Status
+	}{
+		{name: "pass", ip: "192.0.2.1", helo: "mx.example.jp", want: Pass},
+		{name: "trailing dot", ip: "192.0.2.1", helo: "mx.example.jp.", want: Pass},
+		{name: "fail", ip: "192.0.2.9", helo: "mx.example.jp", want: Fail},
+		{name: "no record", ip: "192.0.2.1", helo: "mx.example.com", want: None},
+		{name: "ip literal", ip: "192.0.2.1", helo: "[192.0.2.1]", want: None},
+		{name: "empty", ip: "192.0.2.1", helo: "", want: None},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CheckHelo(net.ParseIP(tc.ip), tc.helo)
+			if got.Status != tc.want {
+				t.Errorf("want %s, but got %s (%s)", tc.want, got.Status, got.Reason)
+			}
+		})
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "mx.example.jp":
+			return []string{"v=spf1 ip4:192.0.2.1 -all"}, nil
+		case "example.jp":
+			return []string{"v=spf1 ip4:192.0.2.2 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name         string
+		mailFrom     string
+		helo         string
+		want         Status
+		wantIdentity Identity
+		wantSender   string
+	}{
+		{name: "null sender", mailFrom: "", helo: "mx.example.jp", want: Pass, wantIdentity: IdentityHelo, wantSender: "postmaster@mx.example.jp"},
+		{name: "angle null sender", mailFrom: "<>", helo: "mx.example.jp", want: Pass, wantIdentity: IdentityHelo, wantSender: "postmaster@mx.example.jp"},
+		{name: "null sender without helo", mailFrom: "<>", helo: "", want: None, wantIdentity: IdentityHelo},
+		{name: "mail from", mailFrom: "<user@example.jp>", helo: "mx.example.jp", want: Fail, wantIdentity: IdentityMailFrom, wantSender: "user@example.jp"},
+		{name: "no local part", mailFrom: "@example.jp", helo: "mx.example.jp", want: Fail, wantIdentity: IdentityMailFrom, wantSender: "postmaster@example.jp"},
+		{name: "domain only", mailFrom: "example.jp", helo: "mx.example.jp", want: Fail, wantIdentity: IdentityMailFrom, wantSender: "postmaster@example.jp"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CheckHost(net.ParseIP("192.0.2.1"), tc.mailFrom, tc.helo)
+			if got.Status != tc.want {
+				t.Errorf("want %s, but got %s (%s)", tc.want, got.Status, got.Reason)
+			}
+			if got.Identity != tc.wantIdentity || got.Sender != tc.wantSender {
+				t.Errorf("want %s %q, but got %s %q", tc.wantIdentity, tc.wantSender, got.Identity, got.Sender)
+			}
+		})
+	}
+}
+
+func TestResponseSizeLimits(t *testing.T) {
+	origTXT, origIP, origMX := DefaultTXTResolver, DefaultIPResolver, DefaultMXResolver
+	t.Cleanup(func() {
+		DefaultTXTResolver, DefaultIPResolver, DefaultMXResolver = origTXT, origIP, origMX
+	})
+	manyTXT := []string{"v=spf1 -all"}
+	for i := 0; i < 100; i++ {
+		manyTXT = append(manyTXT, fmt.Sprintf("token-%d", i))
+	}
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "many-txt.example":
+			return manyTXT, nil
+		case "long.example":
+			return []string{"v=spf1" + strings.Repeat(" ip4:192.0.2.1", 400) + " -all"}, nil
+		case "many-mx.example":
+			return []string{"v=spf1 mx -all"}, nil
+		case "many-a.example":
+			return []string{"v=spf1 a -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	DefaultMXResolver = func(name string) ([]*net.MX, error) {
+		var mxs []*net.MX
+		for i := 0; i < 12; i++ {
+			mxs = append(mxs, &net.MX{Host: fmt.Sprintf("mx%d.example", i), Pref: 10})
+		}
+		return mxs, nil
+	}
+	DefaultIPResolver = func(name string) ([]net.IP, error) {
+		var ips []net.IP
+		for i := 0; i < 200; i++ {
+			ips = append(ips, net.IPv4(198, 51, 100, byte(i)))
+		}
+		return ips, nil
+	}
+
+	testCases := []struct {
+		name   string
+		domain string
+		opts   *Options
+		want   Status
+	}{
+		{name: "too many TXT records", domain: "many-txt.example", want: PermError},
+		{name: "TXT records within limit", domain: "many-txt.example", opts: &Options{MaxTXTRecords: 200}, want: Fail},
+		{name: "record too long", domain: "long.example", want: PermError},
+		{name: "record within limit", domain: "long.example", opts: &Options{MaxRecordLength: 8192}, want: Fail},
+		{name: "too many MX records", domain: "many-mx.example", want: PermError},
+		{name: "too many addresses", domain: "many-a.example", want: PermError},
+		{name: "addresses within limit", domain: "many-a.example", opts: &Options{MaxAddressRecords: 256}, want: Fail},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CheckSPFWithOptions(net.ParseIP("192.0.2.99"), tc.domain, "user@"+tc.domain, "mx.example", tc.opts)
+			if got.Status != tc.want {
+				t.Errorf("expected %s, got %s (%s)", tc.want, got.Status, got.Reason)
+			}
+		})
+	}
+}
+
+func TestCheckSPFContextDeadline(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "example.jp":
+			return []string{"v=spf1 include:_a.example.jp include:_b.example.jp -all"}, nil
+		case "_a.example.jp", "_b.example.jp":
+			// 応答の遅いDNSサーバー
+			time.Sleep(500 * time.Millisecond)
+			return []string{"v=spf1 ip4:198.51.100.0/24 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	got := CheckSPFContext(ctx, net.ParseIP("192.0.2.1"), "example.jp", "user@example.jp", "mx.example.jp", nil)
+	if got.Status != TempError {
+		t.Errorf("expected %s, got %s (%s)", TempError, got.Status, got.Reason)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("evaluation did not stop at the deadline: %s", elapsed)
+	}
+
+	// 期限切れのctxでは問い合わせを行わない
+	got = CheckHostContext(ctx, net.ParseIP("192.0.2.1"), "user@example.jp", "mx.example.jp", nil)
+	if got.Status != TempError || got.Identity != IdentityMailFrom {
+		t.Errorf("expected %s for mailfrom, got %s (%s)", TempError, got.Status, got.Reason)
+	}
+}
+
+func TestMaxTotalDNSTime(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "example.jp":
+			return []string{"v=spf1 include:_a.example.jp include:_b.example.jp -all"}, nil
+		case "_a.example.jp", "_b.example.jp":
+			// 応答の遅いDNSサーバー
+			time.Sleep(100 * time.Millisecond)
+			return []string{"v=spf1 ip4:198.51.100.0/24 -all"}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name     string
+		max      time.Duration
+		want     Status
+		wantCode errcode.Code
+	}{
+		{name: "no limit", want: Fail, wantCode: "SPF_FAIL"},
+		{name: "within limit", max: time.Second, want: Fail, wantCode: "SPF_FAIL"},
+		{name: "exceeded", max: 150 * time.Millisecond, want: TempError, wantCode: errcode.SPFTempErrorDNSTimeExceeded},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			got := CheckSPFWithOptions(net.ParseIP("192.0.2.1"), "example.jp", "user@example.jp", "mx.example.jp",
+				&Options{MaxTotalDNSTime: tc.max})
+			if got.Status != tc.want || got.Code() != tc.wantCode {
+				t.Errorf("expected %s %s, got %s %s (%s)", tc.want, tc.wantCode, got.Status, got.Code(), got.Reason)
+			}
+			// 2つ目のincludeの応答を待たずに打ち切る
+			if elapsed := time.Since(start); tc.max > 0 && tc.max < time.Second && elapsed > tc.max+80*time.Millisecond {
+				t.Errorf("evaluation did not stop at the limit: %s", elapsed)
+			}
+		})
+	}
+}
+
+// slowResolver は include 先のTXTの問い合わせで ctx が終了するまで応答しない Resolver です
+type slowResolver struct {
+	// pending は応答を待っている問い合わせの数です
+	pending *int32
+}
+
+func (r slowResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if name == "example.jp" {
+		return []string{"v=spf1 include:_a.example.jp -all"}, nil
+	}
+	atomic.AddInt32(r.pending, 1)
+	defer atomic.AddInt32(r.pending, -1)
+	<-ctx.Done()
+	return nil, &net.DNSError{Err: ctx.Err().Error(), IsTimeout: true}
+}
+
+func (r slowResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return nil, &net.DNSError{IsNotFound: true}
+}
+
+func (r slowResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{IsNotFound: true}
+}
+
+func (r slowResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return nil, &net.DNSError{IsNotFound: true}
+}
+
+func TestResolverCanceled(t *testing.T) {
+	origTXT, origResolver := DefaultTXTResolver, DefaultResolver
+	t.Cleanup(func() { DefaultTXTResolver, DefaultResolver = origTXT, origResolver })
+	var pending int32
+	DefaultTXTResolver = nil
+	DefaultResolver = slowResolver{pending: &pending}
+
+	// 問い合わせの ctx が打ち切られ、評価が終わった時点で応答待ちの問い合わせは残らない
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	got := CheckSPFContext(ctx, net.ParseIP("192.0.2.1"), "example.jp", "user@example.jp", "mx.example.jp", nil)
+	if got.Status != TempError || got.Code() != errcode.SPFTempErrorCanceled {
+		t.Errorf("expected %s %s, got %s %s (%s)", TempError, errcode.SPFTempErrorCanceled, got.Status, got.Code(), got.Reason)
+	}
+	if n := atomic.LoadInt32(&pending); n != 0 {
+		t.Errorf("expected no pending lookups, got %d", n)
+	}
+
+	got = CheckSPFWithOptions(net.ParseIP("192.0.2.1"), "example.jp", "user@example.jp", "mx.example.jp",
+		&Options{MaxTotalDNSTime: 50 * time.Millisecond})
+	if got.Status != TempError || got.Code() != errcode.SPFTempErrorDNSTimeExceeded {
+		t.Errorf("expected %s %s, got %s %s (%s)", TempError, errcode.SPFTempErrorDNSTimeExceeded, got.Status, got.Code(), got.Reason)
+	}
+	if n := atomic.LoadInt32(&pending); n != 0 {
+		t.Errorf("expected no pending lookups, got %d", n)
+	}
+}
+
+func TestEvalInputResolver(t *testing.T) {
+	origTXT, origResolver := DefaultTXTResolver, DefaultResolver
+	t.Cleanup(func() { DefaultTXTResolver, DefaultResolver = origTXT, origResolver })
+	// DefaultResolver ではなく EvalInput の Resolver で問い合わせる
+	DefaultTXTResolver = nil
+	DefaultResolver = nil
+	var pending int32
+	rec, res := ParseRecord("v=spf1 include:example.jp -all")
+	if res != nil {
+		t.Fatalf("ParseRecord: %s", res.Reason)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	got := rec.EvaluateContext(ctx, EvalInput{
+		IP:       net.ParseIP("192.0.2.1"),
+		Domain:   "example.net",
+		Resolver: slowResolver{pending: &pending},
+	})
+	if got.Status != TempError {
+		t.Errorf("expected %s, got %s (%s)", TempError, got.Status, got.Reason)
+	}
+	if n := atomic.LoadInt32(&pending); n != 0 {
+		t.Errorf("expected no pending lookups, got %d", n)
+	}
+}
+
+func TestRecordString(t *testing.T) {
+	testCases := []struct {
+		name   string
+		record string
+		want   string
+	}{
+		{
+			name:   "qualifiers",
+			record: "v=spf1 +a -mx:example.com/24 ~ip4:192.0.2.0/24 ?include:_spf.example.net -all",
+			want:   "v=spf1 a -mx:example.com/24 ~ip4:192.0.2.0/24 ?include:_spf.example.net -all",
+		},
+		{
+			name:   "cidr only",
+			record: "v=spf1 a/24 mx//64 ip6:2001:db8::/32 -all",
+			want:   "v=spf1 a/24 mx//64 ip6:2001:db8::/32 -all",
+		},
+		{
+			name:   "modifiers are canonically ordered and exp keeps macros",
+			record: "v=spf1 exp=explain.%{d} exists:%{i}.bl.example.com redirect=_spf.example.com",
+			want:   "v=spf1 exists:%{i}.bl.example.com redirect=_spf.example.com exp=explain.%{d}",
+		},
+		{
+			name:   "case and spacing",
+			record: "V=SPF1   IP4:192.0.2.1   -ALL",
+			want:   "v=spf1 ip4:192.0.2.1 -all",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, res := ParseRecord(tc.record)
+			if res != nil {
+				t.Fatalf("failed to parse: %s", res.Reason)
+			}
+			if got := rec.String(); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+			again, res := ParseRecord(rec.String())
+			if res != nil {
+				t.Fatalf("failed to parse serialized record: %s", res.Reason)
+			}
+			if again.String() != rec.String() {
+				t.Errorf("want %s, but got %s", rec.String(), again.String())
+			}
+		})
+	}
+}
+
+func TestRecordBuilder(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("192.0.2.0/24")
+	_, host, _ := net.ParseCIDR("2001:db8::1/128")
+	rec, err := NewRecordBuilder().
+		IP(QualifierPass, v4).
+		IP(QualifierPass, host).
+		MX(QualifierPass, "").
+		Include(QualifierSoftFail, "_spf.example.net").
+		All(QualifierFail).
+		Exp("explain.%{d}").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::1 mx ~include:_spf.example.net -all exp=explain.%{d}"
+	if rec.String() != want || rec.Raw != want {
+		t.Errorf("want %s, but got %s (raw %s)", want, rec.String(), rec.Raw)
+	}
+	if !rec.AllExists || rec.Exp != "explain.%{d}" {
+		t.Errorf("want parsed record, but got %+v", rec)
+	}
+
+	if _, err := NewRecordBuilder().Include(QualifierPass, "").Build(); err == nil {
+		t.Errorf("expected error for include without domain")
+	}
+	if _, err := NewRecordBuilder().Redirect("a.example").Redirect("b.example").Build(); err != nil {
+		t.Errorf("want redirect to be replaced, but got %v", err)
+	}
+}
+
+func TestCheckExists(t *testing.T) {
+	zone := map[string][]net.IP{
+		"2.2.0.192.sbl.example.org": {net.ParseIP("127.0.0.2")},
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2.sbl.example.org": {net.ParseIP("127.0.0.2")},
+		"3.2.0.192.sbl.example.org":          {net.ParseIP("2001:db8::2")},
+		"user.example.com.allow.example.org": {net.ParseIP("127.0.0.1")},
+	}
+	lookup := func(name string) ([]net.IP, error) {
+		if ips, ok := zone[name]; ok {
+			return ips, nil
+		}
+		if name == "4.2.0.192.sbl.example.org" {
+			return nil, &net.DNSError{Err: "timeout", Name: name, IsTimeout: true}
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	spec := RBLDomainSpec("sbl.example.org.")
+	if spec != "%{ir}.sbl.example.org" {
+		t.Fatalf("want %%{ir}.sbl.example.org, but got %s", spec)
+	}
+
+	testCases := []struct {
+		name     string
+		spec     string
+		ip       string
+		sender   string
+		wantName string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "listed ipv4", spec: spec, ip: "192.0.2.2", wantName: "2.2.0.192.sbl.example.org", want: true},
+		{name: "not listed", spec: spec, ip: "192.0.2.1", wantName: "1.2.0.192.sbl.example.org"},
+		{name: "listed ipv6", spec: spec, ip: "2001:db8::1", wantName: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2.sbl.example.org", want: true},
+		{name: "aaaa only", spec: spec, ip: "192.0.2.3", wantName: "3.2.0.192.sbl.example.org"},
+		{name: "lookup error", spec: spec, ip: "192.0.2.4", wantName: "4.2.0.192.sbl.example.org", wantErr: true},
+		{name: "sender macro", spec: "%{l}.%{o}.allow.example.org", ip: "192.0.2.1", sender: "user@example.com", wantName: "user.example.com.allow.example.org", want: true},
+		{name: "ptr macro", spec: "%{p}.example.org", ip: "192.0.2.1", wantErr: true},
+		{name: "cidr", spec: "%{ir}.example.org/24", ip: "192.0.2.1", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ip := net.ParseIP(tc.ip)
+			name, err := ExpandExists(tc.spec, ip, tc.sender, "mx.example.net")
+			if err == nil && name != tc.wantName {
+				t.Errorf("want %s, but got %s", tc.wantName, name)
+			}
+			got, err := CheckExists(tc.spec, ip, tc.sender, "mx.example.net", lookup)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, but got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want %v, but got %v", tc.want, got)
+			}
+		})
+	}
+
+	rec, err := NewRecordBuilder().RBL(QualifierFail, "sbl.example.org").All(QualifierPass).Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "v=spf1 -exists:%{ir}.sbl.example.org all"; rec.String() != want {
+		t.Errorf("want %s, but got %s", want, rec.String())
+	}
+}
+
+func TestPTRPolicy(t *testing.T) {
+	clientIP := net.ParseIP("192.0.2.10")
+	testCases := []struct {
+		name         string
+		record       string
+		policy       PTRPolicy
+		want         Status
+		wantPolicy   PTRPolicy
+		wantPTRCalls int
+		wantTerms    int
+	}{
+		{name: "default evaluates", record: "v=spf1 ptr:example.com -all", want: Pass, wantPolicy: PTRPolicyEvaluate, wantPTRCalls: 1, wantTerms: 1},
+		{name: "evaluate", record: "v=spf1 ptr:example.com -all", policy: PTRPolicyEvaluate, want: Pass, wantPolicy: PTRPolicyEvaluate, wantPTRCalls: 1, wantTerms: 1},
+		{name: "skip", record: "v=spf1 ptr:example.com -all", policy: PTRPolicySkip, want: Fail, wantPolicy: PTRPolicySkip},
+		{name: "permerror", record: "v=spf1 ptr:example.com -all", policy: PTRPolicyPermError, want: PermError, wantPolicy: PTRPolicyPermError},
+		{name: "not reached", record: "v=spf1 ip4:192.0.2.0/24 ptr -all", policy: PTRPolicyPermError, want: Pass},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDNSResolver()
+			d.opts.PTRPolicy = tc.policy
+			d.opts.Trace = true
+			ptrCalls := 0
+			d.txt = func(name string) ([]string, error) {
+				if name == "example.com" {
+					return []string{tc.record}, nil
+				}
+				return nil, &net.DNSError{IsNotFound: true}
+			}
+			d.ptr = func(addr string) ([]string, error) {
+				ptrCalls++
+				return []string{"mail.example.com."}, nil
+			}
+			d.ip = func(name string) ([]net.IP, error) {
+				return []net.IP{clientIP}, nil
+			}
+			got := d.CheckSPF(clientIP, "example.com", "user@example.com", "mail.example.com")
+			if got.Status != tc.want {
+				t.Errorf("want %s, but got %s (%s)", tc.want, got.Status, got.Reason)
+			}
+			if got.PTRPolicy != tc.wantPolicy {
+				t.Errorf("want policy %q, but got %q", tc.wantPolicy, got.PTRPolicy)
+			}
+			if ptrCalls != tc.wantPTRCalls {
+				t.Errorf("want %d PTR lookups, but got %d", tc.wantPTRCalls, ptrCalls)
+			}
+			if got.Stats.Terms != tc.wantTerms {
+				t.Errorf("want %d terms, but got %d", tc.wantTerms, got.Stats.Terms)
+			}
+			var traced PTRPolicy
+			for _, e := range got.Trace {
+				if strings.HasPrefix(e.Term, "ptr") {
+					traced = e.PTRPolicy
+				}
+			}
+			if traced != tc.wantPolicy {
+				t.Errorf("want traced policy %q, but got %q", tc.wantPolicy, traced)
+			}
+		})
+	}
+}
+
+func TestTrace(t *testing.T) {
+	origTXT, origIP := DefaultTXTResolver, DefaultIPResolver
+	t.Cleanup(func() { DefaultTXTResolver, DefaultIPResolver = origTXT, origIP })
+	records := map[string]string{
+		"example.com":      "v=spf1 include:fast.example.net include:slow.example.net redirect=example.org",
+		"fast.example.net": "v=spf1 ip4:198.51.100.0/24 -all",
+		"slow.example.net": "v=spf1 a:mail.example.net -all",
+		"example.org":      "v=spf1 ip4:192.0.2.1 -all",
+	}
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		if name == "slow.example.net" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if r, ok := records[name]; ok {
+			return []string{r}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	DefaultIPResolver = func(name string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("203.0.113.1")}, nil
+	}
+
+	res := CheckSPFWithOptions(net.ParseIP("192.0.2.1"), "example.com", "user@example.com", "mx.example.com", &Options{Trace: true})
+	if res.Status != Pass {
+		t.Fatalf("want %s, but got %s (%s)", Pass, res.Status, res.Reason)
+	}
+	want := []TraceEntry{
+		{Depth: 0, Domain: "example.com", Term: "include:fast.example.net", Queries: 1},
+		{Depth: 0, Domain: "example.com", Term: "include:slow.example.net", Queries: 2},
+		{Depth: 1, Domain: "slow.example.net", Term: "a:mail.example.net", Queries: 1},
+		{Depth: 0, Domain: "example.com", Term: "redirect=example.org", Queries: 1},
+	}
+	if len(res.Trace) != len(want) {
+		t.Fatalf("want %d entries, but got %+v", len(want), res.Trace)
+	}
+	for i, w := range want {
+		got := res.Trace[i]
+		if got.Depth != w.Depth || got.Domain != w.Domain || got.Term != w.Term || got.Queries != w.Queries || got.Matched || got.Error != "" {
+			t.Errorf("entry %d: want %+v, but got %+v", i, w, got)
+		}
+	}
+	if d := res.Trace[1].Duration; d < 20*time.Millisecond || d < res.Trace[2].Duration {
+		t.Errorf("want the slow include to include its lookup time, but got %s", d)
+	}
+	if res.Stats == nil {
+		t.Fatal("want stats, but got nil")
+	}
+	if res.Stats.Terms != 4 || res.Stats.Queries != 5 || res.Stats.VoidLookups != 0 {
+		t.Errorf("want 4 terms and 5 queries, but got %+v", res.Stats)
+	}
+	if res.Stats.LookupTime < 20*time.Millisecond || res.Stats.Elapsed < res.Stats.LookupTime {
+		t.Errorf("want cumulative lookup time within elapsed time, but got %+v", res.Stats)
+	}
+
+	res = CheckSPF(net.ParseIP("192.0.2.1"), "example.com", "user@example.com", "mx.example.com")
+	if res.Trace != nil || res.Stats != nil {
+		t.Errorf("want no trace without Options.Trace, but got %+v", res)
+	}
+}
+
+func TestResultRecord(t *testing.T) {
+	origTXT, origIP := DefaultTXTResolver, DefaultIPResolver
+	t.Cleanup(func() { DefaultTXTResolver, DefaultIPResolver = origTXT, origIP })
+	records := map[string]string{
+		"example.com":    "v=spf1 include:example.net redirect=example.org",
+		"example.net":    "v=spf1 ip4:198.51.100.1 -all",
+		"example.org":    "v=spf1 ip4:192.0.2.1 -all",
+		"matched.test":   "v=spf1 ip4:192.0.2.1 -all",
+		"malformed.test": "v=spf1 ip4:192.0.2.256 -all",
+	}
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		if r, ok := records[name]; ok {
+			return []string{r}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	DefaultIPResolver = func(name string) ([]net.IP, error) {
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name       string
+		domain     string
+		wantStatus Status
+		wantRecord string
+	}{
+		{name: "matched", domain: "matched.test", wantStatus: Pass, wantRecord: records["matched.test"]},
+		{name: "redirect target", domain: "example.com", wantStatus: Pass, wantRecord: records["example.org"]},
+		{name: "syntax error", domain: "malformed.test", wantStatus: PermError, wantRecord: records["malformed.test"]},
+		{name: "no record", domain: "none.test", wantStatus: None, wantRecord: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := CheckSPF(net.ParseIP("192.0.2.1"), tc.domain, "user@"+tc.domain, "mx."+tc.domain)
+			if res.Status != tc.wantStatus {
+				t.Fatalf("want %s, but got %s (%s)", tc.wantStatus, res.Status, res.Reason)
+			}
+			if res.Record != tc.wantRecord {
+				t.Errorf("want %q, but got %q", tc.wantRecord, res.Record)
+			}
+		})
+	}
+}
+
+func TestPolicyStrength(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	records := map[string]string{
+		"strict.test":   "v=spf1 ip4:192.0.2.1 -all",
+		"soft.test":     "v=spf1 ~all",
+		"neutral.test":  "v=spf1 ?all -all",
+		"open.test":     "v=spf1 +all",
+		"noall.test":    "v=spf1 ip4:198.51.100.1",
+		"redirect.test": "v=spf1 redirect=soft.test",
+	}
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		if r, ok := records[name]; ok {
+			return []string{r}, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		domain string
+		want   PolicyStrength
+	}{
+		{domain: "strict.test", want: PolicyStrengthStrict},
+		{domain: "soft.test", want: PolicyStrengthSoft},
+		{domain: "neutral.test", want: PolicyStrengthNeutral},
+		{domain: "open.test", want: PolicyStrengthOpen},
+		{domain: "noall.test", want: PolicyStrengthNone},
+		{domain: "redirect.test", want: PolicyStrengthSoft},
+		{domain: "missing.test", want: PolicyStrengthNone},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			res := CheckSPF(net.ParseIP("192.0.2.1"), tc.domain, "user@"+tc.domain, "mx."+tc.domain)
+			if got := res.PolicyStrength(); got != tc.want {
+				t.Errorf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestControlCharacters(t *testing.T) {
+	origTXT := DefaultTXTResolver
+	t.Cleanup(func() { DefaultTXTResolver = origTXT })
+	records := map[string][]string{
+		"nul.test":       {"v=spf1 ip4:192.0.2.1 -all\x00"},
+		"escape.test":    {"v=spf1 ip4:192.0.2.1\x1b -all"},
+		"unrelated.test": {"token=\x07abc", "v=spf1 ip4:192.0.2.1 -all"},
+	}
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		if r, ok := records[name]; ok {
+			return r, nil
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+
+	testCases := []struct {
+		name       string
+		domain     string
+		strip      bool
+		wantStatus Status
+		wantReason string
+	}{
+		{name: "trailing nul", domain: "nul.test", wantStatus: PermError, wantReason: "control characters"},
+		{name: "trailing nul stripped", domain: "nul.test", strip: true, wantStatus: Pass},
+		{name: "escape", domain: "escape.test", strip: true, wantStatus: PermError, wantReason: "control characters"},
+		{name: "unrelated record", domain: "unrelated.test", wantStatus: Pass},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := CheckSPFWithOptions(net.ParseIP("192.0.2.1"), tc.domain, "user@"+tc.domain, "mx."+tc.domain, &Options{StripTrailingNUL: tc.strip})
+			if res.Status != tc.wantStatus {
+				t.Fatalf("want %s, but got %s (%s)", tc.wantStatus, res.Status, res.Reason)
+			}
+			if !strings.Contains(res.Reason, tc.wantReason) {
+				t.Errorf("want reason containing %q, but got %q", tc.wantReason, res.Reason)
+			}
+		})
+	}
+}
+
+func TestRecordCompile(t *testing.T) {
+	testCases := []struct {
+		name   string
+		record string
+		ip     string
+		want   Status
+	}{
+		{name: "ip4 match", record: "v=spf1 ip4:192.0.2.0/24 -all", ip: "192.0.2.10", want: Pass},
+		{name: "ip4 no match", record: "v=spf1 ip4:192.0.2.0/24 -all", ip: "198.51.100.1", want: Fail},
+		{name: "nested first wins", record: "v=spf1 ~ip4:192.0.2.0/28 ip4:192.0.2.0/24 -all", ip: "192.0.2.1", want: SoftFail},
+		{name: "nested outer first", record: "v=spf1 ip4:192.0.2.0/24 ~ip4:192.0.2.0/28 -all", ip: "192.0.2.1", want: Pass},
+		{name: "nested after inner", record: "v=spf1 ~ip4:192.0.2.0/28 ip4:192.0.2.0/24 -all", ip: "192.0.2.200", want: Pass},
+		{name: "duplicate", record: "v=spf1 ?ip4:192.0.2.1 ip4:192.0.2.1 -all", ip: "192.0.2.1", want: Neutral},
+		{name: "single address", record: "v=spf1 ip4:192.0.2.1 -all", ip: "192.0.2.2", want: Fail},
+		{name: "ip4 any", record: "v=spf1 ~ip4:0.0.0.0/0 ip6:::/0", ip: "203.0.113.1", want: SoftFail},
+		{name: "ip6 any", record: "v=spf1 ~ip4:0.0.0.0/0 ip6:::/0", ip: "2001:db8::1", want: Pass},
+		{name: "ip6 match", record: "v=spf1 ip6:2001:db8::/32 -all", ip: "2001:db8:1::1", want: Pass},
+		{name: "ip6 no match", record: "v=spf1 ip6:2001:db8::/32 -all", ip: "2001:db9::1", want: Fail},
+		{name: "ipv4-mapped sender", record: "v=spf1 ip4:192.0.2.0/24 -all", ip: "::ffff:192.0.2.1", want: Pass},
+		{name: "ip6 does not match ipv4", record: "v=spf1 ip6:::ffff:192.0.2.0/120 -all", ip: "192.0.2.1", want: Fail},
+		{name: "ip4 does not match ipv6", record: "v=spf1 ip4:0.0.0.0/0 -all", ip: "2001:db8::1", want: Fail},
+		{name: "order with other mechanisms", record: "v=spf1 -ip4:192.0.2.1 ip4:192.0.2.0/24 ?all", ip: "192.0.2.1", want: Fail},
+		{name: "last address", record: "v=spf1 ~ip6:ffff::/16 ip6:ffff:ffff::/32 -all", ip: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", want: SoftFail},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, res := ParseRecord(tc.record)
+			if res != nil {
+				t.Fatalf("ParseRecord: %s", res.Reason)
+			}
+			in := EvalInput{IP: net.ParseIP(tc.ip), Domain: "example.jp"}
+			if got := rec.EvaluateContext(context.Background(), in); got.Status != tc.want {
+				t.Fatalf("before Compile: Evaluate = %s (%s); expected %s", got.Status, got.Reason, tc.want)
+			}
+			if err := rec.Compile(); err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if got := rec.EvaluateContext(context.Background(), in); got.Status != tc.want {
+				t.Errorf("after Compile: Evaluate = %s (%s); expected %s", got.Status, got.Reason, tc.want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		rec := &Record{Mechanisms: []MechanismEntry{{Mechanism: MechanismIP4, Value: "192.0.2.300", Qualifier: QualifierPass}}}
+		if err := rec.Compile(); err == nil {
+			t.Fatal("expected an error")
+		}
+		if rec.ipSet != nil {
+			t.Error("record should not be compiled")
+		}
+	})
+
+	// 多数の重なり合うネットワークで、1つずつ照合した場合と同じメカニズムにマッチすること
+	t.Run("matches sequential evaluation", func(t *testing.T) {
+		var mechanisms []MechanismEntry
+		for i := 0; i < 200; i++ {
+			bits := 8 + (i*7)%25
+			mechanisms = append(mechanisms, MechanismEntry{
+				Mechanism: MechanismIP4,
+				Value:     fmt.Sprintf("10.%d.%d.0/%d", (i*37)%4, (i*53)%256, bits),
+				Qualifier: QualifierPass,
+			}, MechanismEntry{
+				Mechanism: MechanismIP6,
+				Value:     fmt.Sprintf("2001:db8:%x::/%d", (i*97)%16, 32+(i*11)%64),
+				Qualifier: QualifierPass,
+			})
+		}
+		rec := &Record{Mechanisms: mechanisms}
+		if err := rec.Compile(); err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		for i := 0; i < 4096; i++ {
+			ips := []net.IP{
+				net.IPv4(10, byte(i%5), byte(i*31), byte(i*17)),
+				net.ParseIP(fmt.Sprintf("2001:db8:%x:%x::%x", i%17, i*13%65536, i)),
+			}
+			for _, ip := range ips {
+				want := -1
+				for j, me := range rec.Mechanisms {
+					var match bool
+					if me.Mechanism == MechanismIP4 {
+						match, _ = rec.matchIP4Mechanism(me, ip, "", "", "", nil, 0, MacroContext{})
+					} else {
+						match, _ = rec.matchIP6Mechanism(me, ip, "", "", "", nil, 0, MacroContext{})
+					}
+					if match {
+						want = j
+						break
+					}
+				}
+				if got := rec.ipSet.lookup(ip); got != want {
+					t.Fatalf("%s: expected mechanism %d, got %d", ip, want, got)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkRecordEvaluate(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("v=spf1")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, " ip4:10.%d.%d.0/24 ip6:2001:db8:%x::/48", i/256, i%256, i)
+	}
+	sb.WriteString(" -all")
+	in := EvalInput{IP: net.ParseIP("192.0.2.1"), Domain: "example.jp"}
+	for _, compile := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compiled=%v", compile), func(b *testing.B) {
+			rec, res := ParseRecord(sb.String())
+			if res != nil {
+				b.Fatalf("ParseRecord: %s", res.Reason)
+			}
+			if compile {
+				if err := rec.Compile(); err != nil {
+					b.Fatalf("Compile: %v", err)
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if got := rec.EvaluateContext(context.Background(), in); got.Status != Fail {
+					b.Fatalf("expected fail, got %s", got.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestResultCode(t *testing.T) {
+	origTXT, origIP := DefaultTXTResolver, DefaultIPResolver
+	t.Cleanup(func() { DefaultTXTResolver, DefaultIPResolver = origTXT, origIP })
+	DefaultTXTResolver = func(name string) ([]string, error) {
+		switch name {
+		case "pass.example":
+			return []string{"v=spf1 ip4:192.0.2.99 -all"}, nil
+		case "lookups.example":
+			return []string{"v=spf1" + strings.Repeat(" a", 11) + " -all"}, nil
+		case "void.example":
+			return []string{"v=spf1 a:v1.example a:v2.example a:v3.example -all"}, nil
+		case "syntax.example":
+			return []string{"v=spf1 foo -all"}, nil
+		case "multiple.example":
+			return []string{"v=spf1 -all", "v=spf1 +all"}, nil
+		case "dns.example":
+			return nil, errors.New("server failure")
+		}
+		return nil, &net.DNSError{IsNotFound: true}
+	}
+	DefaultIPResolver = func(name string) ([]net.IP, error) {
+		if strings.HasPrefix(name, "v") {
+			return nil, &net.DNSError{IsNotFound: true}
+		}
+		return []net.IP{net.ParseIP("198.51.100.1")}, nil
+	}
+
+	testCases := []struct {
+		domain string
+		want   errcode.Code
+	}{
+		{domain: "pass.example", want: "SPF_PASS"},
+		{domain: "lookups.example", want: errcode.SPFPermErrorTooManyLookups},
+		{domain: "void.example", want: errcode.SPFPermErrorTooManyVoidLookups},
+		{domain: "syntax.example", want: errcode.SPFPermErrorSyntax},
+		{domain: "multiple.example", want: errcode.SPFPermErrorMultipleRecords},
+		{domain: "dns.example", want: errcode.SPFTempErrorDNS},
+		{domain: "none.example", want: errcode.SPFNoneNoRecord},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			got := CheckSPF(net.ParseIP("192.0.2.99"), tc.domain, "user@"+tc.domain, "mx.example")
+			if got.Code() != tc.want {
+				t.Errorf("expected %s, got %s (%s %s)", tc.want, got.Code(), got.Status, got.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package spf
+
+// PolicyStrength はレコードの末尾の all による、認可していない送信元への方針の強さです。
+// DMARCレポートの集計やダッシュボードで、送信ドメインの設定を示すために使います。
+// PolicyStrength is how strongly a record treats unauthorized senders, as set by
+// its terminal all. DMARC report consumers and dashboards use it to show how
+// a sending domain is configured.
+type PolicyStrength string
+
+const (
+	// PolicyStrengthStrict は -all です。
+	// PolicyStrengthStrict is -all.
+	PolicyStrengthStrict PolicyStrength = "strict"
+	// PolicyStrengthSoft は ~all です。
+	// PolicyStrengthSoft is ~all.
+	PolicyStrengthSoft PolicyStrength = "soft"
+	// PolicyStrengthNeutral は ?all です。
+	// PolicyStrengthNeutral is ?all.
+	PolicyStrengthNeutral PolicyStrength = "neutral"
+	// PolicyStrengthOpen は +all で、すべての送信元を認可します。
+	// PolicyStrengthOpen is +all, which authorizes every sender.
+	PolicyStrengthOpen PolicyStrength = "open"
+	// PolicyStrengthNone は all がないことを示します。評価は neutral で終わります (RFC 7208 4.7)。
+	// PolicyStrengthNone means there is no all; evaluation ends in neutral (RFC 7208 4.7).
+	PolicyStrengthNone PolicyStrength = "none"
+)
+
+// PolicyStrength は all の限定子に対応する方針の強さを返します。空の場合は PolicyStrengthNone です。
+// PolicyStrength returns the strength for q as the qualifier of all; empty is PolicyStrengthNone.
+func (q Qualifier) PolicyStrength() PolicyStrength {
+	switch q {
+	case QualifierFail:
+		return PolicyStrengthStrict
+	case QualifierSoftFail:
+		return PolicyStrengthSoft
+	case QualifierNeutral:
+		return PolicyStrengthNeutral
+	case QualifierPass:
+		return PolicyStrengthOpen
+	}
+	return PolicyStrengthNone
+}
+
+// AllQualifier はレコードの all の限定子を返します。all がない場合は空です。
+// all より後の項は評価されないため、最初の all を返します。
+// AllQualifier returns the qualifier of the record's all, or empty if there is none.
+// Terms after all are never evaluated, so the first all is used.
+func (r *Record) AllQualifier() Qualifier {
+	for _, me := range r.Mechanisms {
+		if me.Mechanism == MechanismAll {
+			return me.Qualifier
+		}
+	}
+	return ""
+}
+
+// PolicyStrength は結果を決定したレコードの方針の強さを返します。
+// レコードを評価しなかった場合も PolicyStrengthNone です。
+// PolicyStrength returns the strength of the record that determined the result.
+// It is also PolicyStrengthNone when no record was evaluated.
+func (r *Result) PolicyStrength() PolicyStrength {
+	return r.All.PolicyStrength()
+}
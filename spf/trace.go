@@ -0,0 +1,118 @@
+package spf
+
+import "time"
+
+// TraceEntry はDNSルックアップを伴う項1つの評価の記録です。
+// 運用者が自身のレコードで遅い include を見つけるために使います。
+// TraceEntry records the evaluation of one DNS-bearing term, so operators can
+// find slow includes in their own records.
+type TraceEntry struct {
+	// Depth は include と redirect の入れ子の深さです。最初に評価したレコードは0です。
+	// Depth is the include/redirect nesting depth; the first record evaluated is 0.
+	Depth int
+	// Domain は項を含むレコードのドメインです。
+	// Domain is the domain of the record containing the term.
+	Domain string
+	// Term は "include:_spf.example.com" や "redirect=example.net" のような項の表記です。
+	// Term is the term as written, e.g. "include:_spf.example.com" or "redirect=example.net".
+	Term string
+	// Matched はメカニズムがマッチしたかです。redirect= では常に false です。
+	// Matched reports whether the mechanism matched; always false for redirect=.
+	Matched bool
+	// Error は項の評価が TempError または PermError になった場合の理由です。
+	// Error is the reason when the term resulted in TempError or PermError.
+	Error string
+	// Queries はこの項の評価で行った問い合わせの数で、入れ子の項の分を含みます。
+	// Queries is the number of lookups made for this term, including nested terms.
+	Queries int
+	// Duration はこの項の評価にかかった時間で、入れ子の項の分を含みます。
+	// Duration is the wall-clock time spent on this term, including nested terms.
+	Duration time.Duration
+	// PTRPolicy は ptr メカニズムの項でどう扱ったかです。それ以外の項では空です。
+	// PTRPolicy is how a ptr term was handled; empty for other terms.
+	PTRPolicy PTRPolicy
+}
+
+// Stats は評価全体の統計です。
+// Stats holds statistics for the whole evaluation.
+type Stats struct {
+	// Terms はDNSルックアップを伴う項の数です (RFC 7208 4.6.4 の上限の対象)。
+	// Terms is the number of DNS-bearing terms (counted against RFC 7208 4.6.4).
+	Terms int
+	// Queries は実際に行った問い合わせの数です。
+	// Queries is the number of lookups actually made.
+	Queries int
+	// VoidLookups は応答が空だった問い合わせの数です。
+	// VoidLookups is the number of lookups with empty answers.
+	VoidLookups int
+	// LookupTime は問い合わせにかかった時間の合計です。
+	// LookupTime is the cumulative time spent waiting for lookups.
+	LookupTime time.Duration
+	// Elapsed は評価全体にかかった時間です。
+	// Elapsed is the wall-clock time of the whole evaluation.
+	Elapsed time.Duration
+}
+
+// tracer は Options.Trace が有効な評価の記録を集めます。
+type tracer struct {
+	start      time.Time
+	entries    []TraceEntry
+	queries    int
+	lookupTime time.Duration
+}
+
+// traceOf はリゾルバーが記録中であれば tracer を返します。
+func traceOf(resv SPFResolver) *tracer {
+	if di, ok := resv.(interface{ dnsImpl() *dnsResolverImpl }); ok {
+		return di.dnsImpl().tracer
+	}
+	return nil
+}
+
+// beginTerm は項の評価の開始を記録し、終了時に呼ぶ関数を返します。
+// 入れ子の項よりも前に並ぶように、開始時に記録の位置を確保します。
+// beginTerm records the start of a term and returns the function to call when it ends.
+// The entry is reserved at the start so that it precedes its nested terms.
+func beginTerm(resv SPFResolver, depth int, domain, term string) func(matched bool, res *Result) {
+	t := traceOf(resv)
+	if t == nil {
+		return func(bool, *Result) {}
+	}
+	idx := len(t.entries)
+	t.entries = append(t.entries, TraceEntry{Depth: depth, Domain: domain, Term: term})
+	start, queries := time.Now(), t.queries
+	return func(matched bool, res *Result) {
+		e := &t.entries[idx]
+		e.Matched = matched
+		if res != nil && (res.Status == TempError || res.Status == PermError) {
+			e.Error = res.Reason
+		}
+		e.Queries = t.queries - queries
+		e.Duration = time.Since(start)
+	}
+}
+
+// isDNSMechanism はDNSルックアップを伴うメカニズムかを返します。
+func isDNSMechanism(m Mechanism) bool {
+	switch m {
+	case MechanismA, MechanismMX, MechanismInclude, MechanismExists, MechanismPTR:
+		return true
+	}
+	return false
+}
+
+// attachTrace は記録中であれば評価の記録と統計を結果に付けます。
+func (d *dnsResolverImpl) attachTrace(res *Result) *Result {
+	if d.tracer == nil || res == nil {
+		return res
+	}
+	res.Trace = d.tracer.entries
+	res.Stats = &Stats{
+		Terms:       d.termCounter,
+		Queries:     d.tracer.queries,
+		VoidLookups: d.voidCount,
+		LookupTime:  d.tracer.lookupTime,
+		Elapsed:     time.Since(d.tracer.start),
+	}
+	return res
+}
@@ -0,0 +1,369 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// SQLStoreのテストに使うデータベースドライバー
+// SQLStoreが発行する範囲のSQL(CREATE TABLE, ALTER TABLE ADD COLUMN, INSERT, 条件とGROUP BY付きのSELECT, DELETE)だけを
+// メモリ上のテーブルで解釈する
+
+var registerFakeDriver sync.Once
+
+// openFakeDB はテストごとに独立したデータベースを開く
+func openFakeDB(t *testing.T) *sql.DB {
+	t.Helper()
+	registerFakeDriver.Do(func() {
+		sql.Register("mmauth-fake", &fakeDriver{dbs: make(map[string]*fakeDB)})
+	})
+	db, err := sql.Open("mmauth-fake", t.Name())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type fakeDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*fakeDB
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db, ok := d.dbs[name]
+	if !ok {
+		db = &fakeDB{tables: make(map[string]*fakeTable)}
+		d.dbs[name] = db
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeDB struct {
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+}
+
+type fakeTable struct {
+	columns []string
+	rows    []map[string]driver.Value
+	nextID  int64
+}
+
+func (t *fakeTable) hasColumn(name string) bool {
+	for _, c := range t.columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+var (
+	createTableRe = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+) \((.*)\)$`)
+	insertRe      = regexp.MustCompile(`^INSERT INTO (\w+) \((.*)\) VALUES \((.*)\)$`)
+	alterTableRe  = regexp.MustCompile(`^ALTER TABLE (\w+) ADD COLUMN (\w+) (.*)$`)
+	selectRe      = regexp.MustCompile(`^SELECT (DISTINCT )?(.*?) FROM (\w+)(?: WHERE (.*?))?(?: GROUP BY (.*?))?(?: LIMIT (\d+))?$`)
+	deleteRe      = regexp.MustCompile(`^DELETE FROM (\w+) WHERE (.*)$`)
+)
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE INDEX "):
+		return driver.RowsAffected(0), nil
+	case createTableRe.MatchString(s.query):
+		m := createTableRe.FindStringSubmatch(s.query)
+		if _, ok := s.db.tables[m[1]]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		t := &fakeTable{}
+		for _, def := range strings.Split(m[2], ",") {
+			t.columns = append(t.columns, strings.Fields(def)[0])
+		}
+		s.db.tables[m[1]] = t
+		return driver.RowsAffected(0), nil
+	case alterTableRe.MatchString(s.query):
+		m := alterTableRe.FindStringSubmatch(s.query)
+		t, err := s.db.table(m[1])
+		if err != nil {
+			return nil, err
+		}
+		if t.hasColumn(m[2]) {
+			return nil, fmt.Errorf("duplicate column name: %s", m[2])
+		}
+		t.columns = append(t.columns, m[2])
+		for _, row := range t.rows {
+			row[m[2]] = ""
+		}
+		return driver.RowsAffected(0), nil
+	case insertRe.MatchString(s.query):
+		m := insertRe.FindStringSubmatch(s.query)
+		t, err := s.db.table(m[1])
+		if err != nil {
+			return nil, err
+		}
+		cols := splitList(m[2])
+		if len(cols) != len(args) {
+			return nil, fmt.Errorf("%d columns but %d values", len(cols), len(args))
+		}
+		row := make(map[string]driver.Value)
+		for _, c := range t.columns {
+			row[c] = ""
+		}
+		t.nextID++
+		row["id"] = t.nextID
+		for i, c := range cols {
+			if !t.hasColumn(c) {
+				return nil, fmt.Errorf("table %s has no column named %s", m[1], c)
+			}
+			row[c] = args[i]
+		}
+		t.rows = append(t.rows, row)
+		return driver.RowsAffected(1), nil
+	case deleteRe.MatchString(s.query):
+		m := deleteRe.FindStringSubmatch(s.query)
+		t, err := s.db.table(m[1])
+		if err != nil {
+			return nil, err
+		}
+		var kept []map[string]driver.Value
+		for _, row := range t.rows {
+			ok, err := matchWhere(t, row, m[2], args)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				kept = append(kept, row)
+			}
+		}
+		n := len(t.rows) - len(kept)
+		t.rows = kept
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("unsupported statement: %s", s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	m := selectRe.FindStringSubmatch(s.query)
+	if m == nil {
+		return nil, fmt.Errorf("unsupported query: %s", s.query)
+	}
+	t, err := s.db.table(m[3])
+	if err != nil {
+		return nil, err
+	}
+	cols := splitList(m[2])
+	for _, c := range cols {
+		if c != "COUNT(*)" && !t.hasColumn(c) {
+			return nil, fmt.Errorf("no such column: %s", c)
+		}
+	}
+
+	// 条件に一致する行をGROUP BYの列ごとにまとめる
+	var groupBy []string
+	if m[5] != "" {
+		groupBy = splitList(m[5])
+	}
+	// GROUP BYのないCOUNT(*)は該当する行がなくても1行を返す
+	aggregate := groupBy == nil && containsColumn(cols, "COUNT(*)")
+	var keys []string
+	groups := make(map[string][]map[string]driver.Value)
+	if aggregate {
+		keys = append(keys, "")
+	}
+	for i, row := range t.rows {
+		if m[4] != "" {
+			ok, err := matchWhere(t, row, m[4], args)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		key := strconv.Itoa(i)
+		switch {
+		case aggregate:
+			key = ""
+		case groupBy != nil || m[1] != "":
+			var parts []string
+			for _, c := range groupBy {
+				parts = append(parts, fmt.Sprint(row[c]))
+			}
+			if m[1] != "" {
+				for _, c := range cols {
+					parts = append(parts, fmt.Sprint(row[c]))
+				}
+			}
+			key = strings.Join(parts, "\x00")
+		}
+		if _, ok := groups[key]; !ok && !aggregate {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	rows := &fakeRows{columns: cols}
+	for _, key := range keys {
+		g := groups[key]
+		var values []driver.Value
+		for _, c := range cols {
+			if c == "COUNT(*)" {
+				values = append(values, int64(len(g)))
+				continue
+			}
+			var v driver.Value
+			if len(g) > 0 {
+				v = g[0][c]
+			}
+			values = append(values, v)
+		}
+		rows.values = append(rows.values, values)
+	}
+	if m[6] != "" {
+		limit, _ := strconv.Atoi(m[6])
+		if limit < len(rows.values) {
+			rows.values = rows.values[:limit]
+		}
+	}
+	return rows, nil
+}
+
+func (db *fakeDB) table(name string) (*fakeTable, error) {
+	t, ok := db.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+	return t, nil
+}
+
+// matchWhere は "列 演算子 ?" をANDでつないだ条件にrowが一致するかを返す
+// argsはプレースホルダーの順に消費する(SQLStoreの文では条件の前に?はない)
+func matchWhere(t *fakeTable, row map[string]driver.Value, where string, args []driver.Value) (bool, error) {
+	conds := strings.Split(where, " AND ")
+	if len(conds) > len(args) {
+		return false, fmt.Errorf("not enough arguments for %s", where)
+	}
+	for i, cond := range conds {
+		f := strings.Fields(cond)
+		if len(f) != 3 || f[2] != "?" {
+			return false, fmt.Errorf("unsupported condition: %s", cond)
+		}
+		if !t.hasColumn(f[0]) {
+			return false, fmt.Errorf("no such column: %s", f[0])
+		}
+		c, err := compareValues(row[f[0]], args[len(args)-len(conds)+i])
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		switch f[1] {
+		case "=":
+			ok = c == 0
+		case "<":
+			ok = c < 0
+		case ">=":
+			ok = c >= 0
+		default:
+			return false, fmt.Errorf("unsupported operator: %s", f[1])
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compareValues(a, b driver.Value) (int, error) {
+	switch av := a.(type) {
+	case int64:
+		bv, ok := b.(int64)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+		}
+		switch {
+		case av < bv:
+			return -1, nil
+		case av > bv:
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+		}
+		return strings.Compare(av, bv), nil
+	}
+	return 0, fmt.Errorf("unsupported value %T", a)
+}
+
+func containsColumn(cols []string, name string) bool {
+	for _, c := range cols {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		ret = append(ret, strings.TrimSpace(v))
+	}
+	return ret
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore はメモリ上に結果を保持するStore
+// テストや小規模な用途向けで、プロセスを終了すると内容は失われる
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewMemoryStore はMemoryStoreを作成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Save は1通分の認証結果を保存する
+func (m *MemoryStore) Save(ctx context.Context, r *Record) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	rec := *r
+	rec.DKIM = append([]DKIMResult(nil), r.DKIM...)
+	m.mu.Lock()
+	m.records = append(m.records, rec)
+	m.mu.Unlock()
+	return nil
+}
+
+// Aggregate はpolicyDomainについて[begin, end)の期間の結果を集計する
+func (m *MemoryStore) Aggregate(ctx context.Context, policyDomain string, begin, end time.Time) ([]AggregateRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := make(map[string]int)
+	var rows []AggregateRow
+	for i := range m.records {
+		r := &m.records[i]
+		if !inWindow(r.ReceivedAt, begin, end) || r.policyDomain() != normalizeDomain(policyDomain) {
+			continue
+		}
+		key := aggregateKey(r)
+		if n, ok := index[key]; ok {
+			rows[n].Count++
+			continue
+		}
+		index[key] = len(rows)
+		rows = append(rows, AggregateRow{
+			SourceIP:        r.SourceIP,
+			Count:           1,
+			Disposition:     r.Disposition,
+			DMARCDKIM:       r.DMARCDKIM,
+			DMARCSPF:        r.DMARCSPF,
+			HeaderFrom:      normalizeDomain(r.HeaderFrom),
+			EnvelopeFrom:    normalizeDomain(r.EnvelopeFrom),
+			SPFDomain:       normalizeDomain(r.SPFDomain),
+			SPFResult:       r.SPFResult,
+			DKIM:            decodeDKIM(encodeDKIM(r.DKIM)),
+			PolicyOverride:  r.PolicyOverride,
+			OverrideComment: r.OverrideComment,
+		})
+	}
+	sortRows(rows)
+	return rows, nil
+}
+
+// PolicyDomains は[begin, end)の期間に結果が保存されているポリシードメインを返す
+func (m *MemoryStore) PolicyDomains(ctx context.Context, begin, end time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var ret []string
+	for i := range m.records {
+		r := &m.records[i]
+		if !inWindow(r.ReceivedAt, begin, end) {
+			continue
+		}
+		d := r.policyDomain()
+		if !seen[d] {
+			seen[d] = true
+			ret = append(ret, d)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Purge はbeforeより前に受信した結果を削除する
+func (m *MemoryStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.records[:0]
+	var n int64
+	for _, r := range m.records {
+		if r.ReceivedAt.Before(before) {
+			n++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	m.records = kept
+	return n, nil
+}
+
+// Close は何もしない
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func inWindow(t, begin, end time.Time) bool {
+	return !t.Before(begin) && t.Before(end)
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// schema はSQLStoreが使うテーブル定義(SQLite互換)
+// ドライバーによっては複数の文を一度に実行できないため1文ずつ実行する
+var schema = []string{`CREATE TABLE IF NOT EXISTS mmauth_results (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	received_at INTEGER NOT NULL,
+	policy_domain TEXT NOT NULL,
+	source_ip TEXT NOT NULL,
+	header_from TEXT NOT NULL,
+	envelope_from TEXT NOT NULL,
+	spf_domain TEXT NOT NULL,
+	spf_result TEXT NOT NULL,
+	dkim TEXT NOT NULL,
+	dmarc_result TEXT NOT NULL,
+	dmarc_spf TEXT NOT NULL,
+	dmarc_dkim TEXT NOT NULL,
+	policy TEXT NOT NULL,
+	disposition TEXT NOT NULL,
+	policy_override TEXT NOT NULL DEFAULT '',
+	override_comment TEXT NOT NULL DEFAULT ''
+)`,
+	`CREATE INDEX IF NOT EXISTS mmauth_results_domain_time ON mmauth_results (policy_domain, received_at)`,
+}
+
+// addedColumns は最初のスキーマより後に追加した列
+// CREATE TABLE IF NOT EXISTSでは既存のテーブルに列が増えないため、NewSQLStoreで足りない列を追加する
+var addedColumns = []struct {
+	name       string
+	definition string
+}{
+	{name: "policy_override", definition: "TEXT NOT NULL DEFAULT ''"},
+	{name: "override_comment", definition: "TEXT NOT NULL DEFAULT ''"},
+}
+
+// SQLStore はdatabase/sqlを使うStore
+// データベースドライバーは呼び出し側でインポートし、*sql.DBを渡す。
+// クエリはSQLiteを基準にしており、プレースホルダーには?を使う。
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore はdbを使うSQLStoreを作成し、必要なテーブルを作成する
+func NewSQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+	for _, q := range schema {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	for _, c := range addedColumns {
+		if hasColumn(ctx, db, c.name) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "ALTER TABLE mmauth_results ADD COLUMN "+c.name+" "+c.definition); err != nil {
+			return nil, fmt.Errorf("failed to add column %s: %w", c.name, err)
+		}
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// hasColumn はmmauth_resultsに列nameがあるかを返す
+// 列の一覧の取得方法はデータベースごとに異なるため、列を参照するクエリが成功するかで判断する
+func hasColumn(ctx context.Context, db *sql.DB, name string) bool {
+	rows, err := db.QueryContext(ctx, "SELECT "+name+" FROM mmauth_results LIMIT 1")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// Save は1通分の認証結果を保存する
+func (s *SQLStore) Save(ctx context.Context, r *Record) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO mmauth_results (
+		received_at, policy_domain, source_ip, header_from, envelope_from,
+		spf_domain, spf_result, dkim, dmarc_result, dmarc_spf, dmarc_dkim,
+		policy, disposition, policy_override, override_comment
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ReceivedAt.Unix(), r.policyDomain(), r.SourceIP,
+		normalizeDomain(r.HeaderFrom), normalizeDomain(r.EnvelopeFrom),
+		normalizeDomain(r.SPFDomain), r.SPFResult, encodeDKIM(r.DKIM),
+		r.DMARCResult, r.DMARCSPF, r.DMARCDKIM, r.Policy, r.Disposition,
+		r.PolicyOverride, r.OverrideComment,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert record: %w", err)
+	}
+	return nil
+}
+
+// Aggregate はpolicyDomainについて[begin, end)の期間の結果を集計する
+func (s *SQLStore) Aggregate(ctx context.Context, policyDomain string, begin, end time.Time) ([]AggregateRow, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT
+		source_ip, disposition, dmarc_dkim, dmarc_spf, header_from,
+		envelope_from, spf_domain, spf_result, dkim, policy_override,
+		override_comment, COUNT(*)
+	FROM mmauth_results
+	WHERE policy_domain = ? AND received_at >= ? AND received_at < ?
+	GROUP BY source_ip, disposition, dmarc_dkim, dmarc_spf, header_from,
+		envelope_from, spf_domain, spf_result, dkim, policy_override,
+		override_comment`,
+		normalizeDomain(policyDomain), begin.Unix(), end.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records: %w", err)
+	}
+	defer rows.Close()
+
+	var ret []AggregateRow
+	for rows.Next() {
+		var row AggregateRow
+		var dkim string
+		if err := rows.Scan(&row.SourceIP, &row.Disposition, &row.DMARCDKIM, &row.DMARCSPF,
+			&row.HeaderFrom, &row.EnvelopeFrom, &row.SPFDomain, &row.SPFResult, &dkim,
+			&row.PolicyOverride, &row.OverrideComment, &row.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan record: %w", err)
+		}
+		row.DKIM = decodeDKIM(dkim)
+		ret = append(ret, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+	sortRows(ret)
+	return ret, nil
+}
+
+// PolicyDomains は[begin, end)の期間に結果が保存されているポリシードメインを返す
+func (s *SQLStore) PolicyDomains(ctx context.Context, begin, end time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT policy_domain FROM mmauth_results
+	WHERE received_at >= ? AND received_at < ?`, begin.Unix(), end.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domains: %w", err)
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return nil, fmt.Errorf("failed to scan domain: %w", err)
+		}
+		ret = append(ret, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domains: %w", err)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Purge はbeforeより前に受信した結果を削除する
+func (s *SQLStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM mmauth_results WHERE received_at < ?`, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge records: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Close はデータベースを閉じる
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestSQLStore(t *testing.T) *SQLStore {
+	t.Helper()
+	s, err := NewSQLStore(context.Background(), openFakeDB(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestSQLStoreAggregate(t *testing.T) {
+	testStoreAggregate(t, newTestSQLStore(t))
+}
+
+func TestSQLStoreAggregateOverride(t *testing.T) {
+	testStoreAggregateOverride(t, newTestSQLStore(t))
+}
+
+func TestNewSQLStore(t *testing.T) {
+	if _, err := NewSQLStore(context.Background(), nil); err == nil {
+		t.Errorf("expected error for nil db")
+	}
+	db := openFakeDB(t)
+	for i := 0; i < 2; i++ {
+		// 既にテーブルがある場合もエラーにしない
+		if _, err := NewSQLStore(context.Background(), db); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM mmauth_results").Scan(&n); err != nil || n != 0 {
+		t.Errorf("expected an empty table, got %d, %v", n, err)
+	}
+}
+
+func TestNewSQLStoreUpgrade(t *testing.T) {
+	// policy_overrideとoverride_commentを追加する前のテーブル
+	db := openFakeDB(t)
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS mmauth_results (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	received_at INTEGER NOT NULL,
+	policy_domain TEXT NOT NULL,
+	source_ip TEXT NOT NULL,
+	header_from TEXT NOT NULL,
+	envelope_from TEXT NOT NULL,
+	spf_domain TEXT NOT NULL,
+	spf_result TEXT NOT NULL,
+	dkim TEXT NOT NULL,
+	dmarc_result TEXT NOT NULL,
+	dmarc_spf TEXT NOT NULL,
+	dmarc_dkim TEXT NOT NULL,
+	policy TEXT NOT NULL,
+	disposition TEXT NOT NULL
+)`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := db.Exec(`INSERT INTO mmauth_results (
+		received_at, policy_domain, source_ip, header_from, envelope_from,
+		spf_domain, spf_result, dkim, dmarc_result, dmarc_spf, dmarc_dkim,
+		policy, disposition
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		base.Add(time.Hour).Unix(), "example.jp", "192.0.2.1", "example.jp", "",
+		"example.jp", "pass", "", "pass", "pass", "fail", "reject", "none"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s, err := NewSQLStore(context.Background(), db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 2回目は列を追加しない
+	if _, err := NewSQLStore(context.Background(), db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := &Record{
+		ReceivedAt: base.Add(2 * time.Hour), SourceIP: "192.0.2.1", HeaderFrom: "example.jp",
+		SPFDomain: "example.jp", SPFResult: "pass", DMARCResult: "pass", DMARCSPF: "pass", DMARCDKIM: "fail",
+		Policy: "reject", Disposition: "none", PolicyOverride: "forwarded", OverrideComment: "arc=pass",
+	}
+	if err := s.Save(context.Background(), r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows, err := s.Aggregate(context.Background(), "example.jp", base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	var overridden int
+	for _, row := range rows {
+		if row.Count != 1 {
+			t.Errorf("expected count 1, got %d", row.Count)
+		}
+		if row.PolicyOverride == "forwarded" && row.OverrideComment == "arc=pass" {
+			overridden++
+		} else if row.PolicyOverride != "" || row.OverrideComment != "" {
+			t.Errorf("expected no override for the existing row, got %q, %q", row.PolicyOverride, row.OverrideComment)
+		}
+	}
+	if overridden != 1 {
+		t.Errorf("expected 1 overridden row, got %d", overridden)
+	}
+}
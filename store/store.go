@@ -0,0 +1,159 @@
+// Package store はメッセージごとの認証結果を保存し、
+// DMARC集約レポートに必要な単位で集計するためのストアを提供する
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRecord = errors.New("invalid record")
+)
+
+// DKIMResult は1つのDKIM署名の検証結果
+type DKIMResult struct {
+	Domain   string // d=
+	Selector string // s=
+	Result   string // pass, fail, ...
+}
+
+// Record は1通のメッセージの認証結果
+type Record struct {
+	ReceivedAt   time.Time
+	SourceIP     string
+	HeaderFrom   string       // RFC5322.Fromのドメイン
+	EnvelopeFrom string       // RFC5321.MailFromのドメイン
+	PolicyDomain string       // DMARCレコードを公開しているドメイン
+	SPFDomain    string       // SPFで評価したドメイン
+	SPFResult    string       // SPFの結果
+	DKIM         []DKIMResult // DKIMの結果
+	DMARCResult  string       // DMARCの結果
+	DMARCSPF     string       // DMARCでのSPFの評価(アライメントを含む) pass/fail
+	DMARCDKIM    string       // DMARCでのDKIMの評価(アライメントを含む) pass/fail
+	Policy       string       // 公開されていたポリシー
+	Disposition  string       // 実際に適用した扱い
+	// PolicyOverride はポリシーと異なる扱いをした理由の種類(local_policy, sampled_outなど)
+	// 集約レポートのpolicy_evaluated/reason/typeに相当する
+	PolicyOverride  string
+	OverrideComment string // 理由の補足(arc=passなど)
+}
+
+func (r *Record) validate() error {
+	if r == nil {
+		return ErrInvalidRecord
+	}
+	if r.ReceivedAt.IsZero() {
+		return errors.New("invalid record: missing received time")
+	}
+	if r.PolicyDomain == "" && r.HeaderFrom == "" {
+		return errors.New("invalid record: missing policy domain")
+	}
+	return nil
+}
+
+// policyDomain はPolicyDomainが空の場合にHeaderFromを使う
+func (r *Record) policyDomain() string {
+	if r.PolicyDomain != "" {
+		return normalizeDomain(r.PolicyDomain)
+	}
+	return normalizeDomain(r.HeaderFrom)
+}
+
+// normalizeDomain は集計キー用にドメイン名を小文字化し、末尾のドットを除去する
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// AggregateRow はDMARC集約レポートの1行(record要素)に相当する集計結果
+type AggregateRow struct {
+	SourceIP     string
+	Count        int64
+	Disposition  string
+	DMARCDKIM    string
+	DMARCSPF     string
+	HeaderFrom   string
+	EnvelopeFrom string
+	SPFDomain    string
+	SPFResult    string
+	DKIM         []DKIMResult
+	// PolicyOverride と OverrideComment はポリシーを上書きした理由
+	PolicyOverride  string
+	OverrideComment string
+}
+
+// Store は認証結果の保存先
+type Store interface {
+	// Save は1通分の認証結果を保存する
+	Save(ctx context.Context, r *Record) error
+	// Aggregate はpolicyDomainについて[begin, end)の期間の結果を集計する
+	Aggregate(ctx context.Context, policyDomain string, begin, end time.Time) ([]AggregateRow, error)
+	// PolicyDomains は[begin, end)の期間に結果が保存されているポリシードメインを返す
+	PolicyDomains(ctx context.Context, begin, end time.Time) ([]string, error)
+	// Purge はbeforeより前に受信した結果を削除し、削除した件数を返す
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	// Close はストアを閉じる
+	Close() error
+}
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SQLStore)(nil)
+)
+
+// aggregateKey は集計のキー
+// DKIMの結果は順序に依存しないよう並べ替えて連結する
+func aggregateKey(r *Record) string {
+	return strings.Join([]string{
+		r.SourceIP,
+		r.Disposition,
+		r.DMARCDKIM,
+		r.DMARCSPF,
+		normalizeDomain(r.HeaderFrom),
+		normalizeDomain(r.EnvelopeFrom),
+		normalizeDomain(r.SPFDomain),
+		r.SPFResult,
+		encodeDKIM(r.DKIM),
+		r.PolicyOverride,
+		r.OverrideComment,
+	}, "\x00")
+}
+
+// encodeDKIM はDKIMの結果を正規化した文字列にする
+func encodeDKIM(results []DKIMResult) string {
+	parts := make([]string, 0, len(results))
+	for _, d := range results {
+		parts = append(parts, strings.ToLower(d.Domain)+"/"+strings.ToLower(d.Selector)+"/"+d.Result)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// decodeDKIM はencodeDKIMの逆変換
+func decodeDKIM(s string) []DKIMResult {
+	if s == "" {
+		return nil
+	}
+	var ret []DKIMResult
+	for _, p := range strings.Split(s, ",") {
+		f := strings.SplitN(p, "/", 3)
+		if len(f) != 3 {
+			continue
+		}
+		ret = append(ret, DKIMResult{Domain: f[0], Selector: f[1], Result: f[2]})
+	}
+	return ret
+}
+
+// sortRows は集計結果を件数の多い順に並べる
+func sortRows(rows []AggregateRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].SourceIP < rows[j].SourceIP
+	})
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreAggregate(t *testing.T) {
+	testStoreAggregate(t, NewMemoryStore())
+}
+
+// testStoreAggregate はStoreの実装に共通する保存・集計・削除の動作を確認する
+func testStoreAggregate(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []Record{
+		{
+			ReceivedAt: base.Add(time.Hour), SourceIP: "192.0.2.1", HeaderFrom: "example.jp",
+			SPFDomain: "example.jp", SPFResult: "pass", DMARCResult: "pass", DMARCSPF: "pass", DMARCDKIM: "pass",
+			DKIM:        []DKIMResult{{Domain: "example.jp", Selector: "s1", Result: "pass"}, {Domain: "esp.example", Selector: "a", Result: "pass"}},
+			Policy:      "reject",
+			Disposition: "none",
+		},
+		{
+			// DKIMの順序が異なっても同じ行に集計される
+			ReceivedAt: base.Add(2 * time.Hour), SourceIP: "192.0.2.1", HeaderFrom: "Example.JP.",
+			SPFDomain: "example.jp", SPFResult: "pass", DMARCResult: "pass", DMARCSPF: "pass", DMARCDKIM: "pass",
+			DKIM:        []DKIMResult{{Domain: "esp.example", Selector: "a", Result: "pass"}, {Domain: "example.jp", Selector: "s1", Result: "pass"}},
+			Policy:      "reject",
+			Disposition: "none",
+		},
+		{
+			ReceivedAt: base.Add(3 * time.Hour), SourceIP: "198.51.100.1", HeaderFrom: "example.jp",
+			SPFDomain: "bad.example", SPFResult: "fail", DMARCResult: "fail", DMARCSPF: "fail", DMARCDKIM: "fail",
+			Policy:      "reject",
+			Disposition: "reject",
+		},
+		{
+			// 期間外
+			ReceivedAt: base.Add(48 * time.Hour), SourceIP: "192.0.2.1", HeaderFrom: "example.jp",
+			Disposition: "none",
+		},
+		{
+			// 別のポリシードメイン
+			ReceivedAt: base.Add(time.Hour), SourceIP: "192.0.2.1", HeaderFrom: "sub.example.com", PolicyDomain: "example.com",
+			Disposition: "none",
+		},
+	}
+	for i := range records {
+		if err := s.Save(ctx, &records[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	rows, err := s.Aggregate(ctx, "example.jp", base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d: %+v", len(rows), rows)
+	}
+	if rows[0].Count != 2 || rows[0].SourceIP != "192.0.2.1" || rows[0].HeaderFrom != "example.jp" {
+		t.Errorf("unexpected first row: %+v", rows[0])
+	}
+	wantDKIM := []DKIMResult{{Domain: "esp.example", Selector: "a", Result: "pass"}, {Domain: "example.jp", Selector: "s1", Result: "pass"}}
+	if !reflect.DeepEqual(rows[0].DKIM, wantDKIM) {
+		t.Errorf("expected dkim %+v, got %+v", wantDKIM, rows[0].DKIM)
+	}
+	if rows[1].Count != 1 || rows[1].Disposition != "reject" || rows[1].DKIM != nil {
+		t.Errorf("unexpected second row: %+v", rows[1])
+	}
+
+	domains, err := s.PolicyDomains(ctx, base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"example.com", "example.jp"}; !reflect.DeepEqual(domains, want) {
+		t.Errorf("expected domains %v, got %v", want, domains)
+	}
+
+	n, err := s.Purge(ctx, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 purged records, got %d", n)
+	}
+	rows, _ = s.Aggregate(ctx, "example.jp", base, base.Add(72*time.Hour))
+	if len(rows) != 1 || rows[0].Count != 1 {
+		t.Errorf("unexpected rows after purge: %+v", rows)
+	}
+}
+
+func TestRecordValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		record  *Record
+		wantErr bool
+	}{
+		{name: "nil", record: nil, wantErr: true},
+		{name: "missing time", record: &Record{HeaderFrom: "example.jp"}, wantErr: true},
+		{name: "missing domain", record: &Record{ReceivedAt: time.Now()}, wantErr: true},
+		{name: "valid", record: &Record{ReceivedAt: time.Now(), HeaderFrom: "example.jp"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewMemoryStore().Save(context.Background(), tc.record)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreAggregateOverride(t *testing.T) {
+	testStoreAggregateOverride(t, NewMemoryStore())
+}
+
+func testStoreAggregateOverride(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fail := Record{
+		ReceivedAt: base, SourceIP: "192.0.2.1", HeaderFrom: "example.jp",
+		DMARCResult: "fail", DMARCSPF: "fail", DMARCDKIM: "fail", Policy: "reject",
+	}
+	overridden := fail
+	overridden.Disposition = "none"
+	overridden.PolicyOverride = "local_policy"
+	overridden.OverrideComment = "arc=pass"
+	rejected := fail
+	rejected.Disposition = "reject"
+	for _, r := range []Record{overridden, overridden, rejected} {
+		r := r
+		if err := s.Save(ctx, &r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	rows, err := s.Aggregate(ctx, "example.jp", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d: %+v", len(rows), rows)
+	}
+	for _, row := range rows {
+		switch row.Disposition {
+		case "none":
+			if row.Count != 2 || row.PolicyOverride != "local_policy" || row.OverrideComment != "arc=pass" {
+				t.Errorf("unexpected overridden row: %+v", row)
+			}
+		case "reject":
+			if row.Count != 1 || row.PolicyOverride != "" {
+				t.Errorf("unexpected rejected row: %+v", row)
+			}
+		default:
+			t.Errorf("unexpected row: %+v", row)
+		}
+	}
+}